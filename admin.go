@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// defaultHotPrefixLimit is how many prefixes the hot-prefix report returns
+// when no limit is given.
+const defaultHotPrefixLimit = 20
+
+// defaultAdminEndpoint is where the subcommands find a running instance's
+// admin API.
+const defaultAdminEndpoint = "http://localhost:9001"
+
+// newAdminHandler returns the handler for the /admin/ endpoints, which are
+// served on their own listener. Requests need cfg.AdminToken when one is
+// set, and cross-site requests from browsers are refused.
+func newAdminHandler(lazy *LazyBackend, cfg *Config) http.Handler {
+	mux := http.NewServeMux()
+	handler := adminGuard(cfg.AdminToken, mux)
+	mux.HandleFunc("GET /admin/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cfg.Effective())
+	})
+	mux.HandleFunc("GET /admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.Stats())
+	})
+	mux.HandleFunc("GET /admin/usage", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.DataUsage())
+	})
+	mux.HandleFunc("GET /admin/residency", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"cache_allow_buckets": cfg.CacheAllowBuckets,
+			"cache_deny_buckets":  cfg.CacheDenyBuckets,
+			"persisted":           lazy.ledger.report(),
+		})
+	})
+	mux.HandleFunc("GET /admin/stats/identities", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.identities.report())
+	})
+	mux.HandleFunc("GET /admin/slos", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.slos.report())
+	})
+	mux.HandleFunc("GET /admin/stats/prefixes", func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultHotPrefixLimit
+		if v := r.URL.Query().Get("top"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "top must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		writeJSON(w, http.StatusOK, lazy.prefixStats.report(limit))
+	})
+	mux.HandleFunc("DELETE /admin/cache/{bucket}/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		bucket, key := r.PathValue("bucket"), r.PathValue("key")
+		purged, err := lazy.Purge(bucket, key)
+		if err != nil {
+			writeJSON(w, purgeStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		if !purged {
+			writeJSON(w, http.StatusNotFound, map[string]int{"purged": 0})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"purged": 1})
+	})
+	mux.HandleFunc("GET /admin/cache/index.sqlite", func(w http.ResponseWriter, r *http.Request) {
+		// The database is laid out in a temp file, as its first page is
+		// written last
+		f, err := os.CreateTemp("", "s3lazy-index-*.sqlite")
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if err := lazy.ExportIndex(f); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+		w.Header().Set("Content-Disposition", `attachment; filename="s3lazy-index.sqlite"`)
+		http.ServeContent(w, r, "", time.Time{}, f)
+	})
+	mux.HandleFunc("POST /admin/cache/purge", func(w http.ResponseWriter, r *http.Request) {
+		// prefix is "bucket/key-prefix"; a bare bucket name purges the bucket
+		bucket, prefix, _ := strings.Cut(r.URL.Query().Get("prefix"), "/")
+		if bucket == "" {
+			http.Error(w, "prefix must start with a bucket name", http.StatusBadRequest)
+			return
+		}
+		purged, kept, err := lazy.PurgePrefix(bucket, prefix)
+		if err != nil {
+			writeJSON(w, purgeStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged, "kept": kept})
+	})
+	mux.HandleFunc("POST /admin/buckets/{bucket}/clone", func(w http.ResponseWriter, r *http.Request) {
+		dst := r.URL.Query().Get("to")
+		if dst == "" {
+			http.Error(w, "to must name the new bucket", http.StatusBadRequest)
+			return
+		}
+		result, err := lazy.CloneBucket(r.PathValue("bucket"), dst)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var s3Err gofakes3.Error
+			switch {
+			case errors.Is(err, ErrQuotaExceeded):
+				status = http.StatusForbidden
+			case errors.As(err, &s3Err):
+				status = s3Err.ErrorCode().Status()
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		// upstream=true also fetches what the source hadn't cached
+		if r.URL.Query().Get("upstream") == "true" {
+			result.PrefetchID = lazy.Prefetch(context.Background(), dst, "", cfg.PrefetchConcurrency)
+		}
+		writeJSON(w, http.StatusCreated, result)
+	})
+	mux.HandleFunc("POST /admin/buckets/{bucket}/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		result, err := lazy.Snapshot(r.PathValue("bucket"), r.URL.Query().Get("label"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			var s3Err gofakes3.Error
+			if errors.As(err, &s3Err) {
+				status = s3Err.ErrorCode().Status()
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, result)
+	})
+	mux.HandleFunc("GET /admin/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		snapshots, err := lazy.Snapshots()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshots)
+	})
+	mux.HandleFunc("DELETE /admin/snapshots/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if err := lazy.DeleteSnapshot(r.PathValue("name")); err != nil {
+			status := http.StatusInternalServerError
+			var s3Err gofakes3.Error
+			if errors.As(err, &s3Err) {
+				status = s3Err.ErrorCode().Status()
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /admin/events/{bucket}/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			since = t
+		}
+		bucket, key := lazy.canonicalBucket(r.PathValue("bucket")), r.PathValue("key")
+		events, ok := lazy.events.history(bucket, key, since)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no events recorded for " + bucket + "/" + key})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"bucket": bucket, "key": key, "events": events})
+	})
+	mux.HandleFunc("GET /admin/pins", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.pins.list())
+	})
+	mux.HandleFunc("POST /admin/pins", func(w http.ResponseWriter, r *http.Request) {
+		pattern, err := lazy.pins.add(r.URL.Query().Get("pin"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[PIN] %s", pattern)
+		writeJSON(w, http.StatusOK, lazy.pins.list())
+	})
+	mux.HandleFunc("DELETE /admin/pins", func(w http.ResponseWriter, r *http.Request) {
+		pattern := r.URL.Query().Get("pin")
+		if !lazy.pins.remove(pattern) {
+			http.Error(w, "not pinned: "+pattern, http.StatusNotFound)
+			return
+		}
+		log.Printf("[UNPIN] %s", pattern)
+		writeJSON(w, http.StatusOK, lazy.pins.list())
+	})
+	mux.HandleFunc("GET /admin/toggles", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.toggles.state())
+	})
+	mux.HandleFunc("PATCH /admin/toggles", func(w http.ResponseWriter, r *http.Request) {
+		var change toggleState
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&change); err != nil {
+			http.Error(w, "invalid toggles: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := lazy.toggles.apply(change, lazy.identify(r), r.RemoteAddr); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, lazy.toggles.state())
+	})
+	mux.HandleFunc("GET /admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.toggles.auditLog())
+	})
+	mux.HandleFunc("POST /admin/prefetch", func(w http.ResponseWriter, r *http.Request) {
+		// prefix is "bucket/key-prefix"; a bare bucket name prefetches the bucket
+		bucket, prefix, _ := strings.Cut(r.URL.Query().Get("prefix"), "/")
+		if bucket == "" {
+			http.Error(w, "prefix must start with a bucket name", http.StatusBadRequest)
+			return
+		}
+		id := lazy.Prefetch(context.Background(), bucket, prefix, cfg.PrefetchConcurrency)
+		job, _ := lazy.prefetches.get(id)
+		writeJSON(w, http.StatusAccepted, job)
+	})
+	mux.HandleFunc("GET /admin/prefetch", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.prefetches.list())
+	})
+	mux.HandleFunc("GET /admin/prefetch/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := lazy.prefetches.get(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "no such prefetch job", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+	mux.HandleFunc("GET /admin/packs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.packs.usage())
+	})
+	mux.HandleFunc("POST /admin/packs/compact", func(w http.ResponseWriter, r *http.Request) {
+		// Compacts every bucket with any dead bytes, unlike the schedule
+		report, err := lazy.packs.compact(0)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+	mux.HandleFunc("GET /admin/key-filters", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, lazy.KeyFilters())
+	})
+	registerBrowser(mux, lazy)
+	registerUploads(mux, lazy)
+	return handler
+}
+
+// adminGuard refuses admin requests that a web page the operator visits
+// could have sent, going by the Origin and Sec-Fetch-Site headers browsers
+// add, and, when token is set, requests that don't carry it as a bearer
+// token or the password of basic auth, which browsers prompt for.
+func adminGuard(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if crossSite(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "cross-site admin requests are refused"})
+			return
+		}
+		if token != "" && !hasAdminToken(r, token) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="s3lazy admin"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid admin token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// crossSite reports whether a browser sent a request from another site.
+// Clients other than browsers send neither header.
+func crossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return true
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	}
+	return false
+}
+
+// hasAdminToken reports whether a request carries the admin token.
+func hasAdminToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, got, ok = r.BasicAuth()
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// adminRequest sends a request to a running instance's admin API, with the
+// token from S3LAZY_ADMIN_TOKEN if set.
+func adminRequest(ctx context.Context, method, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("S3LAZY_ADMIN_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// purgeStatus is the status of a failed purge.
+func purgeStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusForbidden
+	case isNotFound(err):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// writeJSON writes v as an indented JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("[ADMIN] failed to write response: %v", err)
+	}
+}
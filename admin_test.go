@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminGuard(t *testing.T) {
+	lazyBackend, _, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	cfg := DefaultConfig()
+	cfg.AdminToken = "s3cret"
+	admin := newAdminHandler(lazyBackend, cfg)
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		basic  string
+		want   int
+	}{
+		{"no token", nil, "", http.StatusUnauthorized},
+		{"wrong token", map[string]string{"Authorization": "Bearer nope"}, "", http.StatusUnauthorized},
+		{"bearer token", map[string]string{"Authorization": "Bearer s3cret"}, "", http.StatusOK},
+		{"basic auth", nil, "s3cret", http.StatusOK},
+		{"same origin", map[string]string{"Authorization": "Bearer s3cret", "Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"}, "", http.StatusOK},
+		{"cross-site fetch", map[string]string{"Authorization": "Bearer s3cret", "Sec-Fetch-Site": "cross-site"}, "", http.StatusForbidden},
+		{"other origin", map[string]string{"Authorization": "Bearer s3cret", "Origin": "http://evil.example"}, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/admin/stats", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if tt.basic != "" {
+				req.SetBasicAuth("admin", tt.basic)
+			}
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// Without a token only cross-site requests are refused
+	admin = newAdminHandler(lazyBackend, DefaultConfig())
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status without a token = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// SetBucketAliases gives local buckets extra names. Requests to an alias are
+// served from the bucket it names, sharing its cache, mappings and settings,
+// so an upstream bucket known by several names is only downloaded once.
+func (b *LazyBackend) SetBucketAliases(aliases map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucketAliases = maps.Clone(aliases)
+}
+
+// canonicalBucket returns the bucket an alias names, or the name itself.
+func (b *LazyBackend) canonicalBucket(name string) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if bucket, ok := b.bucketAliases[name]; ok {
+		return bucket
+	}
+	return name
+}
+
+// errAliasDelete refuses to delete a bucket through one of its aliases, which
+// would take the bucket away from every other name too.
+func (b *LazyBackend) errAliasDelete(name string) error {
+	return gofakes3.ErrorMessagef(gofakes3.ErrMethodNotAllowed, "%s is an alias of %s; delete %s itself", name, b.canonicalBucket(name), b.canonicalBucket(name))
+}
+
+// withAliases adds the aliases of the buckets listed to a bucket listing,
+// sorted by name.
+func (b *LazyBackend) withAliases(buckets []gofakes3.BucketInfo) []gofakes3.BucketInfo {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.bucketAliases) == 0 {
+		return buckets
+	}
+	byName := make(map[string]gofakes3.BucketInfo, len(buckets))
+	for _, info := range buckets {
+		byName[info.Name] = info
+	}
+	for _, alias := range slices.Sorted(maps.Keys(b.bucketAliases)) {
+		if info, ok := byName[b.bucketAliases[alias]]; ok {
+			info.Name = alias
+			buckets = append(buckets, info)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return buckets
+}
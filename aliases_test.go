@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_BucketAliases(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	lazyBackend.SetBucketAliases(map[string]string{"prod-data": "test-bucket", "production": "test-bucket"})
+	putString(t, awsBackend, "report.csv", "a,b,c")
+
+	// Both aliases share one cached copy
+	for _, bucket := range []string{"prod-data", "production", "test-bucket"} {
+		obj, err := lazyBackend.GetObject(bucket, "report.csv", nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s): %v", bucket, err)
+		}
+		obj.Contents.Close()
+	}
+	if stats := lazyBackend.Stats(); stats.Misses != 1 || stats.Hits != 2 {
+		t.Errorf("misses = %d, hits = %d; want the object fetched once", stats.Misses, stats.Hits)
+	}
+
+	// Writes through an alias land in the bucket
+	if _, err := lazyBackend.PutObject("production", "alias-note.txt", nil, strings.NewReader("hi"), 2, nil); err != nil {
+		t.Fatalf("PutObject via alias: %v", err)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "alias-note.txt"); err != nil {
+		t.Errorf("write via alias should land in test-bucket: %v", err)
+	}
+
+	buckets, err := lazyBackend.ListBuckets()
+	if err != nil {
+		t.Fatalf("ListBuckets: %v", err)
+	}
+	var names []string
+	for _, info := range buckets {
+		names = append(names, info.Name)
+	}
+	if got := strings.Join(names, ","); got != "prod-data,production,test-bucket" {
+		t.Errorf("buckets = %s, want the bucket and its aliases", got)
+	}
+	if exists, _ := lazyBackend.BucketExists("prod-data"); !exists {
+		t.Error("alias should exist")
+	}
+
+	if err := lazyBackend.DeleteBucket("prod-data"); !gofakes3.HasErrorCode(err, gofakes3.ErrMethodNotAllowed) {
+		t.Errorf("DeleteBucket via alias = %v, want it refused", err)
+	}
+	if exists, _ := localBackend.BucketExists("test-bucket"); !exists {
+		t.Error("bucket should survive a delete through its alias")
+	}
+}
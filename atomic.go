@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3afero"
+	"github.com/spf13/afero"
+)
+
+// openDiskFiles opens the s3afero backend storing objects as files under
+// dataDir, with writes made atomic, and drops objects a crash left
+// half-written.
+func openDiskFiles(dataDir string) (*atomicDisk, error) {
+	fs, err := newAtomicFs(afero.NewBasePathFs(afero.NewOsFs(), dataDir), filepath.Join("s3lazy", "tmp"))
+	if err != nil {
+		return nil, err
+	}
+	files, err := s3afero.MultiBucket(fs)
+	if err != nil {
+		return nil, err
+	}
+	return newAtomicDisk(files, filepath.Join(dataDir, "s3lazy", "writes"))
+}
+
+// atomicFs writes files to a temp file and renames it into place once it is
+// closed, so a file being written is never seen, or left behind by a crash,
+// half-written.
+type atomicFs struct {
+	afero.Fs
+	tmpDir string
+}
+
+// newAtomicFs wraps fs, keeping files being written in tmpDir, which is
+// emptied of any a crash left behind.
+func newAtomicFs(fs afero.Fs, tmpDir string) (*atomicFs, error) {
+	if err := fs.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := fs.MkdirAll(tmpDir, 0700); err != nil {
+		return nil, err
+	}
+	return &atomicFs{Fs: fs, tmpDir: tmpDir}, nil
+}
+
+func (fs *atomicFs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (fs *atomicFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	// Only files being replaced are written aside
+	if flag&os.O_TRUNC == 0 || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return fs.Fs.OpenFile(name, flag, perm)
+	}
+	f, err := afero.TempFile(fs.Fs, fs.tmpDir, "write-*")
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, fs: fs, name: name}, nil
+}
+
+// atomicFile is a file written aside by atomicFs.
+type atomicFile struct {
+	afero.File
+	fs     *atomicFs
+	name   string
+	failed bool // a write failed, so the file is discarded
+	closed bool
+}
+
+func (f *atomicFile) Name() string {
+	return f.name
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+// Close syncs the file and renames it into place, or discards it if a write
+// failed.
+func (f *atomicFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	tmp := f.File.Name()
+	err := f.File.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && f.failed {
+		err = fmt.Errorf("writing %s failed", f.name)
+	}
+	if err != nil {
+		f.fs.Fs.Remove(tmp)
+		return err
+	}
+	return f.fs.Fs.Rename(tmp, f.name)
+}
+
+// atomicDisk keeps the disk backend from serving objects half written. The
+// backend stores an object as a data file and a metadata file, each replaced
+// atomically by atomicFs, but not both at once: a crash in between would
+// leave new data with the old metadata. So every write is recorded in a
+// journal until it completes, and objects whose write failed or was cut
+// short by a crash are dropped, to be fetched again if they were cached.
+type atomicDisk struct {
+	gofakes3.Backend
+	journal string
+}
+
+// journalEntry records an object being written.
+type journalEntry struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// newAtomicDisk wraps a disk backend, journalling writes in journal and
+// dropping the objects of any writes left in it by a crash.
+func newAtomicDisk(backend gofakes3.Backend, journal string) (*atomicDisk, error) {
+	if err := os.MkdirAll(journal, 0755); err != nil {
+		return nil, err
+	}
+	d := &atomicDisk{Backend: backend, journal: journal}
+	if err := d.recover(); err != nil {
+		return nil, fmt.Errorf("recovering interrupted writes: %w", err)
+	}
+	return d, nil
+}
+
+// recover drops the objects of writes left in the journal.
+func (d *atomicDisk) recover() error {
+	names, err := filepath.Glob(filepath.Join(d.journal, "put-*"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var entry journalEntry
+		// A torn entry was written before its object was touched
+		if json.Unmarshal(data, &entry) == nil {
+			if err := d.drop(entry.Bucket, entry.Key); err != nil {
+				return err
+			}
+			log.Printf("[DISK] dropped %s/%s: a crash interrupted writing it", entry.Bucket, entry.Key)
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// begin records that an object is about to be written, returning the
+// journal entry to remove once it is.
+func (d *atomicDisk) begin(bucketName, objectName string) (string, error) {
+	data, err := json.Marshal(journalEntry{Bucket: bucketName, Key: objectName})
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(d.journal, "put-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// drop deletes whatever was written of an object.
+func (d *atomicDisk) drop(bucketName, objectName string) error {
+	_, err := d.Backend.DeleteObject(bucketName, objectName)
+	if gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		return nil
+	}
+	return err
+}
+
+func (d *atomicDisk) PutObject(bucketName, objectName string, meta map[string]string, input io.Reader, size int64, conditions *gofakes3.PutConditions) (gofakes3.PutObjectResult, error) {
+	// Rejected writes leave the object alone, so they are checked before
+	// anything is written
+	if err := checkPutConditions(d, bucketName, objectName, conditions); err != nil {
+		return gofakes3.PutObjectResult{}, err
+	}
+	if exists, err := d.Backend.BucketExists(bucketName); err != nil {
+		return gofakes3.PutObjectResult{}, err
+	} else if !exists {
+		return gofakes3.PutObjectResult{}, gofakes3.BucketNotFound(bucketName)
+	}
+
+	entry, err := d.begin(bucketName, objectName)
+	if err != nil {
+		return gofakes3.PutObjectResult{}, fmt.Errorf("journalling write of %s/%s: %w", bucketName, objectName, err)
+	}
+	result, err := d.Backend.PutObject(bucketName, objectName, meta, input, size, nil)
+	if err != nil {
+		// The data may have been replaced without the metadata
+		if derr := d.drop(bucketName, objectName); derr != nil {
+			// Left in the journal to be dropped on restart
+			log.Printf("[DISK] couldn't drop %s/%s after a failed write: %v", bucketName, objectName, derr)
+			return result, err
+		}
+	}
+	if rerr := os.Remove(entry); rerr != nil {
+		log.Printf("[DISK] couldn't clear the journal entry of %s/%s: %v", bucketName, objectName, rerr)
+	}
+	return result, err
+}
+
+func (d *atomicDisk) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, meta map[string]string) (gofakes3.CopyObjectResult, error) {
+	// Copy through this layer so the write is journalled
+	return gofakes3.CopyObject(d, srcBucket, srcKey, dstBucket, dstKey, meta)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/spf13/afero"
+)
+
+func newTestAtomicDisk(t *testing.T, dataDir string) *atomicDisk {
+	t.Helper()
+	disk, err := openDiskFiles(dataDir)
+	if err != nil {
+		t.Fatalf("openDiskFiles: %v", err)
+	}
+	if ok, _ := disk.BucketExists("test-bucket"); !ok {
+		if err := disk.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	return disk
+}
+
+// failingReader returns its data, then an error rather than EOF, like a
+// client hanging up mid-upload.
+type failingReader struct{ data io.Reader }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestAtomicFs(t *testing.T) {
+	dir := t.TempDir()
+	base := afero.NewBasePathFs(afero.NewOsFs(), dir)
+	if err := base.MkdirAll("tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(base, "tmp/write-left-behind", []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(base, "file", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := newAtomicFs(base, "tmp")
+	if err != nil {
+		t.Fatalf("newAtomicFs: %v", err)
+	}
+	if names, _ := afero.ReadDir(base, "tmp"); len(names) != 0 {
+		t.Errorf("temp dir holds %d files after opening, want it emptied", len(names))
+	}
+
+	f, err := fs.Create("file")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := f.Write([]byte("new contents")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "file")); string(data) != "old" {
+		t.Errorf("file = %q while being written, want the old contents", data)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "file")); string(data) != "new contents" {
+		t.Errorf("file = %q after closing, want the new contents", data)
+	}
+	if names, _ := afero.ReadDir(base, "tmp"); len(names) != 0 {
+		t.Errorf("temp dir holds %d files after closing, want none", len(names))
+	}
+}
+
+func TestAtomicDisk_FailedWrite(t *testing.T) {
+	dataDir := t.TempDir()
+	disk := newTestAtomicDisk(t, dataDir)
+	putString(t, disk, "key", "old")
+
+	content := "new contents the client never finished sending"
+	_, err := disk.PutObject("test-bucket", "key", map[string]string{}, &failingReader{strings.NewReader(content[:10])}, int64(len(content)), nil)
+	if err == nil {
+		t.Fatal("PutObject succeeded, want the read error")
+	}
+	if _, err := disk.HeadObject("test-bucket", "key"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("HeadObject after a failed write: err = %v, want NoSuchKey rather than a truncated object", err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dataDir, "s3lazy", "writes", "*")); len(names) != 0 {
+		t.Errorf("journal = %v, want it cleared", names)
+	}
+
+	// Rejected writes leave the object alone
+	putString(t, disk, "key", "kept")
+	star := "*"
+	_, err = disk.PutObject("test-bucket", "key", map[string]string{}, strings.NewReader("x"), 1, &gofakes3.PutConditions{IfNoneMatch: &star})
+	if !gofakes3.HasErrorCode(err, gofakes3.ErrPreconditionFailed) {
+		t.Errorf("conditional PutObject: err = %v, want PreconditionFailed", err)
+	}
+	if _, data := readObject(t, disk, "key", nil); data != "kept" {
+		t.Errorf("key = %q after a rejected write, want %q", data, "kept")
+	}
+}
+
+func TestAtomicDisk_Recover(t *testing.T) {
+	dataDir := t.TempDir()
+	disk := newTestAtomicDisk(t, dataDir)
+	putString(t, disk, "interrupted", "new data, old metadata")
+	putString(t, disk, "intact", "fine")
+
+	// A crash between replacing an object's data and its metadata leaves
+	// its write in the journal
+	if _, err := disk.begin("test-bucket", "interrupted"); err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+
+	disk = newTestAtomicDisk(t, dataDir)
+	if _, err := disk.HeadObject("test-bucket", "interrupted"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("interrupted write after restart: err = %v, want NoSuchKey", err)
+	}
+	if _, data := readObject(t, disk, "intact", nil); data != "fine" {
+		t.Errorf("intact = %q, want %q", data, "fine")
+	}
+	if names, _ := filepath.Glob(filepath.Join(dataDir, "s3lazy", "writes", "*")); len(names) != 0 {
+		t.Errorf("journal = %v after recovering, want it cleared", names)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// TestAWSChunkedDecoder_SDK uploads through the AWS SDK over TLS, where it
+// sends aws-chunked bodies with a CRC32 trailer by default.
+func TestAWSChunkedDecoder_SDK(t *testing.T) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("test-bucket"); err != nil {
+		t.Fatal(err)
+	}
+	var contentSHA string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			contentSHA = r.Header.Get("X-Amz-Content-Sha256")
+		}
+		awsChunkedDecoder(gofakes3.New(backend).Server()).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	awsCfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion("us-east-1"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(server.URL)
+		o.UsePathStyle = true
+		o.HTTPClient = server.Client()
+	})
+
+	content := strings.Repeat("streamed with a trailing checksum\n", 1000)
+	if _, err := client.PutObject(t.Context(), &s3.PutObjectInput{
+		Bucket: aws.String("test-bucket"),
+		Key:    aws.String("chunked.txt"),
+		Body:   strings.NewReader(content),
+	}); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	if !strings.HasPrefix(contentSHA, "STREAMING-") {
+		t.Skipf("the SDK sent %q rather than an aws-chunked body", contentSHA)
+	}
+	obj, err := backend.GetObject("test-bucket", "chunked.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+	if got, _ := io.ReadAll(obj.Contents); string(got) != content {
+		t.Errorf("stored %d bytes, want the %d decoded bytes", len(got), len(content))
+	}
+	if enc := obj.Metadata["Content-Encoding"]; enc != "" {
+		t.Errorf("Content-Encoding = %q, want aws-chunked stripped", enc)
+	}
+}
+
+// chunkedBody encodes chunks as an aws-chunked body followed by trailers.
+func chunkedBody(chunks []string, trailers string) string {
+	var b strings.Builder
+	for _, c := range chunks {
+		fmt.Fprintf(&b, "%x;chunk-signature=%s\r\n%s\r\n", len(c), strings.Repeat("0", 64), c)
+	}
+	fmt.Fprintf(&b, "0;chunk-signature=%s\r\n%s\r\n", strings.Repeat("0", 64), trailers)
+	return b.String()
+}
+
+func crc32Trailer(s string) string {
+	sum := crc32.ChecksumIEEE([]byte(s))
+	return "x-amz-checksum-crc32:" + base64.StdEncoding.EncodeToString([]byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}) + "\r\n"
+}
+
+func TestAWSChunkedDecoder(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		body     string
+		trailer  string
+		decoded  int
+		wantCode string
+	}{
+		{"trailing checksum", chunkedBody([]string{"hello, ", "world"}, crc32Trailer("hello, world")), "x-amz-checksum-crc32", 12, ""},
+		{"no trailers", chunkedBody([]string{"hello, ", "world"}, ""), "", 12, ""},
+		{"checksum mismatch", chunkedBody([]string{"hello, ", "world"}, crc32Trailer("hello, there")), "x-amz-checksum-crc32", 12, "BadDigest"},
+		{"missing trailer", chunkedBody([]string{"hello, world"}, ""), "x-amz-checksum-crc32", 12, "IncompleteBody"},
+		{"truncated", "c;chunk-signature=0\r\nhello", "", 12, "IncompleteBody"},
+		{"wrong decoded length", chunkedBody([]string{"hello, world"}, ""), "", 20, "IncompleteBody"},
+		{"unknown algorithm", chunkedBody([]string{"hello, world"}, "x-amz-checksum-md4:AAAA\r\n"), "x-amz-checksum-md4", 12, "InvalidRequest"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			var gotHeader http.Header
+			handler := awsChunkedDecoder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = io.ReadAll(r.Body)
+				gotHeader = r.Header
+			}))
+			req := httptest.NewRequest(http.MethodPut, "/test-bucket/key", strings.NewReader(tt.body))
+			req.Header.Set("X-Amz-Content-Sha256", "STREAMING-UNSIGNED-PAYLOAD-TRAILER")
+			req.Header.Set("Content-Encoding", "aws-chunked,gzip")
+			req.Header.Set("X-Amz-Decoded-Content-Length", strconv.Itoa(tt.decoded))
+			req.Header.Set("X-Amz-Trailer", tt.trailer)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.wantCode != "" {
+				if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "<Code>"+tt.wantCode+"</Code>") {
+					t.Errorf("response = %d %s, want %s", rec.Code, rec.Body, tt.wantCode)
+				}
+				return
+			}
+			if !bytes.Equal(got, []byte("hello, world")) {
+				t.Errorf("decoded body = %q", got)
+			}
+			if gotHeader.Get("Content-Length") != "12" || gotHeader.Get("Content-Encoding") != "gzip" || gotHeader.Get("X-Amz-Content-Sha256") != "UNSIGNED-PAYLOAD" {
+				t.Errorf("headers = %v", gotHeader)
+			}
+			if tt.trailer != "" && rec.Header().Get(tt.trailer) == "" {
+				t.Errorf("response has no %s header", tt.trailer)
+			}
+		})
+	}
+}
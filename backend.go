@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -18,19 +23,349 @@ import (
 // When an object is not found locally, it fetches from AWS and caches it.
 type LazyBackend struct {
 	local     gofakes3.Backend
-	awsClient *s3.Client
+	awsClient upstreamClient
 
 	mu            sync.RWMutex
 	bucketMapping map[string]string
+	bucketAliases map[string]string
+	urlSources    map[string]*httpSource
+	ttl           time.Duration
+	bucketTTLs    map[string]time.Duration
+	revalidate    bool
+	ttlJitter     int // percent
+	headerTTL     headerTTLPolicy
+	timeouts      opTimeouts
+	upstreamID    string
+
+	// locks serializes fills, writes and deletes of the same bucket/key
+	locks *keyLocks
+
+	// fills shares one upstream fetch among concurrent misses of a key
+	fills *fillGroup
+
+	// versions counts the readers of each cached object version
+	versions *openVersions
+
+	// quirks describes how the upstream deviates from AWS behavior
+	quirks *upstreamQuirks
+
+	// stats counts hits, misses and bytes served for /admin/stats
+	stats *cacheStats
+
+	// events keeps a short history of each key for /admin/events (nil
+	// disables)
+	events *eventLog
+
+	// hooks are called when objects are fetched, evicted or purged
+	hooks cacheHooks
+
+	// prefixStats aggregates hits, misses and egress by key prefix (nil disables)
+	prefixStats *prefixStats
+
+	// uploads hands out pre-signed upstream multipart uploads (nil disables)
+	uploads *presignedUploads
+
+	// index tracks objects fetched from upstream for eviction
+	index *cacheIndex
+
+	// pins are keys and prefixes exempt from TTL expiry and eviction
+	pins *pinSet
+
+	// toggles are operational switches flipped at runtime via the admin API
+	toggles *runtimeToggles
+
+	// listLag hides newly written keys from listings (nil disables)
+	listLag *listLag
+
+	// shaper limits upstream bandwidth and concurrency by time of day (nil disables)
+	shaper *trafficShaper
+
+	// prefetches tracks background prefix prefetch jobs
+	prefetches *prefetchJobs
+
+	// redactor scrubs or rejects objects before they are cached (nil disables)
+	redactor  Redactor
+	redaction redactionCounters
+
+	// cachePolicy makes buckets proxy-only (nil caches every bucket)
+	cachePolicy *cachePolicy
+
+	// noCacheKeys makes keys proxy-only by glob pattern (nil caches every key)
+	noCacheKeys *keyGlobs
+
+	// ledger records which upstream buckets have had objects cached locally
+	ledger *residencyLedger
+
+	// readOnlyBuckets reject client writes whatever the read-only toggle
+	readOnlyBuckets map[string]bool
+
+	// tagRules apply caching rules to objects by their upstream tags
+	tagRules []TagRule
+
+	// streamThreshold is the size above which GET misses are streamed to the
+	// client without being cached (0 disables)
+	streamThreshold int64
+
+	// identityHeader names a request header that identifies the client,
+	// taking priority over the signing access key ("" disables)
+	identityHeader string
+	identities     *identityStats
+
+	// slos checks latency and error objectives per operation (nil disables)
+	slos *sloTracker
+
+	// chunks caches the parts of large objects that range reads need
+	// (nil disables)
+	chunks *chunkStore
+
+	// rangePassthrough forwards uncached range reads to upstream instead
+	// of downloading the whole object; rangeFills then caches it in the
+	// background (nil disables)
+	rangePassthrough bool
+	rangeFills       *backgroundFills
+
+	// refreshAhead re-fetches hot objects before they expire (nil disables)
+	refreshAhead *refreshAheadPolicy
+
+	// listPrefetch fetches the rest of a listing once a client starts
+	// reading its keys (nil disables)
+	listPrefetch *listPrefetcher
+
+	// packs holds the disk backend's packed objects, for compaction (nil
+	// without a disk backend)
+	packs *packStore
+
+	// team is a shared bucket looked up before the origin (nil disables)
+	team *teamCache
+
+	// verifier checks uploads to upstream after they finish (nil disables)
+	verifier *uploadVerifier
+
+	// localData accounts for the objects clients write, against their
+	// buckets' local quotas
+	localData *localData
+
+	// followInterval is how often followed objects are polled upstream
+	followInterval time.Duration
+
+	// keyFilters answer misses of keys that aren't upstream (nil disables)
+	keyFilters *keyFilters
+
+	// bucketUpstreams are the local buckets fetched from their own upstream
+	// instead of awsClient, guarded by mu
+	bucketUpstreams map[string]bucketUpstream
+
+	// clones are the buckets cloned at runtime, with their mappings
+	clones *cloneRegistry
 }
 
 // NewLazyBackend creates a new lazy-loading backend wrapper.
-func NewLazyBackend(local gofakes3.Backend, awsClient *s3.Client) *LazyBackend {
+func NewLazyBackend(local gofakes3.Backend, awsClient upstreamClient) *LazyBackend {
 	return &LazyBackend{
 		local:         local,
 		awsClient:     awsClient,
 		bucketMapping: make(map[string]string),
+		urlSources:    make(map[string]*httpSource),
+		locks:         newKeyLocks(defaultLockStripes),
+		fills:         newFillGroup(),
+		versions:      newOpenVersions(),
+		quirks:        quirksProfiles["aws"],
+		stats:         &cacheStats{},
+		ledger:        newResidencyLedger(),
+		prefixStats:   newPrefixStats(defaultPrefixStatsDepth),
+		events:        newEventLog(defaultEventLogKeys),
+		index:         newCacheIndex(0),
+		pins:          newPinSet(),
+		toggles:       newRuntimeToggles(),
+		prefetches:    &prefetchJobs{},
+		identities:    newIdentityStats(),
+		localData:     newLocalData(),
+		clones:        newCloneRegistry(),
+
+		followInterval: defaultFollowInterval,
+	}
+}
+
+// SetPins pins keys ("bucket/key") and prefixes ("bucket/prefix*") so they
+// are never expired by TTL or evicted by LRU once cached.
+func (b *LazyBackend) SetPins(patterns []string) error {
+	pins := newPinSet()
+	for _, pattern := range patterns {
+		if _, err := pins.add(pattern); err != nil {
+			return err
+		}
+	}
+	b.pins = pins
+	return nil
+}
+
+// SetCacheMaxBytes limits the total size of objects cached from upstream,
+// evicting them in the eviction policy's order once it is exceeded. Objects written
+// by clients don't count towards the limit. 0 means unlimited.
+func (b *LazyBackend) SetCacheMaxBytes(maxBytes int64) {
+	b.index.mu.Lock()
+	b.index.maxBytes = maxBytes
+	b.index.mu.Unlock()
+	b.evict()
+}
+
+// SetCacheMaxObjects limits how many objects cached from upstream each
+// bucket holds, evicting them in the eviction policy's order once it is exceeded,
+// for filesystems that run out of inodes before space. perBucket overrides
+// the limit for individual buckets. 0 means unlimited.
+func (b *LazyBackend) SetCacheMaxObjects(maxObjects int, perBucket map[string]int) {
+	b.index.mu.Lock()
+	b.index.maxObjects = maxObjects
+	b.index.bucketMaxObjects = maps.Clone(perBucket)
+	b.index.mu.Unlock()
+	b.evict()
+}
+
+// SetEvictionPolicy chooses the order cached objects are evicted in: "lru",
+// "lfu" or "arc". Entries already tracked are kept.
+func (b *LazyBackend) SetEvictionPolicy(name string) error {
+	policy, err := lookupEvictionPolicy(name)
+	if err != nil {
+		return err
+	}
+	b.index.setPolicy(policy)
+	return nil
+}
+
+// evict deletes cache entries in the eviction policy's order until the cache is within
+// its size budget and object limits. It must be called without holding any
+// key lock.
+func (b *LazyBackend) evict() {
+	b.evictEntries(b.index.evictionCandidates(b.pins.pinned))
+}
+
+// evictEntries deletes cache entries from the local backend and returns how
+// many bytes were freed. It must be called without holding any key lock.
+func (b *LazyBackend) evictEntries(entries []cacheEntry) int64 {
+	var freed int64
+	for _, e := range entries {
+		unlock := b.locks.Lock(e.Bucket, e.Key)
+		// Skip entries a concurrent write or eviction already took over
+		if b.index.remove(e.Bucket, e.Key) {
+			if _, err := b.local.DeleteObject(e.Bucket, e.Key); err != nil {
+				log.Printf("[EVICT ERROR] %s/%s: %v", e.Bucket, e.Key, err)
+			} else {
+				log.Printf("[EVICT] %s/%s (%d bytes)", e.Bucket, e.Key, e.Size)
+				b.events.record(e.Bucket, e.Key, eventEvicted, "")
+				b.notify(eventEvicted, e.Bucket, e.Key, e.Size)
+				freed += e.Size
+			}
+		}
+		unlock()
+	}
+	return freed
+}
+
+// SetStreamThreshold streams objects larger than threshold bytes straight
+// from upstream to the client instead of caching them. 0 caches everything.
+func (b *LazyBackend) SetStreamThreshold(threshold int64) {
+	b.streamThreshold = threshold
+}
+
+// SetChunkedRanges caches range reads of objects larger than chunkSize
+// bytes in chunkSize-byte chunks instead of fetching the whole object. Chunks
+// are kept in memory, or under dir if set, within maxBytes (0 is unlimited).
+func (b *LazyBackend) SetChunkedRanges(chunkSize, maxBytes int64, dir string) error {
+	chunks, err := newChunkStore(chunkSize, maxBytes, dir)
+	if err != nil {
+		return err
+	}
+	b.chunks = chunks
+	return nil
+}
+
+// SetPackStore sets the disk backend's pack store, for compacting it.
+func (b *LazyBackend) SetPackStore(packs *packStore) {
+	b.packs = packs
+}
+
+// SetRangePassthrough serves range reads of uncached objects by forwarding
+// the range to upstream instead of caching the whole object first. With
+// backgroundFill, the whole object is then cached in the background.
+func (b *LazyBackend) SetRangePassthrough(enabled, backgroundFill bool) {
+	b.rangePassthrough = enabled
+	b.rangeFills = nil
+	if enabled && backgroundFill {
+		b.rangeFills = newBackgroundFills()
+	}
+}
+
+// SetIdentityHeader identifies clients by the named request header when
+// it is present, instead of by the access key their requests are signed with.
+func (b *LazyBackend) SetIdentityHeader(header string) {
+	b.identityHeader = header
+}
+
+// SetTagRules fetches the tags of every object filled from upstream and
+// applies the first matching rule to it.
+func (b *LazyBackend) SetTagRules(rules []TagRule) error {
+	for _, rule := range rules {
+		if err := validateTagRule(rule); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tagRules = rules
+	return nil
+}
+
+// SetCachePolicy restricts which local buckets may cache upstream objects:
+// only those in allow (every bucket if empty), and never those in deny.
+func (b *LazyBackend) SetCachePolicy(allow, deny []string) {
+	b.cachePolicy = newCachePolicy(allow, deny)
+}
+
+// SetRedactor runs every object fetched from upstream through r before it
+// is cached.
+func (b *LazyBackend) SetRedactor(r Redactor) {
+	b.redactor = r
+}
+
+// SetListLag hides newly created objects from listings for delay, to test
+// clients against list-after-write lag. 0 disables it.
+func (b *LazyBackend) SetListLag(delay time.Duration) {
+	b.listLag = newListLag(delay)
+}
+
+// SetTrafficSchedule limits upstream bandwidth and fetch concurrency during
+// daily time windows. An empty schedule removes all limits.
+func (b *LazyBackend) SetTrafficSchedule(rules []TrafficRule) error {
+	shaper, err := newTrafficShaper(rules)
+	if err != nil {
+		return err
 	}
+	b.shaper = shaper
+	return nil
+}
+
+// defaultPrefixStatsDepth is the number of key path segments prefix
+// statistics are aggregated at by default.
+const defaultPrefixStatsDepth = 1
+
+// SetPrefixStatsDepth sets how many key path segments prefix statistics are
+// aggregated at, resetting collected statistics. A depth of 0 disables them.
+func (b *LazyBackend) SetPrefixStatsDepth(depth int) {
+	if depth <= 0 {
+		b.prefixStats = nil
+		return
+	}
+	b.prefixStats = newPrefixStats(depth)
+}
+
+// SetUpstreamQuirks selects the compatibility mode for a non-AWS upstream.
+func (b *LazyBackend) SetUpstreamQuirks(name string) error {
+	q, err := lookupQuirks(name)
+	if err != nil {
+		return err
+	}
+	b.quirks = q
+	return nil
 }
 
 // SetBucketMappings sets all bucket mappings at once.
@@ -43,6 +378,127 @@ func (b *LazyBackend) SetBucketMappings(mappings map[string]string) {
 	}
 }
 
+// SetURLSources configures buckets that are fetched over plain HTTP(S) from a
+// URL template instead of the S3 API. See httpSource for the template format.
+// With revalidate set, every cache hit is checked against upstream with a HEAD.
+func (b *LazyBackend) SetURLSources(templates map[string]string, revalidate bool) error {
+	sources := make(map[string]*httpSource, len(templates))
+	for bucket, template := range templates {
+		src, err := newHTTPSource(template)
+		if err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket, err)
+		}
+		src.revalidate = revalidate
+		sources[bucket] = src
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.urlSources = sources
+	return nil
+}
+
+// SetCacheTTL sets how long objects fetched from upstream are served before
+// being re-fetched, globally and per bucket. A per-bucket TTL overrides the
+// global one; 0 means cached objects never expire.
+func (b *LazyBackend) SetCacheTTL(ttl time.Duration, perBucket map[string]time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ttl = ttl
+	b.bucketTTLs = make(map[string]time.Duration, len(perBucket))
+	for bucket, d := range perBucket {
+		b.bucketTTLs[bucket] = d
+	}
+}
+
+// SetRevalidate enables revalidating every cache hit with a conditional GET
+// (If-None-Match with the stored upstream ETag), refreshing the local copy
+// when the object changed upstream.
+func (b *LazyBackend) SetRevalidate(revalidate bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.revalidate = revalidate
+}
+
+// SetOperationTimeouts bounds how long each kind of upstream operation may
+// take, keyed by operation name (head, get, list, tagging). An operation
+// without a timeout runs as long as its caller's context allows.
+func (b *LazyBackend) SetOperationTimeouts(timeouts map[string]time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timeouts = maps.Clone(timeouts)
+}
+
+// operation derives the context of one upstream operation from ctx.
+func (b *LazyBackend) operation(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	b.mu.RLock()
+	timeouts := b.timeouts
+	b.mu.RUnlock()
+	return timeouts.context(ctx, op)
+}
+
+// ttlFor returns the TTL that applies to a bucket.
+func (b *LazyBackend) ttlFor(bucketName string) time.Duration {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if ttl, ok := b.bucketTTLs[bucketName]; ok {
+		return ttl
+	}
+	return b.ttl
+}
+
+// entryTTL returns a cached object's TTL: the one a tag rule gave it, or else
+// its bucket's, less any jitter.
+func (b *LazyBackend) entryTTL(e cacheEntry) time.Duration {
+	if e.TTL > 0 {
+		return b.jittered(e, e.TTL)
+	}
+	return b.jittered(e, b.ttlFor(e.Bucket))
+}
+
+// expired reports whether a cached object has outlived its TTL.
+func (b *LazyBackend) expired(bucketName, objectName string) bool {
+	ttl := b.ttlFor(bucketName)
+	if entry, ok := b.index.lookup(bucketName, objectName); ok {
+		ttl = b.entryTTL(entry)
+	}
+	if ttl <= 0 || b.pins.pinned(bucketName, objectName) {
+		return false
+	}
+	age, ok := b.index.age(bucketName, objectName)
+	return ok && age > ttl
+}
+
+// stale reports whether a cached object should be re-fetched before serving.
+func (b *LazyBackend) stale(ctx context.Context, bucketName, objectName string, cached *gofakes3.Object) bool {
+	if b.toggles.offline.Load() {
+		return false
+	}
+	if b.toggles.bypass.Load() {
+		log.Printf("[CACHE BYPASS] %s/%s", bucketName, objectName)
+		return true
+	}
+	if b.expired(bucketName, objectName) {
+		log.Printf("[CACHE EXPIRED] %s/%s", bucketName, objectName)
+		b.events.record(bucketName, objectName, eventExpired, "")
+		return true
+	}
+	upstream, _ := b.upstreamFor(bucketName)
+	if src, ok := upstream.(*httpSource); ok {
+		if !src.revalidate {
+			return false
+		}
+		ctx, cancel := b.operation(ctx, opHead)
+		defer cancel()
+		return src.changed(ctx, bucketName, objectName, cached, b.index.etag(bucketName, objectName))
+	}
+	b.mu.RLock()
+	revalidate := b.revalidate
+	b.mu.RUnlock()
+	// Only objects fetched with an ETag can be revalidated conditionally
+	return revalidate && b.index.etag(bucketName, objectName) != ""
+}
+
 func (b *LazyBackend) awsBucketName(localBucket string) string {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -52,69 +508,436 @@ func (b *LazyBackend) awsBucketName(localBucket string) string {
 	return localBucket
 }
 
+// errOffline is returned for keys that aren't cached while offline mode keeps
+// s3lazy from contacting upstream. Clients see NoSuchKey.
+func errOffline(objectName string) error {
+	return newFailure(ErrUpstreamUnavailable, gofakes3.ErrNoSuchKey, nil, "%s is not cached and s3lazy is offline", objectName)
+}
+
+// errReadOnly is returned for writes while read-only mode is on.
+func errReadOnly() error {
+	return newFailure(ErrReadOnly, "AccessDenied", nil, "%v", ErrReadOnly)
+}
+
+// SetReadOnlyBuckets makes buckets reject client writes and deletes, as
+// read-only mode does for every bucket.
+func (b *LazyBackend) SetReadOnlyBuckets(buckets []string) {
+	b.readOnlyBuckets = make(map[string]bool, len(buckets))
+	for _, bucket := range buckets {
+		b.readOnlyBuckets[b.canonicalBucket(bucket)] = true
+	}
+}
+
+// rejectWrite returns the error for a client write to a bucket while
+// read-only mode is on or the bucket is read-only, and nil otherwise.
+func (b *LazyBackend) rejectWrite(bucketName string) error {
+	if b.toggles.readOnly.Load() {
+		return errReadOnly()
+	}
+	if b.readOnlyBuckets[b.canonicalBucket(bucketName)] {
+		return newFailure(ErrReadOnly, "AccessDenied", nil, "bucket %s is read-only", bucketName)
+	}
+	if isSnapshotBucket(b.canonicalBucket(bucketName)) {
+		return errSnapshotWrite(bucketName)
+	}
+	return nil
+}
+
 // isNotFound checks if an error indicates the object was not found
 func isNotFound(err error) bool {
 	return gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) ||
 		gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket)
 }
 
-// GetObject tries local cache first, then fetches from AWS and caches locally.
+// GetObject is GetObjectContext for gofakes3, which has no request context.
 func (b *LazyBackend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	return b.GetObjectContext(context.Background(), bucketName, objectName, rangeRequest)
+}
+
+// GetObjectContext tries local cache first, then fetches from AWS and caches
+// locally. Upstream requests are made under ctx, bounded by the operation
+// timeouts. The object's X-Cache header tells how it was served.
+func (b *LazyBackend) GetObjectContext(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	obj, status, err := b.getObject(ctx, b.canonicalBucket(bucketName), objectName, rangeRequest)
+	if err != nil {
+		return nil, err
+	}
+	return withCacheStatus(obj, status), nil
+}
+
+// getObject serves a GET, returning how it was served for X-Cache.
+func (b *LazyBackend) getObject(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, string, error) {
+	b.prefetchListed(bucketName, objectName)
+	if err := b.fills.join(ctx, bucketName, objectName); err != nil {
+		return nil, "", err
+	}
+
 	// Try local cache first
-	obj, err := b.local.GetObject(bucketName, objectName, rangeRequest)
+	obj, err := b.getLocal(bucketName, objectName, rangeRequest)
 	if err == nil {
-		log.Printf("[CACHE HIT] %s/%s", bucketName, objectName)
-		return obj, nil
+		if !b.stale(ctx, bucketName, objectName, obj) {
+			b.hit(bucketName, objectName, obj)
+			return obj, cacheHit, nil
+		}
+		obj.Contents.Close()
+		return b.revalidated(ctx, bucketName, objectName, rangeRequest)
 	}
 
 	// Check if it's a "not found" error vs other errors
 	if !isNotFound(err) {
 		log.Printf("[LOCAL ERROR] %s/%s: %v", bucketName, objectName, err)
+		return nil, "", err
+	}
+	if isSnapshotBucket(bucketName) {
+		// A snapshot has what its bucket had when it was taken
+		return nil, "", err
+	}
+	if obj, status, ok := b.getTwin(ctx, bucketName, objectName, rangeRequest); ok {
+		return obj, status, nil
+	}
+	return b.miss(ctx, bucketName, objectName, rangeRequest)
+}
+
+// revalidated refreshes a stale cached object and serves it.
+func (b *LazyBackend) revalidated(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, string, error) {
+	status := cacheRevalidated
+	changed, err := b.refresh(ctx, bucketName, objectName, false)
+	if errors.Is(err, errNotCacheable) {
+		// A tag rule dropped the cached copy; serve it straight from upstream
+		return b.miss(ctx, bucketName, objectName, rangeRequest)
+	}
+	if err != nil {
+		if isNotFound(err) {
+			return nil, "", err
+		}
+		// Keep serving the cached copy while upstream is unavailable
+		log.Printf("[REVALIDATE ERROR] %s/%s: %v", bucketName, objectName, err)
+		status = cacheStale
+	}
+	if changed {
+		status = cacheMiss
+	}
+	obj, err := b.getLocal(bucketName, objectName, rangeRequest)
+	if err != nil {
+		return nil, "", err
+	}
+	if !changed {
+		b.hit(bucketName, objectName, obj)
+	}
+	return obj, status, nil
+}
+
+// miss serves a GET for an object that isn't cached, fetching it from
+// upstream and caching it unless it is streamed through.
+func (b *LazyBackend) miss(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, string, error) {
+	if err := b.filteredMiss(bucketName, objectName); err != nil {
+		return nil, "", err
+	}
+	// Redacted objects must be scanned whole, so they aren't chunked
+	if rangeRequest != nil && b.chunks != nil && b.redactor == nil && b.caches(bucketName, objectName) {
+		obj, status, err := b.chunkedRange(ctx, bucketName, objectName, rangeRequest)
+		if obj != nil || err != nil {
+			return obj, status, err
+		}
+	}
+	if rangeRequest != nil && b.rangePassthrough {
+		obj, err := b.passThroughRange(ctx, bucketName, objectName, rangeRequest)
+		return obj, cacheMiss, err
+	}
+	obj, err := b.fillOrStream(ctx, bucketName, objectName, &streamRequest{rangeRequest: rangeRequest})
+	if err != nil {
+		return nil, "", err
+	}
+	if obj != nil {
+		return obj, cacheMiss, nil
+	}
+
+	// Return from local cache
+	obj, err = b.getLocal(bucketName, objectName, rangeRequest)
+	return obj, cacheMiss, err
+}
+
+// hit records a request served from the local cache.
+func (b *LazyBackend) hit(bucketName, objectName string, obj *gofakes3.Object) {
+	b.toggles.infof("[CACHE HIT] %s/%s", bucketName, objectName)
+	b.stats.recordHit(servedBytes(obj))
+	b.prefixStats.recordHit(bucketName, objectName)
+	b.index.touch(bucketName, objectName)
+	b.events.record(bucketName, objectName, eventServed, "")
+}
+
+// getLocal reads an object from the local backend under the key's shared lock,
+// held until the returned contents are closed unless the backend keeps the
+// version opened intact (see openVersion).
+func (b *LazyBackend) getLocal(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	unlock := b.locks.RLock(bucketName, objectName)
+	obj, err := b.local.GetObject(bucketName, objectName, rangeRequest)
+	if err != nil {
+		unlock()
 		return nil, err
 	}
+	obj.Contents = b.openVersion(bucketName, objectName, obj, unlock)
+	return obj, nil
+}
+
+// fill fetches an object from AWS and writes it to the local backend while
+// holding the key's exclusive lock.
+func (b *LazyBackend) fill(ctx context.Context, bucketName, objectName string) error {
+	_, err := b.fillOrStream(ctx, bucketName, objectName, nil)
+	return err
+}
+
+// fillOrStream is fill for a client GET: an object that isn't to be cached
+// is returned for streaming instead. A nil object
+// means the object is now in the local backend. Concurrent fills of the same
+// key share one upstream fetch.
+func (b *LazyBackend) fillOrStream(ctx context.Context, bucketName, objectName string, stream *streamRequest) (*gofakes3.Object, error) {
+	return b.fills.do(ctx, bucketName, objectName, func() (*gofakes3.Object, error) {
+		return b.fillOnce(ctx, bucketName, objectName, stream)
+	})
+}
+
+// fillOnce fetches an object for fillOrStream, holding the key's exclusive
+// lock.
+func (b *LazyBackend) fillOnce(ctx context.Context, bucketName, objectName string, stream *streamRequest) (*gofakes3.Object, error) {
+	defer b.evict()
+	unlock := b.locks.Lock(bucketName, objectName)
+	defer unlock()
+
+	// Another request may have filled the entry while we waited for the lock
+	if _, err := b.local.HeadObject(bucketName, objectName); err == nil {
+		return nil, nil
+	}
+
+	if b.toggles.offline.Load() {
+		return nil, errOffline(objectName)
+	}
+	b.toggles.infof("[CACHE MISS] %s/%s - fetching from AWS", bucketName, objectName)
+	return b.fetchLocked(ctx, bucketName, objectName, "", stream)
+}
+
+// refresh re-fetches an object from upstream, overwriting the cached copy.
+// When the upstream ETag of the cached copy is known the GET is conditional,
+// and an unchanged object is kept without downloading it again, unless
+// unconditional is set. It reports whether the cached copy was replaced.
+func (b *LazyBackend) refresh(ctx context.Context, bucketName, objectName string, unconditional bool) (bool, error) {
+	defer b.evict()
+	unlock := b.locks.Lock(bucketName, objectName)
+	defer unlock()
+
+	log.Printf("[CACHE REFRESH] %s/%s - revalidating against AWS", bucketName, objectName)
+	etag := b.index.etag(bucketName, objectName)
+	if unconditional || b.toggles.bypass.Load() {
+		etag = ""
+	}
+	_, err := b.fetchLocked(ctx, bucketName, objectName, etag, nil)
+	if errors.Is(err, errNotModified) {
+		b.index.renew(bucketName, objectName)
+		b.events.record(bucketName, objectName, eventRefreshed, "unchanged")
+		return false, nil
+	}
+	if errors.Is(err, errNotCacheable) {
+		b.index.remove(bucketName, objectName)
+		b.chunks.drop(bucketName, objectName)
+		if _, delErr := b.local.DeleteObject(bucketName, objectName); delErr != nil {
+			return false, delErr
+		}
+		log.Printf("[NOT CACHEABLE] %s/%s: dropped cached copy", bucketName, objectName)
+		b.events.record(bucketName, objectName, eventDropped, "excluded from caching")
+	}
+	if err == nil {
+		b.logSwitch(bucketName, objectName)
+		b.events.record(bucketName, objectName, eventRefreshed, "changed")
+	}
+	return err == nil, err
+}
 
-	log.Printf("[CACHE MISS] %s/%s - fetching from AWS", bucketName, objectName)
+// errNotCacheable is returned by fetchLocked when the cache policy, a tag
+// rule or the redactor forbids caching an object.
+var errNotCacheable = errors.New("excluded from caching")
+
+// errNotModified is returned by fetchLocked when a conditional GET finds the
+// cached copy is still current.
+var errNotModified = errors.New("not modified")
+
+// fetchLocked downloads an object from upstream into the local backend.
+// With ifNoneMatch set the GET is conditional on the object having changed.
+// With stream set, objects above the stream-through threshold or excluded by
+// the cache policy or a tag rule aren't cached but returned for streaming straight to the client;
+// without it excluded objects fail with errNotCacheable. The caller must hold
+// the key's exclusive lock.
+func (b *LazyBackend) fetchLocked(ctx context.Context, bucketName, objectName, ifNoneMatch string, stream *streamRequest) (*gofakes3.Object, error) {
+	release, err := b.shaper.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	streaming := false
+	defer func() {
+		if !streaming {
+			release()
+		}
+	}()
 
 	// Fetch from AWS
-	awsBucket := b.awsBucketName(bucketName)
-	awsObj, err := b.awsClient.GetObject(context.Background(), &s3.GetObjectInput{
+	upstream, awsBucket := b.upstreamFor(bucketName)
+	rule, noCache := TagRule{}, !b.caches(bucketName, objectName)
+	if !noCache {
+		rule, _ = b.tagRuleFor(ctx, upstream, awsBucket, objectName)
+		noCache = rule.NoCache
+	}
+	if noCache && stream == nil {
+		return nil, fmt.Errorf("%s/%s: %w", bucketName, objectName, errNotCacheable)
+	}
+	ctx, cancel := b.operation(ctx, opGet)
+	defer func() {
+		if !streaming {
+			cancel()
+		}
+	}()
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(awsBucket),
 		Key:    aws.String(objectName),
-	})
+	}
+	if ifNoneMatch != "" {
+		input.IfNoneMatch = aws.String(ifNoneMatch)
+	}
+	var awsObj *s3.GetObjectOutput
+	// Revalidations go to the origin, which alone knows whether the cached
+	// copy is still current
+	fromTeam := false
+	if ifNoneMatch == "" && b.team.covers(upstream) {
+		awsObj, err = b.team.get(ctx, awsBucket, objectName)
+		fromTeam = err == nil
+		if fromTeam {
+			log.Printf("[TEAM HIT] %s/%s", bucketName, objectName)
+		} else if s3ErrorCode(err) != "NoSuchKey" {
+			log.Printf("[TEAM ERROR] %s/%s: %v", bucketName, objectName, err)
+		}
+	}
+	if !fromTeam {
+		awsObj, err = upstream.GetObject(ctx, input)
+	}
+	if ifNoneMatch != "" && isNotModified(err) {
+		log.Printf("[NOT MODIFIED] %s/%s", bucketName, objectName)
+		h := notModifiedHeaders(err)
+		b.index.setTTL(bucketName, objectName, b.fetchedTTL(rule, h.Get("Cache-Control"), h.Get("Expires")))
+		return nil, errNotModified
+	}
 	if err != nil {
 		log.Printf("[AWS ERROR] %s/%s: %v", awsBucket, objectName, err)
-		return nil, gofakes3.KeyNotFound(objectName)
+		return nil, b.quirks.translate(err, bucketName, objectName)
 	}
-	defer awsObj.Body.Close()
+	if stream != nil && (noCache || b.tooLargeToCache(aws.ToInt64(awsObj.ContentLength))) {
+		obj, err := b.streamThrough(ctx, bucketName, objectName, stream.rangeRequest, upstream, awsBucket, awsObj, func() {
+			release()
+			cancel()
+		})
+		streaming = err == nil
+		return obj, err
+	}
+	// Resume broken downloads and verify them before they land in the cache.
+	// URL source and Object Lambda ETags aren't MD5s of the returned body.
+	_, isHTTPSource := upstream.(*httpSource)
+	verifyMD5 := b.quirks.md5ETags && !isHTTPSource && !isObjectLambdaARN(awsBucket)
+	download := newResumableBody(ctx, upstream, awsBucket, objectName, awsObj, verifyMD5)
+	defer download.Close()
 
 	// Get size from AWS response
+	body := b.shaper.reader(download)
 	var size int64
-	if awsObj.ContentLength != nil {
+	if awsObj.ContentLength != nil && *awsObj.ContentLength >= 0 {
 		size = *awsObj.ContentLength
+	} else {
+		// Object Lambda responses are streamed without a Content-Length,
+		// so spool them to learn the exact size before caching
+		spooled, n, err := spoolToTempFile(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s/%s: %w", awsBucket, objectName, err)
+		}
+		defer spooled.Close()
+		body, size = spooled, n
 	}
 
-	// Extract metadata
-	meta := make(map[string]string)
-	if awsObj.ContentType != nil {
-		meta["Content-Type"] = *awsObj.ContentType
-	}
-	for k, v := range awsObj.Metadata {
-		meta[k] = v
+	meta := upstreamMetadata(awsObj)
+
+	if b.redactor != nil {
+		data, err := b.redact(bucketName, objectName, body, size)
+		if errors.Is(err, errRedactionRejected) {
+			if stream == nil || data == nil {
+				return nil, fmt.Errorf("%s/%s: %w", bucketName, objectName, errNotCacheable)
+			}
+			b.stats.recordMiss(size)
+			b.prefixStats.recordMiss(bucketName, objectName, size)
+			return memoryObject(objectName, meta, awsObj.ETag, data, stream.rangeRequest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, size = bytes.NewReader(data), int64(len(data))
 	}
 
 	// Stream directly to local cache (no memory buffering)
-	log.Printf("[CACHING] %s/%s (%d bytes)", bucketName, objectName, size)
-	_, err = b.local.PutObject(bucketName, objectName, meta, awsObj.Body, size, nil)
+	b.toggles.infof("[CACHING] %s/%s (%d bytes)", bucketName, objectName, size)
+	_, err = b.local.PutObject(bucketName, objectName, meta, body, size, nil)
 	if err != nil {
+		// Don't leave a partially written entry behind to be served as a hit
+		_, _ = b.local.DeleteObject(bucketName, objectName)
+		b.index.remove(bucketName, objectName)
+		if isNoSpace(err) {
+			return nil, newFailure(ErrCacheFull, gofakes3.ErrInternal, err, "no space to cache %s/%s", bucketName, objectName)
+		}
 		return nil, fmt.Errorf("failed to cache %s/%s: %w", bucketName, objectName, err)
 	}
+	b.stats.recordMiss(size)
+	b.prefixStats.recordMiss(bucketName, objectName, size)
+	b.index.add(bucketName, objectName, size, aws.ToString(awsObj.ETag))
+	b.index.setTTL(bucketName, objectName, b.fetchedTTL(rule, aws.ToString(awsObj.CacheControl), expiresHeader(awsObj)))
+	b.index.setNamespace(bucketName, objectName, b.namespace(bucketName))
+	b.events.record(bucketName, objectName, eventFetched, fmt.Sprintf("%d bytes, ETag %s", size, aws.ToString(awsObj.ETag)))
+	b.notify(eventFetched, bucketName, objectName, size)
+	// The whole object supersedes any chunks cached for range reads
+	b.chunks.drop(bucketName, objectName)
+	if err := b.ledger.record(awsBucket, bucketName, size); err != nil {
+		log.Printf("Warning: couldn't save residency ledger: %v", err)
+	}
+	if !fromTeam && b.team.covers(upstream) {
+		b.writeBack(bucketName, objectName, awsBucket, awsObj)
+	}
+	return nil, nil
+}
 
-	// Return from local cache
-	return b.local.GetObject(bucketName, objectName, rangeRequest)
+// tooLargeToCache reports whether a client read of an object of size bytes
+// should be streamed from upstream rather than cached.
+func (b *LazyBackend) tooLargeToCache(size int64) bool {
+	return b.streamThreshold > 0 && size > b.streamThreshold ||
+		b.redactor != nil && size > redactMaxBytes
 }
 
-// HeadObject checks local first, then AWS. Does not cache on HEAD.
+// upstreamMetadata extracts the metadata stored with a cached object from an
+// upstream response.
+func upstreamMetadata(awsObj *s3.GetObjectOutput) map[string]string {
+	meta := make(map[string]string)
+	if awsObj.ContentType != nil {
+		meta["Content-Type"] = *awsObj.ContentType
+	}
+	if awsObj.LastModified != nil {
+		meta["Last-Modified"] = awsObj.LastModified.UTC().Format(http.TimeFormat)
+	}
+	for k, v := range awsObj.Metadata {
+		meta[k] = v
+	}
+	return meta
+}
+
+// HeadObject is HeadObjectContext for gofakes3, which has no request context.
 func (b *LazyBackend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
+	return b.HeadObjectContext(context.Background(), bucketName, objectName)
+}
+
+// HeadObjectContext checks local first, then AWS under ctx. Does not cache on
+// HEAD.
+func (b *LazyBackend) HeadObjectContext(ctx context.Context, bucketName, objectName string) (*gofakes3.Object, error) {
+	bucketName = b.canonicalBucket(bucketName)
 	obj, err := b.local.HeadObject(bucketName, objectName)
 	if err == nil {
 		return obj, nil
@@ -124,76 +947,242 @@ func (b *LazyBackend) HeadObject(bucketName, objectName string) (*gofakes3.Objec
 		log.Printf("[LOCAL HEAD ERROR] %s/%s: %v", bucketName, objectName, err)
 		return nil, err
 	}
+	if isSnapshotBucket(bucketName) {
+		return nil, err
+	}
+	if obj, ok := b.headTwin(bucketName, objectName); ok {
+		return obj, nil
+	}
+
+	if b.toggles.offline.Load() {
+		return nil, errOffline(objectName)
+	}
+	if err := b.filteredMiss(bucketName, objectName); err != nil {
+		return nil, err
+	}
 
 	// Check AWS (but don't cache on HEAD - wait for actual GET)
-	awsBucket := b.awsBucketName(bucketName)
-	awsObj, err := b.awsClient.HeadObject(context.Background(), &s3.HeadObjectInput{
+	upstream, awsBucket := b.upstreamFor(bucketName)
+	if isObjectLambdaARN(awsBucket) {
+		// An Object Lambda HEAD describes the untransformed object, so its size
+		// and ETag wouldn't match the body a GET returns. Fill instead.
+		if err := b.fill(ctx, bucketName, objectName); err != nil {
+			return nil, err
+		}
+		return b.local.HeadObject(bucketName, objectName)
+	}
+	ctx, cancel := b.operation(ctx, opHead)
+	defer cancel()
+	awsObj, err := upstream.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(awsBucket),
 		Key:    aws.String(objectName),
 	})
 	if err != nil {
-		return nil, gofakes3.KeyNotFound(objectName)
+		return nil, b.quirks.translate(err, bucketName, objectName)
 	}
 
-	return headOutputToObject(objectName, awsObj), nil
+	obj = headOutputToObject(objectName, awsObj)
+	if !b.quirks.md5ETags {
+		// The cache computes an MD5 ETag on fill; don't advertise an upstream
+		// ETag that a later GET of the same object won't match
+		obj.Hash = nil
+	}
+	return obj, nil
 }
 
 // CopyObject ensures source exists locally (triggering lazy fetch if needed), then copies.
 func (b *LazyBackend) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, meta map[string]string) (gofakes3.CopyObjectResult, error) {
+	if err := b.rejectWrite(dstBucket); err != nil {
+		return gofakes3.CopyObjectResult{}, err
+	}
+	srcBucket, dstBucket = b.canonicalBucket(srcBucket), b.canonicalBucket(dstBucket)
 	// Ensure source exists locally (this will fetch from AWS if needed)
-	_, err := b.GetObject(srcBucket, srcKey, nil)
+	obj, err := b.GetObject(srcBucket, srcKey, nil)
 	if err != nil {
 		return gofakes3.CopyObjectResult{}, err
 	}
+	obj.Contents.Close()
 
-	// Now do the copy locally
-	return b.local.CopyObject(srcBucket, srcKey, dstBucket, dstKey, meta)
+	// Now do the copy locally, holding both keys so neither changes mid-copy
+	unlock := b.lockPair(srcBucket, srcKey, dstBucket, dstKey)
+	defer unlock()
+	done, err := b.localData.admit(dstBucket, dstKey, obj.Size)
+	if err != nil {
+		return gofakes3.CopyObjectResult{}, err
+	}
+	b.index.remove(dstBucket, dstKey)
+	created := b.listLag != nil && !b.existsLocally(dstBucket, dstKey)
+	result, err := b.local.CopyObject(srcBucket, srcKey, dstBucket, dstKey, meta)
+	done(err == nil)
+	if err == nil {
+		b.events.record(dstBucket, dstKey, eventWritten, "copied from "+srcBucket+"/"+srcKey)
+	}
+	if err == nil && created {
+		b.listLag.created(dstBucket, dstKey)
+	}
+	return result, err
 }
 
 // Delegate all other methods to local backend
 
 func (b *LazyBackend) ListBuckets() ([]gofakes3.BucketInfo, error) {
-	return b.local.ListBuckets()
+	buckets, err := b.local.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	return b.withAliases(buckets), nil
 }
 
 func (b *LazyBackend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) (*gofakes3.ObjectList, error) {
-	return b.local.ListBucket(name, prefix, page)
+	name = b.canonicalBucket(name)
+	list, err := b.local.ListBucket(name, prefix, page)
+	if err != nil {
+		return nil, err
+	}
+	b.listLag.filter(name, list)
+	b.listPrefetch.listed(name, list)
+	return list, nil
 }
 
 func (b *LazyBackend) BucketExists(name string) (bool, error) {
-	return b.local.BucketExists(name)
+	return b.local.BucketExists(b.canonicalBucket(name))
 }
 
 func (b *LazyBackend) CreateBucket(name string) error {
-	return b.local.CreateBucket(name)
+	if b.toggles.readOnly.Load() {
+		return errReadOnly()
+	}
+	return b.local.CreateBucket(b.canonicalBucket(name))
 }
 
 func (b *LazyBackend) DeleteBucket(name string) error {
-	return b.local.DeleteBucket(name)
+	if err := b.rejectWrite(name); err != nil {
+		return err
+	}
+	if b.canonicalBucket(name) != name {
+		return b.errAliasDelete(name)
+	}
+	if err := b.local.DeleteBucket(name); err != nil {
+		return err
+	}
+	b.index.removeBucket(name)
+	b.localData.forgetBucket(name)
+	b.chunks.dropBucket(name)
+	if err := b.clones.forget(name); err != nil {
+		log.Printf("Warning: couldn't save the clones after deleting %s: %v", name, err)
+	}
+	return nil
 }
 
 func (b *LazyBackend) ForceDeleteBucket(name string) error {
-	return b.local.ForceDeleteBucket(name)
+	if err := b.rejectWrite(name); err != nil {
+		return err
+	}
+	if b.canonicalBucket(name) != name {
+		return b.errAliasDelete(name)
+	}
+	if err := b.local.ForceDeleteBucket(name); err != nil {
+		return err
+	}
+	b.index.removeBucket(name)
+	b.localData.forgetBucket(name)
+	b.chunks.dropBucket(name)
+	if err := b.clones.forget(name); err != nil {
+		log.Printf("Warning: couldn't save the clones after deleting %s: %v", name, err)
+	}
+	return nil
 }
 
+// PutObject writes to the local backend. A client write turns a cached
+// object into local data, so it stops being tracked for eviction and counts
+// towards the bucket's local quota instead.
 func (b *LazyBackend) PutObject(bucketName, objectName string, meta map[string]string, input io.Reader, size int64, conditions *gofakes3.PutConditions) (gofakes3.PutObjectResult, error) {
-	return b.local.PutObject(bucketName, objectName, meta, input, size, conditions)
+	if err := b.rejectWrite(bucketName); err != nil {
+		return gofakes3.PutObjectResult{}, err
+	}
+	bucketName = b.canonicalBucket(bucketName)
+	unlock := b.locks.Lock(bucketName, objectName)
+	defer unlock()
+	done, err := b.localData.admit(bucketName, objectName, size)
+	if err != nil {
+		return gofakes3.PutObjectResult{}, err
+	}
+	b.index.remove(bucketName, objectName)
+	b.chunks.drop(bucketName, objectName)
+	created := b.listLag != nil && !b.existsLocally(bucketName, objectName)
+	result, err := b.local.PutObject(bucketName, objectName, meta, input, size, conditions)
+	done(err == nil)
+	if isNoSpace(err) {
+		return result, newFailure(ErrCacheFull, gofakes3.ErrInternal, err, "no space to store %s/%s", bucketName, objectName)
+	}
+	if err == nil {
+		b.events.record(bucketName, objectName, eventWritten, "")
+	}
+	if err == nil && created {
+		b.listLag.created(bucketName, objectName)
+	}
+	return result, err
+}
+
+// existsLocally reports whether a key is in the local backend.
+func (b *LazyBackend) existsLocally(bucketName, objectName string) bool {
+	_, err := b.local.HeadObject(bucketName, objectName)
+	return err == nil
 }
 
 func (b *LazyBackend) DeleteObject(bucketName, objectName string) (gofakes3.ObjectDeleteResult, error) {
+	if err := b.rejectWrite(bucketName); err != nil {
+		return gofakes3.ObjectDeleteResult{}, err
+	}
+	bucketName = b.canonicalBucket(bucketName)
+	unlock := b.locks.Lock(bucketName, objectName)
+	defer unlock()
+	b.index.remove(bucketName, objectName)
+	b.chunks.drop(bucketName, objectName)
+	b.listLag.forget(bucketName, objectName)
+	b.localData.forget(bucketName, objectName)
+	b.events.record(bucketName, objectName, eventDeleted, "")
 	return b.local.DeleteObject(bucketName, objectName)
 }
 
 func (b *LazyBackend) DeleteMulti(bucketName string, objects ...string) (gofakes3.MultiDeleteResult, error) {
+	if err := b.rejectWrite(bucketName); err != nil {
+		return gofakes3.MultiDeleteResult{}, err
+	}
+	bucketName = b.canonicalBucket(bucketName)
+	unlock := b.locks.LockMany(bucketName, objects...)
+	defer unlock()
+	for _, key := range objects {
+		b.index.remove(bucketName, key)
+		b.chunks.drop(bucketName, key)
+		b.listLag.forget(bucketName, key)
+		b.localData.forget(bucketName, key)
+		b.events.record(bucketName, key, eventDeleted, "")
+	}
 	return b.local.DeleteMulti(bucketName, objects...)
 }
 
+// lockPair exclusively locks two keys that may live in different buckets,
+// acquiring stripes in a fixed order so concurrent copies can't deadlock.
+func (b *LazyBackend) lockPair(bucketA, keyA, bucketB, keyB string) func() {
+	i, j := b.locks.stripeIndex(bucketA, keyA), b.locks.stripeIndex(bucketB, keyB)
+	if i == j {
+		return b.locks.Lock(bucketA, keyA)
+	}
+	if i > j {
+		bucketA, keyA, bucketB, keyB = bucketB, keyB, bucketA, keyA
+	}
+	unlockA := b.locks.Lock(bucketA, keyA)
+	unlockB := b.locks.Lock(bucketB, keyB)
+	return func() {
+		unlockB()
+		unlockA()
+	}
+}
+
 // headOutputToObject converts an S3 HeadObjectOutput to a gofakes3.Object
 func headOutputToObject(name string, obj *s3.HeadObjectOutput) *gofakes3.Object {
-	meta := make(map[string]string)
-	if obj.ContentType != nil {
-		meta["Content-Type"] = *obj.ContentType
-	}
+	meta := headMetadata(obj)
 
 	var size int64
 	if obj.ContentLength != nil {
@@ -218,13 +1207,7 @@ func headOutputToObject(name string, obj *s3.HeadObjectOutput) *gofakes3.Object
 
 // getOutputToObject converts an S3 GetObjectOutput to a gofakes3.Object
 func getOutputToObject(name string, obj *s3.GetObjectOutput) *gofakes3.Object {
-	meta := make(map[string]string)
-	if obj.ContentType != nil {
-		meta["Content-Type"] = *obj.ContentType
-	}
-	for k, v := range obj.Metadata {
-		meta[k] = v
-	}
+	meta := upstreamMetadata(obj)
 
 	var size int64
 	if obj.ContentLength != nil {
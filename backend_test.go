@@ -6,11 +6,13 @@ import (
 	"io"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
 )
@@ -676,3 +678,123 @@ func TestLazyBackend_Passthroughs(t *testing.T) {
 		}
 	})
 }
+
+func TestLazyBackend_TTLExpiry(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	put := func(content string) {
+		t.Helper()
+		if _, err := awsBackend.PutObject("test-bucket", "file.txt", nil,
+			bytes.NewReader([]byte(content)), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put object in AWS: %v", err)
+		}
+	}
+	read := func() string {
+		t.Helper()
+		obj, err := lazyBackend.GetObject("test-bucket", "file.txt", nil)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer obj.Contents.Close()
+		data, _ := io.ReadAll(obj.Contents)
+		return string(data)
+	}
+
+	now := time.Now()
+	lazyBackend.index.now = func() time.Time { return now }
+	lazyBackend.SetCacheTTL(time.Hour, map[string]time.Duration{"other-bucket": time.Minute})
+
+	put("v1")
+	if got := read(); got != "v1" {
+		t.Fatalf("first read = %q, want v1", got)
+	}
+	put("v2")
+
+	// Within the TTL the cached copy is served
+	now = now.Add(30 * time.Minute)
+	if got := read(); got != "v1" {
+		t.Errorf("read within TTL = %q, want v1", got)
+	}
+
+	// Past the TTL the object is re-fetched
+	now = now.Add(time.Hour)
+	if got := read(); got != "v2" {
+		t.Errorf("read after TTL = %q, want v2", got)
+	}
+}
+
+// conditionalUpstream adds If-None-Match support to the gofakes3 test
+// upstream, which ignores conditional GETs, and counts full downloads.
+type conditionalUpstream struct {
+	upstreamClient
+	downloads int
+}
+
+func (c *conditionalUpstream) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if params.IfNoneMatch != nil {
+		head, err := c.upstreamClient.HeadObject(ctx, &s3.HeadObjectInput{Bucket: params.Bucket, Key: params.Key})
+		if err == nil && aws.ToString(head.ETag) == *params.IfNoneMatch {
+			return nil, &smithy.GenericAPIError{Code: "NotModified", Message: "Not Modified"}
+		}
+	}
+	c.downloads++
+	return c.upstreamClient.GetObject(ctx, params, optFns...)
+}
+
+func TestLazyBackend_Revalidate(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	upstream := &conditionalUpstream{upstreamClient: lazyBackend.awsClient}
+	lazyBackend.awsClient = upstream
+	lazyBackend.SetRevalidate(true)
+
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	put := func(content string) {
+		t.Helper()
+		if _, err := awsBackend.PutObject("test-bucket", "file.txt", nil,
+			bytes.NewReader([]byte(content)), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put object in AWS: %v", err)
+		}
+	}
+	read := func() string {
+		t.Helper()
+		obj, err := lazyBackend.GetObject("test-bucket", "file.txt", nil)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer obj.Contents.Close()
+		data, _ := io.ReadAll(obj.Contents)
+		return string(data)
+	}
+
+	put("v1")
+	read()
+
+	// Unchanged upstream: the conditional GET doesn't download again
+	if got := read(); got != "v1" {
+		t.Errorf("revalidated read = %q, want v1", got)
+	}
+	if upstream.downloads != 1 {
+		t.Errorf("downloads = %d, want 1", upstream.downloads)
+	}
+
+	// Changed upstream: the next hit picks up the new version
+	put("v2")
+	if got := read(); got != "v2" {
+		t.Errorf("read after change = %q, want v2", got)
+	}
+	if upstream.downloads != 2 {
+		t.Errorf("downloads = %d, want 2", upstream.downloads)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultBackupPrefix is where backups go in the backup bucket unless
+// configured otherwise.
+const defaultBackupPrefix = "s3lazy-backup/"
+
+// defaultBackupInterval is how often local objects are backed up unless
+// configured otherwise.
+const defaultBackupInterval = 15 * time.Minute
+
+// backupClient uploads backups and checks which are already there;
+// *s3.Client implements it.
+type backupClient interface {
+	reportPutter
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// localBackup copies objects written by clients, which exist nowhere but
+// the local backend, to an upstream bucket under the prefix and the
+// instance's name, so work isn't lost with the machine.
+type localBackup struct {
+	client   backupClient
+	bucket   string
+	prefix   string
+	instance string
+
+	// backedUp maps each object backed up to the MD5 of its copy, so
+	// unchanged objects aren't uploaded again. Only the backup job uses it.
+	backedUp map[entryKey]string
+}
+
+func newLocalBackup(client backupClient, bucket, prefix, instance string) *localBackup {
+	return &localBackup{client: client, bucket: bucket, prefix: prefix, instance: instance, backedUp: make(map[entryKey]string)}
+}
+
+// key returns the key of the backup of a local object, e.g.
+// "s3lazy-backup/dev-laptop/my-bucket/notes/todo.txt".
+func (lb *localBackup) key(bucketName, objectName string) string {
+	return fmt.Sprintf("%s%s/%s/%s", lb.prefix, lb.instance, bucketName, objectName)
+}
+
+// backupResult counts what a backup run did.
+type backupResult struct {
+	Uploaded int   `json:"uploaded"`
+	Bytes    int64 `json:"bytes"`
+	Failed   int   `json:"failed"`
+}
+
+// BackUpLocalObjects uploads every object written by clients that changed
+// since its last backup. Cached objects already exist upstream and are
+// skipped. Objects deleted locally keep their last backup. Nothing is
+// uploaded in offline mode.
+func (b *LazyBackend) BackUpLocalObjects(ctx context.Context, lb *localBackup) (backupResult, error) {
+	var result backupResult
+	if b.toggles.offline.Load() {
+		return result, nil
+	}
+	buckets, err := b.local.ListBuckets()
+	if err != nil {
+		return result, err
+	}
+	seen := make(map[entryKey]string)
+	for _, bucket := range buckets {
+		if isSnapshotBucket(bucket.Name) {
+			// Snapshots are copies of buckets that are backed up themselves
+			continue
+		}
+		for key, err := range localKeys(b.local, bucket.Name, "") {
+			if err != nil {
+				return result, err
+			}
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			if _, cached := b.index.lookup(bucket.Name, key); cached {
+				continue
+			}
+			hash, size, err := b.backUpObject(ctx, lb, bucket.Name, key)
+			if err != nil {
+				log.Printf("[BACKUP ERROR] %s/%s: %v", bucket.Name, key, err)
+				result.Failed++
+				continue
+			}
+			seen[entryKey{bucket.Name, key}] = hash
+			if size >= 0 {
+				result.Uploaded++
+				result.Bytes += size
+			}
+		}
+	}
+	lb.backedUp = seen
+	if result.Uploaded > 0 || result.Failed > 0 {
+		log.Printf("[BACKUP] %d object(s), %d bytes backed up to %s/%s%s/; %d failed", result.Uploaded, result.Bytes, lb.bucket, lb.prefix, lb.instance, result.Failed)
+	}
+	return result, nil
+}
+
+// backUpObject uploads one local object unless its backup is current, and
+// returns the object's MD5 and the bytes uploaded, or -1 if it was current.
+func (b *LazyBackend) backUpObject(ctx context.Context, lb *localBackup, bucketName, objectName string) (string, int64, error) {
+	unlock := b.locks.RLock(bucketName, objectName)
+	head, err := b.local.HeadObject(bucketName, objectName)
+	unlock()
+	if err != nil {
+		return "", 0, err
+	}
+	hash := hex.EncodeToString(head.Hash)
+	key := lb.key(bucketName, objectName)
+	last, known := lb.backedUp[entryKey{bucketName, objectName}]
+	if last == hash {
+		return hash, -1, nil
+	}
+	// After a restart, the backup bucket tells what is already there
+	if !known {
+		out, err := lb.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(lb.bucket), Key: aws.String(key)})
+		if err == nil && aws.ToString(out.ETag) == `"`+hash+`"` {
+			return hash, -1, nil
+		}
+	}
+
+	// Uploads need a seekable body to be signed
+	unlock = b.locks.RLock(bucketName, objectName)
+	obj, err := b.local.GetObject(bucketName, objectName, nil)
+	if err != nil {
+		unlock()
+		return "", 0, err
+	}
+	spooled, sent, err := spoolForUpload(obj.Contents)
+	obj.Contents.Close()
+	unlock()
+	if err != nil {
+		return "", 0, err
+	}
+	defer spooled.Close()
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(lb.bucket),
+		Key:           aws.String(key),
+		Body:          spooled,
+		ContentLength: aws.Int64(sent.size),
+	}
+	if ct := obj.Metadata["Content-Type"]; ct != "" {
+		input.ContentType = aws.String(ct)
+	}
+	put, err := lb.client.PutObject(ctx, input)
+	if err != nil {
+		return "", 0, fmt.Errorf("writing backup to %s/%s: %w", lb.bucket, key, err)
+	}
+	// A backup that fails verification is uploaded again on the next run
+	if err := b.verifier.verify(ctx, lb.client, lb.bucket, key, sent, put); err != nil {
+		return "", 0, err
+	}
+	b.toggles.infof("[BACKUP] %s/%s -> %s/%s (%d bytes)", bucketName, objectName, lb.bucket, key, sent.size)
+	return hex.EncodeToString(obj.Hash), sent.size, nil
+}
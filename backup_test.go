@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_BackUpLocalObjects(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	if err := awsBackend.CreateBucket("scratch-backup"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	putString(t, awsBackend, "cached.txt", "from upstream")
+	readObject(t, lazyBackend, "cached.txt", nil)
+	putString(t, lazyBackend, "notes/todo.txt", "written locally")
+
+	client := lazyBackend.awsClient.(*s3.Client)
+	backup := newLocalBackup(client, "scratch-backup", defaultBackupPrefix, "dev-laptop")
+	result, err := lazyBackend.BackUpLocalObjects(t.Context(), backup)
+	if err != nil {
+		t.Fatalf("BackUpLocalObjects: %v", err)
+	}
+	if result != (backupResult{Uploaded: 1, Bytes: 15}) {
+		t.Errorf("result = %+v, want only the local object uploaded", result)
+	}
+	obj, err := awsBackend.GetObject("scratch-backup", "s3lazy-backup/dev-laptop/test-bucket/notes/todo.txt", nil)
+	if err != nil {
+		t.Fatalf("backup not written: %v", err)
+	}
+	got, _ := io.ReadAll(obj.Contents)
+	obj.Contents.Close()
+	if string(got) != "written locally" || obj.Metadata["Content-Type"] != "text/plain" {
+		t.Errorf("backup = %q as %s", got, obj.Metadata["Content-Type"])
+	}
+	if _, err := awsBackend.HeadObject("scratch-backup", "s3lazy-backup/dev-laptop/test-bucket/cached.txt"); err == nil {
+		t.Error("an object cached from upstream was backed up")
+	}
+
+	// Unchanged objects aren't uploaded again, changed ones are
+	if result, _ := lazyBackend.BackUpLocalObjects(t.Context(), backup); result.Uploaded != 0 {
+		t.Errorf("second run uploaded %d object(s), want 0", result.Uploaded)
+	}
+	putString(t, lazyBackend, "notes/todo.txt", "written locally, then edited")
+	if result, _ := lazyBackend.BackUpLocalObjects(t.Context(), backup); result.Uploaded != 1 {
+		t.Errorf("run after an edit uploaded %d object(s), want 1", result.Uploaded)
+	}
+
+	// After a restart, the backup bucket's ETags say what is current
+	restarted := newLocalBackup(client, "scratch-backup", defaultBackupPrefix, "dev-laptop")
+	if result, _ := lazyBackend.BackUpLocalObjects(t.Context(), restarted); result.Uploaded != 0 {
+		t.Errorf("run after a restart uploaded %d object(s), want 0", result.Uploaded)
+	}
+
+	lazyBackend.toggles.offline.Store(true)
+	putString(t, lazyBackend, "offline.txt", "written offline")
+	if result, err := lazyBackend.BackUpLocalObjects(t.Context(), backup); result.Uploaded != 0 || err != nil {
+		t.Errorf("BackUpLocalObjects offline = %+v, %v; want nothing uploaded", result, err)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// benchWorkloads are the workloads `s3lazy bench` can drive.
+var benchWorkloads = []string{"hit", "miss", "range", "write"}
+
+// errBudgetExceeded is returned by runBench when a run misses the
+// performance budget set by -max-p99 or -min-ops.
+var errBudgetExceeded = errors.New("performance budget exceeded")
+
+// benchOptions configures a benchmark run.
+type benchOptions struct {
+	endpoint    string
+	admin       string
+	bucket      string
+	workload    string
+	concurrency int
+	duration    time.Duration
+	requests    int64
+	size        byteSize
+	rangeSize   byteSize
+	objects     int
+	keysFile    string
+	maxP99      time.Duration
+	minOps      float64
+	jsonOutput  bool
+}
+
+// benchReport is the result of a benchmark run.
+type benchReport struct {
+	Workload    string  `json:"workload"`
+	Concurrency int     `json:"concurrency"`
+	Requests    int64   `json:"requests"`
+	Errors      int64   `json:"errors"`
+	Bytes       int64   `json:"bytes"`
+	Seconds     float64 `json:"seconds"`
+	OpsPerSec   float64 `json:"ops_per_sec"`
+	MBPerSec    float64 `json:"mb_per_sec"`
+	P50Millis   float64 `json:"p50_ms"`
+	P90Millis   float64 `json:"p90_ms"`
+	P99Millis   float64 `json:"p99_ms"`
+	MaxMillis   float64 `json:"max_ms"`
+
+	// Budget lists the budget checks that failed
+	Budget []string `json:"budget_failures,omitempty"`
+}
+
+// runBench implements `s3lazy bench`: it drives a workload against a running
+// instance and writes a throughput and latency report to out.
+func runBench(args []string, out io.Writer) error {
+	opts := benchOptions{size: 64 << 10, rangeSize: 4 << 10}
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&opts.endpoint, "endpoint", "http://localhost:9000", "s3lazy endpoint")
+	fs.StringVar(&opts.admin, "admin-endpoint", defaultAdminEndpoint, "s3lazy admin endpoint, for the miss workload")
+	fs.StringVar(&opts.bucket, "bucket", "s3lazy-bench", "bucket to read and write")
+	fs.StringVar(&opts.workload, "workload", "hit", "workload: "+strings.Join(benchWorkloads, ", "))
+	fs.IntVar(&opts.concurrency, "concurrency", 8, "concurrent clients")
+	fs.DurationVar(&opts.duration, "duration", 10*time.Second, "how long to run")
+	fs.Int64Var(&opts.requests, "requests", 0, "stop after this many requests (0 runs for -duration)")
+	fs.Func("size", "object size for seeded and written objects, e.g. 1MiB (default 64KiB)", byteSizeFlag(&opts.size))
+	fs.Func("range-size", "bytes per read in the range workload (default 4KiB)", byteSizeFlag(&opts.rangeSize))
+	fs.IntVar(&opts.objects, "objects", 100, "objects seeded for the hit and range workloads")
+	fs.StringVar(&opts.keysFile, "keys", "", "file of upstream keys, one per line (required for miss)")
+	fs.DurationVar(&opts.maxP99, "max-p99", 0, "fail if p99 latency exceeds this (0 disables)")
+	fs.Float64Var(&opts.minOps, "min-ops", 0, "fail if throughput is below this many requests/s (0 disables)")
+	fs.BoolVar(&opts.jsonOutput, "json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client := s3.New(s3.Options{
+		BaseEndpoint: aws.String(opts.endpoint),
+		Region:       "us-east-1",
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("s3lazy-bench", "s3lazy-bench", ""),
+	})
+	keys, err := prepareBench(ctx, client, opts)
+	if err != nil {
+		return err
+	}
+
+	report := driveBench(ctx, opts, func(ctx context.Context, rng *rand.Rand) (int64, time.Duration, error) {
+		return benchOp(ctx, client, opts, keys, rng)
+	})
+	report.Budget = opts.checkBudget(report)
+	if opts.jsonOutput {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printBenchReport(out, report)
+	}
+	if len(report.Budget) > 0 {
+		return fmt.Errorf("%w: %s", errBudgetExceeded, strings.Join(report.Budget, "; "))
+	}
+	return nil
+}
+
+func (o benchOptions) validate() error {
+	switch {
+	case !slices.Contains(benchWorkloads, o.workload):
+		return fmt.Errorf("unknown workload %q (valid options: %s)", o.workload, strings.Join(benchWorkloads, ", "))
+	case o.concurrency < 1:
+		return fmt.Errorf("-concurrency must be at least 1, got %d", o.concurrency)
+	case o.duration <= 0 && o.requests <= 0:
+		return errors.New("one of -duration or -requests must be positive")
+	case o.objects < 1:
+		return fmt.Errorf("-objects must be at least 1, got %d", o.objects)
+	case o.workload == "miss" && o.keysFile == "":
+		return errors.New("the miss workload needs -keys: upstream objects to purge and re-fetch")
+	case o.workload == "range" && (o.rangeSize <= 0 || o.rangeSize > o.size):
+		return fmt.Errorf("-range-size must be between 1 and -size (%d), got %d", o.size, o.rangeSize)
+	}
+	return nil
+}
+
+// byteSizeFlag parses a size flag such as "64KiB" into dst.
+func byteSizeFlag(dst *byteSize) func(string) error {
+	return func(v string) error {
+		n, err := parseByteSize(v)
+		if err != nil {
+			return err
+		}
+		*dst = n
+		return nil
+	}
+}
+
+// prepareBench returns the keys a workload reads. The hit and range
+// workloads write their own objects first; the miss workload reads keys that
+// exist upstream, since only uncached upstream objects can miss.
+func prepareBench(ctx context.Context, client *s3.Client, opts benchOptions) ([]string, error) {
+	switch opts.workload {
+	case "miss":
+		return readBenchKeys(opts.keysFile)
+	case "write":
+		return nil, createBenchBucket(ctx, client, opts.bucket)
+	}
+	if err := createBenchBucket(ctx, client, opts.bucket); err != nil {
+		return nil, err
+	}
+	body := make([]byte, opts.size)
+	keys := make([]string, opts.objects)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("bench/object-%05d", i)
+		if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(opts.bucket),
+			Key:    aws.String(keys[i]),
+			Body:   bytes.NewReader(body),
+		}); err != nil {
+			return nil, fmt.Errorf("seeding %s/%s: %w", opts.bucket, keys[i], err)
+		}
+	}
+	return keys, nil
+}
+
+// createBenchBucket creates the bucket unless it already exists.
+func createBenchBucket(ctx context.Context, client *s3.Client, bucket string) error {
+	if _, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err == nil {
+		return nil
+	}
+	if _, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		return fmt.Errorf("creating bucket %s: %w", bucket, err)
+	}
+	return nil
+}
+
+// readBenchKeys reads one key per line, skipping blank lines and comments.
+func readBenchKeys(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s lists no keys", path)
+	}
+	return keys, nil
+}
+
+// benchOp runs one request of the workload and returns the bytes moved and
+// how long the request took. In the miss workload the key is purged through
+// the admin API first; the purge isn't timed.
+func benchOp(ctx context.Context, client *s3.Client, opts benchOptions, keys []string, rng *rand.Rand) (int64, time.Duration, error) {
+	if opts.workload == "write" {
+		start := time.Now()
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(opts.bucket),
+			Key:    aws.String(fmt.Sprintf("bench/write-%016x", rng.Uint64())),
+			Body:   bytes.NewReader(make([]byte, opts.size)),
+		})
+		return int64(opts.size), time.Since(start), err
+	}
+
+	key := keys[rng.IntN(len(keys))]
+	if opts.workload == "miss" {
+		if err := purgeBenchKey(ctx, opts.admin, opts.bucket, key); err != nil {
+			return 0, 0, err
+		}
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(opts.bucket),
+		Key:    aws.String(key),
+	}
+	if opts.workload == "range" {
+		offset := rng.Int64N(int64(opts.size-opts.rangeSize) + 1)
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+int64(opts.rangeSize)-1))
+	}
+	start := time.Now()
+	out, err := client.GetObject(ctx, input)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	defer out.Body.Close()
+	n, err := io.Copy(io.Discard, out.Body)
+	return n, time.Since(start), err
+}
+
+// purgeBenchKey drops a key from the cache through the admin API so the
+// next read is a miss.
+func purgeBenchKey(ctx context.Context, adminEndpoint, bucket, key string) error {
+	target := strings.TrimSuffix(adminEndpoint, "/") + "/admin/cache/" + url.PathEscape(bucket) + "/" + key
+	resp, err := adminRequest(ctx, http.MethodDelete, target)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// 404 means the key wasn't cached, which is just as good
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("purging %s/%s: %s", bucket, key, resp.Status)
+	}
+	return nil
+}
+
+// driveBench runs op from opts.concurrency workers until the duration or
+// request count is reached, and summarizes the latencies op reports.
+func driveBench(ctx context.Context, opts benchOptions, op func(context.Context, *rand.Rand) (int64, time.Duration, error)) benchReport {
+	if opts.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.duration)
+		defer cancel()
+	}
+
+	var issued, errs, moved atomic.Int64
+	latencies := make([][]time.Duration, opts.concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range opts.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(start.UnixNano()), uint64(w)))
+			for ctx.Err() == nil {
+				if opts.requests > 0 && issued.Add(1) > opts.requests {
+					return
+				}
+				n, latency, err := op(ctx, rng)
+				if ctx.Err() != nil {
+					// Cut off by the deadline: not a meaningful sample
+					return
+				}
+				latencies[w] = append(latencies[w], latency)
+				moved.Add(n)
+				if err != nil {
+					errs.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	r := benchReport{
+		Workload:    opts.workload,
+		Concurrency: opts.concurrency,
+		Requests:    int64(len(all)),
+		Errors:      errs.Load(),
+		Bytes:       moved.Load(),
+		Seconds:     elapsed.Seconds(),
+		P50Millis:   percentileMillis(all, 0.50),
+		P90Millis:   percentileMillis(all, 0.90),
+		P99Millis:   percentileMillis(all, 0.99),
+		MaxMillis:   percentileMillis(all, 1),
+	}
+	if r.Seconds > 0 {
+		r.OpsPerSec = float64(r.Requests) / r.Seconds
+		r.MBPerSec = float64(r.Bytes) / (1 << 20) / r.Seconds
+	}
+	return r
+}
+
+// percentileMillis returns the p-th percentile of sorted latencies in
+// milliseconds, by the nearest-rank method.
+func percentileMillis(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// checkBudget returns the budget checks a report fails.
+func (o benchOptions) checkBudget(r benchReport) []string {
+	var failures []string
+	if r.Errors > 0 {
+		failures = append(failures, fmt.Sprintf("%d request(s) failed", r.Errors))
+	}
+	if o.maxP99 > 0 && r.P99Millis > float64(o.maxP99)/float64(time.Millisecond) {
+		failures = append(failures, fmt.Sprintf("p99 %.2fms is above %s", r.P99Millis, o.maxP99))
+	}
+	if o.minOps > 0 && r.OpsPerSec < o.minOps {
+		failures = append(failures, fmt.Sprintf("%.1f requests/s is below %.1f", r.OpsPerSec, o.minOps))
+	}
+	return failures
+}
+
+func printBenchReport(out io.Writer, r benchReport) {
+	fmt.Fprintf(out, "workload:    %s (%d clients)\n", r.Workload, r.Concurrency)
+	fmt.Fprintf(out, "requests:    %d in %.2fs (%d errors)\n", r.Requests, r.Seconds, r.Errors)
+	fmt.Fprintf(out, "throughput:  %.1f requests/s, %.2f MiB/s\n", r.OpsPerSec, r.MBPerSec)
+	fmt.Fprintf(out, "latency:     p50 %.2fms  p90 %.2fms  p99 %.2fms  max %.2fms\n", r.P50Millis, r.P90Millis, r.P99Millis, r.MaxMillis)
+	for _, f := range r.Budget {
+		fmt.Fprintf(out, "BUDGET:      %s\n", f)
+	}
+}
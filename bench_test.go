@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// startBenchTarget serves a LazyBackend the way main does and returns it
+// along with its upstream backend and URL.
+func startBenchTarget(t *testing.T) (*LazyBackend, gofakes3.Backend, string) {
+	t.Helper()
+	lazyBackend, _, awsBackend, _ := setupTestBackends(t)
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", newAdminHandler(lazyBackend, DefaultConfig()))
+	mux.Handle("/", lazyBackend.identityLogger(gofakes3.New(lazyBackend).Server()))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return lazyBackend, awsBackend, server.URL
+}
+
+func TestRunBench(t *testing.T) {
+	lazyBackend, _, endpoint := startBenchTarget(t)
+
+	for _, workload := range []string{"hit", "range", "write"} {
+		var out bytes.Buffer
+		err := runBench([]string{"-endpoint", endpoint, "-workload", workload, "-requests", "20", "-concurrency", "4",
+			"-objects", "5", "-size", "1KiB", "-range-size", "100", "-json"}, &out)
+		if err != nil {
+			t.Fatalf("%s: runBench: %v\n%s", workload, err, out.String())
+		}
+		var report benchReport
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("%s: decoding report: %v\n%s", workload, err, out.String())
+		}
+		if report.Requests != 20 || report.Errors != 0 || report.P99Millis <= 0 {
+			t.Errorf("%s: report = %+v, want 20 successful timed requests", workload, report)
+		}
+		wantBytes := int64(20 * 1024)
+		if workload == "range" {
+			wantBytes = 20 * 100
+		}
+		if report.Bytes != wantBytes {
+			t.Errorf("%s: bytes = %d, want %d", workload, report.Bytes, wantBytes)
+		}
+	}
+
+	// Requests are attributed to the bench's access key
+	if report := lazyBackend.identities.report(); len(report) == 0 || report[0].Identity != "s3lazy-bench" {
+		t.Errorf("identities = %+v, want requests from s3lazy-bench", report)
+	}
+}
+
+func TestRunBench_Miss(t *testing.T) {
+	lazyBackend, awsBackend, endpoint := startBenchTarget(t)
+	if err := awsBackend.CreateBucket("upstream"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if err := lazyBackend.CreateBucket("upstream"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if _, err := awsBackend.PutObject("upstream", key, nil, strings.NewReader("data"), 4, nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	keys := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(keys, []byte("# upstream keys\na.txt\n\nb.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runBench([]string{"-endpoint", endpoint, "-admin-endpoint", endpoint, "-bucket", "upstream", "-workload", "miss", "-keys", keys,
+		"-requests", "6", "-concurrency", "1"}, &out); err != nil {
+		t.Fatalf("runBench: %v\n%s", err, out.String())
+	}
+	if misses := lazyBackend.Stats().Misses; misses != 6 {
+		t.Errorf("misses = %d, want every request to miss", misses)
+	}
+	if !strings.Contains(out.String(), "requests:    6") {
+		t.Errorf("report = %q, want 6 requests", out.String())
+	}
+}
+
+func TestRunBench_Budget(t *testing.T) {
+	_, _, endpoint := startBenchTarget(t)
+
+	var out bytes.Buffer
+	err := runBench([]string{"-endpoint", endpoint, "-requests", "10", "-objects", "2", "-min-ops", "1e12"}, &out)
+	if !errors.Is(err, errBudgetExceeded) {
+		t.Fatalf("runBench error = %v, want the budget exceeded", err)
+	}
+	if !strings.Contains(out.String(), "BUDGET:") {
+		t.Errorf("report = %q, want the budget failure listed", out.String())
+	}
+}
+
+func TestRunBench_InvalidOptions(t *testing.T) {
+	for _, args := range [][]string{
+		{"-workload", "burst"},
+		{"-workload", "miss"},
+		{"-concurrency", "0"},
+		{"-workload", "range", "-size", "1KiB", "-range-size", "2KiB"},
+		{"-size", "lots"},
+	} {
+		if err := runBench(args, &bytes.Buffer{}); err == nil {
+			t.Errorf("runBench(%q) should fail", args)
+		}
+	}
+}
+
+func TestPercentileMillis(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]float64{0.5: 50, 0.99: 99, 1: 100} {
+		if got := percentileMillis(sorted, p); got != want {
+			t.Errorf("p%v = %v, want %v", p*100, got, want)
+		}
+	}
+	if got := percentileMillis(nil, 0.5); got != 0 {
+		t.Errorf("percentile of no samples = %v, want 0", got)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// browserPageSize caps how many folders and objects one browser page lists.
+const browserPageSize = 1000
+
+// registerBrowser adds the HTML object browser under /admin/ui/ to the admin
+// mux. It only ever reads the local backend, so browsing never triggers a
+// fetch from upstream. Its purge forms carry a token drawn at startup, which
+// pages of other sites can't read, so they can't submit the forms.
+func registerBrowser(mux *http.ServeMux, lazy *LazyBackend) {
+	formToken := rand.Text()
+	mux.HandleFunc("GET /admin/ui/{$}", func(w http.ResponseWriter, r *http.Request) {
+		buckets, err := lazy.local.ListBuckets()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+		renderBrowser(w, "buckets", map[string]any{"Buckets": buckets})
+	})
+	mux.HandleFunc("GET /admin/ui/browse", func(w http.ResponseWriter, r *http.Request) {
+		bucket, prefix := lazy.canonicalBucket(r.URL.Query().Get("bucket")), r.URL.Query().Get("prefix")
+		listing, err := lazy.browse(bucket, prefix)
+		if err != nil {
+			browserError(w, err)
+			return
+		}
+		listing.FormToken = formToken
+		renderBrowser(w, "browse", listing)
+	})
+	mux.HandleFunc("GET /admin/ui/object", func(w http.ResponseWriter, r *http.Request) {
+		bucket, key := lazy.canonicalBucket(r.URL.Query().Get("bucket")), r.URL.Query().Get("key")
+		obj, err := lazy.local.HeadObject(bucket, key)
+		if err != nil {
+			browserError(w, err)
+			return
+		}
+		entry, cached := lazy.index.lookup(bucket, key)
+		renderBrowser(w, "object", map[string]any{
+			"Bucket":  bucket,
+			"Key":     key,
+			"Parent":  parentPrefix(key),
+			"Object":  obj,
+			"ETag":    formatETag(obj.Hash),
+			"Cached":  cached,
+			"Entry":   entry,
+			"Pinned":  lazy.pins.pinned(bucket, key),
+			"Headers": sortedMetadata(obj.Metadata),
+			"Token":   formToken,
+		})
+	})
+	mux.HandleFunc("GET /admin/ui/download", func(w http.ResponseWriter, r *http.Request) {
+		bucket, key := lazy.canonicalBucket(r.URL.Query().Get("bucket")), r.URL.Query().Get("key")
+		obj, err := lazy.getLocal(bucket, key, nil)
+		if err != nil {
+			browserError(w, err)
+			return
+		}
+		defer obj.Contents.Close()
+		contentType := obj.Metadata["Content-Type"]
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}))
+		if _, err := io.Copy(w, obj.Contents); err != nil {
+			log.Printf("[ADMIN] download of %s/%s failed: %v", bucket, key, err)
+		}
+	})
+	mux.HandleFunc("POST /admin/ui/purge", func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(formToken)) != 1 {
+			http.Error(w, "missing or stale form token; reload the page", http.StatusForbidden)
+			return
+		}
+		bucket, key := lazy.canonicalBucket(r.PostFormValue("bucket")), r.PostFormValue("key")
+		if _, err := lazy.Purge(bucket, key); err != nil {
+			browserError(w, err)
+			return
+		}
+		q := url.Values{"bucket": {bucket}, "prefix": {parentPrefix(key)}}
+		http.Redirect(w, r, "/admin/ui/browse?"+q.Encode(), http.StatusSeeOther)
+	})
+}
+
+// browserListing is one level of a bucket, split into folders and objects.
+type browserListing struct {
+	Bucket    string
+	Prefix    string
+	Parent    string
+	Folders   []string
+	Objects   []browserObject
+	Truncated bool
+	FormToken string
+}
+
+// browserObject is one object row in the browser.
+type browserObject struct {
+	Key          string
+	Name         string
+	Size         int64
+	LastModified time.Time
+	Cached       bool
+	Pinned       bool
+}
+
+// browse lists the folders and objects directly under prefix in a local
+// bucket, marking which objects were fetched from upstream.
+func (b *LazyBackend) browse(bucketName, prefix string) (*browserListing, error) {
+	listing := &browserListing{Bucket: bucketName, Prefix: prefix, Parent: parentPrefix(strings.TrimSuffix(prefix, "/"))}
+	p := &gofakes3.Prefix{HasPrefix: prefix != "", Prefix: prefix, HasDelimiter: true, Delimiter: "/"}
+	var page gofakes3.ListBucketPage
+	for {
+		list, err := b.local.ListBucket(bucketName, p, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, cp := range list.CommonPrefixes {
+			listing.Folders = append(listing.Folders, cp.Prefix)
+		}
+		for _, c := range list.Contents {
+			_, cached := b.index.lookup(bucketName, c.Key)
+			listing.Objects = append(listing.Objects, browserObject{
+				Key:          c.Key,
+				Name:         strings.TrimPrefix(c.Key, prefix),
+				Size:         c.Size,
+				LastModified: c.LastModified.Time,
+				Cached:       cached,
+				Pinned:       b.pins.pinned(bucketName, c.Key),
+			})
+		}
+		if len(listing.Folders)+len(listing.Objects) >= browserPageSize {
+			listing.Truncated = list.IsTruncated || len(listing.Folders)+len(listing.Objects) > browserPageSize
+			break
+		}
+		if !list.IsTruncated || list.NextMarker == "" {
+			break
+		}
+		page = gofakes3.ListBucketPage{Marker: list.NextMarker, HasMarker: true}
+	}
+	return listing, nil
+}
+
+// parentPrefix returns the folder prefix containing key, or "" at the top.
+func parentPrefix(key string) string {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return ""
+	}
+	return key[:i+1]
+}
+
+// sortedMetadata returns object metadata as sorted name/value pairs.
+func sortedMetadata(meta map[string]string) [][2]string {
+	pairs := make([][2]string, 0, len(meta))
+	for k, v := range meta {
+		pairs = append(pairs, [2]string{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	return pairs
+}
+
+// formatETag formats an object's MD5 hash the way S3 reports ETags.
+func formatETag(hash []byte) string {
+	if len(hash) == 0 {
+		return ""
+	}
+	return `"` + hex.EncodeToString(hash) + `"`
+}
+
+// browserError renders a backend error with a matching status.
+func browserError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case isNotFound(err):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrReadOnly):
+		status = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func renderBrowser(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := browserTemplates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("[ADMIN] failed to render %s page: %v", name, err)
+	}
+}
+
+var browserTemplates = template.Must(template.New("browser").Funcs(template.FuncMap{
+	"trim": strings.TrimPrefix,
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>s3lazy</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; border-bottom: 1px solid #ddd; }
+.tag { font-size: 0.8em; padding: 0 0.4em; border-radius: 3px; background: #eee; }
+form { display: inline; }
+</style></head><body>
+<h1><a href="/admin/ui/">s3lazy</a></h1>
+{{end}}
+
+{{define "footer"}}</body></html>{{end}}
+
+{{define "buckets"}}{{template "header"}}
+<h2>Buckets</h2>
+<ul>
+{{range .Buckets}}<li><a href="/admin/ui/browse?bucket={{.Name}}">{{.Name}}</a></li>
+{{else}}<li>No buckets</li>
+{{end}}</ul>
+{{template "footer"}}{{end}}
+
+{{define "browse"}}{{template "header"}}
+<h2>{{.Bucket}}/{{.Prefix}}</h2>
+{{if .Prefix}}<p><a href="/admin/ui/browse?bucket={{.Bucket}}&prefix={{.Parent}}">&larr; up</a></p>{{end}}
+<table>
+<tr><th>Name</th><th>Size</th><th>Last modified</th><th></th><th></th></tr>
+{{range .Folders}}<tr><td><a href="/admin/ui/browse?bucket={{$.Bucket}}&prefix={{.}}">{{trim . $.Prefix}}</a></td><td></td><td></td><td></td><td></td></tr>
+{{end}}
+{{range .Objects}}<tr>
+<td><a href="/admin/ui/object?bucket={{$.Bucket}}&key={{.Key}}">{{.Name}}</a>
+{{if .Cached}}<span class="tag">cached</span>{{else}}<span class="tag">local</span>{{end}}
+{{if .Pinned}}<span class="tag">pinned</span>{{end}}</td>
+<td>{{.Size}}</td>
+<td>{{.LastModified.Format "2006-01-02 15:04:05"}}</td>
+<td><a href="/admin/ui/download?bucket={{$.Bucket}}&key={{.Key}}">download</a></td>
+<td>{{if .Cached}}<form method="post" action="/admin/ui/purge"><input type="hidden" name="token" value="{{$.FormToken}}"><input type="hidden" name="bucket" value="{{$.Bucket}}"><input type="hidden" name="key" value="{{.Key}}"><button>purge</button></form>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{if .Truncated}}<p>Only the first entries are shown.</p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "object"}}{{template "header"}}
+<h2>{{.Bucket}}/{{.Key}}</h2>
+<p><a href="/admin/ui/browse?bucket={{.Bucket}}&prefix={{.Parent}}">&larr; back</a></p>
+<table>
+<tr><th>Size</th><td>{{.Object.Size}} bytes</td></tr>
+<tr><th>ETag</th><td>{{.ETag}}</td></tr>
+<tr><th>Origin</th><td>{{if .Cached}}fetched from upstream{{else}}written locally{{end}}</td></tr>
+{{if .Cached}}<tr><th>Cached at</th><td>{{.Entry.CachedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Last access</th><td>{{.Entry.LastAccess.Format "2006-01-02 15:04:05"}}</td></tr>
+{{if .Entry.ETag}}<tr><th>Upstream ETag</th><td>{{.Entry.ETag}}</td></tr>{{end}}{{end}}
+<tr><th>Pinned</th><td>{{.Pinned}}</td></tr>
+{{range .Headers}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}
+</table>
+<p><a href="/admin/ui/download?bucket={{.Bucket}}&key={{.Key}}">download</a>
+{{if .Cached}}<form method="post" action="/admin/ui/purge"><input type="hidden" name="token" value="{{.Token}}"><input type="hidden" name="bucket" value="{{.Bucket}}"><input type="hidden" name="key" value="{{.Key}}"><button>purge</button></form>{{end}}</p>
+{{template "footer"}}{{end}}
+`))
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAdminBrowser(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create local bucket: %v", err)
+	}
+	for _, key := range []string{"data/a.csv", "data/nested/b.csv", "top.txt"} {
+		if _, err := localBackend.PutObject("test-bucket", key, map[string]string{"Content-Type": "text/csv"},
+			bytes.NewReader([]byte(key)), int64(len(key)), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/admin/ui/")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/admin/ui/browse?bucket=test-bucket") {
+		t.Errorf("bucket list = %d %s, want a link to test-bucket", rec.Code, rec.Body)
+	}
+
+	rec = do(http.MethodGet, "/admin/ui/browse?bucket=test-bucket&prefix=data/")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("browse status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, want := range []string{">nested/<", ">a.csv<", "prefix=data%2fnested%2f"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("browse page missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "top.txt") {
+		t.Error("browse page should only list keys under the prefix")
+	}
+
+	rec = do(http.MethodGet, "/admin/ui/object?bucket=test-bucket&key=data/a.csv")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "written locally") {
+		t.Errorf("object page = %d, want metadata for a local object:\n%s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodGet, "/admin/ui/object?bucket=test-bucket&key=missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing object status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = do(http.MethodGet, "/admin/ui/download?bucket=test-bucket&key=data/a.csv")
+	if rec.Body.String() != "data/a.csv" || rec.Header().Get("Content-Disposition") != "attachment; filename=a.csv" {
+		t.Errorf("download = %q (%s), want the object as an attachment", rec.Body, rec.Header().Get("Content-Disposition"))
+	}
+
+	// Aliases browse the bucket they name
+	lazyBackend.SetBucketAliases(map[string]string{"test-alias": "test-bucket"})
+	if rec := do(http.MethodGet, "/admin/ui/object?bucket=test-alias&key=data/a.csv"); rec.Code != http.StatusOK {
+		t.Errorf("object page through an alias = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// Only objects cached from upstream are purged, by forms carrying the
+	// page's token
+	lazyBackend.index.add("test-bucket", "data/a.csv", 10, "")
+	page := do(http.MethodGet, "/admin/ui/browse?bucket=test-bucket&prefix=data/").Body.String()
+	_, rest, _ := strings.Cut(page, `name="token" value="`)
+	token, _, _ := strings.Cut(rest, `"`)
+	if token == "" {
+		t.Fatalf("browse page has no purge form token:\n%s", page)
+	}
+	purge := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/ui/purge", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := purge(url.Values{"bucket": {"test-bucket"}, "key": {"data/a.csv"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("purge without a token = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec = purge(url.Values{"token": {token}, "bucket": {"test-bucket"}, "key": {"data/a.csv"}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/ui/browse?bucket=test-bucket&prefix=data%2F" {
+		t.Errorf("purge = %d to %q, want a redirect back to the folder", rec.Code, rec.Header().Get("Location"))
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "data/a.csv"); err == nil {
+		t.Error("data/a.csv should have been purged")
+	}
+}
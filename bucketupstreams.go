@@ -0,0 +1,122 @@
+package main
+
+import (
+	"cmp"
+	"strings"
+)
+
+// BucketUpstream is where one local bucket is fetched from when that isn't
+// the default upstream, such as a bucket in another AWS account. Fields
+// left empty are taken from the global settings.
+type BucketUpstream struct {
+	// Upstream bucket name, as in bucket_mappings
+	Bucket string `yaml:"bucket,omitempty"`
+
+	// Region of the bucket, as in aws_region
+	Region string `yaml:"region,omitempty"`
+
+	// S3-compatible endpoint, as in upstream_endpoint
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Path-style addressing, as in upstream_path_style
+	PathStyle *bool `yaml:"path_style,omitempty"`
+
+	// Named profile of the shared AWS config and credentials files
+	Profile string `yaml:"profile,omitempty"`
+
+	// Static credentials, as in upstream_access_key and upstream_secret_key
+	AccessKey string `yaml:"access_key,omitempty"`
+	SecretKey string `yaml:"secret_key,omitempty"`
+}
+
+// describe summarizes where a bucket upstream points, for the startup log.
+func (u BucketUpstream) describe() string {
+	var parts []string
+	if u.Endpoint != "" {
+		parts = append(parts, "endpoint "+u.Endpoint)
+	}
+	if u.Region != "" {
+		parts = append(parts, "region "+u.Region)
+	}
+	switch {
+	case u.AccessKey != "":
+		parts = append(parts, "static credentials")
+	case u.Profile != "":
+		parts = append(parts, "profile "+u.Profile)
+	}
+	return cmp.Or(strings.Join(parts, ", "), "default upstream")
+}
+
+// applyBucketUpstreams folds the upstream bucket names of bucket_upstreams
+// entries into bucket_mappings. An entry may not contradict a mapping made
+// for the same bucket elsewhere.
+func (c *Config) applyBucketUpstreams(errs *configErrors) {
+	for local, u := range c.BucketUpstreams {
+		if u.Bucket == "" {
+			continue
+		}
+		if mapped, ok := c.BucketMappings[local]; ok && mapped != u.Bucket {
+			errs.addf("bucket_upstreams: %s: bucket %s conflicts with bucket_mappings %s", local, u.Bucket, mapped)
+		}
+		c.BucketMappings[local] = u.Bucket
+	}
+}
+
+// validateBucketUpstreams checks each bucket_upstreams entry.
+func (c *Config) validateBucketUpstreams(errs *configErrors) {
+	for local, u := range c.BucketUpstreams {
+		if u.Endpoint != "" {
+			if err := validateEndpoint(u.Endpoint); err != nil {
+				errs.addf("bucket_upstreams: %s: endpoint: %v", local, err)
+			}
+		}
+		if (u.AccessKey == "") != (u.SecretKey == "") {
+			errs.addf("bucket_upstreams: %s: set both access_key and secret_key or neither", local)
+		}
+		if u.AccessKey != "" && u.Profile != "" {
+			errs.addf("bucket_upstreams: %s: set either profile or access_key, not both", local)
+		}
+		if c.URLSources[local] != "" {
+			errs.addf("bucket_upstreams: %s is a URL source", local)
+		}
+	}
+	if len(c.BucketUpstreams) > 0 && c.MockUpstream != "" {
+		errs.addf("bucket_upstreams: not supported with mock_upstream")
+	}
+}
+
+// bucketUpstreamIdentity names the upstream a bucket with its own upstream
+// is fetched from, as upstreamIdentity does for the default one. A profile
+// stands for the account it signs in to.
+func bucketUpstreamIdentity(cfg *Config, u BucketUpstream) string {
+	c := *cfg
+	if u.Endpoint != "" {
+		c.UpstreamEndpoint, c.UpstreamEndpoints = u.Endpoint, nil
+	}
+	switch {
+	case u.AccessKey != "":
+		c.UpstreamAccessKey = u.AccessKey
+	case u.Profile != "":
+		c.UpstreamAccessKey, c.AWSProfile = "", u.Profile
+	}
+	return upstreamIdentity(&c)
+}
+
+// bucketUpstream is the client and identity of a local bucket's own
+// upstream.
+type bucketUpstream struct {
+	client   upstreamLister
+	identity string
+}
+
+// SetBucketUpstream fetches a local bucket from its own upstream client
+// instead of the default one. identity names that upstream for cache
+// namespaces.
+func (b *LazyBackend) SetBucketUpstream(localBucket string, client upstreamLister, identity string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bucketUpstreams == nil {
+		b.bucketUpstreams = make(map[string]bucketUpstream)
+	}
+	b.bucketUpstreams[localBucket] = bucketUpstream{client: client, identity: identity}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func TestLazyBackend_BucketUpstream(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putString(t, awsBackend, "file.txt", "default account")
+
+	// Another account's bucket, reached with its own client
+	other := s3mem.New()
+	if err := other.CreateBucket("billing-prod"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.PutObject("billing-prod", "file.txt", nil, strings.NewReader("billing account"), 15, nil); err != nil {
+		t.Fatal(err)
+	}
+	otherServer := httptest.NewServer(gofakes3.New(other).Server())
+	defer otherServer.Close()
+	if err := localBackend.CreateBucket("billing"); err != nil {
+		t.Fatal(err)
+	}
+	lazyBackend.SetUpstreamIdentity("aws:default")
+	lazyBackend.SetBucketMappings(map[string]string{"billing": "billing-prod"})
+	lazyBackend.SetBucketUpstream("billing", newEndpointClient(t, otherServer.URL), "aws:billing")
+
+	if _, data := readObject(t, lazyBackend, "file.txt", nil); data != "default account" {
+		t.Errorf("test-bucket data = %q", data)
+	}
+	obj, err := lazyBackend.GetObject("billing", "file.txt", nil)
+	if err != nil {
+		t.Fatalf("GetObject(billing): %v", err)
+	}
+	obj.Contents.Close()
+	if obj.Size != 15 {
+		t.Errorf("billing object size = %d, want the other account's object", obj.Size)
+	}
+	if ns := lazyBackend.namespace("billing"); ns != "aws:billing/billing-prod" {
+		t.Errorf("namespace = %q, want the bucket's own identity", ns)
+	}
+	if ns := lazyBackend.namespace("test-bucket"); ns != "aws:default/test-bucket" {
+		t.Errorf("namespace = %q, want the default identity", ns)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bypassHeader makes a GET or HEAD re-fetch the object from upstream,
+// replacing the cached copy, e.g. right after it was changed upstream.
+const bypassHeader = "X-S3lazy-Bypass"
+
+// bypassGuard refreshes the cached copy of an object before the request is
+// served when the client asks to skip the cache: "Cache-Control: no-cache"
+// revalidates it against upstream, and "X-S3lazy-Bypass: true" re-fetches
+// it unconditionally.
+func (b *LazyBackend) bypassGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if bypass, unconditional := requestsBypass(r.Header); bypass {
+				if bucket, key, ok := objectPath(r.URL.Path); ok {
+					b.bypassCache(r.Context(), bucket, key, unconditional)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestsBypass reports whether request headers ask to skip the cache, and
+// whether the object should be re-fetched even if it didn't change.
+func requestsBypass(h http.Header) (bypass, unconditional bool) {
+	if v := h.Get(bypassHeader); v != "" {
+		if on, err := strconv.ParseBool(v); err == nil && on {
+			return true, true
+		}
+	}
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// objectPath splits a path-style request path into bucket and key.
+func objectPath(p string) (bucket, key string, ok bool) {
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	return bucket, key, bucket != "" && key != ""
+}
+
+// bypassCache refreshes a cached object at a client's request. Objects that
+// aren't cached are fetched by the request itself, and objects written by
+// clients are never replaced by the upstream copy. An object that no longer
+// exists upstream is purged so the request sees it's gone.
+func (b *LazyBackend) bypassCache(ctx context.Context, bucketName, objectName string, unconditional bool) {
+	bucketName = b.canonicalBucket(bucketName)
+	if _, cached := b.index.lookup(bucketName, objectName); !cached {
+		return
+	}
+	if b.toggles.offline.Load() {
+		log.Printf("[CACHE BYPASS] %s/%s - offline, serving cached copy", bucketName, objectName)
+		return
+	}
+	log.Printf("[CACHE BYPASS] %s/%s - requested by client", bucketName, objectName)
+	_, err := b.refresh(ctx, bucketName, objectName, unconditional)
+	switch {
+	case errors.Is(err, errNotCacheable):
+		// refresh already dropped the cached copy; the request streams it
+	case isNotFound(err):
+		if _, err := b.purge(bucketName, objectName, false); err != nil {
+			log.Printf("[BYPASS ERROR] %s/%s: %v", bucketName, objectName, err)
+		}
+	case err != nil:
+		// Keep serving the cached copy while upstream is unavailable
+		log.Printf("[BYPASS ERROR] %s/%s: %v", bucketName, objectName, err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestRequestsBypass(t *testing.T) {
+	tests := []struct {
+		header, value                 string
+		wantBypass, wantUnconditional bool
+	}{
+		{"X-S3lazy-Bypass", "true", true, true},
+		{"X-S3lazy-Bypass", "0", false, false},
+		{"Cache-Control", "max-age=0, No-Cache", true, false},
+		{"Cache-Control", "no-store", false, false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set(tt.header, tt.value)
+		bypass, unconditional := requestsBypass(h)
+		if bypass != tt.wantBypass || unconditional != tt.wantUnconditional {
+			t.Errorf("%s: %s = (%v, %v), want (%v, %v)", tt.header, tt.value, bypass, unconditional, tt.wantBypass, tt.wantUnconditional)
+		}
+	}
+}
+
+func TestLazyBackend_BypassGuard(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	handler := lazyBackend.bypassGuard(gofakes3.New(lazyBackend).Server())
+
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	put := func(backend gofakes3.Backend, key, content string) {
+		t.Helper()
+		if _, err := backend.PutObject("test-bucket", key, nil, strings.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	get := func(key string, header ...string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket/"+key, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	put(awsBackend, "data.json", "v1")
+	if _, body := get("data.json"); body != "v1" {
+		t.Fatalf("first GET = %q, want v1", body)
+	}
+	put(awsBackend, "data.json", "v2")
+	if _, body := get("data.json"); body != "v1" {
+		t.Errorf("plain GET = %q, want the cached v1", body)
+	}
+	if _, body := get("data.json", "Cache-Control", "no-cache"); body != "v2" {
+		t.Errorf("no-cache GET = %q, want v2", body)
+	}
+	put(awsBackend, "data.json", "v3")
+	if _, body := get("data.json", bypassHeader, "true"); body != "v3" {
+		t.Errorf("bypass GET = %q, want v3", body)
+	}
+
+	// Objects written by clients are local data and never replaced
+	put(localBackend, "local.txt", "mine")
+	put(awsBackend, "local.txt", "theirs")
+	if _, body := get("local.txt", bypassHeader, "true"); body != "mine" {
+		t.Errorf("bypass GET of local object = %q, want mine", body)
+	}
+
+	// An object deleted upstream is gone after a bypass
+	if _, err := awsBackend.DeleteObject("test-bucket", "data.json"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if code, _ := get("data.json", bypassHeader, "true"); code != http.StatusNotFound {
+		t.Errorf("bypass GET of deleted object status = %d, want %d", code, http.StatusNotFound)
+	}
+}
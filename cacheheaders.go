@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers added to HEAD responses describing the cache state of the object.
+const (
+	cachedAtHeader  = "X-S3lazy-Cached-At"
+	expiresAtHeader = "X-S3lazy-Expires-At"
+	pinnedHeader    = "X-S3lazy-Pinned"
+	sourceHeader    = "X-S3lazy-Source"
+)
+
+// cacheStateHeaders describes the cache state of an object: where a read
+// would be served from ("cache" for a copy fetched from upstream, "local"
+// for an object written by a client, "upstream" for one that isn't cached),
+// when the cached copy was fetched and expires, and whether it is pinned.
+func (b *LazyBackend) cacheStateHeaders(bucketName, objectName string) http.Header {
+	bucketName = b.canonicalBucket(bucketName)
+	h := make(http.Header)
+	entryBucket := bucketName
+	entry, cached := b.index.lookup(bucketName, objectName)
+	if !cached {
+		if twin, ok := b.twinOf(bucketName, objectName); ok {
+			entryBucket = twin
+			entry, cached = b.index.lookup(twin, objectName)
+		}
+	}
+	pinned := b.pins.pinned(entryBucket, objectName)
+	h.Set(pinnedHeader, strconv.FormatBool(pinned))
+	switch {
+	case cached:
+		h.Set(sourceHeader, "cache")
+		h.Set(cachedAtHeader, entry.CachedAt.UTC().Format(time.RFC3339))
+		if ttl := b.entryTTL(entry); ttl > 0 && !pinned {
+			h.Set(expiresAtHeader, entry.CachedAt.Add(ttl).UTC().Format(time.RFC3339))
+		}
+	case b.existsLocally(bucketName, objectName):
+		h.Set(sourceHeader, "local")
+	default:
+		h.Set(sourceHeader, "upstream")
+	}
+	return h
+}
+
+// cacheHeaders adds the cache state of the object to successful HEAD
+// responses, so scripts can check it per key without the admin API.
+func (b *LazyBackend) cacheHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, key, ok := objectPath(r.URL.Path)
+		if r.Method != http.MethodHead || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hw := &headerWriter{ResponseWriter: w, extra: func() http.Header { return b.cacheStateHeaders(bucket, key) }}
+		next.ServeHTTP(hw, r)
+		// A handler that writes nothing gets an implicit 200
+		hw.addExtra(http.StatusOK)
+	})
+}
+
+// headerWriter adds extra headers to a response once its status is known to
+// be a success. They are computed then, after the request was handled.
+type headerWriter struct {
+	http.ResponseWriter
+	extra func() http.Header
+	done  bool
+}
+
+func (w *headerWriter) addExtra(status int) {
+	if w.done {
+		return
+	}
+	w.done = true
+	if status < 300 {
+		for k, v := range w.extra() {
+			w.Header()[k] = v
+		}
+	}
+}
+
+func (w *headerWriter) WriteHeader(status int) {
+	w.addExtra(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	w.addExtra(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_CacheHeaders(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	handler := lazyBackend.cacheHeaders(gofakes3.New(lazyBackend).Server())
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	lazyBackend.SetCacheTTL(time.Hour, nil)
+	if err := lazyBackend.SetPins([]string{"test-bucket/pinned.txt"}); err != nil {
+		t.Fatalf("SetPins: %v", err)
+	}
+	for _, key := range []string{"cached.txt", "pinned.txt", "remote.txt"} {
+		putString(t, awsBackend, key, "upstream")
+	}
+	readObject(t, lazyBackend, "cached.txt", nil)
+	readObject(t, lazyBackend, "pinned.txt", nil)
+	putString(t, lazyBackend, "written.txt", "client data")
+
+	head := func(key string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/test-bucket/"+key, nil))
+		return rec
+	}
+
+	rec := head("cached.txt")
+	cachedAt, err := time.Parse(time.RFC3339, rec.Header().Get(cachedAtHeader))
+	if err != nil || rec.Header().Get(sourceHeader) != "cache" || rec.Header().Get(pinnedHeader) != "false" {
+		t.Errorf("cached object headers = %v", rec.Header())
+	}
+	if expires, err := time.Parse(time.RFC3339, rec.Header().Get(expiresAtHeader)); err != nil || !expires.Equal(cachedAt.Add(time.Hour)) {
+		t.Errorf("%s = %q, want an hour after %s", expiresAtHeader, rec.Header().Get(expiresAtHeader), cachedAt)
+	}
+
+	rec = head("pinned.txt")
+	if rec.Header().Get(pinnedHeader) != "true" || rec.Header().Get(expiresAtHeader) != "" {
+		t.Errorf("pinned object headers = %v, want pinned without an expiry", rec.Header())
+	}
+	if rec := head("written.txt"); rec.Header().Get(sourceHeader) != "local" || rec.Header().Get(cachedAtHeader) != "" {
+		t.Errorf("client-written object headers = %v, want source local", rec.Header())
+	}
+	if rec := head("remote.txt"); rec.Code != http.StatusOK || rec.Header().Get(sourceHeader) != "upstream" {
+		t.Errorf("uncached object: status %d, headers %v; want source upstream", rec.Code, rec.Header())
+	}
+	if rec := head("missing.txt"); rec.Code != http.StatusNotFound || rec.Header().Get(sourceHeader) != "" {
+		t.Errorf("missing object: status %d, headers %v; want a 404 without cache headers", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// chunkedObject records which fixed-size chunks of an upstream object are
+// cached, pinned to the ETag they were fetched at.
+type chunkedObject struct {
+	bucket, key string
+	size        int64
+	etag        string
+	meta        map[string]string
+	ttl         time.Duration
+	cachedAt    time.Time
+
+	chunks map[int64]int64  // chunk index -> length
+	data   map[int64][]byte // chunk contents when kept in memory
+	bytes  int64
+	elem   *list.Element
+}
+
+// chunkStore caches parts of large objects for range reads, so a client
+// reading a file's footer doesn't have to download the whole file first.
+// Chunks are kept in memory, or as files under dir if set, and whole objects'
+// chunks are evicted least recently used first once maxBytes is exceeded.
+type chunkStore struct {
+	chunkSize int64
+	maxBytes  int64 // 0 is unlimited
+	dir       string
+	now       func() time.Time
+
+	mu      sync.Mutex
+	objects map[entryKey]*chunkedObject
+	lru     *list.List // front is most recently used
+	total   int64
+}
+
+// newChunkStore creates a store of chunkSize-byte chunks. Chunks left in dir
+// by a previous run are discarded, since nothing records their ETags.
+func newChunkStore(chunkSize, maxBytes int64, dir string) (*chunkStore, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", chunkSize)
+	}
+	if dir != "" {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &chunkStore{
+		chunkSize: chunkSize,
+		maxBytes:  maxBytes,
+		dir:       dir,
+		now:       time.Now,
+		objects:   make(map[entryKey]*chunkedObject),
+		lru:       list.New(),
+	}, nil
+}
+
+// lookup returns the cached description of an object, marking it as
+// recently used.
+func (s *chunkStore) lookup(bucket, key string) (*chunkedObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[entryKey{bucket, key}]
+	if ok {
+		s.lru.MoveToFront(o.elem)
+	}
+	return o, ok
+}
+
+// start begins caching chunks of an object, replacing anything cached for
+// the key before.
+func (s *chunkStore) start(bucket, key string, size int64, etag string, meta map[string]string, ttl time.Duration) *chunkedObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropLocked(entryKey{bucket, key})
+	o := &chunkedObject{
+		bucket:   bucket,
+		key:      key,
+		size:     size,
+		etag:     etag,
+		meta:     meta,
+		ttl:      ttl,
+		cachedAt: s.now(),
+		chunks:   make(map[int64]int64),
+		data:     make(map[int64][]byte),
+	}
+	o.elem = s.lru.PushFront(o)
+	s.objects[entryKey{bucket, key}] = o
+	return o
+}
+
+// has reports whether chunk i of o is cached.
+func (s *chunkStore) has(o *chunkedObject, i int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := o.chunks[i]
+	return ok
+}
+
+// put caches chunk i of o and evicts other objects' chunks if that takes
+// the store over budget.
+func (s *chunkStore) put(o *chunkedObject, i int64, data []byte) error {
+	if s.dir != "" {
+		if err := os.MkdirAll(s.objectDir(o), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(s.chunkPath(o, i), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects[entryKey{o.bucket, o.key}] != o {
+		// Dropped while the chunk was being fetched
+		if s.dir != "" {
+			os.RemoveAll(s.objectDir(o))
+		}
+		return nil
+	}
+	if s.dir == "" {
+		o.data[i] = data
+	}
+	if _, ok := o.chunks[i]; !ok {
+		o.chunks[i] = int64(len(data))
+		o.bytes += int64(len(data))
+		s.total += int64(len(data))
+	}
+	for s.maxBytes > 0 && s.total > s.maxBytes {
+		victim := s.lru.Back().Value.(*chunkedObject)
+		if victim == o {
+			break
+		}
+		log.Printf("[CHUNK EVICT] %s/%s (%d bytes)", victim.bucket, victim.key, victim.bytes)
+		s.dropLocked(entryKey{victim.bucket, victim.key})
+	}
+	return nil
+}
+
+// open returns n bytes of chunk i of o starting at off.
+func (s *chunkStore) open(o *chunkedObject, i, off, n int64) (io.ReadCloser, error) {
+	if s.dir == "" {
+		s.mu.Lock()
+		data, ok := o.data[i]
+		s.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("chunk %d of %s/%s is not cached", i, o.bucket, o.key)
+		}
+		return io.NopCloser(bytes.NewReader(data[off : off+n])), nil
+	}
+	f, err := os.Open(s.chunkPath(o, i))
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, off, n), f}, nil
+}
+
+// drop forgets every chunk of a key and reports whether there were any.
+func (s *chunkStore) drop(bucket, key string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropLocked(entryKey{bucket, key})
+}
+
+func (s *chunkStore) dropLocked(k entryKey) bool {
+	o, ok := s.objects[k]
+	if !ok {
+		return false
+	}
+	delete(s.objects, k)
+	s.lru.Remove(o.elem)
+	s.total -= o.bytes
+	if s.dir != "" {
+		if err := os.RemoveAll(s.objectDir(o)); err != nil {
+			log.Printf("Warning: couldn't remove chunks of %s/%s: %v", o.bucket, o.key, err)
+		}
+	}
+	return len(o.chunks) > 0
+}
+
+// dropBucket forgets the chunks of every key in a bucket.
+func (s *chunkStore) dropBucket(bucket string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.objects {
+		if k.bucket == bucket {
+			s.dropLocked(k)
+		}
+	}
+}
+
+// usage returns the number of objects with cached chunks and their total
+// size.
+func (s *chunkStore) usage() (objects int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects), s.total
+}
+
+// objectDir is where an object's chunks are written. Keys are hashed, and
+// the ETag included so a changed object never shares files with its
+// predecessor.
+func (s *chunkStore) objectDir(o *chunkedObject) string {
+	sum := sha256.Sum256([]byte(o.bucket + "/" + o.key + "\x00" + o.etag))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *chunkStore) chunkPath(o *chunkedObject, i int64) string {
+	return filepath.Join(s.objectDir(o), strconv.FormatInt(i, 10))
+}
+
+// errChunkedChanged is returned by fetchChunks when the object changed
+// upstream since its first chunk was cached.
+var errChunkedChanged = errors.New("object changed upstream")
+
+// chunkedRange serves a range read of an uncached object from cached
+// chunks, fetching only the chunks the range needs that aren't cached yet.
+// A nil object means the read isn't suited to chunking and should be served
+// as an ordinary miss: the range covers the whole object, the object fits in
+// one chunk, or it may not be cached.
+func (b *LazyBackend) chunkedRange(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, string, error) {
+	unlock := b.locks.Lock(bucketName, objectName)
+	defer unlock()
+
+	// Another request may have cached the whole object while we waited
+	if _, err := b.local.HeadObject(bucketName, objectName); err == nil {
+		return nil, "", nil
+	}
+
+	upstream, awsBucket := b.upstreamFor(bucketName)
+	o, ok := b.chunks.lookup(bucketName, objectName)
+	if ok && b.chunksExpired(o) {
+		b.chunks.drop(bucketName, objectName)
+		ok = false
+	}
+	if !ok {
+		if b.toggles.offline.Load() {
+			return nil, "", errOffline(objectName)
+		}
+		headCtx, cancel := b.operation(ctx, opHead)
+		head, err := upstream.HeadObject(headCtx, &s3.HeadObjectInput{
+			Bucket: aws.String(awsBucket),
+			Key:    aws.String(objectName),
+		})
+		cancel()
+		if err != nil {
+			return nil, "", b.quirks.translate(err, bucketName, objectName)
+		}
+		size := aws.ToInt64(head.ContentLength)
+		if size <= b.chunks.chunkSize || aws.ToString(head.ETag) == "" {
+			return nil, "", nil
+		}
+		rule, _ := b.tagRuleFor(ctx, upstream, awsBucket, objectName)
+		if rule.NoCache {
+			return nil, "", nil
+		}
+		o = b.chunks.start(bucketName, objectName, size, aws.ToString(head.ETag), headMetadata(head), rule.TTL)
+	}
+
+	rng, err := rangeRequest.Range(o.size)
+	if err != nil {
+		return nil, "", err
+	}
+	if rng == nil {
+		return nil, "", nil
+	}
+	first, last := rng.Start/b.chunks.chunkSize, (rng.Start+rng.Length-1)/b.chunks.chunkSize
+
+	fetched, err := b.fetchChunks(ctx, o, upstream, awsBucket, first, last)
+	if errors.Is(err, errChunkedChanged) {
+		log.Printf("[CHUNK] %s/%s changed upstream - dropping cached chunks", bucketName, objectName)
+		b.chunks.drop(bucketName, objectName)
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	body, err := b.openChunks(o, rng, first, last)
+	if err != nil {
+		// A concurrent fill evicted a chunk we just cached
+		log.Printf("[CHUNK] %s/%s: %v - serving as a miss", bucketName, objectName, err)
+		return nil, "", nil
+	}
+	status := cacheHit
+	if fetched > 0 {
+		status = cacheMiss
+		b.toggles.infof("[CHUNK MISS] %s/%s bytes %d-%d (%d bytes fetched)", bucketName, objectName, rng.Start, rng.Start+rng.Length-1, fetched)
+		b.stats.recordMiss(fetched)
+		b.prefixStats.recordMiss(bucketName, objectName, fetched)
+	} else {
+		b.toggles.infof("[CHUNK HIT] %s/%s bytes %d-%d", bucketName, objectName, rng.Start, rng.Start+rng.Length-1)
+		b.stats.recordHit(rng.Length)
+		b.prefixStats.recordHit(bucketName, objectName)
+	}
+	return &gofakes3.Object{
+		Name:     objectName,
+		Metadata: o.meta,
+		Size:     o.size,
+		Hash:     parseETagToHash(&o.etag),
+		Range:    rng,
+		Contents: body,
+	}, status, nil
+}
+
+// chunksExpired reports whether an object's chunks have outlived its TTL.
+func (b *LazyBackend) chunksExpired(o *chunkedObject) bool {
+	ttl := o.ttl
+	if ttl == 0 {
+		ttl = b.ttlFor(o.bucket)
+	}
+	return ttl > 0 && b.chunks.now().Sub(o.cachedAt) > ttl
+}
+
+// fetchChunks caches the chunks first to last of o that aren't cached yet,
+// fetching each run of missing chunks with a single ranged GET pinned to the
+// object's ETag. It returns the number of bytes fetched. The caller must hold
+// the key's exclusive lock.
+func (b *LazyBackend) fetchChunks(ctx context.Context, o *chunkedObject, upstream upstreamClient, awsBucket string, first, last int64) (int64, error) {
+	var fetched int64
+	for i := first; i <= last; i++ {
+		if b.chunks.has(o, i) {
+			continue
+		}
+		if b.toggles.offline.Load() {
+			return fetched, errOffline(o.key)
+		}
+		end := i
+		for end < last && !b.chunks.has(o, end+1) {
+			end++
+		}
+		n, err := b.fetchChunkRun(ctx, o, upstream, awsBucket, i, end)
+		fetched += n
+		if err != nil {
+			return fetched, err
+		}
+		i = end
+	}
+	return fetched, nil
+}
+
+// fetchChunkRun fetches the consecutive chunks first to last of o.
+func (b *LazyBackend) fetchChunkRun(ctx context.Context, o *chunkedObject, upstream upstreamClient, awsBucket string, first, last int64) (int64, error) {
+	release, err := b.shaper.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	ctx, cancel := b.operation(ctx, opGet)
+	defer cancel()
+
+	cs := b.chunks.chunkSize
+	start := first * cs
+	end := min((last+1)*cs, o.size)
+	body, err := b.fetchRange(ctx, upstream, awsBucket, o.key, &o.etag, &gofakes3.ObjectRange{Start: start, Length: end - start})
+	if s3ErrorCode(err) == "PreconditionFailed" {
+		return 0, errChunkedChanged
+	}
+	if err != nil {
+		return 0, b.quirks.translate(err, o.bucket, o.key)
+	}
+	defer body.Close()
+
+	r := b.shaper.reader(body)
+	var fetched int64
+	for i := first; i <= last; i++ {
+		buf := make([]byte, min(cs, o.size-i*cs))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fetched, fmt.Errorf("failed to download chunk %d of %s/%s: %w", i, awsBucket, o.key, err)
+		}
+		if err := b.chunks.put(o, i, buf); err != nil {
+			return fetched, fmt.Errorf("failed to cache chunk %d of %s/%s: %w", i, o.bucket, o.key, err)
+		}
+		fetched += int64(len(buf))
+	}
+	return fetched, nil
+}
+
+// openChunks returns the bytes of rng, read from the cached chunks first to
+// last. Every chunk is opened before returning, so eviction can't pull one
+// out from under the client.
+func (b *LazyBackend) openChunks(o *chunkedObject, rng *gofakes3.ObjectRange, first, last int64) (io.ReadCloser, error) {
+	cs := b.chunks.chunkSize
+	var readers []io.Reader
+	var closers multiCloser
+	for i := first; i <= last; i++ {
+		from := max(rng.Start, i*cs)
+		to := min(rng.Start+rng.Length, (i+1)*cs)
+		rc, err := b.chunks.open(o, i, from-i*cs, to-from)
+		if err != nil {
+			closers.Close()
+			return nil, err
+		}
+		readers = append(readers, rc)
+		closers = append(closers, rc)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(readers...), closers}, nil
+}
+
+// multiCloser closes every closer it holds.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var errs []error
+	for _, c := range m {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// headMetadata extracts the metadata stored with a cached object from an
+// upstream HEAD response.
+func headMetadata(head *s3.HeadObjectOutput) map[string]string {
+	meta := make(map[string]string)
+	if head.ContentType != nil {
+		meta["Content-Type"] = *head.ContentType
+	}
+	if head.LastModified != nil {
+		meta["Last-Modified"] = head.LastModified.UTC().Format(http.TimeFormat)
+	}
+	for k, v := range head.Metadata {
+		meta[k] = v
+	}
+	return meta
+}
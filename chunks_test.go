@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// rangeRecorder records the Range header of every upstream GET, including
+// those of background fills.
+type rangeRecorder struct {
+	upstreamClient
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (r *rangeRecorder) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	r.mu.Lock()
+	r.ranges = append(r.ranges, aws.ToString(params.Range))
+	r.mu.Unlock()
+	return r.upstreamClient.GetObject(ctx, params, optFns...)
+}
+
+// recorded returns the ranges recorded so far.
+func (r *rangeRecorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.ranges)
+}
+
+func TestLazyBackend_ChunkedRanges(t *testing.T) {
+	for _, dir := range []string{"", "disk"} {
+		t.Run("store="+dir, func(t *testing.T) {
+			lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+			defer awsServer.Close()
+			upstream := &rangeRecorder{upstreamClient: lazyBackend.awsClient}
+			lazyBackend.awsClient = upstream
+			if dir != "" {
+				dir = t.TempDir()
+			}
+			if err := lazyBackend.SetChunkedRanges(10, 0, dir); err != nil {
+				t.Fatalf("SetChunkedRanges: %v", err)
+			}
+
+			for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+				if err := b.CreateBucket("test-bucket"); err != nil {
+					t.Fatalf("Failed to create bucket: %v", err)
+				}
+			}
+			content := "aaaaaaaaaabbbbbbbbbbccccccccccdddddddddde"
+			if _, err := awsBackend.PutObject("test-bucket", "data.parquet", map[string]string{"Content-Type": "application/octet-stream"},
+				strings.NewReader(content), int64(len(content)), nil); err != nil {
+				t.Fatalf("Failed to put object: %v", err)
+			}
+
+			read := func(rng *gofakes3.ObjectRangeRequest) (*gofakes3.Object, string) {
+				t.Helper()
+				obj, err := lazyBackend.GetObject("test-bucket", "data.parquet", rng)
+				if err != nil {
+					t.Fatalf("GetObject(%+v): %v", rng, err)
+				}
+				defer obj.Contents.Close()
+				data, err := io.ReadAll(obj.Contents)
+				if err != nil {
+					t.Fatalf("reading: %v", err)
+				}
+				return obj, string(data)
+			}
+
+			// The footer read fetches only the chunks it spans
+			obj, got := read(&gofakes3.ObjectRangeRequest{FromEnd: true, End: 12})
+			if got != content[len(content)-12:] {
+				t.Errorf("footer = %q, want %q", got, content[len(content)-12:])
+			}
+			if obj.Size != int64(len(content)) || obj.Range == nil || obj.Range.Start != int64(len(content)-12) {
+				t.Errorf("object size %d range %+v, want the full size and the footer range", obj.Size, obj.Range)
+			}
+			if obj.Metadata["Content-Type"] != "application/octet-stream" {
+				t.Errorf("Content-Type = %q, want it passed through", obj.Metadata["Content-Type"])
+			}
+			if ranges := upstream.recorded(); len(ranges) != 1 || ranges[0] != "bytes=20-40" {
+				t.Errorf("upstream GETs = %q, want one for chunks 2-4", ranges)
+			}
+			if _, err := localBackend.HeadObject("test-bucket", "data.parquet"); err == nil {
+				t.Error("a range read should not cache the whole object")
+			}
+
+			// A range inside cached chunks is a hit; one reaching further
+			// fetches only the missing chunks, in a single GET
+			if _, got = read(&gofakes3.ObjectRangeRequest{Start: 25, End: 34}); got != content[25:35] {
+				t.Errorf("range 25-34 = %q, want %q", got, content[25:35])
+			}
+			if _, got = read(&gofakes3.ObjectRangeRequest{Start: 5, End: 24}); got != content[5:25] {
+				t.Errorf("range 5-24 = %q, want %q", got, content[5:25])
+			}
+			if ranges := upstream.recorded(); len(ranges) != 2 || ranges[1] != "bytes=0-19" {
+				t.Errorf("upstream GETs = %q, want a second one for chunks 0-1 only", ranges)
+			}
+			if stats := lazyBackend.Stats(); stats.Hits != 1 || stats.Chunks == nil || stats.Chunks.Bytes != int64(len(content)) {
+				t.Errorf("stats = %+v, want one hit and every chunk cached", stats)
+			}
+
+			// A full read caches the whole object and supersedes the chunks
+			if _, got = read(nil); got != content {
+				t.Errorf("full read = %q, want %q", got, content)
+			}
+			if objects, _ := lazyBackend.chunks.usage(); objects != 0 {
+				t.Errorf("%d chunked objects remain after a full read, want 0", objects)
+			}
+		})
+	}
+}
+
+func TestLazyBackend_ChunkedRangesSmallObject(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	if err := lazyBackend.SetChunkedRanges(1<<20, 0, ""); err != nil {
+		t.Fatalf("SetChunkedRanges: %v", err)
+	}
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	if _, err := awsBackend.PutObject("test-bucket", "small.txt", nil, strings.NewReader("small"), 5, nil); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	obj, err := lazyBackend.GetObject("test-bucket", "small.txt", &gofakes3.ObjectRangeRequest{Start: 1, End: 2})
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	obj.Contents.Close()
+	if _, err := localBackend.HeadObject("test-bucket", "small.txt"); err != nil {
+		t.Errorf("objects within one chunk should be cached whole: %v", err)
+	}
+}
+
+func TestChunkStore_Eviction(t *testing.T) {
+	s, err := newChunkStore(10, 25, "")
+	if err != nil {
+		t.Fatalf("newChunkStore: %v", err)
+	}
+	a := s.start("b", "a", 100, `"a"`, nil, 0)
+	if err := s.put(a, 0, make([]byte, 10)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := s.put(a, 1, make([]byte, 10)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	c := s.start("b", "c", 100, `"c"`, nil, 0)
+	if err := s.put(c, 0, make([]byte, 10)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if _, ok := s.lookup("b", "a"); ok {
+		t.Error("the least recently used object should have been evicted")
+	}
+	if objects, bytes := s.usage(); objects != 1 || bytes != 10 {
+		t.Errorf("usage = %d objects, %d bytes, want 1 and 10", objects, bytes)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultCircuitCooldown is how long an open circuit keeps requests from
+// upstream unless configured otherwise.
+const defaultCircuitCooldown = 30 * time.Second
+
+// circuitBreaker stops sending requests to an upstream that keeps failing.
+// After threshold requests in a row find upstream unavailable, the circuit
+// opens: for cooldown, requests fail at once with a circuitOpenError instead of
+// waiting on network timeouts, so only cached objects are served. Then a
+// single request is let through to probe upstream; if it succeeds the
+// circuit closes, and if not it stays open for another cooldown.
+type circuitBreaker struct {
+	upstreamLister
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int // in a row
+	openUntil time.Time
+	probing   bool
+	opened    time.Time
+	lastError string
+	trips     int64
+}
+
+func newCircuitBreaker(client upstreamLister, name string, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{upstreamLister: client, name: name, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// circuitOpenError is returned for requests an open circuit keeps from
+// upstream.
+type circuitOpenError struct {
+	name  string
+	until time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open: %s is failing, not retried until %s", e.name, e.until.Format(time.RFC3339))
+}
+
+// allow reports whether a request may be sent upstream, and whether it is
+// the probe of a circuit whose cooldown has passed.
+func (c *circuitBreaker) allow() (probe bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opened.IsZero() {
+		return false, nil
+	}
+	if c.probing || c.now().Before(c.openUntil) {
+		return false, &circuitOpenError{name: c.name, until: c.openUntil}
+	}
+	c.probing = true
+	return true, nil
+}
+
+// report records the outcome of a request sent upstream.
+func (c *circuitBreaker) report(probe bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if probe {
+		c.probing = false
+	}
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up, which says nothing about upstream
+		return
+	}
+	if err == nil || !isUpstreamUnavailable(err) {
+		c.failures = 0
+		if !c.opened.IsZero() {
+			log.Printf("[CIRCUIT] %s closed after %v", c.name, c.now().Sub(c.opened).Round(time.Second))
+			c.opened, c.openUntil = time.Time{}, time.Time{}
+		}
+		return
+	}
+	c.failures++
+	c.lastError = err.Error()
+	switch {
+	case probe:
+		c.openUntil = c.now().Add(c.cooldown)
+		log.Printf("[CIRCUIT] %s still failing, open for another %v: %v", c.name, c.cooldown, err)
+	case c.opened.IsZero() && c.failures >= c.threshold:
+		c.opened = c.now()
+		c.openUntil = c.opened.Add(c.cooldown)
+		c.trips++
+		log.Printf("[CIRCUIT] %s opened for %v after %d failure(s) in a row: %v", c.name, c.cooldown, c.failures, err)
+	}
+}
+
+// circuitStatus is the state of a circuit in the stats report.
+type circuitStatus struct {
+	Open      bool      `json:"open"`
+	Failures  int       `json:"failures"`
+	Trips     int64     `json:"trips"`
+	OpenSince time.Time `json:"open_since,omitzero"`
+	OpenUntil time.Time `json:"open_until,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+func (c *circuitBreaker) status() *circuitStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &circuitStatus{
+		Open:      !c.opened.IsZero(),
+		Failures:  c.failures,
+		Trips:     c.trips,
+		OpenSince: c.opened,
+		OpenUntil: c.openUntil,
+		LastError: c.lastError,
+	}
+}
+
+// guard sends a request upstream unless the circuit is open.
+func guard[T any](c *circuitBreaker, call func() (T, error)) (T, error) {
+	probe, err := c.allow()
+	if err != nil {
+		var out T
+		return out, err
+	}
+	out, err := call()
+	c.report(probe, err)
+	return out, err
+}
+
+func (c *circuitBreaker) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return guard(c, func() (*s3.GetObjectOutput, error) {
+		return c.upstreamLister.GetObject(ctx, params, optFns...)
+	})
+}
+
+func (c *circuitBreaker) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return guard(c, func() (*s3.HeadObjectOutput, error) {
+		return c.upstreamLister.HeadObject(ctx, params, optFns...)
+	})
+}
+
+func (c *circuitBreaker) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return guard(c, func() (*s3.ListObjectsV2Output, error) {
+		return c.upstreamLister.ListObjectsV2(ctx, params, optFns...)
+	})
+}
+
+func (c *circuitBreaker) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	return guard(c, func() (*s3.GetObjectTaggingOutput, error) {
+		return c.upstreamLister.GetObjectTagging(ctx, params, optFns...)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "cached.txt", "cached")
+	putString(t, awsBackend, "uncached.txt", "uncached")
+	readObject(t, lazyBackend, "cached.txt", nil)
+
+	dead := httptest.NewServer(nil)
+	dead.Close()
+	breaker := newCircuitBreaker(newEndpointClient(t, dead.URL), "upstream", 2, time.Minute)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	lazyBackend.awsClient = breaker
+
+	// Misses fail on the dead upstream until the circuit opens
+	for i := 0; i < 2 && !breaker.status().Open; i++ {
+		if _, err := lazyBackend.GetObject("test-bucket", "uncached.txt", nil); !errors.Is(err, ErrUpstreamUnavailable) {
+			t.Fatalf("GetObject = %v, want ErrUpstreamUnavailable", err)
+		}
+	}
+	if s := breaker.status(); !s.Open || s.Trips != 1 || s.LastError == "" {
+		t.Fatalf("status = %+v, want the circuit open", s)
+	}
+
+	// Open, misses fail at once with a clear error and hits are still served
+	_, err := lazyBackend.GetObject("test-bucket", "uncached.txt", nil)
+	var open *circuitOpenError
+	if !errors.As(err, &open) || !gofakes3.HasErrorCode(err, "ServiceUnavailable") {
+		t.Errorf("GetObject while open = %v, want the open circuit reported", err)
+	}
+	if _, got := readObject(t, lazyBackend, "cached.txt", nil); got != "cached" {
+		t.Errorf("cached content = %q while open", got)
+	}
+
+	// After the cooldown, a probe that reaches upstream closes the circuit
+	breaker.upstreamLister = newEndpointClient(t, awsServer.URL)
+	now = now.Add(time.Minute)
+	if _, got := readObject(t, lazyBackend, "uncached.txt", nil); got != "uncached" {
+		t.Errorf("content after the cooldown = %q", got)
+	}
+	if s := breaker.status(); s.Open || s.Failures != 0 {
+		t.Errorf("status = %+v, want the circuit closed", s)
+	}
+}
+
+func TestCircuitBreaker_FailedProbe(t *testing.T) {
+	breaker := newCircuitBreaker(nil, "upstream", 1, time.Minute)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	if _, err := guard(breaker, func() (int, error) { return 0, errors.New("connection refused") }); err == nil {
+		t.Fatal("failure not returned")
+	}
+	if _, err := guard(breaker, func() (int, error) { return 0, nil }); err == nil {
+		t.Error("request sent while the circuit is open")
+	}
+
+	// A probe that fails keeps the circuit open for another cooldown
+	now = now.Add(time.Minute)
+	if _, err := guard(breaker, func() (int, error) { return 0, errors.New("connection refused") }); err == nil {
+		t.Fatal("probe failure not returned")
+	}
+	if s := breaker.status(); !s.Open || !s.OpenUntil.Equal(now.Add(time.Minute)) || s.Trips != 1 {
+		t.Errorf("status = %+v, want open for another minute", s)
+	}
+}
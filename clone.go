@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// cloneResult reports a bucket clone.
+type cloneResult struct {
+	Source   string `json:"source"`
+	Bucket   string `json:"bucket"`
+	Upstream string `json:"upstream"`
+	Objects  int    `json:"objects"`
+	Bytes    int64  `json:"bytes"`
+
+	// PrefetchID is the prefetch job fetching the objects that weren't
+	// cached, when asked for
+	PrefetchID string `json:"prefetch_id,omitempty"`
+}
+
+// CloneBucket creates the local bucket dst as a copy of src, for an isolated
+// experiment namespace: it holds copies of src's objects, cached or written
+// by clients, and fetches misses from src's upstream bucket, but writes to
+// either bucket leave the other alone. The mapping of dst is saved with the
+// clones, so it survives restarts when the index does.
+func (b *LazyBackend) CloneBucket(src, dst string) (cloneResult, error) {
+	if b.toggles.readOnly.Load() {
+		return cloneResult{}, errReadOnly()
+	}
+	src = b.canonicalBucket(src)
+	if exists, err := b.local.BucketExists(src); err != nil {
+		return cloneResult{}, err
+	} else if !exists {
+		return cloneResult{}, gofakes3.BucketNotFound(src)
+	}
+	if err := gofakes3.ValidateBucketName(dst); err != nil {
+		return cloneResult{}, err
+	}
+	if dst == src || b.canonicalBucket(dst) != dst {
+		return cloneResult{}, gofakes3.ResourceError(gofakes3.ErrBucketAlreadyExists, dst)
+	}
+	// Objects clients wrote count towards dst's quota, so a clone that
+	// doesn't fit is refused before anything is copied
+	written, err := b.writtenBytes(src)
+	if err != nil {
+		return cloneResult{}, err
+	}
+	if err := b.localData.checkBucket(dst, written); err != nil {
+		return cloneResult{}, err
+	}
+	if err := b.local.CreateBucket(dst); err != nil {
+		return cloneResult{}, err
+	}
+
+	// dst is fetched from wherever src is
+	result := cloneResult{Source: src, Bucket: dst, Upstream: b.awsBucketName(src)}
+	record := cloneRecord{Source: src, Upstream: result.Upstream}
+	mapped := b.mapClone(dst, record)
+	if err := b.clones.add(dst, record); err != nil {
+		log.Printf("Warning: couldn't save the mapping of clone %s: %v", dst, err)
+	}
+
+	for key, err := range localKeys(b.local, src, "") {
+		if err != nil {
+			b.dropClone(dst, record, mapped)
+			return cloneResult{}, err
+		}
+		size, err := b.cloneObject(src, dst, key)
+		if gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			// Evicted or deleted since it was listed
+			continue
+		}
+		if err != nil {
+			b.dropClone(dst, record, mapped)
+			return cloneResult{}, fmt.Errorf("cloning %s/%s: %w", src, key, err)
+		}
+		result.Objects++
+		result.Bytes += size
+	}
+	b.evict()
+	log.Printf("[CLONE] %s to %s: %d object(s), %d bytes", src, dst, result.Objects, result.Bytes)
+	return result, nil
+}
+
+// writtenBytes returns the size of the objects clients wrote to a bucket,
+// as opposed to those cached from upstream.
+func (b *LazyBackend) writtenBytes(bucketName string) (int64, error) {
+	var written int64
+	for c, err := range localContents(b.local, bucketName, "") {
+		if err != nil {
+			return 0, err
+		}
+		if _, cached := b.index.lookup(bucketName, c.Key); !cached {
+			written += c.Size
+		}
+	}
+	return written, nil
+}
+
+// dropClone removes a clone that failed partway, with its mapping, so the
+// clone can be tried again.
+func (b *LazyBackend) dropClone(dst string, record cloneRecord, mapped bool) {
+	if err := b.local.ForceDeleteBucket(dst); err != nil {
+		log.Printf("Warning: couldn't remove the failed clone %s: %v", dst, err)
+	}
+	b.index.removeBucket(dst)
+	b.localData.forgetBucket(dst)
+	b.chunks.dropBucket(dst)
+	if err := b.clones.forget(dst); err != nil {
+		log.Printf("Warning: couldn't save the clones after removing %s: %v", dst, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if mapped {
+		delete(b.bucketMapping, dst)
+	}
+	if _, ok := b.bucketUpstreams[record.Source]; ok {
+		delete(b.bucketUpstreams, dst)
+	}
+	if _, ok := b.urlSources[record.Source]; ok {
+		delete(b.urlSources, dst)
+	}
+}
+
+// mapClone has a clone fetch misses from its upstream bucket, through the
+// upstream or URL source of the bucket it was cloned from. A mapping
+// configured for the clone wins. It reports whether it set the mapping.
+func (b *LazyBackend) mapClone(dst string, record cloneRecord) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, configured := b.bucketMapping[dst]
+	if !configured {
+		b.bucketMapping[dst] = record.Upstream
+	}
+	if own, ok := b.bucketUpstreams[record.Source]; ok {
+		b.bucketUpstreams[dst] = own
+	}
+	if source, ok := b.urlSources[record.Source]; ok {
+		b.urlSources[dst] = source
+	}
+	return !configured
+}
+
+// cloneRecord is what a clone fetches misses from: the upstream bucket of
+// the bucket it was cloned from, whose own upstream or URL source it uses.
+type cloneRecord struct {
+	Source   string `json:"source"`
+	Upstream string `json:"upstream"`
+}
+
+// cloneRegistry remembers the buckets cloned at runtime, saved next to the
+// data so a restart doesn't leave clones fetching misses from an upstream
+// bucket of their own name.
+type cloneRegistry struct {
+	mu     sync.Mutex
+	path   string
+	clones map[string]cloneRecord
+}
+
+func newCloneRegistry() *cloneRegistry {
+	return &cloneRegistry{clones: make(map[string]cloneRecord)}
+}
+
+// add records a clone and saves the registry.
+func (r *cloneRegistry) add(bucketName string, record cloneRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clones[bucketName] = record
+	return r.saveLocked()
+}
+
+// forget drops a deleted bucket, if it was a clone, and saves the registry.
+func (r *cloneRegistry) forget(bucketName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clones[bucketName]; !ok {
+		return nil
+	}
+	delete(r.clones, bucketName)
+	return r.saveLocked()
+}
+
+// saveLocked writes the registry to its path via a temp file and rename.
+func (r *cloneRegistry) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(r.clones)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// LoadClones restores the mappings of the clones saved at path, and keeps
+// saving there. Clones whose bucket is gone are dropped. A missing file
+// restores nothing.
+func (b *LazyBackend) LoadClones(path string) error {
+	r := b.clones
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var clones map[string]cloneRecord
+	if err := json.Unmarshal(data, &clones); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for bucketName, record := range clones {
+		if exists, err := b.local.BucketExists(bucketName); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		b.mapClone(bucketName, record)
+		r.clones[bucketName] = record
+	}
+	if len(r.clones) > 0 {
+		log.Printf("Restored the mappings of %d clone(s)", len(r.clones))
+	}
+	return nil
+}
+
+// cloneObject copies an object from src to dst, and its cache entry if it
+// was fetched from upstream.
+func (b *LazyBackend) cloneObject(src, dst, key string) (int64, error) {
+	unlock := b.locks.RLock(src, key)
+	defer unlock()
+	obj, err := b.local.GetObject(src, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer obj.Contents.Close()
+	// Objects clients wrote count towards dst's quota
+	entry, cached := b.index.lookup(src, key)
+	done := func(bool) {}
+	if !cached {
+		if done, err = b.localData.admit(dst, key, obj.Size); err != nil {
+			return 0, err
+		}
+	}
+	_, err = b.local.PutObject(dst, key, obj.Metadata, obj.Contents, obj.Size, nil)
+	done(err == nil)
+	if err != nil {
+		return 0, err
+	}
+	if cached {
+		b.index.add(dst, key, entry.Size, entry.ETag)
+		b.index.setTTL(dst, key, entry.TTL)
+		b.index.setNamespace(dst, key, entry.Namespace)
+	}
+	return obj.Size, nil
+}
+
+// runClone implements `s3lazy clone`: it asks a running instance to clone a
+// bucket and prints what was copied.
+func runClone(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("clone", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: s3lazy clone [flags] <src-bucket> <dst-bucket>")
+		fs.PrintDefaults()
+	}
+	endpoint := fs.String("endpoint", defaultAdminEndpoint, "s3lazy admin endpoint")
+	upstream := fs.Bool("upstream", false, "also fetch the objects src hasn't cached from upstream, in the background")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("want a source and a destination bucket")
+	}
+
+	u := fmt.Sprintf("%s/admin/buckets/%s/clone?to=%s", strings.TrimSuffix(*endpoint, "/"), url.PathEscape(fs.Arg(0)), url.QueryEscape(fs.Arg(1)))
+	if *upstream {
+		u += "&upstream=true"
+	}
+	resp, err := adminRequest(context.Background(), http.MethodPost, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) != nil || failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return errors.New(failure.Error)
+	}
+	var result cloneResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Cloned %s to %s: %d object(s), %d bytes; misses are fetched from upstream bucket %s\n",
+		result.Source, result.Bucket, result.Objects, result.Bytes, result.Upstream)
+	if result.PrefetchID != "" {
+		fmt.Fprintf(out, "Fetching uncached objects from upstream: %s/admin/prefetch/%s\n", strings.TrimSuffix(*endpoint, "/"), result.PrefetchID)
+	}
+	return nil
+}
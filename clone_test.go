@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestCloneBucket(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "cached.txt", "cached")
+	putString(t, awsBackend, "uncached.txt", "uncached")
+	readObject(t, lazyBackend, "cached.txt", nil)
+	putString(t, localBackend, "written.txt", "written by a client")
+
+	server := httptest.NewServer(newAdminHandler(lazyBackend, DefaultConfig()))
+	defer server.Close()
+	var out bytes.Buffer
+	if err := runClone([]string{"-endpoint", server.URL, "test-bucket", "experiment"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Cloned test-bucket to experiment: 2 object(s), 25 bytes"; !strings.Contains(out.String(), want) {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	for key, want := range map[string]string{"cached.txt": "cached", "written.txt": "written by a client"} {
+		obj, err := localBackend.GetObject("experiment", key, nil)
+		if err != nil {
+			t.Fatalf("%s not cloned: %v", key, err)
+		}
+		obj.Contents.Close()
+		if obj.Size != int64(len(want)) {
+			t.Errorf("%s is %d bytes, want %d", key, obj.Size, len(want))
+		}
+	}
+	if _, ok := lazyBackend.index.lookup("experiment", "cached.txt"); !ok {
+		t.Error("cache entry of cached.txt not cloned")
+	}
+	if _, ok := lazyBackend.index.lookup("experiment", "written.txt"); ok {
+		t.Error("object written by a client tracked as cached")
+	}
+
+	// Misses in the clone come from the source's upstream bucket, and writes
+	// stay in the clone
+	obj, err := lazyBackend.GetObject("experiment", "uncached.txt", nil)
+	if err != nil {
+		t.Fatalf("miss in the clone: %v", err)
+	}
+	obj.Contents.Close()
+	if _, err := lazyBackend.DeleteObject("experiment", "cached.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "cached.txt"); err != nil {
+		t.Errorf("deleting from the clone touched the source: %v", err)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"test-bucket", "experiment"}, "BucketAlreadyExists"},
+		{[]string{"missing", "experiment-2"}, "NoSuchBucket"},
+		{[]string{"test-bucket"}, "want a source and a destination bucket"},
+	} {
+		err := runClone(append([]string{"-endpoint", server.URL}, tt.args...), &out)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("clone %v = %v, want %s", tt.args, err, tt.want)
+		}
+	}
+}
+
+func TestCloneBucket_Upstream(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "cached.txt", "cached")
+	putString(t, awsBackend, "uncached.txt", "uncached")
+	readObject(t, lazyBackend, "cached.txt", nil)
+
+	server := httptest.NewServer(newAdminHandler(lazyBackend, DefaultConfig()))
+	defer server.Close()
+	var out bytes.Buffer
+	if err := runClone([]string{"-endpoint", server.URL, "-upstream", "test-bucket", "experiment"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), server.URL+"/admin/prefetch/1") {
+		t.Errorf("output = %q, want the prefetch job", out.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for job, _ := lazyBackend.prefetches.get("1"); job.FinishedAt == nil; job, _ = lazyBackend.prefetches.get("1") {
+		if time.Now().After(deadline) {
+			t.Fatalf("prefetch still %s", job.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := localBackend.HeadObject("experiment", "uncached.txt"); err != nil {
+		t.Errorf("uncached object not fetched into the clone: %v", err)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "uncached.txt"); err == nil {
+		t.Error("uncached object fetched into the source")
+	}
+}
+
+func TestCloneBucket_Restart(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "uncached.txt", "uncached")
+	clonesPath := filepath.Join(t.TempDir(), "clones.json")
+	if err := lazyBackend.LoadClones(clonesPath); err != nil {
+		t.Fatal(err)
+	}
+	for _, dst := range []string{"experiment", "deleted"} {
+		if _, err := lazyBackend.CloneBucket("test-bucket", dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lazyBackend.DeleteBucket("deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart over the same data fetches the clone's misses from the
+	// source's upstream bucket still
+	restarted := NewLazyBackend(localBackend, lazyBackend.awsClient)
+	if err := restarted.LoadClones(clonesPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.awsBucketName("experiment"); got != "test-bucket" {
+		t.Errorf("clone maps to %q after a restart, want test-bucket", got)
+	}
+	if got := restarted.awsBucketName("deleted"); got != "deleted" {
+		t.Errorf("deleted clone maps to %q after a restart, want no mapping", got)
+	}
+	obj, err := restarted.GetObject("experiment", "uncached.txt", nil)
+	if err != nil {
+		t.Fatalf("miss in the clone after a restart: %v", err)
+	}
+	obj.Contents.Close()
+
+	restarted.toggles.readOnly.Store(true)
+	if _, err := restarted.CloneBucket("test-bucket", "experiment-2"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("clone while read-only = %v, want ErrReadOnly", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// compactDir is where compaction writes a bucket's new segments before
+// moving them into place.
+const compactDir = ".compact"
+
+// packCompactMinDead is the share of a bucket's pack bytes that have to be
+// dead before scheduled compaction rewrites it.
+const packCompactMinDead = 0.25
+
+// packCompaction reports what a compaction reclaimed.
+type packCompaction struct {
+	Buckets   int   `json:"buckets"`
+	Reclaimed int64 `json:"reclaimed_bytes"`
+}
+
+// packUsage describes the packs of one bucket.
+type packUsage struct {
+	Keys      int   `json:"keys"`
+	Bytes     int64 `json:"bytes"`
+	DeadBytes int64 `json:"dead_bytes"`
+}
+
+// usage returns the pack usage of every bucket.
+func (s *packStore) usage() map[string]packUsage {
+	if s == nil {
+		return map[string]packUsage{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := make(map[string]packUsage, len(s.buckets))
+	for name, pb := range s.buckets {
+		usage[name] = packUsage{Keys: len(pb.entries), Bytes: pb.size, DeadBytes: pb.size - pb.live}
+	}
+	return usage
+}
+
+// compact rewrites the packs of every bucket with dead bytes making up at
+// least minDead of its packs, reclaiming the space of overwritten and
+// deleted objects.
+func (s *packStore) compact(minDead float64) (packCompaction, error) {
+	if s == nil {
+		return packCompaction{}, nil
+	}
+	s.compacting.Lock()
+	defer s.compacting.Unlock()
+
+	var report packCompaction
+	for name, usage := range s.usage() {
+		if usage.DeadBytes == 0 || float64(usage.DeadBytes) < minDead*float64(usage.Bytes) {
+			continue
+		}
+		reclaimed, err := s.compactBucket(name)
+		if err != nil {
+			return report, fmt.Errorf("compacting packs of %s: %w", name, err)
+		}
+		log.Printf("[PACK] compacted %s: reclaimed %d bytes", name, reclaimed)
+		report.Buckets++
+		report.Reclaimed += reclaimed
+	}
+	return report, nil
+}
+
+// compactBucket copies a bucket's live records into new segments and
+// replaces the old segments with them, returning the bytes reclaimed.
+//
+// Reads and writes carry on meanwhile: writes go to the old segments, and
+// once the live records are copied the keys written or deleted since are
+// brought over under the lock. The new segments are numbered after the old
+// ones, so a crash before all the old ones are removed leaves the newer
+// copies to win when the segments are replayed. They are moved into place
+// in reverse order and the old ones removed in order, so whatever a crash
+// leaves behind replays to the same keys.
+func (s *packStore) compactBucket(bucket string) (int64, error) {
+	s.mu.Lock()
+	pb := s.buckets[bucket]
+	if pb == nil {
+		s.mu.Unlock()
+		return 0, nil
+	}
+	snapshot := maps.Clone(pb.entries)
+	s.mu.Unlock()
+
+	tmp := filepath.Join(pb.dir, compactDir)
+	if err := os.RemoveAll(tmp); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+
+	out := &packWriter{dir: tmp}
+	defer out.close()
+	entries := make(map[string]*packEntry, len(snapshot))
+	for _, key := range slices.Sorted(maps.Keys(snapshot)) {
+		e, err := pb.copyRecord(out, key, snapshot[key])
+		if err != nil {
+			return 0, err
+		}
+		entries[key] = e
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] != pb {
+		// Dropped meanwhile
+		return 0, nil
+	}
+	for key, e := range pb.entries {
+		if snapshot[key] == e {
+			continue
+		}
+		copied, err := pb.copyRecord(out, key, e)
+		if err != nil {
+			return 0, err
+		}
+		entries[key] = copied
+	}
+	for key := range snapshot {
+		if pb.entries[key] != nil {
+			continue
+		}
+		if _, _, _, err := out.write(packRecord{Key: key, Deleted: true, ModTime: s.now()}, nil); err != nil {
+			return 0, err
+		}
+		delete(entries, key)
+	}
+	if err := out.close(); err != nil {
+		return 0, err
+	}
+
+	old, err := packSegments(pb.dir)
+	if err != nil {
+		return 0, err
+	}
+	base := pb.activeSeg + 1
+	for i := len(out.sizes) - 1; i >= 0; i-- {
+		if err := os.Rename(filepath.Join(tmp, segmentName(i)), filepath.Join(pb.dir, segmentName(base+i))); err != nil {
+			return 0, err
+		}
+	}
+	if pb.active != nil {
+		pb.active.Close()
+		pb.active = nil
+	}
+	for _, seg := range old {
+		if err := os.Remove(filepath.Join(pb.dir, segmentName(seg))); err != nil {
+			return 0, err
+		}
+	}
+
+	var size, live int64
+	for _, n := range out.sizes {
+		size += n
+	}
+	for _, e := range entries {
+		e.segment += base
+		live += e.length
+	}
+	reclaimed := pb.size - size
+	pb.entries, pb.size, pb.live = entries, size, live
+	pb.activeSeg, pb.activeSize = base, 0
+	if n := len(out.sizes); n > 0 {
+		pb.activeSeg, pb.activeSize = base+n-1, out.sizes[n-1]
+	}
+	return reclaimed, nil
+}
+
+// copyRecord copies a packed key's record to out.
+func (pb *packBucket) copyRecord(out *packWriter, key string, e *packEntry) (*packEntry, error) {
+	f, err := os.Open(filepath.Join(pb.dir, segmentName(e.segment)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, e.size)
+	if _, err := f.ReadAt(data, e.offset); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", key, err)
+	}
+	seg, offset, length, err := out.write(packRecord{Key: key, Size: e.size, Hash: e.hash, Meta: e.meta, ModTime: e.modTime}, data)
+	if err != nil {
+		return nil, err
+	}
+	return &packEntry{segment: seg, offset: offset, size: e.size, hash: e.hash, meta: e.meta, modTime: e.modTime, length: length}, nil
+}
+
+// packWriter writes records to new segments numbered from 0 in dir.
+type packWriter struct {
+	dir   string
+	f     *os.File
+	w     *bufio.Writer
+	sizes []int64 // of each segment written
+}
+
+// write appends a record, starting a new segment once the current one is
+// full, and returns the segment, the offset of its data and its length.
+func (pw *packWriter) write(rec packRecord, data []byte) (seg int, offset, length int64, err error) {
+	buf, err := encodePackRecord(rec, data)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if pw.f == nil || pw.sizes[len(pw.sizes)-1] >= packSegmentSize {
+		if err := pw.close(); err != nil {
+			return 0, 0, 0, err
+		}
+		f, err := os.Create(filepath.Join(pw.dir, segmentName(len(pw.sizes))))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		pw.f, pw.w = f, bufio.NewWriter(f)
+		pw.sizes = append(pw.sizes, 0)
+	}
+	if _, err := pw.w.Write(buf); err != nil {
+		return 0, 0, 0, err
+	}
+	seg = len(pw.sizes) - 1
+	offset = pw.sizes[seg] + int64(len(buf)-len(data))
+	pw.sizes[seg] += int64(len(buf))
+	return seg, offset, int64(len(buf)), nil
+}
+
+// close flushes and syncs the current segment, so it is safe to move into
+// place.
+func (pw *packWriter) close() error {
+	if pw.f == nil {
+		return nil
+	}
+	f := pw.f
+	pw.f = nil
+	err := pw.w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
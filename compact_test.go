@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestPackStore_Compact(t *testing.T) {
+	dataDir := t.TempDir()
+	disk, packs := newTestPackedDisk(t, dataDir, 100)
+	putString(t, disk, "kept", "v1")
+	putString(t, disk, "kept", "v2")
+	putString(t, disk, "deleted", "gone")
+	putString(t, disk, "other", "stays")
+	if _, err := disk.DeleteObject("test-bucket", "deleted"); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+
+	before := packs.usage()["test-bucket"]
+	if before.Keys != 2 || before.DeadBytes == 0 {
+		t.Fatalf("usage = %+v, want 2 keys and dead bytes", before)
+	}
+
+	// Scheduled compaction leaves buckets with little dead space alone
+	if report, err := packs.compact(0.99); err != nil || report.Buckets != 0 {
+		t.Errorf("compact(0.99) = %+v, %v; want nothing compacted", report, err)
+	}
+
+	report, err := packs.compact(0)
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	after := packs.usage()["test-bucket"]
+	if report.Buckets != 1 || report.Reclaimed != before.DeadBytes {
+		t.Errorf("report = %+v, want the %d dead bytes of one bucket reclaimed", report, before.DeadBytes)
+	}
+	if after.DeadBytes != 0 || after.Bytes != before.Bytes-before.DeadBytes {
+		t.Errorf("usage after compacting = %+v, want only the %d live bytes", after, before.Bytes-before.DeadBytes)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dataDir, "s3lazy", "packs", "test-bucket", "*")); len(names) != 1 || filepath.Base(names[0]) != segmentName(1) {
+		t.Errorf("segments = %v, want the old segment replaced by %s", names, segmentName(1))
+	}
+
+	check := func(disk gofakes3.Backend) {
+		t.Helper()
+		if _, data := readObject(t, disk, "kept", nil); data != "v2" {
+			t.Errorf("kept = %q, want the latest version", data)
+		}
+		if _, data := readObject(t, disk, "other", &gofakes3.ObjectRangeRequest{Start: 1, End: 3}); data != "tay" {
+			t.Errorf("range of other = %q, want %q", data, "tay")
+		}
+		if _, err := disk.HeadObject("test-bucket", "deleted"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			t.Errorf("deleted key: err = %v, want NoSuchKey", err)
+		}
+	}
+	check(disk)
+
+	// Writes carry on into the compacted segments, and survive a restart
+	putString(t, disk, "after", "appended")
+	disk, packs = newTestPackedDisk(t, dataDir, 100)
+	check(disk)
+	if _, data := readObject(t, disk, "after", nil); data != "appended" {
+		t.Errorf("after = %q after reopening", data)
+	}
+	if usage := packs.usage()["test-bucket"]; usage.Keys != 3 || usage.DeadBytes != 0 {
+		t.Errorf("usage after reopening = %+v, want 3 keys and no dead bytes", usage)
+	}
+}
+
+func TestPackStore_CompactLeftovers(t *testing.T) {
+	dataDir := t.TempDir()
+	disk, _ := newTestPackedDisk(t, dataDir, 100)
+	putString(t, disk, "kept", "v1")
+
+	// A compaction interrupted before moving its segments into place
+	tmp := filepath.Join(dataDir, "s3lazy", "packs", "test-bucket", compactDir)
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, segmentName(0)), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	disk, _ = newTestPackedDisk(t, dataDir, 100)
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("stat %s: %v, want it removed on open", compactDir, err)
+	}
+	if _, data := readObject(t, disk, "kept", nil); data != "v1" {
+		t.Errorf("kept = %q, want %q", data, "v1")
+	}
+}
+
+func TestAdmin_PackCompaction(t *testing.T) {
+	lazyBackend, _, _, _ := setupTestBackends(t)
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+
+	// Without a disk backend there is nothing to compact
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/packs/compact", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	disk, packs := newTestPackedDisk(t, t.TempDir(), 100)
+	putString(t, disk, "key", "v1")
+	putString(t, disk, "key", "v2")
+	lazyBackend.SetPackStore(packs)
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/packs", nil))
+	var usage map[string]packUsage
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil || usage["test-bucket"].DeadBytes == 0 {
+		t.Fatalf("GET /admin/packs = %s (%v), want dead bytes in test-bucket", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/packs/compact", nil))
+	var report packCompaction
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || report.Reclaimed != usage["test-bucket"].DeadBytes {
+		t.Errorf("POST /admin/packs/compact = %s (%v), want %d bytes reclaimed", rec.Body, err, usage["test-bucket"].DeadBytes)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/xml"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// conditionalHeaders are the request headers conditionalGuard evaluates.
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"}
+
+// conditionalGuard evaluates the conditional headers of object GETs and
+// HEADs the way S3 does, against the response the cache or upstream gives,
+// so every path answers alike:
+//
+//   - If-Match takes precedence over If-Unmodified-Since; failing either
+//     gives 412 Precondition Failed.
+//   - If-None-Match takes precedence over If-Modified-Since; failing either
+//     gives 304 Not Modified.
+//   - Conditions apply to the whole object, so a Range request that fails
+//     one gets 304 or 412 rather than 206.
+//   - A Range request whose If-Range doesn't match the current object gets
+//     the whole object with 200 instead of a part of a different version.
+//   - A part of an object is sent with 206 Partial Content, which gofakes3
+//     leaves at 200.
+//
+// Objects whose content MD5 isn't known, such as objects streamed from
+// upstreams with non-MD5 ETags, are compared by the upstream ETag they were
+// cached with; when there is none, ETag conditions are skipped. The headers
+// are removed before the request is served, since gofakes3 only knows part
+// of these rules.
+func (b *LazyBackend) conditionalGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, key, ok := objectPath(r.URL.Path)
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !ok || !isObjectRead(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			w = &partialContentWriter{ResponseWriter: w}
+		}
+		if !hasConditions(r.Header) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &conditionalWriter{
+			ResponseWriter: w,
+			r:              r,
+			conditions:     r.Header.Clone(),
+			storedETag:     func() string { return b.index.etag(b.canonicalBucket(bucket), key) },
+		}
+		saved := w.Header().Clone()
+		stripConditions(r)
+		next.ServeHTTP(cw, r)
+		cw.finish()
+		if cw.rangeStale {
+			// The part asked for is of another version; send the whole
+			// object instead
+			h := w.Header()
+			clear(h)
+			maps.Copy(h, saved)
+			r.Header.Del("Range")
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// conditionalWriter evaluates a request's conditions once the headers of
+// the response to it are known, and replaces a response that fails them
+// with a 412 or 304, dropping its body.
+type conditionalWriter struct {
+	http.ResponseWriter
+	r          *http.Request
+	conditions http.Header
+	storedETag func() string
+
+	wroteHeader bool
+	discard     bool // the body isn't sent
+	rangeStale  bool // If-Range failed, so the part mustn't be sent
+}
+
+func (w *conditionalWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status != http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	h := w.Header()
+	etag := h.Get("ETag")
+	if etag == "" || etag == `""` {
+		// Objects without an MD5 get an empty ETag from gofakes3
+		etag = w.storedETag()
+		if etag != "" {
+			h.Set("ETag", etag)
+		} else {
+			h.Del("ETag")
+		}
+	}
+	lastModified, _ := http.ParseTime(h.Get("Last-Modified"))
+	switch evaluateConditions(w.conditions, etag, lastModified) {
+	case http.StatusPreconditionFailed:
+		w.discard = true
+		for _, name := range []string{"Content-Length", "Content-Range", "Content-Type", "Accept-Ranges", "ETag", "Last-Modified"} {
+			h.Del(name)
+		}
+		writePreconditionFailed(w.ResponseWriter, w.r)
+		return
+	case http.StatusNotModified:
+		w.discard = true
+		for _, name := range []string{"Content-Length", "Content-Range", "Content-Type", "Accept-Ranges"} {
+			h.Del(name)
+		}
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if ifRange := w.conditions.Get("If-Range"); ifRange != "" && w.conditions.Get("Range") != "" && !ifRangeMatches(ifRange, etag, lastModified) {
+		w.discard, w.rangeStale = true, true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *conditionalWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.discard {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// finish evaluates the conditions of a response that was never written
+// to, such as a HEAD's, which net/http would send as 200.
+func (w *conditionalWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// isObjectRead reports whether a GET or HEAD reads an object rather than a
+// subresource such as ?tagging or ?acl. Response header overrides and
+// pre-signed URL parameters don't count.
+func isObjectRead(r *http.Request) bool {
+	for k := range r.URL.Query() {
+		if !strings.HasPrefix(k, "response-") && !strings.HasPrefix(strings.ToLower(k), "x-amz-") {
+			return false
+		}
+	}
+	return true
+}
+
+func hasConditions(h http.Header) bool {
+	for _, name := range conditionalHeaders {
+		if h.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func stripConditions(r *http.Request) {
+	for _, name := range conditionalHeaders {
+		r.Header.Del(name)
+	}
+}
+
+// evaluateConditions returns 412 or 304 if the request's preconditions
+// fail for an object with the given ETag and modification time, else 0.
+// Dates that don't parse are ignored, as S3 does. With no ETag known, an
+// If-Match or If-None-Match other than "*", and the date it takes precedence
+// over, is ignored.
+func evaluateConditions(h http.Header, etag string, lastModified time.Time) int {
+	if v := h.Get("If-Match"); v != "" {
+		if (etag != "" || isAnyETag(v)) && !etagListMatches(v, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(h.Get("If-Unmodified-Since")); err == nil && !lastModified.IsZero() && lastModified.After(t) {
+		return http.StatusPreconditionFailed
+	}
+	if v := h.Get("If-None-Match"); v != "" {
+		if (etag != "" || isAnyETag(v)) && etagListMatches(v, etag, true) {
+			return http.StatusNotModified
+		}
+	} else if t, err := http.ParseTime(h.Get("If-Modified-Since")); err == nil && !lastModified.IsZero() && !lastModified.After(t) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// isAnyETag reports whether a condition is "*", which any object matches,
+// whatever its ETag.
+func isAnyETag(v string) bool {
+	return strings.TrimSpace(v) == "*"
+}
+
+// etagListMatches reports whether a comma-separated list of entity tags, or
+// "*", matches etag. Weak comparison ignores W/ prefixes; strong comparison
+// never matches a weak tag.
+func etagListMatches(list, etag string, weak bool) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether an If-Range value, an entity tag or a
+// date, still describes the object, by strong comparison.
+func ifRangeMatches(v, etag string, lastModified time.Time) bool {
+	if strings.HasPrefix(v, `"`) {
+		return v == etag
+	}
+	t, err := http.ParseTime(v)
+	return err == nil && !lastModified.IsZero() && lastModified.Equal(t)
+}
+
+// writePreconditionFailed writes S3's 412 response.
+func writePreconditionFailed(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	_ = xml.NewEncoder(w).Encode(&gofakes3.ErrorResponse{
+		Code:    gofakes3.ErrPreconditionFailed,
+		Message: "At least one of the pre-conditions you specified did not hold",
+	})
+}
+
+// partialContentWriter sends responses carrying a Content-Range with 206
+// Partial Content rather than 200.
+type partialContentWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *partialContentWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status == http.StatusOK && w.Header().Get("Content-Range") != "" {
+		status = http.StatusPartialContent
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *partialContentWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
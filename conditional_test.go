@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_ConditionalRanges(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	handler := lazyBackend.conditionalGuard(gofakes3.New(lazyBackend).Server())
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	lastModified := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, key := range []string{"cached.txt", "passthrough.txt"} {
+		meta := map[string]string{"Last-Modified": lastModified.Format(http.TimeFormat)}
+		if _, err := awsBackend.PutObject("test-bucket", key, meta, strings.NewReader("0123456789"), 10, nil); err != nil {
+			t.Fatalf("Failed to put object in AWS: %v", err)
+		}
+	}
+	readObject(t, lazyBackend, "cached.txt", nil)
+	lazyBackend.SetRangePassthrough(true, false)
+
+	get := func(key string, headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket/"+key, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	plain := get("cached.txt", nil)
+	etag := plain.Header().Get("ETag")
+	if etag == "" || plain.Header().Get("Last-Modified") == "" {
+		t.Fatalf("plain GET: ETag %q, Last-Modified %q", etag, plain.Header().Get("Last-Modified"))
+	}
+	before := lastModified.Add(-time.Hour).Format(http.TimeFormat)
+	after := lastModified.Add(time.Hour).Format(http.TimeFormat)
+
+	for _, key := range []string{"cached.txt", "passthrough.txt"} {
+		for _, tc := range []struct {
+			name    string
+			headers map[string]string
+			status  int
+			body    string
+		}{
+			{"range", nil, http.StatusPartialContent, "234"},
+			{"if-match", map[string]string{"If-Match": etag}, http.StatusPartialContent, "234"},
+			{"if-match star", map[string]string{"If-Match": "*"}, http.StatusPartialContent, "234"},
+			{"if-match mismatch", map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed, ""},
+			{"if-match weak", map[string]string{"If-Match": "W/" + etag}, http.StatusPreconditionFailed, ""},
+			{"if-match wins over if-unmodified-since", map[string]string{"If-Match": etag, "If-Unmodified-Since": before}, http.StatusPartialContent, "234"},
+			{"if-unmodified-since", map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed, ""},
+			{"if-none-match", map[string]string{"If-None-Match": etag}, http.StatusNotModified, ""},
+			{"if-none-match list", map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified, ""},
+			{"if-none-match mismatch", map[string]string{"If-None-Match": `"other"`}, http.StatusPartialContent, "234"},
+			{"if-none-match wins over if-modified-since", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": after}, http.StatusPartialContent, "234"},
+			{"if-modified-since", map[string]string{"If-Modified-Since": after}, http.StatusNotModified, ""},
+			{"if-modified-since earlier", map[string]string{"If-Modified-Since": before}, http.StatusPartialContent, "234"},
+			{"412 before 304", map[string]string{"If-Match": `"other"`, "If-None-Match": etag}, http.StatusPreconditionFailed, ""},
+			{"if-range", map[string]string{"If-Range": etag}, http.StatusPartialContent, "234"},
+			{"if-range mismatch", map[string]string{"If-Range": `"other"`}, http.StatusOK, "0123456789"},
+			{"if-range date mismatch", map[string]string{"If-Range": before}, http.StatusOK, "0123456789"},
+		} {
+			headers := map[string]string{"Range": "bytes=2-4"}
+			for k, v := range tc.headers {
+				headers[k] = v
+			}
+			rec := get(key, headers)
+			if rec.Code != tc.status {
+				t.Errorf("%s %s: status %d, want %d", key, tc.name, rec.Code, tc.status)
+				continue
+			}
+			if tc.body != "" && rec.Body.String() != tc.body {
+				t.Errorf("%s %s: body %q, want %q", key, tc.name, rec.Body.String(), tc.body)
+			}
+			if rec.Code == http.StatusNotModified && (rec.Header().Get("ETag") != etag || rec.Body.Len() != 0) {
+				t.Errorf("%s %s: 304 with ETag %q and %d body bytes", key, tc.name, rec.Header().Get("ETag"), rec.Body.Len())
+			}
+		}
+	}
+
+	if rec := get("missing.txt", map[string]string{"If-Match": etag}); rec.Code != http.StatusNotFound {
+		t.Errorf("missing object with If-Match: status %d, want 404", rec.Code)
+	}
+}
+
+func TestLazyBackend_ConditionalUpstreamETag(t *testing.T) {
+	lazyBackend, _, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	lazyBackend.index.add("test-bucket", "stored.txt", 10, `"upstream-etag"`)
+	// Objects without an MD5 get an empty ETag from gofakes3
+	handler := lazyBackend.conditionalGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `""`)
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	get := func(key string, headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/test-bucket/"+key, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		key     string
+		headers map[string]string
+		status  int
+	}{
+		{"stored.txt", map[string]string{"If-Match": `"upstream-etag"`}, http.StatusOK},
+		{"stored.txt", map[string]string{"If-Match": `""`}, http.StatusPreconditionFailed},
+		{"stored.txt", map[string]string{"If-None-Match": `"upstream-etag"`}, http.StatusNotModified},
+		// Without a known ETag, ETag conditions other than * are skipped
+		{"unknown.txt", map[string]string{"If-Match": `"anything"`}, http.StatusOK},
+		{"unknown.txt", map[string]string{"If-None-Match": `""`}, http.StatusOK},
+		{"unknown.txt", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+	} {
+		rec := get(tc.key, tc.headers)
+		if rec.Code != tc.status {
+			t.Errorf("%s %v: status %d, want %d", tc.key, tc.headers, rec.Code, tc.status)
+		}
+	}
+	if rec := get("stored.txt", nil); rec.Header().Get("ETag") != `""` {
+		t.Errorf("unconditional GET ETag = %q, want it untouched", rec.Header().Get("ETag"))
+	}
+	if rec := get("stored.txt", map[string]string{"If-Match": "*"}); rec.Header().Get("ETag") != `"upstream-etag"` {
+		t.Errorf("conditional GET ETag = %q, want the upstream ETag", rec.Header().Get("ETag"))
+	}
+}
+
+func TestLazyBackend_ConditionalMissSingleRequest(t *testing.T) {
+	_, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "file.txt", "0123456789")
+	var heads atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		}
+		gofakes3.New(awsBackend).Server().ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+	lazyBackend := NewLazyBackend(localBackend, newEndpointClient(t, upstream.URL))
+	if err := lazyBackend.SetUpstreamQuirks("generic"); err != nil {
+		t.Fatal(err)
+	}
+	handler := lazyBackend.conditionalGuard(gofakes3.New(lazyBackend).Server())
+
+	// The MD5 of the content, which the cache serves as the ETag
+	const etag = `"781e5e245d69b566979b86e28d23f2c7"`
+	req := httptest.NewRequest(http.MethodGet, "/test-bucket/file.txt", nil)
+	req.Header.Set("If-Match", etag)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Errorf("miss with a matching If-Match = %d %q, want the object", rec.Code, rec.Body)
+	}
+	if n := heads.Load(); n != 0 {
+		t.Errorf("%d HEAD(s) sent upstream for a conditional miss, want none", n)
+	}
+}
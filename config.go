@@ -1,9 +1,19 @@
 package main
 
 import (
-	"log"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,90 +23,1436 @@ type Config struct {
 	// Server settings
 	ListenAddr string `yaml:"listen_addr"`
 
+	// Address the /admin/ API listens on, apart from the S3 API, and the
+	// token its requests must carry. Binding beyond loopback requires a
+	// token
+	AdminListenAddr string `yaml:"admin_listen_addr"`
+	AdminToken      string `yaml:"admin_token"`
+
+	// Listener limits for exposure beyond localhost: concurrent connections
+	// (0 is unlimited), request header and body sizes (a body limit of 0 is
+	// unlimited) and timeouts for slow clients (0 disables each)
+	ListenMaxConnections int           `yaml:"listen_max_connections"`
+	ListenMaxHeaderBytes byteSize      `yaml:"listen_max_header_bytes"`
+	ListenMaxBodyBytes   byteSize      `yaml:"listen_max_body_bytes"`
+	ListenHeaderTimeout  time.Duration `yaml:"listen_header_timeout"`
+	ListenReadTimeout    time.Duration `yaml:"listen_read_timeout"`
+	ListenWriteTimeout   time.Duration `yaml:"listen_write_timeout"`
+	ListenIdleTimeout    time.Duration `yaml:"listen_idle_timeout"`
+
+	// How long shutdown waits for in-flight requests and background fetches
+	// to finish
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period"`
+
+	// Abort startup on problems that are otherwise logged as warnings, such
+	// as an unwritable data dir or an init bucket that can't be created
+	Strict bool `yaml:"strict"`
+
 	// Backend selection: "disk", "memory", or "localstack"
 	BackendType string `yaml:"backend_type"`
 
+	// Per-bucket backend types overriding BackendType, e.g. memory for small
+	// config buckets and localstack for buckets other services must see
+	BucketBackends map[string]string `yaml:"bucket_backends"`
+
+	// How often the memory backend snapshots its contents into the data
+	// dir, reloaded on startup so the cache survives restarts (0 disables)
+	MemorySnapshotInterval time.Duration `yaml:"memory_snapshot_interval"`
+
 	// Local disk backend settings
 	DataDir string `yaml:"data_dir"`
 
+	// Set when several replicas share DataDir over a network filesystem, so
+	// background jobs run only on the replica holding the leader lease
+	SharedDataDir bool `yaml:"shared_data_dir"`
+
+	// Stable identity used for the leader lease (default: hostname + listen address)
+	InstanceID string `yaml:"instance_id"`
+
 	// LocalStack settings (only used if backend_type is "localstack")
 	LocalStackEndpoint string `yaml:"localstack_endpoint"`
 
+	// Re-create init buckets and re-warm the warm manifest when LocalStack
+	// restarts and loses its state
+	LocalStackReseed bool `yaml:"localstack_reseed"`
+
 	// AWS settings (for upstream source)
 	AWSRegion string `yaml:"aws_region"`
 
+	// Named profile of the shared AWS config and credentials files to sign
+	// upstream requests with, instead of the default credential chain
+	AWSProfile string `yaml:"aws_profile"`
+
+	// IAM role assumed with the OIDC token in a file, as with IAM roles for
+	// service accounts on EKS; AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE
+	// are used if unset
+	AWSRoleARN              string `yaml:"aws_role_arn"`
+	AWSWebIdentityTokenFile string `yaml:"aws_web_identity_token_file"`
+
+	// S3-compatible upstream endpoints used instead of AWS. Requests rotate
+	// over them, and endpoints or addresses that fail are skipped for a while
+	UpstreamEndpoints []string `yaml:"upstream_endpoints"`
+
+	// A single S3-compatible endpoint used instead of AWS, such as MinIO,
+	// Cloudflare R2 or Wasabi; the shorthand of upstream_endpoints
+	UpstreamEndpoint string `yaml:"upstream_endpoint"`
+
+	// Address buckets on upstream endpoints as a path ("host/bucket") rather
+	// than a subdomain ("bucket.host")
+	UpstreamPathStyle bool `yaml:"upstream_path_style"`
+
+	// Static upstream credentials, instead of the AWS credential chain
+	UpstreamAccessKey string `yaml:"upstream_access_key"`
+	UpstreamSecretKey string `yaml:"upstream_secret_key"`
+
+	// Serve the files under this directory as upstream instead of AWS, from
+	// a built-in S3 server: each directory is a bucket. For demos and
+	// development without AWS credentials
+	MockUpstream string `yaml:"mock_upstream"`
+
+	// Identifies the upstream in the cache index, overriding the identity
+	// derived from the endpoints and credentials; objects cached under
+	// another namespace are dropped on startup
+	CacheNamespace string `yaml:"cache_namespace"`
+
+	// Compatibility quirks for non-AWS upstreams: "aws", "minio", "ceph" or "generic"
+	UpstreamQuirks string `yaml:"upstream_quirks"`
+
 	// Bucket mappings: local bucket name -> AWS bucket name
 	BucketMappings map[string]string `yaml:"bucket_mappings"`
 
-	// Buckets to create on startup
-	InitBuckets []string `yaml:"init_buckets"`
+	// Upstream bucket -> buckets keys missing from it are looked up in next,
+	// in order, e.g. a staging bucket falling back to production
+	BucketFallbacks map[string][]string `yaml:"bucket_fallbacks"`
+
+	// Local bucket -> its own upstream: bucket name, region, endpoint,
+	// credentials profile or keys and path style, e.g. for buckets in other
+	// AWS accounts
+	BucketUpstreams map[string]BucketUpstream `yaml:"bucket_upstreams"`
+
+	// Bucket aliases: extra local name -> local bucket it is served from,
+	// sharing that bucket's cache
+	BucketAliases map[string]string `yaml:"bucket_aliases"`
+
+	// URL sources: local bucket name -> URL template fetched over plain HTTP(S)
+	// instead of the S3 API, e.g. "https://cdn.example.com/{key}"
+	URLSources map[string]string `yaml:"url_sources"`
+
+	// Check URL sources with a HEAD request on every cache hit and re-fetch
+	// objects that changed upstream
+	URLSourceRevalidate bool `yaml:"url_source_revalidate"`
+
+	// How long objects fetched from upstream are served before being re-fetched
+	// (0 means never), and per-bucket overrides
+	CacheTTL   time.Duration            `yaml:"cache_ttl"`
+	BucketTTLs map[string]time.Duration `yaml:"bucket_ttls"`
+
+	// Shorten each object's TTL by a random share of up to this many percent,
+	// so objects cached together don't expire together (0 disables)
+	TTLJitterPercent int `yaml:"ttl_jitter_percent"`
+
+	// Take each object's TTL from the Cache-Control or Expires header
+	// upstream returns with it, kept between the min and max TTL (a max of
+	// 0 is unbounded); objects without either header keep the bucket's TTL
+	TTLFromHeaders    bool          `yaml:"ttl_from_headers"`
+	TTLFromHeadersMin time.Duration `yaml:"ttl_from_headers_min"`
+	TTLFromHeadersMax time.Duration `yaml:"ttl_from_headers_max"`
+
+	// Revalidate every cache hit with a conditional GET against upstream and
+	// refresh objects that changed
+	Revalidate bool `yaml:"revalidate"`
+
+	// Re-fetch objects with at least RefreshAheadMinHits hits since they were
+	// fetched once they are this close to their TTL (0 disables)
+	RefreshAhead        time.Duration `yaml:"refresh_ahead"`
+	RefreshAheadMinHits int           `yaml:"refresh_ahead_min_hits"`
+
+	// Maximum total size of objects cached from upstream before the least
+	// recently used are evicted, e.g. "10GiB" (0 means unlimited)
+	CacheMaxBytes byteSize `yaml:"cache_max_bytes"`
+
+	// Maximum number of objects cached from upstream in each bucket before
+	// the least recently used are evicted (0 means unlimited), and
+	// per-bucket overrides
+	MaxObjectsPerBucket int            `yaml:"max_objects_per_bucket"`
+	BucketMaxObjects    map[string]int `yaml:"bucket_max_objects"`
+
+	// Maximum total size of the objects clients write to each bucket, which
+	// are never evicted, e.g. "5GiB" (0 means unlimited), and per-bucket
+	// overrides; writes past it fail with QuotaExceeded
+	LocalQuota        byteSize            `yaml:"local_quota"`
+	BucketLocalQuotas map[string]byteSize `yaml:"bucket_local_quotas"`
+
+	// Order cached objects are evicted in when a limit is exceeded: "lru"
+	// (least recently used), "lfu" (least frequently used) or "arc"
+	// (adaptive replacement)
+	EvictionPolicy string `yaml:"eviction_policy"`
+
+	// Disk backend usage above which least recently used cached objects are
+	// trimmed, and the usage trimming stops at (default 80% of the high mark)
+	DiskHighWatermark byteSize `yaml:"disk_high_watermark"`
+	DiskLowWatermark  byteSize `yaml:"disk_low_watermark"`
+
+	// Compress objects written to the disk backend: "zstd" or "none".
+	// Objects already stored compressed are read either way
+	DiskCompression string `yaml:"disk_compression"`
+
+	// Objects up to this size are stored in shared pack files by the disk
+	// backend instead of a file each, e.g. "64KiB" (0 disables)
+	PackThreshold byteSize `yaml:"pack_threshold"`
+
+	// How often pack files are compacted to reclaim the space of deleted
+	// and overwritten objects (0 disables; POST /admin/packs/compact runs
+	// it on demand)
+	PackCompactInterval time.Duration `yaml:"pack_compact_interval"`
+
+	// AES-256 key objects written to the disk backend are encrypted with,
+	// hex or base64 encoded, given directly or read from a file
+	EncryptionKey     string `yaml:"encryption_key"`
+	EncryptionKeyFile string `yaml:"encryption_key_file"`
+
+	// Objects larger than this are streamed from upstream to the client
+	// without being cached, e.g. "1GiB" (0 caches everything)
+	StreamThreshold byteSize `yaml:"stream_threshold"`
+
+	// Local buckets whose upstream keys are kept in a bloom filter, so GETs
+	// of keys that don't exist are answered without asking upstream, and how
+	// often the filters are rebuilt from a listing
+	KeyFilterBuckets []string      `yaml:"key_filter_buckets"`
+	KeyFilterRefresh time.Duration `yaml:"key_filter_refresh"`
+
+	// How often objects followed with ?s3lazy-follow are checked upstream
+	// for appends
+	FollowInterval time.Duration `yaml:"follow_interval"`
+
+	// Range reads of uncached objects larger than this cache only the
+	// chunks of this size they touch instead of the whole object, e.g.
+	// "8MiB" (0 disables), within an optional budget (0 is unlimited)
+	ChunkSize          byteSize `yaml:"chunk_size"`
+	ChunkCacheMaxBytes byteSize `yaml:"chunk_cache_max_bytes"`
+
+	// Forward range reads of uncached objects to upstream instead of
+	// downloading the whole object first, optionally caching the whole
+	// object in the background afterwards
+	RangePassthrough    bool `yaml:"range_passthrough"`
+	RangeBackgroundFill bool `yaml:"range_background_fill"`
+
+	// Number of key path segments hit/miss statistics are aggregated at
+	// for the hot-prefix report (0 disables prefix statistics)
+	PrefixStatsDepth int `yaml:"prefix_stats_depth"`
+
+	// Number of keys whose recent events are kept for /admin/events (0
+	// disables the event log)
+	EventLogKeys int `yaml:"event_log_keys"`
+
+	// Latency and error objectives per operation, e.g. "get_hit:p99<20ms"
+	// or "get_miss:errors<1%", checked over a rolling window
+	SLOs      []string      `yaml:"slos"`
+	SLOWindow time.Duration `yaml:"slo_window"`
+
+	// Request header that identifies clients in request logs, per-identity
+	// statistics and the audit log, e.g. "X-Client-Id". Requests without it
+	// are identified by the access key they are signed with.
+	IdentityHeader string `yaml:"identity_header"`
+
+	// Keys ("bucket/key") and prefixes ("bucket/prefix*") that are never
+	// expired by TTL or evicted by LRU
+	Pins []string `yaml:"pins"`
+
+	// Data residency: local buckets allowed to cache upstream objects (all if
+	// empty) and buckets that never do; the others are proxy-only
+	CacheAllowBuckets []string `yaml:"cache_allow_buckets"`
+	CacheDenyBuckets  []string `yaml:"cache_deny_buckets"`
+
+	// Buckets whose objects clients may read but not write or delete
+	ReadOnlyBuckets []string `yaml:"read_only_buckets"`
+
+	// Glob patterns of keys that are proxy-only in every bucket, e.g. "*.tmp"
+	// or "logs/**"
+	NoCachePatterns []string `yaml:"no_cache_patterns"`
+
+	// Upstream bucket periodic cache usage reports are written to ("" disables),
+	// the key prefix they are written under and how often
+	ReportBucket   string        `yaml:"report_bucket"`
+	ReportPrefix   string        `yaml:"report_prefix"`
+	ReportInterval time.Duration `yaml:"report_interval"`
+
+	// Upstream bucket objects written by clients are backed up to ("" disables),
+	// the key prefix backups are written under and how often
+	BackupBucket   string        `yaml:"backup_bucket"`
+	BackupPrefix   string        `yaml:"backup_prefix"`
+	BackupInterval time.Duration `yaml:"backup_interval"`
+
+	// Shared S3 bucket looked up between the local cache and the origin, and
+	// written back to on origin fills ("" disables); the key prefix copies are
+	// kept under and the bucket's region, if not aws_region
+	TeamCacheBucket string `yaml:"team_cache_bucket"`
+	TeamCachePrefix string `yaml:"team_cache_prefix"`
+	TeamCacheRegion string `yaml:"team_cache_region"`
+
+	// Whether backups and team cache write-backs are read back with a HEAD
+	// and compared with what was sent
+	VerifyUploads bool `yaml:"verify_uploads"`
+
+	// URL cache fill, eviction and purge events are posted to as JSON ("" disables)
+	CacheEventWebhook string `yaml:"cache_event_webhook"`
+
+	// Message systems cache events and audit entries are published to, as
+	// nats://host:4222/subject or kafka+http(s)://rest-proxy:8082/topic URLs
+	EventSinks []string `yaml:"event_sinks"`
+
+	// Regular expressions scrubbed from objects before they are cached, and
+	// whether matches are replaced ("scrub") or keep the object out ("reject")
+	RedactPatterns []string `yaml:"redact_patterns"`
+	RedactAction   string   `yaml:"redact_action"`
+
+	// Rules applied by upstream object tag, e.g. never caching objects
+	// tagged pii=true; tags are only fetched when rules are set
+	TagRules []TagRule `yaml:"tag_rules"`
+
+	// Daily windows limiting upstream bandwidth and fetch concurrency, e.g.
+	// throttled fetches during work hours
+	TrafficSchedule []TrafficRule `yaml:"traffic_schedule"`
+
+	// Chaos testing: hide newly created objects from listings for this long,
+	// simulating list-after-write lag (0 disables)
+	ChaosListDelay time.Duration `yaml:"chaos_list_delay"`
+
+	// Buckets to create on startup, each either a name or an entry that
+	// also sets the bucket's upstream, cache policy, TTL, read-only mode
+	// and warm prefixes
+	InitBuckets []InitBucket `yaml:"init_buckets"`
+
+	// File listing "bucket/key" objects to fetch into the cache on startup
+	WarmManifest string `yaml:"warm_manifest"`
+
+	// Keep /readyz failing until the warm manifest has finished loading
+	ReadyAfterWarm bool `yaml:"ready_after_warm"`
+
+	// Upstream prefixes ("bucket/prefix") whose keys are all fetched into the
+	// cache on startup, and how many objects are fetched in parallel
+	Prefetch            []string `yaml:"prefetch"`
+	PrefetchConcurrency int      `yaml:"prefetch_concurrency"`
+
+	// Once a client reads a key it listed, fetch the keys listed after it in
+	// the background, this many at a time (0 disables), and at most this many
+	// bytes of them per listing page (0 means unlimited)
+	ListPrefetchConcurrency int      `yaml:"list_prefetch_concurrency"`
+	ListPrefetchMaxBytes    byteSize `yaml:"list_prefetch_max_bytes"`
+
+	// How long each kind of operation may take before it is abandoned, keyed
+	// by operation: head, get, list, tagging or localstack (unset means no limit)
+	OperationTimeouts map[string]time.Duration `yaml:"operation_timeouts"`
+
+	// When an upstream GET of an object of at most hedge_max_bytes hasn't
+	// answered after hedge_delay, send it again and use whichever answers
+	// first (0 disables)
+	HedgeDelay    time.Duration `yaml:"hedge_delay"`
+	HedgeMaxBytes byteSize      `yaml:"hedge_max_bytes"`
+
+	// Retries of failed upstream requests: how many attempts each gets in
+	// all (0 uses the SDK's 3, or AWS_MAX_ATTEMPTS), the longest wait between
+	// them (0 uses the SDK's 20s), and which failures are retried: the
+	// classes "connection", "server" and "throttling", or S3 error codes
+	// (unset retries all three classes)
+	UpstreamMaxAttempts int           `yaml:"upstream_max_attempts"`
+	UpstreamMaxBackoff  time.Duration `yaml:"upstream_max_backoff"`
+	UpstreamRetryOn     []string      `yaml:"upstream_retry_on"`
+
+	// How long connecting to upstream, TLS handshake included, may take (0
+	// uses the SDK's 30s), and how long an upstream response may go without
+	// sending any data, before or during its body (0 disables)
+	UpstreamConnectTimeout time.Duration `yaml:"upstream_connect_timeout"`
+	UpstreamReadTimeout    time.Duration `yaml:"upstream_read_timeout"`
+
+	// After this many upstream requests in a row fail to reach upstream,
+	// stop sending it requests for upstream_circuit_cooldown and serve only
+	// what is cached (0 disables)
+	UpstreamCircuitFailures int           `yaml:"upstream_circuit_failures"`
+	UpstreamCircuitCooldown time.Duration `yaml:"upstream_circuit_cooldown"`
+
+	// Let clients upload large objects straight to upstream through
+	// pre-signed multipart URLs from the admin API, valid for this long
+	PresignedUploads      bool          `yaml:"presigned_uploads"`
+	PresignedUploadExpiry time.Duration `yaml:"presigned_upload_expiry"`
+
+	// Sources records where each setting that isn't a default came from,
+	// keyed by its YAML name: "file <path>" or "env <VAR>"
+	Sources map[string]string `yaml:"-"`
 }
 
 // DefaultConfig returns configuration with sensible defaults
 func DefaultConfig() *Config {
 	return &Config{
-		ListenAddr:         ":9000",
-		BackendType:        "disk",
-		DataDir:            "/data",
-		LocalStackEndpoint: "http://localhost:4566",
-		AWSRegion:          "us-east-1",
-		BucketMappings:     make(map[string]string),
-		InitBuckets:        []string{},
+		ListenAddr:              ":9000",
+		AdminListenAddr:         "127.0.0.1:9001",
+		ListenMaxHeaderBytes:    defaultMaxHeaderBytes,
+		ListenHeaderTimeout:     defaultHeaderTimeout,
+		ListenIdleTimeout:       defaultIdleTimeout,
+		ShutdownGracePeriod:     defaultShutdownGracePeriod,
+		FollowInterval:          defaultFollowInterval,
+		KeyFilterRefresh:        defaultKeyFilterRefresh,
+		BackendType:             "disk",
+		DataDir:                 "/data",
+		LocalStackEndpoint:      "http://localhost:4566",
+		AWSRegion:               "us-east-1",
+		UpstreamPathStyle:       true,
+		UpstreamQuirks:          "aws",
+		EvictionPolicy:          "lru",
+		RefreshAheadMinHits:     defaultRefreshAheadMinHits,
+		ReportPrefix:            defaultReportPrefix,
+		ReportInterval:          defaultReportInterval,
+		BackupPrefix:            defaultBackupPrefix,
+		BackupInterval:          defaultBackupInterval,
+		BucketBackends:          make(map[string]string),
+		BucketMappings:          make(map[string]string),
+		BucketFallbacks:         make(map[string][]string),
+		BucketUpstreams:         make(map[string]BucketUpstream),
+		BucketAliases:           make(map[string]string),
+		BucketTTLs:              make(map[string]time.Duration),
+		BucketMaxObjects:        make(map[string]int),
+		BucketLocalQuotas:       make(map[string]byteSize),
+		URLSources:              make(map[string]string),
+		PrefixStatsDepth:        defaultPrefixStatsDepth,
+		SLOWindow:               defaultSLOWindow,
+		EventLogKeys:            defaultEventLogKeys,
+		PrefetchConcurrency:     defaultPrefetchConcurrency,
+		ListPrefetchMaxBytes:    defaultListPrefetchMaxBytes,
+		OperationTimeouts:       make(map[string]time.Duration),
+		HedgeMaxBytes:           defaultHedgeMaxBytes,
+		UpstreamConnectTimeout:  defaultUpstreamConnectTimeout,
+		UpstreamReadTimeout:     defaultUpstreamReadTimeout,
+		UpstreamCircuitCooldown: defaultCircuitCooldown,
+		PresignedUploadExpiry:   defaultPresignedUploadExpiry,
+		InitBuckets:             []InitBucket{},
+		Sources:                 make(map[string]string),
 	}
 }
 
 // LoadConfig loads configuration from file and environment variables.
 // Priority: Environment variables override config file values which override defaults.
-func LoadConfig() *Config {
+// Unknown fields and invalid values are all reported in the returned error
+// rather than silently replaced with defaults.
+func LoadConfig() (*Config, error) {
 	cfg := DefaultConfig()
+	var errs configErrors
 
 	// Load from config file if specified
 	if configFile := os.Getenv("S3LAZY_CONFIG_FILE"); configFile != "" {
-		data, err := os.ReadFile(configFile)
-		if err != nil {
-			log.Printf("Warning: failed to read config file %s: %v", configFile, err)
-		} else if err := yaml.Unmarshal(data, cfg); err != nil {
-			log.Printf("Warning: failed to parse config file %s: %v", configFile, err)
+		if err := cfg.loadFile(configFile); err != nil {
+			errs.add(err)
+		}
+	}
+
+	// env reads an environment variable, recording it as the source of a
+	// setting when it is set
+	known := map[string]bool{"S3LAZY_CONFIG_FILE": true}
+	env := func(name, field string) string {
+		known[name] = true
+		v := os.Getenv(name)
+		if v != "" {
+			cfg.Sources[field] = "env " + name
 		}
+		return v
 	}
 
 	// Environment variables override config file
-	if v := os.Getenv("S3LAZY_LISTEN_ADDR"); v != "" {
+	if v := env("S3LAZY_LISTEN_ADDR", "listen_addr"); v != "" {
 		cfg.ListenAddr = v
 	}
-	if v := os.Getenv("S3LAZY_BACKEND"); v != "" {
+	if v := env("S3LAZY_ADMIN_LISTEN_ADDR", "admin_listen_addr"); v != "" {
+		cfg.AdminListenAddr = v
+	}
+	if v := env("S3LAZY_ADMIN_TOKEN", "admin_token"); v != "" {
+		cfg.AdminToken = v
+	}
+	if v := env("S3LAZY_LISTEN_MAX_CONNECTIONS", "listen_max_connections"); v != "" {
+		cfg.ListenMaxConnections = errs.parseInt("S3LAZY_LISTEN_MAX_CONNECTIONS", v)
+	}
+	if v := env("S3LAZY_LISTEN_MAX_HEADER_BYTES", "listen_max_header_bytes"); v != "" {
+		cfg.ListenMaxHeaderBytes = errs.parseByteSize("S3LAZY_LISTEN_MAX_HEADER_BYTES", v)
+	}
+	if v := env("S3LAZY_LISTEN_MAX_BODY_BYTES", "listen_max_body_bytes"); v != "" {
+		cfg.ListenMaxBodyBytes = errs.parseByteSize("S3LAZY_LISTEN_MAX_BODY_BYTES", v)
+	}
+	if v := env("S3LAZY_LISTEN_HEADER_TIMEOUT", "listen_header_timeout"); v != "" {
+		cfg.ListenHeaderTimeout = errs.parseDuration("S3LAZY_LISTEN_HEADER_TIMEOUT", v)
+	}
+	if v := env("S3LAZY_LISTEN_READ_TIMEOUT", "listen_read_timeout"); v != "" {
+		cfg.ListenReadTimeout = errs.parseDuration("S3LAZY_LISTEN_READ_TIMEOUT", v)
+	}
+	if v := env("S3LAZY_LISTEN_WRITE_TIMEOUT", "listen_write_timeout"); v != "" {
+		cfg.ListenWriteTimeout = errs.parseDuration("S3LAZY_LISTEN_WRITE_TIMEOUT", v)
+	}
+	if v := env("S3LAZY_LISTEN_IDLE_TIMEOUT", "listen_idle_timeout"); v != "" {
+		cfg.ListenIdleTimeout = errs.parseDuration("S3LAZY_LISTEN_IDLE_TIMEOUT", v)
+	}
+	if v := env("S3LAZY_SHUTDOWN_GRACE_PERIOD", "shutdown_grace_period"); v != "" {
+		cfg.ShutdownGracePeriod = errs.parseDuration("S3LAZY_SHUTDOWN_GRACE_PERIOD", v)
+	}
+	if v := env("S3LAZY_STRICT", "strict"); v != "" {
+		cfg.Strict = errs.parseBool("S3LAZY_STRICT", v)
+	}
+	if v := env("S3LAZY_BACKEND", "backend_type"); v != "" {
 		cfg.BackendType = v
 	}
-	if v := os.Getenv("S3LAZY_DATA_DIR"); v != "" {
+	if v := env("S3LAZY_DATA_DIR", "data_dir"); v != "" {
 		cfg.DataDir = v
 	}
-	if v := os.Getenv("S3LAZY_LOCALSTACK_ENDPOINT"); v != "" {
+	if v := env("S3LAZY_SHARED_DATA_DIR", "shared_data_dir"); v != "" {
+		cfg.SharedDataDir = errs.parseBool("S3LAZY_SHARED_DATA_DIR", v)
+	}
+	if v := env("S3LAZY_INSTANCE_ID", "instance_id"); v != "" {
+		cfg.InstanceID = v
+	}
+	if v := env("S3LAZY_LOCALSTACK_ENDPOINT", "localstack_endpoint"); v != "" {
 		cfg.LocalStackEndpoint = v
 	}
-	if v := os.Getenv("S3LAZY_AWS_REGION"); v != "" {
+	if v := env("S3LAZY_LOCALSTACK_RESEED", "localstack_reseed"); v != "" {
+		cfg.LocalStackReseed = errs.parseBool("S3LAZY_LOCALSTACK_RESEED", v)
+	}
+	if v := env("S3LAZY_AWS_REGION", "aws_region"); v != "" {
 		cfg.AWSRegion = v
 	}
+	if v := env("S3LAZY_AWS_PROFILE", "aws_profile"); v != "" {
+		cfg.AWSProfile = v
+	}
+	if v := env("S3LAZY_AWS_ROLE_ARN", "aws_role_arn"); v != "" {
+		cfg.AWSRoleARN = v
+	}
+	if v := env("S3LAZY_AWS_WEB_IDENTITY_TOKEN_FILE", "aws_web_identity_token_file"); v != "" {
+		cfg.AWSWebIdentityTokenFile = v
+	}
+	if v := env("S3LAZY_UPSTREAM_ENDPOINTS", "upstream_endpoints"); v != "" {
+		cfg.UpstreamEndpoints = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_UPSTREAM_ENDPOINT", "upstream_endpoint"); v != "" {
+		cfg.UpstreamEndpoint = v
+	}
+	if v := env("S3LAZY_UPSTREAM_PATH_STYLE", "upstream_path_style"); v != "" {
+		cfg.UpstreamPathStyle = errs.parseBool("S3LAZY_UPSTREAM_PATH_STYLE", v)
+	}
+	if v := env("S3LAZY_UPSTREAM_ACCESS_KEY", "upstream_access_key"); v != "" {
+		cfg.UpstreamAccessKey = v
+	}
+	if v := env("S3LAZY_UPSTREAM_SECRET_KEY", "upstream_secret_key"); v != "" {
+		cfg.UpstreamSecretKey = v
+	}
+	if v := env("S3LAZY_MOCK_UPSTREAM", "mock_upstream"); v != "" {
+		cfg.MockUpstream = v
+	}
+	if v := env("S3LAZY_CACHE_NAMESPACE", "cache_namespace"); v != "" {
+		cfg.CacheNamespace = v
+	}
+	if v := env("S3LAZY_UPSTREAM_QUIRKS", "upstream_quirks"); v != "" {
+		cfg.UpstreamQuirks = v
+	}
 	// Also support standard AWS_REGION
-	if v := os.Getenv("AWS_REGION"); v != "" && os.Getenv("S3LAZY_AWS_REGION") == "" {
-		cfg.AWSRegion = v
+	if os.Getenv("S3LAZY_AWS_REGION") == "" {
+		if v := env("AWS_REGION", "aws_region"); v != "" {
+			cfg.AWSRegion = v
+		}
+	}
+	// And the variables EKS sets for IAM roles for service accounts
+	if cfg.AWSRoleARN == "" && cfg.AWSWebIdentityTokenFile == "" {
+		if v := env("AWS_ROLE_ARN", "aws_role_arn"); v != "" {
+			cfg.AWSRoleARN = v
+		}
+		if v := env("AWS_WEB_IDENTITY_TOKEN_FILE", "aws_web_identity_token_file"); v != "" {
+			cfg.AWSWebIdentityTokenFile = v
+		}
+	}
+
+	if v := env("S3LAZY_URL_SOURCE_REVALIDATE", "url_source_revalidate"); v != "" {
+		cfg.URLSourceRevalidate = errs.parseBool("S3LAZY_URL_SOURCE_REVALIDATE", v)
+	}
+
+	if v := env("S3LAZY_CACHE_TTL", "cache_ttl"); v != "" {
+		cfg.CacheTTL = errs.parseDuration("S3LAZY_CACHE_TTL", v)
+	}
+	if v := env("S3LAZY_TTL_JITTER_PERCENT", "ttl_jitter_percent"); v != "" {
+		cfg.TTLJitterPercent = errs.parseInt("S3LAZY_TTL_JITTER_PERCENT", v)
+	}
+	if v := env("S3LAZY_TTL_FROM_HEADERS", "ttl_from_headers"); v != "" {
+		cfg.TTLFromHeaders = errs.parseBool("S3LAZY_TTL_FROM_HEADERS", v)
+	}
+	if v := env("S3LAZY_TTL_FROM_HEADERS_MIN", "ttl_from_headers_min"); v != "" {
+		cfg.TTLFromHeadersMin = errs.parseDuration("S3LAZY_TTL_FROM_HEADERS_MIN", v)
+	}
+	if v := env("S3LAZY_TTL_FROM_HEADERS_MAX", "ttl_from_headers_max"); v != "" {
+		cfg.TTLFromHeadersMax = errs.parseDuration("S3LAZY_TTL_FROM_HEADERS_MAX", v)
+	}
+	// Parse per-bucket TTLs from "bucket1:5m,bucket2:1h" format
+	if v := env("S3LAZY_BUCKET_TTLS", "bucket_ttls"); v != "" {
+		ttls := make(map[string]string)
+		errs.parseMappings(ttls, "S3LAZY_BUCKET_TTLS", v)
+		for bucket, v := range ttls {
+			cfg.BucketTTLs[bucket] = errs.parseDuration("S3LAZY_BUCKET_TTLS "+bucket, v)
+		}
+	}
+	if v := env("S3LAZY_REVALIDATE", "revalidate"); v != "" {
+		cfg.Revalidate = errs.parseBool("S3LAZY_REVALIDATE", v)
+	}
+	if v := env("S3LAZY_REFRESH_AHEAD", "refresh_ahead"); v != "" {
+		cfg.RefreshAhead = errs.parseDuration("S3LAZY_REFRESH_AHEAD", v)
+	}
+	if v := env("S3LAZY_REFRESH_AHEAD_MIN_HITS", "refresh_ahead_min_hits"); v != "" {
+		cfg.RefreshAheadMinHits = errs.parseInt("S3LAZY_REFRESH_AHEAD_MIN_HITS", v)
+	}
+	if v := env("S3LAZY_CACHE_MAX_BYTES", "cache_max_bytes"); v != "" {
+		cfg.CacheMaxBytes = errs.parseByteSize("S3LAZY_CACHE_MAX_BYTES", v)
+	}
+	if v := env("S3LAZY_MAX_OBJECTS_PER_BUCKET", "max_objects_per_bucket"); v != "" {
+		cfg.MaxObjectsPerBucket = errs.parseInt("S3LAZY_MAX_OBJECTS_PER_BUCKET", v)
+	}
+	// Parse per-bucket object limits from "bucket1:1000,bucket2:50000" format
+	if v := env("S3LAZY_BUCKET_MAX_OBJECTS", "bucket_max_objects"); v != "" {
+		limits := make(map[string]string)
+		errs.parseMappings(limits, "S3LAZY_BUCKET_MAX_OBJECTS", v)
+		for bucket, v := range limits {
+			cfg.BucketMaxObjects[bucket] = errs.parseInt("S3LAZY_BUCKET_MAX_OBJECTS "+bucket, v)
+		}
+	}
+	if v := env("S3LAZY_LOCAL_QUOTA", "local_quota"); v != "" {
+		cfg.LocalQuota = errs.parseByteSize("S3LAZY_LOCAL_QUOTA", v)
+	}
+	// Parse per-bucket local quotas from "bucket1:1GiB,bucket2:500MiB" format
+	if v := env("S3LAZY_BUCKET_LOCAL_QUOTAS", "bucket_local_quotas"); v != "" {
+		quotas := make(map[string]string)
+		errs.parseMappings(quotas, "S3LAZY_BUCKET_LOCAL_QUOTAS", v)
+		for bucket, v := range quotas {
+			cfg.BucketLocalQuotas[bucket] = errs.parseByteSize("S3LAZY_BUCKET_LOCAL_QUOTAS "+bucket, v)
+		}
+	}
+	if v := env("S3LAZY_EVICTION_POLICY", "eviction_policy"); v != "" {
+		cfg.EvictionPolicy = v
+	}
+	if v := env("S3LAZY_DISK_HIGH_WATERMARK", "disk_high_watermark"); v != "" {
+		cfg.DiskHighWatermark = errs.parseByteSize("S3LAZY_DISK_HIGH_WATERMARK", v)
+	}
+	if v := env("S3LAZY_DISK_LOW_WATERMARK", "disk_low_watermark"); v != "" {
+		cfg.DiskLowWatermark = errs.parseByteSize("S3LAZY_DISK_LOW_WATERMARK", v)
+	}
+	if v := env("S3LAZY_DISK_COMPRESSION", "disk_compression"); v != "" {
+		cfg.DiskCompression = v
+	}
+	if v := env("S3LAZY_PACK_THRESHOLD", "pack_threshold"); v != "" {
+		cfg.PackThreshold = errs.parseByteSize("S3LAZY_PACK_THRESHOLD", v)
+	}
+	if v := env("S3LAZY_PACK_COMPACT_INTERVAL", "pack_compact_interval"); v != "" {
+		cfg.PackCompactInterval = errs.parseDuration("S3LAZY_PACK_COMPACT_INTERVAL", v)
+	}
+	if v := env("S3LAZY_ENCRYPTION_KEY", "encryption_key"); v != "" {
+		cfg.EncryptionKey = v
+	}
+	if v := env("S3LAZY_ENCRYPTION_KEY_FILE", "encryption_key_file"); v != "" {
+		cfg.EncryptionKeyFile = v
+	}
+	if v := env("S3LAZY_STREAM_THRESHOLD", "stream_threshold"); v != "" {
+		cfg.StreamThreshold = errs.parseByteSize("S3LAZY_STREAM_THRESHOLD", v)
+	}
+	if v := env("S3LAZY_KEY_FILTER_BUCKETS", "key_filter_buckets"); v != "" {
+		cfg.KeyFilterBuckets = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_KEY_FILTER_REFRESH", "key_filter_refresh"); v != "" {
+		cfg.KeyFilterRefresh = errs.parseDuration("S3LAZY_KEY_FILTER_REFRESH", v)
+	}
+	if v := env("S3LAZY_FOLLOW_INTERVAL", "follow_interval"); v != "" {
+		cfg.FollowInterval = errs.parseDuration("S3LAZY_FOLLOW_INTERVAL", v)
+	}
+	if v := env("S3LAZY_CHUNK_SIZE", "chunk_size"); v != "" {
+		cfg.ChunkSize = errs.parseByteSize("S3LAZY_CHUNK_SIZE", v)
+	}
+	if v := env("S3LAZY_CHUNK_CACHE_MAX_BYTES", "chunk_cache_max_bytes"); v != "" {
+		cfg.ChunkCacheMaxBytes = errs.parseByteSize("S3LAZY_CHUNK_CACHE_MAX_BYTES", v)
+	}
+	if v := env("S3LAZY_RANGE_PASSTHROUGH", "range_passthrough"); v != "" {
+		cfg.RangePassthrough = errs.parseBool("S3LAZY_RANGE_PASSTHROUGH", v)
+	}
+	if v := env("S3LAZY_RANGE_BACKGROUND_FILL", "range_background_fill"); v != "" {
+		cfg.RangeBackgroundFill = errs.parseBool("S3LAZY_RANGE_BACKGROUND_FILL", v)
+	}
+
+	if v := env("S3LAZY_TRAFFIC_SCHEDULE", "traffic_schedule"); v != "" {
+		rules, err := parseTrafficSchedule(v)
+		if err != nil {
+			errs.addf("S3LAZY_TRAFFIC_SCHEDULE: %v", err)
+		}
+		cfg.TrafficSchedule = rules
+	}
+	if v := env("S3LAZY_CHAOS_LIST_DELAY", "chaos_list_delay"); v != "" {
+		cfg.ChaosListDelay = errs.parseDuration("S3LAZY_CHAOS_LIST_DELAY", v)
+	}
+
+	if v := env("S3LAZY_PREFIX_STATS_DEPTH", "prefix_stats_depth"); v != "" {
+		cfg.PrefixStatsDepth = errs.parseInt("S3LAZY_PREFIX_STATS_DEPTH", v)
+	}
+	if v := env("S3LAZY_EVENT_LOG_KEYS", "event_log_keys"); v != "" {
+		cfg.EventLogKeys = errs.parseInt("S3LAZY_EVENT_LOG_KEYS", v)
+	}
+	if v := env("S3LAZY_SLOS", "slos"); v != "" {
+		cfg.SLOs = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_SLO_WINDOW", "slo_window"); v != "" {
+		cfg.SLOWindow = errs.parseDuration("S3LAZY_SLO_WINDOW", v)
+	}
+	if v := env("S3LAZY_IDENTITY_HEADER", "identity_header"); v != "" {
+		cfg.IdentityHeader = v
 	}
 
 	// Parse init buckets from comma-separated list
-	if v := os.Getenv("S3LAZY_INIT_BUCKETS"); v != "" {
-		cfg.InitBuckets = parseCommaSeparated(v)
+	if v := env("S3LAZY_INIT_BUCKETS", "init_buckets"); v != "" {
+		cfg.InitBuckets = nil
+		for _, name := range parseCommaSeparated(v) {
+			cfg.InitBuckets = append(cfg.InitBuckets, InitBucket{Name: name})
+		}
+	}
+
+	if v := env("S3LAZY_PINS", "pins"); v != "" {
+		cfg.Pins = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_CACHE_ALLOW_BUCKETS", "cache_allow_buckets"); v != "" {
+		cfg.CacheAllowBuckets = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_CACHE_DENY_BUCKETS", "cache_deny_buckets"); v != "" {
+		cfg.CacheDenyBuckets = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_READ_ONLY_BUCKETS", "read_only_buckets"); v != "" {
+		cfg.ReadOnlyBuckets = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_NO_CACHE_PATTERNS", "no_cache_patterns"); v != "" {
+		cfg.NoCachePatterns = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_REPORT_BUCKET", "report_bucket"); v != "" {
+		cfg.ReportBucket = v
+	}
+	if v := env("S3LAZY_REPORT_PREFIX", "report_prefix"); v != "" {
+		cfg.ReportPrefix = v
+	}
+	if v := env("S3LAZY_REPORT_INTERVAL", "report_interval"); v != "" {
+		cfg.ReportInterval = errs.parseDuration("S3LAZY_REPORT_INTERVAL", v)
+	}
+	if v := env("S3LAZY_BACKUP_BUCKET", "backup_bucket"); v != "" {
+		cfg.BackupBucket = v
+	}
+	if v := env("S3LAZY_BACKUP_PREFIX", "backup_prefix"); v != "" {
+		cfg.BackupPrefix = v
+	}
+	if v := env("S3LAZY_BACKUP_INTERVAL", "backup_interval"); v != "" {
+		cfg.BackupInterval = errs.parseDuration("S3LAZY_BACKUP_INTERVAL", v)
+	}
+	if v := env("S3LAZY_TEAM_CACHE_BUCKET", "team_cache_bucket"); v != "" {
+		cfg.TeamCacheBucket = v
+	}
+	if v := env("S3LAZY_TEAM_CACHE_PREFIX", "team_cache_prefix"); v != "" {
+		cfg.TeamCachePrefix = v
+	}
+	if v := env("S3LAZY_TEAM_CACHE_REGION", "team_cache_region"); v != "" {
+		cfg.TeamCacheRegion = v
+	}
+	if v := env("S3LAZY_VERIFY_UPLOADS", "verify_uploads"); v != "" {
+		cfg.VerifyUploads = errs.parseBool("S3LAZY_VERIFY_UPLOADS", v)
+	}
+	if v := env("S3LAZY_CACHE_EVENT_WEBHOOK", "cache_event_webhook"); v != "" {
+		cfg.CacheEventWebhook = v
+	}
+	if v := env("S3LAZY_EVENT_SINKS", "event_sinks"); v != "" {
+		cfg.EventSinks = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_REDACT_PATTERNS", "redact_patterns"); v != "" {
+		cfg.RedactPatterns = parseRedactPatterns(v)
+	}
+	if v := env("S3LAZY_REDACT_ACTION", "redact_action"); v != "" {
+		cfg.RedactAction = v
+	}
+	if v := env("S3LAZY_TAG_RULES", "tag_rules"); v != "" {
+		rules, err := parseTagRules(v)
+		if err != nil {
+			errs.addf("S3LAZY_TAG_RULES: %v", err)
+		}
+		cfg.TagRules = rules
+	}
+
+	if v := env("S3LAZY_WARM_MANIFEST", "warm_manifest"); v != "" {
+		cfg.WarmManifest = v
+	}
+	if v := env("S3LAZY_READY_AFTER_WARM", "ready_after_warm"); v != "" {
+		cfg.ReadyAfterWarm = errs.parseBool("S3LAZY_READY_AFTER_WARM", v)
+	}
+
+	if v := env("S3LAZY_PREFETCH", "prefetch"); v != "" {
+		cfg.Prefetch = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_PREFETCH_CONCURRENCY", "prefetch_concurrency"); v != "" {
+		cfg.PrefetchConcurrency = errs.parseInt("S3LAZY_PREFETCH_CONCURRENCY", v)
+	}
+	if v := env("S3LAZY_LIST_PREFETCH_CONCURRENCY", "list_prefetch_concurrency"); v != "" {
+		cfg.ListPrefetchConcurrency = errs.parseInt("S3LAZY_LIST_PREFETCH_CONCURRENCY", v)
+	}
+	if v := env("S3LAZY_LIST_PREFETCH_MAX_BYTES", "list_prefetch_max_bytes"); v != "" {
+		cfg.ListPrefetchMaxBytes = errs.parseByteSize("S3LAZY_LIST_PREFETCH_MAX_BYTES", v)
+	}
+	// Parse operation timeouts from "head:5s,get:10m" format
+	if v := env("S3LAZY_OPERATION_TIMEOUTS", "operation_timeouts"); v != "" {
+		timeouts := make(map[string]string)
+		errs.parseMappings(timeouts, "S3LAZY_OPERATION_TIMEOUTS", v)
+		for op, v := range timeouts {
+			cfg.OperationTimeouts[op] = errs.parseDuration("S3LAZY_OPERATION_TIMEOUTS "+op, v)
+		}
+	}
+	if v := env("S3LAZY_HEDGE_DELAY", "hedge_delay"); v != "" {
+		cfg.HedgeDelay = errs.parseDuration("S3LAZY_HEDGE_DELAY", v)
+	}
+	if v := env("S3LAZY_HEDGE_MAX_BYTES", "hedge_max_bytes"); v != "" {
+		cfg.HedgeMaxBytes = errs.parseByteSize("S3LAZY_HEDGE_MAX_BYTES", v)
+	}
+	if v := env("S3LAZY_UPSTREAM_MAX_ATTEMPTS", "upstream_max_attempts"); v != "" {
+		cfg.UpstreamMaxAttempts = errs.parseInt("S3LAZY_UPSTREAM_MAX_ATTEMPTS", v)
+	}
+	if v := env("S3LAZY_UPSTREAM_MAX_BACKOFF", "upstream_max_backoff"); v != "" {
+		cfg.UpstreamMaxBackoff = errs.parseDuration("S3LAZY_UPSTREAM_MAX_BACKOFF", v)
+	}
+	if v := env("S3LAZY_UPSTREAM_RETRY_ON", "upstream_retry_on"); v != "" {
+		cfg.UpstreamRetryOn = parseCommaSeparated(v)
+	}
+	if v := env("S3LAZY_UPSTREAM_CONNECT_TIMEOUT", "upstream_connect_timeout"); v != "" {
+		cfg.UpstreamConnectTimeout = errs.parseDuration("S3LAZY_UPSTREAM_CONNECT_TIMEOUT", v)
+	}
+	if v := env("S3LAZY_UPSTREAM_READ_TIMEOUT", "upstream_read_timeout"); v != "" {
+		cfg.UpstreamReadTimeout = errs.parseDuration("S3LAZY_UPSTREAM_READ_TIMEOUT", v)
+	}
+	if v := env("S3LAZY_UPSTREAM_CIRCUIT_FAILURES", "upstream_circuit_failures"); v != "" {
+		cfg.UpstreamCircuitFailures = errs.parseInt("S3LAZY_UPSTREAM_CIRCUIT_FAILURES", v)
+	}
+	if v := env("S3LAZY_UPSTREAM_CIRCUIT_COOLDOWN", "upstream_circuit_cooldown"); v != "" {
+		cfg.UpstreamCircuitCooldown = errs.parseDuration("S3LAZY_UPSTREAM_CIRCUIT_COOLDOWN", v)
+	}
+	if v := env("S3LAZY_PRESIGNED_UPLOADS", "presigned_uploads"); v != "" {
+		cfg.PresignedUploads = errs.parseBool("S3LAZY_PRESIGNED_UPLOADS", v)
+	}
+	if v := env("S3LAZY_PRESIGNED_UPLOAD_EXPIRY", "presigned_upload_expiry"); v != "" {
+		cfg.PresignedUploadExpiry = errs.parseDuration("S3LAZY_PRESIGNED_UPLOAD_EXPIRY", v)
 	}
 
 	// Parse bucket mappings from "local1:aws1,local2:aws2" format
-	if v := os.Getenv("S3LAZY_BUCKET_MAP"); v != "" {
-		for _, mapping := range parseCommaSeparated(v) {
-			parts := strings.SplitN(mapping, ":", 2)
-			if len(parts) == 2 {
-				cfg.BucketMappings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	if v := env("S3LAZY_BUCKET_MAP", "bucket_mappings"); v != "" {
+		errs.parseMappings(cfg.BucketMappings, "S3LAZY_BUCKET_MAP", v)
+	}
+
+	// Parse bucket fallbacks from "staging1:prod1|dr1,staging2:prod2" format
+	if v := env("S3LAZY_BUCKET_FALLBACKS", "bucket_fallbacks"); v != "" {
+		chains := make(map[string]string)
+		errs.parseMappings(chains, "S3LAZY_BUCKET_FALLBACKS", v)
+		for bucket, chain := range chains {
+			cfg.BucketFallbacks[bucket] = strings.Split(chain, "|")
+		}
+	}
+
+	// Parse bucket aliases from "alias1:bucket1,alias2:bucket1" format
+	if v := env("S3LAZY_BUCKET_ALIASES", "bucket_aliases"); v != "" {
+		errs.parseMappings(cfg.BucketAliases, "S3LAZY_BUCKET_ALIASES", v)
+	}
+
+	// Parse per-bucket backends from "bucket1:memory,bucket2:localstack" format
+	if v := env("S3LAZY_BUCKET_BACKENDS", "bucket_backends"); v != "" {
+		errs.parseMappings(cfg.BucketBackends, "S3LAZY_BUCKET_BACKENDS", v)
+	}
+
+	if v := env("S3LAZY_MEMORY_SNAPSHOT_INTERVAL", "memory_snapshot_interval"); v != "" {
+		cfg.MemorySnapshotInterval = errs.parseDuration("S3LAZY_MEMORY_SNAPSHOT_INTERVAL", v)
+	}
+
+	// Parse URL sources from "local1:https://host/{key},local2:..." format
+	if v := env("S3LAZY_URL_SOURCES", "url_sources"); v != "" {
+		errs.parseMappings(cfg.URLSources, "S3LAZY_URL_SOURCES", v)
+	}
+
+	// Init bucket entries stand for settings of their own bucket
+	cfg.applyInitBuckets(&errs)
+	cfg.applyBucketUpstreams(&errs)
+
+	// A misspelled variable is silently ignored otherwise
+	if cfg.Strict {
+		for _, kv := range os.Environ() {
+			name, _, _ := strings.Cut(kv, "=")
+			if strings.HasPrefix(name, "S3LAZY_") && !known[name] {
+				errs.addf("%s: unknown environment variable", name)
+			}
+		}
+	}
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile merges a YAML config file into cfg, rejecting unknown fields.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			msgs := make([]string, len(typeErr.Errors))
+			for i, msg := range typeErr.Errors {
+				msgs[i] = path + ": " + explainUnknownField(msg)
+			}
+			return errors.New(strings.Join(msgs, "\n"))
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var keys map[string]yaml.Node
+	if yaml.Unmarshal(data, &keys) == nil {
+		for key := range keys {
+			c.Sources[key] = "file " + path
+		}
+	}
+	return nil
+}
+
+var unknownFieldRe = regexp.MustCompile(`field (\S+) not found in type main\.Config`)
+
+// explainUnknownField rewrites the YAML decoder's unknown-field message,
+// suggesting the snake_case name when a camelCase spelling was used.
+func explainUnknownField(msg string) string {
+	m := unknownFieldRe.FindStringSubmatchIndex(msg)
+	if m == nil {
+		return msg
+	}
+	field := msg[m[2]:m[3]]
+	out := msg[:m[0]] + "unknown field " + strconv.Quote(field)
+	if snake := toSnakeCase(field); snake != field && knownConfigFields()[snake] {
+		out += fmt.Sprintf(" (did you mean %q?)", snake)
+	}
+	return out + msg[m[1]:]
+}
+
+// toSnakeCase converts camelCase to snake_case.
+func toSnakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// knownConfigFields returns the YAML names of every config field.
+func knownConfigFields() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// validBackendTypes are the accepted local backend types.
+var validBackendTypes = map[string]bool{"disk": true, "memory": true, "localstack": true}
+
+// upstreamEndpoints returns the S3-compatible endpoints used instead of AWS,
+// if any.
+func (c *Config) upstreamEndpoints() []string {
+	if c.UpstreamEndpoint != "" {
+		return []string{c.UpstreamEndpoint}
+	}
+	return c.UpstreamEndpoints
+}
+
+// Validate checks that every setting has a usable value.
+func (c *Config) Validate() error {
+	var errs configErrors
+	if c.AdminListenAddr == c.ListenAddr {
+		errs.addf("admin_listen_addr: must differ from listen_addr %s", c.ListenAddr)
+	} else if c.AdminToken == "" && !isLoopbackAddr(c.AdminListenAddr) {
+		errs.addf("admin_listen_addr: %s is reachable from other hosts; set admin_token", c.AdminListenAddr)
+	}
+	if c.ListenMaxConnections < 0 {
+		errs.addf("listen_max_connections: must not be negative, got %d", c.ListenMaxConnections)
+	}
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"listen_header_timeout", c.ListenHeaderTimeout},
+		{"listen_read_timeout", c.ListenReadTimeout},
+		{"listen_write_timeout", c.ListenWriteTimeout},
+		{"listen_idle_timeout", c.ListenIdleTimeout},
+	} {
+		if t.d < 0 {
+			errs.addf("%s: must not be negative, got %v", t.name, t.d)
+		}
+	}
+	if len(c.KeyFilterBuckets) > 0 && c.KeyFilterRefresh <= 0 {
+		errs.addf("key_filter_refresh: must be positive, got %v", c.KeyFilterRefresh)
+	}
+	for _, bucket := range c.KeyFilterBuckets {
+		upstream := bucket
+		if mapped, ok := c.BucketMappings[bucket]; ok {
+			upstream = mapped
+		}
+		switch {
+		case c.URLSources[bucket] != "":
+			errs.addf("key_filter_buckets: %s is a URL source, which can't be listed", bucket)
+		case len(c.BucketFallbacks[upstream]) > 0:
+			errs.addf("key_filter_buckets: %s falls back to other buckets, whose keys the filter wouldn't know", bucket)
+		}
+	}
+	if c.FollowInterval <= 0 {
+		errs.addf("follow_interval: must be positive, got %v", c.FollowInterval)
+	}
+	if c.ShutdownGracePeriod <= 0 {
+		errs.addf("shutdown_grace_period: must be positive, got %v", c.ShutdownGracePeriod)
+	}
+	if !validBackendTypes[c.BackendType] {
+		errs.addf("backend_type: unknown backend %q (valid options: disk, memory, localstack)", c.BackendType)
+	}
+	for bucket, backendType := range c.BucketBackends {
+		if !validBackendTypes[backendType] {
+			errs.addf("bucket_backends: bucket %s: unknown backend %q (valid options: disk, memory, localstack)", bucket, backendType)
+		}
+	}
+	if c.MemorySnapshotInterval < 0 {
+		errs.addf("memory_snapshot_interval: must not be negative, got %v", c.MemorySnapshotInterval)
+	}
+	if _, err := lookupQuirks(c.UpstreamQuirks); err != nil {
+		errs.addf("upstream_quirks: %v", err)
+	}
+	if _, err := lookupEvictionPolicy(c.EvictionPolicy); err != nil {
+		errs.addf("eviction_policy: %v", err)
+	}
+	for _, endpoint := range c.UpstreamEndpoints {
+		if err := validateEndpoint(endpoint); err != nil {
+			errs.addf("upstream_endpoints: %v", err)
+		}
+	}
+	if c.UpstreamEndpoint != "" {
+		if err := validateEndpoint(c.UpstreamEndpoint); err != nil {
+			errs.addf("upstream_endpoint: %v", err)
+		}
+		if len(c.UpstreamEndpoints) > 0 {
+			errs.addf("upstream_endpoint: set either upstream_endpoint or upstream_endpoints, not both")
+		}
+	}
+	if (c.UpstreamAccessKey == "") != (c.UpstreamSecretKey == "") {
+		errs.addf("upstream_access_key, upstream_secret_key: set both or neither")
+	}
+	if c.UpstreamAccessKey != "" && c.AWSProfile != "" {
+		errs.addf("aws_profile: set either aws_profile or upstream_access_key, not both")
+	}
+	if (c.AWSRoleARN == "") != (c.AWSWebIdentityTokenFile == "") {
+		errs.addf("aws_role_arn, aws_web_identity_token_file: set both or neither")
+	}
+	if c.AWSWebIdentityTokenFile != "" {
+		if _, err := os.Stat(c.AWSWebIdentityTokenFile); err != nil {
+			errs.addf("aws_web_identity_token_file: %v", err)
+		}
+	}
+	if c.MockUpstream != "" {
+		if info, err := os.Stat(c.MockUpstream); err != nil {
+			errs.addf("mock_upstream: %v", err)
+		} else if !info.IsDir() {
+			errs.addf("mock_upstream: %s is not a directory", c.MockUpstream)
+		}
+		if len(c.upstreamEndpoints()) > 0 {
+			errs.addf("mock_upstream: set either mock_upstream or upstream_endpoints, not both")
+		}
+	}
+	for bucket, template := range c.URLSources {
+		if _, err := newHTTPSource(template); err != nil {
+			errs.addf("url_sources: bucket %s: %v", bucket, err)
+		}
+	}
+	for local, upstream := range c.BucketMappings {
+		if local == "" || upstream == "" {
+			errs.addf("bucket_mappings: %q -> %q: bucket names must not be empty", local, upstream)
+		}
+	}
+	c.validateBucketUpstreams(&errs)
+	for bucket, chain := range c.BucketFallbacks {
+		for i, fallback := range chain {
+			switch {
+			case strings.TrimSpace(fallback) == "":
+				errs.addf("bucket_fallbacks: %s: bucket names must not be empty", bucket)
+			case fallback == bucket || slices.Contains(chain[:i], fallback):
+				errs.addf("bucket_fallbacks: %s: %s is in the chain twice", bucket, fallback)
+			}
+		}
+	}
+	for alias, bucket := range c.BucketAliases {
+		switch {
+		case alias == "" || bucket == "":
+			errs.addf("bucket_aliases: %q -> %q: bucket names must not be empty", alias, bucket)
+		case alias == bucket:
+			errs.addf("bucket_aliases: %s is an alias of itself", alias)
+		case c.BucketAliases[bucket] != "":
+			errs.addf("bucket_aliases: %s -> %s: %s is itself an alias", alias, bucket, bucket)
+		}
+		for setting, buckets := range map[string]map[string]string{
+			"bucket_mappings": c.BucketMappings,
+			"url_sources":     c.URLSources,
+			"bucket_backends": c.BucketBackends,
+		} {
+			if _, ok := buckets[alias]; ok {
+				errs.addf("bucket_aliases: %s is an alias, so its %s entry would never be used; configure %s instead", alias, setting, bucket)
 			}
 		}
 	}
+	if c.CacheTTL < 0 {
+		errs.addf("cache_ttl: must not be negative, got %v", c.CacheTTL)
+	}
+	switch {
+	case c.TTLFromHeadersMin < 0:
+		errs.addf("ttl_from_headers_min: must not be negative, got %v", c.TTLFromHeadersMin)
+	case c.TTLFromHeadersMax < 0:
+		errs.addf("ttl_from_headers_max: must not be negative, got %v", c.TTLFromHeadersMax)
+	case c.TTLFromHeadersMax > 0 && c.TTLFromHeadersMax < c.TTLFromHeadersMin:
+		errs.addf("ttl_from_headers_max: %v is below ttl_from_headers_min %v", c.TTLFromHeadersMax, c.TTLFromHeadersMin)
+	}
+	if c.TTLJitterPercent < 0 || c.TTLJitterPercent >= 100 {
+		errs.addf("ttl_jitter_percent: must be between 0 and 99, got %d", c.TTLJitterPercent)
+	}
+	for bucket, ttl := range c.BucketTTLs {
+		if ttl < 0 {
+			errs.addf("bucket_ttls: bucket %s: must not be negative, got %v", bucket, ttl)
+		}
+	}
+	if c.RefreshAhead < 0 {
+		errs.addf("refresh_ahead: must not be negative, got %v", c.RefreshAhead)
+	}
+	if c.RefreshAheadMinHits < 1 {
+		errs.addf("refresh_ahead_min_hits: must be at least 1, got %d", c.RefreshAheadMinHits)
+	}
+	if c.MaxObjectsPerBucket < 0 {
+		errs.addf("max_objects_per_bucket: must not be negative, got %d", c.MaxObjectsPerBucket)
+	}
+	for bucket, limit := range c.BucketMaxObjects {
+		if limit < 0 {
+			errs.addf("bucket_max_objects: bucket %s: must not be negative, got %d", bucket, limit)
+		}
+	}
+	for _, pattern := range c.Pins {
+		if _, err := parsePin(pattern); err != nil {
+			errs.addf("pins: %v", err)
+		}
+	}
+	if _, err := newRegexRedactor(nil, c.RedactAction); err != nil {
+		errs.addf("redact_action: %v", err)
+	}
+	for _, p := range c.RedactPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			errs.addf("redact_patterns: invalid pattern %q: %v", p, err)
+		}
+	}
+	if c.ReportBucket != "" && c.ReportInterval <= 0 {
+		errs.addf("report_interval: must be positive, got %v", c.ReportInterval)
+	}
+	if c.BackupBucket != "" && c.BackupInterval <= 0 {
+		errs.addf("backup_interval: must be positive, got %v", c.BackupInterval)
+	}
+	if c.TeamCacheBucket == "" && (c.TeamCachePrefix != "" || c.TeamCacheRegion != "") {
+		errs.addf("team_cache_prefix, team_cache_region: need team_cache_bucket")
+	}
+	if c.CacheEventWebhook != "" {
+		if err := validateEndpoint(c.CacheEventWebhook); err != nil {
+			errs.addf("cache_event_webhook: %v", err)
+		}
+	}
+	for _, sink := range c.EventSinks {
+		if _, err := newEventSink(sink); err != nil {
+			errs.addf("event_sinks: %v", err)
+		}
+	}
+	for _, pattern := range c.NoCachePatterns {
+		if err := checkGlob(pattern); err != nil {
+			errs.addf("no_cache_patterns: %v", err)
+		}
+	}
+	for _, rule := range c.TagRules {
+		if err := validateTagRule(rule); err != nil {
+			errs.addf("tag_rules: %v", err)
+		}
+	}
+	for _, rule := range c.TrafficSchedule {
+		if _, err := parseTrafficRule(rule); err != nil {
+			errs.addf("traffic_schedule: %v", err)
+		}
+	}
+	for _, prefix := range c.Prefetch {
+		if bucket, _, _ := strings.Cut(prefix, "/"); bucket == "" {
+			errs.addf("prefetch: %q must start with a bucket name", prefix)
+		}
+	}
+	if c.PrefetchConcurrency < 1 {
+		errs.addf("prefetch_concurrency: must be at least 1, got %d", c.PrefetchConcurrency)
+	}
+	if c.ListPrefetchConcurrency < 0 {
+		errs.addf("list_prefetch_concurrency: must not be negative, got %d", c.ListPrefetchConcurrency)
+	}
+	for op, timeout := range c.OperationTimeouts {
+		if !slices.Contains(timeoutOperations, op) {
+			errs.addf("operation_timeouts: unknown operation %q (want one of %s)", op, strings.Join(timeoutOperations, ", "))
+		} else if timeout < 0 {
+			errs.addf("operation_timeouts: %s: must not be negative, got %v", op, timeout)
+		}
+	}
+	if c.HedgeDelay < 0 {
+		errs.addf("hedge_delay: must not be negative, got %v", c.HedgeDelay)
+	}
+	if c.HedgeDelay > 0 && c.HedgeMaxBytes < 1 {
+		errs.addf("hedge_max_bytes: must be at least 1 when hedge_delay is set, got %d", c.HedgeMaxBytes)
+	}
+	if c.UpstreamMaxAttempts < 0 {
+		errs.addf("upstream_max_attempts: must not be negative, got %d", c.UpstreamMaxAttempts)
+	}
+	if c.UpstreamMaxBackoff < 0 {
+		errs.addf("upstream_max_backoff: must not be negative, got %v", c.UpstreamMaxBackoff)
+	}
+	if err := validateRetryOn(c.UpstreamRetryOn); err != nil {
+		errs.addf("upstream_retry_on: %v", err)
+	}
+	if c.UpstreamConnectTimeout < 0 {
+		errs.addf("upstream_connect_timeout: must not be negative, got %v", c.UpstreamConnectTimeout)
+	}
+	if c.UpstreamReadTimeout < 0 {
+		errs.addf("upstream_read_timeout: must not be negative, got %v", c.UpstreamReadTimeout)
+	}
+	if c.UpstreamCircuitFailures < 0 {
+		errs.addf("upstream_circuit_failures: must not be negative, got %d", c.UpstreamCircuitFailures)
+	}
+	if c.UpstreamCircuitFailures > 0 && c.UpstreamCircuitCooldown <= 0 {
+		errs.addf("upstream_circuit_cooldown: must be positive when upstream_circuit_failures is set, got %v", c.UpstreamCircuitCooldown)
+	}
+	if c.PresignedUploads && (c.PresignedUploadExpiry <= 0 || c.PresignedUploadExpiry > maxPresignedUploadExpiry) {
+		errs.addf("presigned_upload_expiry: must be between 0 and %v, got %v", maxPresignedUploadExpiry, c.PresignedUploadExpiry)
+	}
+	if c.ChaosListDelay < 0 {
+		errs.addf("chaos_list_delay: must not be negative, got %v", c.ChaosListDelay)
+	}
+	if c.PrefixStatsDepth < 0 {
+		errs.addf("prefix_stats_depth: must not be negative, got %d", c.PrefixStatsDepth)
+	}
+	if c.EventLogKeys < 0 {
+		errs.addf("event_log_keys: must not be negative, got %d", c.EventLogKeys)
+	}
+	for _, slo := range c.SLOs {
+		if _, err := parseSLO(slo); err != nil {
+			errs.addf("slos: %v", err)
+		}
+	}
+	if len(c.SLOs) > 0 && c.SLOWindow < time.Second {
+		errs.addf("slo_window: must be at least 1s, got %v", c.SLOWindow)
+	}
+	if c.ChunkCacheMaxBytes > 0 && c.ChunkSize <= 0 {
+		errs.addf("chunk_cache_max_bytes: requires chunk_size")
+	}
+	if c.RangeBackgroundFill && !c.RangePassthrough {
+		errs.addf("range_background_fill: requires range_passthrough")
+	}
+	if strings.ContainsAny(c.IdentityHeader, " \t:") {
+		errs.addf("identity_header: %q is not a valid header name", c.IdentityHeader)
+	}
+	if c.DiskLowWatermark > 0 && c.DiskHighWatermark <= 0 {
+		errs.addf("disk_low_watermark: requires disk_high_watermark")
+	}
+	if c.DiskHighWatermark > 0 {
+		if c.DiskLowWatermark >= c.DiskHighWatermark {
+			errs.addf("disk_low_watermark: must be below disk_high_watermark (%d), got %d", c.DiskHighWatermark, c.DiskLowWatermark)
+		}
+		if !c.usesBackend("disk") {
+			errs.addf("disk_high_watermark: requires the disk backend")
+		}
+	}
+	switch c.DiskCompression {
+	case "", "none":
+	case "zstd":
+		if !c.usesBackend("disk") {
+			errs.addf("disk_compression: requires the disk backend")
+		}
+	default:
+		errs.addf("disk_compression: unknown compression %q (valid options: zstd, none)", c.DiskCompression)
+	}
+	if c.PackThreshold > maxPackThreshold {
+		errs.addf("pack_threshold: must be at most %d, got %d", maxPackThreshold, c.PackThreshold)
+	}
+	if c.PackThreshold > 0 && !c.usesBackend("disk") {
+		errs.addf("pack_threshold: requires the disk backend")
+	}
+	if c.PackCompactInterval < 0 {
+		errs.addf("pack_compact_interval: must not be negative, got %v", c.PackCompactInterval)
+	}
+	if c.PackCompactInterval > 0 && !c.usesBackend("disk") {
+		errs.addf("pack_compact_interval: requires the disk backend")
+	}
+	if c.encrypts() {
+		if c.EncryptionKey != "" && c.EncryptionKeyFile != "" {
+			errs.addf("encryption_key: set either encryption_key or encryption_key_file, not both")
+		} else if _, err := loadEncryptionKey(c.EncryptionKey, c.EncryptionKeyFile); err != nil {
+			errs.addf("encryption_key: %v", err)
+		}
+		if !c.usesBackend("disk") {
+			errs.addf("encryption_key: requires the disk backend")
+		}
+	}
+	return errs.err()
+}
+
+// diskLowWatermark returns the usage disk trimming stops at.
+func (c *Config) diskLowWatermark() int64 {
+	if c.DiskLowWatermark > 0 {
+		return int64(c.DiskLowWatermark)
+	}
+	return int64(c.DiskHighWatermark) * defaultLowWatermarkPercent / 100
+}
+
+// configErrors collects every problem found while loading the config so they
+// can be reported together.
+type configErrors []error
+
+func (e *configErrors) add(err error) {
+	*e = append(*e, err)
+}
 
-	return cfg
+func (e *configErrors) addf(format string, args ...any) {
+	e.add(fmt.Errorf(format, args...))
+}
+
+func (e configErrors) err() error {
+	sort.Slice(e, func(i, j int) bool { return e[i].Error() < e[j].Error() })
+	return errors.Join(e...)
+}
+
+// encrypts reports whether objects written to the disk backend are
+// encrypted.
+func (c *Config) encrypts() bool {
+	return c.EncryptionKey != "" || c.EncryptionKeyFile != ""
+}
+
+// usesBackend reports whether the default backend or any bucket uses the
+// given backend type.
+func (c *Config) usesBackend(backendType string) bool {
+	if c.BackendType == backendType {
+		return true
+	}
+	for _, t := range c.BucketBackends {
+		if t == backendType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBool parses a boolean environment value, recording an error if it is
+// malformed.
+func (e *configErrors) parseBool(name, v string) bool {
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		e.addf("%s: invalid boolean %q", name, v)
+	}
+	return b
+}
+
+// parseInt parses an integer environment value, recording an error if it is
+// malformed.
+func (e *configErrors) parseInt(name, v string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		e.addf("%s: invalid integer %q", name, v)
+	}
+	return n
+}
+
+// parseDuration parses a duration environment value such as "30m", recording
+// an error if it is malformed.
+func (e *configErrors) parseDuration(name, v string) time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		e.addf("%s: invalid duration %q (use e.g. 30s, 5m, 1h)", name, v)
+	}
+	return d
+}
+
+// byteSize is a size in bytes that can be written with a unit suffix, such
+// as "512MiB", "10GB" or "1T". Units are binary (1K = 1024 bytes).
+type byteSize int64
+
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses a byte count with an optional unit suffix.
+func parseByteSize(s string) (byteSize, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(v, u.suffix) {
+			v, multiplier = strings.TrimSpace(strings.TrimSuffix(v, u.suffix)), u.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return byteSize(n * multiplier), nil
+}
+
+// UnmarshalYAML accepts either a plain byte count or a size with a unit.
+func (b *byteSize) UnmarshalYAML(value *yaml.Node) error {
+	n, err := parseByteSize(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*b = n
+	return nil
+}
+
+// parseByteSize parses a size environment value, recording an error if it is
+// malformed.
+func (e *configErrors) parseByteSize(name, v string) byteSize {
+	n, err := parseByteSize(v)
+	if err != nil {
+		e.addf("%s: %v", name, err)
+	}
+	return n
+}
+
+// parseMappings parses "local1:value1,local2:value2" pairs into dst,
+// recording an error for every entry without a key or value. Only the first
+// colon separates name from value, so values may contain colons.
+func (e *configErrors) parseMappings(dst map[string]string, name, v string) {
+	for _, mapping := range parseCommaSeparated(v) {
+		key, value, ok := strings.Cut(mapping, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			e.addf("%s: malformed entry %q (want key:value)", name, mapping)
+			continue
+		}
+		dst[key] = value
+	}
 }
 
 // parseCommaSeparated splits a comma-separated string and trims whitespace
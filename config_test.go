@@ -3,9 +3,33 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
+// mustLoadConfig loads the config, failing the test on validation errors.
+func mustLoadConfig(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	return cfg
+}
+
+// loadConfigError loads the config and returns the validation error, failing
+// the test if there was none.
+func loadConfigError(t *testing.T) string {
+	t.Helper()
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig should have failed")
+	}
+	return err.Error()
+}
+
 func TestDefaultConfig(t *testing.T) {
 	cfg := DefaultConfig()
 
@@ -24,6 +48,9 @@ func TestDefaultConfig(t *testing.T) {
 	if cfg.AWSRegion != "us-east-1" {
 		t.Errorf("AWSRegion = %q, want %q", cfg.AWSRegion, "us-east-1")
 	}
+	if cfg.UpstreamQuirks != "aws" {
+		t.Errorf("UpstreamQuirks = %q, want %q", cfg.UpstreamQuirks, "aws")
+	}
 	if cfg.BucketMappings == nil {
 		t.Error("BucketMappings should not be nil")
 	}
@@ -45,7 +72,6 @@ func TestLoadConfig_BackendType(t *testing.T) {
 		{"disk explicitly", "disk", "disk"},
 		{"memory", "memory", "memory"},
 		{"localstack", "localstack", "localstack"},
-		{"custom value", "custom", "custom"},
 	}
 
 	for _, tt := range tests {
@@ -56,7 +82,7 @@ func TestLoadConfig_BackendType(t *testing.T) {
 				t.Setenv("S3LAZY_BACKEND", tt.envValue)
 			}
 
-			cfg := LoadConfig()
+			cfg := mustLoadConfig(t)
 
 			if cfg.BackendType != tt.want {
 				t.Errorf("BackendType = %q, want %q", cfg.BackendType, tt.want)
@@ -73,8 +99,9 @@ func TestLoadConfig_EnvOverrides(t *testing.T) {
 	t.Setenv("S3LAZY_DATA_DIR", "/custom/data")
 	t.Setenv("S3LAZY_LOCALSTACK_ENDPOINT", "http://localstack:4566")
 	t.Setenv("S3LAZY_AWS_REGION", "eu-west-1")
+	t.Setenv("S3LAZY_UPSTREAM_QUIRKS", "minio")
 
-	cfg := LoadConfig()
+	cfg := mustLoadConfig(t)
 
 	if cfg.ListenAddr != ":8080" {
 		t.Errorf("ListenAddr = %q, want %q", cfg.ListenAddr, ":8080")
@@ -91,6 +118,9 @@ func TestLoadConfig_EnvOverrides(t *testing.T) {
 	if cfg.AWSRegion != "eu-west-1" {
 		t.Errorf("AWSRegion = %q, want %q", cfg.AWSRegion, "eu-west-1")
 	}
+	if cfg.UpstreamQuirks != "minio" {
+		t.Errorf("UpstreamQuirks = %q, want %q", cfg.UpstreamQuirks, "minio")
+	}
 }
 
 func TestLoadConfig_AWSRegionFallback(t *testing.T) {
@@ -99,7 +129,7 @@ func TestLoadConfig_AWSRegionFallback(t *testing.T) {
 	// AWS_REGION should be used if S3LAZY_AWS_REGION is not set
 	t.Setenv("AWS_REGION", "ap-southeast-1")
 
-	cfg := LoadConfig()
+	cfg := mustLoadConfig(t)
 
 	if cfg.AWSRegion != "ap-southeast-1" {
 		t.Errorf("AWSRegion = %q, want %q", cfg.AWSRegion, "ap-southeast-1")
@@ -108,7 +138,7 @@ func TestLoadConfig_AWSRegionFallback(t *testing.T) {
 	// But S3LAZY_AWS_REGION takes precedence
 	t.Setenv("S3LAZY_AWS_REGION", "us-west-2")
 
-	cfg = LoadConfig()
+	cfg = mustLoadConfig(t)
 
 	if cfg.AWSRegion != "us-west-2" {
 		t.Errorf("AWSRegion = %q, want %q (S3LAZY_AWS_REGION should take precedence)", cfg.AWSRegion, "us-west-2")
@@ -138,7 +168,7 @@ func TestLoadConfig_InitBucketsParsing(t *testing.T) {
 				t.Setenv("S3LAZY_INIT_BUCKETS", tt.envValue)
 			}
 
-			cfg := LoadConfig()
+			cfg := mustLoadConfig(t)
 
 			if len(cfg.InitBuckets) != len(tt.want) {
 				t.Errorf("InitBuckets length = %d, want %d", len(cfg.InitBuckets), len(tt.want))
@@ -146,8 +176,8 @@ func TestLoadConfig_InitBucketsParsing(t *testing.T) {
 			}
 
 			for i, bucket := range cfg.InitBuckets {
-				if bucket != tt.want[i] {
-					t.Errorf("InitBuckets[%d] = %q, want %q", i, bucket, tt.want[i])
+				if bucket.Name != tt.want[i] {
+					t.Errorf("InitBuckets[%d] = %q, want %q", i, bucket.Name, tt.want[i])
 				}
 			}
 		})
@@ -166,7 +196,6 @@ func TestLoadConfig_BucketMapParsing(t *testing.T) {
 		{"single mapping", "local:aws", map[string]string{"local": "aws"}},
 		{"multiple mappings", "local1:aws1,local2:aws2", map[string]string{"local1": "aws1", "local2": "aws2"}},
 		{"with spaces", " local1 : aws1 , local2 : aws2 ", map[string]string{"local1": "aws1", "local2": "aws2"}},
-		{"invalid entry ignored", "local1:aws1,invalid,local2:aws2", map[string]string{"local1": "aws1", "local2": "aws2"}},
 	}
 
 	for _, tt := range tests {
@@ -177,7 +206,7 @@ func TestLoadConfig_BucketMapParsing(t *testing.T) {
 				t.Setenv("S3LAZY_BUCKET_MAP", tt.envValue)
 			}
 
-			cfg := LoadConfig()
+			cfg := mustLoadConfig(t)
 
 			if len(cfg.BucketMappings) != len(tt.want) {
 				t.Errorf("BucketMappings length = %d, want %d", len(cfg.BucketMappings), len(tt.want))
@@ -193,146 +222,1498 @@ func TestLoadConfig_BucketMapParsing(t *testing.T) {
 	}
 }
 
-func TestLoadConfig_YAMLFile(t *testing.T) {
+func TestLoadConfig_URLSourcesParsing(t *testing.T) {
 	clearS3LazyEnvVars(t)
 
-	// Create a temporary YAML config file
-	tmpDir := t.TempDir()
-	configPath := filepath.Join(tmpDir, "config.yaml")
+	t.Setenv("S3LAZY_URL_SOURCES", "cdn:https://cdn.example.com/{key}, public:http://mirror.local/{bucket}/{key}")
 
-	yamlContent := `
-listen_addr: ":8888"
-backend_type: "localstack"
-data_dir: "/yaml/data"
-localstack_endpoint: "http://yaml-localstack:4566"
-aws_region: "eu-central-1"
-init_buckets:
-  - "yaml-bucket-1"
-  - "yaml-bucket-2"
-bucket_mappings:
-  yaml-local: "yaml-aws"
-`
+	cfg := mustLoadConfig(t)
 
-	if err := os.WriteFile(configPath, []byte(yamlContent), 0644); err != nil {
-		t.Fatalf("Failed to write config file: %v", err)
+	want := map[string]string{
+		"cdn":    "https://cdn.example.com/{key}",
+		"public": "http://mirror.local/{bucket}/{key}",
 	}
+	if len(cfg.URLSources) != len(want) {
+		t.Fatalf("URLSources length = %d, want %d", len(cfg.URLSources), len(want))
+	}
+	for k, v := range want {
+		if cfg.URLSources[k] != v {
+			t.Errorf("URLSources[%q] = %q, want %q", k, cfg.URLSources[k], v)
+		}
+	}
+}
 
-	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+func TestLoadConfig_URLSourceRevalidate(t *testing.T) {
+	clearS3LazyEnvVars(t)
 
-	cfg := LoadConfig()
+	if cfg := mustLoadConfig(t); cfg.URLSourceRevalidate {
+		t.Error("URLSourceRevalidate should default to false")
+	}
 
-	if cfg.ListenAddr != ":8888" {
-		t.Errorf("ListenAddr = %q, want %q", cfg.ListenAddr, ":8888")
+	t.Setenv("S3LAZY_URL_SOURCE_REVALIDATE", "true")
+	if cfg := mustLoadConfig(t); !cfg.URLSourceRevalidate {
+		t.Error("URLSourceRevalidate should be true when env is set")
 	}
-	if cfg.BackendType != "localstack" {
-		t.Errorf("BackendType = %q, want %q", cfg.BackendType, "localstack")
+
+	t.Setenv("S3LAZY_URL_SOURCE_REVALIDATE", "not-a-bool")
+	if err := loadConfigError(t); !strings.Contains(err, `S3LAZY_URL_SOURCE_REVALIDATE: invalid boolean "not-a-bool"`) {
+		t.Errorf("error = %q, want invalid boolean", err)
 	}
-	if cfg.DataDir != "/yaml/data" {
-		t.Errorf("DataDir = %q, want %q", cfg.DataDir, "/yaml/data")
+}
+
+func TestLoadConfig_SharedDataDir(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.SharedDataDir {
+		t.Error("SharedDataDir should default to false")
 	}
-	if cfg.LocalStackEndpoint != "http://yaml-localstack:4566" {
-		t.Errorf("LocalStackEndpoint = %q, want %q", cfg.LocalStackEndpoint, "http://yaml-localstack:4566")
+
+	t.Setenv("S3LAZY_SHARED_DATA_DIR", "true")
+	t.Setenv("S3LAZY_INSTANCE_ID", "replica-0")
+
+	cfg = mustLoadConfig(t)
+	if !cfg.SharedDataDir {
+		t.Error("SharedDataDir should be true when env is set")
 	}
-	if cfg.AWSRegion != "eu-central-1" {
-		t.Errorf("AWSRegion = %q, want %q", cfg.AWSRegion, "eu-central-1")
+	if cfg.InstanceID != "replica-0" {
+		t.Errorf("InstanceID = %q, want %q", cfg.InstanceID, "replica-0")
 	}
-	if len(cfg.InitBuckets) != 2 || cfg.InitBuckets[0] != "yaml-bucket-1" {
-		t.Errorf("InitBuckets = %v, want [yaml-bucket-1 yaml-bucket-2]", cfg.InitBuckets)
+}
+
+func TestLoadConfig_WarmManifest(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_WARM_MANIFEST", "/etc/s3lazy/warm.txt")
+	t.Setenv("S3LAZY_READY_AFTER_WARM", "1")
+
+	cfg := mustLoadConfig(t)
+
+	if cfg.WarmManifest != "/etc/s3lazy/warm.txt" {
+		t.Errorf("WarmManifest = %q, want %q", cfg.WarmManifest, "/etc/s3lazy/warm.txt")
 	}
-	if cfg.BucketMappings["yaml-local"] != "yaml-aws" {
-		t.Errorf("BucketMappings[yaml-local] = %q, want %q", cfg.BucketMappings["yaml-local"], "yaml-aws")
+	if !cfg.ReadyAfterWarm {
+		t.Error("ReadyAfterWarm should be true when env is set")
 	}
 }
 
-func TestLoadConfig_EnvOverridesYAML(t *testing.T) {
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    byteSize
+		wantErr bool
+	}{
+		{"1048576", 1 << 20, false},
+		{"512MiB", 512 << 20, false},
+		{"10GB", 10 << 30, false},
+		{"2t", 2 << 40, false},
+		{"100 K", 100 << 10, false},
+		{"lots", 0, true},
+		{"-1", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfig_CacheMaxBytes(t *testing.T) {
 	clearS3LazyEnvVars(t)
 
-	// Create a temporary YAML config file
-	tmpDir := t.TempDir()
-	configPath := filepath.Join(tmpDir, "config.yaml")
+	t.Setenv("S3LAZY_CACHE_MAX_BYTES", "10GiB")
 
-	yamlContent := `
-listen_addr: ":8888"
-backend_type: "disk"
-`
+	cfg := mustLoadConfig(t)
 
-	if err := os.WriteFile(configPath, []byte(yamlContent), 0644); err != nil {
-		t.Fatalf("Failed to write config file: %v", err)
+	if cfg.CacheMaxBytes != 10<<30 {
+		t.Errorf("CacheMaxBytes = %d, want %d", cfg.CacheMaxBytes, int64(10<<30))
 	}
+}
 
-	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
-	// Env var should override YAML
-	t.Setenv("S3LAZY_BACKEND", "localstack")
+func TestLoadConfig_MaxObjects(t *testing.T) {
+	clearS3LazyEnvVars(t)
 
-	cfg := LoadConfig()
+	t.Setenv("S3LAZY_MAX_OBJECTS_PER_BUCKET", "100000")
+	t.Setenv("S3LAZY_BUCKET_MAX_OBJECTS", "thumbnails:5000,datasets:0")
+	cfg := mustLoadConfig(t)
+	if cfg.MaxObjectsPerBucket != 100000 || cfg.BucketMaxObjects["thumbnails"] != 5000 || cfg.BucketMaxObjects["datasets"] != 0 {
+		t.Errorf("MaxObjectsPerBucket = %d, BucketMaxObjects = %v, want 100000 and thumbnails:5000, datasets:0",
+			cfg.MaxObjectsPerBucket, cfg.BucketMaxObjects)
+	}
 
-	// YAML value
-	if cfg.ListenAddr != ":8888" {
-		t.Errorf("ListenAddr = %q, want %q", cfg.ListenAddr, ":8888")
+	t.Setenv("S3LAZY_BUCKET_MAX_OBJECTS", "thumbnails:-1")
+	if err := loadConfigError(t); !strings.Contains(err, "bucket_max_objects: bucket thumbnails") {
+		t.Errorf("error = %q, want a negative limit rejected", err)
 	}
-	// Env var override
-	if cfg.BackendType != "localstack" {
-		t.Errorf("BackendType = %q, want %q (env should override yaml)", cfg.BackendType, "localstack")
+}
+
+func TestLoadConfig_LocalQuota(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_LOCAL_QUOTA", "5GiB")
+	t.Setenv("S3LAZY_BUCKET_LOCAL_QUOTAS", "scratch:100MiB,datasets:0")
+	cfg := mustLoadConfig(t)
+	if cfg.LocalQuota != 5<<30 || cfg.BucketLocalQuotas["scratch"] != 100<<20 || cfg.BucketLocalQuotas["datasets"] != 0 || len(cfg.BucketLocalQuotas) != 2 {
+		t.Errorf("LocalQuota = %d, BucketLocalQuotas = %v, want 5GiB and scratch:100MiB, datasets:0",
+			cfg.LocalQuota, cfg.BucketLocalQuotas)
+	}
+
+	t.Setenv("S3LAZY_BUCKET_LOCAL_QUOTAS", "scratch:lots")
+	if err := loadConfigError(t); !strings.Contains(err, "S3LAZY_BUCKET_LOCAL_QUOTAS scratch") {
+		t.Errorf("error = %q, want an invalid size rejected", err)
 	}
 }
 
-func TestLoadConfig_InvalidYAMLFile(t *testing.T) {
+func TestLoadConfig_ListenerLimits(t *testing.T) {
 	clearS3LazyEnvVars(t)
 
-	// Non-existent file - should use defaults
-	t.Setenv("S3LAZY_CONFIG_FILE", "/nonexistent/config.yaml")
+	cfg := mustLoadConfig(t)
+	if cfg.ListenMaxConnections != 0 || cfg.ListenMaxHeaderBytes != defaultMaxHeaderBytes || cfg.ListenHeaderTimeout != defaultHeaderTimeout || cfg.ListenIdleTimeout != defaultIdleTimeout {
+		t.Errorf("defaults = %d connections, %d header bytes, %v header timeout, %v idle timeout",
+			cfg.ListenMaxConnections, cfg.ListenMaxHeaderBytes, cfg.ListenHeaderTimeout, cfg.ListenIdleTimeout)
+	}
 
-	cfg := LoadConfig()
+	t.Setenv("S3LAZY_LISTEN_MAX_CONNECTIONS", "256")
+	t.Setenv("S3LAZY_LISTEN_MAX_BODY_BYTES", "5GiB")
+	t.Setenv("S3LAZY_LISTEN_WRITE_TIMEOUT", "30m")
+	cfg = mustLoadConfig(t)
+	if cfg.ListenMaxConnections != 256 || cfg.ListenMaxBodyBytes != 5<<30 || cfg.ListenWriteTimeout != 30*time.Minute {
+		t.Errorf("limits = %d connections, %d body bytes, %v write timeout; want 256, 5GiB, 30m",
+			cfg.ListenMaxConnections, cfg.ListenMaxBodyBytes, cfg.ListenWriteTimeout)
+	}
 
-	// Should fall back to defaults
-	if cfg.BackendType != "disk" {
-		t.Errorf("BackendType = %q, want %q (should use default when file not found)", cfg.BackendType, "disk")
+	t.Setenv("S3LAZY_LISTEN_IDLE_TIMEOUT", "-1s")
+	if err := loadConfigError(t); !strings.Contains(err, "listen_idle_timeout") {
+		t.Errorf("error = %q, want negative listen_idle_timeout rejected", err)
 	}
 }
 
-func TestLoadConfig_MalformedYAML(t *testing.T) {
+func TestLoadConfig_ShutdownGracePeriod(t *testing.T) {
 	clearS3LazyEnvVars(t)
 
-	tmpDir := t.TempDir()
-	configPath := filepath.Join(tmpDir, "config.yaml")
+	if cfg := mustLoadConfig(t); cfg.ShutdownGracePeriod != defaultShutdownGracePeriod {
+		t.Errorf("ShutdownGracePeriod = %v, want %v by default", cfg.ShutdownGracePeriod, defaultShutdownGracePeriod)
+	}
+	t.Setenv("S3LAZY_SHUTDOWN_GRACE_PERIOD", "2m")
+	if cfg := mustLoadConfig(t); cfg.ShutdownGracePeriod != 2*time.Minute {
+		t.Errorf("ShutdownGracePeriod = %v, want 2m", cfg.ShutdownGracePeriod)
+	}
+	t.Setenv("S3LAZY_SHUTDOWN_GRACE_PERIOD", "0s")
+	if err := loadConfigError(t); !strings.Contains(err, "shutdown_grace_period") {
+		t.Errorf("error = %q, want zero shutdown_grace_period rejected", err)
+	}
+}
 
-	// Invalid YAML - tabs are not allowed in YAML
-	malformedYAML := "backend_type: localstack\n\t\tinvalid indentation"
+func TestLoadConfig_DiskWatermarks(t *testing.T) {
+	clearS3LazyEnvVars(t)
 
-	if err := os.WriteFile(configPath, []byte(malformedYAML), 0644); err != nil {
-		t.Fatalf("Failed to write config file: %v", err)
+	t.Setenv("S3LAZY_BACKEND", "disk")
+	t.Setenv("S3LAZY_DATA_DIR", t.TempDir())
+	t.Setenv("S3LAZY_DISK_HIGH_WATERMARK", "10GiB")
+	cfg := mustLoadConfig(t)
+	if cfg.DiskHighWatermark != 10<<30 || cfg.diskLowWatermark() != 8<<30 {
+		t.Errorf("watermarks = %d/%d, want 10GiB with the low mark defaulting to 8GiB", cfg.DiskHighWatermark, cfg.diskLowWatermark())
 	}
 
-	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+	t.Setenv("S3LAZY_DISK_LOW_WATERMARK", "12GiB")
+	if err := loadConfigError(t); !strings.Contains(err, "disk_low_watermark") {
+		t.Errorf("error = %q, want low watermark above high rejected", err)
+	}
+
+	t.Setenv("S3LAZY_BACKEND", "memory")
+	t.Setenv("S3LAZY_DISK_LOW_WATERMARK", "8GiB")
+	if err := loadConfigError(t); !strings.Contains(err, "requires the disk backend") {
+		t.Errorf("error = %q, want memory backend rejected", err)
+	}
+}
 
-	cfg := LoadConfig()
+func TestLoadConfig_PackThreshold(t *testing.T) {
+	clearS3LazyEnvVars(t)
 
-	// Should fall back to defaults when YAML parse fails
-	if cfg.BackendType != "disk" {
-		t.Errorf("BackendType = %q, want %q (should use default on parse error)", cfg.BackendType, "disk")
+	t.Setenv("S3LAZY_BACKEND", "disk")
+	t.Setenv("S3LAZY_DATA_DIR", t.TempDir())
+	t.Setenv("S3LAZY_PACK_THRESHOLD", "64KiB")
+	if cfg := mustLoadConfig(t); cfg.PackThreshold != 64<<10 {
+		t.Errorf("PackThreshold = %d, want 64KiB", cfg.PackThreshold)
+	}
+
+	t.Setenv("S3LAZY_PACK_THRESHOLD", "1GiB")
+	if err := loadConfigError(t); !strings.Contains(err, "pack_threshold: must be at most") {
+		t.Errorf("error = %q, want a huge threshold rejected", err)
+	}
+
+	t.Setenv("S3LAZY_BACKEND", "memory")
+	t.Setenv("S3LAZY_PACK_THRESHOLD", "64KiB")
+	if err := loadConfigError(t); !strings.Contains(err, "requires the disk backend") {
+		t.Errorf("error = %q, want memory backend rejected", err)
 	}
 }
 
-func TestLoadConfig_YAMLWrongFieldName(t *testing.T) {
+func TestLoadConfig_PackCompactInterval(t *testing.T) {
 	clearS3LazyEnvVars(t)
 
-	tmpDir := t.TempDir()
-	configPath := filepath.Join(tmpDir, "config.yaml")
+	t.Setenv("S3LAZY_BACKEND", "disk")
+	t.Setenv("S3LAZY_DATA_DIR", t.TempDir())
+	t.Setenv("S3LAZY_PACK_COMPACT_INTERVAL", "1h")
+	if cfg := mustLoadConfig(t); cfg.PackCompactInterval != time.Hour {
+		t.Errorf("PackCompactInterval = %v, want 1h", cfg.PackCompactInterval)
+	}
 
-	// Common mistake: using camelCase instead of snake_case
-	yamlContent := `backendType: localstack`
+	t.Setenv("S3LAZY_PACK_COMPACT_INTERVAL", "-1m")
+	if err := loadConfigError(t); !strings.Contains(err, "pack_compact_interval: must not be negative") {
+		t.Errorf("error = %q, want a negative interval rejected", err)
+	}
 
-	if err := os.WriteFile(configPath, []byte(yamlContent), 0644); err != nil {
-		t.Fatalf("Failed to write config file: %v", err)
+	t.Setenv("S3LAZY_BACKEND", "memory")
+	t.Setenv("S3LAZY_PACK_COMPACT_INTERVAL", "1h")
+	if err := loadConfigError(t); !strings.Contains(err, "pack_compact_interval: requires the disk backend") {
+		t.Errorf("error = %q, want memory backend rejected", err)
 	}
+}
 
-	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+func TestLoadConfig_EncryptionKey(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	key := strings.Repeat("ab", 32)
+	t.Setenv("S3LAZY_BACKEND", "disk")
+	t.Setenv("S3LAZY_DATA_DIR", t.TempDir())
+	t.Setenv("S3LAZY_ENCRYPTION_KEY", key)
+	cfg := mustLoadConfig(t)
+	if !cfg.encrypts() {
+		t.Error("encrypts() = false, want true with a key set")
+	}
+	for _, s := range cfg.Effective() {
+		if s.Field == "encryption_key" && s.Value != redacted {
+			t.Errorf("effective encryption_key = %v, want it redacted", s.Value)
+		}
+	}
 
-	cfg := LoadConfig()
+	t.Setenv("S3LAZY_ENCRYPTION_KEY", "too-short")
+	if err := loadConfigError(t); !strings.Contains(err, "encryption_key: key must be 32 bytes") {
+		t.Errorf("error = %q, want a bad key rejected", err)
+	}
 
-	// backendType (camelCase) is ignored, default is used
-	if cfg.BackendType != "disk" {
-		t.Errorf("BackendType = %q, want %q (camelCase field should be ignored)", cfg.BackendType, "disk")
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("S3LAZY_ENCRYPTION_KEY_FILE", keyFile)
+	if err := loadConfigError(t); !strings.Contains(err, "not both") {
+		t.Errorf("error = %q, want a key and key file rejected", err)
+	}
+	t.Setenv("S3LAZY_ENCRYPTION_KEY", "")
+	if cfg := mustLoadConfig(t); cfg.EncryptionKeyFile != keyFile {
+		t.Errorf("EncryptionKeyFile = %q, want %q", cfg.EncryptionKeyFile, keyFile)
+	}
+
+	t.Setenv("S3LAZY_BACKEND", "memory")
+	if err := loadConfigError(t); !strings.Contains(err, "requires the disk backend") {
+		t.Errorf("error = %q, want memory backend rejected", err)
+	}
+}
+
+func TestLoadConfig_DiskCompression(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_BACKEND", "disk")
+	t.Setenv("S3LAZY_DATA_DIR", t.TempDir())
+	t.Setenv("S3LAZY_DISK_COMPRESSION", "zstd")
+	if cfg := mustLoadConfig(t); cfg.DiskCompression != "zstd" {
+		t.Errorf("DiskCompression = %q, want zstd", cfg.DiskCompression)
+	}
+
+	t.Setenv("S3LAZY_DISK_COMPRESSION", "gzip")
+	if err := loadConfigError(t); !strings.Contains(err, "disk_compression") {
+		t.Errorf("error = %q, want unknown compression rejected", err)
+	}
+
+	t.Setenv("S3LAZY_BACKEND", "memory")
+	t.Setenv("S3LAZY_DISK_COMPRESSION", "zstd")
+	if err := loadConfigError(t); !strings.Contains(err, "requires the disk backend") {
+		t.Errorf("error = %q, want memory backend rejected", err)
+	}
+}
+
+func TestLoadConfig_StreamThreshold(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_STREAM_THRESHOLD", "512MiB")
+
+	cfg := mustLoadConfig(t)
+
+	if cfg.StreamThreshold != 512<<20 {
+		t.Errorf("StreamThreshold = %d, want %d", cfg.StreamThreshold, int64(512<<20))
+	}
+}
+
+func TestLoadConfig_KeyFilters(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_KEY_FILTER_BUCKETS", "build-cache, datasets")
+	t.Setenv("S3LAZY_KEY_FILTER_REFRESH", "15m")
+	cfg := mustLoadConfig(t)
+	if !slices.Equal(cfg.KeyFilterBuckets, []string{"build-cache", "datasets"}) || cfg.KeyFilterRefresh != 15*time.Minute {
+		t.Errorf("KeyFilterBuckets = %v, KeyFilterRefresh = %v", cfg.KeyFilterBuckets, cfg.KeyFilterRefresh)
+	}
+
+	t.Setenv("S3LAZY_BUCKET_MAP", "datasets:datasets-staging")
+	t.Setenv("S3LAZY_BUCKET_FALLBACKS", "datasets-staging:datasets-prod")
+	if err := loadConfigError(t); !strings.Contains(err, "key_filter_buckets: datasets falls back") {
+		t.Errorf("error = %q, want a bucket with fallbacks rejected", err)
+	}
+}
+
+func TestLoadConfig_FollowInterval(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	if cfg := mustLoadConfig(t); cfg.FollowInterval != defaultFollowInterval {
+		t.Errorf("FollowInterval = %v, want %v", cfg.FollowInterval, defaultFollowInterval)
+	}
+	t.Setenv("S3LAZY_FOLLOW_INTERVAL", "500ms")
+	if cfg := mustLoadConfig(t); cfg.FollowInterval != 500*time.Millisecond {
+		t.Errorf("FollowInterval = %v, want 500ms", cfg.FollowInterval)
+	}
+	t.Setenv("S3LAZY_FOLLOW_INTERVAL", "0s")
+	if err := loadConfigError(t); !strings.Contains(err, "follow_interval") {
+		t.Errorf("error = %q, want a zero interval rejected", err)
+	}
+}
+
+func TestLoadConfig_ChunkSize(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_CHUNK_CACHE_MAX_BYTES", "1GiB")
+	if err := loadConfigError(t); !strings.Contains(err, "requires chunk_size") {
+		t.Errorf("error = %q, want chunk_cache_max_bytes without chunk_size rejected", err)
+	}
+
+	t.Setenv("S3LAZY_CHUNK_SIZE", "8MiB")
+	cfg := mustLoadConfig(t)
+	if cfg.ChunkSize != 8<<20 || cfg.ChunkCacheMaxBytes != 1<<30 {
+		t.Errorf("ChunkSize = %d, ChunkCacheMaxBytes = %d, want 8MiB and 1GiB", cfg.ChunkSize, cfg.ChunkCacheMaxBytes)
+	}
+}
+
+func TestLoadConfig_RangePassthrough(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_RANGE_BACKGROUND_FILL", "true")
+	if err := loadConfigError(t); !strings.Contains(err, "requires range_passthrough") {
+		t.Errorf("error = %q, want background fill without pass-through rejected", err)
+	}
+
+	t.Setenv("S3LAZY_RANGE_PASSTHROUGH", "true")
+	if cfg := mustLoadConfig(t); !cfg.RangePassthrough || !cfg.RangeBackgroundFill {
+		t.Errorf("RangePassthrough = %v, RangeBackgroundFill = %v, want both on", cfg.RangePassthrough, cfg.RangeBackgroundFill)
+	}
+}
+
+func TestLoadConfig_BucketBackends(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_BUCKET_BACKENDS", "config:memory,shared:localstack")
+
+	cfg := mustLoadConfig(t)
+
+	if cfg.BucketBackends["config"] != "memory" || cfg.BucketBackends["shared"] != "localstack" {
+		t.Errorf("BucketBackends = %v, want config:memory, shared:localstack", cfg.BucketBackends)
+	}
+	if !cfg.usesBackend("localstack") || cfg.usesBackend("tape") {
+		t.Error("usesBackend should report per-bucket backend types")
+	}
+}
+
+func TestLoadConfig_LocalStackReseed(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	if mustLoadConfig(t).LocalStackReseed {
+		t.Error("LocalStackReseed should default to false")
+	}
+
+	t.Setenv("S3LAZY_LOCALSTACK_RESEED", "true")
+	if !mustLoadConfig(t).LocalStackReseed {
+		t.Error("LocalStackReseed should be true when env is set")
+	}
+}
+
+func TestLoadConfig_CacheTTL(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_CACHE_TTL", "30m")
+	t.Setenv("S3LAZY_BUCKET_TTLS", "configs:1m,datasets:24h")
+
+	cfg := mustLoadConfig(t)
+
+	if cfg.CacheTTL != 30*time.Minute {
+		t.Errorf("CacheTTL = %v, want 30m", cfg.CacheTTL)
+	}
+	if cfg.BucketTTLs["configs"] != time.Minute || cfg.BucketTTLs["datasets"] != 24*time.Hour {
+		t.Errorf("BucketTTLs = %v, want configs:1m, datasets:24h", cfg.BucketTTLs)
+	}
+
+	t.Setenv("S3LAZY_BUCKET_TTLS", "configs:1m,broken:soon")
+	if err := loadConfigError(t); !strings.Contains(err, `S3LAZY_BUCKET_TTLS broken: invalid duration "soon"`) {
+		t.Errorf("error = %q, want invalid duration for bucket broken", err)
+	}
+}
+
+func TestLoadConfig_TTLJitter(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_TTL_JITTER_PERCENT", "10")
+	if cfg := mustLoadConfig(t); cfg.TTLJitterPercent != 10 {
+		t.Errorf("TTLJitterPercent = %d, want 10", cfg.TTLJitterPercent)
+	}
+
+	t.Setenv("S3LAZY_TTL_JITTER_PERCENT", "100")
+	if err := loadConfigError(t); !strings.Contains(err, "ttl_jitter_percent") {
+		t.Errorf("error = %q, want ttl_jitter_percent out of range", err)
+	}
+}
+
+func TestLoadConfig_MemorySnapshotInterval(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_MEMORY_SNAPSHOT_INTERVAL", "5m")
+	if cfg := mustLoadConfig(t); cfg.MemorySnapshotInterval != 5*time.Minute {
+		t.Errorf("MemorySnapshotInterval = %v, want 5m", cfg.MemorySnapshotInterval)
+	}
+
+	t.Setenv("S3LAZY_MEMORY_SNAPSHOT_INTERVAL", "-1m")
+	if err := loadConfigError(t); !strings.Contains(err, "memory_snapshot_interval") {
+		t.Errorf("error = %q, want negative memory_snapshot_interval rejected", err)
+	}
+}
+
+func TestLoadConfig_TTLFromHeaders(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_TTL_FROM_HEADERS", "true")
+	t.Setenv("S3LAZY_TTL_FROM_HEADERS_MIN", "30s")
+	t.Setenv("S3LAZY_TTL_FROM_HEADERS_MAX", "24h")
+	cfg := mustLoadConfig(t)
+	if !cfg.TTLFromHeaders || cfg.TTLFromHeadersMin != 30*time.Second || cfg.TTLFromHeadersMax != 24*time.Hour {
+		t.Errorf("TTL from headers = %v, %v to %v; want true, 30s to 24h", cfg.TTLFromHeaders, cfg.TTLFromHeadersMin, cfg.TTLFromHeadersMax)
+	}
+
+	t.Setenv("S3LAZY_TTL_FROM_HEADERS_MAX", "10s")
+	if err := loadConfigError(t); !strings.Contains(err, "ttl_from_headers_max") {
+		t.Errorf("error = %q, want max below min rejected", err)
+	}
+}
+
+func TestLoadConfig_Revalidate(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_REVALIDATE", "true")
+
+	if !mustLoadConfig(t).Revalidate {
+		t.Error("Revalidate should be true when env is set")
+	}
+}
+
+func TestLoadConfig_RefreshAhead(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	if cfg := mustLoadConfig(t); cfg.RefreshAhead != 0 || cfg.RefreshAheadMinHits != defaultRefreshAheadMinHits {
+		t.Errorf("defaults = %v, %d", cfg.RefreshAhead, cfg.RefreshAheadMinHits)
+	}
+	t.Setenv("S3LAZY_REFRESH_AHEAD", "5m")
+	t.Setenv("S3LAZY_REFRESH_AHEAD_MIN_HITS", "10")
+	if cfg := mustLoadConfig(t); cfg.RefreshAhead != 5*time.Minute || cfg.RefreshAheadMinHits != 10 {
+		t.Errorf("RefreshAhead = %v, RefreshAheadMinHits = %d", cfg.RefreshAhead, cfg.RefreshAheadMinHits)
+	}
+
+	t.Setenv("S3LAZY_REFRESH_AHEAD_MIN_HITS", "0")
+	if err := loadConfigError(t); !strings.Contains(err, "refresh_ahead_min_hits") {
+		t.Errorf("error = %q, want min hits rejected", err)
+	}
+}
+
+func TestLoadConfig_IdentityHeader(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_IDENTITY_HEADER", "X-Client-Id")
+	if cfg := mustLoadConfig(t); cfg.IdentityHeader != "X-Client-Id" {
+		t.Errorf("IdentityHeader = %q, want X-Client-Id", cfg.IdentityHeader)
+	}
+
+	t.Setenv("S3LAZY_IDENTITY_HEADER", "X-Client: Id")
+	if err := loadConfigError(t); !strings.Contains(err, "identity_header") {
+		t.Errorf("error = %q, want it to mention identity_header", err)
+	}
+}
+
+func TestLoadConfig_PrefixStatsDepth(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	if cfg := mustLoadConfig(t); cfg.PrefixStatsDepth != defaultPrefixStatsDepth {
+		t.Errorf("PrefixStatsDepth default = %d, want %d", cfg.PrefixStatsDepth, defaultPrefixStatsDepth)
+	}
+
+	t.Setenv("S3LAZY_PREFIX_STATS_DEPTH", "3")
+	if cfg := mustLoadConfig(t); cfg.PrefixStatsDepth != 3 {
+		t.Errorf("PrefixStatsDepth = %d, want 3", cfg.PrefixStatsDepth)
+	}
+
+	t.Setenv("S3LAZY_PREFIX_STATS_DEPTH", "deep")
+	if err := loadConfigError(t); !strings.Contains(err, "S3LAZY_PREFIX_STATS_DEPTH") {
+		t.Errorf("error = %q, want invalid integer", err)
+	}
+}
+
+func TestLoadConfig_EventLogKeys(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	if cfg := mustLoadConfig(t); cfg.EventLogKeys != defaultEventLogKeys {
+		t.Errorf("EventLogKeys default = %d, want %d", cfg.EventLogKeys, defaultEventLogKeys)
+	}
+
+	t.Setenv("S3LAZY_EVENT_LOG_KEYS", "0")
+	if cfg := mustLoadConfig(t); cfg.EventLogKeys != 0 {
+		t.Errorf("EventLogKeys = %d, want 0", cfg.EventLogKeys)
+	}
+
+	t.Setenv("S3LAZY_EVENT_LOG_KEYS", "-1")
+	if err := loadConfigError(t); !strings.Contains(err, "event_log_keys") {
+		t.Errorf("error = %q, want negative event_log_keys rejected", err)
+	}
+}
+
+func TestLoadConfig_Pins(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_PINS", "ref/genome.fa, ref/models/*")
+	cfg := mustLoadConfig(t)
+	if len(cfg.Pins) != 2 || cfg.Pins[0] != "ref/genome.fa" || cfg.Pins[1] != "ref/models/*" {
+		t.Errorf("Pins = %v, want [ref/genome.fa ref/models/*]", cfg.Pins)
+	}
+
+	t.Setenv("S3LAZY_PINS", "no-slash")
+	if err := loadConfigError(t); !strings.Contains(err, "pins") {
+		t.Errorf("error = %q, want invalid pin", err)
+	}
+}
+
+func TestLoadConfig_Strict(t *testing.T) {
+	clearS3LazyEnvVars(t)
+	t.Setenv("S3LAZY_DATADIR", "/tmp/typo")
+
+	if cfg := mustLoadConfig(t); cfg.Strict {
+		t.Error("Strict should default to false")
+	}
+
+	t.Setenv("S3LAZY_STRICT", "true")
+	if err := loadConfigError(t); !strings.Contains(err, "S3LAZY_DATADIR: unknown environment variable") {
+		t.Errorf("error = %q, want unknown S3LAZY_DATADIR", err)
+	}
+
+	os.Unsetenv("S3LAZY_DATADIR")
+	if cfg := mustLoadConfig(t); !cfg.Strict {
+		t.Error("Strict = false, want true")
+	}
+}
+
+func TestLoadConfig_ChaosListDelay(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_CHAOS_LIST_DELAY", "30s")
+	if cfg := mustLoadConfig(t); cfg.ChaosListDelay != 30*time.Second {
+		t.Errorf("ChaosListDelay = %v, want 30s", cfg.ChaosListDelay)
+	}
+
+	t.Setenv("S3LAZY_CHAOS_LIST_DELAY", "-1s")
+	if err := loadConfigError(t); !strings.Contains(err, "chaos_list_delay") {
+		t.Errorf("error = %q, want negative delay rejected", err)
+	}
+}
+
+func TestLoadConfig_TrafficSchedule(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_TRAFFIC_SCHEDULE", "09:00-18:00 bandwidth=1M concurrency=2")
+	cfg := mustLoadConfig(t)
+	if len(cfg.TrafficSchedule) != 1 || cfg.TrafficSchedule[0].Concurrency != 2 || cfg.TrafficSchedule[0].Bandwidth != 1<<20 {
+		t.Errorf("TrafficSchedule = %+v, want one 09:00-18:00 rule", cfg.TrafficSchedule)
+	}
+
+	t.Setenv("S3LAZY_TRAFFIC_SCHEDULE", "25:00-26:00 concurrency=2")
+	if err := loadConfigError(t); !strings.Contains(err, "traffic_schedule") {
+		t.Errorf("error = %q, want invalid window", err)
+	}
+}
+
+func TestLoadConfig_TagRules(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_TAG_RULES", "pii=true:no-cache,tmp=true:5m")
+	cfg := mustLoadConfig(t)
+	want := []TagRule{{Tag: "pii=true", NoCache: true}, {Tag: "tmp=true", TTL: 5 * time.Minute}}
+	if len(cfg.TagRules) != len(want) || cfg.TagRules[0] != want[0] || cfg.TagRules[1] != want[1] {
+		t.Errorf("TagRules = %+v, want %+v", cfg.TagRules, want)
+	}
+
+	for _, bad := range []string{"pii:no-cache", "pii=true:forever", "pii=true"} {
+		t.Setenv("S3LAZY_TAG_RULES", bad)
+		if err := loadConfigError(t); !strings.Contains(err, "S3LAZY_TAG_RULES") && !strings.Contains(err, "tag_rules") {
+			t.Errorf("%q: error = %q, want invalid tag rule", bad, err)
+		}
+	}
+}
+
+func TestLoadConfig_CacheBuckets(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_CACHE_ALLOW_BUCKETS", "assets,fixtures")
+	t.Setenv("S3LAZY_CACHE_DENY_BUCKETS", "prod-data")
+	cfg := mustLoadConfig(t)
+	if len(cfg.CacheAllowBuckets) != 2 || len(cfg.CacheDenyBuckets) != 1 || cfg.CacheDenyBuckets[0] != "prod-data" {
+		t.Errorf("CacheAllowBuckets = %v, CacheDenyBuckets = %v", cfg.CacheAllowBuckets, cfg.CacheDenyBuckets)
+	}
+}
+
+func TestLoadConfig_NoCachePatterns(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_NO_CACHE_PATTERNS", "*.tmp,logs/**")
+	cfg := mustLoadConfig(t)
+	if len(cfg.NoCachePatterns) != 2 || cfg.NoCachePatterns[1] != "logs/**" {
+		t.Errorf("NoCachePatterns = %v", cfg.NoCachePatterns)
+	}
+
+	t.Setenv("S3LAZY_NO_CACHE_PATTERNS", "logs/[a-")
+	if err := loadConfigError(t); !strings.Contains(err, "no_cache_patterns") {
+		t.Errorf("error = %q, want an invalid pattern", err)
+	}
+}
+
+func TestLoadConfig_Reports(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.ReportBucket != "" || cfg.ReportPrefix != defaultReportPrefix || cfg.ReportInterval != defaultReportInterval {
+		t.Errorf("defaults = %q, %q, %v", cfg.ReportBucket, cfg.ReportPrefix, cfg.ReportInterval)
+	}
+	t.Setenv("S3LAZY_REPORT_BUCKET", "platform-reports")
+	t.Setenv("S3LAZY_REPORT_PREFIX", "cache/")
+	t.Setenv("S3LAZY_REPORT_INTERVAL", "15m")
+	cfg = mustLoadConfig(t)
+	if cfg.ReportBucket != "platform-reports" || cfg.ReportPrefix != "cache/" || cfg.ReportInterval != 15*time.Minute {
+		t.Errorf("ReportBucket = %q, ReportPrefix = %q, ReportInterval = %v", cfg.ReportBucket, cfg.ReportPrefix, cfg.ReportInterval)
+	}
+
+	t.Setenv("S3LAZY_REPORT_INTERVAL", "0s")
+	if err := loadConfigError(t); !strings.Contains(err, "report_interval") {
+		t.Errorf("error = %q, want the interval rejected", err)
+	}
+}
+
+func TestLoadConfig_Backup(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.BackupBucket != "" || cfg.BackupPrefix != defaultBackupPrefix || cfg.BackupInterval != defaultBackupInterval {
+		t.Errorf("defaults = %q, %q, %v", cfg.BackupBucket, cfg.BackupPrefix, cfg.BackupInterval)
+	}
+	t.Setenv("S3LAZY_BACKUP_BUCKET", "scratch-backup")
+	t.Setenv("S3LAZY_BACKUP_PREFIX", "laptops/")
+	t.Setenv("S3LAZY_BACKUP_INTERVAL", "5m")
+	cfg = mustLoadConfig(t)
+	if cfg.BackupBucket != "scratch-backup" || cfg.BackupPrefix != "laptops/" || cfg.BackupInterval != 5*time.Minute {
+		t.Errorf("BackupBucket = %q, BackupPrefix = %q, BackupInterval = %v", cfg.BackupBucket, cfg.BackupPrefix, cfg.BackupInterval)
+	}
+
+	t.Setenv("S3LAZY_BACKUP_INTERVAL", "0s")
+	if err := loadConfigError(t); !strings.Contains(err, "backup_interval") {
+		t.Errorf("error = %q, want the interval rejected", err)
+	}
+}
+
+func TestLoadConfig_TeamCache(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_TEAM_CACHE_PREFIX", "s3lazy/")
+	if err := loadConfigError(t); !strings.Contains(err, "team_cache_bucket") {
+		t.Errorf("error = %q, want the prefix rejected without a bucket", err)
+	}
+
+	t.Setenv("S3LAZY_TEAM_CACHE_BUCKET", "team-mirror")
+	t.Setenv("S3LAZY_TEAM_CACHE_REGION", "eu-west-1")
+	cfg := mustLoadConfig(t)
+	if cfg.TeamCacheBucket != "team-mirror" || cfg.TeamCachePrefix != "s3lazy/" || cfg.TeamCacheRegion != "eu-west-1" {
+		t.Errorf("TeamCacheBucket = %q, TeamCachePrefix = %q, TeamCacheRegion = %q", cfg.TeamCacheBucket, cfg.TeamCachePrefix, cfg.TeamCacheRegion)
+	}
+}
+
+func TestLoadConfig_VerifyUploads(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	if cfg := mustLoadConfig(t); cfg.VerifyUploads {
+		t.Error("uploads verified by default")
+	}
+	t.Setenv("S3LAZY_VERIFY_UPLOADS", "true")
+	if cfg := mustLoadConfig(t); !cfg.VerifyUploads {
+		t.Error("VerifyUploads = false, want true")
+	}
+	t.Setenv("S3LAZY_VERIFY_UPLOADS", "sometimes")
+	if err := loadConfigError(t); !strings.Contains(err, "S3LAZY_VERIFY_UPLOADS") {
+		t.Errorf("error = %q, want invalid S3LAZY_VERIFY_UPLOADS", err)
+	}
+}
+
+func TestLoadConfig_CacheEventWebhook(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_CACHE_EVENT_WEBHOOK", "https://hooks.internal/s3lazy")
+	if cfg := mustLoadConfig(t); cfg.CacheEventWebhook != "https://hooks.internal/s3lazy" {
+		t.Errorf("CacheEventWebhook = %q, want https://hooks.internal/s3lazy", cfg.CacheEventWebhook)
+	}
+
+	t.Setenv("S3LAZY_CACHE_EVENT_WEBHOOK", "hooks.internal")
+	if err := loadConfigError(t); !strings.Contains(err, "cache_event_webhook") {
+		t.Errorf("error = %q, want invalid cache_event_webhook", err)
+	}
+}
+
+func TestLoadConfig_AWSProfile(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_AWS_PROFILE", "dev-account")
+	if cfg := mustLoadConfig(t); cfg.AWSProfile != "dev-account" {
+		t.Errorf("AWSProfile = %q, want dev-account", cfg.AWSProfile)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_ACCESS_KEY", "AKIAEXAMPLE")
+	t.Setenv("S3LAZY_UPSTREAM_SECRET_KEY", "secret")
+	if err := loadConfigError(t); !strings.Contains(err, "aws_profile") {
+		t.Errorf("error = %q, want aws_profile conflicting with upstream_access_key", err)
+	}
+}
+
+func TestLoadConfig_WebIdentity(t *testing.T) {
+	clearS3LazyEnvVars(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("eyJhbGciOi..."), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The variables EKS injects are picked up
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/s3lazy")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	cfg := mustLoadConfig(t)
+	if cfg.AWSRoleARN != "arn:aws:iam::123456789012:role/s3lazy" || cfg.AWSWebIdentityTokenFile != tokenFile {
+		t.Errorf("AWSRoleARN = %q, AWSWebIdentityTokenFile = %q", cfg.AWSRoleARN, cfg.AWSWebIdentityTokenFile)
+	}
+
+	// s3lazy's own variables take priority
+	t.Setenv("S3LAZY_AWS_ROLE_ARN", "arn:aws:iam::210987654321:role/reader")
+	t.Setenv("S3LAZY_AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	if cfg := mustLoadConfig(t); cfg.AWSRoleARN != "arn:aws:iam::210987654321:role/reader" {
+		t.Errorf("AWSRoleARN = %q, want the S3LAZY_ setting", cfg.AWSRoleARN)
+	}
+
+	t.Setenv("S3LAZY_AWS_WEB_IDENTITY_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+	if err := loadConfigError(t); !strings.Contains(err, "aws_web_identity_token_file") {
+		t.Errorf("error = %q, want the missing token file rejected", err)
+	}
+	clearS3LazyEnvVars(t)
+	t.Setenv("S3LAZY_AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/s3lazy")
+	if err := loadConfigError(t); !strings.Contains(err, "aws_role_arn") {
+		t.Errorf("error = %q, want a role without a token file rejected", err)
+	}
+}
+
+func TestLoadConfig_EventSinks(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_EVENT_SINKS", "nats://nats.internal:4222/s3lazy, kafka+https://kafka-rest.internal/s3lazy-events")
+	if cfg := mustLoadConfig(t); len(cfg.EventSinks) != 2 || cfg.EventSinks[1] != "kafka+https://kafka-rest.internal/s3lazy-events" {
+		t.Errorf("EventSinks = %q", cfg.EventSinks)
+	}
+
+	for _, sink := range []string{"amqp://mq.internal/s3lazy", "kafka+http://kafka-rest.internal", "nats:///s3lazy"} {
+		t.Setenv("S3LAZY_EVENT_SINKS", sink)
+		if err := loadConfigError(t); !strings.Contains(err, "event_sinks") {
+			t.Errorf("%s: error = %q, want invalid event_sinks", sink, err)
+		}
+	}
+}
+
+func TestLoadConfig_Redaction(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_REDACT_PATTERNS", `\d{3,4}-\d{4}; [\w.]+@corp\.com`)
+	t.Setenv("S3LAZY_REDACT_ACTION", "reject")
+	cfg := mustLoadConfig(t)
+	if len(cfg.RedactPatterns) != 2 || cfg.RedactPatterns[0] != `\d{3,4}-\d{4}` || cfg.RedactAction != "reject" {
+		t.Errorf("RedactPatterns = %q, RedactAction = %q", cfg.RedactPatterns, cfg.RedactAction)
+	}
+
+	t.Setenv("S3LAZY_REDACT_PATTERNS", "(")
+	t.Setenv("S3LAZY_REDACT_ACTION", "mask")
+	err := loadConfigError(t)
+	if !strings.Contains(err, "redact_patterns") || !strings.Contains(err, "redact_action") {
+		t.Errorf("error = %q, want invalid pattern and action", err)
+	}
+}
+
+func TestLoadConfig_Prefetch(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	if cfg := mustLoadConfig(t); cfg.PrefetchConcurrency != defaultPrefetchConcurrency {
+		t.Errorf("PrefetchConcurrency default = %d, want %d", cfg.PrefetchConcurrency, defaultPrefetchConcurrency)
+	}
+
+	t.Setenv("S3LAZY_PREFETCH", "my-bucket/fixtures/,models")
+	t.Setenv("S3LAZY_PREFETCH_CONCURRENCY", "16")
+	cfg := mustLoadConfig(t)
+	if len(cfg.Prefetch) != 2 || cfg.Prefetch[0] != "my-bucket/fixtures/" || cfg.PrefetchConcurrency != 16 {
+		t.Errorf("Prefetch = %v (concurrency %d), want 2 prefixes with concurrency 16", cfg.Prefetch, cfg.PrefetchConcurrency)
+	}
+
+	t.Setenv("S3LAZY_PREFETCH_CONCURRENCY", "0")
+	if err := loadConfigError(t); !strings.Contains(err, "prefetch_concurrency") {
+		t.Errorf("error = %q, want invalid concurrency", err)
+	}
+}
+
+func TestLoadConfig_UpstreamEndpoints(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_UPSTREAM_ENDPOINTS", "https://minio-1:9000, https://minio-2:9000")
+	cfg := mustLoadConfig(t)
+	if len(cfg.UpstreamEndpoints) != 2 || cfg.UpstreamEndpoints[1] != "https://minio-2:9000" {
+		t.Errorf("UpstreamEndpoints = %v, want 2 endpoints", cfg.UpstreamEndpoints)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_ENDPOINTS", "minio-1:9000")
+	if err := loadConfigError(t); !strings.Contains(err, "upstream_endpoints") {
+		t.Errorf("error = %q, want invalid endpoint", err)
+	}
+}
+
+func TestLoadConfig_BucketFallbacks(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_BUCKET_FALLBACKS", "assets-staging:assets-prod|assets-dr, docs-staging:docs")
+	cfg := mustLoadConfig(t)
+	if got := cfg.BucketFallbacks["assets-staging"]; !slices.Equal(got, []string{"assets-prod", "assets-dr"}) {
+		t.Errorf("assets-staging chain = %v", got)
+	}
+	if got := cfg.BucketFallbacks["docs-staging"]; !slices.Equal(got, []string{"docs"}) {
+		t.Errorf("docs-staging chain = %v", got)
+	}
+
+	t.Setenv("S3LAZY_BUCKET_FALLBACKS", "assets-staging:assets-prod|assets-staging")
+	if err := loadConfigError(t); !strings.Contains(err, "twice") {
+		t.Errorf("error = %q, want a cycle rejected", err)
+	}
+}
+
+func TestLoadConfig_UpstreamEndpoint(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if !cfg.UpstreamPathStyle || len(cfg.upstreamEndpoints()) != 0 {
+		t.Errorf("defaults = %v, %v; want path style and no endpoints", cfg.UpstreamPathStyle, cfg.upstreamEndpoints())
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_ENDPOINT", "https://account.r2.cloudflarestorage.com")
+	t.Setenv("S3LAZY_UPSTREAM_PATH_STYLE", "false")
+	t.Setenv("S3LAZY_UPSTREAM_ACCESS_KEY", "key")
+	t.Setenv("S3LAZY_UPSTREAM_SECRET_KEY", "secret")
+	cfg = mustLoadConfig(t)
+	if got := cfg.upstreamEndpoints(); len(got) != 1 || got[0] != "https://account.r2.cloudflarestorage.com" {
+		t.Errorf("upstreamEndpoints() = %v", got)
+	}
+	if cfg.UpstreamPathStyle || cfg.UpstreamAccessKey != "key" || cfg.UpstreamSecretKey != "secret" {
+		t.Errorf("UpstreamPathStyle = %v, UpstreamAccessKey = %q, UpstreamSecretKey = %q", cfg.UpstreamPathStyle, cfg.UpstreamAccessKey, cfg.UpstreamSecretKey)
+	}
+	for _, s := range cfg.Effective() {
+		if s.Field == "upstream_secret_key" && s.Value != redacted {
+			t.Errorf("upstream_secret_key = %v, want it redacted", s.Value)
+		}
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_SECRET_KEY", "")
+	if err := loadConfigError(t); !strings.Contains(err, "upstream_secret_key") {
+		t.Errorf("error = %q, want an access key without a secret rejected", err)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_SECRET_KEY", "secret")
+	t.Setenv("S3LAZY_UPSTREAM_ENDPOINTS", "https://minio-1:9000")
+	if err := loadConfigError(t); !strings.Contains(err, "not both") {
+		t.Errorf("error = %q, want upstream_endpoint and upstream_endpoints rejected together", err)
+	}
+}
+
+func TestLoadConfig_MockUpstream(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	dir := t.TempDir()
+	t.Setenv("S3LAZY_MOCK_UPSTREAM", dir)
+	if cfg := mustLoadConfig(t); cfg.MockUpstream != dir {
+		t.Errorf("MockUpstream = %q, want %q", cfg.MockUpstream, dir)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_ENDPOINTS", "https://minio-1:9000")
+	if err := loadConfigError(t); !strings.Contains(err, "not both") {
+		t.Errorf("error = %q, want mock upstream and endpoints rejected together", err)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_ENDPOINTS", "")
+	t.Setenv("S3LAZY_MOCK_UPSTREAM", filepath.Join(dir, "missing"))
+	if err := loadConfigError(t); !strings.Contains(err, "mock_upstream:") {
+		t.Errorf("error = %q, want a missing directory rejected", err)
+	}
+}
+
+func TestLoadConfig_ListPrefetch(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.ListPrefetchConcurrency != 0 || cfg.ListPrefetchMaxBytes != defaultListPrefetchMaxBytes {
+		t.Errorf("defaults = %d, %d; want list prefetch off with a %d-byte budget", cfg.ListPrefetchConcurrency, cfg.ListPrefetchMaxBytes, defaultListPrefetchMaxBytes)
+	}
+
+	t.Setenv("S3LAZY_LIST_PREFETCH_CONCURRENCY", "4")
+	t.Setenv("S3LAZY_LIST_PREFETCH_MAX_BYTES", "1GiB")
+	cfg = mustLoadConfig(t)
+	if cfg.ListPrefetchConcurrency != 4 || cfg.ListPrefetchMaxBytes != 1<<30 {
+		t.Errorf("ListPrefetch = %d, %d; want 4, 1GiB", cfg.ListPrefetchConcurrency, cfg.ListPrefetchMaxBytes)
+	}
+
+	t.Setenv("S3LAZY_LIST_PREFETCH_CONCURRENCY", "-1")
+	if err := loadConfigError(t); !strings.Contains(err, "list_prefetch_concurrency: must not be negative") {
+		t.Errorf("error = %q, want a negative concurrency rejected", err)
+	}
+}
+
+func TestLoadConfig_OperationTimeouts(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_OPERATION_TIMEOUTS", "head:5s, get:10m")
+	cfg := mustLoadConfig(t)
+	if cfg.OperationTimeouts[opHead] != 5*time.Second || cfg.OperationTimeouts[opGet] != 10*time.Minute {
+		t.Errorf("OperationTimeouts = %v, want head 5s and get 10m", cfg.OperationTimeouts)
+	}
+
+	t.Setenv("S3LAZY_OPERATION_TIMEOUTS", "put:5s")
+	if err := loadConfigError(t); !strings.Contains(err, `unknown operation "put"`) {
+		t.Errorf("error = %q, want the unknown operation rejected", err)
+	}
+
+	t.Setenv("S3LAZY_OPERATION_TIMEOUTS", "list:-1s")
+	if err := loadConfigError(t); !strings.Contains(err, "operation_timeouts: list: must not be negative") {
+		t.Errorf("error = %q, want a negative timeout rejected", err)
+	}
+}
+
+func TestLoadConfig_BucketAliases(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_BUCKET_ALIASES", "prod-data:data,production:data")
+	cfg := mustLoadConfig(t)
+	if cfg.BucketAliases["prod-data"] != "data" || cfg.BucketAliases["production"] != "data" {
+		t.Errorf("BucketAliases = %v, want both aliases of data", cfg.BucketAliases)
+	}
+
+	t.Setenv("S3LAZY_BUCKET_ALIASES", "a:b,b:c")
+	if err := loadConfigError(t); !strings.Contains(err, "bucket_aliases: a -> b: b is itself an alias") {
+		t.Errorf("error = %q, want a chained alias rejected", err)
+	}
+
+	t.Setenv("S3LAZY_BUCKET_ALIASES", "prod-data:data")
+	t.Setenv("S3LAZY_BUCKET_MAP", "prod-data:prod")
+	if err := loadConfigError(t); !strings.Contains(err, "its bucket_mappings entry would never be used") {
+		t.Errorf("error = %q, want a mapping of an alias rejected", err)
+	}
+}
+
+func TestLoadConfig_PresignedUploads(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_PRESIGNED_UPLOADS", "true")
+	t.Setenv("S3LAZY_PRESIGNED_UPLOAD_EXPIRY", "30m")
+	cfg := mustLoadConfig(t)
+	if !cfg.PresignedUploads || cfg.PresignedUploadExpiry != 30*time.Minute {
+		t.Errorf("PresignedUploads = %v, %v; want on, 30m", cfg.PresignedUploads, cfg.PresignedUploadExpiry)
+	}
+
+	t.Setenv("S3LAZY_PRESIGNED_UPLOAD_EXPIRY", "200h")
+	if err := loadConfigError(t); !strings.Contains(err, "presigned_upload_expiry:") {
+		t.Errorf("error = %q, want an expiry beyond 7 days rejected", err)
+	}
+}
+
+func TestLoadConfig_CacheNamespace(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	t.Setenv("S3LAZY_CACHE_NAMESPACE", "prod-account")
+	if cfg := mustLoadConfig(t); cfg.CacheNamespace != "prod-account" {
+		t.Errorf("CacheNamespace = %q, want prod-account", cfg.CacheNamespace)
+	}
+}
+
+func TestLoadConfig_EvictionPolicy(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	if cfg := mustLoadConfig(t); cfg.EvictionPolicy != "lru" {
+		t.Errorf("EvictionPolicy = %q, want lru by default", cfg.EvictionPolicy)
+	}
+
+	t.Setenv("S3LAZY_EVICTION_POLICY", "arc")
+	if cfg := mustLoadConfig(t); cfg.EvictionPolicy != "arc" {
+		t.Errorf("EvictionPolicy = %q, want arc", cfg.EvictionPolicy)
+	}
+
+	t.Setenv("S3LAZY_EVICTION_POLICY", "fifo")
+	if err := loadConfigError(t); !strings.Contains(err, `eviction_policy: unknown eviction policy "fifo"`) {
+		t.Errorf("error = %q, want the unknown policy rejected", err)
+	}
+}
+
+func TestLoadConfig_Hedge(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.HedgeDelay != 0 || cfg.HedgeMaxBytes != defaultHedgeMaxBytes {
+		t.Errorf("defaults = %v, %d; want hedging off with a %d-byte limit", cfg.HedgeDelay, cfg.HedgeMaxBytes, defaultHedgeMaxBytes)
+	}
+
+	t.Setenv("S3LAZY_HEDGE_DELAY", "50ms")
+	t.Setenv("S3LAZY_HEDGE_MAX_BYTES", "64KiB")
+	cfg = mustLoadConfig(t)
+	if cfg.HedgeDelay != 50*time.Millisecond || cfg.HedgeMaxBytes != 64<<10 {
+		t.Errorf("Hedge = %v, %d; want 50ms, 64KiB", cfg.HedgeDelay, cfg.HedgeMaxBytes)
+	}
+
+	t.Setenv("S3LAZY_HEDGE_MAX_BYTES", "0")
+	if err := loadConfigError(t); !strings.Contains(err, "hedge_max_bytes: must be at least 1") {
+		t.Errorf("error = %q, want a zero limit rejected", err)
+	}
+
+	t.Setenv("S3LAZY_HEDGE_MAX_BYTES", "")
+	t.Setenv("S3LAZY_HEDGE_DELAY", "-1s")
+	if err := loadConfigError(t); !strings.Contains(err, "hedge_delay: must not be negative") {
+		t.Errorf("error = %q, want a negative delay rejected", err)
+	}
+}
+
+func TestLoadConfig_UpstreamRetry(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.UpstreamMaxAttempts != 0 || cfg.UpstreamMaxBackoff != 0 || cfg.UpstreamRetryOn != nil {
+		t.Errorf("defaults = %d, %v, %v; want the SDK's policy", cfg.UpstreamMaxAttempts, cfg.UpstreamMaxBackoff, cfg.UpstreamRetryOn)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_MAX_ATTEMPTS", "8")
+	t.Setenv("S3LAZY_UPSTREAM_MAX_BACKOFF", "5s")
+	t.Setenv("S3LAZY_UPSTREAM_RETRY_ON", "connection, server,XMinioServerNotInitialized")
+	cfg = mustLoadConfig(t)
+	if cfg.UpstreamMaxAttempts != 8 || cfg.UpstreamMaxBackoff != 5*time.Second ||
+		!slices.Equal(cfg.UpstreamRetryOn, []string{"connection", "server", "XMinioServerNotInitialized"}) {
+		t.Errorf("retry policy = %d, %v, %v", cfg.UpstreamMaxAttempts, cfg.UpstreamMaxBackoff, cfg.UpstreamRetryOn)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_RETRY_ON", "connections")
+	if err := loadConfigError(t); !strings.Contains(err, `upstream_retry_on: unknown class "connections"`) {
+		t.Errorf("error = %q, want a misspelled class rejected", err)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_RETRY_ON", "")
+	t.Setenv("S3LAZY_UPSTREAM_MAX_ATTEMPTS", "-1")
+	if err := loadConfigError(t); !strings.Contains(err, "upstream_max_attempts: must not be negative") {
+		t.Errorf("error = %q, want negative attempts rejected", err)
+	}
+}
+
+func TestLoadConfig_UpstreamConnTimeouts(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.UpstreamConnectTimeout != defaultUpstreamConnectTimeout || cfg.UpstreamReadTimeout != defaultUpstreamReadTimeout {
+		t.Errorf("defaults = %v, %v; want %v, %v", cfg.UpstreamConnectTimeout, cfg.UpstreamReadTimeout, defaultUpstreamConnectTimeout, defaultUpstreamReadTimeout)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_CONNECT_TIMEOUT", "3s")
+	t.Setenv("S3LAZY_UPSTREAM_READ_TIMEOUT", "0")
+	cfg = mustLoadConfig(t)
+	if cfg.UpstreamConnectTimeout != 3*time.Second || cfg.UpstreamReadTimeout != 0 {
+		t.Errorf("timeouts = %v, %v; want 3s and none", cfg.UpstreamConnectTimeout, cfg.UpstreamReadTimeout)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_READ_TIMEOUT", "-1s")
+	if err := loadConfigError(t); !strings.Contains(err, "upstream_read_timeout: must not be negative") {
+		t.Errorf("error = %q, want a negative timeout rejected", err)
+	}
+}
+
+func TestLoadConfig_UpstreamCircuit(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.UpstreamCircuitFailures != 0 || cfg.UpstreamCircuitCooldown != defaultCircuitCooldown {
+		t.Errorf("defaults = %d, %v; want the circuit breaker off with a %v cooldown", cfg.UpstreamCircuitFailures, cfg.UpstreamCircuitCooldown, defaultCircuitCooldown)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_CIRCUIT_FAILURES", "5")
+	t.Setenv("S3LAZY_UPSTREAM_CIRCUIT_COOLDOWN", "2m")
+	cfg = mustLoadConfig(t)
+	if cfg.UpstreamCircuitFailures != 5 || cfg.UpstreamCircuitCooldown != 2*time.Minute {
+		t.Errorf("circuit = %d, %v; want 5, 2m", cfg.UpstreamCircuitFailures, cfg.UpstreamCircuitCooldown)
+	}
+
+	t.Setenv("S3LAZY_UPSTREAM_CIRCUIT_COOLDOWN", "0s")
+	if err := loadConfigError(t); !strings.Contains(err, "upstream_circuit_cooldown: must be positive") {
+		t.Errorf("error = %q, want a zero cooldown rejected", err)
+	}
+}
+
+func TestLoadConfig_SLOs(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	cfg := mustLoadConfig(t)
+	if cfg.SLOs != nil || cfg.SLOWindow != defaultSLOWindow {
+		t.Errorf("defaults = %v, %v; want no objectives over %v", cfg.SLOs, cfg.SLOWindow, defaultSLOWindow)
+	}
+
+	t.Setenv("S3LAZY_SLOS", "get_hit:p99<20ms, get_miss:errors<1%")
+	t.Setenv("S3LAZY_SLO_WINDOW", "10m")
+	cfg = mustLoadConfig(t)
+	if !slices.Equal(cfg.SLOs, []string{"get_hit:p99<20ms", "get_miss:errors<1%"}) || cfg.SLOWindow != 10*time.Minute {
+		t.Errorf("objectives = %v over %v", cfg.SLOs, cfg.SLOWindow)
+	}
+
+	t.Setenv("S3LAZY_SLOS", "get_hits:p99<20ms")
+	if err := loadConfigError(t); !strings.Contains(err, `slos: "get_hits:p99<20ms": unknown operation`) {
+		t.Errorf("error = %q, want an unknown operation rejected", err)
+	}
+
+	t.Setenv("S3LAZY_SLOS", "get:p99<1s")
+	t.Setenv("S3LAZY_SLO_WINDOW", "500ms")
+	if err := loadConfigError(t); !strings.Contains(err, "slo_window: must be at least 1s") {
+		t.Errorf("error = %q, want a short window rejected", err)
+	}
+}
+
+func TestLoadConfig_YAMLFile(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	// Create a temporary YAML config file
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	yamlContent := `
+listen_addr: ":8888"
+backend_type: "localstack"
+data_dir: "/yaml/data"
+localstack_endpoint: "http://yaml-localstack:4566"
+aws_region: "eu-central-1"
+cache_max_bytes: "512MiB"
+cache_ttl: "15m"
+bucket_ttls:
+  yaml-bucket-1: "1h"
+init_buckets:
+  - "yaml-bucket-1"
+  - "yaml-bucket-2"
+bucket_mappings:
+  yaml-local: "yaml-aws"
+`
+
+	if err := os.WriteFile(configPath, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+
+	cfg := mustLoadConfig(t)
+
+	if cfg.ListenAddr != ":8888" {
+		t.Errorf("ListenAddr = %q, want %q", cfg.ListenAddr, ":8888")
+	}
+	if cfg.BackendType != "localstack" {
+		t.Errorf("BackendType = %q, want %q", cfg.BackendType, "localstack")
+	}
+	if cfg.DataDir != "/yaml/data" {
+		t.Errorf("DataDir = %q, want %q", cfg.DataDir, "/yaml/data")
+	}
+	if cfg.CacheTTL != 15*time.Minute || cfg.BucketTTLs["yaml-bucket-1"] != time.Hour {
+		t.Errorf("CacheTTL = %v, BucketTTLs = %v, want 15m and yaml-bucket-1:1h", cfg.CacheTTL, cfg.BucketTTLs)
+	}
+	if cfg.CacheMaxBytes != 512<<20 {
+		t.Errorf("CacheMaxBytes = %d, want %d", cfg.CacheMaxBytes, 512<<20)
+	}
+	if cfg.LocalStackEndpoint != "http://yaml-localstack:4566" {
+		t.Errorf("LocalStackEndpoint = %q, want %q", cfg.LocalStackEndpoint, "http://yaml-localstack:4566")
+	}
+	if cfg.AWSRegion != "eu-central-1" {
+		t.Errorf("AWSRegion = %q, want %q", cfg.AWSRegion, "eu-central-1")
+	}
+	if len(cfg.InitBuckets) != 2 || cfg.InitBuckets[0].Name != "yaml-bucket-1" {
+		t.Errorf("InitBuckets = %v, want [yaml-bucket-1 yaml-bucket-2]", cfg.InitBuckets)
+	}
+	if cfg.BucketMappings["yaml-local"] != "yaml-aws" {
+		t.Errorf("BucketMappings[yaml-local] = %q, want %q", cfg.BucketMappings["yaml-local"], "yaml-aws")
+	}
+}
+
+func TestLoadConfig_EnvOverridesYAML(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	// Create a temporary YAML config file
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	yamlContent := `
+listen_addr: ":8888"
+backend_type: "disk"
+`
+
+	if err := os.WriteFile(configPath, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+	// Env var should override YAML
+	t.Setenv("S3LAZY_BACKEND", "localstack")
+
+	cfg := mustLoadConfig(t)
+
+	// YAML value
+	if cfg.ListenAddr != ":8888" {
+		t.Errorf("ListenAddr = %q, want %q", cfg.ListenAddr, ":8888")
+	}
+	// Env var override
+	if cfg.BackendType != "localstack" {
+		t.Errorf("BackendType = %q, want %q (env should override yaml)", cfg.BackendType, "localstack")
+	}
+}
+
+func TestLoadConfig_InvalidYAMLFile(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	// A config file that was asked for but can't be read is an error
+	t.Setenv("S3LAZY_CONFIG_FILE", "/nonexistent/config.yaml")
+
+	if err := loadConfigError(t); !strings.Contains(err, "/nonexistent/config.yaml") {
+		t.Errorf("error = %q, want it to name the missing file", err)
+	}
+}
+
+func TestLoadConfig_MalformedYAML(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	// Invalid YAML - tabs are not allowed in YAML
+	malformedYAML := "backend_type: localstack\n\t\tinvalid indentation"
+
+	if err := os.WriteFile(configPath, []byte(malformedYAML), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+
+	if err := loadConfigError(t); !strings.Contains(err, "line 2") {
+		t.Errorf("error = %q, want the offending line", err)
+	}
+}
+
+func TestLoadConfig_BucketUpstreams(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+bucket_upstreams:
+  billing:
+    bucket: billing-prod
+    region: eu-west-1
+    profile: billing-readonly
+  archive:
+    endpoint: "https://s3.eu-central-1.wasabisys.com"
+    path_style: false
+    access_key: key
+    secret_key: secret
+`
+	if err := os.WriteFile(configPath, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+
+	cfg := mustLoadConfig(t)
+	if cfg.BucketMappings["billing"] != "billing-prod" {
+		t.Errorf("BucketMappings[billing] = %q, want billing-prod", cfg.BucketMappings["billing"])
+	}
+	if u := cfg.BucketUpstreams["billing"]; u.Region != "eu-west-1" || u.Profile != "billing-readonly" {
+		t.Errorf("billing upstream = %+v", u)
+	}
+	if u := cfg.BucketUpstreams["archive"]; u.PathStyle == nil || *u.PathStyle || u.AccessKey != "key" {
+		t.Errorf("archive upstream = %+v", u)
+	}
+	if bucketUpstreamIdentity(cfg, cfg.BucketUpstreams["billing"]) == upstreamIdentity(cfg) {
+		t.Error("a bucket with its own profile should have its own identity")
+	}
+	for _, s := range cfg.Effective() {
+		if s.Field != "bucket_upstreams" {
+			continue
+		}
+		archive, _ := s.Value.(map[string]any)["archive"].(map[string]any)
+		if archive["secret_key"] != redacted {
+			t.Errorf("archive secret_key = %v, want it redacted", archive["secret_key"])
+		}
+	}
+
+	t.Setenv("S3LAZY_BUCKET_MAP", "billing:billing-staging")
+	if err := loadConfigError(t); !strings.Contains(err, "conflicts with bucket_mappings") {
+		t.Errorf("error = %q, want the conflicting mapping rejected", err)
+	}
+}
+
+func TestLoadConfig_InitBucketTemplates(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	yamlContent := `
+cache_allow_buckets: [other]
+init_buckets:
+  - plain
+  - name: assets
+    upstream: prod-assets
+    policy: cache
+    ttl: 5m
+    read_only: true
+    warm_prefixes: [fixtures/, models/v1/]
+  - name: uploads
+    policy: proxy
+`
+	if err := os.WriteFile(configPath, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+
+	cfg := mustLoadConfig(t)
+	if names := cfg.initBucketNames(); !slices.Equal(names, []string{"plain", "assets", "uploads"}) {
+		t.Errorf("init bucket names = %v, want [plain assets uploads]", names)
+	}
+	if cfg.BucketMappings["assets"] != "prod-assets" {
+		t.Errorf("BucketMappings[assets] = %q, want prod-assets", cfg.BucketMappings["assets"])
+	}
+	if cfg.BucketTTLs["assets"] != 5*time.Minute {
+		t.Errorf("BucketTTLs[assets] = %v, want 5m", cfg.BucketTTLs["assets"])
+	}
+	if !slices.Equal(cfg.CacheAllowBuckets, []string{"other", "assets"}) || !slices.Equal(cfg.CacheDenyBuckets, []string{"uploads"}) {
+		t.Errorf("cache policy = allow %v, deny %v; want allow [other assets], deny [uploads]", cfg.CacheAllowBuckets, cfg.CacheDenyBuckets)
+	}
+	if !slices.Equal(cfg.ReadOnlyBuckets, []string{"assets"}) {
+		t.Errorf("ReadOnlyBuckets = %v, want [assets]", cfg.ReadOnlyBuckets)
+	}
+	if !slices.Equal(cfg.Prefetch, []string{"assets/fixtures/", "assets/models/v1/"}) {
+		t.Errorf("Prefetch = %v, want the assets warm prefixes", cfg.Prefetch)
+	}
+
+	for _, tt := range []struct {
+		yaml, want string
+	}{
+		{"init_buckets:\n  - name: a\n    polcy: proxy\n", `unknown init_buckets field "polcy"`},
+		{"init_buckets:\n  - name: a\n    policy: sometimes\n", `unknown policy "sometimes"`},
+		{"init_buckets:\n  - upstream: b\n", "entry without a name"},
+		{"bucket_ttls: {a: 1h}\ninit_buckets:\n  - name: a\n    ttl: 5m\n", "conflicts with bucket_ttls"},
+	} {
+		if err := os.WriteFile(configPath, []byte(tt.yaml), 0644); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		if err := loadConfigError(t); !strings.Contains(err, tt.want) {
+			t.Errorf("error = %q, want it to contain %q", err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfig_YAMLWrongFieldName(t *testing.T) {
+	clearS3LazyEnvVars(t)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	// Common mistake: using camelCase instead of snake_case
+	yamlContent := "listen_addr: \":8080\"\nbackendType: localstack\nbucketMapz: {}\n"
+
+	if err := os.WriteFile(configPath, []byte(yamlContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+
+	err := loadConfigError(t)
+	for _, want := range []string{
+		`line 2: unknown field "backendType" (did you mean "backend_type"?)`,
+		`line 3: unknown field "bucketMapz"`,
+	} {
+		if !strings.Contains(err, want) {
+			t.Errorf("error = %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestLoadConfig_AdminListener(t *testing.T) {
+	clearS3LazyEnvVars(t)
+	if cfg := mustLoadConfig(t); cfg.AdminListenAddr != "127.0.0.1:9001" || cfg.AdminToken != "" {
+		t.Errorf("admin listener = %q, token %q; want 127.0.0.1:9001 without a token", cfg.AdminListenAddr, cfg.AdminToken)
+	}
+
+	// Other hosts may reach the admin API once it needs a token
+	t.Setenv("S3LAZY_ADMIN_LISTEN_ADDR", ":9001")
+	t.Setenv("S3LAZY_ADMIN_TOKEN", "s3cret")
+	if cfg := mustLoadConfig(t); cfg.AdminListenAddr != ":9001" || cfg.AdminToken != "s3cret" {
+		t.Errorf("admin listener = %q, token %q; want :9001 with the token", cfg.AdminListenAddr, cfg.AdminToken)
+	}
+}
+
+func TestLoadConfig_InvalidValues(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		yaml string
+		want string
+	}{
+		{"unknown backend", map[string]string{"S3LAZY_BACKEND": "custom"}, "", `backend_type: unknown backend "custom"`},
+		{"unknown bucket backend", map[string]string{"S3LAZY_BUCKET_BACKENDS": "a:tape"}, "", `bucket_backends: bucket a: unknown backend "tape"`},
+		{"malformed mapping", map[string]string{"S3LAZY_BUCKET_MAP": "local1:aws1,invalid"}, "", `S3LAZY_BUCKET_MAP: malformed entry "invalid"`},
+		{"unknown quirks", map[string]string{"S3LAZY_UPSTREAM_QUIRKS": "swift"}, "", "upstream_quirks:"},
+		{"bad url source", map[string]string{"S3LAZY_URL_SOURCES": "cdn:ftp://host/{key}"}, "", "url_sources: bucket cdn:"},
+		{"bad yaml duration", nil, "cache_ttl: soon\n", "line 1: cannot unmarshal !!str `soon` into time.Duration"},
+		{"bad yaml size", nil, "cache_max_bytes: lots\n", `line 1: invalid size "lots"`},
+		{"negative ttl", nil, "cache_ttl: -5m\n", "cache_ttl: must not be negative"},
+		{"admin on the s3 listener", map[string]string{"S3LAZY_ADMIN_LISTEN_ADDR": ":9000"}, "", "admin_listen_addr: must differ from listen_addr :9000"},
+		{"public admin without a token", map[string]string{"S3LAZY_ADMIN_LISTEN_ADDR": ":9001"}, "", "admin_listen_addr: :9001 is reachable from other hosts; set admin_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearS3LazyEnvVars(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if tt.yaml != "" {
+				configPath := filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(configPath, []byte(tt.yaml), 0644); err != nil {
+					t.Fatalf("Failed to write config file: %v", err)
+				}
+				t.Setenv("S3LAZY_CONFIG_FILE", configPath)
+			}
+
+			if err := loadConfigError(t); !strings.Contains(err, tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
 	}
 }
 
@@ -372,14 +1753,122 @@ func clearS3LazyEnvVars(t *testing.T) {
 	t.Helper()
 	envVars := []string{
 		"S3LAZY_LISTEN_ADDR",
+		"S3LAZY_ADMIN_LISTEN_ADDR",
+		"S3LAZY_ADMIN_TOKEN",
+		"S3LAZY_MEMORY_SNAPSHOT_INTERVAL",
+		"S3LAZY_LISTEN_MAX_CONNECTIONS",
+		"S3LAZY_LISTEN_MAX_HEADER_BYTES",
+		"S3LAZY_LISTEN_MAX_BODY_BYTES",
+		"S3LAZY_LISTEN_HEADER_TIMEOUT",
+		"S3LAZY_LISTEN_READ_TIMEOUT",
+		"S3LAZY_LISTEN_WRITE_TIMEOUT",
+		"S3LAZY_LISTEN_IDLE_TIMEOUT",
+		"S3LAZY_SHUTDOWN_GRACE_PERIOD",
 		"S3LAZY_BACKEND",
 		"S3LAZY_DATA_DIR",
+		"S3LAZY_SHARED_DATA_DIR",
+		"S3LAZY_INSTANCE_ID",
 		"S3LAZY_LOCALSTACK_ENDPOINT",
 		"S3LAZY_AWS_REGION",
+		"S3LAZY_AWS_PROFILE",
+		"S3LAZY_UPSTREAM_QUIRKS",
 		"S3LAZY_CONFIG_FILE",
 		"S3LAZY_INIT_BUCKETS",
 		"S3LAZY_BUCKET_MAP",
+		"S3LAZY_WARM_MANIFEST",
+		"S3LAZY_READY_AFTER_WARM",
+		"S3LAZY_PREFIX_STATS_DEPTH",
+		"S3LAZY_EVENT_LOG_KEYS",
+		"S3LAZY_SLOS",
+		"S3LAZY_SLO_WINDOW",
+		"S3LAZY_TTL_JITTER_PERCENT",
+		"S3LAZY_TTL_FROM_HEADERS",
+		"S3LAZY_TTL_FROM_HEADERS_MIN",
+		"S3LAZY_TTL_FROM_HEADERS_MAX",
+		"S3LAZY_CACHE_MAX_BYTES",
+		"S3LAZY_STREAM_THRESHOLD",
+		"S3LAZY_FOLLOW_INTERVAL",
+		"S3LAZY_KEY_FILTER_BUCKETS",
+		"S3LAZY_KEY_FILTER_REFRESH",
+		"S3LAZY_CHUNK_SIZE",
+		"S3LAZY_CHUNK_CACHE_MAX_BYTES",
+		"S3LAZY_RANGE_PASSTHROUGH",
+		"S3LAZY_RANGE_BACKGROUND_FILL",
+		"S3LAZY_IDENTITY_HEADER",
+		"S3LAZY_DISK_HIGH_WATERMARK",
+		"S3LAZY_DISK_LOW_WATERMARK",
+		"S3LAZY_DISK_COMPRESSION",
+		"S3LAZY_PACK_THRESHOLD",
+		"S3LAZY_PACK_COMPACT_INTERVAL",
+		"S3LAZY_ENCRYPTION_KEY",
+		"S3LAZY_ENCRYPTION_KEY_FILE",
+		"S3LAZY_BUCKET_BACKENDS",
+		"S3LAZY_LOCALSTACK_RESEED",
+		"S3LAZY_CACHE_TTL",
+		"S3LAZY_BUCKET_TTLS",
+		"S3LAZY_MAX_OBJECTS_PER_BUCKET",
+		"S3LAZY_BUCKET_MAX_OBJECTS",
+		"S3LAZY_LOCAL_QUOTA",
+		"S3LAZY_BUCKET_LOCAL_QUOTAS",
+		"S3LAZY_REVALIDATE",
+		"S3LAZY_REFRESH_AHEAD",
+		"S3LAZY_REFRESH_AHEAD_MIN_HITS",
+		"S3LAZY_PINS",
+		"S3LAZY_STRICT",
+		"S3LAZY_CHAOS_LIST_DELAY",
+		"S3LAZY_TRAFFIC_SCHEDULE",
+		"S3LAZY_TAG_RULES",
+		"S3LAZY_CACHE_ALLOW_BUCKETS",
+		"S3LAZY_CACHE_DENY_BUCKETS",
+		"S3LAZY_READ_ONLY_BUCKETS",
+		"S3LAZY_NO_CACHE_PATTERNS",
+		"S3LAZY_REPORT_BUCKET",
+		"S3LAZY_CACHE_EVENT_WEBHOOK",
+		"S3LAZY_EVENT_SINKS",
+		"S3LAZY_REPORT_PREFIX",
+		"S3LAZY_REPORT_INTERVAL",
+		"S3LAZY_BACKUP_BUCKET",
+		"S3LAZY_BACKUP_PREFIX",
+		"S3LAZY_BACKUP_INTERVAL",
+		"S3LAZY_TEAM_CACHE_BUCKET",
+		"S3LAZY_TEAM_CACHE_PREFIX",
+		"S3LAZY_TEAM_CACHE_REGION",
+		"S3LAZY_VERIFY_UPLOADS",
+		"S3LAZY_REDACT_PATTERNS",
+		"S3LAZY_REDACT_ACTION",
+		"S3LAZY_PREFETCH",
+		"S3LAZY_UPSTREAM_ENDPOINTS",
+		"S3LAZY_BUCKET_FALLBACKS",
+		"S3LAZY_UPSTREAM_ENDPOINT",
+		"S3LAZY_UPSTREAM_PATH_STYLE",
+		"S3LAZY_UPSTREAM_ACCESS_KEY",
+		"S3LAZY_UPSTREAM_SECRET_KEY",
+		"S3LAZY_MOCK_UPSTREAM",
+		"S3LAZY_PREFETCH_CONCURRENCY",
+		"S3LAZY_OPERATION_TIMEOUTS",
+		"S3LAZY_LIST_PREFETCH_CONCURRENCY",
+		"S3LAZY_LIST_PREFETCH_MAX_BYTES",
+		"S3LAZY_HEDGE_DELAY",
+		"S3LAZY_UPSTREAM_MAX_ATTEMPTS",
+		"S3LAZY_UPSTREAM_MAX_BACKOFF",
+		"S3LAZY_UPSTREAM_RETRY_ON",
+		"S3LAZY_UPSTREAM_CONNECT_TIMEOUT",
+		"S3LAZY_UPSTREAM_READ_TIMEOUT",
+		"S3LAZY_UPSTREAM_CIRCUIT_FAILURES",
+		"S3LAZY_UPSTREAM_CIRCUIT_COOLDOWN",
+		"S3LAZY_EVICTION_POLICY",
+		"S3LAZY_CACHE_NAMESPACE",
+		"S3LAZY_PRESIGNED_UPLOADS",
+		"S3LAZY_BUCKET_ALIASES",
+		"S3LAZY_PRESIGNED_UPLOAD_EXPIRY",
+		"S3LAZY_HEDGE_MAX_BYTES",
+		"S3LAZY_URL_SOURCES",
+		"S3LAZY_URL_SOURCE_REVALIDATE",
 		"AWS_REGION",
+		"S3LAZY_AWS_ROLE_ARN",
+		"S3LAZY_AWS_WEB_IDENTITY_TOKEN_FILE",
+		"AWS_ROLE_ARN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE",
 	}
 	for _, env := range envVars {
 		t.Setenv(env, "")
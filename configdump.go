@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secret values in the effective configuration.
+const redacted = "REDACTED"
+
+// secretFields are settings whose whole value is secret.
+var secretFields = map[string]bool{"encryption_key": true, "upstream_secret_key": true, "secret_key": true, "admin_token": true}
+
+// configSetting is one resolved setting of the effective configuration.
+type configSetting struct {
+	Field  string `json:"field"`
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+// Effective returns every setting with its resolved value and where it came
+// from ("default", "file <path>" or "env <VAR>"), sorted by field name.
+// Secrets are redacted.
+func (c *Config) Effective() []configSetting {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		log.Printf("Warning: failed to render effective config: %v", err)
+		return nil
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		log.Printf("Warning: failed to render effective config: %v", err)
+		return nil
+	}
+
+	settings := make([]configSetting, 0, len(values))
+	for field, v := range values {
+		source := c.Sources[field]
+		if source == "" {
+			source = "default"
+		}
+		if secretFields[field] && v != "" {
+			v = redacted
+		}
+		settings = append(settings, configSetting{Field: field, Value: redact(v), Source: source})
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Field < settings[j].Field })
+	return settings
+}
+
+// redact hides credentials embedded in URL values, such as pre-signed URL
+// signatures in query strings and passwords in userinfo, and secret fields
+// of nested settings.
+func redact(v any) any {
+	switch v := v.(type) {
+	case string:
+		return redactURL(v)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			if secretFields[k] && item != "" {
+				out[k] = redacted
+				continue
+			}
+			out[k] = redact(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = redact(item)
+		}
+		return out
+	}
+	return v
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	switch u.Scheme {
+	case "http", "https", "kafka+http", "kafka+https", "nats":
+	default:
+		return s
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	} else if u.Scheme == "nats" && u.User != nil {
+		// A NATS user without a password is an auth token
+		u.User = url.User(redacted)
+	}
+	// Keep template placeholders readable rather than percent-encoded
+	out, _ := url.PathUnescape(u.String())
+	return out
+}
+
+// logEffectiveConfig logs the resolved configuration at startup.
+func logEffectiveConfig(c *Config) {
+	log.Printf("Effective configuration:")
+	for _, s := range c.Effective() {
+		value, _ := json.Marshal(s.Value)
+		log.Printf("  %s = %s (%s)", s.Field, value, s.Source)
+	}
+}
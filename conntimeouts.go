@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// Connection timeouts of upstream clients unless configured otherwise.
+const (
+	defaultUpstreamConnectTimeout = 10 * time.Second
+	defaultUpstreamReadTimeout    = time.Minute
+)
+
+// dialFunc is the signature of http.Transport.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// readTimeoutConn fails a read that gets no data from upstream for timeout,
+// so a response that hangs, before its headers or halfway through its body,
+// fails instead of blocking the request it serves. Idle keep-alive
+// connections are closed after timeout too.
+type readTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *readTimeoutConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// withConnTimeouts bounds how long dial may take to connect, and wraps the
+// connections it makes so reads time out. Zero leaves either unbounded.
+func withConnTimeouts(dial dialFunc, connect, read time.Duration) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if connect > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, connect)
+			defer cancel()
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil || read <= 0 {
+			return conn, err
+		}
+		return &readTimeoutConn{Conn: conn, timeout: read}, nil
+	}
+}
+
+// applyConnTimeouts sets the configured connect and read timeouts on the
+// transport of an upstream client. The connect timeout covers the TLS
+// handshake too.
+func applyConnTimeouts(tr *http.Transport, cfg *Config) {
+	tr.DialContext = withConnTimeouts(tr.DialContext, cfg.UpstreamConnectTimeout, cfg.UpstreamReadTimeout)
+	if cfg.UpstreamConnectTimeout > 0 {
+		tr.TLSHandshakeTimeout = cfg.UpstreamConnectTimeout
+	}
+}
+
+// upstreamHTTPClient returns the SDK's HTTP client with the configured
+// connection timeouts, or nil to leave the SDK's in place.
+func upstreamHTTPClient(cfg *Config) *awshttp.BuildableClient {
+	if cfg.UpstreamConnectTimeout == 0 && cfg.UpstreamReadTimeout == 0 {
+		return nil
+	}
+	return awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		applyConnTimeouts(tr, cfg)
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// timeoutTestClient creates an upstream client for url with a short read
+// timeout and no retries.
+func timeoutTestClient(t *testing.T, url string) upstreamLister {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.UpstreamEndpoint = url
+	cfg.UpstreamAccessKey, cfg.UpstreamSecretKey = "test", "test"
+	cfg.UpstreamMaxAttempts = 1
+	cfg.UpstreamReadTimeout = 100 * time.Millisecond
+	client, err := createUpstreamClient(cfg, BucketUpstream{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestUpstreamReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// Never answer
+			<-release
+			return
+		}
+		// Send half the body, then stall
+		w.Header().Set("Content-Length", "10")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	client := timeoutTestClient(t, server.URL)
+	bucket, key := aws.String("test-bucket"), aws.String("key")
+
+	start := time.Now()
+	if _, err := client.HeadObject(t.Context(), &s3.HeadObjectInput{Bucket: bucket, Key: key}); err == nil {
+		t.Error("HEAD that never got an answer succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("HEAD took %v to time out", elapsed)
+	}
+
+	start = time.Now()
+	out, err := client.GetObject(t.Context(), &s3.GetObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	defer out.Body.Close()
+	if _, err := io.ReadAll(out.Body); err == nil {
+		t.Error("stalled body read in full")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GET took %v to time out", elapsed)
+	}
+}
+
+func TestWithConnTimeouts(t *testing.T) {
+	// A dial that hangs until it is given up on
+	hang := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	start := time.Now()
+	if _, err := withConnTimeouts(hang, 50*time.Millisecond, 0)(t.Context(), "tcp", "upstream:443"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("dial = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("dial took %v to time out", elapsed)
+	}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) { return client, nil }
+	conn, err := withConnTimeouts(dial, 0, 0)(t.Context(), "tcp", "upstream:443")
+	if err != nil || conn != client {
+		t.Errorf("dial without timeouts = %v, %v; want the connection as it is", conn, err)
+	}
+}
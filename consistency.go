@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// listLag hides newly written keys from listings for a fixed delay, simulating
+// the list-after-write lag of eventually consistent object stores. Reads of
+// the key itself are unaffected, as they were on S3 before 2020.
+type listLag struct {
+	delay time.Duration
+	now   func() time.Time
+
+	mu sync.Mutex
+	// visibleAt is when each recently created key starts showing up in listings
+	visibleAt map[entryKey]time.Time
+}
+
+// newListLag creates a list lag of delay, or nil (disabled) if delay is 0.
+func newListLag(delay time.Duration) *listLag {
+	if delay <= 0 {
+		return nil
+	}
+	return &listLag{delay: delay, now: time.Now, visibleAt: make(map[entryKey]time.Time)}
+}
+
+// created records that a key which didn't exist before was just written.
+func (l *listLag) created(bucket, key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.visibleAt[entryKey{bucket, key}] = l.now().Add(l.delay)
+}
+
+// forget stops hiding a key, e.g. because it was deleted.
+func (l *listLag) forget(bucket, key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.visibleAt, entryKey{bucket, key})
+}
+
+// filter removes keys that aren't visible yet from a listing of bucket, and
+// drops keys whose delay has passed.
+func (l *listLag) filter(bucket string, list *gofakes3.ObjectList) {
+	if l == nil || list == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for k, at := range l.visibleAt {
+		if !now.Before(at) {
+			delete(l.visibleAt, k)
+		}
+	}
+	if len(l.visibleAt) == 0 {
+		return
+	}
+
+	visible := list.Contents[:0]
+	for _, c := range list.Contents {
+		if _, hidden := l.visibleAt[entryKey{bucket, c.Key}]; !hidden {
+			visible = append(visible, c)
+		}
+	}
+	list.Contents = visible
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_ListLag(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	put := func(key string) {
+		t.Helper()
+		if _, err := lazyBackend.PutObject("test-bucket", key, nil,
+			bytes.NewReader([]byte(key)), int64(len(key)), nil); err != nil {
+			t.Fatalf("PutObject(%s): %v", key, err)
+		}
+	}
+	listed := func() map[string]bool {
+		t.Helper()
+		list, err := lazyBackend.ListBucket("test-bucket", nil, gofakes3.ListBucketPage{})
+		if err != nil {
+			t.Fatalf("ListBucket: %v", err)
+		}
+		keys := make(map[string]bool)
+		for _, c := range list.Contents {
+			keys[c.Key] = true
+		}
+		return keys
+	}
+
+	put("old.txt")
+	lazyBackend.SetListLag(time.Minute)
+	now := time.Now()
+	lazyBackend.listLag.now = func() time.Time { return now }
+
+	put("new.txt")
+	put("old.txt")
+	if keys := listed(); keys["new.txt"] || !keys["old.txt"] {
+		t.Errorf("listed %v, want old.txt but not the new key", keys)
+	}
+	if _, err := lazyBackend.HeadObject("test-bucket", "new.txt"); err != nil {
+		t.Errorf("new key should be readable immediately: %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if keys := listed(); !keys["new.txt"] {
+		t.Errorf("listed %v, want new.txt after the delay", keys)
+	}
+
+	put("gone.txt")
+	if _, err := lazyBackend.DeleteObject("test-bucket", "gone.txt"); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	if len(lazyBackend.listLag.visibleAt) != 0 {
+		t.Errorf("deleted key should no longer be tracked, have %d", len(lazyBackend.listLag.visibleAt))
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// rejectedCredentialCodes are the upstream error codes meaning the
+// credentials a request was signed with expired or aren't valid.
+var rejectedCredentialCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+	"TokenRefreshRequired":  true,
+	"InvalidToken":          true,
+	"InvalidAccessKeyId":    true,
+	"InvalidClientTokenId":  true,
+}
+
+// credentialsUnavailableError is returned when upstream credentials couldn't
+// be obtained at all, e.g. because an SSO session expired or STS refused to
+// issue new ones.
+type credentialsUnavailableError struct {
+	err error
+}
+
+func (e *credentialsUnavailableError) Error() string {
+	return "retrieving upstream credentials: " + e.err.Error()
+}
+
+func (e *credentialsUnavailableError) Unwrap() error {
+	return e.err
+}
+
+// isRejectedCredentials reports whether upstream refused a request for the
+// credentials it was signed with.
+func isRejectedCredentials(err error) bool {
+	return rejectedCredentialCodes[s3ErrorCode(err)]
+}
+
+// isCredentialError reports whether an upstream request failed because of
+// s3lazy's own credentials rather than the object or upstream's health.
+func isCredentialError(err error) bool {
+	var unavailable *credentialsUnavailableError
+	return errors.As(err, &unavailable) || isRejectedCredentials(err)
+}
+
+// upstreamCredentials provides the credentials of an upstream client and
+// keeps track of whether they work. Credentials upstream rejects are dropped
+// from the cache so the request is retried with fresh ones, which makes a
+// long-running instance pick up renewed SSO sessions, profiles and roles
+// instead of failing until it is restarted.
+type upstreamCredentials struct {
+	name     string
+	provider aws.CredentialsProvider
+
+	// refreshable is false for static keys, which retrying can't fix
+	refreshable bool
+
+	mu           sync.Mutex
+	failures     int64
+	refreshes    int64
+	failingSince time.Time
+	lastError    error
+}
+
+func newUpstreamCredentials(name string, provider aws.CredentialsProvider, refreshable bool) *upstreamCredentials {
+	return &upstreamCredentials{name: name, provider: provider, refreshable: refreshable}
+}
+
+// Retrieve implements aws.CredentialsProvider.
+func (c *upstreamCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		err = &credentialsUnavailableError{err}
+		if ctx.Err() == nil {
+			c.failed(err)
+		}
+		return creds, err
+	}
+	return creds, nil
+}
+
+// refresh drops the cached credentials, so the next request retrieves new
+// ones.
+func (c *upstreamCredentials) refresh(cause error) bool {
+	cache, ok := c.provider.(*aws.CredentialsCache)
+	if !c.refreshable || !ok {
+		return false
+	}
+	cache.Invalidate()
+	c.mu.Lock()
+	c.refreshes++
+	c.mu.Unlock()
+	log.Printf("[CREDENTIALS] %s rejected its credentials (%s), refreshing them", c.name, s3ErrorCode(cause))
+	return true
+}
+
+// observe records the outcome of a request attempt signed with the
+// credentials.
+func (c *upstreamCredentials) observe(err error) {
+	var unavailable *credentialsUnavailableError
+	switch {
+	case errors.As(err, &unavailable):
+		// Already recorded by Retrieve
+	case isRejectedCredentials(err):
+		c.failed(err)
+	case err == nil || answeredWithCode(err):
+		// Upstream accepted the credentials
+		c.succeeded()
+	}
+}
+
+// answeredWithCode reports whether upstream answered a request with an error
+// that says nothing about its credentials. HEAD responses have no body to
+// tell ExpiredToken apart from other 400s and 403s, so those don't count.
+func answeredWithCode(err error) bool {
+	var respErr *awshttp.ResponseError
+	if !errors.As(err, &respErr) || s3ErrorCode(err) == "" {
+		return false
+	}
+	status := respErr.HTTPStatusCode()
+	return status != http.StatusBadRequest && status != http.StatusForbidden
+}
+
+func (c *upstreamCredentials) failed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures++
+	c.lastError = err
+	if c.failingSince.IsZero() {
+		c.failingSince = time.Now()
+		log.Printf("[CREDENTIALS] %s credentials failing: %v", c.name, err)
+	}
+}
+
+func (c *upstreamCredentials) succeeded() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.failingSince.IsZero() {
+		log.Printf("[CREDENTIALS] %s credentials working again after %v", c.name, time.Since(c.failingSince).Round(time.Second))
+		c.failingSince, c.lastError = time.Time{}, nil
+	}
+}
+
+// credentialStatus is the health of upstream credentials reported in stats.
+type credentialStatus struct {
+	OK           bool       `json:"ok"`
+	Failures     int64      `json:"failures"`
+	Refreshes    int64      `json:"refreshes"`
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+func (c *upstreamCredentials) status() *credentialStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := &credentialStatus{OK: c.failingSince.IsZero(), Failures: c.failures, Refreshes: c.refreshes}
+	if !s.OK {
+		since := c.failingSince
+		s.FailingSince = &since
+		s.LastError = c.lastError.Error()
+	}
+	return s
+}
+
+// retryer returns the retryer of clients signing with the credentials: the
+// one configured, or the SDK's standard retryer, also retrying requests
+// rejected for their credentials once those were refreshed.
+func (c *upstreamCredentials) retryer(configured func() aws.Retryer) func() aws.Retryer {
+	return func() aws.Retryer {
+		var r aws.Retryer = retry.NewStandard()
+		if configured != nil {
+			r = configured()
+		}
+		v2, ok := r.(aws.RetryerV2)
+		if !ok {
+			return r
+		}
+		return credentialRetryer{RetryerV2: v2, creds: c}
+	}
+}
+
+// credentialRetryer is a retryer that refreshes rejected credentials and
+// reports the outcome of each attempt to them.
+type credentialRetryer struct {
+	aws.RetryerV2
+	creds *upstreamCredentials
+}
+
+func (r credentialRetryer) IsErrorRetryable(err error) bool {
+	if isRejectedCredentials(err) && r.creds.refresh(err) {
+		return true
+	}
+	return r.RetryerV2.IsErrorRetryable(err)
+}
+
+func (r credentialRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	release, err := r.RetryerV2.GetAttemptToken(ctx)
+	if err != nil {
+		return release, err
+	}
+	return func(err error) error {
+		r.creds.observe(err)
+		return release(err)
+	}, nil
+}
+
+// upstreamCredentialsOf returns the credentials of an upstream client, if
+// s3lazy created it.
+func upstreamCredentialsOf(client upstreamClient) *upstreamCredentials {
+	s3Client, ok := s3ClientOf(client)
+	if !ok {
+		return nil
+	}
+	creds, _ := s3Client.Options().Credentials.(*upstreamCredentials)
+	return creds
+}
+
+// describeUpstream names an upstream in credential logs.
+func describeUpstream(u BucketUpstream) string {
+	if d := u.describe(); d != "default upstream" {
+		return fmt.Sprintf("upstream (%s)", d)
+	}
+	return "upstream"
+}
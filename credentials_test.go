@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// rotatingProvider hands out the next access key each time credentials are
+// retrieved, or fails with err.
+type rotatingProvider struct {
+	mu   sync.Mutex
+	keys []string
+	err  error
+	n    int
+}
+
+func (p *rotatingProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return aws.Credentials{}, p.err
+	}
+	key := p.keys[min(p.n, len(p.keys)-1)]
+	p.n++
+	return aws.Credentials{AccessKeyID: key, SecretAccessKey: "secret", SessionToken: "session", CanExpire: true, Expires: time.Now().Add(time.Hour)}, nil
+}
+
+// setupCredentialBackends returns a LazyBackend whose upstream rejects
+// requests signed with the access key "expired" with ExpiredToken.
+func setupCredentialBackends(t *testing.T, provider aws.CredentialsProvider, refreshable bool) (*LazyBackend, gofakes3.Backend) {
+	t.Helper()
+	awsBackend := s3mem.New()
+	faker := gofakes3.New(awsBackend).Server()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Authorization"), "Credential=expired/") {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`<Error><Code>ExpiredToken</Code><Message>The provided token has expired.</Message></Error>`))
+			return
+		}
+		faker.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	creds := newUpstreamCredentials("upstream", aws.NewCredentialsCache(provider), refreshable)
+	noBackoff := func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+		})
+	}
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  creds,
+		Retryer:      creds.retryer(noBackoff)(),
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+	})
+	local := s3mem.New()
+	for _, b := range []gofakes3.Backend{local, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	return NewLazyBackend(local, client), awsBackend
+}
+
+func TestUpstreamCredentials_RefreshedWhenExpired(t *testing.T) {
+	provider := &rotatingProvider{keys: []string{"expired", "renewed"}}
+	lazyBackend, awsBackend := setupCredentialBackends(t, provider, true)
+	putString(t, awsBackend, "data.txt", "fetched with renewed credentials")
+
+	if _, got := readObject(t, lazyBackend, "data.txt", nil); got != "fetched with renewed credentials" {
+		t.Errorf("content = %q", got)
+	}
+	if provider.n != 2 {
+		t.Errorf("credentials retrieved %d times, want once more after ExpiredToken", provider.n)
+	}
+	status := lazyBackend.Stats().UpstreamCredentials
+	if status == nil || !status.OK || status.Failures != 1 || status.Refreshes != 1 {
+		t.Errorf("upstream_credentials = %+v, want ok after one refresh", status)
+	}
+}
+
+func TestUpstreamCredentials_Rejected(t *testing.T) {
+	// Static keys aren't refreshed, so upstream keeps rejecting them
+	provider := &rotatingProvider{keys: []string{"expired"}}
+	lazyBackend, awsBackend := setupCredentialBackends(t, provider, false)
+	putString(t, awsBackend, "data.txt", "unreachable")
+
+	_, err := lazyBackend.GetObject("test-bucket", "data.txt", nil)
+	if !errors.Is(err, ErrUpstreamCredentials) || !gofakes3.HasErrorCode(err, gofakes3.ErrInternal) {
+		t.Fatalf("GetObject error = %v, want ErrUpstreamCredentials served as InternalError", err)
+	}
+	if _, err := lazyBackend.HeadObject("test-bucket", "missing.txt"); isNotFound(err) {
+		t.Errorf("HeadObject error = %v, want the credential failure rather than not found", err)
+	}
+	status := lazyBackend.Stats().UpstreamCredentials
+	if status == nil || status.OK || status.Refreshes != 0 || status.FailingSince == nil || !strings.Contains(status.LastError, "ExpiredToken") {
+		t.Errorf("upstream_credentials = %+v, want failing with ExpiredToken", status)
+	}
+}
+
+func TestUpstreamCredentials_Unavailable(t *testing.T) {
+	provider := &rotatingProvider{keys: []string{"renewed"}, err: errors.New("the SSO session has expired or is invalid")}
+	lazyBackend, awsBackend := setupCredentialBackends(t, provider, true)
+	putString(t, awsBackend, "data.txt", "fetched after login")
+
+	_, err := lazyBackend.GetObject("test-bucket", "data.txt", nil)
+	if !errors.Is(err, ErrUpstreamCredentials) || errors.Is(err, ErrUpstreamUnavailable) {
+		t.Fatalf("GetObject error = %v, want ErrUpstreamCredentials", err)
+	}
+	if status := lazyBackend.Stats().UpstreamCredentials; status.OK || !strings.Contains(status.LastError, "SSO session") {
+		t.Errorf("upstream_credentials = %+v, want failing", status)
+	}
+
+	// Logging in again is picked up by the next request
+	provider.mu.Lock()
+	provider.err = nil
+	provider.mu.Unlock()
+	if _, got := readObject(t, lazyBackend, "data.txt", nil); got != "fetched after login" {
+		t.Errorf("content = %q", got)
+	}
+	if status := lazyBackend.Stats().UpstreamCredentials; !status.OK || status.LastError != "" {
+		t.Errorf("upstream_credentials = %+v, want ok again", status)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// twinBuckets returns the other local buckets that could hold a copy of an
+// object fetched from the same upstream bucket as bucketName: mapped buckets,
+// URL sources and the upstream bucket's own name, sorted.
+func (b *LazyBackend) twinBuckets(bucketName string) []string {
+	b.mu.RLock()
+	candidates := make(map[string]bool, len(b.bucketMapping)+len(b.urlSources)+1)
+	for name := range b.bucketMapping {
+		candidates[name] = true
+	}
+	for name := range b.urlSources {
+		candidates[name] = true
+	}
+	b.mu.RUnlock()
+	candidates[b.awsBucketName(bucketName)] = true
+	delete(candidates, bucketName)
+	return slices.Sorted(maps.Keys(candidates))
+}
+
+// twinOf returns a bucket that cached objectName from the same upstream
+// bucket and key that bucketName would fetch it from. Only entries that
+// recorded their upstream count, so client writes and copies cached before
+// namespaces were recorded are never shared. Redaction rules can differ by
+// bucket, so nothing is shared while a redactor is set.
+func (b *LazyBackend) twinOf(bucketName, objectName string) (string, bool) {
+	if b.redactor != nil {
+		return "", false
+	}
+	namespace := b.namespace(bucketName)
+	for _, twin := range b.twinBuckets(bucketName) {
+		if e, ok := b.index.lookup(twin, objectName); ok && e.Namespace == namespace {
+			return twin, true
+		}
+	}
+	return "", false
+}
+
+// getTwin serves a GET of an object that isn't cached in its bucket from a
+// copy another bucket mapped to the same upstream bucket cached, so the
+// object isn't downloaded and stored twice. A stale copy is revalidated
+// first; if that fails the request falls back to an ordinary miss.
+func (b *LazyBackend) getTwin(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, string, bool) {
+	twin, ok := b.twinOf(bucketName, objectName)
+	if !ok {
+		return nil, "", false
+	}
+	obj, err := b.getLocal(twin, objectName, rangeRequest)
+	if err != nil {
+		return nil, "", false
+	}
+	status := cacheHit
+	if b.stale(ctx, twin, objectName, obj) {
+		obj.Contents.Close()
+		changed, err := b.refresh(ctx, twin, objectName, false)
+		if err != nil {
+			return nil, "", false
+		}
+		if obj, err = b.getLocal(twin, objectName, rangeRequest); err != nil {
+			return nil, "", false
+		}
+		status = cacheRevalidated
+		if changed {
+			status = cacheMiss
+		}
+	}
+	b.toggles.infof("[DEDUP] %s/%s served from the copy cached for %s", bucketName, objectName, twin)
+	b.stats.recordHit(servedBytes(obj))
+	b.prefixStats.recordHit(bucketName, objectName)
+	b.index.touch(twin, objectName)
+	b.events.record(bucketName, objectName, eventServed, "from "+twin)
+	return obj, status, true
+}
+
+// headTwin is getTwin for HEAD requests.
+func (b *LazyBackend) headTwin(bucketName, objectName string) (*gofakes3.Object, bool) {
+	twin, ok := b.twinOf(bucketName, objectName)
+	if !ok {
+		return nil, false
+	}
+	obj, err := b.local.HeadObject(twin, objectName)
+	if err != nil {
+		if !isNotFound(err) {
+			log.Printf("[LOCAL HEAD ERROR] %s/%s: %v", twin, objectName, err)
+		}
+		return nil, false
+	}
+	return obj, true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_DedupMappedBuckets(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, bucket := range []string{"team-a", "team-b", "other"} {
+		if err := localBackend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	for _, bucket := range []string{"shared-data", "other-data"} {
+		if err := awsBackend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	lazyBackend.SetBucketMappings(map[string]string{"team-a": "shared-data", "team-b": "shared-data", "other": "other-data"})
+	for _, bucket := range []string{"shared-data", "other-data"} {
+		if _, err := awsBackend.PutObject(bucket, "model.bin", nil, strings.NewReader(bucket), int64(len(bucket)), nil); err != nil {
+			t.Fatalf("PutObject(%s): %v", bucket, err)
+		}
+	}
+
+	read := func(bucket string) string {
+		t.Helper()
+		obj, err := lazyBackend.GetObject(bucket, "model.bin", nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s): %v", bucket, err)
+		}
+		defer obj.Contents.Close()
+		data, err := io.ReadAll(obj.Contents)
+		if err != nil {
+			t.Fatalf("read %s: %v", bucket, err)
+		}
+		return string(data)
+	}
+	if got := read("team-a"); got != "shared-data" {
+		t.Fatalf("team-a content = %q", got)
+	}
+	if got := read("team-b"); got != "shared-data" {
+		t.Errorf("team-b content = %q, want the shared object", got)
+	}
+	if stats := lazyBackend.Stats(); stats.Misses != 1 || stats.Hits != 1 {
+		t.Errorf("misses = %d, hits = %d; want the object fetched once", stats.Misses, stats.Hits)
+	}
+	if _, err := localBackend.HeadObject("team-b", "model.bin"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("team-b should share team-a's copy rather than store its own: %v", err)
+	}
+	if obj, err := lazyBackend.HeadObject("team-b", "model.bin"); err != nil || obj.Size != int64(len("shared-data")) {
+		t.Errorf("HeadObject(team-b) = %v, %v; want the shared copy", obj, err)
+	}
+
+	// Another upstream bucket isn't shared
+	if got := read("other"); got != "other-data" {
+		t.Errorf("other content = %q, want its own upstream's object", got)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strconv"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Metadata the codec stores with each object. It is stripped before
+// objects reach clients.
+const (
+	compressionMetaKey  = "X-S3lazy-Compression"
+	encryptionMetaKey   = "X-S3lazy-Encryption"
+	keyIDMetaKey        = "X-S3lazy-Key-Id"
+	originalSizeMetaKey = "X-S3lazy-Original-Size"
+	originalHashMetaKey = "X-S3lazy-Original-Md5"
+)
+
+var codecMetaKeys = []string{compressionMetaKey, encryptionMetaKey, keyIDMetaKey, originalSizeMetaKey, originalHashMetaKey}
+
+// encodedDisk compresses objects with zstd and encrypts them with AES-GCM,
+// either or both, as they are written to the disk backend and decodes them
+// as they are read. Compression trades CPU for disk space when caching
+// large text and JSON datasets; encryption keeps cached copies of
+// sensitive objects out of cleartext on shared machines. Objects keep
+// their original size and ETag. Reads decode however an object was
+// stored, so changing the settings leaves objects written before readable.
+type encodedDisk struct {
+	gofakes3.Backend
+	compress bool
+	cipher   *diskCipher // nil stores objects unencrypted
+	spoolDir string      // where objects are encoded before being stored
+}
+
+// newEncodedDisk wraps a disk backend, compressing new objects if compress
+// is set and encrypting them if cipher is set.
+func newEncodedDisk(backend gofakes3.Backend, compress bool, cipher *diskCipher, spoolDir string) *encodedDisk {
+	return &encodedDisk{Backend: backend, compress: compress, cipher: cipher, spoolDir: spoolDir}
+}
+
+// storedEncoding describes how an object is stored.
+type storedEncoding struct {
+	compressed bool
+	encrypted  bool
+	storedSize int64 // size of the stored object
+}
+
+// restoreOriginal restores the size and hash an object had before it was
+// encoded and returns how it is stored.
+func restoreOriginal(obj *gofakes3.Object) (storedEncoding, error) {
+	enc := storedEncoding{
+		compressed: obj.Metadata[compressionMetaKey] == "zstd",
+		encrypted:  obj.Metadata[encryptionMetaKey] == "aes-256-gcm",
+		storedSize: obj.Size,
+	}
+	if enc.compressed || enc.encrypted {
+		size, err := strconv.ParseInt(obj.Metadata[originalSizeMetaKey], 10, 64)
+		if err != nil {
+			return enc, fmt.Errorf("%s: bad original size: %w", obj.Name, err)
+		}
+		hash, err := hex.DecodeString(obj.Metadata[originalHashMetaKey])
+		if err != nil {
+			return enc, fmt.Errorf("%s: bad original hash: %w", obj.Name, err)
+		}
+		obj.Size, obj.Hash = size, hash
+	}
+	if obj.Metadata != nil {
+		obj.Metadata = maps.Clone(obj.Metadata)
+		for _, k := range codecMetaKeys {
+			delete(obj.Metadata, k)
+		}
+	}
+	return enc, nil
+}
+
+// checkKey fails for objects encrypted with a key other than the codec's.
+func (c *encodedDisk) checkKey(obj *gofakes3.Object, keyID string) error {
+	if c.cipher == nil {
+		return fmt.Errorf("%s is encrypted and no encryption key is configured", obj.Name)
+	}
+	if keyID != c.cipher.keyID {
+		return fmt.Errorf("%s is encrypted with key %s, not the configured key %s", obj.Name, keyID, c.cipher.keyID)
+	}
+	return nil
+}
+
+func (c *encodedDisk) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
+	obj, err := c.Backend.HeadObject(bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := restoreOriginal(obj); err != nil {
+		obj.Contents.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (c *encodedDisk) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	obj, err := c.Backend.GetObject(bucketName, objectName, nil)
+	if err != nil {
+		return nil, err
+	}
+	keyID := obj.Metadata[keyIDMetaKey]
+	enc, err := restoreOriginal(obj)
+	if err == nil && enc.encrypted {
+		err = c.checkKey(obj, keyID)
+	}
+	if err != nil {
+		obj.Contents.Close()
+		return nil, err
+	}
+	if !enc.compressed && !enc.encrypted {
+		if rangeRequest == nil {
+			return obj, nil
+		}
+		// Let the backend seek to the range
+		obj.Contents.Close()
+		obj, err = c.Backend.GetObject(bucketName, objectName, rangeRequest)
+		if err != nil {
+			return nil, err
+		}
+		_, err = restoreOriginal(obj)
+		return obj, err
+	}
+
+	rng, err := rangeRequest.Range(obj.Size)
+	if err != nil {
+		obj.Contents.Close()
+		return nil, err
+	}
+	var contents io.ReadCloser
+	if enc.encrypted && !enc.compressed && rng != nil {
+		obj.Contents.Close()
+		contents, err = c.decryptRange(bucketName, objectName, rng, enc.storedSize)
+	} else {
+		contents, err = c.decode(obj.Contents, enc, rng)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s/%s: %w", bucketName, objectName, err)
+	}
+	obj.Range = rng
+	obj.Contents = contents
+	return obj, nil
+}
+
+// decode reads a stored object from the start, decrypting and decompressing
+// it and skipping to the range if there is one.
+func (c *encodedDisk) decode(stored io.ReadCloser, enc storedEncoding, rng *gofakes3.ObjectRange) (io.ReadCloser, error) {
+	body := &decodingBody{Reader: stored, file: stored}
+	if enc.encrypted {
+		prefix := make([]byte, noncePrefixSize)
+		if _, err := io.ReadFull(stored, prefix); err != nil {
+			stored.Close()
+			return nil, err
+		}
+		body.Reader = c.cipher.decrypter(stored, prefix, 0, c.cipher.plaintextSize(enc.storedSize))
+	}
+	if enc.compressed {
+		dec, err := zstd.NewReader(body.Reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			stored.Close()
+			return nil, err
+		}
+		body.Reader, body.dec = dec, dec
+	}
+	if rng != nil {
+		// Encoded data can't be seeked: decode up to the range
+		if _, err := io.CopyN(io.Discard, body.Reader, rng.Start); err != nil {
+			body.Close()
+			return nil, fmt.Errorf("skipping to byte %d: %w", rng.Start, err)
+		}
+		body.Reader = io.LimitReader(body.Reader, rng.Length)
+	}
+	return body, nil
+}
+
+// decryptRange reads a range of an object stored encrypted but not
+// compressed, starting at the segment holding its first byte.
+func (c *encodedDisk) decryptRange(bucketName, objectName string, rng *gofakes3.ObjectRange, storedSize int64) (io.ReadCloser, error) {
+	head, err := c.Backend.GetObject(bucketName, objectName, &gofakes3.ObjectRangeRequest{Start: 0, End: noncePrefixSize - 1})
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	_, err = io.ReadFull(head.Contents, prefix)
+	head.Contents.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	first := rng.Start / encryptionSegmentSize
+	last := (rng.Start + rng.Length - 1) / encryptionSegmentSize
+	end := min(c.cipher.encryptedOffset(last+1), storedSize) - 1
+	stored, err := c.Backend.GetObject(bucketName, objectName, &gofakes3.ObjectRangeRequest{Start: c.cipher.encryptedOffset(first), End: end})
+	if err != nil {
+		return nil, err
+	}
+	plain := c.cipher.decrypter(stored.Contents, prefix, first, c.cipher.plaintextSize(storedSize))
+	if _, err := io.CopyN(io.Discard, plain, rng.Start-first*encryptionSegmentSize); err != nil {
+		stored.Contents.Close()
+		return nil, fmt.Errorf("skipping to byte %d: %w", rng.Start, err)
+	}
+	return &decodingBody{Reader: io.LimitReader(plain, rng.Length), file: stored.Contents}, nil
+}
+
+// decodingBody reads an object through its decoders and closes them and
+// the stored object.
+type decodingBody struct {
+	io.Reader
+	dec  *zstd.Decoder // nil unless compressed
+	file io.Closer
+}
+
+func (d *decodingBody) Close() error {
+	if d.dec != nil {
+		d.dec.Close()
+	}
+	return d.file.Close()
+}
+
+func (c *encodedDisk) PutObject(bucketName, objectName string, meta map[string]string, input io.Reader, size int64, conditions *gofakes3.PutConditions) (gofakes3.PutObjectResult, error) {
+	// The backend compares conditions against the stored hash, which is
+	// the hash of the encoded data
+	if err := checkPutConditions(c, bucketName, objectName, conditions); err != nil {
+		return gofakes3.PutObjectResult{}, err
+	}
+
+	meta = maps.Clone(meta)
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	// Always set the markers, so the backend doesn't carry them over from
+	// a previous version of the object stored differently
+	meta[compressionMetaKey], meta[encryptionMetaKey] = "none", "none"
+	if !c.compress && c.cipher == nil {
+		return c.Backend.PutObject(bucketName, objectName, meta, input, size, nil)
+	}
+
+	spool, storedSize, originalSize, hash, err := c.spool(input)
+	if err != nil {
+		return gofakes3.PutObjectResult{}, fmt.Errorf("encoding %s/%s: %w", bucketName, objectName, err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if c.compress {
+		meta[compressionMetaKey] = "zstd"
+	}
+	if c.cipher != nil {
+		meta[encryptionMetaKey] = "aes-256-gcm"
+		meta[keyIDMetaKey] = c.cipher.keyID
+	}
+	meta[originalSizeMetaKey] = strconv.FormatInt(originalSize, 10)
+	meta[originalHashMetaKey] = hex.EncodeToString(hash)
+	return c.Backend.PutObject(bucketName, objectName, meta, spool, storedSize, nil)
+}
+
+// checkPutConditions checks a conditional write against the object as
+// backend presents it, for layers that store objects differently from how
+// they present them and pass no conditions on.
+func checkPutConditions(backend gofakes3.Backend, bucketName, objectName string, conditions *gofakes3.PutConditions) error {
+	if conditions == nil {
+		return nil
+	}
+	info := &gofakes3.ConditionalObjectInfo{}
+	existing, err := backend.HeadObject(bucketName, objectName)
+	switch {
+	case err == nil:
+		info.Exists, info.Hash = true, existing.Hash
+	case !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey):
+		return err
+	}
+	return gofakes3.CheckPutConditions(conditions, info)
+}
+
+// spool encodes input into a temporary file, rewound for reading, and
+// returns the file with the stored and original sizes and the MD5 of the
+// original data.
+func (c *encodedDisk) spool(input io.Reader) (f *os.File, storedSize, originalSize int64, hash []byte, err error) {
+	if err := os.MkdirAll(c.spoolDir, 0755); err != nil {
+		return nil, 0, 0, nil, err
+	}
+	f, err = os.CreateTemp(c.spoolDir, ".encode-*")
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	// Writers are closed innermost first: the compressor flushes into the
+	// encrypter, which seals its last segment into the file
+	var w io.Writer = f
+	var closers []io.Closer
+	if c.cipher != nil {
+		encrypter, err := c.cipher.encrypter(w)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		w = encrypter
+		closers = append([]io.Closer{encrypter}, closers...)
+	}
+	if c.compress {
+		compressor, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		w = compressor
+		closers = append([]io.Closer{compressor}, closers...)
+	}
+
+	hasher := md5.New()
+	originalSize, err = io.Copy(w, io.TeeReader(input, hasher))
+	for _, closer := range closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+	if storedSize, err = f.Seek(0, io.SeekCurrent); err != nil {
+		return nil, 0, 0, nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, nil, err
+	}
+	return f, storedSize, originalSize, hasher.Sum(nil), nil
+}
+
+func (c *encodedDisk) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, meta map[string]string) (gofakes3.CopyObjectResult, error) {
+	// Copy through this layer so the copy is decoded and re-encoded
+	return gofakes3.CopyObject(c, srcBucket, srcKey, dstBucket, dstKey, meta)
+}
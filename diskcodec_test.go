@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3afero"
+	"github.com/spf13/afero"
+)
+
+// newTestEncodedDisk creates a disk backend in a temporary data dir
+// wrapped the way newLocalBackend does.
+func newTestEncodedDisk(t *testing.T, compress bool, cipher *diskCipher) (*diskListing, gofakes3.Backend, string) {
+	t.Helper()
+	dataDir := t.TempDir()
+	raw, err := s3afero.MultiBucket(afero.NewBasePathFs(afero.NewOsFs(), dataDir))
+	if err != nil {
+		t.Fatalf("Failed to create disk backend: %v", err)
+	}
+	disk := newDiskListing(newEncodedDisk(raw, compress, cipher, filepath.Join(dataDir, "s3lazy")), dataDir, nil)
+	if err := disk.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	return disk, raw, dataDir
+}
+
+func readObject(t *testing.T, backend gofakes3.Backend, key string, rng *gofakes3.ObjectRangeRequest) (*gofakes3.Object, string) {
+	t.Helper()
+	obj, err := backend.GetObject("test-bucket", key, rng)
+	if err != nil {
+		t.Fatalf("GetObject(%s, %+v): %v", key, rng, err)
+	}
+	defer obj.Contents.Close()
+	data, err := io.ReadAll(obj.Contents)
+	if err != nil {
+		t.Fatalf("reading %s: %v", key, err)
+	}
+	return obj, string(data)
+}
+
+func TestEncodedDisk_Compression(t *testing.T) {
+	disk, _, dataDir := newTestEncodedDisk(t, true, nil)
+	content := strings.Repeat(`{"id": 1, "name": "fixture", "tags": ["a", "b"]}`+"\n", 1000)
+	if _, err := disk.PutObject("test-bucket", "data.json", map[string]string{"Content-Type": "application/json"},
+		strings.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dataDir, "buckets", "test-bucket", "data.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= int64(len(content))/10 {
+		t.Errorf("stored %d bytes for %d bytes of JSON, want it compressed", info.Size(), len(content))
+	}
+
+	wantHash := md5.Sum([]byte(content))
+	head, err := disk.HeadObject("test-bucket", "data.json")
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if head.Size != int64(len(content)) || string(head.Hash) != string(wantHash[:]) {
+		t.Errorf("head size %d hash %x, want the original %d and %x", head.Size, head.Hash, len(content), wantHash)
+	}
+	if head.Metadata["Content-Type"] != "application/json" || head.Metadata[compressionMetaKey] != "" {
+		t.Errorf("metadata = %v, want the client's without the compression markers", head.Metadata)
+	}
+
+	if _, data := readObject(t, disk, "data.json", nil); data != content {
+		t.Errorf("read %d bytes, want the original %d", len(data), len(content))
+	}
+	obj, data := readObject(t, disk, "data.json", &gofakes3.ObjectRangeRequest{Start: 5000, End: 5099})
+	if data != content[5000:5100] || obj.Range == nil || obj.Range.Start != 5000 || obj.Range.Length != 100 {
+		t.Errorf("range = %q (%+v), want bytes 5000-5099", data, obj.Range)
+	}
+
+	list, err := disk.ListBucket("test-bucket", nil, gofakes3.ListBucketPage{})
+	if err != nil {
+		t.Fatalf("ListBucket: %v", err)
+	}
+	if len(list.Contents) != 1 || list.Contents[0].Size != int64(len(content)) {
+		t.Errorf("listing = %+v, want data.json at its original size", list.Contents)
+	}
+
+	if _, err := disk.CopyObject("test-bucket", "data.json", "test-bucket", "copy.json", nil); err != nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+	if _, data := readObject(t, disk, "copy.json", nil); data != content {
+		t.Error("copy should read back as the original")
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dataDir, "s3lazy"))
+	if len(entries) != 0 {
+		t.Errorf("spool dir holds %d files, want them removed", len(entries))
+	}
+}
+
+func TestEncodedDisk_SwitchedOff(t *testing.T) {
+	disk, raw, dataDir := newTestEncodedDisk(t, true, nil)
+	if _, err := disk.PutObject("test-bucket", "a.txt", nil, strings.NewReader("compressed"), 10, nil); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	// Objects stored compressed stay readable, and overwriting one stores
+	// it uncompressed
+	plain := newDiskListing(newEncodedDisk(raw, false, nil, filepath.Join(dataDir, "s3lazy")), dataDir, nil)
+	if _, data := readObject(t, plain, "a.txt", nil); data != "compressed" {
+		t.Errorf("read %q, want the compressed object decoded", data)
+	}
+	if _, err := plain.PutObject("test-bucket", "a.txt", nil, strings.NewReader("plain"), 5, nil); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	if _, data := readObject(t, plain, "a.txt", &gofakes3.ObjectRangeRequest{Start: 1, End: 3}); data != "lai" {
+		t.Errorf("range = %q, want %q", data, "lai")
+	}
+	if stored, err := os.ReadFile(filepath.Join(dataDir, "buckets", "test-bucket", "a.txt")); err != nil || string(stored) != "plain" {
+		t.Errorf("stored %q (%v), want the object uncompressed", stored, err)
+	}
+}
+
+func TestEncodedDisk_PutConditions(t *testing.T) {
+	disk, _, _ := newTestEncodedDisk(t, true, nil)
+	if _, err := disk.PutObject("test-bucket", "a.txt", nil, strings.NewReader("v1"), 2, nil); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	// If-Match compares against the ETag of the uncompressed object
+	etag := gofakes3.FormatETag(md5.New().Sum(nil))
+	if _, err := disk.PutObject("test-bucket", "a.txt", nil, strings.NewReader("v2"), 2,
+		&gofakes3.PutConditions{IfMatch: &etag}); !gofakes3.HasErrorCode(err, gofakes3.ErrPreconditionFailed) {
+		t.Errorf("PutObject with a stale ETag: err = %v, want PreconditionFailed", err)
+	}
+	v1 := md5.Sum([]byte("v1"))
+	etag = gofakes3.FormatETag(v1[:])
+	if _, err := disk.PutObject("test-bucket", "a.txt", nil, strings.NewReader("v2"), 2,
+		&gofakes3.PutConditions{IfMatch: &etag}); err != nil {
+		t.Errorf("PutObject with the current ETag: %v", err)
+	}
+}
+
+func testCipher(t *testing.T, seed byte) *diskCipher {
+	t.Helper()
+	cipher, err := newDiskCipher(bytes.Repeat([]byte{seed}, 32))
+	if err != nil {
+		t.Fatalf("newDiskCipher: %v", err)
+	}
+	return cipher
+}
+
+func TestEncodedDisk_Encryption(t *testing.T) {
+	var b strings.Builder
+	for i := 0; b.Len() < 3*encryptionSegmentSize+100; i++ {
+		fmt.Fprintf(&b, "secret record %d\n", i)
+	}
+	content := b.String()
+
+	for _, compress := range []bool{false, true} {
+		cipher := testCipher(t, 1)
+		disk, raw, dataDir := newTestEncodedDisk(t, compress, cipher)
+		if _, err := disk.PutObject("test-bucket", "data.txt", nil, strings.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatalf("PutObject: %v", err)
+		}
+		stored, err := os.ReadFile(filepath.Join(dataDir, "buckets", "test-bucket", "data.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(stored), "secret record") {
+			t.Errorf("compress %v: stored object contains cleartext", compress)
+		}
+
+		if _, data := readObject(t, disk, "data.txt", nil); data != content {
+			t.Errorf("compress %v: read %d bytes, want the original %d", compress, len(data), len(content))
+		}
+		size := int64(len(content))
+		for _, rr := range []gofakes3.ObjectRangeRequest{
+			{Start: 10, End: 99},
+			{Start: encryptionSegmentSize - 10, End: encryptionSegmentSize + 9},
+			{Start: 2*encryptionSegmentSize + 5, End: gofakes3.RangeNoEnd},
+			{FromEnd: true, End: 1},
+		} {
+			rng, _ := rr.Range(size)
+			obj, data := readObject(t, disk, "data.txt", &rr)
+			want := content[rng.Start : rng.Start+rng.Length]
+			if data != want || obj.Range == nil || *obj.Range != *rng {
+				t.Errorf("compress %v: range %+v = %d bytes (%+v), want %d bytes (%+v)", compress, rr, len(data), obj.Range, len(want), rng)
+			}
+		}
+
+		// Objects can't be read with another key or none, rather than
+		// being served as garbage
+		for _, other := range []*diskCipher{testCipher(t, 2), nil} {
+			wrong := newEncodedDisk(raw, compress, other, filepath.Join(dataDir, "s3lazy"))
+			if _, err := wrong.GetObject("test-bucket", "data.txt", nil); err == nil {
+				t.Errorf("compress %v: reading with key %v should fail", compress, other)
+			}
+		}
+	}
+}
+
+func TestEncodedDisk_EncryptionSizes(t *testing.T) {
+	cipher := testCipher(t, 1)
+	disk, _, _ := newTestEncodedDisk(t, false, cipher)
+	for _, size := range []int{0, 1, encryptionSegmentSize, encryptionSegmentSize + 1, 2 * encryptionSegmentSize} {
+		content := strings.Repeat("x", size)
+		key := fmt.Sprintf("size-%d", size)
+		if _, err := disk.PutObject("test-bucket", key, nil, strings.NewReader(content), int64(size), nil); err != nil {
+			t.Fatalf("PutObject(%s): %v", key, err)
+		}
+		if _, data := readObject(t, disk, key, nil); data != content {
+			t.Errorf("%s: read %d bytes, want %d", key, len(data), size)
+		}
+		if size > 0 {
+			if _, data := readObject(t, disk, key, &gofakes3.ObjectRangeRequest{FromEnd: true, End: 1}); data != "x" {
+				t.Errorf("%s: last byte = %q, want x", key, data)
+			}
+		}
+	}
+}
+
+func TestEncodedDisk_EncryptionTampered(t *testing.T) {
+	disk, _, dataDir := newTestEncodedDisk(t, false, testCipher(t, 1))
+	content := strings.Repeat("y", 1000)
+	if _, err := disk.PutObject("test-bucket", "a.txt", nil, strings.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	path := filepath.Join(dataDir, "buckets", "test-bucket", "a.txt")
+	stored, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored[100] ^= 1
+	if err := os.WriteFile(path, stored, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := disk.GetObject("test-bucket", "a.txt", nil)
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	defer obj.Contents.Close()
+	if _, err := io.ReadAll(obj.Contents); err == nil {
+		t.Error("reading a tampered object should fail")
+	}
+}
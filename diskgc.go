@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// diskGCResult counts what a startup garbage collection cleaned up.
+type diskGCResult struct {
+	MissingEntries   int `json:"missing_entries"`   // index entries without an object
+	OrphanedMetadata int `json:"orphaned_metadata"` // metadata files without data
+	TempFiles        int `json:"temp_files"`        // temp files left by a crash
+}
+
+// CollectDiskGarbage reconciles the cache index with the disk backend under
+// dataDir after a crash or files deleted by hand: it removes temp files and
+// metadata files whose object data is gone, and drops index entries whose
+// object no longer exists, so eviction doesn't count bytes that aren't
+// there. Objects on disk but not in the index are left alone, since objects
+// written by clients are never indexed.
+func (b *LazyBackend) CollectDiskGarbage(ctx context.Context, dataDir string) (diskGCResult, error) {
+	var result diskGCResult
+	for _, pattern := range []string{
+		filepath.Join(dataDir, "s3lazy", ".encode-*"),
+		filepath.Join(dataDir, ".s3lazy-write-check-*"),
+	} {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			if os.Remove(path) == nil {
+				result.TempFiles++
+			}
+		}
+	}
+
+	orphaned, err := removeOrphanedMetadata(ctx, dataDir)
+	result.OrphanedMetadata = orphaned
+	if err != nil {
+		return result, err
+	}
+
+	b.index.mu.Lock()
+	entries := make([]cacheEntry, 0, len(b.index.entries))
+	for _, e := range b.index.entries {
+		entries = append(entries, *e)
+	}
+	b.index.mu.Unlock()
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		unlock := b.locks.RLock(e.Bucket, e.Key)
+		_, err := b.local.HeadObject(e.Bucket, e.Key)
+		unlock()
+		if err == nil || !isNotFound(err) {
+			continue
+		}
+		if b.index.remove(e.Bucket, e.Key) {
+			b.events.record(e.Bucket, e.Key, eventDropped, "missing from disk")
+			result.MissingEntries++
+		}
+	}
+	return result, nil
+}
+
+// removeOrphanedMetadata removes the disk backend's metadata files, kept
+// under <dataDir>/metadata/<bucket>/, whose data file under
+// <dataDir>/buckets/<bucket>/ is missing.
+func removeOrphanedMetadata(ctx context.Context, dataDir string) (int, error) {
+	metaDir := filepath.Join(dataDir, "metadata")
+	removed := 0
+	err := filepath.WalkDir(metaDir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var meta struct{ File string }
+		if json.Unmarshal(data, &meta) != nil || meta.File == "" {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(dataDir, "buckets", meta.File)); !errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Printf("[GC] removed metadata of missing object %s", filepath.ToSlash(meta.File))
+		removed++
+		return nil
+	})
+	return removed, err
+}
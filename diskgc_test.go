@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollectDiskGarbage(t *testing.T) {
+	disk, _, dataDir := newTestEncodedDisk(t, false, nil)
+	lazyBackend := NewLazyBackend(disk, nil)
+	for _, key := range []string{"kept.txt", "gone.txt", "client.txt"} {
+		if _, err := disk.PutObject("test-bucket", key, map[string]string{}, strings.NewReader("data"), 4, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Fetched from upstream, unlike client.txt
+	lazyBackend.index.add("test-bucket", "kept.txt", 4, "")
+	lazyBackend.index.add("test-bucket", "gone.txt", 4, "")
+
+	// A file deleted by hand, and a temp file a crash left behind
+	if err := os.Remove(filepath.Join(dataDir, "buckets", "test-bucket", "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dataDir, "s3lazy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "s3lazy", ".encode-123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := lazyBackend.CollectDiskGarbage(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("CollectDiskGarbage: %v", err)
+	}
+	if result != (diskGCResult{MissingEntries: 1, OrphanedMetadata: 1, TempFiles: 1}) {
+		t.Errorf("result = %+v, want one of each", result)
+	}
+	if _, ok := lazyBackend.index.lookup("test-bucket", "gone.txt"); ok {
+		t.Error("index entry of the deleted file kept")
+	}
+	if _, ok := lazyBackend.index.lookup("test-bucket", "kept.txt"); !ok {
+		t.Error("index entry of an object on disk dropped")
+	}
+	if _, data := readObject(t, disk, "client.txt", nil); data != "data" {
+		t.Errorf("client.txt = %q, want it left alone", data)
+	}
+	if entries, _ := lazyBackend.index.usage(); entries != 1 {
+		t.Errorf("index has %d entries, want 1", entries)
+	}
+
+	// A second run finds nothing left to clean
+	if result, err := lazyBackend.CollectDiskGarbage(context.Background(), dataDir); err != nil || result != (diskGCResult{}) {
+		t.Errorf("second run = %+v, %v; want nothing cleaned", result, err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// diskListing adds pagination to the disk backend's listings. s3afero builds
+// the whole listing of a bucket, with the metadata of every key, for every
+// request and leaves gofakes3 to ignore max-keys, so listing a bucket of a
+// million cached keys spikes memory. diskListing instead walks the bucket's
+// directory tree in key order and stops once the page is full, so a page
+// costs memory in proportion to its size and the largest directory's entry
+// names. Keys kept in packs are merged in.
+type diskListing struct {
+	gofakes3.Backend
+	root  string     // the backend's buckets directory
+	meta  string     // the backend's metadata directory
+	packs *packStore // nil if nothing is packed
+}
+
+// newDiskListing wraps an s3afero backend rooted at dataDir, with objects
+// packed in packs if it isn't nil.
+func newDiskListing(backend gofakes3.Backend, dataDir string, packs *packStore) *diskListing {
+	return &diskListing{Backend: backend, root: filepath.Join(dataDir, "buckets"), meta: filepath.Join(dataDir, "metadata"), packs: packs}
+}
+
+// diskKey is a key, or a directory standing in for a run of keys, found
+// while walking a bucket.
+type diskKey struct {
+	key   string      // directories end in "/"
+	entry fs.DirEntry // nil for packed keys
+}
+
+func (d *diskListing) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) (*gofakes3.ObjectList, error) {
+	if prefix == nil {
+		prefix = &gofakes3.Prefix{}
+	}
+	if err := gofakes3.ValidateBucketName(name); err != nil {
+		return nil, gofakes3.BucketNotFound(name)
+	}
+	dir := filepath.Join(d.root, name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, gofakes3.BucketNotFound(name)
+	}
+
+	maxKeys := page.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = gofakes3.DefaultMaxBucketKeys
+	}
+	delimiter := ""
+	if prefix.HasDelimiter {
+		delimiter = prefix.Delimiter
+	}
+
+	list := gofakes3.NewObjectList()
+	var count int64
+	var lastPrefix string
+	keys := walkDiskKeys(dir, prefix.Prefix, page.Marker, delimiter)
+	if d.packs != nil {
+		keys = mergePackedKeys(keys, d.packs.keys(name, prefix.Prefix, page.Marker))
+	}
+	for k, err := range keys {
+		if err != nil {
+			return nil, err
+		}
+		entry, isPrefix := k.key, false
+		if delimiter != "" {
+			if i := strings.Index(k.key[len(prefix.Prefix):], delimiter); i >= 0 {
+				entry, isPrefix = k.key[:len(prefix.Prefix)+i+len(delimiter)], true
+			}
+		}
+		if isPrefix && (entry == lastPrefix || page.HasMarker && entry <= page.Marker) {
+			continue
+		}
+		if count == maxKeys {
+			list.IsTruncated = true
+			break
+		}
+
+		if isPrefix {
+			list.AddPrefix(entry)
+			lastPrefix = entry
+		} else {
+			content, err := d.content(name, k)
+			if isNotFound(err) {
+				// Deleted since the directory was read
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			list.Add(content)
+		}
+		list.NextMarker = entry
+		count++
+	}
+	if !list.IsTruncated {
+		list.NextMarker = ""
+	}
+	return list, nil
+}
+
+// content describes one key of a listing. The ETag and size come from the
+// backend, which keeps them in its metadata store and knows the size of
+// compressed objects before compression.
+func (d *diskListing) content(bucket string, k diskKey) (*gofakes3.Content, error) {
+	var modTime time.Time
+	if k.entry == nil {
+		e, ok := d.packs.lookup(bucket, k.key)
+		if !ok {
+			return nil, gofakes3.KeyNotFound(k.key)
+		}
+		modTime = e.modTime
+	} else {
+		info, err := k.entry.Info()
+		if os.IsNotExist(err) {
+			return nil, gofakes3.KeyNotFound(k.key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		modTime = info.ModTime()
+	}
+	obj, err := d.Backend.HeadObject(bucket, k.key)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Contents != nil {
+		obj.Contents.Close()
+	}
+	return &gofakes3.Content{
+		Key:          k.key,
+		LastModified: gofakes3.NewContentTime(modTime),
+		ETag:         gofakes3.FormatETag(obj.Hash),
+		Size:         obj.Size,
+	}, nil
+}
+
+// walkDiskKeys yields the keys stored under a bucket directory that start
+// with prefix and sort after marker, in S3 key order. Directories that can
+// only contribute one common prefix for a "/" delimiter are yielded instead
+// of being walked.
+func walkDiskKeys(dir, prefix, marker, delimiter string) iter.Seq2[diskKey, error] {
+	return func(yield func(diskKey, error) bool) {
+		walkDiskDir(dir, "", prefix, marker, delimiter, yield)
+	}
+}
+
+// mergePackedKeys merges packed keys, in order, into the keys found walking
+// a bucket. A key found both ways, left behind by a crash while it moved
+// into a pack, is yielded once.
+func mergePackedKeys(walked iter.Seq2[diskKey, error], packed iter.Seq[string]) iter.Seq2[diskKey, error] {
+	return func(yield func(diskKey, error) bool) {
+		next, stop := iter.Pull(packed)
+		defer stop()
+		p, more := next()
+		for k, err := range walked {
+			if err != nil {
+				yield(diskKey{}, err)
+				return
+			}
+			for ; more && p < k.key; p, more = next() {
+				if !yield(diskKey{key: p}, nil) {
+					return
+				}
+			}
+			if more && p == k.key {
+				// The packed copy is the one objects are read from
+				k = diskKey{key: p}
+				p, more = next()
+			}
+			if !yield(k, nil) {
+				return
+			}
+		}
+		for ; more; p, more = next() {
+			if !yield(diskKey{key: p}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// walkDiskDir walks one directory, whose keys start with keyPrefix. It
+// returns false once yield asks to stop.
+func walkDiskDir(dir, keyPrefix, prefix, marker, delimiter string, yield func(diskKey, error) bool) bool {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) && keyPrefix != "" {
+		// Emptied and removed since its parent was read
+		return true
+	}
+	if err != nil {
+		return yield(diskKey{}, err)
+	}
+	// Keys sort by their full path, so a directory sorts as its name
+	// followed by "/"
+	sortKey := func(e fs.DirEntry) string {
+		if e.IsDir() {
+			return e.Name() + "/"
+		}
+		return e.Name()
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortKey(entries[i]) < sortKey(entries[j]) })
+
+	for _, e := range entries {
+		key := keyPrefix + sortKey(e)
+		if !e.IsDir() {
+			if strings.HasPrefix(key, prefix) && key > marker && !yield(diskKey{key: key, entry: e}, nil) {
+				return false
+			}
+			continue
+		}
+
+		switch {
+		case !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key):
+			// Nothing under it matches the prefix
+		case key < marker && !strings.HasPrefix(marker, key):
+			// Everything under it sorts before the marker
+		case delimiter == "/" && len(key) > len(prefix) && strings.HasPrefix(key, prefix):
+			// Everything under it rolls up into one common prefix
+			if !yield(diskKey{key: key, entry: e}, nil) {
+				return false
+			}
+		default:
+			if !walkDiskDir(filepath.Join(dir, e.Name()), key, prefix, marker, delimiter, yield) {
+				return false
+			}
+		}
+	}
+	return true
+}
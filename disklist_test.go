@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3afero"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/spf13/afero"
+)
+
+// listAll follows a listing page by page, returning every key and common
+// prefix, sorted.
+func listAll(t *testing.T, backend gofakes3.Backend, prefix *gofakes3.Prefix, maxKeys int64) []string {
+	t.Helper()
+	var out []string
+	page := gofakes3.ListBucketPage{MaxKeys: maxKeys}
+	for {
+		list, err := backend.ListBucket("test-bucket", prefix, page)
+		if err != nil {
+			t.Fatalf("ListBucket(%v, %+v): %v", prefix, page, err)
+		}
+		if int64(len(list.Contents)+len(list.CommonPrefixes)) > maxKeys {
+			t.Fatalf("page of %d entries, want at most %d", len(list.Contents)+len(list.CommonPrefixes), maxKeys)
+		}
+		for _, cp := range list.CommonPrefixes {
+			out = append(out, cp.Prefix)
+		}
+		for _, c := range list.Contents {
+			out = append(out, c.Key)
+		}
+		if !list.IsTruncated {
+			sort.Strings(out)
+			return out
+		}
+		page = gofakes3.ListBucketPage{Marker: list.NextMarker, HasMarker: true, MaxKeys: maxKeys}
+	}
+}
+
+func TestDiskListing(t *testing.T) {
+	dataDir := t.TempDir()
+	diskBackend, err := s3afero.MultiBucket(afero.NewBasePathFs(afero.NewOsFs(), dataDir))
+	if err != nil {
+		t.Fatalf("Failed to create disk backend: %v", err)
+	}
+	disk := newDiskListing(diskBackend, dataDir, nil)
+	mem := s3mem.New()
+
+	// "a-b" sorts before "a/..." in key order but after the directory "a"
+	// by file name
+	keys := []string{"a-b", "a/1", "a/2/x", "a/2/y", "a0", "b/c/d/e", "b/c/f", "root.txt"}
+	for _, backend := range []gofakes3.Backend{disk, mem} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+		for _, key := range keys {
+			if _, err := backend.PutObject("test-bucket", key, nil, strings.NewReader(key), int64(len(key)), nil); err != nil {
+				t.Fatalf("PutObject(%s): %v", key, err)
+			}
+		}
+	}
+
+	prefixes := []*gofakes3.Prefix{
+		nil,
+		{HasPrefix: true, Prefix: "a"},
+		{HasPrefix: true, Prefix: "a/"},
+		{HasDelimiter: true, Delimiter: "/"},
+		{HasPrefix: true, Prefix: "b/", HasDelimiter: true, Delimiter: "/"},
+		{HasPrefix: true, Prefix: "a/2", HasDelimiter: true, Delimiter: "/"},
+	}
+	for _, prefix := range prefixes {
+		for _, maxKeys := range []int64{1, 2, 1000} {
+			got := strings.Join(listAll(t, disk, prefix, maxKeys), " ")
+			want := strings.Join(listAll(t, mem, prefix, 1000), " ")
+			if got != want {
+				t.Errorf("disk listing of %v in pages of %d = %q, want %q", prefix, maxKeys, got, want)
+			}
+		}
+	}
+
+	list, err := disk.ListBucket("test-bucket", nil, gofakes3.ListBucketPage{MaxKeys: 1000})
+	if err != nil {
+		t.Fatalf("ListBucket: %v", err)
+	}
+	c := list.Contents[0]
+	if c.Key != "a-b" || c.Size != 3 || c.ETag == "" || c.LastModified.IsZero() {
+		t.Errorf("first content = %+v, want a-b with its size, ETag and modification time", c)
+	}
+
+	if _, err := disk.ListBucket("missing-bucket", nil, gofakes3.ListBucketPage{}); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Errorf("listing a missing bucket: err = %v, want NoSuchBucket", err)
+	}
+}
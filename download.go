@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// downloadAttempts is how many times a download is attempted in total when
+// the upstream connection fails mid-stream.
+const downloadAttempts = 3
+
+// errChecksumMismatch is returned when a downloaded object doesn't match the
+// MD5 its upstream ETag advertises.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// resumableBody reads an upstream object body, resuming from the failed
+// offset with a ranged GET when the connection breaks, and verifies the
+// assembled bytes against the expected size and MD5 ETag at EOF. Resumed
+// requests carry If-Match so a concurrent upstream overwrite can't be spliced
+// into the middle of the old version.
+type resumableBody struct {
+	ctx      context.Context
+	client   upstreamClient
+	bucket   string
+	key      string
+	etag     *string
+	size     int64 // -1 when unknown
+	attempts int
+
+	body   io.ReadCloser
+	offset int64
+	hash   hash.Hash
+	want   []byte // expected MD5, nil when the ETag isn't one
+}
+
+// newResumableBody wraps the body of a successful GetObject. With md5ETags
+// unset the ETag is treated as opaque and only the size is verified.
+func newResumableBody(ctx context.Context, client upstreamClient, bucket, key string, out *s3.GetObjectOutput, md5ETags bool) *resumableBody {
+	r := &resumableBody{
+		ctx:      ctx,
+		client:   client,
+		bucket:   bucket,
+		key:      key,
+		etag:     out.ETag,
+		size:     -1,
+		attempts: 1,
+		body:     out.Body,
+		hash:     md5.New(),
+	}
+	if out.ContentLength != nil && *out.ContentLength >= 0 {
+		r.size = *out.ContentLength
+	}
+	if md5ETags {
+		r.want = md5FromETag(out.ETag)
+	}
+	return r
+}
+
+// md5FromETag returns the MD5 an ETag encodes, or nil for multipart and other
+// non-MD5 ETags.
+func md5FromETag(etag *string) []byte {
+	if etag == nil {
+		return nil
+	}
+	sum, err := hex.DecodeString(strings.Trim(*etag, `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
+
+func (r *resumableBody) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		r.hash.Write(p[:n])
+
+		switch {
+		case err == io.EOF && (r.size < 0 || r.offset == r.size):
+			return n, r.verify()
+		case err == io.EOF:
+			// The connection closed early without an error
+			err = io.ErrUnexpectedEOF
+			fallthrough
+		case err != nil:
+			if resumeErr := r.resume(err); resumeErr != nil {
+				return n, resumeErr
+			}
+			if n > 0 {
+				return n, nil
+			}
+		default:
+			return n, nil
+		}
+	}
+}
+
+// verify checks the assembled object against the expected MD5.
+func (r *resumableBody) verify() error {
+	if r.want != nil && !bytes.Equal(r.hash.Sum(nil), r.want) {
+		return fmt.Errorf("%s/%s: %w: got %x, want %x", r.bucket, r.key, errChecksumMismatch, r.hash.Sum(nil), r.want)
+	}
+	return io.EOF
+}
+
+// resume re-requests the rest of the object after a failed read.
+func (r *resumableBody) resume(cause error) error {
+	if r.attempts >= downloadAttempts || r.size < 0 || r.etag == nil {
+		return fmt.Errorf("%w: %s/%s broke off at byte %d: %w", ErrUpstreamUnavailable, r.bucket, r.key, r.offset, cause)
+	}
+	r.attempts++
+	r.body.Close()
+	log.Printf("[RESUME] %s/%s at byte %d (attempt %d/%d): %v", r.bucket, r.key, r.offset, r.attempts, downloadAttempts, cause)
+
+	out, err := r.client.GetObject(r.ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(r.bucket),
+		Key:     aws.String(r.key),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-", r.offset)),
+		IfMatch: r.etag,
+	})
+	if err != nil {
+		r.body = io.NopCloser(strings.NewReader(""))
+		return fmt.Errorf("resuming %s/%s at byte %d: %w", r.bucket, r.key, r.offset, err)
+	}
+	if !strings.HasPrefix(aws.ToString(out.ContentRange), fmt.Sprintf("bytes %d-", r.offset)) {
+		// The upstream ignored the range and sent the whole object again
+		out.Body.Close()
+		r.body = io.NopCloser(strings.NewReader(""))
+		return fmt.Errorf("resuming %s/%s: upstream doesn't support ranged GETs: %w", r.bucket, r.key, cause)
+	}
+	r.body = out.Body
+	return nil
+}
+
+func (r *resumableBody) Close() error {
+	return r.body.Close()
+}
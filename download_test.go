@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// flakyUpstream serves one object, breaking every body after failAfter bytes
+// until it has failed failures times.
+type flakyUpstream struct {
+	content   string
+	etag      string
+	failAfter int
+	failures  int
+	ranged    []string
+}
+
+type brokenReader struct {
+	r         io.Reader
+	remaining int
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, errors.New("connection reset by peer")
+	}
+	if len(p) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= n
+	return n, err
+}
+
+func (f *flakyUpstream) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	start := 0
+	out := &s3.GetObjectOutput{ETag: aws.String(f.etag)}
+	if params.Range != nil {
+		f.ranged = append(f.ranged, *params.Range)
+		fmt.Sscanf(*params.Range, "bytes=%d-", &start)
+		out.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, len(f.content)-1, len(f.content)))
+	}
+	out.ContentLength = aws.Int64(int64(len(f.content) - start))
+
+	var body io.Reader = strings.NewReader(f.content[start:])
+	if f.failures > 0 {
+		f.failures--
+		body = &brokenReader{r: body, remaining: f.failAfter}
+	}
+	out.Body = io.NopCloser(body)
+	return out, nil
+}
+
+func (f *flakyUpstream) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+func readThrough(t *testing.T, up *flakyUpstream) (string, error) {
+	t.Helper()
+	out, err := up.GetObject(context.Background(), &s3.GetObjectInput{})
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	body := newResumableBody(context.Background(), up, "bucket", "key", out, true)
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	return string(data), err
+}
+
+func TestResumableBody_ResumesFromOffset(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	up := &flakyUpstream{
+		content:   content,
+		etag:      fmt.Sprintf(`"%x"`, md5.Sum([]byte(content))),
+		failAfter: 30,
+		failures:  2,
+	}
+
+	got, err := readThrough(t, up)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got != content {
+		t.Errorf("content = %q, want %q", got, content)
+	}
+	if want := []string{"bytes=30-", "bytes=60-"}; fmt.Sprint(up.ranged) != fmt.Sprint(want) {
+		t.Errorf("ranged requests = %v, want %v", up.ranged, want)
+	}
+}
+
+func TestResumableBody_GivesUpAfterAttempts(t *testing.T) {
+	content := strings.Repeat("x", 100)
+	up := &flakyUpstream{content: content, etag: `"abc"`, failAfter: 10, failures: downloadAttempts}
+
+	if _, err := readThrough(t, up); err == nil {
+		t.Error("expected an error after exhausting download attempts")
+	}
+}
+
+func TestResumableBody_ChecksumMismatch(t *testing.T) {
+	up := &flakyUpstream{
+		content: "corrupted body",
+		etag:    fmt.Sprintf(`"%x"`, md5.Sum([]byte("original body"))),
+	}
+
+	if _, err := readThrough(t, up); !errors.Is(err, errChecksumMismatch) {
+		t.Errorf("err = %v, want errChecksumMismatch", err)
+	}
+}
+
+func TestMD5FromETag(t *testing.T) {
+	tests := []struct {
+		etag string
+		want bool
+	}{
+		{`"d41d8cd98f00b204e9800998ecf8427e"`, true},
+		{`"d41d8cd98f00b204e9800998ecf8427e-3"`, false},
+		{`W/"abc"`, false},
+	}
+
+	for _, tt := range tests {
+		if got := md5FromETag(aws.String(tt.etag)) != nil; got != tt.want {
+			t.Errorf("md5FromETag(%s) ok = %v, want %v", tt.etag, got, tt.want)
+		}
+	}
+}
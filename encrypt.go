@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Objects are encrypted in segments so they can be streamed, and range
+// reads can start at the segment holding the first byte. A stored object
+// is a random nonce prefix followed by each segment sealed with AES-GCM
+// under the prefix and the segment's index, the last segment marked as
+// such so a truncated object fails to decrypt.
+const (
+	encryptionSegmentSize = 64 << 10
+	noncePrefixSize       = 8
+)
+
+// diskCipher encrypts objects stored by the disk backend with AES-256-GCM.
+type diskCipher struct {
+	aead  cipher.AEAD
+	keyID string // identifies the key objects were encrypted with
+}
+
+// newDiskCipher creates a cipher for a 32-byte key.
+func newDiskCipher(key []byte) (*diskCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &diskCipher{aead: aead, keyID: hex.EncodeToString(sum[:8])}, nil
+}
+
+// parseEncryptionKey decodes a hex or base64 encoded 32-byte key.
+func parseEncryptionKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("key must be 32 bytes, hex or base64 encoded")
+}
+
+// loadEncryptionKey returns the key given directly or read from a file.
+func loadEncryptionKey(key, keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = string(data)
+	}
+	return parseEncryptionKey(key)
+}
+
+// segmentCount returns how many segments an object of size bytes is
+// stored in. An empty object is one empty segment.
+func segmentCount(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return (size + encryptionSegmentSize - 1) / encryptionSegmentSize
+}
+
+// plaintextSize returns the size of the data a stored object of stored
+// bytes decrypts to.
+func (c *diskCipher) plaintextSize(stored int64) int64 {
+	sealedSegment := int64(encryptionSegmentSize + c.aead.Overhead())
+	segments := max((stored-noncePrefixSize+sealedSegment-1)/sealedSegment, 1)
+	return stored - noncePrefixSize - segments*int64(c.aead.Overhead())
+}
+
+// encryptedOffset returns where segment i starts in the stored object.
+func (c *diskCipher) encryptedOffset(i int64) int64 {
+	return noncePrefixSize + i*(encryptionSegmentSize+int64(c.aead.Overhead()))
+}
+
+func segmentNonce(prefix []byte, i int64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], uint32(i))
+	return nonce
+}
+
+// segmentAD marks the last segment of an object.
+func segmentAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encrypter returns a writer encrypting into w. Closing it writes the
+// last segment.
+func (c *diskCipher) encrypter(w io.Writer) (io.WriteCloser, error) {
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &segmentWriter{cipher: c, w: w, prefix: prefix, buf: make([]byte, 0, encryptionSegmentSize)}, nil
+}
+
+type segmentWriter struct {
+	cipher *diskCipher
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+	index  int64
+}
+
+func (s *segmentWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full segment is only sealed once more data arrives, as
+		// whether it is the last isn't known until then
+		if len(s.buf) == encryptionSegmentSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):encryptionSegmentSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *segmentWriter) seal(last bool) error {
+	sealed := s.cipher.aead.Seal(nil, segmentNonce(s.prefix, s.index), s.buf, segmentAD(last))
+	s.buf = s.buf[:0]
+	s.index++
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+func (s *segmentWriter) Close() error {
+	return s.seal(true)
+}
+
+// decrypter returns a reader decrypting an object of size bytes from r,
+// which holds the stored object from the start of segment first on.
+func (c *diskCipher) decrypter(r io.Reader, prefix []byte, first, size int64) io.Reader {
+	return &segmentReader{cipher: c, r: r, prefix: prefix, index: first, count: segmentCount(size), size: size}
+}
+
+type segmentReader struct {
+	cipher *diskCipher
+	r      io.Reader
+	prefix []byte
+	index  int64 // the next segment to read
+	count  int64
+	size   int64
+	plain  bytes.Reader // the current segment, decrypted
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for s.plain.Len() == 0 {
+		if s.index == s.count {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	return s.plain.Read(p)
+}
+
+func (s *segmentReader) next() error {
+	plainLen := int64(encryptionSegmentSize)
+	last := s.index == s.count-1
+	if last {
+		plainLen = s.size - s.index*encryptionSegmentSize
+	}
+	sealed := make([]byte, plainLen+int64(s.cipher.aead.Overhead()))
+	if _, err := io.ReadFull(s.r, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("reading segment %d: %w", s.index, err)
+	}
+	plain, err := s.cipher.aead.Open(sealed[:0], segmentNonce(s.prefix, s.index), sealed, segmentAD(last))
+	if err != nil {
+		return fmt.Errorf("decrypting segment %d: %w", s.index, err)
+	}
+	s.plain.Reset(plain)
+	s.index++
+	return nil
+}
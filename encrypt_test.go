@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseEncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for _, s := range []string{hex.EncodeToString(key), base64.StdEncoding.EncodeToString(key) + "\n"} {
+		got, err := parseEncryptionKey(s)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("parseEncryptionKey(%q) = %x, %v; want the key", s, got, err)
+		}
+	}
+	for _, bad := range []string{"", "not a key", hex.EncodeToString(key[:16])} {
+		if _, err := parseEncryptionKey(bad); err == nil {
+			t.Errorf("parseEncryptionKey(%q) should fail", bad)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(file, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := loadEncryptionKey("", file); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("loadEncryptionKey from file = %x, %v; want the key", got, err)
+	}
+}
+
+func TestDiskCipher_PlaintextSize(t *testing.T) {
+	cipher, err := newDiskCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, encryptionSegmentSize - 1, encryptionSegmentSize, encryptionSegmentSize + 1, 5*encryptionSegmentSize + 17} {
+		var stored bytes.Buffer
+		w, err := cipher.encrypter(&stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(bytes.Repeat([]byte{'z'}, size))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := cipher.plaintextSize(int64(stored.Len())); got != int64(size) {
+			t.Errorf("plaintextSize(%d) = %d, want %d", stored.Len(), got, size)
+		}
+	}
+}
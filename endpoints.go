@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// endpointEjectDuration is how long an upstream endpoint or address that
+// failed is taken out of rotation before it is tried again.
+const endpointEjectDuration = 30 * time.Second
+
+// endpointDialTimeout bounds each connection attempt, so a dead address
+// fails over quickly instead of waiting for the OS timeout.
+const endpointDialTimeout = 5 * time.Second
+
+// upstreamEndpoint is one upstream S3 endpoint in an endpointPool.
+type upstreamEndpoint struct {
+	url    string
+	client upstreamLister
+
+	// ejectedUntil is zero while the endpoint is healthy
+	ejectedUntil time.Time
+}
+
+// upstreamLister is an upstreamClient that can also list keys and read tags.
+type upstreamLister interface {
+	upstreamClient
+	s3.ListObjectsV2APIClient
+	upstreamTagger
+}
+
+// endpointPool spreads upstream requests over several equivalent endpoints,
+// such as the nodes of a MinIO cluster. An endpoint that fails with a network
+// error or a 5xx response is ejected for endpointEjectDuration and the
+// request is retried on the next one.
+type endpointPool struct {
+	endpoints []*upstreamEndpoint
+	now       func() time.Time
+
+	mu   sync.Mutex
+	next int
+}
+
+func newEndpointPool(endpoints []*upstreamEndpoint) *endpointPool {
+	return &endpointPool{endpoints: endpoints, now: time.Now}
+}
+
+// order returns the endpoints to try for one request: healthy endpoints in
+// round-robin order, then ejected ones, soonest to return first, so a
+// request is still attempted when every endpoint is down.
+func (p *endpointPool) order() []*upstreamEndpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	start := p.next
+	p.next = (p.next + 1) % len(p.endpoints)
+
+	var healthy, ejected []*upstreamEndpoint
+	for i := range p.endpoints {
+		e := p.endpoints[(start+i)%len(p.endpoints)]
+		if now.Before(e.ejectedUntil) {
+			ejected = append(ejected, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+	sort.Slice(ejected, func(i, j int) bool { return ejected[i].ejectedUntil.Before(ejected[j].ejectedUntil) })
+	return append(healthy, ejected...)
+}
+
+// report records the outcome of a request to an endpoint.
+func (p *endpointPool) report(e *upstreamEndpoint, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if endpointFailed(err) {
+		e.ejectedUntil = p.now().Add(endpointEjectDuration)
+		log.Printf("[ENDPOINT] ejected %s for %s: %v", e.url, endpointEjectDuration, err)
+		return
+	}
+	if !e.ejectedUntil.IsZero() {
+		e.ejectedUntil = time.Time{}
+		log.Printf("[ENDPOINT] %s is back in rotation", e.url)
+	}
+}
+
+// endpointFailed reports whether an error means the endpoint itself is
+// unhealthy, as opposed to an answer about the request such as NoSuchKey.
+func endpointFailed(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		// The SDK reports send failures as a response error with no status
+		status := respErr.HTTPStatusCode()
+		return status == 0 || status >= 500
+	}
+	// No response at all: connection refused, reset, DNS or timeout
+	return true
+}
+
+// tryEndpoints runs a request against each endpoint in turn until one answers.
+func tryEndpoints[T any](p *endpointPool, call func(upstreamLister) (T, error)) (T, error) {
+	var out T
+	var err error
+	for _, e := range p.order() {
+		out, err = call(e.client)
+		p.report(e, err)
+		if !endpointFailed(err) {
+			return out, err
+		}
+	}
+	return out, err
+}
+
+func (p *endpointPool) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return tryEndpoints(p, func(c upstreamLister) (*s3.GetObjectOutput, error) {
+		return c.GetObject(ctx, params, optFns...)
+	})
+}
+
+func (p *endpointPool) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return tryEndpoints(p, func(c upstreamLister) (*s3.HeadObjectOutput, error) {
+		return c.HeadObject(ctx, params, optFns...)
+	})
+}
+
+func (p *endpointPool) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return tryEndpoints(p, func(c upstreamLister) (*s3.ListObjectsV2Output, error) {
+		return c.ListObjectsV2(ctx, params, optFns...)
+	})
+}
+
+func (p *endpointPool) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	return tryEndpoints(p, func(c upstreamLister) (*s3.GetObjectTaggingOutput, error) {
+		return c.GetObjectTagging(ctx, params, optFns...)
+	})
+}
+
+// validateEndpoint checks that an upstream endpoint is an http(s) URL.
+func validateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q: want http(s)://host[:port]", endpoint)
+	}
+	return nil
+}
+
+// addressRotator dials hosts that resolve to several addresses, rotating
+// over them and skipping addresses that recently refused or timed out.
+type addressRotator struct {
+	resolver *net.Resolver
+	dialer   *net.Dialer
+	now      func() time.Time
+
+	mu      sync.Mutex
+	next    map[string]int       // rotation position per host
+	ejected map[string]time.Time // address -> when it may be tried again
+}
+
+func newAddressRotator() *addressRotator {
+	return &addressRotator{
+		resolver: net.DefaultResolver,
+		dialer:   &net.Dialer{Timeout: endpointDialTimeout, KeepAlive: 30 * time.Second},
+		now:      time.Now,
+		next:     make(map[string]int),
+		ejected:  make(map[string]time.Time),
+	}
+}
+
+// DialContext resolves addr's host and connects to the first address that
+// accepts, starting from a different healthy address on every call.
+func (r *addressRotator) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := r.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, target := range r.order(host, port, ips) {
+		conn, err := r.dialer.DialContext(ctx, network, target)
+		if err == nil {
+			r.mu.Lock()
+			delete(r.ejected, target)
+			r.mu.Unlock()
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+		r.mu.Lock()
+		r.ejected[target] = r.now().Add(endpointEjectDuration)
+		r.mu.Unlock()
+		log.Printf("[ENDPOINT] ejected address %s of %s for %s: %v", target, host, endpointEjectDuration, err)
+	}
+	return nil, lastErr
+}
+
+// order returns a host's addresses joined with port, rotated, with ejected
+// addresses moved to the end.
+func (r *addressRotator) order(host, port string, ips []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start := r.next[host] % len(ips)
+	r.next[host] = (start + 1) % len(ips)
+
+	now := r.now()
+	var healthy, ejected []string
+	for i := range ips {
+		target := net.JoinHostPort(ips[(start+i)%len(ips)], port)
+		if until, ok := r.ejected[target]; ok && now.Before(until) {
+			ejected = append(ejected, target)
+		} else {
+			healthy = append(healthy, target)
+		}
+	}
+	return append(healthy, ejected...)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// newEndpointClient creates an S3 client for a test endpoint.
+func newEndpointClient(t *testing.T, endpoint string) *s3.Client {
+	t.Helper()
+	awsCfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion("us-east-1"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+		config.WithRetryMaxAttempts(1),
+	)
+	if err != nil {
+		t.Fatalf("Failed to load AWS config: %v", err)
+	}
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+	})
+}
+
+func TestEndpointPool_Failover(t *testing.T) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if _, err := backend.PutObject("test-bucket", "file.txt", nil, bytes.NewReader([]byte("data")), 4, nil); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	healthy := httptest.NewServer(gofakes3.New(backend).Server())
+	defer healthy.Close()
+	dead := httptest.NewServer(nil)
+	dead.Close()
+
+	pool := newEndpointPool([]*upstreamEndpoint{
+		{url: dead.URL, client: newEndpointClient(t, dead.URL)},
+		{url: healthy.URL, client: newEndpointClient(t, healthy.URL)},
+	})
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := pool.HeadObject(context.Background(), &s3.HeadObjectInput{
+			Bucket: aws.String("test-bucket"), Key: aws.String("file.txt"),
+		}); err != nil {
+			t.Fatalf("HeadObject %d: %v", i, err)
+		}
+	}
+	if order := pool.order(); order[0].url != healthy.URL {
+		t.Errorf("healthy endpoint should be tried first while the dead one is ejected")
+	}
+
+	// A missing key is an answer, not an endpoint failure
+	_, err := pool.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("test-bucket"), Key: aws.String("missing.txt"),
+	})
+	if err == nil || endpointFailed(err) {
+		t.Errorf("missing key error = %v, want a not-found answer", err)
+	}
+	if !pool.endpoints[1].ejectedUntil.IsZero() {
+		t.Error("a not-found answer should not eject the endpoint")
+	}
+
+	// After the ejection expires the dead endpoint is tried first again in turn
+	now = now.Add(endpointEjectDuration + time.Second)
+	first, second := pool.order()[0].url, pool.order()[0].url
+	if first != dead.URL && second != dead.URL {
+		t.Error("ejected endpoint should return to rotation after the eject duration")
+	}
+}
+
+func TestEndpointFailed(t *testing.T) {
+	if endpointFailed(nil) || endpointFailed(context.Canceled) {
+		t.Error("nil and cancelled requests are not endpoint failures")
+	}
+	if !endpointFailed(errors.New("connection refused")) {
+		t.Error("errors without a response should be endpoint failures")
+	}
+}
+
+func TestAddressRotator(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	r := newAddressRotator()
+	order := r.order("example", port, []string{"10.0.0.1", "10.0.0.2"})
+	if next := r.order("example", port, []string{"10.0.0.1", "10.0.0.2"}); next[0] == order[0] {
+		t.Errorf("consecutive dials should start from different addresses: %v, %v", order, next)
+	}
+
+	r.ejected[net.JoinHostPort("10.0.0.1", port)] = time.Now().Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if got := r.order("example", port, []string{"10.0.0.1", "10.0.0.2"}); got[0] != net.JoinHostPort("10.0.0.2", port) {
+			t.Errorf("ejected address should be tried last, got %v", got)
+		}
+	}
+
+	conn, err := r.DialContext(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("DialContext: %v", err)
+	}
+	conn.Close()
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	if err := validateEndpoint("https://minio-1.internal:9000"); err != nil {
+		t.Errorf("valid endpoint rejected: %v", err)
+	}
+	for _, bad := range []string{"minio-1:9000", "ftp://host", "https://"} {
+		if err := validateEndpoint(bad); err == nil {
+			t.Errorf("validateEndpoint(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"syscall"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// Failure modes of the library API. Code embedding s3lazy can check for them
+// with errors.Is; the errors returned are still S3 errors, so they can be
+// served to S3 clients as they are.
+var (
+	// ErrUpstreamUnavailable means an object couldn't be fetched because
+	// upstream couldn't be reached, answered with a server error or timed
+	// out, or because s3lazy is offline.
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+	// ErrUpstreamCredentials means an object couldn't be fetched because
+	// upstream rejected s3lazy's credentials, e.g. because they expired, or
+	// new ones couldn't be obtained.
+	ErrUpstreamCredentials = errors.New("upstream credentials failing")
+
+	// ErrCacheFull means the local backend ran out of space for an object.
+	ErrCacheFull = errors.New("cache full")
+
+	// ErrReadOnly means a write was rejected because read-only mode is on.
+	ErrReadOnly = errors.New("s3lazy is in read-only mode")
+
+	// ErrQuotaExceeded means a write was rejected because it would take a
+	// bucket past its quota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrNotCached means a purge kept an object because the cache index
+	// doesn't track it as cached from upstream.
+	ErrNotCached = errors.New("not cached")
+)
+
+// failure is an S3 error that is also one of the failure modes above,
+// wrapping the error that caused it, if any.
+type failure struct {
+	*gofakes3.ErrorResponse
+	kind  error
+	cause error
+}
+
+// newFailure returns an error of the given failure mode, served to S3
+// clients with code and the formatted message.
+func newFailure(kind error, code gofakes3.ErrorCode, cause error, format string, args ...any) error {
+	return &failure{
+		ErrorResponse: &gofakes3.ErrorResponse{Code: code, Message: fmt.Sprintf(format, args...)},
+		kind:          kind,
+		cause:         cause,
+	}
+}
+
+func (f *failure) Error() string {
+	if f.cause != nil {
+		return fmt.Sprintf("%s: %v", f.ErrorResponse.Error(), f.cause)
+	}
+	return f.ErrorResponse.Error()
+}
+
+func (f *failure) Is(target error) bool {
+	return target == f.kind
+}
+
+func (f *failure) Unwrap() error {
+	return f.cause
+}
+
+// isUpstreamUnavailable reports whether an upstream error means upstream
+// couldn't serve the request at all, rather than refusing it.
+func isUpstreamUnavailable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up, not upstream
+		return false
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() >= http.StatusInternalServerError {
+		return true
+	}
+	switch code := s3ErrorCode(err); {
+	case code == "":
+		// Never got an answer: connection failures and timeouts
+		return true
+	case code == "InternalError" || code == "ServiceUnavailable" || code == "SlowDown":
+		return true
+	default:
+		// URL sources report other 5xx statuses as HTTP5xx
+		return strings.HasPrefix(code, "HTTP5")
+	}
+}
+
+// isNoSpace reports whether a local write failed for lack of disk space.
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
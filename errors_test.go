@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// fullBackend is a local backend whose disk is full.
+type fullBackend struct{ gofakes3.Backend }
+
+func (fullBackend) PutObject(bucketName, objectName string, meta map[string]string, input io.Reader, size int64, conditions *gofakes3.PutConditions) (gofakes3.PutObjectResult, error) {
+	return gofakes3.PutObjectResult{}, &os.PathError{Op: "write", Path: objectName, Err: syscall.ENOSPC}
+}
+
+func TestErrors_UpstreamUnavailable(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, _ := setupTestBackends(t)
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+
+	// A missing object is just missing
+	if _, err := lazyBackend.GetObject("test-bucket", "missing", nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) || errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("missing key: err = %v, want NoSuchKey", err)
+	}
+
+	// Offline, an uncached object is ErrUpstreamUnavailable to embedders, but
+	// clients still see NoSuchKey
+	lazyBackend.toggles.offline.Store(true)
+	_, err := lazyBackend.GetObject("test-bucket", "missing", nil)
+	if !errors.Is(err, ErrUpstreamUnavailable) || !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("offline: err = %v, want ErrUpstreamUnavailable served as NoSuchKey", err)
+	}
+	server := httptest.NewServer(gofakes3.New(lazyBackend).Server())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/test-bucket/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(string(body), "<Code>NoSuchKey</Code>") {
+		t.Errorf("offline GET over HTTP = %d %s, want a NoSuchKey error", resp.StatusCode, body)
+	}
+	lazyBackend.toggles.offline.Store(false)
+
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busy.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+	if err := lazyBackend.SetURLSources(map[string]string{"busy": busy.URL + "/{key}", "down": down.URL + "/{key}"}, false); err != nil {
+		t.Fatalf("SetURLSources failed: %v", err)
+	}
+	for _, bucket := range []string{"busy", "down"} {
+		if err := localBackend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+		if _, err := lazyBackend.GetObject(bucket, "key", nil); !errors.Is(err, ErrUpstreamUnavailable) {
+			t.Errorf("GET from %s upstream: err = %v, want ErrUpstreamUnavailable", bucket, err)
+		}
+		if _, err := lazyBackend.HeadObject(bucket, "key"); !errors.Is(err, ErrUpstreamUnavailable) {
+			t.Errorf("HEAD from %s upstream: err = %v, want ErrUpstreamUnavailable", bucket, err)
+		}
+	}
+}
+
+func TestErrors_ReadOnly(t *testing.T) {
+	lazyBackend, localBackend, _, _ := setupTestBackends(t)
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	putString(t, lazyBackend, "key", "data")
+
+	lazyBackend.toggles.readOnly.Store(true)
+	_, err := lazyBackend.PutObject("test-bucket", "key", map[string]string{}, strings.NewReader("new"), 3, nil)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("PutObject: err = %v, want ErrReadOnly", err)
+	}
+	if _, err := lazyBackend.DeleteObject("test-bucket", "key"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeleteObject: err = %v, want ErrReadOnly", err)
+	}
+	if err := lazyBackend.CreateBucket("other"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("CreateBucket: err = %v, want ErrReadOnly", err)
+	}
+	if _, data := readObject(t, lazyBackend, "key", nil); data != "data" {
+		t.Errorf("key = %q after rejected writes, want %q", data, "data")
+	}
+}
+
+func TestErrors_ReadOnlyBucket(t *testing.T) {
+	lazyBackend, localBackend, _, _ := setupTestBackends(t)
+	for _, bucket := range []string{"test-bucket", "other"} {
+		if err := localBackend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, lazyBackend, "key", "data")
+
+	lazyBackend.SetReadOnlyBuckets([]string{"test-bucket"})
+	_, err := lazyBackend.PutObject("test-bucket", "key", map[string]string{}, strings.NewReader("new"), 3, nil)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("PutObject: err = %v, want ErrReadOnly", err)
+	}
+	if _, err := lazyBackend.DeleteObject("test-bucket", "key"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeleteObject: err = %v, want ErrReadOnly", err)
+	}
+	if _, err := lazyBackend.CopyObject("other", "key", "test-bucket", "copy", nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("CopyObject into the bucket: err = %v, want ErrReadOnly", err)
+	}
+	if _, err := lazyBackend.PutObject("other", "key", map[string]string{}, strings.NewReader("new"), 3, nil); err != nil {
+		t.Errorf("PutObject to another bucket: %v", err)
+	}
+}
+
+func TestErrors_CacheFull(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, _ := setupTestBackends(t)
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "key", "data")
+	full := NewLazyBackend(fullBackend{localBackend}, lazyBackend.awsClient)
+
+	if _, err := full.GetObject("test-bucket", "key", nil); !errors.Is(err, ErrCacheFull) || !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("fill: err = %v, want ErrCacheFull wrapping ENOSPC", err)
+	}
+	_, err := full.PutObject("test-bucket", "key", map[string]string{}, strings.NewReader("new"), 3, nil)
+	if !errors.Is(err, ErrCacheFull) {
+		t.Errorf("PutObject: err = %v, want ErrCacheFull", err)
+	}
+}
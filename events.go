@@ -0,0 +1,134 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultEventLogKeys is how many keys the event log tracks unless
+// configured otherwise.
+const defaultEventLogKeys = 10000
+
+// maxEventsPerKey bounds the history kept for a single key; older events
+// are dropped first.
+const maxEventsPerKey = 32
+
+// Events recorded in a key's history.
+const (
+	eventFetched     = "fetched"      // downloaded from upstream into the cache
+	eventServed      = "served"       // read from the cache
+	eventRefreshed   = "refreshed"    // revalidated or re-downloaded
+	eventExpired     = "expired"      // outlived its TTL
+	eventEvicted     = "evicted"      // deleted to make room
+	eventPurged      = "purged"       // deleted through the admin API
+	eventDropped     = "dropped"      // deleted because it may no longer be cached
+	eventWritten     = "written"      // written by a client
+	eventDeleted     = "deleted"      // deleted by a client
+	eventWrittenBack = "written_back" // uploaded to upstream through a pre-signed upload
+)
+
+// keyEvent is one entry of a key's history. Consecutive events of the same
+// kind and detail are folded into one, counting the repeats, with the time
+// of the latest.
+type keyEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+	Count  int       `json:"count,omitempty"`
+}
+
+// keyHistory is the recorded history of one key.
+type keyHistory struct {
+	events []keyEvent
+	last   time.Time
+}
+
+// eventLog keeps a compact history of what happened to each key, so
+// questions like "why did this key go stale" can be answered after the fact.
+// It tracks up to maxKeys keys, forgetting the least recently active ones
+// first.
+type eventLog struct {
+	maxKeys int
+	now     func() time.Time
+
+	mu   sync.Mutex
+	keys map[entryKey]*keyHistory
+}
+
+// newEventLog creates an event log tracking up to maxKeys keys. It returns
+// nil, which records nothing, for maxKeys <= 0.
+func newEventLog(maxKeys int) *eventLog {
+	if maxKeys <= 0 {
+		return nil
+	}
+	return &eventLog{maxKeys: maxKeys, now: time.Now, keys: make(map[entryKey]*keyHistory)}
+}
+
+// SetEventLogKeys sets how many keys the event log tracks; 0 disables it.
+func (b *LazyBackend) SetEventLogKeys(maxKeys int) {
+	b.events = newEventLog(maxKeys)
+}
+
+// record appends an event to a key's history.
+func (l *eventLog) record(bucketName, objectName, event, detail string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	k := entryKey{bucketName, objectName}
+	h, ok := l.keys[k]
+	if !ok {
+		if len(l.keys) >= l.maxKeys {
+			l.forgetOldest()
+		}
+		h = &keyHistory{}
+		l.keys[k] = h
+	}
+	h.last = now
+	if n := len(h.events); n > 0 && h.events[n-1].Event == event && h.events[n-1].Detail == detail {
+		last := &h.events[n-1]
+		last.Time = now
+		last.Count = max(last.Count, 1) + 1
+		return
+	}
+	h.events = append(h.events, keyEvent{Time: now, Event: event, Detail: detail})
+	if len(h.events) > maxEventsPerKey {
+		h.events = h.events[len(h.events)-maxEventsPerKey:]
+	}
+}
+
+// forgetOldest drops the tenth of the keys that were least recently active,
+// so making room is amortized over many new keys. The caller must hold l.mu.
+func (l *eventLog) forgetOldest() {
+	keys := slices.SortedFunc(maps.Keys(l.keys), func(a, b entryKey) int {
+		return l.keys[a].last.Compare(l.keys[b].last)
+	})
+	for _, k := range keys[:max(len(keys)/10, 1)] {
+		delete(l.keys, k)
+	}
+}
+
+// history returns a key's events since a time, oldest first, and whether
+// the key has any history at all.
+func (l *eventLog) history(bucketName, objectName string, since time.Time) ([]keyEvent, bool) {
+	if l == nil {
+		return nil, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, ok := l.keys[entryKey{bucketName, objectName}]
+	if !ok {
+		return nil, false
+	}
+	events := []keyEvent{}
+	for _, e := range h.events {
+		if !e.Time.Before(since) {
+			events = append(events, e)
+		}
+	}
+	return events, true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestEventLog_FoldsAndBounds(t *testing.T) {
+	el := newEventLog(10)
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	el.now = func() time.Time { return now }
+
+	el.record("b", "k", eventFetched, "")
+	for range 3 {
+		now = now.Add(time.Second)
+		el.record("b", "k", eventServed, "")
+	}
+	events, ok := el.history("b", "k", time.Time{})
+	if !ok || len(events) != 2 || events[1].Event != eventServed || events[1].Count != 3 || !events[1].Time.Equal(now) {
+		t.Fatalf("history = %+v, want fetched then 3 folded serves", events)
+	}
+	if events, _ := el.history("b", "k", now); len(events) != 1 {
+		t.Errorf("history since %v = %+v, want only the serves", now, events)
+	}
+
+	for i := range maxEventsPerKey + 5 {
+		el.record("b", "k", eventRefreshed, string(rune('a'+i)))
+	}
+	if events, _ := el.history("b", "k", time.Time{}); len(events) != maxEventsPerKey || events[0].Event != eventRefreshed {
+		t.Errorf("history has %d events starting %+v, want the last %d", len(events), events[0], maxEventsPerKey)
+	}
+
+	// A new key beyond the limit forgets the least recently active one
+	for i := range 9 {
+		now = now.Add(time.Second)
+		el.record("b", string(rune('0'+i)), eventServed, "")
+	}
+	el.record("b", "new", eventServed, "")
+	if _, ok := el.history("b", "k", time.Time{}); ok {
+		t.Error("oldest key still tracked after exceeding the limit")
+	}
+	if _, ok := el.history("b", "new", time.Time{}); !ok {
+		t.Error("new key not tracked")
+	}
+
+	var disabled *eventLog
+	disabled.record("b", "k", eventServed, "")
+	if _, ok := disabled.history("b", "k", time.Time{}); ok {
+		t.Error("disabled event log recorded an event")
+	}
+}
+
+func TestLazyBackend_EventLog(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "file.txt", "upstream")
+	readObject(t, lazyBackend, "file.txt", nil)
+	readObject(t, lazyBackend, "file.txt", nil)
+	readObject(t, lazyBackend, "file.txt", nil)
+	if _, err := lazyBackend.Purge("test-bucket", "file.txt"); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	putString(t, lazyBackend, "file.txt", "client data")
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/events/test-bucket/file.txt", nil))
+	var resp struct {
+		Events []keyEvent `json:"events"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/events: status %d, err %v", rec.Code, err)
+	}
+	var got []string
+	for _, e := range resp.Events {
+		got = append(got, e.Event)
+	}
+	want := []string{eventFetched, eventServed, eventPurged, eventWritten}
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+	if resp.Events[1].Count != 2 {
+		t.Errorf("served count = %d, want 2", resp.Events[1].Count)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/events/test-bucket/other.txt", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown key: status %d, want 404", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/events/test-bucket/file.txt?since=yesterday", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid since: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventSinkBatch bounds the events an event sink publishes at once.
+const eventSinkBatch = 100
+
+// eventSinkTimeout bounds connecting to an event sink and each publish.
+const eventSinkTimeout = 10 * time.Second
+
+// sinkEvent is what event sinks publish: a cache event or a toggle change
+// from the audit log.
+type sinkEvent struct {
+	Type  string      `json:"type"` // "cache" or "audit"
+	Cache *CacheEvent `json:"cache,omitempty"`
+	Audit *auditEntry `json:"audit,omitempty"`
+}
+
+// key is what the event is partitioned by: the object of a cache event, or
+// the setting of an audit entry.
+func (e sinkEvent) key() string {
+	if e.Cache != nil {
+		return e.Cache.Bucket + "/" + e.Cache.Key
+	}
+	return e.Audit.Setting
+}
+
+// eventPublisher delivers batches of events to a message system.
+type eventPublisher interface {
+	publish(ctx context.Context, events []sinkEvent) error
+	close()
+}
+
+// eventSink publishes cache and audit events to a message system such as
+// Kafka or NATS, from a queue so a slow broker never holds up requests.
+type eventSink struct {
+	name    string
+	pub     eventPublisher
+	queue   chan sinkEvent
+	dropped atomic.Int64
+}
+
+// newEventSink returns the sink for a URL:
+//
+//	nats://[user:password@|token@]host:4222/subject
+//	kafka+http(s)://rest-proxy:8082/topic
+//
+// Kafka is reached through a Confluent-compatible REST proxy. Nothing is
+// dialed until the first event.
+func newEventSink(rawURL string) (*eventSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event sink %q: %w", rawURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid event sink %q: no host", redactURL(rawURL))
+	}
+	var pub eventPublisher
+	switch u.Scheme {
+	case "nats":
+		pub, err = newNATSPublisher(u)
+	case "kafka+http", "kafka+https":
+		pub, err = newKafkaRESTPublisher(u)
+	default:
+		err = errors.New("want nats:// or kafka+http(s)://")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid event sink %q: %w", redactURL(rawURL), err)
+	}
+	return &eventSink{name: redactURL(rawURL), pub: pub, queue: make(chan sinkEvent, webhookQueueSize)}, nil
+}
+
+// sendCache queues a cache event.
+func (s *eventSink) sendCache(e CacheEvent) {
+	s.send(sinkEvent{Type: "cache", Cache: &e})
+}
+
+// sendAudit queues an audit entry.
+func (s *eventSink) sendAudit(e auditEntry) {
+	s.send(sinkEvent{Type: "audit", Audit: &e})
+}
+
+// send queues an event to be published, dropping it if the queue is full.
+func (s *eventSink) send(e sinkEvent) {
+	select {
+	case s.queue <- e:
+	default:
+		if n := s.dropped.Add(1); n%100 == 1 {
+			log.Printf("[EVENT SINK] %s: queue full: dropped %d event(s) so far", s.name, n)
+		}
+	}
+}
+
+// run publishes queued events in batches until ctx is done.
+func (s *eventSink) run(ctx context.Context) {
+	defer s.pub.close()
+	for {
+		var batch []sinkEvent
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-s.queue:
+			batch = append(batch, e)
+		}
+	drain:
+		for len(batch) < eventSinkBatch {
+			select {
+			case e := <-s.queue:
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		pubCtx, cancel := context.WithTimeout(ctx, eventSinkTimeout)
+		if err := s.pub.publish(pubCtx, batch); err != nil && ctx.Err() == nil {
+			log.Printf("[EVENT SINK ERROR] %s: dropped %d event(s): %v", s.name, len(batch), err)
+		}
+		cancel()
+	}
+}
+
+// natsPublisher publishes each event as a JSON message on subject.cache or
+// subject.audit, over one connection speaking the NATS text protocol.
+type natsPublisher struct {
+	addr     string
+	subject  string
+	user     string
+	password string
+	token    string
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func newNATSPublisher(u *url.URL) (*natsPublisher, error) {
+	p := &natsPublisher{addr: u.Host, subject: strings.Trim(u.Path, "/")}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if p.subject == "" {
+		p.subject = "s3lazy"
+	}
+	if strings.ContainsAny(p.subject, " \t*>/") {
+		return nil, fmt.Errorf("invalid subject %q", p.subject)
+	}
+	if password, ok := u.User.Password(); ok {
+		p.user, p.password = u.User.Username(), password
+	} else if u.User != nil {
+		p.token = u.User.Username()
+	}
+	return p, nil
+}
+
+// natsInfo is the part of the server's INFO message s3lazy checks.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// connect dials the server and waits for it to accept the CONNECT. The
+// caller must hold p.mu.
+func (p *natsPublisher) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: eventSinkTimeout}
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(eventSinkTimeout))
+	r := bufio.NewReader(conn)
+	fail := func(err error) error {
+		conn.Close()
+		return err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fail(err)
+	}
+	var info natsInfo
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(line[len("INFO "):]), &info) != nil {
+		return fail(fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line)))
+	}
+	if info.TLSRequired {
+		return fail(errors.New("server requires TLS, which isn't supported"))
+	}
+	opts, _ := json.Marshal(map[string]any{
+		"verbose": false, "pedantic": false, "name": "s3lazy", "lang": "go",
+		"user": p.user, "pass": p.password, "auth_token": p.token,
+	})
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", opts); err != nil {
+		return fail(err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fail(err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			_ = conn.SetDeadline(time.Time{})
+			p.conn, p.w = conn, bufio.NewWriter(conn)
+			go p.read(conn, r)
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fail(fmt.Errorf("server: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR"))))
+		}
+	}
+}
+
+// read answers the server's keepalive PINGs and logs its errors until the
+// connection closes.
+func (p *natsPublisher) read(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			p.mu.Lock()
+			if p.conn == conn {
+				_ = conn.SetWriteDeadline(time.Now().Add(eventSinkTimeout))
+				_, _ = p.w.WriteString("PONG\r\n")
+				_ = p.w.Flush()
+			}
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("[EVENT SINK ERROR] nats %s: %s", p.addr, line)
+		}
+	}
+	p.mu.Lock()
+	if p.conn == conn {
+		p.conn.Close()
+		p.conn = nil
+	}
+	p.mu.Unlock()
+}
+
+func (p *natsPublisher) publish(ctx context.Context, events []sinkEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return err
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	_ = p.conn.SetWriteDeadline(deadline)
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.w, "PUB %s.%s %d\r\n", p.subject, e.Type, len(data))
+		p.w.Write(data)
+		p.w.WriteString("\r\n")
+	}
+	if err := p.w.Flush(); err != nil {
+		// Reconnect for the next batch
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (p *natsPublisher) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// kafkaRESTPublisher produces events to a Kafka topic through a REST proxy,
+// one request per batch, keyed by object or setting.
+type kafkaRESTPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func newKafkaRESTPublisher(u *url.URL) (*kafkaRESTPublisher, error) {
+	prefix, topic := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if topic == "" {
+		return nil, errors.New("no topic")
+	}
+	proxy := *u
+	proxy.Scheme = strings.TrimPrefix(u.Scheme, "kafka+")
+	proxy.Path = path.Join(prefix, "topics", topic)
+	return &kafkaRESTPublisher{url: proxy.String(), client: &http.Client{Timeout: eventSinkTimeout}}, nil
+}
+
+// kafkaRecord is one record of a REST proxy produce request.
+type kafkaRecord struct {
+	Key   string    `json:"key"`
+	Value sinkEvent `json:"value"`
+}
+
+// kafkaOffsets is the part of a produce response that reports records the
+// proxy couldn't write.
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (p *kafkaRESTPublisher) publish(ctx context.Context, events []sinkEvent) error {
+	records := make([]kafkaRecord, len(events))
+	for i, e := range events {
+		records[i] = kafkaRecord{Key: e.key(), Value: e}
+	}
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("REST proxy returned %s", resp.Status)
+	}
+	var out kafkaOffsets
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		// The proxy accepted the batch; it just didn't report offsets
+		return nil
+	}
+	failed := 0
+	var last string
+	for _, o := range out.Offsets {
+		if o.ErrorCode != nil {
+			failed++
+			last = o.Error
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d record(s) failed: %s", failed, len(events), last)
+	}
+	return nil
+}
+
+func (p *kafkaRESTPublisher) close() {}
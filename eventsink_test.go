@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts one connection, checks the CONNECT and reports the
+// subject and payload of each PUB.
+func fakeNATS(t *testing.T, token string) (string, <-chan [2]string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	msgs := make(chan [2]string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"auth_required\":true}\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "CONNECT":
+				var opts struct {
+					Token string `json:"auth_token"`
+				}
+				json.Unmarshal([]byte(fields[1]), &opts)
+				if opts.Token != token {
+					fmt.Fprintf(conn, "-ERR 'Authorization Violation'\r\n")
+					return
+				}
+			case "PING":
+				fmt.Fprintf(conn, "PONG\r\n")
+			case "PUB":
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				msgs <- [2]string{fields[1], string(payload[:n])}
+			}
+		}
+	}()
+	return ln.Addr().String(), msgs
+}
+
+func TestEventSink_NATS(t *testing.T) {
+	addr, msgs := fakeNATS(t, "s3cret")
+	sink, err := newEventSink("nats://s3cret@" + addr + "/s3lazy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.run(ctx)
+
+	sink.sendCache(CacheEvent{Event: "fetched", Bucket: "my-bucket", Key: "config.json", Size: 2048})
+	sink.sendAudit(auditEntry{Identity: "alice", Setting: "offline", From: false, To: true})
+	for _, want := range []struct{ subject, field string }{{"s3lazy.cache", "config.json"}, {"s3lazy.audit", "offline"}} {
+		select {
+		case msg := <-msgs:
+			if msg[0] != want.subject || !strings.Contains(msg[1], want.field) {
+				t.Errorf("message = %q on %s, want %s on %s", msg[1], msg[0], want.field, want.subject)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no message on %s", want.subject)
+		}
+	}
+}
+
+func TestEventSink_NATSRejectsBadToken(t *testing.T) {
+	addr, _ := fakeNATS(t, "s3cret")
+	sink, err := newEventSink("nats://wrong@" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.pub.publish(context.Background(), []sinkEvent{{Type: "cache", Cache: &CacheEvent{}}})
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("publish error = %v, want the server's authorization error", err)
+	}
+}
+
+func TestEventSink_KafkaREST(t *testing.T) {
+	var gotPath, gotType string
+	var got struct {
+		Records []struct {
+			Key   string    `json:"key"`
+			Value sinkEvent `json:"value"`
+		} `json:"records"`
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotType = r.URL.Path, r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":1},{"partition":0,"offset":2}]}`)
+	}))
+	defer proxy.Close()
+
+	sink, err := newEventSink("kafka+" + proxy.URL + "/s3lazy-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.pub.publish(context.Background(), []sinkEvent{
+		{Type: "cache", Cache: &CacheEvent{Event: "evicted", Bucket: "my-bucket", Key: "old.csv"}},
+		{Type: "audit", Audit: &auditEntry{Setting: "read_only"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/topics/s3lazy-events" || gotType != "application/vnd.kafka.json.v2+json" {
+		t.Errorf("POST %s as %s, want /topics/s3lazy-events as application/vnd.kafka.json.v2+json", gotPath, gotType)
+	}
+	if len(got.Records) != 2 || got.Records[0].Key != "my-bucket/old.csv" || got.Records[1].Key != "read_only" || got.Records[1].Value.Type != "audit" {
+		t.Errorf("records = %+v", got.Records)
+	}
+}
+
+func TestEventSink_KafkaRESTRecordErrors(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"offsets":[{"error_code":50003,"error":"Kafka error: leader not available"}]}`)
+	}))
+	defer proxy.Close()
+
+	sink, err := newEventSink("kafka+" + proxy.URL + "/s3lazy-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.pub.publish(context.Background(), []sinkEvent{{Type: "cache", Cache: &CacheEvent{}}})
+	if err == nil || !strings.Contains(err.Error(), "leader not available") {
+		t.Errorf("publish error = %v, want the record's error", err)
+	}
+}
+
+func TestOnAudit(t *testing.T) {
+	lazyBackend, _, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	var got []auditEntry
+	lazyBackend.OnAudit(func(e auditEntry) { got = append(got, e) })
+
+	on := true
+	if err := lazyBackend.toggles.apply(toggleState{Offline: &on}, "alice", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Setting != "offline" || got[0].Identity != "alice" {
+		t.Errorf("audit hook got %+v", got)
+	}
+}
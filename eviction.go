@@ -0,0 +1,322 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"iter"
+	"sort"
+	"strings"
+)
+
+// evictionPolicy decides the order cache entries are evicted in. The cache
+// index tells it about every entry it tracks, under the index lock.
+type evictionPolicy interface {
+	// added starts tracking a new entry. Entries loaded from a saved index
+	// are added least recently used first and keep their hit counts.
+	added(e *cacheEntry)
+	// accessed records a cache hit on an entry, or its refill from upstream.
+	// Hits are counted in e.Hits before it is called.
+	accessed(e *cacheEntry)
+	// removed stops tracking an entry.
+	removed(e *cacheEntry)
+	// victims yields the tracked entries in the order they should be evicted.
+	victims() iter.Seq[*cacheEntry]
+}
+
+// evictionPolicies are the policies eviction_policy selects from.
+var evictionPolicies = map[string]func() evictionPolicy{
+	"lru": func() evictionPolicy { return newLRUPolicy() },
+	"lfu": func() evictionPolicy { return newLFUPolicy() },
+	"arc": func() evictionPolicy { return newARCPolicy() },
+}
+
+// lookupEvictionPolicy returns a new policy by name.
+func lookupEvictionPolicy(name string) (evictionPolicy, error) {
+	newPolicy, ok := evictionPolicies[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		names := make([]string, 0, len(evictionPolicies))
+		for n := range evictionPolicies {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown eviction policy %q (valid options: %s)", name, strings.Join(names, ", "))
+	}
+	return newPolicy(), nil
+}
+
+// entryList is a list of cache entries in recency order, front is most
+// recently used.
+type entryList struct {
+	order *list.List
+	elems map[*cacheEntry]*list.Element
+}
+
+func newEntryList() *entryList {
+	return &entryList{order: list.New(), elems: make(map[*cacheEntry]*list.Element)}
+}
+
+func (l *entryList) pushFront(e *cacheEntry) {
+	l.elems[e] = l.order.PushFront(e)
+}
+
+func (l *entryList) moveToFront(e *cacheEntry) {
+	l.order.MoveToFront(l.elems[e])
+}
+
+// remove drops an entry, reporting whether the list held it.
+func (l *entryList) remove(e *cacheEntry) bool {
+	el, ok := l.elems[e]
+	if ok {
+		l.order.Remove(el)
+		delete(l.elems, e)
+	}
+	return ok
+}
+
+func (l *entryList) len() int {
+	return l.order.Len()
+}
+
+// oldestFirst yields the entries least recently used first.
+func (l *entryList) oldestFirst() iter.Seq[*cacheEntry] {
+	return func(yield func(*cacheEntry) bool) {
+		for el := l.order.Back(); el != nil; el = el.Prev() {
+			if !yield(el.Value.(*cacheEntry)) {
+				return
+			}
+		}
+	}
+}
+
+// lruPolicy evicts the least recently used entries first.
+type lruPolicy struct {
+	entries *entryList
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{entries: newEntryList()}
+}
+
+func (p *lruPolicy) added(e *cacheEntry)    { p.entries.pushFront(e) }
+func (p *lruPolicy) accessed(e *cacheEntry) { p.entries.moveToFront(e) }
+func (p *lruPolicy) removed(e *cacheEntry)  { p.entries.remove(e) }
+
+func (p *lruPolicy) victims() iter.Seq[*cacheEntry] {
+	return p.entries.oldestFirst()
+}
+
+// lfuPolicy evicts the least frequently used entries first, and the least
+// recently used of those with the same hit count. A scan of many keys read
+// once doesn't push out keys that are read often, but keys that were popular
+// once stay cached until newer ones are hit more often.
+type lfuPolicy struct {
+	byHits *list.List // of *lfuBucket, fewest hits first
+	bucket map[*cacheEntry]*list.Element
+}
+
+// lfuBucket holds the entries with the same hit count.
+type lfuBucket struct {
+	hits    int64
+	entries *entryList
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{byHits: list.New(), bucket: make(map[*cacheEntry]*list.Element)}
+}
+
+// file puts an entry in the bucket for its hit count, searching from after.
+func (p *lfuPolicy) file(e *cacheEntry, after *list.Element) {
+	el := p.byHits.Front()
+	if after != nil {
+		el = after
+	}
+	for el != nil && el.Value.(*lfuBucket).hits < e.Hits {
+		el = el.Next()
+	}
+	if el == nil || el.Value.(*lfuBucket).hits != e.Hits {
+		b := &lfuBucket{hits: e.Hits, entries: newEntryList()}
+		if el == nil {
+			el = p.byHits.PushBack(b)
+		} else {
+			el = p.byHits.InsertBefore(b, el)
+		}
+	}
+	el.Value.(*lfuBucket).entries.pushFront(e)
+	p.bucket[e] = el
+}
+
+// unfile takes an entry out of its bucket, returning the bucket before it
+// (nil if there is none), and drops the bucket if it is left empty.
+func (p *lfuPolicy) unfile(e *cacheEntry) *list.Element {
+	el, ok := p.bucket[e]
+	if !ok {
+		return nil
+	}
+	delete(p.bucket, e)
+	prev := el.Prev()
+	b := el.Value.(*lfuBucket)
+	b.entries.remove(e)
+	if b.entries.len() == 0 {
+		p.byHits.Remove(el)
+	}
+	return prev
+}
+
+func (p *lfuPolicy) added(e *cacheEntry) {
+	p.file(e, nil)
+}
+
+func (p *lfuPolicy) accessed(e *cacheEntry) {
+	// Hit counts only grow, so the new bucket is after the old one
+	p.file(e, p.unfile(e))
+}
+
+func (p *lfuPolicy) removed(e *cacheEntry) {
+	p.unfile(e)
+}
+
+func (p *lfuPolicy) victims() iter.Seq[*cacheEntry] {
+	return func(yield func(*cacheEntry) bool) {
+		for el := p.byHits.Front(); el != nil; el = el.Next() {
+			for e := range el.Value.(*lfuBucket).entries.oldestFirst() {
+				if !yield(e) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// arcPolicy is an adaptive replacement cache (Megiddo and Modha). Entries
+// are kept in two lists: recent holds those used once since they were
+// cached, frequent those used again. Keys evicted from each are remembered
+// as ghosts; a ghost cached again shows which list was evicted from too
+// eagerly, and shifts the target size of recent towards it. A scan only
+// churns recent, while frequent keeps the working set.
+//
+// Sizes are counted in entries rather than bytes, and ghosts are bounded by
+// the number of entries cached.
+type arcPolicy struct {
+	recent, frequent *entryList
+
+	// Ghosts of keys evicted from recent and frequent, most recent first
+	recentGhosts, frequentGhosts *ghostList
+
+	// target is the number of entries recent aims for
+	target int
+}
+
+func newARCPolicy() *arcPolicy {
+	return &arcPolicy{
+		recent:         newEntryList(),
+		frequent:       newEntryList(),
+		recentGhosts:   newGhostList(),
+		frequentGhosts: newGhostList(),
+	}
+}
+
+func (p *arcPolicy) added(e *cacheEntry) {
+	k := entryKey{e.Bucket, e.Key}
+	size := p.recent.len() + p.frequent.len() + 1
+	switch {
+	case p.recentGhosts.remove(k):
+		// recent was too small
+		p.target = min(size, p.target+max(p.frequentGhosts.len()/max(p.recentGhosts.len(), 1), 1))
+		p.frequent.pushFront(e)
+	case p.frequentGhosts.remove(k):
+		// frequent was too small
+		p.target = max(0, p.target-max(p.recentGhosts.len()/max(p.frequentGhosts.len(), 1), 1))
+		p.frequent.pushFront(e)
+	case e.Hits > 0:
+		// Loaded from a saved index after being hit
+		p.frequent.pushFront(e)
+	default:
+		p.recent.pushFront(e)
+	}
+}
+
+func (p *arcPolicy) accessed(e *cacheEntry) {
+	if p.recent.remove(e) {
+		p.frequent.pushFront(e)
+	} else {
+		p.frequent.moveToFront(e)
+	}
+}
+
+func (p *arcPolicy) removed(e *cacheEntry) {
+	k := entryKey{e.Bucket, e.Key}
+	if p.recent.remove(e) {
+		p.recentGhosts.pushFront(k)
+	} else if p.frequent.remove(e) {
+		p.frequentGhosts.pushFront(k)
+	}
+	// Remember at most as many ghosts as there are entries
+	size := p.recent.len() + p.frequent.len()
+	for p.recentGhosts.len()+p.frequentGhosts.len() > size {
+		if p.recentGhosts.len() > 0 {
+			p.recentGhosts.removeOldest()
+		} else {
+			p.frequentGhosts.removeOldest()
+		}
+	}
+}
+
+// victims takes the least recently used entry of recent while it is over
+// its target, and of frequent otherwise.
+func (p *arcPolicy) victims() iter.Seq[*cacheEntry] {
+	return func(yield func(*cacheEntry) bool) {
+		nextRecent, stopRecent := iter.Pull(p.recent.oldestFirst())
+		defer stopRecent()
+		nextFrequent, stopFrequent := iter.Pull(p.frequent.oldestFirst())
+		defer stopFrequent()
+		recentLeft, frequentLeft := p.recent.len(), p.frequent.len()
+		for recentLeft > 0 || frequentLeft > 0 {
+			var e *cacheEntry
+			if recentLeft > 0 && (recentLeft > p.target || frequentLeft == 0) {
+				e, _ = nextRecent()
+				recentLeft--
+			} else {
+				e, _ = nextFrequent()
+				frequentLeft--
+			}
+			if !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// ghostList remembers keys evicted from an ARC list, most recent first.
+type ghostList struct {
+	order *list.List
+	elems map[entryKey]*list.Element
+}
+
+func newGhostList() *ghostList {
+	return &ghostList{order: list.New(), elems: make(map[entryKey]*list.Element)}
+}
+
+func (g *ghostList) pushFront(k entryKey) {
+	g.remove(k)
+	g.elems[k] = g.order.PushFront(k)
+}
+
+// remove forgets a key, reporting whether it was remembered.
+func (g *ghostList) remove(k entryKey) bool {
+	el, ok := g.elems[k]
+	if ok {
+		g.order.Remove(el)
+		delete(g.elems, k)
+	}
+	return ok
+}
+
+func (g *ghostList) removeOldest() {
+	if el := g.order.Back(); el != nil {
+		g.remove(el.Value.(entryKey))
+	}
+}
+
+func (g *ghostList) len() int {
+	return g.order.Len()
+}
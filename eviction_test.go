@@ -0,0 +1,139 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// candidateKeys returns the keys of the eviction candidates of an index.
+func candidateKeys(x *cacheIndex) []string {
+	var keys []string
+	for _, c := range x.evictionCandidates(keepNone) {
+		keys = append(keys, c.Key)
+	}
+	return keys
+}
+
+// A scan of keys read once evicts the scanned keys rather than a key read
+// often, except under LRU.
+func TestEvictionPolicies_Scan(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		want   []string
+	}{
+		{"lru", []string{"hot", "scan1"}},
+		{"lfu", []string{"scan1", "scan2"}},
+		{"arc", []string{"scan1", "scan2"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			x := newCacheIndex(300)
+			policy, err := lookupEvictionPolicy(tc.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			x.setPolicy(policy)
+
+			x.add("b", "hot", 100, "")
+			x.touch("b", "hot")
+			x.touch("b", "hot")
+			for _, key := range []string{"scan1", "scan2", "scan3", "scan4"} {
+				x.add("b", key, 100, "")
+			}
+
+			// 500 bytes against a 300-byte budget
+			if got := candidateKeys(x); !slices.Equal(got, tc.want) {
+				t.Errorf("candidates = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLFUPolicy_Order(t *testing.T) {
+	x := newCacheIndex(0)
+	x.setPolicy(newLFUPolicy())
+	for _, key := range []string{"a", "b", "c"} {
+		x.add("b", key, 1, "")
+	}
+	x.touch("b", "a")
+	x.touch("b", "a")
+	x.touch("b", "b")
+	x.touch("b", "c")
+	x.add("b", "d", 1, "")
+
+	var keys []string
+	for e := range x.policy.victims() {
+		keys = append(keys, e.Key)
+	}
+	if got := strings.Join(keys, ","); got != "d,b,c,a" {
+		t.Errorf("eviction order = %s, want fewest hits first, then least recently used", got)
+	}
+
+	x.remove("b", "b")
+	x.remove("b", "c")
+	keys = keys[:0]
+	for e := range x.policy.victims() {
+		keys = append(keys, e.Key)
+	}
+	if got := strings.Join(keys, ","); got != "d,a" {
+		t.Errorf("eviction order = %s after removals, want d,a", got)
+	}
+}
+
+// An ARC key evicted from the recent list and cached again goes to the
+// frequent list, and grows the recent list's target.
+func TestARCPolicy_Ghosts(t *testing.T) {
+	p := newARCPolicy()
+	a := &cacheEntry{Bucket: "b", Key: "a"}
+	b := &cacheEntry{Bucket: "b", Key: "b"}
+	p.added(a)
+	p.added(b)
+	p.removed(a)
+	if p.recentGhosts.len() != 1 {
+		t.Fatalf("recent ghosts = %d, want 1", p.recentGhosts.len())
+	}
+
+	again := &cacheEntry{Bucket: "b", Key: "a"}
+	p.added(again)
+	if p.frequent.len() != 1 || p.target != 1 {
+		t.Errorf("frequent = %d, target = %d; want the ghost hit in frequent and a target of 1", p.frequent.len(), p.target)
+	}
+	// recent is within its target now, so frequent is evicted from first
+	var keys []string
+	for e := range p.victims() {
+		keys = append(keys, e.Key)
+	}
+	if got := strings.Join(keys, ","); got != "a,b" {
+		t.Errorf("eviction order = %s, want a,b", got)
+	}
+}
+
+func TestCacheIndex_SetPolicyKeepsEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	x := newCacheIndex(0)
+	x.add("b", "first", 10, "")
+	x.add("b", "second", 10, "")
+	x.touch("b", "first")
+	if err := x.save(path); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	// Hit counts survive a restart and order entries under LFU
+	loaded := newCacheIndex(15)
+	loaded.setPolicy(newLFUPolicy())
+	if err := loaded.load(path); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if got := candidateKeys(loaded); !slices.Equal(got, []string{"second"}) {
+		t.Errorf("candidates = %v, want [second]", got)
+	}
+
+	loaded.setPolicy(newARCPolicy())
+	if n, bytes := loaded.usage(); n != 2 || bytes != 20 {
+		t.Errorf("usage = %d entries, %d bytes after switching policy, want 2, 20", n, bytes)
+	}
+	if got := candidateKeys(loaded); !slices.Equal(got, []string{"second"}) {
+		t.Errorf("candidates = %v after switching to ARC, want [second]", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// expectContinueGuard answers PUTs sent with "Expect: 100-continue" that
+// are bound to fail before their body is read. net/http only sends the
+// client "100 Continue" once a handler starts reading the body, so a write
+// to a read-only or missing bucket gets its error instead, and the client
+// never sends a body that would be thrown away. Without this, the aws-chunked
+// decoder and multipart part uploads read the whole body before the backend
+// gets to refuse it.
+func (b *LazyBackend) expectContinueGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, _, ok := objectPath(r.URL.Path)
+		if r.Method != http.MethodPut || !ok || !strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := b.rejectBeforeBody(bucket); err != nil {
+			b.toggles.infof("[EXPECT] %s %s rejected before its body: %v", r.Method, r.URL.Path, err)
+			writeRejection(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectBeforeBody returns the error a write to the bucket fails with
+// whatever its body, or nil.
+func (b *LazyBackend) rejectBeforeBody(bucketName string) error {
+	if err := b.rejectWrite(bucketName); err != nil {
+		return err
+	}
+	if exists, err := b.BucketExists(bucketName); err == nil && !exists {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+	return nil
+}
+
+// writeRejection writes an S3 error response for a request refused before
+// it reaches gofakes3, such as by rejectBeforeBody or quotaGuard. Read-only
+// and quota rejections are 403s, as from readOnlyGuard.
+func writeRejection(w http.ResponseWriter, err error) {
+	resp := &gofakes3.ErrorResponse{Code: gofakes3.ErrInternal, Message: err.Error()}
+	var f *failure
+	var described *gofakes3.ErrorResponse
+	var coded gofakes3.Error
+	switch {
+	case errors.As(err, &f):
+		resp.Code, resp.Message = f.Code, f.Message
+	case errors.As(err, &described):
+		resp.Code, resp.Message = described.Code, described.Message
+	case errors.As(err, &coded):
+		resp.Code, resp.Message = coded.ErrorCode(), coded.ErrorCode().Message()
+	}
+	status := resp.Code.Status()
+	if errors.Is(err, ErrReadOnly) || errors.Is(err, ErrQuotaExceeded) {
+		status = http.StatusForbidden
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// sendExpectContinue sends the headers of a PUT expecting 100-continue and
+// returns the first response. If it's 100 Continue, the body is sent and the
+// final response returned.
+func sendExpectContinue(t *testing.T, url, path, body string) (interim, final int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", path, len(body))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusContinue {
+		return 0, resp.StatusCode
+	}
+	fmt.Fprint(conn, body)
+	if resp, err = http.ReadResponse(br, nil); err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	resp.Body.Close()
+	return http.StatusContinue, resp.StatusCode
+}
+
+func TestExpectContinueGuard(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, bucket := range []string{"test-bucket", "frozen"} {
+		if err := localBackend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	lazyBackend.SetReadOnlyBuckets([]string{"frozen"})
+	server := httptest.NewServer(lazyBackend.expectContinueGuard(gofakes3.New(lazyBackend).Server()))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		path           string
+		interim, final int
+	}{
+		{"/test-bucket/upload.bin", http.StatusContinue, http.StatusOK},
+		{"/frozen/upload.bin", 0, http.StatusForbidden},
+		{"/missing-bucket/upload.bin", 0, http.StatusNotFound},
+		{"/frozen/upload.bin?partNumber=1&uploadId=1", 0, http.StatusForbidden},
+	} {
+		interim, final := sendExpectContinue(t, server.URL, tt.path, "large upload")
+		if interim != tt.interim || final != tt.final {
+			t.Errorf("PUT %s: got %d then %d, want %d then %d", tt.path, interim, final, tt.interim, tt.final)
+		}
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "upload.bin"); err != nil {
+		t.Errorf("accepted upload not stored: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fallbackChain looks keys missing from an upstream bucket up in the next
+// buckets of its chain, in order, e.g. a staging bucket and then the
+// production bucket it is seeded from. Reads of a key come from the first
+// bucket that has it; listings only cover the first bucket.
+type fallbackChain struct {
+	upstreamLister
+	chains map[string][]string
+}
+
+func newFallbackChain(client upstreamLister, chains map[string][]string) *fallbackChain {
+	return &fallbackChain{upstreamLister: client, chains: chains}
+}
+
+// isMissingKey reports whether an upstream error says the key doesn't exist
+// in the bucket, which a GET reports as NoSuchKey and a HEAD as NotFound.
+func isMissingKey(err error) bool {
+	code := s3ErrorCode(err)
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+// tryChain calls op with the bucket and then each of its fallbacks until one
+// has the key, and returns the last answer.
+func tryChain[T any](c *fallbackChain, bucket, key string, op func(bucket string) (T, error)) (T, error) {
+	out, err := op(bucket)
+	for _, fallback := range c.chains[bucket] {
+		if !isMissingKey(err) {
+			break
+		}
+		if out, err = op(fallback); err == nil {
+			log.Printf("[FALLBACK] %s/%s found in %s", bucket, key, fallback)
+		}
+	}
+	return out, err
+}
+
+func (c *fallbackChain) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return tryChain(c, aws.ToString(params.Bucket), aws.ToString(params.Key), func(bucket string) (*s3.GetObjectOutput, error) {
+		in := *params
+		in.Bucket = aws.String(bucket)
+		return c.upstreamLister.GetObject(ctx, &in, optFns...)
+	})
+}
+
+func (c *fallbackChain) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return tryChain(c, aws.ToString(params.Bucket), aws.ToString(params.Key), func(bucket string) (*s3.HeadObjectOutput, error) {
+		in := *params
+		in.Bucket = aws.String(bucket)
+		return c.upstreamLister.HeadObject(ctx, &in, optFns...)
+	})
+}
+
+func (c *fallbackChain) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	return tryChain(c, aws.ToString(params.Bucket), aws.ToString(params.Key), func(bucket string) (*s3.GetObjectTaggingOutput, error) {
+		in := *params
+		in.Bucket = aws.String(bucket)
+		return c.upstreamLister.GetObjectTagging(ctx, &in, optFns...)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func TestFallbackChain(t *testing.T) {
+	backend := s3mem.New()
+	for _, bucket := range []string{"staging", "prod"} {
+		if err := backend.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for bucket, content := range map[string]string{"staging": "staging copy", "prod": "prod copy"} {
+		if _, err := backend.PutObject(bucket, "changed.txt", nil, strings.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := backend.PutObject("prod", "unchanged.txt", nil, strings.NewReader("prod only"), 9, nil); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	defer server.Close()
+	chain := newFallbackChain(newEndpointClient(t, server.URL), map[string][]string{"staging": {"prod"}})
+
+	ctx := context.Background()
+	for key, want := range map[string]string{"changed.txt": "staging copy", "unchanged.txt": "prod only"} {
+		out, err := chain.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("staging"), Key: aws.String(key)})
+		if err != nil {
+			t.Fatalf("GetObject(%s): %v", key, err)
+		}
+		data, _ := io.ReadAll(out.Body)
+		out.Body.Close()
+		if string(data) != want {
+			t.Errorf("GetObject(%s) = %q, want %q", key, data, want)
+		}
+	}
+
+	if _, err := chain.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("staging"), Key: aws.String("unchanged.txt")}); err != nil {
+		t.Errorf("HeadObject of a key only in the fallback: %v", err)
+	}
+	if _, err := chain.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("staging"), Key: aws.String("missing.txt")}); !isMissingKey(err) {
+		t.Errorf("HeadObject of a key missing everywhere = %v, want NotFound", err)
+	}
+	// Buckets without a chain don't fall back
+	if _, err := chain.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("prod"), Key: aws.String("missing.txt")}); !isMissingKey(err) {
+		t.Errorf("GetObject = %v, want NoSuchKey", err)
+	}
+}
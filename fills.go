@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// fillCall is a fill in progress, whose outcome the requests for the same
+// key wait for.
+type fillCall struct {
+	done    chan struct{}
+	obj     *gofakes3.Object
+	err     error
+	waiters int // guarded by fillGroup.mu
+}
+
+// fillGroup deduplicates concurrent fills of the same key, so a burst of
+// requests for an uncached object makes one upstream GET rather than queueing
+// on the key lock and each finding out for itself.
+type fillGroup struct {
+	mu    sync.Mutex
+	calls map[entryKey]*fillCall
+}
+
+func newFillGroup() *fillGroup {
+	return &fillGroup{calls: make(map[entryKey]*fillCall)}
+}
+
+// waiting returns how many requests wait for the fill of a key in flight.
+func (g *fillGroup) waiting(bucketName, objectName string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[entryKey{bucketName, objectName}]; ok {
+		return c.waiters
+	}
+	return 0
+}
+
+// do runs fill for a key unless a fill of it is already in flight, in which
+// case it waits for that one and shares its outcome. An outcome that can't be
+// shared makes the waiter run its own fill: an object streamed to another
+// client, a failure to cache the object, or the other request giving up.
+func (g *fillGroup) do(ctx context.Context, bucketName, objectName string, fill func() (*gofakes3.Object, error)) (*gofakes3.Object, error) {
+	k := entryKey{bucketName, objectName}
+	g.mu.Lock()
+	if c, ok := g.calls[k]; ok {
+		c.waiters++
+		g.mu.Unlock()
+		if shared, err := c.wait(ctx); shared {
+			return nil, err
+		}
+		return fill()
+	}
+	c := &fillCall{done: make(chan struct{})}
+	g.calls[k] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, k)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.obj, c.err = fill()
+	return c.obj, c.err
+}
+
+// join waits for a fill of a key in flight, if any, before a request looks
+// the key up in the cache, where it would otherwise queue behind the fill
+// and then try again itself if the fill failed. It returns the fill's error
+// if that can be shared.
+func (g *fillGroup) join(ctx context.Context, bucketName, objectName string) error {
+	g.mu.Lock()
+	c, ok := g.calls[entryKey{bucketName, objectName}]
+	if !ok {
+		g.mu.Unlock()
+		return nil
+	}
+	c.waiters++
+	g.mu.Unlock()
+	_, err := c.wait(ctx)
+	return err
+}
+
+// wait blocks until the fill is done and reports whether its outcome can be
+// shared, along with the error it shares.
+func (c *fillCall) wait(ctx context.Context) (bool, error) {
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+	if c.obj != nil || unshareable(c.err) {
+		return false, nil
+	}
+	return true, c.err
+}
+
+// unshareable reports whether a fill failed for reasons specific to the
+// request that ran it, so another request should try for itself.
+func unshareable(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errNotCacheable)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// gatedUpstream counts GETs and holds each one until released.
+type gatedUpstream struct {
+	upstreamClient
+	gets    atomic.Int32
+	release chan struct{}
+}
+
+func (g *gatedUpstream) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	g.gets.Add(1)
+	<-g.release
+	return g.upstreamClient.GetObject(ctx, params, optFns...)
+}
+
+func TestLazyBackend_ConcurrentMissesShareFill(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	upstream := &gatedUpstream{upstreamClient: lazyBackend.awsClient, release: make(chan struct{})}
+	lazyBackend.awsClient = upstream
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "file.txt", "shared")
+
+	const requests = 50
+	for _, tc := range []struct {
+		key     string
+		wantErr bool
+	}{
+		{"file.txt", false},
+		{"missing.txt", true},
+	} {
+		upstream.gets.Store(0)
+		upstream.release = make(chan struct{})
+		var wg sync.WaitGroup
+		errs := make(chan error, requests)
+		get := func() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				obj, err := lazyBackend.GetObject("test-bucket", tc.key, nil)
+				if err == nil {
+					obj.Contents.Close()
+				}
+				errs <- err
+			}()
+		}
+		waitFor := func(what string, done func() bool) {
+			t.Helper()
+			deadline := time.Now().Add(5 * time.Second)
+			for !done() {
+				if time.Now().After(deadline) {
+					t.Fatalf("%s: timed out waiting for %s", tc.key, what)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		// The rest arrive while the first request's GET is in flight
+		get()
+		waitFor("the upstream GET", func() bool { return upstream.gets.Load() == 1 })
+		for range requests - 1 {
+			get()
+		}
+		waitFor("the requests to queue", func() bool { return lazyBackend.fills.waiting("test-bucket", tc.key) == requests-1 })
+		close(upstream.release)
+		wg.Wait()
+		close(errs)
+
+		if n := upstream.gets.Load(); n != 1 {
+			t.Errorf("%s: %d upstream GETs for %d concurrent requests, want 1", tc.key, n, requests)
+		}
+		for err := range errs {
+			if (err != nil) != tc.wantErr {
+				t.Errorf("%s: GetObject error = %v, want error %v", tc.key, err, tc.wantErr)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// followParam makes a GET keep the response open after the object and
+// stream what is appended to it upstream, like tail -f. Its value is how long
+// to follow, e.g. "10m", or "true" to follow until the client disconnects.
+const followParam = "s3lazy-follow"
+
+const defaultFollowInterval = 2 * time.Second
+
+// SetFollowInterval sets how often followed objects are checked upstream for
+// appends.
+func (b *LazyBackend) SetFollowInterval(interval time.Duration) {
+	b.followInterval = interval
+}
+
+// followGuard serves GETs that ask to follow an object: the object is sent
+// as usual, then upstream is polled and every time the object has grown,
+// the new bytes are sent too. The response has no Content-Length and ends
+// when the follow time is up, the client disconnects, or the object
+// disappears or shrinks upstream.
+func (b *LazyBackend) followGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.URL.Query().Get(followParam)
+		bucket, key, ok := objectPath(r.URL.Path)
+		if r.Method != http.MethodGet || value == "" || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		until, err := parseFollow(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		obj, err := b.GetObjectContext(r.Context(), bucket, key, nil)
+		if err != nil {
+			// The plain GET fails the same way, as an S3 error
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if until > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, until)
+			defer cancel()
+		}
+		b.follow(ctx, w, b.canonicalBucket(bucket), key, obj.Metadata["Content-Type"], obj.Contents)
+	})
+}
+
+// parseFollow parses the value of followParam into how long to follow, 0
+// meaning until the client disconnects.
+func parseFollow(value string) (time.Duration, error) {
+	if on, err := strconv.ParseBool(value); err == nil {
+		if !on {
+			return 0, fmt.Errorf("%s: set a duration or true", followParam)
+		}
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s: invalid duration %q", followParam, value)
+	}
+	return d, nil
+}
+
+// follow writes the object's current contents and then its appends until
+// ctx is done.
+func (b *LazyBackend) follow(ctx context.Context, w http.ResponseWriter, bucketName, objectName, contentType string, contents io.ReadCloser) {
+	rc := http.NewResponseController(w)
+	// A follow outlasts the listener's write timeout by design
+	_ = rc.SetWriteDeadline(time.Time{})
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	offset, err := io.Copy(w, contents)
+	contents.Close()
+	if err != nil {
+		return
+	}
+	_ = rc.Flush()
+	log.Printf("[FOLLOW] %s/%s from %d bytes", bucketName, objectName, offset)
+
+	upstream, awsBucket := b.upstreamFor(bucketName)
+	changed := false
+	defer func() {
+		// Later plain GETs get the grown object rather than the cached copy
+		if changed {
+			b.bypassCache(context.Background(), bucketName, objectName, true)
+		}
+	}()
+	ticker := time.NewTicker(b.followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if b.toggles.offline.Load() {
+			continue
+		}
+		head, err := upstream.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(awsBucket),
+			Key:    aws.String(objectName),
+		})
+		if err != nil {
+			if b.quirks.isNotFound(err) {
+				log.Printf("[FOLLOW] %s/%s is gone upstream", bucketName, objectName)
+				return
+			}
+			if ctx.Err() == nil {
+				log.Printf("[FOLLOW ERROR] %s/%s: %v", bucketName, objectName, err)
+			}
+			continue
+		}
+		size := aws.ToInt64(head.ContentLength)
+		if size < offset {
+			log.Printf("[FOLLOW] %s/%s shrank upstream from %d to %d bytes", bucketName, objectName, offset, size)
+			return
+		}
+		if size == offset {
+			continue
+		}
+		n, err := b.followAppend(ctx, w, upstream, awsBucket, objectName, head.ETag, offset, size)
+		offset += n
+		changed = changed || n > 0
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[FOLLOW ERROR] %s/%s: %v", bucketName, objectName, err)
+			}
+			continue
+		}
+		_ = rc.Flush()
+	}
+}
+
+// followAppend writes the bytes of an object from offset to size, and
+// returns how many it wrote. A GET of a newer object than the HEAD saw
+// fails its If-Match and is picked up on the next poll.
+func (b *LazyBackend) followAppend(ctx context.Context, w io.Writer, upstream upstreamClient, awsBucket, objectName string, etag *string, offset, size int64) (int64, error) {
+	out, err := upstream.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(awsBucket),
+		Key:     aws.String(objectName),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, size-1)),
+		IfMatch: etag,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer out.Body.Close()
+	return io.Copy(w, out.Body)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestFollowGuard(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putString(t, awsBackend, "build.log", "line 1\n")
+	lazyBackend.SetFollowInterval(10 * time.Millisecond)
+	server := httptest.NewServer(lazyBackend.followGuard(http.NotFoundHandler()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/test-bucket/build.log?s3lazy-follow=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	first := make([]byte, len("line 1\n"))
+	if _, err := io.ReadFull(resp.Body, first); err != nil || string(first) != "line 1\n" {
+		t.Fatalf("first read = %q, %v", first, err)
+	}
+
+	// The job appends by replacing the object with a longer one, then
+	// deletes it, which ends the follow
+	putString(t, awsBackend, "build.log", "line 1\nline 2\n")
+	appended := make([]byte, len("line 2\n"))
+	if _, err := io.ReadFull(resp.Body, appended); err != nil || string(appended) != "line 2\n" {
+		t.Fatalf("appended read = %q, %v", appended, err)
+	}
+	if _, err := awsBackend.DeleteObject("test-bucket", "build.log"); err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := io.ReadAll(resp.Body); err != nil || len(rest) != 0 {
+		t.Errorf("rest = %q, %v; want the response to end", rest, err)
+	}
+}
+
+func TestParseFollow(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"true", 0, true},
+		{"10m", 10 * time.Minute, true},
+		{"false", 0, false},
+		{"-1s", 0, false},
+		{"soon", 0, false},
+	} {
+		got, err := parseFollow(tt.value)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseFollow(%q) = %v, %v", tt.value, got, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// expireAtOnce is the TTL of objects upstream says must not be reused
+// without revalidation. A TTL of 0 would mean the bucket's TTL instead.
+const expireAtOnce = time.Nanosecond
+
+// headerTTLPolicy derives cached objects' TTLs from the Cache-Control and
+// Expires headers upstream returns with them, clamped to [min, max].
+type headerTTLPolicy struct {
+	enabled  bool
+	min, max time.Duration // max 0 is unbounded
+}
+
+// SetHeaderTTL makes objects fetched from upstream expire when their
+// Cache-Control or Expires header says, kept within minTTL and maxTTL (0 for
+// no upper bound). Objects without either header, or with a TTL from a tag
+// rule, keep the TTL they would otherwise have.
+func (b *LazyBackend) SetHeaderTTL(enabled bool, minTTL, maxTTL time.Duration) {
+	b.headerTTL = headerTTLPolicy{enabled: enabled, min: minTTL, max: maxTTL}
+}
+
+// fetchedTTL returns the TTL to record for an object just fetched or
+// revalidated: the tag rule's if it sets one, else the one its headers give,
+// else 0 for the bucket's.
+func (b *LazyBackend) fetchedTTL(rule TagRule, cacheControl, expires string) time.Duration {
+	if rule.TTL > 0 || !b.headerTTL.enabled {
+		return rule.TTL
+	}
+	ttl, ok := headerTTL(cacheControl, expires, time.Now())
+	if !ok {
+		return 0
+	}
+	ttl = max(ttl, b.headerTTL.min)
+	if b.headerTTL.max > 0 {
+		ttl = min(ttl, b.headerTTL.max)
+	}
+	return max(ttl, expireAtOnce)
+}
+
+// headerTTL works out how long a response may be cached from its
+// Cache-Control and Expires headers, the way a shared cache would:
+// s-maxage, then max-age, then Expires. no-cache and no-store allow no
+// reuse at all, as does an Expires that is unparseable or already past.
+// ok is false if the headers say nothing about it.
+func headerTTL(cacheControl, expires string, now time.Time) (ttl time.Duration, ok bool) {
+	maxAge, sMaxAge := -1, -1
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0, true
+		case "max-age":
+			maxAge = parseDeltaSeconds(value)
+		case "s-maxage":
+			sMaxAge = parseDeltaSeconds(value)
+		}
+	}
+	switch {
+	case sMaxAge >= 0:
+		return time.Duration(sMaxAge) * time.Second, true
+	case maxAge >= 0:
+		return time.Duration(maxAge) * time.Second, true
+	case expires == "":
+		return 0, false
+	}
+	at, err := http.ParseTime(expires)
+	if err != nil {
+		return 0, true
+	}
+	return max(at.Sub(now), 0), true
+}
+
+// parseDeltaSeconds parses a Cache-Control age, returning -1 if it is
+// malformed.
+func parseDeltaSeconds(v string) int {
+	n, err := strconv.Atoi(strings.Trim(v, `"`))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// expiresHeader returns the Expires header of a GetObject response as sent.
+func expiresHeader(awsObj *s3.GetObjectOutput) string {
+	if awsObj.ExpiresString != nil {
+		return *awsObj.ExpiresString
+	}
+	if awsObj.Expires != nil {
+		return awsObj.Expires.UTC().Format(http.TimeFormat)
+	}
+	return ""
+}
+
+// notModifiedHeaders returns the headers of a 304 response to a conditional
+// GetObject, which S3 sends with the object's current Cache-Control and
+// Expires.
+func notModifiedHeaders(err error) http.Header {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.Response != nil && respErr.Response.Response != nil {
+		return respErr.Response.Header
+	}
+	return http.Header{}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestHeaderTTL(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		cacheControl, expires string
+		want                  time.Duration
+		ok                    bool
+	}{
+		{"", "", 0, false},
+		{"public", "", 0, false},
+		{"max-age=300", "", 5 * time.Minute, true},
+		{"public, max-age=300, s-maxage=60", "", time.Minute, true},
+		{"max-age=300", "Fri, 16 Oct 2026 10:00:00 GMT", 5 * time.Minute, true},
+		{"", "Fri, 16 Oct 2026 10:00:00 GMT", time.Hour, true},
+		{"", "Fri, 16 Oct 2026 08:00:00 GMT", 0, true},
+		{"", "0", 0, true},
+		{"no-cache", "", 0, true},
+		{"max-age=300, no-store", "", 0, true},
+		{"max-age=soon", "", 0, false},
+	} {
+		got, ok := headerTTL(tt.cacheControl, tt.expires, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("headerTTL(%q, %q) = %v, %v; want %v, %v", tt.cacheControl, tt.expires, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLazyBackend_HeaderTTL(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, cacheControl := range map[string]string{
+		"short.txt":    "max-age=1",
+		"long.txt":     "max-age=86400",
+		"no-cache.txt": "no-cache",
+		"plain.txt":    "",
+	} {
+		meta := map[string]string{}
+		if cacheControl != "" {
+			meta["Cache-Control"] = cacheControl
+		}
+		if _, err := awsBackend.PutObject("test-bucket", key, meta, strings.NewReader("data"), 4, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lazyBackend.SetCacheTTL(time.Hour, nil)
+	lazyBackend.SetHeaderTTL(true, time.Minute, 12*time.Hour)
+
+	for key, want := range map[string]time.Duration{
+		"short.txt":    time.Minute,    // raised to the minimum
+		"long.txt":     12 * time.Hour, // lowered to the maximum
+		"no-cache.txt": time.Minute,    // raised to the minimum
+		"plain.txt":    time.Hour,      // the bucket's
+	} {
+		obj, err := lazyBackend.GetObjectContext(context.Background(), "test-bucket", key, nil)
+		if err != nil {
+			t.Fatalf("GET %s: %v", key, err)
+		}
+		obj.Contents.Close()
+		entry, ok := lazyBackend.index.lookup("test-bucket", key)
+		if !ok {
+			t.Fatalf("%s not indexed", key)
+		}
+		if ttl := lazyBackend.entryTTL(entry); ttl != want {
+			t.Errorf("%s: TTL = %v, want %v", key, ttl, want)
+		}
+	}
+
+	// Without a minimum, no-cache objects expire at once
+	lazyBackend.SetHeaderTTL(true, 0, 0)
+	if ttl := lazyBackend.fetchedTTL(TagRule{}, "no-cache", ""); ttl != expireAtOnce {
+		t.Errorf("no-cache TTL = %v, want %v", ttl, expireAtOnce)
+	}
+	// A tag rule's TTL wins over the headers
+	if ttl := lazyBackend.fetchedTTL(TagRule{TTL: time.Hour}, "max-age=60", ""); ttl != time.Hour {
+		t.Errorf("tag rule TTL = %v, want 1h", ttl)
+	}
+}
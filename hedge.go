@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultHedgeMaxBytes is the largest object a hedged GET fetches unless
+// configured otherwise.
+const defaultHedgeMaxBytes = 1 << 20
+
+// hedgedClient cuts the tail latency of small GETs: when upstream hasn't
+// answered a GET within delay, the same request is sent again, and whichever
+// answers first is used while the other is cancelled.
+//
+// The size of an object isn't known before it is fetched, so the hedge of a
+// whole-object GET asks for its first maxBytes only. It wins only if that
+// turns out to be the whole object; for larger objects the first request is
+// left to finish. Ranged GETs are hedged as they are if the range is at most
+// maxBytes long. A hedge that fails is ignored.
+type hedgedClient struct {
+	upstreamLister
+	delay    time.Duration
+	maxBytes int64
+}
+
+func newHedgedClient(client upstreamLister, delay time.Duration, maxBytes int64) *hedgedClient {
+	return &hedgedClient{upstreamLister: client, delay: delay, maxBytes: maxBytes}
+}
+
+// hedgeResult is the answer to one of the two requests of a hedged GET.
+type hedgeResult struct {
+	out    *s3.GetObjectOutput
+	err    error
+	cancel context.CancelFunc
+	hedge  bool
+}
+
+func (h *hedgedClient) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	hedge, whole, ok := h.hedgeInput(params)
+	if !ok {
+		return h.upstreamLister.GetObject(ctx, params, optFns...)
+	}
+
+	results := make(chan hedgeResult, 2)
+	running := make(map[bool]context.CancelFunc, 2) // by whether it is the hedge
+	send := func(input *s3.GetObjectInput, isHedge bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		running[isHedge] = cancel
+		go func() {
+			out, err := h.upstreamLister.GetObject(ctx, input, optFns...)
+			results <- hedgeResult{out: out, err: err, cancel: cancel, hedge: isHedge}
+		}()
+	}
+	// drop cancels the requests still running, and closes their bodies if
+	// they answer anyway
+	drop := func() {
+		for _, cancel := range running {
+			cancel()
+		}
+		go func(n int) {
+			for range n {
+				discardHedge(<-results)
+			}
+		}(len(running))
+	}
+	send(params, false)
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			send(hedge, true)
+			continue
+		case r := <-results:
+			delete(running, r.hedge)
+			if r.hedge && !h.complete(r, whole) {
+				// Leave it to the first request
+				discardHedge(r)
+				continue
+			}
+			drop()
+			if r.err != nil {
+				r.cancel()
+				return nil, r.err
+			}
+			if r.hedge {
+				log.Printf("[HEDGE] %s/%s: the hedged request answered first", aws.ToString(params.Bucket), aws.ToString(params.Key))
+			}
+			r.out.Body = &cancelOnClose{ReadCloser: r.out.Body, cancel: r.cancel}
+			return r.out, nil
+		case <-ctx.Done():
+			drop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// hedgeInput returns the request to hedge a GET with, and whether it asks
+// for the start of a whole object. GETs of ranges longer than maxBytes
+// aren't hedged, nor are Object Lambda GETs, which transform whole objects.
+func (h *hedgedClient) hedgeInput(params *s3.GetObjectInput) (*s3.GetObjectInput, bool, bool) {
+	if isObjectLambdaARN(aws.ToString(params.Bucket)) {
+		return nil, false, false
+	}
+	hedge := *params
+	if params.Range == nil {
+		hedge.Range = aws.String(fmt.Sprintf("bytes=0-%d", h.maxBytes-1))
+		return &hedge, true, true
+	}
+	n, ok := rangeLength(aws.ToString(params.Range))
+	return &hedge, false, ok && n <= h.maxBytes
+}
+
+// rangeLength returns the length of a bounded HTTP byte range.
+func rangeLength(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, false
+	}
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok || to == "" {
+		return 0, false
+	}
+	end, err := strconv.ParseInt(to, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if from == "" {
+		// The last end bytes
+		return end, true
+	}
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || end < start {
+		return 0, false
+	}
+	return end - start + 1, true
+}
+
+// complete reports whether a hedge's answer can stand in for the request it
+// hedged. The start of a whole object is turned into the whole object if
+// that is all there is.
+func (h *hedgedClient) complete(r hedgeResult, whole bool) bool {
+	if r.err != nil {
+		return false
+	}
+	if !whole {
+		return true
+	}
+	if r.out.ContentRange == nil {
+		// Upstream ignored the range and sent the whole object
+		return true
+	}
+	rng, total, ok := parseContentRange(aws.ToString(r.out.ContentRange))
+	if !ok || rng.Start != 0 || rng.Length != total {
+		return false
+	}
+	r.out.ContentRange = nil
+	r.out.ContentLength = aws.Int64(total)
+	return true
+}
+
+// discardHedge cancels a request that lost and closes its body.
+func discardHedge(r hedgeResult) {
+	r.cancel()
+	if r.out != nil && r.out.Body != nil {
+		r.out.Body.Close()
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// slowFirstUpstream holds its first whole-object GET back for slow, or until
+// it is cancelled, and records the range of every GET.
+type slowFirstUpstream struct {
+	*s3.Client
+	slow time.Duration
+
+	mu        sync.Mutex
+	ranges    []string
+	cancelled bool
+}
+
+func (u *slowFirstUpstream) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	u.mu.Lock()
+	first := params.Range == nil && !slices.Contains(u.ranges, "")
+	u.ranges = append(u.ranges, aws.ToString(params.Range))
+	u.mu.Unlock()
+	if first {
+		select {
+		case <-time.After(u.slow):
+		case <-ctx.Done():
+			u.mu.Lock()
+			u.cancelled = true
+			u.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	return u.Client.GetObject(ctx, params, optFns...)
+}
+
+func TestHedgedClient(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		content    string
+		slow       time.Duration
+		wantHedged bool
+	}{
+		// The hedge answers with the whole object and the stalled GET is cancelled
+		{name: "small object", content: "small", slow: time.Minute, wantHedged: true},
+		// The hedge only gets the start of the object, so the first GET is waited for
+		{name: "large object", content: "larger than the hedge", slow: 100 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+			defer awsServer.Close()
+			for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+				if err := b.CreateBucket("test-bucket"); err != nil {
+					t.Fatalf("Failed to create bucket: %v", err)
+				}
+			}
+			putString(t, awsBackend, "object.txt", tc.content)
+			upstream := &slowFirstUpstream{Client: lazyBackend.awsClient.(*s3.Client), slow: tc.slow}
+			lazyBackend.awsClient = newHedgedClient(upstream, 10*time.Millisecond, 8)
+
+			start := time.Now()
+			if _, got := readObject(t, lazyBackend, "object.txt", nil); got != tc.content {
+				t.Errorf("content = %q, want %q", got, tc.content)
+			}
+			if tc.wantHedged && time.Since(start) > 10*time.Second {
+				t.Errorf("GET took %v, want the hedge to answer", time.Since(start))
+			}
+
+			// The loser is cancelled in the background
+			deadline := time.Now().Add(5 * time.Second)
+			upstream.mu.Lock()
+			for upstream.cancelled != tc.wantHedged && time.Now().Before(deadline) {
+				upstream.mu.Unlock()
+				time.Sleep(time.Millisecond)
+				upstream.mu.Lock()
+			}
+			defer upstream.mu.Unlock()
+			// The hedge can reach upstream first if the GET it hedges is slow to start
+			slices.Sort(upstream.ranges)
+			if len(upstream.ranges) != 2 || upstream.ranges[0] != "" || upstream.ranges[1] != "bytes=0-7" {
+				t.Errorf("upstream ranges = %q, want a whole GET hedged with bytes=0-7", upstream.ranges)
+			}
+			if upstream.cancelled != tc.wantHedged {
+				t.Errorf("first GET cancelled = %v, want %v", upstream.cancelled, tc.wantHedged)
+			}
+			if _, err := localBackend.HeadObject("test-bucket", "object.txt"); err != nil {
+				t.Errorf("object should be cached: %v", err)
+			}
+		})
+	}
+}
+
+func TestRangeLength(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   int64
+		ok     bool
+	}{
+		{"bytes=0-99", 100, true},
+		{"bytes=10-10", 1, true},
+		{"bytes=-500", 500, true},
+		{"bytes=100-", 0, false},
+		{"bytes=0-1,5-9", 0, false},
+		{"bytes=9-0", 0, false},
+		{"items=0-1", 0, false},
+	} {
+		n, ok := rangeLength(tc.header)
+		if n != tc.want || ok != tc.ok {
+			t.Errorf("rangeLength(%q) = %d, %v; want %d, %v", tc.header, n, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// The whole of a short object stands in for it, however upstream answered
+func TestHedgedClient_Complete(t *testing.T) {
+	h := newHedgedClient(nil, time.Second, 8)
+	whole := hedgeResult{out: &s3.GetObjectOutput{ContentRange: aws.String("bytes 0-4/5"), ContentLength: aws.Int64(5)}}
+	if !h.complete(whole, true) || whole.out.ContentRange != nil || aws.ToInt64(whole.out.ContentLength) != 5 {
+		t.Errorf("a hedge covering the object should become a full response, got %+v", whole.out)
+	}
+	part := hedgeResult{out: &s3.GetObjectOutput{ContentRange: aws.String("bytes 0-7/100")}}
+	if h.complete(part, true) {
+		t.Error("the start of a larger object shouldn't stand in for it")
+	}
+	if !h.complete(part, false) {
+		t.Error("a hedged range should stand in for the same range")
+	}
+	if h.complete(hedgeResult{err: context.Canceled}, false) {
+		t.Error("a failed hedge shouldn't stand in for anything")
+	}
+	if aws.ToString(part.out.ContentRange) != "bytes 0-7/100" {
+		t.Error("a partial hedge should be left as it is")
+	}
+}
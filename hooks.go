@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheEvent describes an object entering or leaving the cache, for hooks
+// that mirror or log the cache's lifecycle.
+type CacheEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"` // "fetched", "evicted" or "purged"
+	Bucket string    `json:"bucket"`
+	Key    string    `json:"key"`
+	Size   int64     `json:"size"`
+}
+
+// cacheHooks are the callbacks registered for cache events.
+type cacheHooks struct {
+	mu  sync.RWMutex
+	fns []func(CacheEvent)
+}
+
+// OnCacheEvent registers fn to be called whenever an object is fetched into
+// the cache, evicted or purged. fn runs synchronously while the key is
+// locked, so it must return quickly and hand slow work to another goroutine.
+func (b *LazyBackend) OnCacheEvent(fn func(CacheEvent)) {
+	b.hooks.mu.Lock()
+	defer b.hooks.mu.Unlock()
+	b.hooks.fns = append(b.hooks.fns, fn)
+}
+
+// OnAudit registers fn to be called with every toggle change recorded in
+// the audit log. Like cache event hooks, fn must return quickly.
+func (b *LazyBackend) OnAudit(fn func(auditEntry)) {
+	b.toggles.mu.Lock()
+	defer b.toggles.mu.Unlock()
+	b.toggles.onAudit = append(b.toggles.onAudit, fn)
+}
+
+// notify calls the registered hooks for a cache event.
+func (b *LazyBackend) notify(event, bucketName, objectName string, size int64) {
+	b.hooks.mu.RLock()
+	defer b.hooks.mu.RUnlock()
+	if len(b.hooks.fns) == 0 {
+		return
+	}
+	e := CacheEvent{Time: time.Now().UTC(), Event: event, Bucket: bucketName, Key: objectName, Size: size}
+	for _, fn := range b.hooks.fns {
+		fn(e)
+	}
+}
+
+// webhookQueueSize bounds the events waiting to be posted to a webhook;
+// further events are dropped until it catches up.
+const webhookQueueSize = 1000
+
+// webhookTimeout bounds each webhook request.
+const webhookTimeout = 10 * time.Second
+
+// webhook posts cache events as JSON to a URL, one request per event, from
+// a queue so a slow receiver never holds up requests.
+type webhook struct {
+	url     string
+	client  *http.Client
+	queue   chan CacheEvent
+	dropped atomic.Int64
+}
+
+func newWebhook(url string) *webhook {
+	return &webhook{url: url, client: &http.Client{Timeout: webhookTimeout}, queue: make(chan CacheEvent, webhookQueueSize)}
+}
+
+// send queues an event to be posted, dropping it if the queue is full.
+func (w *webhook) send(e CacheEvent) {
+	select {
+	case w.queue <- e:
+	default:
+		if n := w.dropped.Add(1); n%100 == 1 {
+			log.Printf("[WEBHOOK] queue full: dropped %d event(s) so far", n)
+		}
+	}
+}
+
+// run posts queued events until ctx is done.
+func (w *webhook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-w.queue:
+			if err := w.post(ctx, e); err != nil {
+				log.Printf("[WEBHOOK ERROR] %s %s/%s: %v", e.Event, e.Bucket, e.Key, err)
+			}
+		}
+	}
+}
+
+// post sends one event to the webhook.
+func (w *webhook) post(ctx context.Context, e CacheEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_CacheEventHooks(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	var events []CacheEvent
+	lazyBackend.OnCacheEvent(func(e CacheEvent) { events = append(events, e) })
+
+	putString(t, awsBackend, "a.txt", "aaaa")
+	putString(t, awsBackend, "b.txt", "bbbbbb")
+	readObject(t, lazyBackend, "a.txt", nil)
+	readObject(t, lazyBackend, "b.txt", nil)
+	if _, err := lazyBackend.Purge("test-bucket", "b.txt"); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	lazyBackend.SetCacheMaxBytes(1)
+
+	want := []CacheEvent{
+		{Event: eventFetched, Key: "a.txt", Size: 4},
+		{Event: eventFetched, Key: "b.txt", Size: 6},
+		{Event: eventPurged, Key: "b.txt", Size: 6},
+		{Event: eventEvicted, Key: "a.txt", Size: 4},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %+v", events, want)
+	}
+	for i, w := range want {
+		got := events[i]
+		if got.Event != w.Event || got.Bucket != "test-bucket" || got.Key != w.Key || got.Size != w.Size || got.Time.IsZero() {
+			t.Errorf("event %d = %+v, want %s of test-bucket/%s (%d bytes)", i, got, w.Event, w.Key, w.Size)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var received []CacheEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e CacheEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad event", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, e)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	hook := newWebhook(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hook.run(ctx)
+	hook.send(CacheEvent{Event: eventFetched, Bucket: "b", Key: "k1"})
+	hook.send(CacheEvent{Event: eventEvicted, Bucket: "b", Key: "k2"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d events, want 2", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if received[0].Key != "k1" || received[1].Event != eventEvicted {
+		t.Errorf("received = %+v, want k1 fetched then k2 evicted in order", received)
+	}
+
+	// A full queue drops events instead of blocking
+	full := &webhook{queue: make(chan CacheEvent, 1)}
+	full.send(CacheEvent{})
+	full.send(CacheEvent{})
+	if full.dropped.Load() != 1 {
+		t.Errorf("dropped = %d, want 1", full.dropped.Load())
+	}
+}
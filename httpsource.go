@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// httpSourceTimeout bounds how long a URL source request may wait for headers.
+const httpSourceTimeout = 30 * time.Second
+
+// httpSource fetches objects over plain HTTP(S) from a URL template instead of
+// the S3 API. This covers CDN-fronted buckets and pre-signed or public URLs
+// where the user has no AWS credentials.
+//
+// The template may contain {bucket} and {key} placeholders, e.g.
+// "https://cdn.example.com/{key}?Policy=...&Signature=...". A template without
+// {key} is a base URL and keys are appended to its path, which suits artifact
+// registries and dataset mirrors.
+type httpSource struct {
+	template string
+	client   *http.Client
+
+	// revalidate enables a HEAD-based freshness check on every cache hit
+	revalidate bool
+}
+
+// newHTTPSource validates a URL template and creates a source for it.
+func newHTTPSource(template string) (*httpSource, error) {
+	if !strings.Contains(template, "{key}") {
+		template = baseURLTemplate(template)
+	}
+	u, err := url.Parse(strings.NewReplacer("{key}", "k", "{bucket}", "b").Replace(template))
+	if err != nil {
+		return nil, fmt.Errorf("invalid url template %q: %w", template, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("url template %q must use http or https", template)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = httpSourceTimeout
+	return &httpSource{template: template, client: &http.Client{Transport: transport}}, nil
+}
+
+// baseURLTemplate turns a base URL into a template that appends the key to
+// the path, keeping any query string intact.
+func baseURLTemplate(base string) string {
+	path, query, hasQuery := strings.Cut(base, "?")
+	template := strings.TrimSuffix(path, "/") + "/{key}"
+	if hasQuery {
+		template += "?" + query
+	}
+	return template
+}
+
+// url expands the template for a bucket/key. Keys are path-escaped per segment
+// so slashes keep their meaning.
+func (s *httpSource) url(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.NewReplacer(
+		"{bucket}", url.PathEscape(bucket),
+		"{key}", strings.Join(segments, "/"),
+	).Replace(s.template)
+}
+
+func (s *httpSource) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url(aws.ToString(params.Bucket), aws.ToString(params.Key)), nil)
+	if err != nil {
+		return nil, err
+	}
+	if params.Range != nil {
+		req.Header.Set("Range", *params.Range)
+	}
+	if params.IfMatch != nil {
+		req.Header.Set("If-Match", *params.IfMatch)
+	}
+	if params.IfNoneMatch != nil {
+		req.Header.Set("If-None-Match", *params.IfNoneMatch)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, httpStatusError(resp.StatusCode)
+	}
+
+	out := &s3.GetObjectOutput{Body: resp.Body}
+	if resp.ContentLength >= 0 {
+		out.ContentLength = aws.Int64(resp.ContentLength)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		out.ContentType = aws.String(ct)
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		out.ContentRange = aws.String(cr)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		out.ETag = aws.String(etag)
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		out.LastModified = aws.Time(lm)
+	}
+	return out, nil
+}
+
+func (s *httpSource) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url(aws.ToString(params.Bucket), aws.ToString(params.Key)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpStatusError(resp.StatusCode)
+	}
+
+	out := &s3.HeadObjectOutput{}
+	if resp.ContentLength >= 0 {
+		out.ContentLength = aws.Int64(resp.ContentLength)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		out.ContentType = aws.String(ct)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		out.ETag = aws.String(etag)
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		out.LastModified = aws.Time(lm)
+	}
+	return out, nil
+}
+
+// changed issues a HEAD for a cached key and reports whether the upstream
+// copy differs from it, comparing the ETag it was cached with, since most
+// HTTP servers' ETags can't be compared with the local MD5. Upstream errors
+// are treated as unchanged so an unreachable mirror doesn't turn cache hits
+// into failures.
+func (s *httpSource) changed(ctx context.Context, bucket, key string, cached *gofakes3.Object, etag string) bool {
+	head, err := s.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		log.Printf("[REVALIDATE ERROR] %s/%s: %v", bucket, key, err)
+		return false
+	}
+
+	if etag != "" && head.ETag != nil {
+		return etag != *head.ETag
+	}
+	if head.LastModified != nil {
+		if cachedAt, err := http.ParseTime(cached.Metadata["Last-Modified"]); err == nil {
+			return head.LastModified.After(cachedAt)
+		}
+	}
+	return head.ContentLength != nil && *head.ContentLength != cached.Size
+}
+
+// httpStatusError maps an HTTP status from a URL source onto an S3-style API
+// error so callers can treat both kinds of upstream the same way.
+func httpStatusError(status int) error {
+	code := fmt.Sprintf("HTTP%d", status)
+	switch status {
+	case http.StatusNotFound:
+		code = "NoSuchKey"
+	case http.StatusForbidden:
+		// Expired pre-signed URLs and private CDN paths both land here
+		code = "AccessDenied"
+	case http.StatusNotModified:
+		code = "NotModified"
+	}
+	return &smithy.GenericAPIError{Code: code, Message: http.StatusText(status)}
+}
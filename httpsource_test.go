@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestNewHTTPSource_Validation(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{"valid https", "https://cdn.example.com/{key}", false},
+		{"valid with bucket and query", "http://mirror.local/{bucket}/{key}?sig=abc", false},
+		{"base url without key placeholder", "https://mirror.example.com/datasets", false},
+		{"unsupported scheme", "ftp://example.com/{key}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newHTTPSource(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newHTTPSource(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPSource_URL(t *testing.T) {
+	src, err := newHTTPSource("https://cdn.example.com/{bucket}/{key}?sig=abc")
+	if err != nil {
+		t.Fatalf("newHTTPSource failed: %v", err)
+	}
+
+	got := src.url("my-bucket", "path/to/a file.txt")
+	want := "https://cdn.example.com/my-bucket/path/to/a%20file.txt?sig=abc"
+	if got != want {
+		t.Errorf("url() = %q, want %q", got, want)
+	}
+}
+
+func TestHTTPSource_BaseURL(t *testing.T) {
+	tests := []struct {
+		base string
+		want string
+	}{
+		{"https://mirror.example.com/datasets", "https://mirror.example.com/datasets/v1/train.parquet"},
+		{"https://mirror.example.com/datasets/", "https://mirror.example.com/datasets/v1/train.parquet"},
+		{"https://mirror.example.com/datasets?token=abc", "https://mirror.example.com/datasets/v1/train.parquet?token=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			src, err := newHTTPSource(tt.base)
+			if err != nil {
+				t.Fatalf("newHTTPSource failed: %v", err)
+			}
+			if got := src.url("bucket", "v1/train.parquet"); got != tt.want {
+				t.Errorf("url() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLazyBackend_URLSource_Revalidate(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	version := "v1"
+	var gets int
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+version+`"`)
+		if r.Method == http.MethodGet {
+			gets++
+			_, _ = w.Write([]byte("content " + version))
+		}
+	}))
+	defer mirror.Close()
+
+	if err := lazyBackend.SetURLSources(map[string]string{"mirror": mirror.URL + "/files"}, true); err != nil {
+		t.Fatalf("SetURLSources failed: %v", err)
+	}
+	if err := localBackend.CreateBucket("mirror"); err != nil {
+		t.Fatalf("Failed to create local bucket: %v", err)
+	}
+
+	read := func() string {
+		t.Helper()
+		obj, err := lazyBackend.GetObject("mirror", "data.txt", nil)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		defer obj.Contents.Close()
+		data, _ := io.ReadAll(obj.Contents)
+		return string(data)
+	}
+
+	if got := read(); got != "content v1" {
+		t.Errorf("first read = %q, want %q", got, "content v1")
+	}
+
+	// Unchanged upstream: served from cache without another GET
+	if got := read(); got != "content v1" {
+		t.Errorf("second read = %q, want %q", got, "content v1")
+	}
+	if gets != 1 {
+		t.Errorf("upstream GETs = %d, want 1", gets)
+	}
+
+	// Changed upstream: the HEAD check triggers a re-fetch
+	version = "v2"
+	if got := read(); got != "content v2" {
+		t.Errorf("read after change = %q, want %q", got, "content v2")
+	}
+	if gets != 2 {
+		t.Errorf("upstream GETs = %d, want 2", gets)
+	}
+
+	// The ETag compared is the one the index holds, so a source set up
+	// again, as after a restart, still notices a change of the same size
+	if err := lazyBackend.SetURLSources(map[string]string{"mirror": mirror.URL + "/files"}, true); err != nil {
+		t.Fatalf("SetURLSources failed: %v", err)
+	}
+	version = "v3"
+	if got := read(); got != "content v3" {
+		t.Errorf("read after change = %q, want %q", got, "content v3")
+	}
+}
+
+func TestLazyBackend_URLSource_CacheMiss(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	var requests int
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/data/report.csv" || r.URL.Query().Get("token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		_, _ = w.Write([]byte("a,b,c"))
+	}))
+	defer cdn.Close()
+
+	if err := lazyBackend.SetURLSources(map[string]string{
+		"cdn-bucket": cdn.URL + "/data/{key}?token=secret",
+	}, false); err != nil {
+		t.Fatalf("SetURLSources failed: %v", err)
+	}
+	if err := localBackend.CreateBucket("cdn-bucket"); err != nil {
+		t.Fatalf("Failed to create local bucket: %v", err)
+	}
+
+	obj, err := lazyBackend.GetObject("cdn-bucket", "report.csv", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	data, _ := io.ReadAll(obj.Contents)
+	obj.Contents.Close()
+
+	if string(data) != "a,b,c" {
+		t.Errorf("Content = %q, want %q", string(data), "a,b,c")
+	}
+	if obj.Metadata["Content-Type"] != "text/csv" {
+		t.Errorf("Content-Type = %q, want %q", obj.Metadata["Content-Type"], "text/csv")
+	}
+
+	// Second read is served from the cache
+	obj, err = lazyBackend.GetObject("cdn-bucket", "report.csv", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	obj.Contents.Close()
+	if requests != 1 {
+		t.Errorf("URL source requests = %d, want 1", requests)
+	}
+}
+
+func TestLazyBackend_URLSource_NotFound(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	cdn := httptest.NewServer(http.NotFoundHandler())
+	defer cdn.Close()
+
+	if err := lazyBackend.SetURLSources(map[string]string{"cdn-bucket": cdn.URL + "/{key}"}, false); err != nil {
+		t.Fatalf("SetURLSources failed: %v", err)
+	}
+	if err := localBackend.CreateBucket("cdn-bucket"); err != nil {
+		t.Fatalf("Failed to create local bucket: %v", err)
+	}
+
+	_, err := lazyBackend.GetObject("cdn-bucket", "missing.txt", nil)
+	if !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("Expected ErrNoSuchKey, got: %v", err)
+	}
+}
+
+func TestLazyBackend_URLSource_DoesNotAffectOtherBuckets(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if err := lazyBackend.SetURLSources(map[string]string{"cdn-bucket": "https://unused.invalid/{key}"}, false); err != nil {
+		t.Fatalf("SetURLSources failed: %v", err)
+	}
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create local bucket: %v", err)
+	}
+	if err := awsBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create AWS bucket: %v", err)
+	}
+	content := []byte("from s3")
+	if _, err := awsBackend.PutObject("test-bucket", "file.txt", nil,
+		bytes.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("Failed to put object in AWS: %v", err)
+	}
+
+	obj, err := lazyBackend.GetObject("test-bucket", "file.txt", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	obj.Contents.Close()
+}
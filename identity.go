@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// anonymousIdentity is reported for requests that carry no credentials.
+const anonymousIdentity = "anonymous"
+
+// maxTrackedIdentities caps the number of distinct identities counted, so
+// random access keys can't grow the stats without bound. Further identities
+// are folded into otherIdentity.
+const maxTrackedIdentities = 1000
+
+// otherIdentity aggregates identities seen after maxTrackedIdentities.
+const otherIdentity = "(other)"
+
+// identify returns who sent a request: the custom identity header if one is
+// configured and set, else the access key the request was signed with. The
+// signature itself isn't verified, so this is attribution, not
+// authentication.
+func (b *LazyBackend) identify(r *http.Request) string {
+	if b.identityHeader != "" {
+		if v := strings.TrimSpace(r.Header.Get(b.identityHeader)); v != "" {
+			return v
+		}
+	}
+	if key := accessKeyID(r); key != "" {
+		return key
+	}
+	return anonymousIdentity
+}
+
+// accessKeyID extracts the access key from a SigV4 or SigV2 Authorization
+// header, or from the query string of a presigned URL.
+func accessKeyID(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(auth, "AWS4-HMAC-SHA256 "):
+		// AWS4-HMAC-SHA256 Credential=AKID/20240101/us-east-1/s3/aws4_request, ...
+		for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ",") {
+			if cred, ok := strings.CutPrefix(strings.TrimSpace(part), "Credential="); ok {
+				key, _, _ := strings.Cut(cred, "/")
+				return key
+			}
+		}
+	case strings.HasPrefix(auth, "AWS "):
+		// AWS AKID:signature
+		key, _, _ := strings.Cut(strings.TrimPrefix(auth, "AWS "), ":")
+		return key
+	}
+	q := r.URL.Query()
+	if cred := q.Get("X-Amz-Credential"); cred != "" {
+		key, _, _ := strings.Cut(cred, "/")
+		return key
+	}
+	return q.Get("AWSAccessKeyId")
+}
+
+// identityCounters are the statistics kept per client identity.
+type identityCounters struct {
+	Requests  int64     `json:"requests"`
+	Errors    int64     `json:"errors"`
+	BytesSent int64     `json:"bytes_sent"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// identityReport is one row of the per-identity report.
+type identityReport struct {
+	Identity string `json:"identity"`
+	identityCounters
+}
+
+// identityStats counts S3 requests per client identity.
+type identityStats struct {
+	mu       sync.Mutex
+	counters map[string]*identityCounters
+}
+
+func newIdentityStats() *identityStats {
+	return &identityStats{counters: make(map[string]*identityCounters)}
+}
+
+// record counts one request and the response it got.
+func (s *identityStats) record(identity string, status int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[identity]
+	if !ok {
+		if len(s.counters) >= maxTrackedIdentities {
+			identity = otherIdentity
+			c, ok = s.counters[identity]
+		}
+		if !ok {
+			c = &identityCounters{}
+			s.counters[identity] = c
+		}
+	}
+	c.Requests++
+	if status >= 400 {
+		c.Errors++
+	}
+	c.BytesSent += bytes
+	c.LastSeen = time.Now()
+}
+
+// report returns every identity, busiest first.
+func (s *identityStats) report() []identityReport {
+	s.mu.Lock()
+	rows := make([]identityReport, 0, len(s.counters))
+	for id, c := range s.counters {
+		rows = append(rows, identityReport{Identity: id, identityCounters: *c})
+	}
+	s.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Requests != rows[j].Requests {
+			return rows[i].Requests > rows[j].Requests
+		}
+		return rows[i].Identity < rows[j].Identity
+	})
+	return rows
+}
+
+// identityLogger logs every S3 request with the identity that sent it and
+// counts it towards that identity's statistics.
+func (b *LazyBackend) identityLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := b.identify(r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+		b.identities.record(identity, rec.status, rec.bytes)
+		if b.slos != nil {
+			b.slos.observe(sloOperationsOf(r, rec.Header()), rec.status, elapsed)
+		}
+		b.toggles.infof("[REQUEST] %s %s %s %d %d bytes %s", identity, r.Method, r.URL.Path, rec.status, rec.bytes, elapsed.Round(time.Millisecond))
+	})
+}
+
+// statusRecorder captures the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes flushes through so streamed responses aren't buffered.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
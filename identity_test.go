@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLazyBackend_Identify(t *testing.T) {
+	lazyBackend := NewLazyBackend(nil, nil)
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   string
+	}{
+		{"unsigned", "/bucket/key", nil, anonymousIdentity},
+		{"sigv4", "/bucket/key", http.Header{"Authorization": {"AWS4-HMAC-SHA256 Credential=AKIDV4/20240101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc"}}, "AKIDV4"},
+		{"sigv2", "/bucket/key", http.Header{"Authorization": {"AWS AKIDV2:c2lnbmF0dXJl"}}, "AKIDV2"},
+		{"presigned v4", "/bucket/key?X-Amz-Credential=AKIDURL%2F20240101%2Fus-east-1%2Fs3%2Faws4_request", nil, "AKIDURL"},
+		{"presigned v2", "/bucket/key?AWSAccessKeyId=AKIDURL2&Signature=x", nil, "AKIDURL2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+		if got := lazyBackend.identify(r); got != tt.want {
+			t.Errorf("%s: identify = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	lazyBackend.SetIdentityHeader("X-Client-Id")
+	r := httptest.NewRequest(http.MethodGet, "/bucket/key", nil)
+	r.Header.Set("Authorization", "AWS AKIDV2:c2lnbmF0dXJl")
+	if got := lazyBackend.identify(r); got != "AKIDV2" {
+		t.Errorf("without the identity header, identify = %q, want the access key", got)
+	}
+	r.Header.Set("X-Client-Id", "build-agent")
+	if got := lazyBackend.identify(r); got != "build-agent" {
+		t.Errorf("identify = %q, want the identity header to win", got)
+	}
+}
+
+func TestLazyBackend_IdentityLogger(t *testing.T) {
+	lazyBackend := NewLazyBackend(nil, nil)
+	handler := lazyBackend.identityLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "nope", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+
+	for _, path := range []string{"/found", "/found", "/missing"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "AWS alice:sig")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/found", nil))
+
+	report := lazyBackend.identities.report()
+	if len(report) != 2 {
+		t.Fatalf("report has %d identities, want 2: %+v", len(report), report)
+	}
+	alice := report[0]
+	if alice.Identity != "alice" || alice.Requests != 3 || alice.Errors != 1 || alice.BytesSent != int64(2*len("hello")+len("nope\n")) {
+		t.Errorf("alice = %+v, want 3 requests, 1 error and the bytes sent", alice)
+	}
+	if report[1].Identity != anonymousIdentity || report[1].Requests != 1 {
+		t.Errorf("report[1] = %+v, want one anonymous request", report[1])
+	}
+}
+
+func TestIdentityStats_Cap(t *testing.T) {
+	s := newIdentityStats()
+	for i := 0; i < maxTrackedIdentities+5; i++ {
+		s.record(fmt.Sprintf("key-%d", i), http.StatusOK, 1)
+	}
+	report := s.report()
+	if len(report) != maxTrackedIdentities+1 {
+		t.Fatalf("report has %d identities, want %d", len(report), maxTrackedIdentities+1)
+	}
+	if report[0].Identity != otherIdentity || report[0].Requests != 5 {
+		t.Errorf("report[0] = %+v, want 5 requests folded into %s", report[0], otherIdentity)
+	}
+}
+
+func TestAdminHandler_IdentityAudit(t *testing.T) {
+	lazyBackend := NewLazyBackend(nil, nil)
+	lazyBackend.SetIdentityHeader("X-Client-Id")
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+
+	r := httptest.NewRequest(http.MethodPatch, "/admin/toggles", strings.NewReader(`{"offline": true}`))
+	r.Header.Set("X-Client-Id", "ops")
+	admin.ServeHTTP(httptest.NewRecorder(), r)
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/audit", nil))
+	var audit []auditEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &audit); err != nil {
+		t.Fatalf("decoding audit log: %v", err)
+	}
+	if len(audit) != 1 || audit[0].Identity != "ops" || audit[0].Remote == "" {
+		t.Errorf("audit = %+v, want the change attributed to ops and its address", audit)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/stats/identities", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /admin/stats/identities status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
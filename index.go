@@ -0,0 +1,362 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexSaveInterval is how often the cache index is persisted for the disk
+// backend, bounding what a crash can lose.
+const indexSaveInterval = 5 * time.Minute
+
+// cacheEntry describes one object fetched from upstream into the local cache.
+type cacheEntry struct {
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Size       int64     `json:"size"`
+	ETag       string    `json:"etag,omitempty"`
+	CachedAt   time.Time `json:"cached_at"`
+	LastAccess time.Time `json:"last_access"`
+
+	// Namespace is the upstream the object was fetched from
+	Namespace string `json:"namespace,omitempty"`
+
+	// Hits counts the cache hits on the entry, for eviction policies that
+	// weigh frequency
+	Hits int64 `json:"hits,omitempty"`
+
+	// TTL overrides the bucket's TTL when a tag rule set one
+	TTL time.Duration `json:"ttl,omitempty"`
+
+	// recentHits counts the cache hits since the entry was last fetched or
+	// revalidated, for refresh-ahead
+	recentHits int64
+}
+
+type entryKey struct {
+	bucket string
+	key    string
+}
+
+// cacheIndex tracks objects fetched from upstream, ordered by an eviction
+// policy, so the cache can be held to a size budget and buckets to object
+// counts. Objects written by clients are local data rather than cache and
+// are never tracked or evicted.
+type cacheIndex struct {
+	mu       sync.Mutex
+	maxBytes int64
+	total    int64
+	entries  map[entryKey]*cacheEntry
+	policy   evictionPolicy
+	now      func() time.Time
+
+	// newest is the entry most recently added or hit, which is never
+	// evicted
+	newest *cacheEntry
+
+	// Object count limits per bucket (0 is unlimited), and the number of
+	// entries in each bucket
+	maxObjects       int
+	bucketMaxObjects map[string]int
+	counts           map[string]int
+}
+
+// newCacheIndex creates an index with a budget of maxBytes (0 is unlimited).
+func newCacheIndex(maxBytes int64) *cacheIndex {
+	return &cacheIndex{
+		maxBytes: maxBytes,
+		entries:  make(map[entryKey]*cacheEntry),
+		policy:   newLRUPolicy(),
+		now:      time.Now,
+		counts:   make(map[string]int),
+	}
+}
+
+// objectLimitLocked returns the most entries a bucket may hold (0 is
+// unlimited).
+func (x *cacheIndex) objectLimitLocked(bucket string) int {
+	if limit, ok := x.bucketMaxObjects[bucket]; ok {
+		return limit
+	}
+	return x.maxObjects
+}
+
+// setPolicy switches to another eviction policy, handing it the entries
+// tracked so far in the order the current one would evict them.
+func (x *cacheIndex) setPolicy(policy evictionPolicy) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for e := range x.policy.victims() {
+		policy.added(e)
+	}
+	x.policy = policy
+}
+
+// add records a freshly cached object and its upstream ETag as the most
+// recently used entry. A previous entry for the same key is replaced in
+// place, keeping its hit count.
+func (x *cacheIndex) add(bucket, key string, size int64, etag string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.now()
+	if e, ok := x.entries[entryKey{bucket, key}]; ok {
+		x.total += size - e.Size
+		e.Size, e.ETag, e.CachedAt, e.LastAccess, e.TTL, e.recentHits = size, etag, now, now, 0, 0
+		x.policy.accessed(e)
+		x.newest = e
+		return
+	}
+	x.insertLocked(&cacheEntry{Bucket: bucket, Key: key, Size: size, ETag: etag, CachedAt: now, LastAccess: now})
+}
+
+// renew restarts an entry's TTL after upstream confirmed it is unchanged.
+func (x *cacheIndex) renew(bucket, key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.entries[entryKey{bucket, key}]; ok {
+		e.CachedAt = x.now()
+		e.recentHits = 0
+	}
+}
+
+// setTTL gives an entry its own TTL, overriding the bucket's (0 clears it).
+func (x *cacheIndex) setTTL(bucket, key string, ttl time.Duration) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.entries[entryKey{bucket, key}]; ok {
+		e.TTL = ttl
+	}
+}
+
+// setNamespace records the upstream an entry was fetched from.
+func (x *cacheIndex) setNamespace(bucket, key, namespace string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.entries[entryKey{bucket, key}]; ok {
+		e.Namespace = namespace
+	}
+}
+
+// etag returns the upstream ETag an entry was fetched with.
+func (x *cacheIndex) etag(bucket, key string) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.entries[entryKey{bucket, key}]; ok {
+		return e.ETag
+	}
+	return ""
+}
+
+func (x *cacheIndex) insertLocked(e *cacheEntry) {
+	x.policy.added(e)
+	x.newest = e
+	x.entries[entryKey{e.Bucket, e.Key}] = e
+	x.total += e.Size
+	x.counts[e.Bucket]++
+}
+
+// lookup returns a copy of an entry, if the key is tracked.
+func (x *cacheIndex) lookup(bucket, key string) (cacheEntry, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e, ok := x.entries[entryKey{bucket, key}]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	return *e, true
+}
+
+// touch marks an entry as used by a cache hit.
+func (x *cacheIndex) touch(bucket, key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e, ok := x.entries[entryKey{bucket, key}]; ok {
+		e.LastAccess = x.now()
+		e.Hits++
+		e.recentHits++
+		x.policy.accessed(e)
+		x.newest = e
+	}
+}
+
+// age returns how long ago an entry was fetched from upstream. Objects that
+// aren't tracked (client writes) report false.
+func (x *cacheIndex) age(bucket, key string) (time.Duration, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e, ok := x.entries[entryKey{bucket, key}]
+	if !ok {
+		return 0, false
+	}
+	return x.now().Sub(e.CachedAt), true
+}
+
+// remove drops an entry, reporting whether it was tracked.
+func (x *cacheIndex) remove(bucket, key string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.removeLocked(entryKey{bucket, key})
+}
+
+func (x *cacheIndex) removeLocked(k entryKey) bool {
+	e, ok := x.entries[k]
+	if !ok {
+		return false
+	}
+	x.policy.removed(e)
+	if x.newest == e {
+		x.newest = nil
+	}
+	delete(x.entries, k)
+	x.total -= e.Size
+	if x.counts[k.bucket]--; x.counts[k.bucket] == 0 {
+		delete(x.counts, k.bucket)
+	}
+	return true
+}
+
+// removeBucket drops every entry in a bucket.
+func (x *cacheIndex) removeBucket(bucket string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for k := range x.entries {
+		if k.bucket == bucket {
+			x.removeLocked(k)
+		}
+	}
+}
+
+// usage returns the number of tracked entries and their total size.
+func (x *cacheIndex) usage() (entries int, bytes int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.entries), x.total
+}
+
+// snapshot returns a copy of every entry, ordered by bucket and key.
+func (x *cacheIndex) snapshot() []cacheEntry {
+	x.mu.Lock()
+	entries := make([]cacheEntry, 0, len(x.entries))
+	for _, e := range x.entries {
+		entries = append(entries, *e)
+	}
+	x.mu.Unlock()
+	slices.SortFunc(entries, func(a, b cacheEntry) int {
+		return cmp.Or(strings.Compare(a.Bucket, b.Bucket), strings.Compare(a.Key, b.Key))
+	})
+	return entries
+}
+
+// evictionCandidates returns the entries that must go, in the policy's
+// order, to bring the cache back within budget and every bucket within its
+// object limit, skipping those keep reports true for. The entry most
+// recently added or hit is never a candidate, so an object larger than the
+// budget can still be served right after it is fetched.
+func (x *cacheIndex) evictionCandidates(keep func(bucket, key string) bool) []cacheEntry {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var excess int64
+	if x.maxBytes > 0 {
+		excess = x.total - x.maxBytes
+	}
+	overCount := make(map[string]int)
+	for bucket, count := range x.counts {
+		if limit := x.objectLimitLocked(bucket); limit > 0 && count > limit {
+			overCount[bucket] = count - limit
+		}
+	}
+	return x.candidatesLocked(excess, overCount, keep)
+}
+
+// trimCandidates returns the entries to evict first adding up to at least
+// excess bytes, skipping those keep reports true for and the newest entry.
+func (x *cacheIndex) trimCandidates(excess int64, keep func(bucket, key string) bool) []cacheEntry {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.candidatesLocked(excess, nil, keep)
+}
+
+// candidatesLocked returns the entries to evict first adding up to at least
+// excess bytes, plus those needed to take overCount entries out of each
+// bucket in it.
+func (x *cacheIndex) candidatesLocked(excess int64, overCount map[string]int, keep func(bucket, key string) bool) []cacheEntry {
+	var candidates []cacheEntry
+	for e := range x.policy.victims() {
+		if excess <= 0 && len(overCount) == 0 {
+			break
+		}
+		over := overCount[e.Bucket] > 0
+		if e == x.newest || excess <= 0 && !over || keep(e.Bucket, e.Key) {
+			continue
+		}
+		candidates = append(candidates, *e)
+		excess -= e.Size
+		if over {
+			if overCount[e.Bucket]--; overCount[e.Bucket] == 0 {
+				delete(overCount, e.Bucket)
+			}
+		}
+	}
+	return candidates
+}
+
+// save writes the index to path, in eviction order, via a temp file and
+// rename so a crash never leaves a truncated index behind.
+func (x *cacheIndex) save(path string) error {
+	x.mu.Lock()
+	entries := make([]*cacheEntry, 0, len(x.entries))
+	for e := range x.policy.victims() {
+		entries = append(entries, e)
+	}
+	data, err := json.Marshal(entries)
+	x.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load replaces the index contents with those saved at path. A missing file
+// leaves the index empty.
+func (x *cacheIndex) load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, e := range x.entries {
+		x.policy.removed(e)
+	}
+	x.entries = make(map[entryKey]*cacheEntry, len(entries))
+	x.newest = nil
+	x.total = 0
+	x.counts = make(map[string]int)
+	for _, e := range entries {
+		x.removeLocked(entryKey{e.Bucket, e.Key})
+		x.insertLocked(e)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestCacheIndex_EvictionCandidates(t *testing.T) {
+	x := newCacheIndex(250)
+	x.add("b", "old", 100, "")
+	x.add("b", "mid", 100, "")
+	x.add("b", "new", 100, "")
+
+	// A hit on "old" makes "mid" the least recently used entry
+	x.touch("b", "old")
+
+	candidates := x.evictionCandidates(keepNone)
+	if len(candidates) != 1 || candidates[0].Key != "mid" {
+		t.Fatalf("candidates = %+v, want [mid]", candidates)
+	}
+}
+
+func TestCacheIndex_NeverEvictsNewest(t *testing.T) {
+	x := newCacheIndex(10)
+	x.add("b", "huge", 100, "")
+
+	if candidates := x.evictionCandidates(keepNone); len(candidates) != 0 {
+		t.Errorf("candidates = %+v, want none", candidates)
+	}
+}
+
+func TestCacheIndex_ObjectLimits(t *testing.T) {
+	x := newCacheIndex(0)
+	x.maxObjects = 2
+	x.bucketMaxObjects = map[string]int{"big": 0}
+	for _, key := range []string{"a1", "a2", "a3", "a4"} {
+		x.add("a", key, 1, "")
+		x.add("big", key, 1, "")
+	}
+	x.touch("a", "a1")
+
+	// "a" is two over its limit and loses its least recently used entries;
+	// "big" is unlimited
+	candidates := x.evictionCandidates(keepNone)
+	var keys []string
+	for _, c := range candidates {
+		keys = append(keys, c.Bucket+"/"+c.Key)
+	}
+	if strings.Join(keys, ",") != "a/a2,a/a3" {
+		t.Errorf("candidates = %v, want [a/a2 a/a3]", keys)
+	}
+
+	x.remove("a", "a2")
+	x.remove("a", "a3")
+	if candidates := x.evictionCandidates(keepNone); len(candidates) != 0 {
+		t.Errorf("candidates = %+v, want none once within the limit", candidates)
+	}
+}
+
+func TestCacheIndex_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s3lazy", "index.json")
+
+	x := newCacheIndex(0)
+	x.add("b", "first", 10, "")
+	x.add("b", "second", 20, "")
+	if err := x.save(path); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	loaded := newCacheIndex(15)
+	if err := loaded.load(path); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if n, bytes := loaded.usage(); n != 2 || bytes != 30 {
+		t.Errorf("usage = %d entries, %d bytes, want 2, 30", n, bytes)
+	}
+	// LRU order survives the round trip
+	candidates := loaded.evictionCandidates(keepNone)
+	if len(candidates) != 1 || candidates[0].Key != "first" {
+		t.Errorf("candidates = %+v, want [first]", candidates)
+	}
+}
+
+func TestLazyBackend_EvictsLeastRecentlyUsed(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	content := bytes.Repeat([]byte("x"), 100)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := awsBackend.PutObject("test-bucket", key, nil,
+			bytes.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put object in AWS: %v", err)
+		}
+	}
+
+	// Client writes are local data and never evicted
+	if _, err := lazyBackend.PutObject("test-bucket", "local", nil,
+		bytes.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	lazyBackend.SetCacheMaxBytes(250)
+
+	get := func(key string) {
+		t.Helper()
+		obj, err := lazyBackend.GetObject("test-bucket", key, nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s) failed: %v", key, err)
+		}
+		obj.Contents.Close()
+	}
+	get("a")
+	get("b")
+	get("a") // hit: "b" is now least recently used
+	get("c") // over budget: evicts "b"
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "local": true} {
+		_, err := localBackend.HeadObject("test-bucket", key)
+		if cached := err == nil; cached != want {
+			t.Errorf("%s cached = %v, want %v", key, cached, want)
+		}
+	}
+	if _, bytes := lazyBackend.index.usage(); bytes != 200 {
+		t.Errorf("cached bytes = %d, want 200", bytes)
+	}
+}
+
+func TestLazyBackend_CacheMaxObjects(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := awsBackend.PutObject("test-bucket", key, nil, strings.NewReader("x"), 1, nil); err != nil {
+			t.Fatalf("Failed to put object in AWS: %v", err)
+		}
+	}
+	lazyBackend.SetCacheMaxObjects(0, map[string]int{"test-bucket": 2})
+
+	for _, key := range []string{"a", "b", "c"} {
+		obj, err := lazyBackend.GetObject("test-bucket", key, nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s) failed: %v", key, err)
+		}
+		obj.Contents.Close()
+	}
+	for key, want := range map[string]bool{"a": false, "b": true, "c": true} {
+		_, err := localBackend.HeadObject("test-bucket", key)
+		if cached := err == nil; cached != want {
+			t.Errorf("%s cached = %v, want %v", key, cached, want)
+		}
+	}
+}
+
+func keepNone(bucket, key string) bool { return false }
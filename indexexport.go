@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"iter"
+	"time"
+)
+
+// indexExportTable is the table the cache index is exported as, one row per
+// object cached from upstream.
+const indexExportTable = `CREATE TABLE objects (
+	bucket TEXT NOT NULL,
+	key TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	etag TEXT,
+	namespace TEXT,
+	cached_at TEXT,
+	last_access TEXT,
+	hits INTEGER NOT NULL,
+	ttl_seconds INTEGER,
+	pinned INTEGER NOT NULL
+)`
+
+// ExportIndex writes the cache index to w as a SQLite database, for
+// analysing large caches offline with standard tools. Times are UTC, in the
+// format SQLite's date functions take.
+func (b *LazyBackend) ExportIndex(w io.WriterAt) error {
+	entries := b.index.snapshot()
+	rows := func(yield func([]any) bool) {
+		for _, e := range entries {
+			var ttl any
+			if e.TTL > 0 {
+				ttl = int64(e.TTL / time.Second)
+			}
+			var pinned int64
+			if b.pins.pinned(e.Bucket, e.Key) {
+				pinned = 1
+			}
+			row := []any{
+				e.Bucket, e.Key, e.Size, sqliteText(e.ETag), sqliteText(e.Namespace),
+				sqliteTime(e.CachedAt), sqliteTime(e.LastAccess), e.Hits, ttl, pinned,
+			}
+			if !yield(row) {
+				return
+			}
+		}
+	}
+	db := newSQLiteWriter(w)
+	if err := db.table("objects", indexExportTable, iter.Seq[[]any](rows)); err != nil {
+		return err
+	}
+	return db.close()
+}
+
+// sqliteText returns s as a column value, with empty strings as NULL.
+func sqliteText(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// sqliteTime returns t as a column value, with the zero time as NULL.
+func sqliteTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
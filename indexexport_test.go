@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestExportIndex(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "b.txt", "bravo")
+	putString(t, awsBackend, "a.txt", "alpha!")
+	readObject(t, lazyBackend, "b.txt", nil)
+	readObject(t, lazyBackend, "a.txt", nil)
+	readObject(t, lazyBackend, "a.txt", nil)
+	if err := lazyBackend.SetPins([]string{"test-bucket/a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(newAdminHandler(lazyBackend, DefaultConfig()))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/admin/cache/index.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/vnd.sqlite3" {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	rows := sqliteRows(t, data, "objects")
+	if len(rows) != 2 {
+		t.Fatalf("exported %d rows, want 2", len(rows))
+	}
+	a, b := rows[0], rows[1]
+	if a[0] != "test-bucket" || a[1] != "a.txt" || a[2] != int64(6) || a[7] != int64(1) || a[9] != int64(1) {
+		t.Errorf("a.txt row = %v, want 6 bytes, 1 hit, pinned", a)
+	}
+	if b[1] != "b.txt" || b[2] != int64(5) || b[7] != int64(0) || b[8] != nil || b[9] != int64(0) {
+		t.Errorf("b.txt row = %v, want 5 bytes, no hits, no TTL, not pinned", b)
+	}
+	if etag, ok := a[3].(string); !ok || etag == "" {
+		t.Errorf("etag = %v, want the upstream ETag", a[3])
+	}
+	if _, err := time.Parse("2006-01-02 15:04:05.000", a[6].(string)); err != nil {
+		t.Errorf("last_access = %v: %v", a[6], err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Cache policies of an init bucket.
+const (
+	initPolicyCache = "cache" // cache upstream objects (the default)
+	initPolicyProxy = "proxy" // proxy-only: never cache upstream objects
+)
+
+// InitBucket is a bucket created on startup, together with the settings
+// that describe it, so one entry of init_buckets configures the whole bucket.
+// A plain bucket name is an entry with only Name set.
+type InitBucket struct {
+	Name string `yaml:"name"`
+
+	// Upstream bucket fetched from, as in bucket_mappings
+	Upstream string `yaml:"upstream,omitempty"`
+
+	// "cache" or "proxy", as in cache_allow_buckets and cache_deny_buckets
+	Policy string `yaml:"policy,omitempty"`
+
+	// TTL of objects fetched from upstream, as in bucket_ttls; "0s" never
+	// expires them
+	TTL *time.Duration `yaml:"ttl,omitempty"`
+
+	// Reject client writes, as in read_only_buckets
+	ReadOnly bool `yaml:"read_only,omitempty"`
+
+	// Upstream prefixes fetched on startup, as in prefetch
+	WarmPrefixes []string `yaml:"warm_prefixes,omitempty"`
+}
+
+// initBucketFields are the keys an init_buckets entry may have.
+var initBucketFields = []string{"name", "upstream", "policy", "ttl", "read_only", "warm_prefixes"}
+
+// UnmarshalYAML accepts either a bucket name or a mapping of the fields,
+// rejecting unknown fields as the rest of the config file does.
+func (b *InitBucket) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*b = InitBucket{Name: value.Value}
+		return nil
+	}
+	if value.Kind == yaml.MappingNode {
+		for i := 0; i < len(value.Content); i += 2 {
+			if key := value.Content[i]; !slices.Contains(initBucketFields, key.Value) {
+				return fmt.Errorf("line %d: unknown init_buckets field %q", key.Line, key.Value)
+			}
+		}
+	}
+	type plain InitBucket
+	return value.Decode((*plain)(b))
+}
+
+// MarshalYAML renders an entry with only a name as the plain name.
+func (b InitBucket) MarshalYAML() (any, error) {
+	if b.Upstream == "" && b.Policy == "" && b.TTL == nil && !b.ReadOnly && len(b.WarmPrefixes) == 0 {
+		return b.Name, nil
+	}
+	type plain InitBucket
+	return plain(b), nil
+}
+
+// initBucketNames returns the names of the buckets to create on startup.
+func (c *Config) initBucketNames() []string {
+	names := make([]string, len(c.InitBuckets))
+	for i, b := range c.InitBuckets {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// applyInitBuckets folds the settings of init bucket entries into the
+// settings they stand for. An entry may not contradict a setting made for
+// the same bucket elsewhere.
+func (c *Config) applyInitBuckets(errs *configErrors) {
+	for _, b := range c.InitBuckets {
+		if b.Name == "" {
+			errs.addf("init_buckets: entry without a name")
+			continue
+		}
+		if b.Upstream != "" {
+			if mapped, ok := c.BucketMappings[b.Name]; ok && mapped != b.Upstream {
+				errs.addf("init_buckets: %s: upstream %s conflicts with bucket_mappings %s", b.Name, b.Upstream, mapped)
+			}
+			c.BucketMappings[b.Name] = b.Upstream
+		}
+		switch b.Policy {
+		case "":
+		case initPolicyCache:
+			if slices.Contains(c.CacheDenyBuckets, b.Name) {
+				errs.addf("init_buckets: %s: policy %s conflicts with cache_deny_buckets", b.Name, b.Policy)
+			}
+			// Without an allow list every bucket already caches
+			if len(c.CacheAllowBuckets) > 0 && !slices.Contains(c.CacheAllowBuckets, b.Name) {
+				c.CacheAllowBuckets = append(c.CacheAllowBuckets, b.Name)
+			}
+		case initPolicyProxy:
+			if slices.Contains(c.CacheAllowBuckets, b.Name) {
+				errs.addf("init_buckets: %s: policy %s conflicts with cache_allow_buckets", b.Name, b.Policy)
+			}
+			if !slices.Contains(c.CacheDenyBuckets, b.Name) {
+				c.CacheDenyBuckets = append(c.CacheDenyBuckets, b.Name)
+			}
+		default:
+			errs.addf("init_buckets: %s: unknown policy %q (valid options: %s, %s)", b.Name, b.Policy, initPolicyCache, initPolicyProxy)
+		}
+		if b.TTL != nil {
+			if ttl, ok := c.BucketTTLs[b.Name]; ok && ttl != *b.TTL {
+				errs.addf("init_buckets: %s: ttl %v conflicts with bucket_ttls %v", b.Name, *b.TTL, ttl)
+			}
+			c.BucketTTLs[b.Name] = *b.TTL
+		}
+		if b.ReadOnly && !slices.Contains(c.ReadOnlyBuckets, b.Name) {
+			c.ReadOnlyBuckets = append(c.ReadOnlyBuckets, b.Name)
+		}
+		for _, prefix := range b.WarmPrefixes {
+			if p := b.Name + "/" + prefix; !slices.Contains(c.Prefetch, p) {
+				c.Prefetch = append(c.Prefetch, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// keyFilterFalsePositives is the share of missing keys a key filter lets
+// through to upstream anyway. Lower rates cost more memory: at 1%, about
+// 1.2 bytes per key.
+const keyFilterFalsePositives = 0.01
+
+const defaultKeyFilterRefresh = time.Hour
+
+// bloomFilter is a set of strings that answers "maybe present" or
+// "definitely absent".
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// newBloomFilter sizes a filter for n keys at the given false positive rate.
+func newBloomFilter(n int, falsePositives float64) *bloomFilter {
+	n = max(n, 1)
+	m := math.Ceil(-float64(n) * math.Log(falsePositives) / (math.Ln2 * math.Ln2))
+	k := max(1, math.Round(m/float64(n)*math.Ln2))
+	return &bloomFilter{bits: make([]uint64, (int(m)+63)/64), hashes: uint64(k)}
+}
+
+// positions returns the two hashes the bit positions of a key are derived
+// from.
+func (f *bloomFilter) positions(key string) (uint64, uint64) {
+	h1, h2 := fnv.New64a(), fnv.New64()
+	h1.Write([]byte(key))
+	h2.Write([]byte(key))
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+func (f *bloomFilter) add(key string) {
+	a, b := f.positions(key)
+	m := uint64(len(f.bits)) * 64
+	for i := range f.hashes {
+		bit := (a + i*b) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) mayContain(key string) bool {
+	a, b := f.positions(key)
+	m := uint64(len(f.bits)) * 64
+	for i := range f.hashes {
+		bit := (a + i*b) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// keyFilter is the filter of one bucket's upstream keys, with what the
+// admin API reports about it.
+type keyFilter struct {
+	filter      *bloomFilter
+	Keys        int       `json:"keys"`
+	Bytes       int       `json:"bytes"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	Rejected    int64     `json:"rejected"`
+}
+
+// keyFilters answers GETs and HEADs of keys that don't exist upstream
+// with NoSuchKey straight away, from a bloom filter of each configured
+// bucket's upstream keys built from a full listing. Until a bucket's first
+// listing completes, its misses go upstream as usual.
+type keyFilters struct {
+	buckets  []string
+	mu       sync.RWMutex
+	filters  map[string]*keyFilter
+	rejected map[string]*atomic.Int64
+}
+
+func newKeyFilters(buckets []string) *keyFilters {
+	f := &keyFilters{buckets: buckets, filters: make(map[string]*keyFilter), rejected: make(map[string]*atomic.Int64)}
+	for _, bucket := range buckets {
+		f.rejected[bucket] = &atomic.Int64{}
+	}
+	return f
+}
+
+// SetKeyFilters keeps key filters for the local buckets. An empty list
+// disables them.
+func (b *LazyBackend) SetKeyFilters(buckets []string) {
+	b.keyFilters = nil
+	if len(buckets) > 0 {
+		b.keyFilters = newKeyFilters(buckets)
+	}
+}
+
+// excludes reports whether a key is known not to exist upstream. Folder
+// placeholder keys aren't listed, so they are never excluded.
+func (f *keyFilters) excludes(bucketName, objectName string) bool {
+	if f == nil || strings.HasSuffix(objectName, "/") {
+		return false
+	}
+	f.mu.RLock()
+	kf, ok := f.filters[bucketName]
+	f.mu.RUnlock()
+	if !ok || kf.filter.mayContain(objectName) {
+		return false
+	}
+	f.rejected[bucketName].Add(1)
+	return true
+}
+
+// filteredMiss returns the NoSuchKey a key filter answers for a key it
+// excludes, or nil.
+func (b *LazyBackend) filteredMiss(bucketName, objectName string) error {
+	if !b.keyFilters.excludes(bucketName, objectName) {
+		return nil
+	}
+	b.toggles.infof("[KEY FILTER] %s/%s - not upstream", bucketName, objectName)
+	return gofakes3.KeyNotFound(objectName)
+}
+
+// RefreshKeyFilters rebuilds the key filter of every configured bucket from
+// a listing of its upstream bucket. A bucket whose listing fails keeps its
+// previous filter.
+func (b *LazyBackend) RefreshKeyFilters(ctx context.Context) {
+	if b.keyFilters == nil || b.toggles.offline.Load() {
+		return
+	}
+	for _, bucket := range b.keyFilters.buckets {
+		keys, err := b.listUpstream(ctx, bucket, "")
+		if err != nil {
+			log.Printf("[KEY FILTER] %s: listing failed, keeping the previous filter: %v", bucket, err)
+			continue
+		}
+		filter := newBloomFilter(len(keys), keyFilterFalsePositives)
+		for _, key := range keys {
+			filter.add(key)
+		}
+		b.keyFilters.mu.Lock()
+		b.keyFilters.filters[bucket] = &keyFilter{filter: filter, Keys: len(keys), Bytes: len(filter.bits) * 8, RefreshedAt: time.Now().UTC()}
+		b.keyFilters.mu.Unlock()
+		log.Printf("[KEY FILTER] %s: %d keys in %d bytes", bucket, len(keys), len(filter.bits)*8)
+	}
+}
+
+// KeyFilters returns the state of each bucket's key filter, for the admin
+// API; buckets not listed yet are omitted.
+func (b *LazyBackend) KeyFilters() map[string]keyFilter {
+	out := make(map[string]keyFilter)
+	if b.keyFilters == nil {
+		return out
+	}
+	b.keyFilters.mu.RLock()
+	defer b.keyFilters.mu.RUnlock()
+	for bucket, f := range b.keyFilters.filters {
+		kf := *f
+		kf.Rejected = b.keyFilters.rejected[bucket].Load()
+		out[bucket] = kf
+	}
+	return out
+}
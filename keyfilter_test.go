@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(10000, keyFilterFalsePositives)
+	for i := range 10000 {
+		f.add(fmt.Sprintf("present/%d", i))
+	}
+	for i := range 10000 {
+		if !f.mayContain(fmt.Sprintf("present/%d", i)) {
+			t.Fatalf("present/%d: false negative", i)
+		}
+	}
+	falsePositives := 0
+	for i := range 10000 {
+		if f.mayContain(fmt.Sprintf("absent/%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("%d of 10000 absent keys passed, want about 1%%", falsePositives)
+	}
+}
+
+func TestLazyBackend_KeyFilters(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putString(t, awsBackend, "listed.txt", "listed")
+	lazyBackend.SetKeyFilters([]string{"test-bucket"})
+
+	// Before the first listing, misses go upstream
+	putString(t, awsBackend, "early.txt", "early")
+	if _, data := readObject(t, lazyBackend, "early.txt", nil); data != "early" {
+		t.Errorf("data = %q", data)
+	}
+
+	lazyBackend.RefreshKeyFilters(context.Background())
+	if _, data := readObject(t, lazyBackend, "listed.txt", nil); data != "listed" {
+		t.Errorf("data = %q", data)
+	}
+
+	// Added upstream after the listing: missing until the next refresh
+	putString(t, awsBackend, "late.txt", "late")
+	if _, err := lazyBackend.GetObject("test-bucket", "late.txt", nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("GetObject = %v, want NoSuchKey from the filter", err)
+	}
+	if _, err := lazyBackend.HeadObject("test-bucket", "late.txt"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("HeadObject = %v, want NoSuchKey from the filter", err)
+	}
+	if got := lazyBackend.KeyFilters()["test-bucket"]; got.Keys != 2 || got.Rejected != 2 {
+		t.Errorf("filter = %+v, want 2 keys and 2 rejected", got)
+	}
+
+	lazyBackend.RefreshKeyFilters(context.Background())
+	if _, data := readObject(t, lazyBackend, "late.txt", nil); data != "late" {
+		t.Errorf("data = %q", data)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dataLayoutVersion is the version of the on-disk layout this build reads
+// and writes. Bump it, and add a migration from the previous version,
+// whenever a change to what is stored under the data dir would leave an
+// older data dir unreadable.
+const dataLayoutVersion = 1
+
+// layoutLockStale is how old a migration lock must be before it is assumed
+// to belong to an instance that crashed while migrating.
+const layoutLockStale = 10 * time.Minute
+
+// layoutMigration moves a data dir from one layout version to the next. A
+// migration interrupted by a crash is run again from the start, so it must
+// cope with finding its work partly done.
+type layoutMigration struct {
+	from int
+	name string
+	run  func(dataDir string) error
+}
+
+// layoutMigrations are the migrations from every past layout version, in
+// order.
+var layoutMigrations = []layoutMigration{
+	// Data dirs written before the layout was versioned are stamped as
+	// they are
+	{from: 0, name: "stamp unversioned data dir", run: func(string) error { return nil }},
+}
+
+// layoutStamp is the content of the layout version file.
+type layoutStamp struct {
+	Version int `json:"version"`
+}
+
+// layoutPath returns the path of a data dir's layout version file.
+func layoutPath(dataDir string) string {
+	return filepath.Join(dataDir, "s3lazy", "layout.json")
+}
+
+// migrateDataDir brings a data dir to the current layout version, running
+// each migration it needs in order and stamping the version reached after
+// every one. An empty data dir is stamped with the current version; one
+// stamped by a newer s3lazy is refused rather than misread.
+func migrateDataDir(dataDir string) error {
+	return migrateLayout(dataDir, layoutMigrations, dataLayoutVersion)
+}
+
+func migrateLayout(dataDir string, migrations []layoutMigration, current int) error {
+	unlock, err := lockLayout(dataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	version, err := readLayoutVersion(dataDir, current)
+	if err != nil {
+		return err
+	}
+	if version > current {
+		return fmt.Errorf("data dir %s has layout version %d, newer than the %d this s3lazy supports; upgrade s3lazy or use another data dir", dataDir, version, current)
+	}
+	for _, m := range migrations {
+		if m.from != version || version >= current {
+			continue
+		}
+		log.Printf("[LAYOUT] migrating %s from layout %d to %d: %s", dataDir, version, version+1, m.name)
+		if err := m.run(dataDir); err != nil {
+			return fmt.Errorf("migrating layout %d to %d (%s): %w", version, version+1, m.name, err)
+		}
+		version++
+		if err := writeLayoutVersion(dataDir, version); err != nil {
+			return err
+		}
+	}
+	if version != current {
+		return fmt.Errorf("no migration from layout version %d of data dir %s", version, dataDir)
+	}
+	return nil
+}
+
+// readLayoutVersion returns the layout version a data dir is stamped with.
+// An unstamped data dir is new, and stamped with the current version, if
+// it is empty, and version 0 otherwise.
+func readLayoutVersion(dataDir string, current int) (int, error) {
+	data, err := os.ReadFile(layoutPath(dataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		entries, err := os.ReadDir(dataDir)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			// The lock is the only thing in a new data dir's s3lazy dir
+			if e.Name() != "s3lazy" || !onlyLayoutLock(filepath.Join(dataDir, "s3lazy")) {
+				return 0, nil
+			}
+		}
+		return current, writeLayoutVersion(dataDir, current)
+	}
+	if err != nil {
+		return 0, err
+	}
+	var stamp layoutStamp
+	if err := json.Unmarshal(data, &stamp); err != nil {
+		return 0, fmt.Errorf("reading %s: %w", layoutPath(dataDir), err)
+	}
+	return stamp.Version, nil
+}
+
+// onlyLayoutLock reports whether dir holds nothing but the layout lock.
+func onlyLayoutLock(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.Name() != "layout.lock" {
+			return false
+		}
+	}
+	return true
+}
+
+// writeLayoutVersion stamps a data dir with a layout version, via a temp
+// file and rename so a crash never leaves a truncated stamp behind.
+func writeLayoutVersion(dataDir string, version int) error {
+	data, err := json.Marshal(layoutStamp{Version: version})
+	if err != nil {
+		return err
+	}
+	path := layoutPath(dataDir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// lockLayout keeps replicas sharing a data dir from checking and migrating
+// its layout at the same time, waiting for a replica already at it.
+func lockLayout(dataDir string) (func(), error) {
+	if err := os.MkdirAll(filepath.Join(dataDir, "s3lazy"), 0755); err != nil {
+		return nil, err
+	}
+	lockPath := filepath.Join(dataDir, "s3lazy", "layout.lock")
+	waiting := false
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > layoutLockStale {
+			log.Printf("[LAYOUT] breaking stale lock %s", lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+		if !waiting {
+			log.Printf("[LAYOUT] waiting for another instance to finish with %s", lockPath)
+			waiting = true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stampedVersion returns the layout version a data dir is stamped with.
+func stampedVersion(t *testing.T, dataDir string) int {
+	t.Helper()
+	version, err := readLayoutVersion(dataDir, -1)
+	if err != nil {
+		t.Fatalf("reading layout version: %v", err)
+	}
+	return version
+}
+
+func TestMigrateLayout_NewDataDir(t *testing.T) {
+	dataDir := t.TempDir()
+	var ran []string
+	migrations := []layoutMigration{
+		{from: 0, name: "first", run: func(string) error { ran = append(ran, "first"); return nil }},
+	}
+	if err := migrateLayout(dataDir, migrations, 1); err != nil {
+		t.Fatalf("migrateLayout: %v", err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("migrations %v ran on an empty data dir", ran)
+	}
+	if v := stampedVersion(t, dataDir); v != 1 {
+		t.Errorf("layout version = %d, want 1", v)
+	}
+}
+
+func TestMigrateLayout_UnversionedDataDir(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dataDir, "my-bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var ran []string
+	step := func(name string) func(string) error {
+		return func(dir string) error {
+			if dir != dataDir {
+				t.Errorf("%s ran on %s, want %s", name, dir, dataDir)
+			}
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	migrations := []layoutMigration{
+		{from: 0, name: "first", run: step("first")},
+		{from: 1, name: "second", run: step("second")},
+	}
+	if err := migrateLayout(dataDir, migrations, 2); err != nil {
+		t.Fatalf("migrateLayout: %v", err)
+	}
+	if !slices.Equal(ran, []string{"first", "second"}) {
+		t.Errorf("migrations ran = %v, want [first second]", ran)
+	}
+	if v := stampedVersion(t, dataDir); v != 2 {
+		t.Errorf("layout version = %d, want 2", v)
+	}
+
+	// Already current: nothing runs again
+	ran = nil
+	if err := migrateLayout(dataDir, migrations, 2); err != nil || len(ran) != 0 {
+		t.Errorf("second migrateLayout = %v, ran %v; want nothing run", err, ran)
+	}
+}
+
+func TestMigrateLayout_FailedMigrationResumes(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := writeLayoutVersion(dataDir, 1); err == nil {
+		t.Fatal("stamp written without an s3lazy dir")
+	}
+	if err := os.MkdirAll(filepath.Join(dataDir, "s3lazy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLayoutVersion(dataDir, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	fail := true
+	migrations := []layoutMigration{
+		{from: 1, name: "second", run: func(string) error { return nil }},
+		{from: 2, name: "third", run: func(string) error {
+			if fail {
+				return errors.New("disk on fire")
+			}
+			return nil
+		}},
+	}
+	err := migrateLayout(dataDir, migrations, 3)
+	if err == nil || !strings.Contains(err.Error(), "third") {
+		t.Fatalf("migrateLayout = %v, want the third migration's failure", err)
+	}
+	if v := stampedVersion(t, dataDir); v != 2 {
+		t.Errorf("layout version after failure = %d, want 2", v)
+	}
+
+	fail = false
+	if err := migrateLayout(dataDir, migrations, 3); err != nil {
+		t.Fatalf("migrateLayout after fix: %v", err)
+	}
+	if v := stampedVersion(t, dataDir); v != 3 {
+		t.Errorf("layout version = %d, want 3", v)
+	}
+}
+
+func TestMigrateLayout_Refused(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dataDir, "s3lazy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLayoutVersion(dataDir, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateLayout(dataDir, nil, 1); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("migrateLayout of a newer data dir = %v, want it refused", err)
+	}
+	if err := writeLayoutVersion(dataDir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateLayout(dataDir, nil, 1); err == nil || !strings.Contains(err.Error(), "no migration") {
+		t.Errorf("migrateLayout without a migration = %v, want an error", err)
+	}
+}
+
+func TestMigrateDataDir_Current(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dataDir, "stray"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDataDir(dataDir); err != nil {
+		t.Fatalf("migrateDataDir: %v", err)
+	}
+	if v := stampedVersion(t, dataDir); v != dataLayoutVersion {
+		t.Errorf("layout version = %d, want %d", v, dataLayoutVersion)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "s3lazy", "layout.lock")); !os.IsNotExist(err) {
+		t.Errorf("layout lock left behind: %v", err)
+	}
+}
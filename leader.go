@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLeaseTTL is how long a leader lease stays valid without renewal.
+const defaultLeaseTTL = 30 * time.Second
+
+// leaseRecord is the on-disk leader lease.
+type leaseRecord struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// leaderElector coordinates replicas that share a network filesystem as their
+// data dir. Exactly one replica holds a time-limited lease file and runs the
+// background jobs that would otherwise stomp on each other.
+//
+// A nil *leaderElector always reports leadership, so single-instance setups
+// need no special casing.
+type leaderElector struct {
+	path string
+	id   string
+	ttl  time.Duration
+	now  func() time.Time
+
+	leader atomic.Bool
+}
+
+// newLeaderElector creates an elector whose lease lives in dir.
+func newLeaderElector(dir, id string, ttl time.Duration) (*leaderElector, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	return &leaderElector{
+		path: filepath.Join(dir, "leader.lease"),
+		id:   id,
+		ttl:  ttl,
+		now:  time.Now,
+	}, nil
+}
+
+// defaultInstanceID derives a stable identity from the hostname and listen
+// address, so a restarted replica reclaims its own lease.
+func defaultInstanceID(listenAddr string) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return host + listenAddr
+}
+
+// IsLeader reports whether this instance currently holds the lease.
+func (e *leaderElector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	return e.leader.Load()
+}
+
+// tryAcquire takes the lease if it is free or expired, or renews it if this
+// instance already holds it. It returns whether this instance is the leader.
+func (e *leaderElector) tryAcquire() (bool, error) {
+	unlock, err := e.lockFile()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	rec, err := e.read()
+	if err != nil && !os.IsNotExist(err) {
+		// A corrupt lease is treated like a missing one
+		log.Printf("Warning: unreadable leader lease %s: %v", e.path, err)
+	}
+	if err == nil && rec.Holder != e.id && e.now().Before(rec.Expires) {
+		return false, nil
+	}
+
+	return true, e.write(leaseRecord{Holder: e.id, Expires: e.now().Add(e.ttl)})
+}
+
+// release gives up the lease if this instance holds it, letting another
+// replica take over without waiting for expiry.
+func (e *leaderElector) release() error {
+	unlock, err := e.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	e.leader.Store(false)
+	if rec, err := e.read(); err == nil && rec.Holder == e.id {
+		return os.Remove(e.path)
+	}
+	return nil
+}
+
+// run renews the lease until ctx is cancelled, then releases it.
+func (e *leaderElector) run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		leader, err := e.tryAcquire()
+		if err != nil {
+			log.Printf("Warning: leader lease error: %v", err)
+			leader = false
+		}
+		if was := e.leader.Swap(leader); was != leader {
+			if leader {
+				log.Printf("[LEADER] %s acquired leadership", e.id)
+			} else {
+				log.Printf("[LEADER] %s lost leadership", e.id)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := e.release(); err != nil {
+				log.Printf("Warning: failed to release leader lease: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// finishLeading runs save if this instance leads, then has the elector,
+// started with run under a context stop cancels, give up its lease and waits
+// until it has. Saving while the lease is still held means the state a
+// restart picks up is written, and no other replica takes over and writes
+// alongside. A nil elector always saves.
+func (e *leaderElector) finishLeading(stop context.CancelFunc, stopped <-chan struct{}, save func()) {
+	if e.IsLeader() {
+		save()
+	}
+	stop()
+	<-stopped
+}
+
+// runLeaderJob runs fn every interval while this instance is the leader.
+// Followers skip their turn rather than queueing it.
+func runLeaderJob(ctx context.Context, e *leaderElector, name string, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !e.IsLeader() {
+				continue
+			}
+			log.Printf("[JOB] running %s", name)
+			fn()
+		}
+	}
+}
+
+// lockFile serializes lease reads and writes across replicas using an
+// exclusively created lock file. A lock older than the lease TTL is assumed
+// to belong to a crashed replica and is broken.
+func (e *leaderElector) lockFile() (func(), error) {
+	lockPath := e.path + ".lock"
+	deadline := e.now().Add(e.ttl)
+
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintln(f, e.id)
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, statErr := os.Stat(lockPath); statErr == nil && e.now().Sub(info.ModTime()) > e.ttl {
+			os.Remove(lockPath)
+			continue
+		}
+		if e.now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (e *leaderElector) read() (leaseRecord, error) {
+	var rec leaseRecord
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return rec, err
+	}
+	return rec, json.Unmarshal(data, &rec)
+}
+
+// write replaces the lease via a temp file and rename so readers never see a
+// partially written record.
+func (e *leaderElector) write(rec leaseRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.path)
+}
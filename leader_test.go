@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaderElector_SingleLeader(t *testing.T) {
+	dir := t.TempDir()
+
+	a, err := newLeaderElector(dir, "replica-a", time.Minute)
+	if err != nil {
+		t.Fatalf("newLeaderElector failed: %v", err)
+	}
+	b, err := newLeaderElector(dir, "replica-b", time.Minute)
+	if err != nil {
+		t.Fatalf("newLeaderElector failed: %v", err)
+	}
+
+	if ok, err := a.tryAcquire(); err != nil || !ok {
+		t.Fatalf("replica-a should acquire a free lease: ok=%v err=%v", ok, err)
+	}
+	if ok, err := b.tryAcquire(); err != nil || ok {
+		t.Fatalf("replica-b should not acquire a held lease: ok=%v err=%v", ok, err)
+	}
+
+	// The holder can renew its own lease
+	if ok, err := a.tryAcquire(); err != nil || !ok {
+		t.Fatalf("replica-a should renew its lease: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestLeaderElector_TakeoverAfterExpiry(t *testing.T) {
+	dir := t.TempDir()
+
+	a, _ := newLeaderElector(dir, "replica-a", time.Minute)
+	b, _ := newLeaderElector(dir, "replica-b", time.Minute)
+
+	if ok, _ := a.tryAcquire(); !ok {
+		t.Fatal("replica-a should acquire a free lease")
+	}
+
+	// Pretend replica-a stopped renewing two minutes ago
+	b.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	if ok, err := b.tryAcquire(); err != nil || !ok {
+		t.Fatalf("replica-b should take over an expired lease: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestLeaderElector_Release(t *testing.T) {
+	dir := t.TempDir()
+
+	a, _ := newLeaderElector(dir, "replica-a", time.Minute)
+	b, _ := newLeaderElector(dir, "replica-b", time.Minute)
+
+	if ok, _ := a.tryAcquire(); !ok {
+		t.Fatal("replica-a should acquire a free lease")
+	}
+	if err := a.release(); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if ok, _ := b.tryAcquire(); !ok {
+		t.Error("replica-b should acquire a released lease immediately")
+	}
+}
+
+func TestLeaderElector_BreaksStaleLock(t *testing.T) {
+	dir := t.TempDir()
+
+	a, _ := newLeaderElector(dir, "replica-a", 100*time.Millisecond)
+
+	// A lock file left behind by a crashed replica
+	if err := os.WriteFile(a.path+".lock", []byte("crashed"), 0644); err != nil {
+		t.Fatalf("Failed to write lock file: %v", err)
+	}
+	old := time.Now().Add(-time.Second)
+	if err := os.Chtimes(a.path+".lock", old, old); err != nil {
+		t.Fatalf("Failed to age lock file: %v", err)
+	}
+
+	if ok, err := a.tryAcquire(); err != nil || !ok {
+		t.Fatalf("stale lock should be broken: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestLeaderElector_NilIsLeader(t *testing.T) {
+	var e *leaderElector
+	if !e.IsLeader() {
+		t.Error("nil elector should always report leadership")
+	}
+}
+
+func TestRunLeaderJob_SkipsFollowers(t *testing.T) {
+	dir := t.TempDir()
+	e, _ := newLeaderElector(dir, "replica-a", time.Minute)
+
+	var runs atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runLeaderJob(ctx, e, "test", 10*time.Millisecond, func() { runs.Add(1) })
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if runs.Load() != 0 {
+		t.Errorf("follower ran job %d times, want 0", runs.Load())
+	}
+
+	e.leader.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if runs.Load() == 0 {
+		t.Error("leader should run the job")
+	}
+}
+
+func TestLeaderElector_FinishLeading(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := newLeaderElector(dir, "replica-a", time.Minute)
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		a.run(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !a.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("replica-a never acquired the lease")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The index is saved while the lease is held, then the lease released
+	index := newCacheIndex(0)
+	index.add("test-bucket", "file.txt", 4, "")
+	indexPath := filepath.Join(dir, "index.json")
+	a.finishLeading(stop, stopped, func() {
+		if err := index.save(indexPath); err != nil {
+			t.Errorf("saving the index: %v", err)
+		}
+	})
+	if _, err := os.Stat(indexPath); err != nil {
+		t.Errorf("index not saved on shutdown: %v", err)
+	}
+	b, _ := newLeaderElector(dir, "replica-b", time.Minute)
+	if ok, err := b.tryAcquire(); err != nil || !ok {
+		t.Errorf("replica-b should acquire the released lease: ok=%v err=%v", ok, err)
+	}
+
+	// A follower leaves saving to the leader
+	followerStopped := make(chan struct{})
+	close(followerStopped)
+	a.finishLeading(func() {}, followerStopped, func() { t.Error("a follower saved") })
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes. Config errors exit with EX_CONFIG, so orchestrators and
+// systemd (RestartPreventExitStatus=78) can tell a process that will fail
+// the same way on every restart from one that crashed.
+const (
+	exitRuntime = 1
+	exitConfig  = 78
+)
+
+// defaultShutdownGracePeriod is how long shutdown waits for in-flight
+// requests and fetches unless configured otherwise.
+const defaultShutdownGracePeriod = 30 * time.Second
+
+// fatalConfig logs a configuration error and exits with exitConfig.
+func fatalConfig(format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(exitConfig)
+}
+
+// sdNotify sends a state change such as "READY=1" to the service manager
+// over $NOTIFY_SOCKET. It does nothing when not run under systemd with
+// Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifyState is sdNotify logging failures instead of returning them.
+func notifyState(state string) {
+	if err := sdNotify(state); err != nil {
+		log.Printf("Warning: couldn't notify service manager of %q: %v", state, err)
+	}
+}
+
+// watchdogInterval returns how often to ping the systemd watchdog: half of
+// WatchdogSec, if it is set for this process.
+func watchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond / 2, true
+}
+
+// runWatchdog pings the systemd watchdog every interval until stop is closed.
+func runWatchdog(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			notifyState("WATCHDOG=1")
+		}
+	}
+}
+
+// waitWithin runs wait and returns whether it finished before deadline.
+// A wait still running at the deadline is left to finish on its own.
+func waitWithin(deadline time.Time, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wait()
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
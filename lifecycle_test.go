@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotify opens a socket standing in for systemd's notify socket and
+// points NOTIFY_SOCKET at it.
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// nextState reads the next state sent to the notify socket, or "" if none
+// arrives soon.
+func nextState(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+func TestSdNotify_WithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("sdNotify outside systemd: %v", err)
+	}
+}
+
+func TestReadiness_Announce(t *testing.T) {
+	conn := listenNotify(t)
+	r := &readiness{}
+
+	r.setNotReady("warming 3 object(s)")
+	if got := nextState(t, conn); got != "STATUS=warming 3 object(s)" {
+		t.Errorf("state = %q, want the warming status", got)
+	}
+	r.setListening()
+	if got := nextState(t, conn); got != "" {
+		t.Errorf("state = %q before ready, want none", got)
+	}
+	r.setReady()
+	if got := nextState(t, conn); got != "READY=1\nSTATUS=Serving" {
+		t.Errorf("state = %q, want READY=1", got)
+	}
+	r.setReady()
+	if got := nextState(t, conn); got != "" {
+		t.Errorf("state = %q, want READY=1 sent only once", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	if _, ok := watchdogInterval(); ok {
+		t.Error("watchdog enabled without WATCHDOG_USEC")
+	}
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if interval, ok := watchdogInterval(); !ok || interval != 15*time.Second {
+		t.Errorf("interval = %v, %v; want 15s", interval, ok)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if _, ok := watchdogInterval(); ok && os.Getpid() != 1 {
+		t.Error("watchdog enabled for another process")
+	}
+}
+
+func TestWaitWithin(t *testing.T) {
+	if !waitWithin(time.Now().Add(time.Second), func() {}) {
+		t.Error("quick wait not finished before the deadline")
+	}
+	release := make(chan struct{})
+	defer close(release)
+	if waitWithin(time.Now().Add(10*time.Millisecond), func() { <-release }) {
+		t.Error("blocked wait reported finished")
+	}
+}
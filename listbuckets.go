@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// maxBucketsLimit is the largest max-buckets S3 accepts.
+const maxBucketsLimit = 10000
+
+// bucketPage selects a page of ListBuckets, from the parameters newer SDKs
+// send.
+type bucketPage struct {
+	Prefix            string
+	ContinuationToken string
+	MaxBuckets        int // 0 is every bucket
+}
+
+// bucketList is a page of buckets, with the token to pass for the next
+// one, "" on the last.
+type bucketList struct {
+	Buckets           []gofakes3.BucketInfo
+	ContinuationToken string
+}
+
+// bucketPageLister is a local backend that pages ListBuckets itself.
+type bucketPageLister interface {
+	ListBucketsPageContext(ctx context.Context, page bucketPage) (bucketList, error)
+}
+
+// ListBucketsPage returns a page of buckets. A local backend that pages
+// them itself is asked for the page, unless aliases are listed alongside
+// its buckets; otherwise the buckets are paged here, in name order, with the
+// last bucket of a page as the token of the next.
+func (b *LazyBackend) ListBucketsPage(ctx context.Context, page bucketPage) (bucketList, error) {
+	if lister, ok := b.local.(bucketPageLister); ok && !b.hasAliases() {
+		return lister.ListBucketsPageContext(ctx, page)
+	}
+	buckets, err := b.ListBuckets()
+	if err != nil {
+		return bucketList{}, err
+	}
+	return pageBuckets(buckets, page)
+}
+
+// hasAliases reports whether any bucket aliases are set.
+func (b *LazyBackend) hasAliases() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.bucketAliases) > 0
+}
+
+// pageBuckets picks a page out of buckets in name order. Backends such as
+// s3mem list buckets in no particular order, so they are sorted first.
+func pageBuckets(buckets []gofakes3.BucketInfo, page bucketPage) (bucketList, error) {
+	buckets = slices.Clone(buckets)
+	slices.SortFunc(buckets, func(a, b gofakes3.BucketInfo) int { return strings.Compare(a.Name, b.Name) })
+	var after string
+	if page.ContinuationToken != "" {
+		name, err := base64.RawURLEncoding.DecodeString(page.ContinuationToken)
+		if err != nil || len(name) == 0 {
+			return bucketList{}, gofakes3.ErrorMessage(gofakes3.ErrInvalidArgument, "The continuation token provided is incorrect")
+		}
+		after = string(name)
+	}
+	list := bucketList{Buckets: []gofakes3.BucketInfo{}}
+	for _, bucket := range buckets {
+		if !strings.HasPrefix(bucket.Name, page.Prefix) || bucket.Name <= after {
+			continue
+		}
+		if page.MaxBuckets > 0 && len(list.Buckets) == page.MaxBuckets {
+			list.ContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(list.Buckets[len(list.Buckets)-1].Name))
+			break
+		}
+		list.Buckets = append(list.Buckets, bucket)
+	}
+	return list, nil
+}
+
+// listBucketsResult is a ListBuckets response with the pagination elements
+// gofakes3 leaves out.
+type listBucketsResult struct {
+	XMLName           xml.Name           `xml:"ListAllMyBucketsResult"`
+	Xmlns             string             `xml:"xmlns,attr"`
+	Owner             *gofakes3.UserInfo `xml:"Owner,omitempty"`
+	Buckets           gofakes3.Buckets   `xml:"Buckets>Bucket"`
+	ContinuationToken string             `xml:"ContinuationToken,omitempty"`
+	Prefix            string             `xml:"Prefix,omitempty"`
+}
+
+// listBucketsPaging answers ListBuckets requests that ask for a page:
+// gofakes3 ignores prefix, continuation-token and max-buckets and returns
+// every bucket. Requests without them are passed on unchanged.
+func (b *LazyBackend) listBucketsPaging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.Method != http.MethodGet || r.URL.Path != "/" || !q.Has("prefix") && !q.Has("continuation-token") && !q.Has("max-buckets") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		page := bucketPage{Prefix: q.Get("prefix"), ContinuationToken: q.Get("continuation-token")}
+		if v := q.Get("max-buckets"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxBucketsLimit {
+				writeRejection(w, gofakes3.ErrorMessagef(gofakes3.ErrInvalidArgument, "max-buckets must be between 1 and %d, got %q", maxBucketsLimit, v))
+				return
+			}
+			page.MaxBuckets = n
+		}
+		list, err := b.ListBucketsPage(r.Context(), page)
+		if err != nil {
+			writeRejection(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(xml.Header))
+		_ = xml.NewEncoder(w).Encode(&listBucketsResult{
+			Xmlns:             "http://s3.amazonaws.com/doc/2006-03-01/",
+			Owner:             &gofakes3.UserInfo{ID: "fe7272ea58be830e56fe1663b10fafef", DisplayName: "GoFakeS3"},
+			Buckets:           list.Buckets,
+			ContinuationToken: list.ContinuationToken,
+			Prefix:            page.Prefix,
+		})
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// listBucketPages lists the buckets under prefix max at a time with the
+// SDK's paginator, returning their names and the number of pages.
+func listBucketPages(t *testing.T, client *s3.Client, prefix string, max int32) ([]string, int) {
+	t.Helper()
+	var names []string
+	pages := 0
+	paginator := s3.NewListBucketsPaginator(client, &s3.ListBucketsInput{Prefix: aws.String(prefix), MaxBuckets: aws.Int32(max)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(t.Context())
+		if err != nil {
+			t.Fatalf("ListBuckets page %d: %v", pages+1, err)
+		}
+		if aws.ToString(page.Prefix) != prefix {
+			t.Errorf("prefix = %q, want it echoed", aws.ToString(page.Prefix))
+		}
+		pages++
+		for _, bucket := range page.Buckets {
+			names = append(names, aws.ToString(bucket.Name))
+		}
+	}
+	return names, pages
+}
+
+func TestListBucketsPaging(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, bucket := range []string{"alpha-1", "alpha-2", "alpha-3", "alpha-4", "beta"} {
+		if err := localBackend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	server := httptest.NewServer(lazyBackend.listBucketsPaging(gofakes3.New(lazyBackend).Server()))
+	defer server.Close()
+	client := newEndpointClient(t, server.URL)
+
+	// The memory backend lists buckets in map order; pages must still
+	// cover every bucket once, however often it's listed
+	for range 5 {
+		names, pages := listBucketPages(t, client, "", 2)
+		if want := []string{"alpha-1", "alpha-2", "alpha-3", "alpha-4", "beta"}; pages != 3 || !slices.Equal(names, want) {
+			t.Fatalf("without aliases, listed %v in %d pages, want %v in 3", names, pages, want)
+		}
+	}
+
+	lazyBackend.SetBucketAliases(map[string]string{"alpha-5": "beta"})
+	names, pages := listBucketPages(t, client, "alpha-", 2)
+	if want := []string{"alpha-1", "alpha-2", "alpha-3", "alpha-4", "alpha-5"}; pages != 3 || !slices.Equal(names, want) {
+		t.Errorf("listed %v in %d pages, want %v in 3", names, pages, want)
+	}
+
+	// Without pagination parameters every bucket is listed
+	all, err := client.ListBuckets(t.Context(), &s3.ListBucketsInput{})
+	if err != nil || len(all.Buckets) != 6 || all.ContinuationToken != nil {
+		t.Errorf("ListBuckets = %d buckets, token %v, %v; want all 6", len(all.Buckets), all.ContinuationToken, err)
+	}
+
+	_, err = client.ListBuckets(t.Context(), &s3.ListBucketsInput{ContinuationToken: aws.String("not a token")})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "InvalidArgument" {
+		t.Errorf("ListBuckets with a bad token = %v, want InvalidArgument", err)
+	}
+	resp, err := http.Get(server.URL + "/?max-buckets=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("max-buckets=0 status = %d, want 400", resp.StatusCode)
+	}
+}
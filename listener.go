@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// Listener defaults that harden the server against slow or stalled clients
+// without getting in the way of large transfers.
+const (
+	defaultMaxHeaderBytes = 1 << 20 // http.DefaultMaxHeaderBytes
+	defaultHeaderTimeout  = 30 * time.Second
+	defaultIdleTimeout    = 2 * time.Minute
+)
+
+// newServer returns the HTTP server for the configured listener limits.
+// Request bodies above the body limit are rejected before they reach
+// handler.
+func newServer(cfg *Config, handler http.Handler) *http.Server {
+	if cfg.ListenMaxBodyBytes > 0 {
+		handler = bodyLimit(int64(cfg.ListenMaxBodyBytes), handler)
+	}
+	return &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           handler,
+		MaxHeaderBytes:    int(cfg.ListenMaxHeaderBytes),
+		ReadHeaderTimeout: cfg.ListenHeaderTimeout,
+		ReadTimeout:       cfg.ListenReadTimeout,
+		WriteTimeout:      cfg.ListenWriteTimeout,
+		IdleTimeout:       cfg.ListenIdleTimeout,
+	}
+}
+
+// isLoopbackAddr reports whether a listen address only accepts connections
+// from this host.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listen opens the server's listener, holding it to the configured number of
+// concurrent connections.
+func listen(cfg *Config) (net.Listener, error) {
+	l, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ListenMaxConnections > 0 {
+		l = newLimitListener(l, cfg.ListenMaxConnections)
+	}
+	return l, nil
+}
+
+// limitListener accepts at most n connections at a time. Further
+// connections wait in the kernel's backlog until one closes.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+func newLimitListener(l net.Listener, n int) *limitListener {
+	return &limitListener{Listener: l, sem: make(chan struct{}, n)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+// limitConn frees its slot in a limitListener when closed.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// bodyLimit rejects requests whose body is larger than max bytes with S3's
+// EntityTooLarge error. Bodies of unknown length are cut off at the limit.
+func bodyLimit(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_ = xml.NewEncoder(w).Encode(&gofakes3.ErrorResponse{
+				Code:    "EntityTooLarge",
+				Message: fmt.Sprintf("Your proposed upload exceeds the maximum allowed size of %d bytes", max),
+			})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyLimit(t *testing.T) {
+	handler := bodyLimit(8, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+	put := func(body io.Reader) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/bucket/key", body))
+		return rec
+	}
+	if rec := put(strings.NewReader("small")); rec.Code != http.StatusOK {
+		t.Errorf("small body: status %d, want 200", rec.Code)
+	}
+	rec := put(strings.NewReader("far too large"))
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "EntityTooLarge") {
+		t.Errorf("large body: status %d, body %q; want 413 EntityTooLarge", rec.Code, rec.Body.String())
+	}
+	// Without a Content-Length the body is cut off at the limit
+	if rec := put(io.MultiReader(strings.NewReader("far too "), strings.NewReader("large"))); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body of unknown length: status %d, want 413", rec.Code)
+	}
+}
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newLimitListener(inner, 1)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for range 2 {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("second connection accepted while the first is open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("second connection not accepted after the first closed")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// listPrefetchWindow is how long after a listing a GET of one of its keys
+// still starts prefetching the rest.
+const listPrefetchWindow = 5 * time.Minute
+
+// maxListPrefetchPages bounds how many listing pages are remembered.
+const maxListPrefetchPages = 64
+
+// defaultListPrefetchMaxBytes bounds the bytes prefetched after one listing
+// page unless configured otherwise.
+const defaultListPrefetchMaxBytes = 256 << 20
+
+// listedPage is one page of a client listing.
+type listedPage struct {
+	bucket    string
+	keys      []string // in listing order
+	sizes     map[string]int64
+	listedAt  time.Time
+	triggered bool
+}
+
+// listPrefetcher watches for clients listing a prefix and then reading keys
+// from it, and fetches the keys further down the listing in the background
+// so they are ready by the time the client gets to them. Listings are served
+// from the local backend, so the keys fetched are those that expired or were
+// evicted since they were listed.
+type listPrefetcher struct {
+	maxBytes int64 // listed bytes prefetched per page (0 means unlimited)
+	slots    chan struct{}
+	now      func() time.Time
+
+	mu    sync.Mutex
+	pages []*listedPage // oldest first
+	byKey map[entryKey]*listedPage
+	wg    sync.WaitGroup
+}
+
+func newListPrefetcher(concurrency int, maxBytes int64) *listPrefetcher {
+	return &listPrefetcher{
+		maxBytes: maxBytes,
+		slots:    make(chan struct{}, concurrency),
+		now:      time.Now,
+		byKey:    make(map[entryKey]*listedPage),
+	}
+}
+
+// listed records a page a client listed.
+func (p *listPrefetcher) listed(bucket string, list *gofakes3.ObjectList) {
+	if p == nil || len(list.Contents) < 2 {
+		return
+	}
+	page := &listedPage{bucket: bucket, sizes: make(map[string]int64, len(list.Contents)), listedAt: p.now()}
+	for _, c := range list.Contents {
+		if strings.HasSuffix(c.Key, "/") {
+			continue
+		}
+		page.keys = append(page.keys, c.Key)
+		page.sizes[c.Key] = c.Size
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pages = append(p.pages, page)
+	for _, key := range page.keys {
+		p.byKey[entryKey{bucket, key}] = page
+	}
+	for len(p.pages) > maxListPrefetchPages {
+		p.forget(p.pages[0])
+	}
+}
+
+// forget drops the oldest page. The caller must hold p.mu.
+func (p *listPrefetcher) forget(page *listedPage) {
+	p.pages = p.pages[1:]
+	for _, key := range page.keys {
+		if k := (entryKey{page.bucket, key}); p.byKey[k] == page {
+			delete(p.byKey, k)
+		}
+	}
+}
+
+// next returns the keys to prefetch after a client read key, if it is the
+// first read from a recent listing: the keys listed after it, up to the
+// byte budget.
+func (p *listPrefetcher) next(bucket, key string) []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.pages) > 0 && p.now().Sub(p.pages[0].listedAt) > listPrefetchWindow {
+		p.forget(p.pages[0])
+	}
+	page := p.byKey[entryKey{bucket, key}]
+	if page == nil || page.triggered {
+		return nil
+	}
+	page.triggered = true
+
+	var keys []string
+	var budget int64
+	after := false
+	for _, k := range page.keys {
+		if k == key {
+			after = true
+			continue
+		}
+		if !after {
+			continue
+		}
+		if p.maxBytes > 0 && budget+page.sizes[k] > p.maxBytes {
+			break
+		}
+		budget += page.sizes[k]
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// start prefetches keys in the background, running at most the configured
+// number of fetches at a time across all listings.
+func (p *listPrefetcher) start(bucket string, keys []string, fetch func(ctx context.Context, bucket, key string) (bool, error)) {
+	if len(keys) == 0 {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		fetched := 0
+		for _, key := range keys {
+			p.slots <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-p.slots }()
+				// The prefetch outlives the request that started it
+				did, err := fetch(context.Background(), bucket, key)
+				if err != nil && !errors.Is(err, errNotCacheable) {
+					log.Printf("[LIST PREFETCH ERROR] %s/%s: %v", bucket, key, err)
+				}
+				if did {
+					mu.Lock()
+					fetched++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if fetched > 0 {
+			log.Printf("[LIST PREFETCH] %s: fetched %d of the next %d listed key(s)", bucket, fetched, len(keys))
+		}
+	}()
+}
+
+// wait blocks until every running prefetch has finished.
+func (p *listPrefetcher) wait() {
+	if p != nil {
+		p.wg.Wait()
+	}
+}
+
+// SetListPrefetch prefetches the keys of a listing once a client starts
+// reading them, at most concurrency at a time and maxBytes of them per
+// listing page (0 means unlimited). A concurrency of 0 disables it.
+func (b *LazyBackend) SetListPrefetch(concurrency int, maxBytes int64) {
+	b.listPrefetch = nil
+	if concurrency > 0 {
+		b.listPrefetch = newListPrefetcher(concurrency, maxBytes)
+	}
+}
+
+// prefetchListed starts prefetching the rest of a listing after a client
+// read one of its keys.
+func (b *LazyBackend) prefetchListed(bucketName, objectName string) {
+	if b.listPrefetch == nil || b.toggles.offline.Load() || !b.cachePolicy.caches(bucketName) {
+		return
+	}
+	b.listPrefetch.start(bucketName, b.listPrefetch.next(bucketName, objectName), b.prefetchKey)
+}
+
+// prefetchKey makes sure a listed key is cached and fresh: a key that was
+// evicted is fetched again, and one that expired is refreshed. Keys written
+// by clients are left alone. It reports whether anything was fetched.
+func (b *LazyBackend) prefetchKey(ctx context.Context, bucketName, objectName string) (bool, error) {
+	if _, err := b.local.HeadObject(bucketName, objectName); err != nil {
+		return true, b.fill(ctx, bucketName, objectName)
+	}
+	if _, cached := b.index.lookup(bucketName, objectName); !cached || !b.expired(bucketName, objectName) {
+		return false, nil
+	}
+	return b.refresh(ctx, bucketName, objectName, false)
+}
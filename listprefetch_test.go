@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func listOf(sizes map[string]int64, keys ...string) *gofakes3.ObjectList {
+	list := &gofakes3.ObjectList{}
+	for _, key := range keys {
+		list.Contents = append(list.Contents, &gofakes3.Content{Key: key, Size: sizes[key]})
+	}
+	return list
+}
+
+func TestListPrefetcher_Next(t *testing.T) {
+	now := time.Unix(1000, 0)
+	p := newListPrefetcher(2, 250)
+	p.now = func() time.Time { return now }
+	sizes := map[string]int64{"a": 100, "b": 100, "c": 100, "d": 100}
+	p.listed("bucket", listOf(sizes, "a", "b", "dir/", "c", "d"))
+
+	if keys := p.next("other", "a"); keys != nil {
+		t.Errorf("next for an unlisted bucket = %v, want nothing", keys)
+	}
+	// Keys listed after the one read, within the byte budget
+	if keys := p.next("bucket", "b"); !slices.Equal(keys, []string{"c", "d"}) {
+		t.Errorf("next(b) = %v, want [c d]", keys)
+	}
+	// Only the first read from a listing prefetches
+	if keys := p.next("bucket", "c"); keys != nil {
+		t.Errorf("second read: next = %v, want nothing", keys)
+	}
+
+	p.listed("bucket", listOf(sizes, "a", "b", "c", "d"))
+	if keys := p.next("bucket", "a"); !slices.Equal(keys, []string{"b", "c"}) {
+		t.Errorf("next(a) = %v, want the 250-byte budget to stop at [b c]", keys)
+	}
+
+	p.listed("bucket", listOf(sizes, "a", "b"))
+	now = now.Add(listPrefetchWindow + time.Second)
+	if keys := p.next("bucket", "a"); keys != nil {
+		t.Errorf("next after the window = %v, want nothing", keys)
+	}
+}
+
+func TestLazyBackend_ListPrefetch(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, _ := setupTestBackends(t)
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	for _, key := range []string{"logs/1", "logs/2", "logs/3"} {
+		putString(t, awsBackend, key, "entry "+key)
+		if _, data := readObject(t, lazyBackend, key, nil); data != "entry "+key {
+			t.Fatalf("%s = %q", key, data)
+		}
+	}
+	lazyBackend.SetListPrefetch(2, 0)
+
+	list, err := lazyBackend.ListBucket("test-bucket", &gofakes3.Prefix{HasPrefix: true, Prefix: "logs/"}, gofakes3.ListBucketPage{})
+	if err != nil {
+		t.Fatalf("ListBucket: %v", err)
+	}
+	if len(list.Contents) != 3 {
+		t.Fatalf("listed %d keys, want 3", len(list.Contents))
+	}
+
+	// logs/3 is evicted after the listing; reading logs/1 brings it back
+	lazyBackend.evictEntries([]cacheEntry{{Bucket: "test-bucket", Key: "logs/3"}})
+	if _, err := localBackend.HeadObject("test-bucket", "logs/3"); err == nil {
+		t.Fatal("logs/3 still cached after evicting it")
+	}
+	readObject(t, lazyBackend, "logs/1", nil)
+	lazyBackend.listPrefetch.wait()
+	if _, err := localBackend.HeadObject("test-bucket", "logs/3"); err != nil {
+		t.Errorf("logs/3 not prefetched after reading logs/1: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// localData accounts for the objects clients write to each bucket, apart
+// from the objects cached from upstream that the cache index tracks, and
+// holds writes to a per-bucket quota: local data is never evicted, so
+// runaway test writes would otherwise fill the disk and have disk trimming
+// evict the cached dataset instead.
+type localData struct {
+	mu           sync.Mutex
+	sizes        map[entryKey]int64
+	buckets      map[string]*localUsage
+	quota        int64
+	bucketQuotas map[string]int64
+
+	// counting is set while the objects already stored are counted after
+	// startup; deleted holds keys deleted meanwhile, which the count must
+	// not add back
+	counting bool
+	deleted  map[entryKey]bool
+}
+
+// localUsage is the local data of one bucket.
+type localUsage struct {
+	objects int
+	bytes   int64
+	pending int64 // bytes of writes admitted but not yet stored
+}
+
+func newLocalData() *localData {
+	return &localData{sizes: make(map[entryKey]int64), buckets: make(map[string]*localUsage)}
+}
+
+// SetLocalQuota limits the total size of the objects clients write to each
+// bucket. perBucket overrides the limit for individual buckets. 0 means
+// unlimited.
+func (b *LazyBackend) SetLocalQuota(quota int64, perBucket map[string]int64) {
+	b.localData.mu.Lock()
+	defer b.localData.mu.Unlock()
+	b.localData.quota = quota
+	b.localData.bucketQuotas = maps.Clone(perBucket)
+}
+
+// quotaLocked returns a bucket's quota, 0 if it has none.
+func (d *localData) quotaLocked(bucketName string) int64 {
+	if quota, ok := d.bucketQuotas[bucketName]; ok {
+		return quota
+	}
+	return d.quota
+}
+
+func (d *localData) usageLocked(bucketName string) *localUsage {
+	u, ok := d.buckets[bucketName]
+	if !ok {
+		u = &localUsage{}
+		d.buckets[bucketName] = u
+	}
+	return u
+}
+
+// admit checks that writing size bytes to a key keeps its bucket within its
+// quota, and reserves the bytes until the returned function reports whether
+// the write stored them.
+func (d *localData) admit(bucketName, objectName string, size int64) (func(stored bool), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.checkLocked(bucketName, objectName, size); err != nil {
+		return nil, err
+	}
+	u := d.usageLocked(bucketName)
+	u.pending += size
+	return func(stored bool) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		u.pending -= size
+		if stored {
+			d.setLocked(entryKey{bucketName, objectName}, size)
+		}
+	}, nil
+}
+
+// check returns the error writing size bytes to a key fails with, as
+// admit, without reserving them.
+func (d *localData) check(bucketName, objectName string, size int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.checkLocked(bucketName, objectName, size)
+}
+
+// checkLocked counts what the key holds now as freed by the write.
+func (d *localData) checkLocked(bucketName, objectName string, size int64) error {
+	quota := d.quotaLocked(bucketName)
+	if quota <= 0 {
+		return nil
+	}
+	var used int64
+	if u, ok := d.buckets[bucketName]; ok {
+		used = u.bytes + u.pending
+	}
+	if after := used - d.sizes[entryKey{bucketName, objectName}] + size; after > quota {
+		return newFailure(ErrQuotaExceeded, "QuotaExceeded", nil,
+			"writing %d bytes to %s/%s would take the data written to bucket %s to %d bytes, past its local quota of %d bytes",
+			size, bucketName, objectName, bucketName, after, quota)
+	}
+	return nil
+}
+
+// checkBucket returns the error adding size bytes of new objects clients
+// wrote to a bucket fails with, such as a clone's.
+func (d *localData) checkBucket(bucketName string, size int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	quota := d.quotaLocked(bucketName)
+	if quota <= 0 {
+		return nil
+	}
+	var used int64
+	if u, ok := d.buckets[bucketName]; ok {
+		used = u.bytes + u.pending
+	}
+	if after := used + size; after > quota {
+		return newFailure(ErrQuotaExceeded, "QuotaExceeded", nil,
+			"adding %d bytes written by clients would take bucket %s to %d bytes, past its local quota of %d bytes",
+			size, bucketName, after, quota)
+	}
+	return nil
+}
+
+// set records a key clients wrote, replacing what it held.
+func (d *localData) set(bucketName, objectName string, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setLocked(entryKey{bucketName, objectName}, size)
+}
+
+func (d *localData) setLocked(k entryKey, size int64) {
+	u := d.usageLocked(k.bucket)
+	if old, ok := d.sizes[k]; ok {
+		u.objects--
+		u.bytes -= old
+	}
+	d.sizes[k] = size
+	u.objects++
+	u.bytes += size
+}
+
+// forget stops counting a key, after it was deleted or became a cached
+// object.
+func (d *localData) forget(bucketName, objectName string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	k := entryKey{bucketName, objectName}
+	if d.counting {
+		d.deleted[k] = true
+	}
+	size, ok := d.sizes[k]
+	if !ok {
+		return
+	}
+	delete(d.sizes, k)
+	u := d.buckets[bucketName]
+	u.objects--
+	u.bytes -= size
+}
+
+// forgetBucket stops counting a deleted bucket.
+func (d *localData) forgetBucket(bucketName string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k := range d.sizes {
+		if k.bucket == bucketName {
+			delete(d.sizes, k)
+		}
+	}
+	delete(d.buckets, bucketName)
+}
+
+// CountLocalData counts the objects clients wrote before startup: those in
+// the local backend that aren't cached from upstream. Snapshots aren't
+// counted. Writes and deletes meanwhile are accounted for as usual.
+func (b *LazyBackend) CountLocalData(ctx context.Context) error {
+	d := b.localData
+	d.mu.Lock()
+	d.counting, d.deleted = true, make(map[entryKey]bool)
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.counting, d.deleted = false, nil
+		d.mu.Unlock()
+	}()
+
+	buckets, err := b.local.ListBuckets()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		if isSnapshotBucket(bucket.Name) {
+			continue
+		}
+		for c, err := range localContents(b.local, bucket.Name, "") {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, cached := b.index.lookup(bucket.Name, c.Key); cached {
+				continue
+			}
+			k := entryKey{bucket.Name, c.Key}
+			d.mu.Lock()
+			if _, known := d.sizes[k]; !known && !d.deleted[k] {
+				d.setLocked(k, c.Size)
+			}
+			d.mu.Unlock()
+		}
+	}
+	objects, bytes := d.total()
+	log.Printf("[LOCAL DATA] %d object(s), %d bytes written by clients", objects, bytes)
+	return nil
+}
+
+// total returns the number and size of the objects clients wrote to every
+// bucket.
+func (d *localData) total() (int, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var objects int
+	var bytes int64
+	for _, u := range d.buckets {
+		objects += u.objects
+		bytes += u.bytes
+	}
+	return objects, bytes
+}
+
+// bucketDataUsage is one bucket's line in /admin/usage: what it cached from
+// upstream and what clients wrote to it, against its quota.
+type bucketDataUsage struct {
+	Bucket        string `json:"bucket"`
+	CachedObjects int    `json:"cached_objects"`
+	CachedBytes   int64  `json:"cached_bytes"`
+	LocalObjects  int    `json:"local_objects"`
+	LocalBytes    int64  `json:"local_bytes"`
+	LocalQuota    int64  `json:"local_quota,omitempty"`
+}
+
+// DataUsage returns the usage of every bucket holding cached or local
+// objects, sorted by name.
+func (b *LazyBackend) DataUsage() []bucketDataUsage {
+	usage := make(map[string]*bucketDataUsage)
+	of := func(bucketName string) *bucketDataUsage {
+		u, ok := usage[bucketName]
+		if !ok {
+			u = &bucketDataUsage{Bucket: bucketName}
+			usage[bucketName] = u
+		}
+		return u
+	}
+	b.index.mu.Lock()
+	for _, e := range b.index.entries {
+		u := of(e.Bucket)
+		u.CachedObjects++
+		u.CachedBytes += e.Size
+	}
+	b.index.mu.Unlock()
+
+	d := b.localData
+	d.mu.Lock()
+	for bucketName, local := range d.buckets {
+		if local.objects == 0 {
+			continue
+		}
+		u := of(bucketName)
+		u.LocalObjects, u.LocalBytes = local.objects, local.bytes
+	}
+	for bucketName, u := range usage {
+		u.LocalQuota = d.quotaLocked(bucketName)
+	}
+	d.mu.Unlock()
+
+	result := make([]bucketDataUsage, 0, len(usage))
+	for _, bucketName := range slices.Sorted(maps.Keys(usage)) {
+		result = append(result, *usage[bucketName])
+	}
+	return result
+}
+
+// quotaGuard refuses object PUTs that would take a bucket past its local
+// quota before their body is read, with a 403 rather than the 500 the
+// backend's error becomes, which SDKs would retry, sending the body again.
+// Copies and multipart uploads are held to the quota by the backend.
+func (b *LazyBackend) quotaGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, key, ok := objectPath(r.URL.Path)
+		if r.Method != http.MethodPut || !ok || r.Header.Get("X-Amz-Copy-Source") != "" || r.URL.Query().Has("uploadId") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		size := r.ContentLength
+		if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+			size, _ = strconv.ParseInt(decoded, 10, 64)
+		}
+		if err := b.localData.check(b.canonicalBucket(bucket), key, size); err != nil {
+			b.toggles.infof("[QUOTA] %s %s rejected: %v", r.Method, r.URL.Path, err)
+			writeRejection(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
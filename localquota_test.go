@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLocalQuota(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	lazyBackend.SetLocalQuota(10, map[string]int64{"scratch": 0})
+
+	// Cached objects don't count towards the quota
+	putString(t, awsBackend, "dataset.csv", strings.Repeat("x", 64))
+	readObject(t, lazyBackend, "dataset.csv", nil)
+
+	putString(t, lazyBackend, "a.txt", "123456")
+	_, err := lazyBackend.PutObject("test-bucket", "b.txt", nil, strings.NewReader("123456"), 6, nil)
+	if !errors.Is(err, ErrQuotaExceeded) || !gofakes3.HasErrorCode(err, "QuotaExceeded") {
+		t.Fatalf("PUT past the quota = %v, want QuotaExceeded", err)
+	}
+	if !strings.Contains(err.Error(), "to 12 bytes, past its local quota of 10 bytes") {
+		t.Errorf("error = %q, want the usage and quota", err)
+	}
+	// Replacing an object frees what it held
+	putString(t, lazyBackend, "a.txt", "123456789")
+	if _, err := lazyBackend.CopyObject("test-bucket", "dataset.csv", "test-bucket", "copy.csv", nil); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("copy past the quota = %v, want QuotaExceeded", err)
+	}
+	if _, err := lazyBackend.HeadObject("test-bucket", "dataset.csv"); err != nil {
+		t.Errorf("cached object gone after a rejected write: %v", err)
+	}
+
+	stats := lazyBackend.Stats()
+	if stats.Objects != 1 || stats.CacheBytes != 64 || stats.LocalObjects != 1 || stats.LocalBytes != 9 {
+		t.Errorf("stats = %d cached objects of %d bytes, %d local of %d bytes; want 1/64 and 1/9",
+			stats.Objects, stats.CacheBytes, stats.LocalObjects, stats.LocalBytes)
+	}
+	want := []bucketDataUsage{{Bucket: "test-bucket", CachedObjects: 1, CachedBytes: 64, LocalObjects: 1, LocalBytes: 9, LocalQuota: 10}}
+	if got := lazyBackend.DataUsage(); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("DataUsage() = %+v, want %+v", got, want)
+	}
+
+	if _, err := lazyBackend.DeleteObject("test-bucket", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, lazyBackend, "b.txt", "1234567890")
+
+	// An override of 0 lifts the quota
+	if err := lazyBackend.CreateBucket("scratch"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lazyBackend.PutObject("scratch", "big", nil, strings.NewReader(strings.Repeat("x", 100)), 100, nil); err != nil {
+		t.Errorf("PUT to a bucket without a quota: %v", err)
+	}
+}
+
+func TestLocalQuota_Guard(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	lazyBackend.SetLocalQuota(10, nil)
+	server := httptest.NewServer(lazyBackend.quotaGuard(awsChunkedDecoder(gofakes3.New(lazyBackend).Server())))
+	defer server.Close()
+	client := newEndpointClient(t, server.URL)
+
+	if _, err := client.PutObject(t.Context(), &s3.PutObjectInput{Bucket: aws.String("test-bucket"), Key: aws.String("small"), Body: strings.NewReader("fits")}); err != nil {
+		t.Fatalf("PUT within the quota: %v", err)
+	}
+	_, err := client.PutObject(t.Context(), &s3.PutObjectInput{Bucket: aws.String("test-bucket"), Key: aws.String("large"), Body: strings.NewReader("doesn't fit")})
+	var apiErr smithy.APIError
+	var respErr *awshttp.ResponseError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "QuotaExceeded" || !errors.As(err, &respErr) || respErr.HTTPStatusCode() != http.StatusForbidden {
+		t.Errorf("PUT past the quota = %v, want a 403 QuotaExceeded", err)
+	}
+}
+
+func TestCountLocalData(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, bucket := range []string{"test-bucket", "test-bucket@v1"} {
+		if err := localBackend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	// Left by an earlier run: one cached object and two written by clients
+	putString(t, localBackend, "cached.txt", "from upstream")
+	lazyBackend.index.add("test-bucket", "cached.txt", 13, "")
+	putString(t, localBackend, "a.txt", "alpha")
+	putString(t, localBackend, "b.txt", "bravo")
+	if _, err := localBackend.PutObject("test-bucket@v1", "a.txt", nil, strings.NewReader("alpha"), 5, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Writes since startup are counted already
+	putString(t, lazyBackend, "b.txt", "bravo!")
+
+	if err := lazyBackend.CountLocalData(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if objects, bytes := lazyBackend.localData.total(); objects != 2 || bytes != 11 {
+		t.Errorf("local data = %d object(s), %d bytes; want 2, 11", objects, bytes)
+	}
+	lazyBackend.SetLocalQuota(12, nil)
+	if _, err := lazyBackend.PutObject("test-bucket", "c.txt", nil, strings.NewReader("charlie"), 7, nil); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("PUT past the quota after counting = %v, want QuotaExceeded", err)
+	}
+}
+
+func TestLocalQuota_Clone(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "dataset.csv", strings.Repeat("x", 64))
+	readObject(t, lazyBackend, "dataset.csv", nil)
+	putString(t, lazyBackend, "a.txt", "123456")
+	lazyBackend.SetLocalQuota(10, map[string]int64{"test-bucket": 0})
+
+	// Cached objects are copied regardless; written ones count
+	if _, err := lazyBackend.CloneBucket("test-bucket", "experiment"); err != nil {
+		t.Fatalf("clone within the quota: %v", err)
+	}
+	if objects, bytes := lazyBackend.localData.total(); objects != 2 || bytes != 12 {
+		t.Errorf("local data = %d object(s), %d bytes; want 2, 12", objects, bytes)
+	}
+
+	putString(t, lazyBackend, "b.txt", "123456")
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/buckets/test-bucket/clone?to=experiment-2", nil))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "take bucket experiment-2 to 12 bytes, past its local quota of 10 bytes") {
+		t.Errorf("clone past the quota = %d %s, want a 403 naming the quota", rec.Code, rec.Body)
+	}
+	// Nothing is left behind, so the clone can be tried again
+	if exists, err := localBackend.BucketExists("experiment-2"); err != nil || exists {
+		t.Errorf("experiment-2 exists = %v, %v after a refused clone; want it gone", exists, err)
+	}
+	if _, err := lazyBackend.DeleteObject("test-bucket", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lazyBackend.CloneBucket("test-bucket", "experiment-2"); err != nil {
+		t.Errorf("clone retried within the quota: %v", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -20,8 +21,9 @@ import (
 // LocalStackBackend implements gofakes3.Backend by proxying to an S3-compatible
 // service like LocalStack. This allows using LocalStack as the local cache layer.
 type LocalStackBackend struct {
-	client *s3.Client
-	region string
+	client  *s3.Client
+	region  string
+	timeout time.Duration // of each operation, 0 for none
 }
 
 // NewLocalStackBackend creates a backend that talks to LocalStack or any S3-compatible service.
@@ -42,8 +44,19 @@ func NewLocalStackBackend(endpoint, region string) (*LocalStackBackend, error) {
 	return &LocalStackBackend{client: client, region: region}, nil
 }
 
-func (b *LocalStackBackend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
-	ctx := context.Background()
+// SetTimeout bounds how long each operation may take; 0 means no limit. A
+// GET's time includes reading its body.
+func (b *LocalStackBackend) SetTimeout(timeout time.Duration) {
+	b.timeout = timeout
+}
+
+// operation derives the context of one operation from ctx.
+func (b *LocalStackBackend) operation(ctx context.Context) (context.Context, context.CancelFunc) {
+	return opTimeouts{opLocalStack: b.timeout}.context(ctx, opLocalStack)
+}
+
+func (b *LocalStackBackend) GetObjectContext(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	ctx, cancel := b.operation(ctx)
 
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
@@ -63,14 +76,18 @@ func (b *LocalStackBackend) GetObject(bucketName, objectName string, rangeReques
 
 	obj, err := b.client.GetObject(ctx, input)
 	if err != nil {
+		cancel()
 		return nil, s3ErrorToGofakes3(err, bucketName, objectName)
 	}
 
-	return getOutputToObject(objectName, obj), nil
+	result := getOutputToObject(objectName, obj)
+	result.Contents = &cancelOnClose{ReadCloser: result.Contents, cancel: cancel}
+	return result, nil
 }
 
-func (b *LocalStackBackend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
-	ctx := context.Background()
+func (b *LocalStackBackend) HeadObjectContext(ctx context.Context, bucketName, objectName string) (*gofakes3.Object, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	obj, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucketName),
@@ -83,8 +100,9 @@ func (b *LocalStackBackend) HeadObject(bucketName, objectName string) (*gofakes3
 	return headOutputToObject(objectName, obj), nil
 }
 
-func (b *LocalStackBackend) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, meta map[string]string) (gofakes3.CopyObjectResult, error) {
-	ctx := context.Background()
+func (b *LocalStackBackend) CopyObjectContext(ctx context.Context, srcBucket, srcKey, dstBucket, dstKey string, meta map[string]string) (gofakes3.CopyObjectResult, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	_, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
 		Bucket:     aws.String(dstBucket),
@@ -98,29 +116,64 @@ func (b *LocalStackBackend) CopyObject(srcBucket, srcKey, dstBucket, dstKey stri
 	return gofakes3.CopyObjectResult{}, nil
 }
 
-func (b *LocalStackBackend) ListBuckets() ([]gofakes3.BucketInfo, error) {
-	ctx := context.Background()
+// ListBucketsContext lists every bucket, following continuation tokens in
+// case the service pages its answer.
+func (b *LocalStackBackend) ListBucketsContext(ctx context.Context) ([]gofakes3.BucketInfo, error) {
+	var buckets []gofakes3.BucketInfo
+	page := bucketPage{}
+	for {
+		list, err := b.ListBucketsPageContext(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, list.Buckets...)
+		if list.ContinuationToken == "" || list.ContinuationToken == page.ContinuationToken {
+			return buckets, nil
+		}
+		page.ContinuationToken = list.ContinuationToken
+	}
+}
+
+// ListBucketsPageContext passes a page request on to the service, whose
+// continuation tokens are handed to clients as they are.
+func (b *LocalStackBackend) ListBucketsPageContext(ctx context.Context, page bucketPage) (bucketList, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
-	result, err := b.client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	input := &s3.ListBucketsInput{}
+	if page.Prefix != "" {
+		input.Prefix = aws.String(page.Prefix)
+	}
+	if page.ContinuationToken != "" {
+		input.ContinuationToken = aws.String(page.ContinuationToken)
+	}
+	if page.MaxBuckets > 0 {
+		input.MaxBuckets = aws.Int32(int32(page.MaxBuckets))
+	}
+	result, err := b.client.ListBuckets(ctx, input)
 	if err != nil {
-		return nil, s3ErrorToGofakes3(err, "", "")
+		return bucketList{}, s3ErrorToGofakes3(err, "", "")
 	}
 
-	buckets := make([]gofakes3.BucketInfo, 0, len(result.Buckets))
+	list := bucketList{
+		Buckets:           make([]gofakes3.BucketInfo, 0, len(result.Buckets)),
+		ContinuationToken: aws.ToString(result.ContinuationToken),
+	}
 	for _, bucket := range result.Buckets {
 		if bucket.Name == nil || bucket.CreationDate == nil {
 			continue
 		}
-		buckets = append(buckets, gofakes3.BucketInfo{
+		list.Buckets = append(list.Buckets, gofakes3.BucketInfo{
 			Name:         *bucket.Name,
 			CreationDate: gofakes3.NewContentTime(*bucket.CreationDate),
 		})
 	}
-	return buckets, nil
+	return list, nil
 }
 
-func (b *LocalStackBackend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) (*gofakes3.ObjectList, error) {
-	ctx := context.Background()
+func (b *LocalStackBackend) ListBucketContext(ctx context.Context, name string, prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) (*gofakes3.ObjectList, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(name),
@@ -182,8 +235,9 @@ func (b *LocalStackBackend) ListBucket(name string, prefix *gofakes3.Prefix, pag
 	}, nil
 }
 
-func (b *LocalStackBackend) BucketExists(name string) (bool, error) {
-	ctx := context.Background()
+func (b *LocalStackBackend) BucketExistsContext(ctx context.Context, name string) (bool, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	_, err := b.client.HeadBucket(ctx, &s3.HeadBucketInput{
 		Bucket: aws.String(name),
@@ -199,8 +253,9 @@ func (b *LocalStackBackend) BucketExists(name string) (bool, error) {
 	return true, nil
 }
 
-func (b *LocalStackBackend) CreateBucket(name string) error {
-	ctx := context.Background()
+func (b *LocalStackBackend) CreateBucketContext(ctx context.Context, name string) error {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	input := &s3.CreateBucketInput{
 		Bucket: aws.String(name),
@@ -219,8 +274,9 @@ func (b *LocalStackBackend) CreateBucket(name string) error {
 	return s3ErrorToGofakes3(err, name, "")
 }
 
-func (b *LocalStackBackend) DeleteBucket(name string) error {
-	ctx := context.Background()
+func (b *LocalStackBackend) DeleteBucketContext(ctx context.Context, name string) error {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	_, err := b.client.DeleteBucket(ctx, &s3.DeleteBucketInput{
 		Bucket: aws.String(name),
@@ -228,8 +284,9 @@ func (b *LocalStackBackend) DeleteBucket(name string) error {
 	return s3ErrorToGofakes3(err, name, "")
 }
 
-func (b *LocalStackBackend) ForceDeleteBucket(name string) error {
-	ctx := context.Background()
+func (b *LocalStackBackend) ForceDeleteBucketContext(ctx context.Context, name string) error {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	// First, delete all objects in the bucket
 	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
@@ -269,8 +326,9 @@ func (b *LocalStackBackend) ForceDeleteBucket(name string) error {
 	return s3ErrorToGofakes3(err, name, "")
 }
 
-func (b *LocalStackBackend) PutObject(bucketName, objectName string, meta map[string]string, input io.Reader, size int64, conditions *gofakes3.PutConditions) (gofakes3.PutObjectResult, error) {
-	ctx := context.Background()
+func (b *LocalStackBackend) PutObjectContext(ctx context.Context, bucketName, objectName string, meta map[string]string, input io.Reader, size int64, conditions *gofakes3.PutConditions) (gofakes3.PutObjectResult, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	// Read all data (S3 client needs the full body)
 	data, err := io.ReadAll(input)
@@ -302,8 +360,9 @@ func (b *LocalStackBackend) PutObject(bucketName, objectName string, meta map[st
 	}, nil
 }
 
-func (b *LocalStackBackend) DeleteObject(bucketName, objectName string) (gofakes3.ObjectDeleteResult, error) {
-	ctx := context.Background()
+func (b *LocalStackBackend) DeleteObjectContext(ctx context.Context, bucketName, objectName string) (gofakes3.ObjectDeleteResult, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(bucketName),
@@ -312,8 +371,9 @@ func (b *LocalStackBackend) DeleteObject(bucketName, objectName string) (gofakes
 	return gofakes3.ObjectDeleteResult{}, s3ErrorToGofakes3(err, bucketName, objectName)
 }
 
-func (b *LocalStackBackend) DeleteMulti(bucketName string, objects ...string) (gofakes3.MultiDeleteResult, error) {
-	ctx := context.Background()
+func (b *LocalStackBackend) DeleteMultiContext(ctx context.Context, bucketName string, objects ...string) (gofakes3.MultiDeleteResult, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
 
 	var objectIds []s3types.ObjectIdentifier
 	for _, key := range objects {
@@ -332,6 +392,56 @@ func (b *LocalStackBackend) DeleteMulti(bucketName string, objects ...string) (g
 	return gofakes3.MultiDeleteResult{}, s3ErrorToGofakes3(err, bucketName, "")
 }
 
+// The gofakes3.Backend methods run each request under a fresh context.
+
+func (b *LocalStackBackend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	return b.GetObjectContext(context.Background(), bucketName, objectName, rangeRequest)
+}
+
+func (b *LocalStackBackend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
+	return b.HeadObjectContext(context.Background(), bucketName, objectName)
+}
+
+func (b *LocalStackBackend) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, meta map[string]string) (gofakes3.CopyObjectResult, error) {
+	return b.CopyObjectContext(context.Background(), srcBucket, srcKey, dstBucket, dstKey, meta)
+}
+
+func (b *LocalStackBackend) ListBuckets() ([]gofakes3.BucketInfo, error) {
+	return b.ListBucketsContext(context.Background())
+}
+
+func (b *LocalStackBackend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) (*gofakes3.ObjectList, error) {
+	return b.ListBucketContext(context.Background(), name, prefix, page)
+}
+
+func (b *LocalStackBackend) BucketExists(name string) (bool, error) {
+	return b.BucketExistsContext(context.Background(), name)
+}
+
+func (b *LocalStackBackend) CreateBucket(name string) error {
+	return b.CreateBucketContext(context.Background(), name)
+}
+
+func (b *LocalStackBackend) DeleteBucket(name string) error {
+	return b.DeleteBucketContext(context.Background(), name)
+}
+
+func (b *LocalStackBackend) ForceDeleteBucket(name string) error {
+	return b.ForceDeleteBucketContext(context.Background(), name)
+}
+
+func (b *LocalStackBackend) PutObject(bucketName, objectName string, meta map[string]string, input io.Reader, size int64, conditions *gofakes3.PutConditions) (gofakes3.PutObjectResult, error) {
+	return b.PutObjectContext(context.Background(), bucketName, objectName, meta, input, size, conditions)
+}
+
+func (b *LocalStackBackend) DeleteObject(bucketName, objectName string) (gofakes3.ObjectDeleteResult, error) {
+	return b.DeleteObjectContext(context.Background(), bucketName, objectName)
+}
+
+func (b *LocalStackBackend) DeleteMulti(bucketName string, objects ...string) (gofakes3.MultiDeleteResult, error) {
+	return b.DeleteMultiContext(context.Background(), bucketName, objects...)
+}
+
 // s3ErrorCode extracts the S3 error code from an AWS SDK error.
 // Returns empty string if the error doesn't have an error code.
 func s3ErrorCode(err error) string {
@@ -484,6 +484,44 @@ func TestLocalStackBackend_ListBuckets(t *testing.T) {
 	}
 }
 
+func TestLocalStackBackend_ListBucketsPage(t *testing.T) {
+	tc := setupLocalStack(t)
+	defer tc.teardown(t)
+
+	backend := tc.newBackend(t, "us-east-1")
+	for _, name := range []string{"page-test-a", "page-test-b", "page-test-c", "other-bucket"} {
+		if err := backend.CreateBucket(name); err != nil {
+			t.Fatalf("CreateBucket %s failed: %v", name, err)
+		}
+		defer backend.DeleteBucket(name)
+	}
+
+	var names []string
+	page := bucketPage{Prefix: "page-test-", MaxBuckets: 2}
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("ListBucketsPage never returned the last page")
+		}
+		list, err := backend.ListBucketsPageContext(tc.ctx, page)
+		if err != nil {
+			t.Fatalf("ListBucketsPage failed: %v", err)
+		}
+		if len(list.Buckets) > 2 {
+			t.Errorf("page of %d buckets, want at most 2", len(list.Buckets))
+		}
+		for _, b := range list.Buckets {
+			names = append(names, b.Name)
+		}
+		if list.ContinuationToken == "" {
+			break
+		}
+		page.ContinuationToken = list.ContinuationToken
+	}
+	if len(names) != 3 || names[0] != "page-test-a" || names[2] != "page-test-c" {
+		t.Errorf("listed %v, want the three page-test buckets", names)
+	}
+}
+
 func TestLocalStackBackend_GetObject_RangeRequest(t *testing.T) {
 	tc := setupLocalStack(t)
 	defer tc.teardown(t)
@@ -1,34 +1,100 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/johannesboyne/gofakes3"
-	"github.com/johannesboyne/gofakes3/backend/s3afero"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
-	"github.com/spf13/afero"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:], os.Stdout); err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(os.Stderr, "bench:", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "clone" {
+		if err := runClone(os.Args[2:], os.Stdout); err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(os.Stderr, "clone:", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		if err := runSnapshot(os.Args[2:], os.Stdout); err != nil {
+			if !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintln(os.Stderr, "snapshot:", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Load configuration
-	cfg := LoadConfig()
+	cfg, err := LoadConfig()
+	if err != nil {
+		fatalConfig("Invalid configuration:\n%v", err)
+	}
 
 	log.Printf("s3lazy starting with backend=%s", cfg.BackendType)
+	logEffectiveConfig(cfg)
 
 	// Create AWS client for upstream (real AWS)
 	awsClient, err := createAWSClient(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create AWS client: %v", err)
 	}
+	for bucket, chain := range cfg.BucketFallbacks {
+		log.Printf("Keys missing from upstream bucket %s are looked up in %s", bucket, strings.Join(chain, ", "))
+	}
+	if cfg.HedgeDelay > 0 {
+		log.Printf("GETs of objects up to %d bytes are hedged after %v", cfg.HedgeMaxBytes, cfg.HedgeDelay)
+	}
+	if cfg.UpstreamCircuitFailures > 0 {
+		log.Printf("Upstream requests stop for %v after %d failure(s) in a row", cfg.UpstreamCircuitCooldown, cfg.UpstreamCircuitFailures)
+	}
+	awsClient = wrapUpstreamClient(cfg, awsClient, BucketUpstream{})
+
+	// The mock upstream's buckets are created locally to cache them
+	if cfg.MockUpstream != "" {
+		buckets, err := mockUpstreamBuckets(cfg.MockUpstream)
+		if err != nil {
+			log.Fatalf("Failed to read mock upstream: %v", err)
+		}
+		for _, bucket := range buckets {
+			if !slices.Contains(cfg.initBucketNames(), bucket) {
+				cfg.InitBuckets = append(cfg.InitBuckets, InitBucket{Name: bucket})
+			}
+		}
+	}
 
 	// Create local backend based on configuration
 	localBackend, err := createLocalBackend(cfg)
@@ -38,6 +104,62 @@ func main() {
 
 	// Wrap with lazy-loading
 	lazyBackend := NewLazyBackend(localBackend, awsClient)
+	if err := lazyBackend.SetUpstreamQuirks(cfg.UpstreamQuirks); err != nil {
+		fatalConfig("Invalid upstream quirks mode: %v", err)
+	}
+
+	lazyBackend.SetPrefixStatsDepth(cfg.PrefixStatsDepth)
+	lazyBackend.SetEventLogKeys(cfg.EventLogKeys)
+	if err := lazyBackend.SetSLOs(cfg.SLOs, cfg.SLOWindow); err != nil {
+		fatalConfig("Invalid SLO: %v", err)
+	}
+	if len(cfg.SLOs) > 0 {
+		log.Printf("Checking %d SLO(s) over a rolling %v window", len(cfg.SLOs), cfg.SLOWindow)
+	}
+	if cfg.IdentityHeader != "" {
+		log.Printf("Identifying clients by the %s header", cfg.IdentityHeader)
+		lazyBackend.SetIdentityHeader(cfg.IdentityHeader)
+	}
+	lazyBackend.SetCacheTTL(cfg.CacheTTL, cfg.BucketTTLs)
+	lazyBackend.SetTTLJitter(cfg.TTLJitterPercent)
+	if cfg.TTLFromHeaders {
+		log.Printf("Objects expire as their Cache-Control or Expires header says, within %s and %s", cfg.TTLFromHeadersMin, cfg.TTLFromHeadersMax)
+	}
+	lazyBackend.SetHeaderTTL(cfg.TTLFromHeaders, cfg.TTLFromHeadersMin, cfg.TTLFromHeadersMax)
+	lazyBackend.SetRevalidate(cfg.Revalidate)
+	lazyBackend.SetRefreshAhead(cfg.RefreshAhead, cfg.RefreshAheadMinHits)
+	lazyBackend.SetOperationTimeouts(cfg.OperationTimeouts)
+	if err := lazyBackend.SetPins(cfg.Pins); err != nil {
+		fatalConfig("Invalid pin: %v", err)
+	}
+	if len(cfg.CacheAllowBuckets) > 0 || len(cfg.CacheDenyBuckets) > 0 {
+		lazyBackend.SetCachePolicy(cfg.CacheAllowBuckets, cfg.CacheDenyBuckets)
+		log.Printf("Cache policy: allow %v, deny %v; other buckets are proxy-only", cfg.CacheAllowBuckets, cfg.CacheDenyBuckets)
+	}
+	if len(cfg.NoCachePatterns) > 0 {
+		if err := lazyBackend.SetNoCachePatterns(cfg.NoCachePatterns); err != nil {
+			fatalConfig("Invalid no-cache pattern: %v", err)
+		}
+		log.Printf("Keys matching %v are proxy-only", cfg.NoCachePatterns)
+	}
+	if len(cfg.RedactPatterns) > 0 {
+		redactor, err := newRegexRedactor(cfg.RedactPatterns, cfg.RedactAction)
+		if err != nil {
+			fatalConfig("Invalid redaction settings: %v", err)
+		}
+		lazyBackend.SetRedactor(redactor)
+		log.Printf("Redacting %d pattern(s) from cached objects", len(cfg.RedactPatterns))
+	}
+	if err := lazyBackend.SetTagRules(cfg.TagRules); err != nil {
+		fatalConfig("Invalid tag rule: %v", err)
+	}
+	if err := lazyBackend.SetTrafficSchedule(cfg.TrafficSchedule); err != nil {
+		fatalConfig("Invalid traffic schedule: %v", err)
+	}
+	if cfg.ChaosListDelay > 0 {
+		log.Printf("Chaos: new objects are hidden from listings for %s", cfg.ChaosListDelay)
+		lazyBackend.SetListLag(cfg.ChaosListDelay)
+	}
 
 	// Set bucket mappings
 	if len(cfg.BucketMappings) > 0 {
@@ -45,45 +167,437 @@ func main() {
 		log.Printf("Configured %d bucket mapping(s)", len(cfg.BucketMappings))
 	}
 
+	if len(cfg.BucketAliases) > 0 {
+		lazyBackend.SetBucketAliases(cfg.BucketAliases)
+		log.Printf("Configured %d bucket alias(es)", len(cfg.BucketAliases))
+	}
+	if len(cfg.ReadOnlyBuckets) > 0 {
+		lazyBackend.SetReadOnlyBuckets(cfg.ReadOnlyBuckets)
+		log.Printf("Read-only buckets: %v", cfg.ReadOnlyBuckets)
+	}
+
+	// Set URL sources
+	if len(cfg.URLSources) > 0 {
+		if err := lazyBackend.SetURLSources(cfg.URLSources, cfg.URLSourceRevalidate); err != nil {
+			fatalConfig("Invalid URL source: %v", err)
+		}
+		log.Printf("Configured %d URL source(s)", len(cfg.URLSources))
+	}
+	lazyBackend.SetUpstreamIdentity(upstreamIdentity(cfg))
+	for bucket, u := range cfg.BucketUpstreams {
+		client, err := createUpstreamClient(cfg, u)
+		if err != nil {
+			fatalConfig("Failed to create upstream client for bucket %s: %v", bucket, err)
+		}
+		lazyBackend.SetBucketUpstream(bucket, wrapUpstreamClient(cfg, client, u), bucketUpstreamIdentity(cfg, u))
+		log.Printf("Bucket %s is fetched from its own upstream (%s)", bucket, u.describe())
+	}
+
+	if cfg.PresignedUploads {
+		client, ok := s3ClientOf(awsClient)
+		if !ok {
+			fatalConfig("Pre-signed uploads need an S3 upstream")
+		}
+		lazyBackend.SetPresignedUploads(client, cfg.PresignedUploadExpiry)
+		log.Printf("Clients can upload straight to upstream with pre-signed URLs valid for %v", cfg.PresignedUploadExpiry)
+	}
+
+	// Background jobs run until shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+
+	// Coordinate background jobs between replicas sharing the data dir. The
+	// election outlives the other background jobs, so the lease is held
+	// until the state is saved on shutdown
+	var elector *leaderElector
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	electionStopped := make(chan struct{})
+	if cfg.SharedDataDir {
+		instanceID := cfg.InstanceID
+		if instanceID == "" {
+			instanceID = defaultInstanceID(cfg.ListenAddr)
+		}
+		elector, err = newLeaderElector(filepath.Join(cfg.DataDir, "s3lazy"), instanceID, defaultLeaseTTL)
+		if err != nil {
+			log.Fatalf("Failed to set up leader election: %v", err)
+		}
+		log.Printf("Shared data dir: instance %s competing for leadership", instanceID)
+		go func() {
+			defer close(electionStopped)
+			elector.run(electionCtx)
+		}()
+	} else {
+		close(electionStopped)
+	}
+
+	if err := lazyBackend.SetEvictionPolicy(cfg.EvictionPolicy); err != nil {
+		fatalConfig("Invalid eviction policy: %v", err)
+	}
+
+	// Track cached objects for eviction, persisting the index next to the
+	// data so a restart doesn't orphan entries that were cached before it
+	var indexPath string
+	if cfg.usesBackend("disk") || cfg.usesBackend("memory") && cfg.MemorySnapshotInterval > 0 {
+		indexPath = filepath.Join(cfg.DataDir, "s3lazy", "index.json")
+		if err := lazyBackend.index.load(indexPath); err != nil {
+			if cfg.Strict {
+				log.Fatalf("Failed to load cache index %s: %v", indexPath, err)
+			}
+			log.Printf("Warning: couldn't load cache index %s: %v", indexPath, err)
+		}
+		lazyBackend.DropForeignEntries()
+		ledgerPath := filepath.Join(cfg.DataDir, "s3lazy", "residency.json")
+		if err := lazyBackend.ledger.load(ledgerPath); err != nil {
+			if cfg.Strict {
+				log.Fatalf("Failed to load residency ledger %s: %v", ledgerPath, err)
+			}
+			log.Printf("Warning: couldn't load residency ledger %s: %v", ledgerPath, err)
+		}
+		clonesPath := filepath.Join(cfg.DataDir, "s3lazy", "clones.json")
+		if err := lazyBackend.LoadClones(clonesPath); err != nil {
+			if cfg.Strict {
+				log.Fatalf("Failed to load clones %s: %v", clonesPath, err)
+			}
+			log.Printf("Warning: couldn't load clones %s: %v", clonesPath, err)
+		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runLeaderJob(bgCtx, elector, "cache index save", indexSaveInterval, func() {
+				if err := lazyBackend.index.save(indexPath); err != nil {
+					log.Printf("Warning: couldn't save cache index: %v", err)
+				}
+				if err := lazyBackend.ledger.save(); err != nil {
+					log.Printf("Warning: couldn't save residency ledger: %v", err)
+				}
+			})
+		}()
+	}
+	// Reconcile the index with the disk after a crash or files deleted by
+	// hand. Replicas sharing the data dir may be writing to it meanwhile, so
+	// it is left alone there.
+	if cfg.usesBackend("disk") && !cfg.SharedDataDir {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			result, err := lazyBackend.CollectDiskGarbage(bgCtx, cfg.DataDir)
+			if err != nil && bgCtx.Err() == nil {
+				log.Printf("Warning: startup garbage collection failed: %v", err)
+			}
+			log.Printf("[GC] dropped %d index entries of missing objects, removed %d orphaned metadata and %d temp file(s)",
+				result.MissingEntries, result.OrphanedMetadata, result.TempFiles)
+		}()
+	}
+	snapshot := findMemorySnapshot(localBackend)
+	if snapshot != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runLeaderJob(bgCtx, elector, "memory snapshot", cfg.MemorySnapshotInterval, func() {
+				if _, err := snapshot.save(); err != nil {
+					log.Printf("Warning: couldn't save memory snapshot: %v", err)
+				}
+			})
+		}()
+	}
+	if cfg.CacheMaxBytes > 0 {
+		log.Printf("Cache limited to %d bytes (%s eviction)", cfg.CacheMaxBytes, cfg.EvictionPolicy)
+	}
+	lazyBackend.SetCacheMaxBytes(int64(cfg.CacheMaxBytes))
+	if cfg.MaxObjectsPerBucket > 0 || len(cfg.BucketMaxObjects) > 0 {
+		log.Printf("Cache limited to %d objects per bucket, overridden for %d buckets (%s eviction)", cfg.MaxObjectsPerBucket, len(cfg.BucketMaxObjects), cfg.EvictionPolicy)
+	}
+	lazyBackend.SetCacheMaxObjects(cfg.MaxObjectsPerBucket, cfg.BucketMaxObjects)
+	if cfg.LocalQuota > 0 || len(cfg.BucketLocalQuotas) > 0 {
+		log.Printf("Data written by clients limited to %d bytes per bucket, overridden for %d buckets", cfg.LocalQuota, len(cfg.BucketLocalQuotas))
+	}
+	quotas := make(map[string]int64, len(cfg.BucketLocalQuotas))
+	for bucket, quota := range cfg.BucketLocalQuotas {
+		quotas[bucket] = int64(quota)
+	}
+	lazyBackend.SetLocalQuota(int64(cfg.LocalQuota), quotas)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		if err := lazyBackend.CountLocalData(bgCtx); err != nil && bgCtx.Err() == nil {
+			log.Printf("Warning: couldn't count the data written by clients: %v", err)
+		}
+	}()
+	if cfg.DiskHighWatermark > 0 {
+		high, low := int64(cfg.DiskHighWatermark), cfg.diskLowWatermark()
+		log.Printf("Disk usage trimmed to %d bytes once above %d bytes", low, high)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runLeaderJob(bgCtx, elector, "disk trim", diskCheckInterval, func() {
+				if _, err := lazyBackend.TrimDisk(cfg.DataDir, high, low); err != nil {
+					log.Printf("Warning: couldn't measure disk usage: %v", err)
+				}
+			})
+		}()
+	}
+	if cfg.CacheEventWebhook != "" {
+		hook := newWebhook(cfg.CacheEventWebhook)
+		lazyBackend.OnCacheEvent(hook.send)
+		log.Printf("Cache events posted to %s", redactURL(cfg.CacheEventWebhook))
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			hook.run(bgCtx)
+		}()
+	}
+	for _, raw := range cfg.EventSinks {
+		sink, err := newEventSink(raw)
+		if err != nil {
+			fatalConfig("Failed to set up event sink: %v", err)
+		}
+		lazyBackend.OnCacheEvent(sink.sendCache)
+		lazyBackend.OnAudit(sink.sendAudit)
+		log.Printf("Cache and audit events published to %s", sink.name)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			sink.run(bgCtx)
+		}()
+	}
+
+	if cfg.ReportBucket != "" {
+		client, ok := s3ClientOf(awsClient)
+		if !ok {
+			fatalConfig("Cache reports need an S3 upstream")
+		}
+		instanceID := cfg.InstanceID
+		if instanceID == "" {
+			instanceID = defaultInstanceID(cfg.ListenAddr)
+		}
+		reporter := newCacheReporter(client, cfg.ReportBucket, cfg.ReportPrefix, instanceID)
+		log.Printf("Cache reports written to %s/%s%s/ every %s", cfg.ReportBucket, cfg.ReportPrefix, instanceID, cfg.ReportInterval)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runLeaderJob(bgCtx, elector, "cache report", cfg.ReportInterval, func() {
+				if _, err := lazyBackend.WriteReport(bgCtx, reporter); err != nil {
+					log.Printf("Warning: couldn't write cache report: %v", err)
+				}
+			})
+		}()
+	}
+	if cfg.VerifyUploads {
+		lazyBackend.SetUploadVerifier(newUploadVerifier())
+		log.Printf("Uploads to upstream are verified after they finish")
+	}
+	if cfg.BackupBucket != "" {
+		client, ok := s3ClientOf(awsClient)
+		if !ok {
+			fatalConfig("Backups need an S3 upstream")
+		}
+		instanceID := cfg.InstanceID
+		if instanceID == "" {
+			instanceID = defaultInstanceID(cfg.ListenAddr)
+		}
+		backup := newLocalBackup(client, cfg.BackupBucket, cfg.BackupPrefix, instanceID)
+		log.Printf("Objects written by clients backed up to %s/%s%s/ every %s", cfg.BackupBucket, cfg.BackupPrefix, instanceID, cfg.BackupInterval)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runLeaderJob(bgCtx, elector, "backup", cfg.BackupInterval, func() {
+				if _, err := lazyBackend.BackUpLocalObjects(bgCtx, backup); err != nil && bgCtx.Err() == nil {
+					log.Printf("Warning: couldn't back up local objects: %v", err)
+				}
+			})
+		}()
+	}
+	if cfg.TeamCacheBucket != "" {
+		client, ok := s3ClientOf(awsClient)
+		if !ok {
+			fatalConfig("A team cache needs an S3 upstream")
+		}
+		lazyBackend.SetTeamCache(newTeamCache(client, cfg.TeamCacheBucket, cfg.TeamCachePrefix, cfg.TeamCacheRegion))
+		log.Printf("Uncached objects are looked up in team cache %s/%s before the origin", cfg.TeamCacheBucket, cfg.TeamCachePrefix)
+	}
+	if cfg.RefreshAhead > 0 {
+		log.Printf("Objects with %d+ hits are refreshed %s before they expire", cfg.RefreshAheadMinHits, cfg.RefreshAhead)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runLeaderJob(bgCtx, elector, "refresh-ahead", refreshAheadInterval(cfg.RefreshAhead), func() {
+				lazyBackend.RefreshAhead(bgCtx)
+			})
+		}()
+	}
+	lazyBackend.SetPackStore(findPackStore(localBackend))
+	if cfg.PackCompactInterval > 0 {
+		log.Printf("Pack files compacted every %s", cfg.PackCompactInterval)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runLeaderJob(bgCtx, elector, "pack compaction", cfg.PackCompactInterval, func() {
+				if _, err := lazyBackend.packs.compact(packCompactMinDead); err != nil {
+					log.Printf("Warning: couldn't compact packs: %v", err)
+				}
+			})
+		}()
+	}
+	lazyBackend.SetFollowInterval(cfg.FollowInterval)
+	if len(cfg.KeyFilterBuckets) > 0 {
+		log.Printf("Keys missing upstream in %d bucket(s) are answered from key filters, rebuilt every %s", len(cfg.KeyFilterBuckets), cfg.KeyFilterRefresh)
+		lazyBackend.SetKeyFilters(cfg.KeyFilterBuckets)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			lazyBackend.RefreshKeyFilters(bgCtx)
+			// Every replica keeps its own filters in memory
+			runLeaderJob(bgCtx, nil, "key filter refresh", cfg.KeyFilterRefresh, func() {
+				lazyBackend.RefreshKeyFilters(bgCtx)
+			})
+		}()
+	}
+	if cfg.StreamThreshold > 0 {
+		log.Printf("Objects over %d bytes are streamed without caching", cfg.StreamThreshold)
+		lazyBackend.SetStreamThreshold(int64(cfg.StreamThreshold))
+	}
+	if cfg.ChunkSize > 0 {
+		var chunkDir string
+		// Chunks are stored unencrypted, so with encryption on they are
+		// kept in memory
+		if cfg.usesBackend("disk") && !cfg.encrypts() {
+			chunkDir = filepath.Join(cfg.DataDir, "s3lazy", "chunks")
+		}
+		if err := lazyBackend.SetChunkedRanges(int64(cfg.ChunkSize), int64(cfg.ChunkCacheMaxBytes), chunkDir); err != nil {
+			log.Fatalf("Failed to set up chunked range caching: %v", err)
+		}
+		log.Printf("Range reads cache %d-byte chunks", cfg.ChunkSize)
+	}
+	if cfg.RangePassthrough {
+		log.Printf("Uncached range reads are forwarded to upstream (background fill: %v)", cfg.RangeBackgroundFill)
+		lazyBackend.SetRangePassthrough(true, cfg.RangeBackgroundFill)
+	}
+	if cfg.ListPrefetchConcurrency > 0 {
+		log.Printf("Listed keys are prefetched once clients start reading them (%d at a time, %d bytes per page)", cfg.ListPrefetchConcurrency, cfg.ListPrefetchMaxBytes)
+		lazyBackend.SetListPrefetch(cfg.ListPrefetchConcurrency, int64(cfg.ListPrefetchMaxBytes))
+	}
+
 	// Initialize buckets
-	for _, bucket := range cfg.InitBuckets {
-		if err := lazyBackend.CreateBucket(bucket); err != nil {
-			log.Printf("Warning: couldn't create bucket %s: %v", bucket, err)
-		} else {
-			log.Printf("Created bucket: %s", bucket)
+	if err := createInitBuckets(lazyBackend, cfg.initBucketNames()); err != nil && cfg.Strict {
+		log.Fatalf("Failed to create init buckets:\n%v", err)
+	}
+
+	// Warm the cache from the manifest in the background, optionally holding
+	// readiness until it completes
+	ready := &readiness{}
+	ready.setReady()
+	var entries []warmEntry
+	if cfg.WarmManifest != "" {
+		entries, err = loadWarmManifest(cfg.WarmManifest)
+		if errors.Is(err, errWarmManifestSyntax) {
+			fatalConfig("Invalid warm manifest: %v", err)
+		} else if err != nil {
+			log.Fatalf("Failed to load warm manifest: %v", err)
+		}
+		if cfg.ReadyAfterWarm {
+			ready.setNotReady(fmt.Sprintf("warming %d object(s)", len(entries)))
 		}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			log.Printf("[WARM] warming %d object(s) from %s", len(entries), cfg.WarmManifest)
+			warmed, failed := lazyBackend.Warm(entries)
+			log.Printf("[WARM] complete: %d cached, %d failed", warmed, failed)
+			ready.setReady()
+		}()
+	}
+
+	// Prefetch whole upstream prefixes in the background
+	for _, prefix := range cfg.Prefetch {
+		bucket, keyPrefix, _ := strings.Cut(prefix, "/")
+		lazyBackend.Prefetch(bgCtx, bucket, keyPrefix, cfg.PrefetchConcurrency)
+	}
+
+	// Watch for LocalStack restarts, which silently drop every bucket
+	if ls := findLocalStack(localBackend); ls != nil {
+		watcher := &localStackWatcher{backend: ls}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			watcher.run(bgCtx, func(lost []string) {
+				for _, bucket := range lost {
+					lazyBackend.index.removeBucket(bucket)
+				}
+				if !cfg.LocalStackReseed {
+					log.Printf("[LOCALSTACK] set S3LAZY_LOCALSTACK_RESEED=true to re-create buckets automatically")
+					return
+				}
+				_ = createInitBuckets(lazyBackend, cfg.initBucketNames())
+				rewarm := append(lazyBackend.pins.exactKeys(), entries...)
+				if len(rewarm) > 0 {
+					warmed, failed := lazyBackend.Warm(rewarm)
+					log.Printf("[LOCALSTACK] re-warmed %d object(s), %d failed", warmed, failed)
+				}
+			})
+		}()
 	}
 
 	// Create gofakes3 server
 	faker := gofakes3.New(lazyBackend,
-		gofakes3.WithLogger(gofakes3.StdLog(log.Default())),
+		gofakes3.WithLogger(lazyBackend.toggles),
 	)
 
-	// Create HTTP server with health check
+	// Create HTTP server with health and readiness checks
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
-	mux.Handle("/", faker.Server())
+	mux.HandleFunc("/readyz", ready.readyzHandler)
+	mux.Handle("/", lazyBackend.identityLogger(lazyBackend.toggles.readOnlyGuard(lazyBackend.quotaGuard(lazyBackend.expectContinueGuard(lazyBackend.followGuard(lazyBackend.bypassGuard(lazyBackend.conditionalGuard(lazyBackend.cacheHeaders(lazyBackend.listBucketsPaging(awsChunkedDecoder(faker.Server())))))))))))
+
+	server := newServer(cfg, mux)
+	listener, err := listen(cfg)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.ListenAddr, err)
+	}
 
-	server := &http.Server{
-		Addr:    cfg.ListenAddr,
-		Handler: mux,
+	// The admin API gets its own listener, on loopback unless configured
+	// otherwise, so S3 clients can't reach it and it can't hide a bucket
+	// named admin
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/health", healthHandler)
+	adminMux.HandleFunc("/readyz", ready.readyzHandler)
+	adminMux.Handle("/admin/", newAdminHandler(lazyBackend, cfg))
+	adminServer := &http.Server{
+		Addr:              cfg.AdminListenAddr,
+		Handler:           adminMux,
+		ReadHeaderTimeout: cfg.ListenHeaderTimeout,
+		IdleTimeout:       cfg.ListenIdleTimeout,
+	}
+	adminListener, err := net.Listen("tcp", cfg.AdminListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", cfg.AdminListenAddr, err)
 	}
+	go func() {
+		if err := adminServer.Serve(adminListener); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Admin server failed: %v", err)
+		}
+	}()
 
 	// Graceful shutdown handling
-	done := make(chan bool)
+	done := make(chan struct{})
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	var deadline time.Time
 	go func() {
 		<-quit
 		log.Println("Shutting down server...")
+		notifyState("STOPPING=1")
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// In-flight requests, then fetches still running in the
+		// background, get the grace period between them
+		deadline = time.Now().Add(cfg.ShutdownGracePeriod)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
 			log.Fatalf("Server forced to shutdown: %v", err)
 		}
+		_ = adminServer.Shutdown(ctx)
 
 		close(done)
 	}()
@@ -91,40 +605,225 @@ func main() {
 	// Start server
 	log.Printf("Starting lazy-loading S3 proxy on %s", cfg.ListenAddr)
 	log.Printf("Backend type: %s", cfg.BackendType)
-	switch cfg.BackendType {
-	case "disk":
+	if cfg.usesBackend("disk") {
 		log.Printf("Data directory: %s", cfg.DataDir)
-	case "localstack":
+	}
+	if cfg.usesBackend("localstack") {
 		log.Printf("LocalStack endpoint: %s", cfg.LocalStackEndpoint)
 	}
 	log.Printf("Health check: http://localhost%s/health", cfg.ListenAddr)
+	log.Printf("Admin API on %s", cfg.AdminListenAddr)
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if cfg.ListenMaxConnections > 0 {
+		log.Printf("Serving at most %d connection(s) at a time", cfg.ListenMaxConnections)
+	}
+	ready.setListening()
+	if interval, ok := watchdogInterval(); ok {
+		go runWatchdog(interval, done)
+	}
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed: %v", err)
 	}
 
 	<-done
+	stopBackground()
+	drained := waitWithin(deadline, func() {
+		background.Wait()
+		lazyBackend.rangeFills.wait()
+		lazyBackend.team.wait()
+		lazyBackend.listPrefetch.wait()
+	})
+	if !drained {
+		log.Printf("Warning: background fetches still running after the %s grace period; stopping without them", cfg.ShutdownGracePeriod)
+	}
+	elector.finishLeading(stopElection, electionStopped, func() {
+		if snapshot != nil {
+			if objects, err := snapshot.save(); err != nil {
+				log.Printf("Warning: couldn't save memory snapshot: %v", err)
+			} else {
+				log.Printf("Saved %d object(s) to memory snapshot %s", objects, snapshot.path)
+			}
+		}
+		if indexPath != "" {
+			if err := lazyBackend.index.save(indexPath); err != nil {
+				log.Printf("Warning: couldn't save cache index: %v", err)
+			}
+			if err := lazyBackend.ledger.save(); err != nil {
+				log.Printf("Warning: couldn't save residency ledger: %v", err)
+			}
+		}
+	})
 	log.Println("Server stopped")
 }
 
-// createAWSClient creates an S3 client for the real AWS endpoint
-func createAWSClient(cfg *Config) (*s3.Client, error) {
-	awsCfg, err := config.LoadDefaultConfig(context.Background(),
-		config.WithRegion(cfg.AWSRegion),
-	)
+// createAWSClient creates an S3 client for the real AWS endpoint, a pool
+// of clients rotating over the configured upstream endpoints, or a client
+// of the mock upstream
+func createAWSClient(cfg *Config) (upstreamLister, error) {
+	if cfg.MockUpstream != "" {
+		url, err := startMockUpstream(cfg.MockUpstream)
+		if err != nil {
+			return nil, fmt.Errorf("mock upstream: %w", err)
+		}
+		awsCfg, err := config.LoadDefaultConfig(context.Background(),
+			config.WithRegion(cfg.AWSRegion),
+			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(mockUpstreamCredential, mockUpstreamCredential, "")),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			o.BaseEndpoint = aws.String(url)
+			o.UsePathStyle = true
+		}), nil
+	}
+
+	switch urls := cfg.upstreamEndpoints(); len(urls) {
+	case 0:
+	case 1:
+		log.Printf("Upstream is %s", urls[0])
+	default:
+		log.Printf("Upstream rotates over %d endpoint(s)", len(urls))
+	}
+	if cfg.AWSRoleARN != "" && cfg.UpstreamAccessKey == "" && cfg.AWSProfile == "" {
+		log.Printf("Upstream credentials from assuming %s with web identity token %s", cfg.AWSRoleARN, cfg.AWSWebIdentityTokenFile)
+	}
+	return createUpstreamClient(cfg, BucketUpstream{})
+}
+
+// createUpstreamClient creates the client of an upstream whose settings
+// override the global ones where they are set: an S3 client for AWS or a
+// pool of clients rotating over S3-compatible endpoints.
+func createUpstreamClient(cfg *Config, u BucketUpstream) (upstreamLister, error) {
+	opts := []func(*config.LoadOptions) error{config.WithRegion(cmp.Or(u.Region, cfg.AWSRegion))}
+	webIdentity, static := false, false
+	switch {
+	case u.AccessKey != "":
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(u.AccessKey, u.SecretKey, "")))
+		static = true
+	case u.Profile != "":
+		opts = append(opts, config.WithSharedConfigProfile(u.Profile))
+	case cfg.UpstreamAccessKey != "":
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.UpstreamAccessKey, cfg.UpstreamSecretKey, "")))
+		static = true
+	case cfg.AWSProfile != "":
+		opts = append(opts, config.WithSharedConfigProfile(cfg.AWSProfile))
+	case cfg.AWSRoleARN != "":
+		webIdentity = true
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background(), opts...)
 	if err != nil {
 		return nil, err
 	}
+	if client := upstreamHTTPClient(cfg); client != nil {
+		awsCfg.HTTPClient = client
+	}
+	if webIdentity {
+		awsCfg.Credentials = webIdentityCredentials(awsCfg, cfg.AWSRoleARN, cfg.AWSWebIdentityTokenFile)
+	}
+	if cfg.UpstreamMaxAttempts > 0 {
+		awsCfg.RetryMaxAttempts = cfg.UpstreamMaxAttempts
+	}
+	if retryer := upstreamRetryer(cfg); retryer != nil {
+		awsCfg.Retryer = retryer
+	}
+	if awsCfg.Credentials != nil {
+		creds := newUpstreamCredentials(describeUpstream(u), awsCfg.Credentials, !static)
+		awsCfg.Credentials, awsCfg.Retryer = creds, creds.retryer(awsCfg.Retryer)
+	}
+
+	urls := cfg.upstreamEndpoints()
+	if u.Endpoint != "" {
+		urls = []string{u.Endpoint}
+	}
+	pathStyle := cfg.UpstreamPathStyle
+	if u.PathStyle != nil {
+		pathStyle = *u.PathStyle
+	}
+	if len(urls) == 0 {
+		// Buckets outside the configured region are found and retried
+		// in their own region
+		return newRegionCorrector(s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			// Object Lambda Access Point ARNs in bucket mappings may live in
+			// another region than the default client region
+			o.UseARNRegion = true
+		})), nil
+	}
+
+	// Each endpoint may itself resolve to several addresses
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = newAddressRotator().DialContext
+	applyConnTimeouts(transport, cfg)
+	httpClient := &http.Client{Transport: transport}
+
+	endpoints := make([]*upstreamEndpoint, len(urls))
+	for i, endpoint := range urls {
+		endpoints[i] = &upstreamEndpoint{
+			url: endpoint,
+			client: s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+				o.BaseEndpoint = aws.String(endpoint)
+				o.UsePathStyle = pathStyle
+				o.HTTPClient = httpClient
+			}),
+		}
+	}
+	return newEndpointPool(endpoints), nil
+}
 
-	return s3.NewFromConfig(awsCfg), nil
+// wrapUpstreamClient adds the configured fallback chains, hedging and
+// circuit breaker to an upstream client.
+func wrapUpstreamClient(cfg *Config, client upstreamLister, u BucketUpstream) upstreamLister {
+	if len(cfg.BucketFallbacks) > 0 {
+		client = newFallbackChain(client, cfg.BucketFallbacks)
+	}
+	if cfg.HedgeDelay > 0 {
+		client = newHedgedClient(client, cfg.HedgeDelay, int64(cfg.HedgeMaxBytes))
+	}
+	if cfg.UpstreamCircuitFailures > 0 {
+		client = newCircuitBreaker(client, describeUpstream(u), cfg.UpstreamCircuitFailures, cfg.UpstreamCircuitCooldown)
+	}
+	return client
 }
 
-// createLocalBackend creates the local storage backend based on configuration
+// createLocalBackend creates the local storage backend based on configuration.
+// Buckets with their own backend type are routed through a multiplexer; each
+// backend type is created once and shared by every bucket that uses it.
 func createLocalBackend(cfg *Config) (gofakes3.Backend, error) {
-	switch cfg.BackendType {
+	fallback, err := newLocalBackend(cfg, cfg.BackendType)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.BucketBackends) == 0 {
+		return fallback, nil
+	}
+
+	byType := map[string]gofakes3.Backend{cfg.BackendType: fallback}
+	routes := make(map[string]gofakes3.Backend, len(cfg.BucketBackends))
+	for bucket, backendType := range cfg.BucketBackends {
+		backend, ok := byType[backendType]
+		if !ok {
+			if backend, err = newLocalBackend(cfg, backendType); err != nil {
+				return nil, fmt.Errorf("bucket %s: %w", bucket, err)
+			}
+			byType[backendType] = backend
+		}
+		routes[bucket] = backend
+		log.Printf("Bucket %s uses the %s backend", bucket, backendType)
+	}
+	return NewMultiplexBackend(fallback, routes), nil
+}
+
+// newLocalBackend creates a single local storage backend of the given type
+func newLocalBackend(cfg *Config, backendType string) (gofakes3.Backend, error) {
+	switch backendType {
 	case "localstack":
 		log.Printf("Using LocalStack backend at %s", cfg.LocalStackEndpoint)
-		return NewLocalStackBackend(cfg.LocalStackEndpoint, cfg.AWSRegion)
+		backend, err := NewLocalStackBackend(cfg.LocalStackEndpoint, cfg.AWSRegion)
+		if err != nil {
+			return nil, err
+		}
+		backend.SetTimeout(cfg.OperationTimeouts[opLocalStack])
+		return backend, nil
 
 	case "disk":
 		log.Printf("Using disk backend at %s", cfg.DataDir)
@@ -133,18 +832,78 @@ func createLocalBackend(cfg *Config) (gofakes3.Backend, error) {
 		if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
 			return nil, err
 		}
+		if err := checkWritable(cfg.DataDir); err != nil {
+			if cfg.Strict {
+				return nil, err
+			}
+			log.Printf("Warning: %v; cache fills will fail", err)
+		} else if err := migrateDataDir(cfg.DataDir); err != nil {
+			return nil, fmt.Errorf("data dir layout: %w", err)
+		}
 
-		// Create filesystem-based backend using afero
-		fs := afero.NewBasePathFs(afero.NewOsFs(), cfg.DataDir)
-		return s3afero.MultiBucket(fs)
+		// Create filesystem-based backend using afero, writing files
+		// atomically, packing small objects, compressing and encrypting
+		// objects if enabled and listing through the bucket directories page
+		// by page
+		files, err := openDiskFiles(cfg.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		packs, err := openPackStore(filepath.Join(cfg.DataDir, "s3lazy", "packs"))
+		if err != nil {
+			return nil, fmt.Errorf("opening packs: %w", err)
+		}
+		if cfg.PackThreshold > 0 {
+			log.Printf("Packing objects up to %d bytes", cfg.PackThreshold)
+		}
+		backend := newPackedDisk(files, packs, int64(cfg.PackThreshold))
+		if cfg.DiskCompression == "zstd" {
+			log.Printf("Compressing objects on disk with zstd")
+		}
+		var cipher *diskCipher
+		if cfg.encrypts() {
+			key, err := loadEncryptionKey(cfg.EncryptionKey, cfg.EncryptionKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("encryption key: %w", err)
+			}
+			if cipher, err = newDiskCipher(key); err != nil {
+				return nil, fmt.Errorf("encryption key: %w", err)
+			}
+			log.Printf("Encrypting objects on disk with AES-256-GCM (key %s)", cipher.keyID)
+		}
+		encoded := newEncodedDisk(backend, cfg.DiskCompression == "zstd", cipher, filepath.Join(cfg.DataDir, "s3lazy"))
+		return newDiskListing(encoded, cfg.DataDir, packs), nil
 
 	case "memory":
-		log.Printf("Using in-memory backend (ephemeral, data will not persist)")
-		return s3mem.New(), nil
+		if cfg.MemorySnapshotInterval <= 0 {
+			log.Printf("Using in-memory backend (ephemeral, data will not persist)")
+			return s3mem.New(), nil
+		}
+		snapshot := newMemorySnapshot(s3mem.New(), filepath.Join(cfg.DataDir, "s3lazy", "memory.snapshot"))
+		objects, err := snapshot.load()
+		if err != nil {
+			if cfg.Strict {
+				return nil, fmt.Errorf("loading memory snapshot %s: %w", snapshot.path, err)
+			}
+			log.Printf("Warning: couldn't load memory snapshot %s: %v", snapshot.path, err)
+		}
+		log.Printf("Using in-memory backend, snapshotted to %s every %s (%d object(s) restored)", snapshot.path, cfg.MemorySnapshotInterval, objects)
+		return snapshot, nil
 
 	default:
-		return nil, fmt.Errorf("unknown backend type: %q (valid options: disk, memory, localstack)", cfg.BackendType)
+		return nil, fmt.Errorf("unknown backend type: %q (valid options: disk, memory, localstack)", backendType)
+	}
+}
+
+// checkWritable verifies a directory can be written to by creating and
+// removing a temporary file in it.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".s3lazy-write-check-*")
+	if err != nil {
+		return fmt.Errorf("data directory %s is not writable: %w", dir, err)
 	}
+	f.Close()
+	return os.Remove(f.Name())
 }
 
 // healthHandler returns OK if the server is running
@@ -153,3 +912,55 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("OK"))
 }
+
+// readiness tracks whether the instance should receive traffic, and tells
+// the service manager once it is both listening and ready.
+type readiness struct {
+	ready     atomic.Bool
+	listening atomic.Bool
+	reason    atomic.Value
+	announced sync.Once
+}
+
+// setNotReady marks the instance unready with a reason reported by /readyz.
+func (r *readiness) setNotReady(reason string) {
+	r.reason.Store(reason)
+	r.ready.Store(false)
+	notifyState("STATUS=" + reason)
+}
+
+func (r *readiness) setReady() {
+	r.ready.Store(true)
+	r.announce()
+}
+
+// setListening records that the listener is open.
+func (r *readiness) setListening() {
+	r.listening.Store(true)
+	r.announce()
+}
+
+// announce notifies the service manager the first time the instance is
+// both listening and ready.
+func (r *readiness) announce() {
+	if !r.listening.Load() || !r.ready.Load() {
+		return
+	}
+	r.announced.Do(func() {
+		notifyState("READY=1\nSTATUS=Serving")
+	})
+}
+
+// readyzHandler returns OK once the instance is ready to serve traffic, and
+// 503 with the reason until then.
+func (r *readiness) readyzHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	if !r.ready.Load() {
+		reason, _ := r.reason.Load().(string)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(reason))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK"))
+}
@@ -25,6 +25,28 @@ func TestHealthHandler(t *testing.T) {
 	}
 }
 
+func TestReadyzHandler(t *testing.T) {
+	r := &readiness{}
+	r.setNotReady("warming 3 object(s)")
+
+	w := httptest.NewRecorder()
+	r.readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if w.Body.String() != "warming 3 object(s)" {
+		t.Errorf("body = %q, want %q", w.Body.String(), "warming 3 object(s)")
+	}
+
+	r.setReady()
+
+	w = httptest.NewRecorder()
+	r.readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
 func TestCreateLocalBackend_Disk(t *testing.T) {
 	tmpDir := t.TempDir()
 	cfg := &Config{
@@ -109,6 +131,39 @@ func TestCreateLocalBackend_DiskInvalidDir(t *testing.T) {
 	}
 }
 
+func TestCreateLocalBackend_DiskUnwritableStrict(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	dataDir := t.TempDir()
+	if err := os.Chmod(dataDir, 0555); err != nil {
+		t.Fatalf("Failed to make data dir read-only: %v", err)
+	}
+	defer os.Chmod(dataDir, 0755)
+
+	cfg := &Config{BackendType: "disk", DataDir: dataDir}
+	if _, err := createLocalBackend(cfg); err != nil {
+		t.Errorf("non-strict mode should only warn, got %v", err)
+	}
+	cfg.Strict = true
+	if _, err := createLocalBackend(cfg); err == nil {
+		t.Error("expected error for unwritable data dir in strict mode")
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkWritable(dir); err != nil {
+		t.Errorf("checkWritable(%s) = %v", dir, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("checkWritable left %d file(s) behind", len(entries))
+	}
+	if err := checkWritable(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
+
 func TestCreateLocalBackend_InvalidType(t *testing.T) {
 	cfg := &Config{
 		BackendType: "aws",
@@ -120,6 +175,34 @@ func TestCreateLocalBackend_InvalidType(t *testing.T) {
 	}
 }
 
+func TestCreateLocalBackend_PerBucket(t *testing.T) {
+	cfg := &Config{
+		BackendType:    "disk",
+		DataDir:        t.TempDir(),
+		BucketBackends: map[string]string{"config": "memory", "settings": "memory"},
+	}
+
+	backend, err := createLocalBackend(cfg)
+	if err != nil {
+		t.Fatalf("createLocalBackend failed: %v", err)
+	}
+	mux, ok := backend.(*MultiplexBackend)
+	if !ok {
+		t.Fatalf("backend = %T, want *MultiplexBackend", backend)
+	}
+	if mux.backendFor("config") != mux.backendFor("settings") {
+		t.Error("buckets with the same backend type should share one backend")
+	}
+	if mux.backendFor("config") == mux.backendFor("datasets") {
+		t.Error("unrouted buckets should use the default backend")
+	}
+
+	cfg.BucketBackends = map[string]string{"config": "tape"}
+	if _, err := createLocalBackend(cfg); err == nil {
+		t.Error("expected error for invalid per-bucket backend type")
+	}
+}
+
 func TestCreateAWSClient(t *testing.T) {
 	cfg := &Config{
 		AWSRegion: "us-east-1",
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// memorySnapshotVersion identifies the snapshot format, so a snapshot
+// written by an incompatible version is refused rather than misread.
+const memorySnapshotVersion = 1
+
+// snapshotHeader starts a memory snapshot.
+type snapshotHeader struct {
+	Version int
+}
+
+// snapshotRecord is a bucket, when Key is empty, or an object of the
+// bucket before it.
+type snapshotRecord struct {
+	Bucket   string
+	Key      string
+	Metadata map[string]string
+	Data     []byte
+}
+
+// memorySnapshot is the memory backend saving its contents to a file now
+// and then and reloading them on startup, so the cache survives a restart
+// while still serving from memory.
+type memorySnapshot struct {
+	gofakes3.Backend
+	path string
+}
+
+// newMemorySnapshot wraps a memory backend saving snapshots to path.
+func newMemorySnapshot(backend gofakes3.Backend, path string) *memorySnapshot {
+	return &memorySnapshot{Backend: backend, path: path}
+}
+
+// findMemorySnapshot returns the snapshotting memory backend among the
+// local backends, or nil if there is none.
+func findMemorySnapshot(backend gofakes3.Backend) *memorySnapshot {
+	switch b := backend.(type) {
+	case *memorySnapshot:
+		return b
+	case *MultiplexBackend:
+		for _, routed := range b.backends() {
+			if m, ok := routed.(*memorySnapshot); ok {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// save writes every bucket and object to the snapshot file, via a temp file
+// and rename so a crash never leaves a truncated snapshot behind. Objects
+// are read one at a time, so writes made during a save may or may not be
+// included. It returns the number of objects saved.
+func (m *memorySnapshot) save() (int, error) {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return 0, err
+	}
+	tmp := m.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	objects, err := m.write(gob.NewEncoder(w))
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return objects, os.Rename(tmp, m.path)
+}
+
+func (m *memorySnapshot) write(enc *gob.Encoder) (int, error) {
+	if err := enc.Encode(snapshotHeader{Version: memorySnapshotVersion}); err != nil {
+		return 0, err
+	}
+	buckets, err := m.ListBuckets()
+	if err != nil {
+		return 0, err
+	}
+	objects := 0
+	for _, bucket := range buckets {
+		if err := enc.Encode(snapshotRecord{Bucket: bucket.Name}); err != nil {
+			return objects, err
+		}
+		for key, err := range localKeys(m.Backend, bucket.Name, "") {
+			if err != nil {
+				return objects, fmt.Errorf("listing %s: %w", bucket.Name, err)
+			}
+			obj, err := m.GetObject(bucket.Name, key, nil)
+			if gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+				// Deleted since it was listed
+				continue
+			}
+			if err != nil {
+				return objects, fmt.Errorf("reading %s/%s: %w", bucket.Name, key, err)
+			}
+			data, err := io.ReadAll(obj.Contents)
+			obj.Contents.Close()
+			if err != nil {
+				return objects, fmt.Errorf("reading %s/%s: %w", bucket.Name, key, err)
+			}
+			record := snapshotRecord{Bucket: bucket.Name, Key: key, Metadata: obj.Metadata, Data: data}
+			if err := enc.Encode(record); err != nil {
+				return objects, err
+			}
+			objects++
+		}
+	}
+	return objects, nil
+}
+
+// load restores the buckets and objects saved in the snapshot file. A
+// missing file loads nothing. It returns the number of objects loaded.
+func (m *memorySnapshot) load() (int, error) {
+	f, err := os.Open(m.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, fmt.Errorf("reading snapshot header: %w", err)
+	}
+	if header.Version != memorySnapshotVersion {
+		return 0, fmt.Errorf("snapshot version %d, want %d", header.Version, memorySnapshotVersion)
+	}
+	objects := 0
+	for {
+		var record snapshotRecord
+		err := dec.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return objects, fmt.Errorf("reading snapshot: %w", err)
+		}
+		if record.Key == "" {
+			if err := m.CreateBucket(record.Bucket); err != nil && !gofakes3.HasErrorCode(err, gofakes3.ErrBucketAlreadyExists) {
+				return objects, err
+			}
+			continue
+		}
+		if _, err := m.PutObject(record.Bucket, record.Key, record.Metadata, bytes.NewReader(record.Data), int64(len(record.Data)), nil); err != nil {
+			return objects, fmt.Errorf("restoring %s/%s: %w", record.Bucket, record.Key, err)
+		}
+		objects++
+	}
+}
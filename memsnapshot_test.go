@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func TestMemorySnapshot_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s3lazy", "memory.snapshot")
+	saved := newMemorySnapshot(s3mem.New(), path)
+	for _, bucket := range []string{"test-bucket", "empty-bucket"} {
+		if err := saved.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta := map[string]string{"Content-Type": "text/plain", "X-Amz-Meta-Owner": "ops"}
+	for key, content := range map[string]string{"a.txt": "alpha", "dir/b.txt": "beta"} {
+		if _, err := saved.PutObject("test-bucket", key, meta, strings.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := saved.save(); err != nil || n != 2 {
+		t.Fatalf("save = %d, %v; want 2 objects", n, err)
+	}
+
+	loaded := newMemorySnapshot(s3mem.New(), path)
+	if n, err := loaded.load(); err != nil || n != 2 {
+		t.Fatalf("load = %d, %v; want 2 objects", n, err)
+	}
+	if ok, err := loaded.BucketExists("empty-bucket"); err != nil || !ok {
+		t.Errorf("empty bucket not restored: %v, %v", ok, err)
+	}
+	if _, got := readObject(t, loaded, "dir/b.txt", nil); got != "beta" {
+		t.Errorf("dir/b.txt = %q, want beta", got)
+	}
+	obj, _ := readObject(t, loaded, "a.txt", nil)
+	if obj.Metadata["X-Amz-Meta-Owner"] != "ops" || obj.Metadata["Content-Type"] != "text/plain" {
+		t.Errorf("metadata = %v, want it restored", obj.Metadata)
+	}
+}
+
+func TestMemorySnapshot_Missing(t *testing.T) {
+	snapshot := newMemorySnapshot(s3mem.New(), filepath.Join(t.TempDir(), "memory.snapshot"))
+	if n, err := snapshot.load(); err != nil || n != 0 {
+		t.Errorf("load of missing snapshot = %d, %v; want nothing loaded", n, err)
+	}
+}
+
+func TestMemorySnapshot_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.snapshot")
+	if err := os.WriteFile(path, []byte("not a snapshot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newMemorySnapshot(s3mem.New(), path).load(); err == nil {
+		t.Error("load of corrupt snapshot succeeded")
+	}
+}
+
+func TestFindMemorySnapshot(t *testing.T) {
+	snapshot := newMemorySnapshot(s3mem.New(), "unused")
+	if findMemorySnapshot(snapshot) != snapshot {
+		t.Error("snapshot not found as the local backend")
+	}
+	multiplexed := NewMultiplexBackend(s3mem.New(), map[string]gofakes3.Backend{"a": snapshot})
+	if findMemorySnapshot(multiplexed) != snapshot {
+		t.Error("snapshot not found behind the multiplexer")
+	}
+	if findMemorySnapshot(s3mem.New()) != nil {
+		t.Error("found a snapshot for the plain memory backend")
+	}
+}
+
+func TestMemorySnapshot_SavedByLeaderOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+	elector, err := newLeaderElector(dir, "replica-a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		elector.run(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !elector.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatal("never acquired the lease")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	snapshot := newMemorySnapshot(s3mem.New(), filepath.Join(dir, "memory.snapshot"))
+	if err := snapshot.CreateBucket("test-bucket"); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, snapshot, "a.txt", "alpha")
+	elector.finishLeading(stop, stopped, func() {
+		if _, err := snapshot.save(); err != nil {
+			t.Errorf("saving the snapshot: %v", err)
+		}
+	})
+	loaded := newMemorySnapshot(s3mem.New(), snapshot.path)
+	if n, err := loaded.load(); err != nil || n != 1 {
+		t.Errorf("load after shutdown = %d, %v; want the object cached before it", n, err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// mockUpstreamCredential is the access key and secret the mock upstream is
+// signed with; it doesn't check them.
+const mockUpstreamCredential = "mock"
+
+// startMockUpstream serves the files under dir from an in-memory S3 server
+// on a loopback port, so s3lazy can be demoed and developed without AWS.
+// Each directory under dir is a bucket, and the files under it are its
+// keys. It returns the server's URL; the server runs until exit.
+func startMockUpstream(dir string) (string, error) {
+	backend := s3mem.New()
+	buckets, objects, err := seedMockUpstream(backend, dir)
+	if err != nil {
+		return "", err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	faker := gofakes3.New(backend, gofakes3.WithLogger(gofakes3.DiscardLog()))
+	go func() {
+		if err := http.Serve(ln, faker.Server()); err != nil {
+			log.Printf("[MOCK] upstream stopped: %v", err)
+		}
+	}()
+	url := "http://" + ln.Addr().String()
+	log.Printf("[MOCK] serving %d object(s) in %d bucket(s) from %s as upstream at %s", objects, buckets, dir, url)
+	return url, nil
+}
+
+// mockUpstreamBuckets returns the buckets the mock upstream serves from dir.
+func mockUpstreamBuckets(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var buckets []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			buckets = append(buckets, entry.Name())
+		}
+	}
+	return buckets, nil
+}
+
+// seedMockUpstream creates a bucket for each directory under dir and puts
+// every file under it as an object. Hidden files and directories are
+// skipped.
+func seedMockUpstream(backend gofakes3.Backend, dir string) (buckets, objects int, err error) {
+	names, err := mockUpstreamBuckets(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, bucket := range names {
+		if err := backend.CreateBucket(bucket); err != nil {
+			return buckets, objects, fmt.Errorf("bucket %s: %w", bucket, err)
+		}
+		buckets++
+
+		root := filepath.Join(dir, bucket)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(d.Name(), ".") && p != root {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			key := filepath.ToSlash(rel)
+			meta := map[string]string{}
+			if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+				meta["Content-Type"] = contentType
+			}
+			if _, err := backend.PutObject(bucket, key, meta, bytes.NewReader(data), int64(len(data)), nil); err != nil {
+				return fmt.Errorf("%s/%s: %w", bucket, key, err)
+			}
+			objects++
+			return nil
+		})
+		if err != nil {
+			return buckets, objects, err
+		}
+	}
+	return buckets, objects, nil
+}
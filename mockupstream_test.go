@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// writeMockUpstream lays out a mock upstream directory.
+func writeMockUpstream(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSeedMockUpstream(t *testing.T) {
+	dir := writeMockUpstream(t, map[string]string{
+		"fixtures/data/users.json": `[]`,
+		"fixtures/readme.txt":      "hello",
+		"fixtures/.DS_Store":       "hidden",
+		"fixtures/.git/HEAD":       "hidden",
+		"models/weights.bin":       "0101",
+		"stray.txt":                "not in a bucket",
+	})
+
+	backend := s3mem.New()
+	buckets, objects, err := seedMockUpstream(backend, dir)
+	if err != nil {
+		t.Fatalf("seedMockUpstream: %v", err)
+	}
+	if buckets != 2 || objects != 3 {
+		t.Errorf("seeded %d bucket(s) and %d object(s), want 2 and 3", buckets, objects)
+	}
+	obj, err := backend.HeadObject("fixtures", "data/users.json")
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	if obj.Metadata["Content-Type"] != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", obj.Metadata["Content-Type"])
+	}
+	if _, err := backend.HeadObject("fixtures", ".DS_Store"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("hidden file: err = %v, want NoSuchKey", err)
+	}
+}
+
+func TestMockUpstream_LazyFetch(t *testing.T) {
+	dir := writeMockUpstream(t, map[string]string{"fixtures/readme.txt": "hello from the mock"})
+	cfg := DefaultConfig()
+	cfg.MockUpstream = dir
+
+	client, err := createAWSClient(cfg)
+	if err != nil {
+		t.Fatalf("createAWSClient: %v", err)
+	}
+	local := s3mem.New()
+	if err := local.CreateBucket("fixtures"); err != nil {
+		t.Fatal(err)
+	}
+	lazy := NewLazyBackend(local, client)
+
+	obj, err := lazy.GetObject("fixtures", "readme.txt", nil)
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	data, err := io.ReadAll(obj.Contents)
+	obj.Contents.Close()
+	if err != nil || string(data) != "hello from the mock" {
+		t.Errorf("readme.txt = %q, %v", data, err)
+	}
+	if _, err := local.HeadObject("fixtures", "readme.txt"); err != nil {
+		t.Errorf("object not cached locally after the fetch: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"sort"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// MultiplexBackend implements gofakes3.Backend by routing each bucket to its
+// own local backend, so small config buckets can live in memory while
+// datasets go to disk and shared buckets to LocalStack. Buckets without a
+// route use the default backend.
+type MultiplexBackend struct {
+	fallback gofakes3.Backend
+	routes   map[string]gofakes3.Backend
+}
+
+// NewMultiplexBackend creates a multiplexer routing the given buckets to
+// their backends and everything else to fallback.
+func NewMultiplexBackend(fallback gofakes3.Backend, routes map[string]gofakes3.Backend) *MultiplexBackend {
+	m := &MultiplexBackend{fallback: fallback, routes: make(map[string]gofakes3.Backend, len(routes))}
+	for bucket, backend := range routes {
+		m.routes[bucket] = backend
+	}
+	return m
+}
+
+// backendFor returns the backend serving a bucket.
+func (m *MultiplexBackend) backendFor(bucket string) gofakes3.Backend {
+	if backend, ok := m.routes[bucket]; ok {
+		return backend
+	}
+	return m.fallback
+}
+
+// backends returns each distinct backend once, the fallback first.
+func (m *MultiplexBackend) backends() []gofakes3.Backend {
+	all := []gofakes3.Backend{m.fallback}
+	for _, backend := range m.routes {
+		seen := false
+		for _, b := range all {
+			if b == backend {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			all = append(all, backend)
+		}
+	}
+	return all
+}
+
+// ListBuckets merges the buckets of every backend. A bucket is only listed
+// from the backend it is routed to, so a stale copy left in another backend
+// doesn't show up twice.
+func (m *MultiplexBackend) ListBuckets() ([]gofakes3.BucketInfo, error) {
+	var buckets []gofakes3.BucketInfo
+	for _, backend := range m.backends() {
+		infos, err := backend.ListBuckets()
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if m.backendFor(info.Name) == backend {
+				buckets = append(buckets, info)
+			}
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return buckets, nil
+}
+
+func (m *MultiplexBackend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) (*gofakes3.ObjectList, error) {
+	return m.backendFor(name).ListBucket(name, prefix, page)
+}
+
+func (m *MultiplexBackend) CreateBucket(name string) error {
+	return m.backendFor(name).CreateBucket(name)
+}
+
+func (m *MultiplexBackend) BucketExists(name string) (bool, error) {
+	return m.backendFor(name).BucketExists(name)
+}
+
+func (m *MultiplexBackend) DeleteBucket(name string) error {
+	return m.backendFor(name).DeleteBucket(name)
+}
+
+func (m *MultiplexBackend) ForceDeleteBucket(name string) error {
+	return m.backendFor(name).ForceDeleteBucket(name)
+}
+
+func (m *MultiplexBackend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	return m.backendFor(bucketName).GetObject(bucketName, objectName, rangeRequest)
+}
+
+func (m *MultiplexBackend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
+	return m.backendFor(bucketName).HeadObject(bucketName, objectName)
+}
+
+func (m *MultiplexBackend) DeleteObject(bucketName, objectName string) (gofakes3.ObjectDeleteResult, error) {
+	return m.backendFor(bucketName).DeleteObject(bucketName, objectName)
+}
+
+func (m *MultiplexBackend) PutObject(bucketName, key string, meta map[string]string, input io.Reader, size int64, conditions *gofakes3.PutConditions) (gofakes3.PutObjectResult, error) {
+	return m.backendFor(bucketName).PutObject(bucketName, key, meta, input, size, conditions)
+}
+
+func (m *MultiplexBackend) DeleteMulti(bucketName string, objects ...string) (gofakes3.MultiDeleteResult, error) {
+	return m.backendFor(bucketName).DeleteMulti(bucketName, objects...)
+}
+
+// CopyObject copies within a backend natively, and streams the object from
+// one backend into the other when the buckets are served by different ones.
+func (m *MultiplexBackend) CopyObject(srcBucket, srcKey, dstBucket, dstKey string, meta map[string]string) (gofakes3.CopyObjectResult, error) {
+	if src := m.backendFor(srcBucket); src == m.backendFor(dstBucket) {
+		return src.CopyObject(srcBucket, srcKey, dstBucket, dstKey, meta)
+	}
+	return gofakes3.CopyObject(m, srcBucket, srcKey, dstBucket, dstKey, meta)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func TestMultiplexBackend_RoutesByBucket(t *testing.T) {
+	fallback, small := s3mem.New(), s3mem.New()
+	mux := NewMultiplexBackend(fallback, map[string]gofakes3.Backend{"config": small})
+
+	for _, bucket := range []string{"config", "datasets"} {
+		if err := mux.CreateBucket(bucket); err != nil {
+			t.Fatalf("CreateBucket(%s) failed: %v", bucket, err)
+		}
+	}
+	// A stale bucket of the same name in the fallback must not be listed twice
+	if err := fallback.CreateBucket("config"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+
+	if exists, _ := small.BucketExists("config"); !exists {
+		t.Error("config bucket should live in its routed backend")
+	}
+	if exists, _ := small.BucketExists("datasets"); exists {
+		t.Error("datasets bucket should live in the default backend")
+	}
+
+	buckets, err := mux.ListBuckets()
+	if err != nil {
+		t.Fatalf("ListBuckets failed: %v", err)
+	}
+	if len(buckets) != 2 || buckets[0].Name != "config" || buckets[1].Name != "datasets" {
+		t.Errorf("ListBuckets = %+v, want [config datasets]", buckets)
+	}
+}
+
+func TestMultiplexBackend_CopyAcrossBackends(t *testing.T) {
+	fallback, small := s3mem.New(), s3mem.New()
+	mux := NewMultiplexBackend(fallback, map[string]gofakes3.Backend{"config": small})
+
+	for _, bucket := range []string{"config", "datasets"} {
+		if err := mux.CreateBucket(bucket); err != nil {
+			t.Fatalf("CreateBucket(%s) failed: %v", bucket, err)
+		}
+	}
+	content := []byte("settings")
+	if _, err := mux.PutObject("config", "app.yaml", nil, bytes.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	if _, err := mux.CopyObject("config", "app.yaml", "datasets", "app.yaml", nil); err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+
+	obj, err := fallback.GetObject("datasets", "app.yaml", nil)
+	if err != nil {
+		t.Fatalf("copied object missing from default backend: %v", err)
+	}
+	defer obj.Contents.Close()
+	data, _ := io.ReadAll(obj.Contents)
+	if string(data) != "settings" {
+		t.Errorf("copied content = %q, want %q", data, "settings")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// upstreamIdentity names the upstream s3lazy is configured to fetch from, so
+// objects cached from one upstream aren't served once s3lazy points at
+// another. The AWS account is told apart by the configured or environment
+// credentials, hashed so the cache index doesn't record them; namespace
+// overrides the whole identity where that isn't enough, such as with
+// instance role credentials.
+func upstreamIdentity(cfg *Config) string {
+	switch {
+	case cfg.CacheNamespace != "":
+		return cfg.CacheNamespace
+	case cfg.MockUpstream != "":
+		dir, err := filepath.Abs(cfg.MockUpstream)
+		if err != nil {
+			dir = cfg.MockUpstream
+		}
+		return "mock:" + dir
+	case len(cfg.upstreamEndpoints()) > 0:
+		endpoints := slices.Clone(cfg.upstreamEndpoints())
+		slices.Sort(endpoints)
+		return "endpoints:" + strings.Join(endpoints, ",")
+	}
+	// A configured profile takes priority over the environment's keys
+	account := cmp.Or(cfg.UpstreamAccessKey, cfg.AWSProfile, cfg.AWSRoleARN)
+	if account == "" {
+		account = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if account == "" {
+		account = os.Getenv("AWS_PROFILE")
+	}
+	if account == "" {
+		return "aws"
+	}
+	sum := sha256.Sum256([]byte(account))
+	return "aws:" + hex.EncodeToString(sum[:8])
+}
+
+// SetUpstreamIdentity sets the identity of the upstream objects are cached
+// from. Entries cached from a different upstream are dropped by
+// DropForeignEntries.
+func (b *LazyBackend) SetUpstreamIdentity(identity string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.upstreamID = identity
+}
+
+// namespace returns where a bucket's objects are currently fetched from: its
+// URL template, or the upstream identity and upstream bucket name.
+func (b *LazyBackend) namespace(bucketName string) string {
+	b.mu.RLock()
+	src, ok := b.urlSources[bucketName]
+	identity := b.upstreamID
+	if own, hasOwn := b.bucketUpstreams[bucketName]; hasOwn {
+		identity = own.identity
+	}
+	b.mu.RUnlock()
+	if ok {
+		return "url:" + src.template
+	}
+	return identity + "/" + b.awsBucketName(bucketName)
+}
+
+// DropForeignEntries evicts cached objects that were fetched from another
+// upstream than their bucket's current one, after the upstream or bucket
+// mappings changed between runs. Entries cached before namespaces were
+// recorded are assumed to match. It returns the number of objects dropped.
+func (b *LazyBackend) DropForeignEntries() int {
+	b.index.mu.Lock()
+	var foreign []cacheEntry
+	for _, e := range b.index.entries {
+		foreign = append(foreign, *e)
+	}
+	b.index.mu.Unlock()
+
+	namespaces := make(map[string]string)
+	dropped := make(map[string]int)
+	foreign = slices.DeleteFunc(foreign, func(e cacheEntry) bool {
+		ns, ok := namespaces[e.Bucket]
+		if !ok {
+			ns = b.namespace(e.Bucket)
+			namespaces[e.Bucket] = ns
+		}
+		if e.Namespace == "" || e.Namespace == ns {
+			return true
+		}
+		dropped[e.Bucket]++
+		return false
+	})
+	for bucket, n := range dropped {
+		log.Printf("[NAMESPACE] %s now fetches from %s: dropping %d object(s) cached from elsewhere", bucket, namespaces[bucket], n)
+	}
+	b.evictEntries(foreign)
+	return len(foreign)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestUpstreamIdentity(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_PROFILE", "")
+	if got := upstreamIdentity(&Config{}); got != "aws" {
+		t.Errorf("identity = %q, want aws", got)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	account := upstreamIdentity(&Config{})
+	if !strings.HasPrefix(account, "aws:") || strings.Contains(account, "AKIAEXAMPLE") {
+		t.Errorf("identity = %q, want the hashed access key", account)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAOTHER")
+	if upstreamIdentity(&Config{}) == account {
+		t.Error("another account should have another identity")
+	}
+	if upstreamIdentity(&Config{UpstreamAccessKey: "AKIAEXAMPLE"}) != account {
+		t.Error("static upstream keys should take priority over the environment")
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_PROFILE", "dev")
+	if upstreamIdentity(&Config{AWSProfile: "dev"}) != upstreamIdentity(&Config{}) {
+		t.Error("aws_profile should name the same account as AWS_PROFILE")
+	}
+	if upstreamIdentity(&Config{AWSRoleARN: "arn:aws:iam::123456789012:role/a"}) == upstreamIdentity(&Config{AWSRoleARN: "arn:aws:iam::123456789012:role/b"}) {
+		t.Error("another role should have another identity")
+	}
+
+	// Endpoint order doesn't matter
+	a := upstreamIdentity(&Config{UpstreamEndpoints: []string{"http://b:9000", "http://a:9000"}})
+	b := upstreamIdentity(&Config{UpstreamEndpoints: []string{"http://a:9000", "http://b:9000"}})
+	if a != b || a != "endpoints:http://a:9000,http://b:9000" {
+		t.Errorf("identities = %q, %q; want both endpoints, sorted", a, b)
+	}
+
+	if got := upstreamIdentity(&Config{CacheNamespace: "prod", UpstreamEndpoints: []string{"http://a:9000"}}); got != "prod" {
+		t.Errorf("identity = %q, want the configured namespace", got)
+	}
+}
+
+func TestLazyBackend_DropForeignEntries(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	lazyBackend.SetUpstreamIdentity("aws:one")
+	putString(t, awsBackend, "cached.txt", "from account one")
+	readObject(t, lazyBackend, "cached.txt", nil)
+	putString(t, lazyBackend, "written.txt", "client data")
+	// Cached before namespaces were recorded
+	putString(t, localBackend, "legacy.txt", "old")
+	lazyBackend.index.add("test-bucket", "legacy.txt", 3, "")
+
+	if entry, _ := lazyBackend.index.lookup("test-bucket", "cached.txt"); entry.Namespace != "aws:one/test-bucket" {
+		t.Fatalf("namespace = %q, want aws:one/test-bucket", entry.Namespace)
+	}
+	if n := lazyBackend.DropForeignEntries(); n != 0 {
+		t.Errorf("dropped %d entries with the same upstream, want 0", n)
+	}
+
+	// Pointing the bucket at another account drops what the first one served
+	lazyBackend.SetUpstreamIdentity("aws:two")
+	if n := lazyBackend.DropForeignEntries(); n != 1 {
+		t.Errorf("dropped %d entries, want 1", n)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "cached.txt"); err == nil {
+		t.Error("object cached from the old upstream should be gone")
+	}
+	for _, key := range []string{"written.txt", "legacy.txt"} {
+		if _, err := localBackend.HeadObject("test-bucket", key); err != nil {
+			t.Errorf("%s should be kept: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// keyGlobs matches object keys against glob patterns. A pattern with a "/"
+// matches the whole key, where "*" stays within one path segment and "**"
+// matches any number of them: "logs/**" matches every key under logs/. A
+// pattern without one matches the last segment of keys at any depth, so
+// "*.tmp" matches both "a.tmp" and "jobs/42/b.tmp".
+type keyGlobs struct {
+	patterns []string
+}
+
+// newKeyGlobs compiles glob patterns, rejecting malformed ones.
+func newKeyGlobs(patterns []string) (*keyGlobs, error) {
+	for _, pattern := range patterns {
+		if err := checkGlob(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return &keyGlobs{patterns: patterns}, nil
+}
+
+// checkGlob reports whether a pattern is a valid key glob.
+func checkGlob(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// match reports whether a key matches any of the patterns.
+func (g *keyGlobs) match(key string) bool {
+	if g == nil {
+		return false
+	}
+	for _, pattern := range g.patterns {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(key)); ok {
+				return true
+			}
+			continue
+		}
+		if matchSegments(strings.Split(pattern, "/"), strings.Split(key, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches a key's path segments against a pattern's.
+func matchSegments(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(key); i++ {
+				if matchSegments(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(key) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], key[0]); !ok {
+			return false
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
+
+// SetNoCachePatterns makes objects whose keys match any of the glob patterns
+// proxy-only in every bucket: reads are streamed from upstream and nothing
+// is written to the local backend.
+func (b *LazyBackend) SetNoCachePatterns(patterns []string) error {
+	globs, err := newKeyGlobs(patterns)
+	if err != nil {
+		return err
+	}
+	b.noCacheKeys = nil
+	if len(patterns) > 0 {
+		b.noCacheKeys = globs
+	}
+	return nil
+}
+
+// caches reports whether an object may be cached, by its bucket's cache
+// policy and the no-cache patterns.
+func (b *LazyBackend) caches(bucketName, objectName string) bool {
+	return b.cachePolicy.caches(bucketName) && !b.noCacheKeys.match(objectName)
+}
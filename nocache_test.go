@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestKeyGlobs(t *testing.T) {
+	globs, err := newKeyGlobs([]string{"*.tmp", "logs/**", "data/*/raw.csv"})
+	if err != nil {
+		t.Fatalf("newKeyGlobs: %v", err)
+	}
+	for key, want := range map[string]bool{
+		"a.tmp":              true,
+		"jobs/42/b.tmp":      true,
+		"a.tmp.gz":           false,
+		"logs/app.log":       true,
+		"logs/2026/10/a.log": true,
+		"old/logs/app.log":   false,
+		"data/x/raw.csv":     true,
+		"data/x/y/raw.csv":   false,
+		"data/raw.csv":       false,
+	} {
+		if got := globs.match(key); got != want {
+			t.Errorf("match(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	if _, err := newKeyGlobs([]string{"logs/[a-"}); err == nil {
+		t.Error("want an error for a malformed pattern")
+	}
+}
+
+func TestLazyBackend_NoCachePatterns(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	if err := lazyBackend.SetNoCachePatterns([]string{"*.tmp"}); err != nil {
+		t.Fatalf("SetNoCachePatterns: %v", err)
+	}
+	putString(t, awsBackend, "scratch/job.tmp", "scratch")
+	putString(t, awsBackend, "scratch/result.csv", "result")
+
+	for i := 0; i < 2; i++ {
+		if _, got := readObject(t, lazyBackend, "scratch/job.tmp", nil); got != "scratch" {
+			t.Errorf("content = %q, want scratch", got)
+		}
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "scratch/job.tmp"); err == nil {
+		t.Error("object matching a no-cache pattern should not be stored locally")
+	}
+	if err := lazyBackend.fill(t.Context(), "test-bucket", "scratch/job.tmp"); err == nil {
+		t.Error("fill of an object matching a no-cache pattern should fail")
+	}
+
+	readObject(t, lazyBackend, "scratch/result.csv", nil)
+	if _, err := localBackend.HeadObject("test-bucket", "scratch/result.csv"); err != nil {
+		t.Errorf("other objects should be cached: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// isObjectLambdaARN reports whether an upstream bucket name is an S3 Object
+// Lambda Access Point ARN rather than a plain bucket name.
+func isObjectLambdaARN(bucket string) bool {
+	return strings.HasPrefix(bucket, "arn:") && strings.Contains(bucket, ":s3-object-lambda:")
+}
+
+// spooledFile is a temp file holding a fully downloaded body. Closing it
+// removes the file.
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil && rmErr != nil && !os.IsNotExist(rmErr) {
+		err = rmErr
+	}
+	return err
+}
+
+// spoolToTempFile copies r into a temp file and returns it rewound to the
+// start along with the number of bytes written. Object Lambda transformations
+// stream their output without a Content-Length, but local backends need an
+// exact size up front.
+func spoolToTempFile(r io.Reader) (*spooledFile, int64, error) {
+	f, err := os.CreateTemp("", "s3lazy-spool-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	spooled := &spooledFile{File: f}
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		spooled.Close()
+		return nil, 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, 0, err
+	}
+	return spooled, n, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIsObjectLambdaARN(t *testing.T) {
+	tests := []struct {
+		bucket string
+		want   bool
+	}{
+		{"my-bucket", false},
+		{"arn:aws:s3-object-lambda:us-east-1:123456789012:accesspoint/my-olap", true},
+		{"arn:aws:s3:us-east-1:123456789012:accesspoint/my-ap", false},
+		{"s3-object-lambda", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.bucket, func(t *testing.T) {
+			if got := isObjectLambdaARN(tt.bucket); got != tt.want {
+				t.Errorf("isObjectLambdaARN(%q) = %v, want %v", tt.bucket, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpoolToTempFile(t *testing.T) {
+	content := "transformed body without a content length"
+
+	spooled, size, err := spoolToTempFile(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("spoolToTempFile failed: %v", err)
+	}
+
+	if size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", size, len(content))
+	}
+
+	data, err := io.ReadAll(spooled)
+	if err != nil {
+		t.Fatalf("Failed to read spooled file: %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("content = %q, want %q", string(data), content)
+	}
+
+	name := spooled.Name()
+	if err := spooled.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Error("temp file should be removed on Close")
+	}
+}
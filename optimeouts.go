@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// The operations operation_timeouts can bound.
+const (
+	opHead       = "head"       // upstream HEAD requests
+	opGet        = "get"        // upstream GETs, until the body is read
+	opList       = "list"       // each page of an upstream listing
+	opTagging    = "tagging"    // upstream tag reads for tag rules
+	opLocalStack = "localstack" // each request to a LocalStack backend
+)
+
+var timeoutOperations = []string{opHead, opGet, opList, opTagging, opLocalStack}
+
+// opTimeouts bounds how long each kind of operation may take. Operations
+// without a timeout run until their caller's context is done.
+type opTimeouts map[string]time.Duration
+
+// context derives the context for one operation from ctx, bounded by the
+// operation's timeout if it has one. The returned cancel must be called once
+// the operation is done.
+func (t opTimeouts) context(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	if d := t[op]; d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// cancelOnClose wraps the body of an operation so its context is cancelled
+// once the body is closed rather than when the operation returns.
+type cancelOnClose struct {
+	io.ReadCloser
+	once   sync.Once
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.once.Do(c.cancel)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newStalledSource makes test-bucket a URL source that answers every key but
+// "stalled", which hangs until the request is abandoned.
+func newStalledSource(t *testing.T, lazyBackend *LazyBackend) {
+	t.Helper()
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stalled" {
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte("quick"))
+	}))
+	t.Cleanup(source.Close)
+	if err := lazyBackend.SetURLSources(map[string]string{"test-bucket": source.URL + "/{key}"}, false); err != nil {
+		t.Fatalf("SetURLSources failed: %v", err)
+	}
+}
+
+func TestLazyBackend_OperationTimeouts(t *testing.T) {
+	lazyBackend, localBackend, _, _ := setupTestBackends(t)
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	newStalledSource(t, lazyBackend)
+	lazyBackend.SetOperationTimeouts(map[string]time.Duration{opGet: 50 * time.Millisecond, opHead: 50 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := lazyBackend.GetObject("test-bucket", "stalled", nil); err == nil {
+		t.Fatal("GetObject of a stalled key succeeded, want it to time out")
+	}
+	if _, err := lazyBackend.HeadObject("test-bucket", "stalled"); err == nil {
+		t.Fatal("HeadObject of a stalled key succeeded, want it to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled operations took %v, want them abandoned after their timeouts", elapsed)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "stalled"); err == nil {
+		t.Error("a timed out fill was cached")
+	}
+
+	obj, err := lazyBackend.GetObject("test-bucket", "quick", nil)
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	data, _ := io.ReadAll(obj.Contents)
+	obj.Contents.Close()
+	if string(data) != "quick" {
+		t.Errorf("quick = %q, want %q", data, "quick")
+	}
+}
+
+func TestLazyBackend_GetObjectContext(t *testing.T) {
+	lazyBackend, localBackend, _, _ := setupTestBackends(t)
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	newStalledSource(t, lazyBackend)
+
+	// Without timeouts, the caller's context still abandons the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := lazyBackend.GetObjectContext(ctx, "test-bucket", "stalled", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetObjectContext: err = %v, want the context's deadline exceeded", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// newTestPackedDisk creates a disk backend packing objects up to threshold
+// bytes, wrapped the way newLocalBackend does, with a bucket.
+func newTestPackedDisk(t *testing.T, dataDir string, threshold int64) (*diskListing, *packStore) {
+	t.Helper()
+	files, err := openDiskFiles(dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create disk backend: %v", err)
+	}
+	packs, err := openPackStore(filepath.Join(dataDir, "s3lazy", "packs"))
+	if err != nil {
+		t.Fatalf("openPackStore: %v", err)
+	}
+	disk := newDiskListing(newEncodedDisk(newPackedDisk(files, packs, threshold), false, nil, filepath.Join(dataDir, "s3lazy")), dataDir, packs)
+	if ok, _ := disk.BucketExists("test-bucket"); !ok {
+		if err := disk.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	return disk, packs
+}
+
+func putString(t *testing.T, backend gofakes3.Backend, key, content string) {
+	t.Helper()
+	if _, err := backend.PutObject("test-bucket", key, map[string]string{"Content-Type": "text/plain"},
+		strings.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("PutObject(%s): %v", key, err)
+	}
+}
+
+func fileExists(dataDir, key string) bool {
+	_, err := os.Stat(filepath.Join(dataDir, "buckets", "test-bucket", key))
+	return err == nil
+}
+
+func TestPackedDisk(t *testing.T) {
+	dataDir := t.TempDir()
+	disk, packs := newTestPackedDisk(t, dataDir, 10)
+
+	putString(t, disk, "small.txt", "tiny")
+	putString(t, disk, "large.txt", "larger than the threshold")
+	if fileExists(dataDir, "small.txt") || !fileExists(dataDir, "large.txt") {
+		t.Error("want small.txt packed and large.txt in its own file")
+	}
+
+	obj, data := readObject(t, disk, "small.txt", nil)
+	if data != "tiny" || obj.Size != 4 || obj.Metadata["Content-Type"] != "text/plain" {
+		t.Errorf("small.txt = %q, size %d, metadata %v", data, obj.Size, obj.Metadata)
+	}
+	if _, data := readObject(t, disk, "small.txt", &gofakes3.ObjectRangeRequest{Start: 1, End: 2}); data != "in" {
+		t.Errorf("range = %q, want %q", data, "in")
+	}
+	head, err := disk.HeadObject("test-bucket", "small.txt")
+	if err != nil || gofakes3.FormatETag(head.Hash) != gofakes3.FormatETag(obj.Hash) || len(head.Hash) == 0 {
+		t.Errorf("HeadObject = %+v, %v; want the packed object's hash", head, err)
+	}
+
+	// Objects move between packs and files as their size changes
+	putString(t, disk, "small.txt", "now too large to pack")
+	putString(t, disk, "large.txt", "short")
+	if !fileExists(dataDir, "small.txt") || fileExists(dataDir, "large.txt") {
+		t.Error("want small.txt moved to a file and large.txt packed")
+	}
+	if _, data := readObject(t, disk, "small.txt", nil); data != "now too large to pack" {
+		t.Errorf("small.txt = %q after growing", data)
+	}
+	if _, data := readObject(t, disk, "large.txt", nil); data != "short" {
+		t.Errorf("large.txt = %q after shrinking", data)
+	}
+
+	if _, err := disk.CopyObject("test-bucket", "large.txt", "test-bucket", "copy.txt", nil); err != nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+	if _, data := readObject(t, disk, "copy.txt", nil); data != "short" {
+		t.Errorf("copy = %q, want %q", data, "short")
+	}
+
+	if _, err := disk.DeleteObject("test-bucket", "large.txt"); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	if _, err := disk.HeadObject("test-bucket", "large.txt"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("deleted packed key: err = %v, want NoSuchKey", err)
+	}
+	if _, err := disk.DeleteMulti("test-bucket", "small.txt"); err != nil {
+		t.Fatalf("DeleteMulti: %v", err)
+	}
+
+	// copy.txt is still packed
+	if err := disk.DeleteBucket("test-bucket"); !gofakes3.HasErrorCode(err, gofakes3.ErrBucketNotEmpty) {
+		t.Errorf("DeleteBucket with a packed key: err = %v, want BucketNotEmpty", err)
+	}
+	if err := disk.ForceDeleteBucket("test-bucket"); err != nil {
+		t.Fatalf("ForceDeleteBucket: %v", err)
+	}
+	if packs.count("test-bucket") != 0 {
+		t.Error("force-deleting the bucket should drop its packs")
+	}
+}
+
+func TestPackedDisk_Listing(t *testing.T) {
+	dataDir := t.TempDir()
+	disk, _ := newTestPackedDisk(t, dataDir, 4)
+	mem := s3mem.New()
+	if err := mem.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	// Short keys' contents fit the threshold and are packed, the others are
+	// files, so listings have to merge both
+	keys := []string{"a-b", "a/1", "a/2/x", "a/2/yyyyy", "a0", "b/c/d/e", "b/c/fffff", "root.txt", "z"}
+	for _, key := range keys {
+		putString(t, disk, key, key)
+		putString(t, mem, key, key)
+	}
+
+	prefixes := []*gofakes3.Prefix{
+		nil,
+		{HasPrefix: true, Prefix: "a/"},
+		{HasDelimiter: true, Delimiter: "/"},
+		{HasPrefix: true, Prefix: "a/2", HasDelimiter: true, Delimiter: "/"},
+	}
+	for _, prefix := range prefixes {
+		for _, maxKeys := range []int64{1, 2, 1000} {
+			got := strings.Join(listAll(t, disk, prefix, maxKeys), " ")
+			want := strings.Join(listAll(t, mem, prefix, 1000), " ")
+			if got != want {
+				t.Errorf("listing of %v in pages of %d = %q, want %q", prefix, maxKeys, got, want)
+			}
+		}
+	}
+
+	list, err := disk.ListBucket("test-bucket", &gofakes3.Prefix{HasPrefix: true, Prefix: "a/1"}, gofakes3.ListBucketPage{})
+	if err != nil {
+		t.Fatalf("ListBucket: %v", err)
+	}
+	if len(list.Contents) != 1 || list.Contents[0].Size != 3 || list.Contents[0].ETag == "" || list.Contents[0].LastModified.IsZero() {
+		t.Errorf("contents = %+v, want packed a/1 with its size, ETag and modification time", list.Contents)
+	}
+}
+
+func TestPackStore_Reopen(t *testing.T) {
+	dataDir := t.TempDir()
+	disk, _ := newTestPackedDisk(t, dataDir, 100)
+	putString(t, disk, "kept", "v1")
+	putString(t, disk, "kept", "v2")
+	putString(t, disk, "deleted", "gone")
+	if _, err := disk.DeleteObject("test-bucket", "deleted"); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+
+	// A crash mid-append leaves a torn record behind
+	segment := filepath.Join(dataDir, "s3lazy", "packs", "test-bucket", segmentName(0))
+	intact, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 40, '{', '"', 'k'})
+	f.Close()
+
+	disk, packs := newTestPackedDisk(t, dataDir, 100)
+	if info, err := os.Stat(segment); err != nil || info.Size() != intact.Size() {
+		t.Errorf("segment size after reopening = %v (%v), want the torn record cut off at %d", info.Size(), err, intact.Size())
+	}
+	if _, data := readObject(t, disk, "kept", nil); data != "v2" {
+		t.Errorf("kept = %q, want the latest version", data)
+	}
+	if _, err := disk.HeadObject("test-bucket", "deleted"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("deleted key after reopening: err = %v, want NoSuchKey", err)
+	}
+	putString(t, disk, "after", "appended")
+	if _, data := readObject(t, disk, "after", nil); data != "appended" || packs.count("test-bucket") != 2 {
+		t.Errorf("after = %q with %d packed keys, want appends to work after reopening", data, packs.count("test-bucket"))
+	}
+}
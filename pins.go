@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pin is a pinned key, or a key prefix when prefix is set.
+type pin struct {
+	bucket string
+	key    string
+	prefix bool
+}
+
+func (p pin) String() string {
+	if p.prefix {
+		return p.bucket + "/" + p.key + "*"
+	}
+	return p.bucket + "/" + p.key
+}
+
+func (p pin) matches(bucket, key string) bool {
+	if p.bucket != bucket {
+		return false
+	}
+	if p.prefix {
+		return strings.HasPrefix(key, p.key)
+	}
+	return p.key == key
+}
+
+// parsePin parses "bucket/key" (an exact key) or "bucket/prefix*" (every key
+// under a prefix). A pattern ending in "/" is also treated as a prefix, so
+// "bucket/datasets/" pins the whole folder.
+func parsePin(pattern string) (pin, error) {
+	bucket, key, ok := strings.Cut(strings.TrimSpace(pattern), "/")
+	if !ok || bucket == "" {
+		return pin{}, fmt.Errorf("invalid pin %q: want bucket/key or bucket/prefix*", pattern)
+	}
+	p := pin{bucket: bucket, key: key}
+	if strings.HasSuffix(key, "*") {
+		p.key, p.prefix = strings.TrimSuffix(key, "*"), true
+	} else if key == "" || strings.HasSuffix(key, "/") {
+		p.prefix = true
+	}
+	return p, nil
+}
+
+// pinSet holds the keys and prefixes that are never evicted by TTL or LRU.
+type pinSet struct {
+	mu   sync.RWMutex
+	pins map[string]pin
+}
+
+func newPinSet() *pinSet {
+	return &pinSet{pins: make(map[string]pin)}
+}
+
+// add pins a pattern and returns its normalized form.
+func (s *pinSet) add(pattern string) (string, error) {
+	p, err := parsePin(pattern)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pins[p.String()] = p
+	return p.String(), nil
+}
+
+// remove unpins a pattern, reporting whether it was pinned.
+func (s *pinSet) remove(pattern string) bool {
+	p, err := parsePin(pattern)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pins[p.String()]; !ok {
+		return false
+	}
+	delete(s.pins, p.String())
+	return true
+}
+
+// list returns every pinned pattern, sorted.
+func (s *pinSet) list() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]string, 0, len(s.pins))
+	for pattern := range s.pins {
+		out = append(out, pattern)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// pinned reports whether a key is covered by any pin.
+func (s *pinSet) pinned(bucket, key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.pins {
+		if p.matches(bucket, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// exactKeys returns the individually pinned keys, which can be fetched
+// ahead of time since they name specific objects.
+func (s *pinSet) exactKeys() []warmEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []warmEntry
+	for _, p := range s.pins {
+		if !p.prefix {
+			entries = append(entries, warmEntry{Bucket: p.bucket, Key: p.key})
+		}
+	}
+	return entries
+}
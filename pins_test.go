@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestParsePin(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{"ref/genome.fa", "ref/genome.fa", false},
+		{"ref/datasets/*", "ref/datasets/*", false},
+		{"ref/datasets/", "ref/datasets/*", false},
+		{"ref", "", true},
+		{"/key", "", true},
+		{"ref/", "ref/*", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			p, err := parsePin(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePin(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+			if err == nil && p.String() != tt.want {
+				t.Errorf("parsePin(%q) = %q, want %q", tt.pattern, p.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPinSet_Pinned(t *testing.T) {
+	pins := newPinSet()
+	for _, pattern := range []string{"ref/genome.fa", "ref/datasets/*"} {
+		if _, err := pins.add(pattern); err != nil {
+			t.Fatalf("add(%q): %v", pattern, err)
+		}
+	}
+
+	tests := []struct {
+		bucket, key string
+		want        bool
+	}{
+		{"ref", "genome.fa", true},
+		{"ref", "genome.fa.fai", false},
+		{"ref", "datasets/a/b.parquet", true},
+		{"other", "datasets/a/b.parquet", false},
+	}
+	for _, tt := range tests {
+		if got := pins.pinned(tt.bucket, tt.key); got != tt.want {
+			t.Errorf("pinned(%s, %s) = %v, want %v", tt.bucket, tt.key, got, tt.want)
+		}
+	}
+
+	if !pins.remove("ref/datasets/") {
+		t.Error("remove of pinned prefix should succeed")
+	}
+	if pins.remove("ref/datasets/") {
+		t.Error("second remove should report not pinned")
+	}
+	if entries := pins.exactKeys(); len(entries) != 1 || entries[0].Key != "genome.fa" {
+		t.Errorf("exactKeys() = %v, want only genome.fa", entries)
+	}
+}
+
+func TestLazyBackend_PinnedNotEvicted(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	for _, key := range []string{"ref/big.bin", "a.txt", "b.txt"} {
+		data := bytes.Repeat([]byte("x"), 10)
+		if _, err := awsBackend.PutObject("test-bucket", key, nil, bytes.NewReader(data), int64(len(data)), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	if err := lazyBackend.SetPins([]string{"test-bucket/ref/*"}); err != nil {
+		t.Fatalf("SetPins: %v", err)
+	}
+
+	now := time.Now()
+	lazyBackend.index.now = func() time.Time { return now }
+	lazyBackend.SetCacheTTL(time.Minute, nil)
+	lazyBackend.SetCacheMaxBytes(20)
+
+	for _, key := range []string{"ref/big.bin", "a.txt", "b.txt"} {
+		obj, err := lazyBackend.GetObject("test-bucket", key, nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s): %v", key, err)
+		}
+		obj.Contents.Close()
+	}
+
+	// The pinned key is the least recently used, but a.txt is evicted instead
+	if _, err := localBackend.HeadObject("test-bucket", "ref/big.bin"); err != nil {
+		t.Errorf("pinned key should stay cached: %v", err)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "a.txt"); err == nil {
+		t.Error("a.txt should have been evicted")
+	}
+
+	// Past the TTL the pinned key is still served from cache
+	now = now.Add(time.Hour)
+	if lazyBackend.expired("test-bucket", "ref/big.bin") {
+		t.Error("pinned key should not expire")
+	}
+	if !lazyBackend.expired("test-bucket", "b.txt") {
+		t.Error("unpinned key should expire")
+	}
+}
+
+func TestAdminPins(t *testing.T) {
+	lazyBackend, _, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/admin/pins?pin=ref/datasets/"); rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodPost, "/admin/pins?pin=nobucket"); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST of invalid pin status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec := do(http.MethodGet, "/admin/pins")
+	if got := rec.Body.String(); got != "[\n  \"ref/datasets/*\"\n]\n" {
+		t.Errorf("GET response = %q, want the pinned prefix", got)
+	}
+	if !lazyBackend.pins.pinned("ref", "datasets/x") {
+		t.Error("pin added through the admin API should apply")
+	}
+	if rec := do(http.MethodDelete, "/admin/pins?pin=ref/datasets/*"); rec.Code != http.StatusOK {
+		t.Errorf("DELETE status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, "/admin/pins?pin=ref/datasets/*"); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
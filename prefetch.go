@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultPrefetchConcurrency bounds how many objects a prefetch job fetches
+// in parallel unless configured otherwise.
+const defaultPrefetchConcurrency = 8
+
+// maxPrefetchJobs bounds how many finished prefetch jobs are remembered for
+// progress reporting.
+const maxPrefetchJobs = 50
+
+// errNotListable is returned when prefetching from an upstream that can't
+// list keys, such as a URL source.
+var errNotListable = errors.New("upstream can't list keys")
+
+// prefetchJob reports the progress of one prefix prefetch.
+type prefetchJob struct {
+	ID         string     `json:"id"`
+	Bucket     string     `json:"bucket"`
+	Prefix     string     `json:"prefix"`
+	State      string     `json:"state"` // listing, fetching, done or failed
+	Listed     int        `json:"listed"`
+	Cached     int        `json:"cached"`
+	Failed     int        `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// prefetchJobs tracks running and recently finished prefetch jobs.
+type prefetchJobs struct {
+	mu     sync.Mutex
+	nextID int
+	jobs   []*prefetchJob // oldest first
+}
+
+// start registers a new job.
+func (p *prefetchJobs) start(bucket, prefix string) *prefetchJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	job := &prefetchJob{
+		ID:        strconv.Itoa(p.nextID),
+		Bucket:    bucket,
+		Prefix:    prefix,
+		State:     "listing",
+		StartedAt: time.Now(),
+	}
+	p.jobs = append(p.jobs, job)
+	// Forget the oldest finished jobs beyond the limit
+	for i := 0; len(p.jobs) > maxPrefetchJobs && i < len(p.jobs); {
+		if p.jobs[i].FinishedAt != nil {
+			p.jobs = append(p.jobs[:i], p.jobs[i+1:]...)
+			continue
+		}
+		i++
+	}
+	return job
+}
+
+// update changes a job while holding the lock.
+func (p *prefetchJobs) update(job *prefetchJob, fn func(*prefetchJob)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(job)
+}
+
+// list returns a snapshot of every job, oldest first.
+func (p *prefetchJobs) list() []prefetchJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]prefetchJob, len(p.jobs))
+	for i, job := range p.jobs {
+		out[i] = *job
+	}
+	return out
+}
+
+// get returns a snapshot of one job.
+func (p *prefetchJobs) get(id string) (prefetchJob, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, job := range p.jobs {
+		if job.ID == id {
+			return *job, true
+		}
+	}
+	return prefetchJob{}, false
+}
+
+// Prefetch lists every key under prefix upstream and fetches them into the
+// local cache in the background with a bounded worker pool. It returns the
+// job's ID immediately; progress is available from b.prefetches.
+func (b *LazyBackend) Prefetch(ctx context.Context, bucketName, prefix string, concurrency int) string {
+	if concurrency <= 0 {
+		concurrency = defaultPrefetchConcurrency
+	}
+	job := b.prefetches.start(b.canonicalBucket(bucketName), prefix)
+	go b.runPrefetch(ctx, job, concurrency)
+	return job.ID
+}
+
+func (b *LazyBackend) runPrefetch(ctx context.Context, job *prefetchJob, concurrency int) {
+	finish := func(err error) {
+		b.prefetches.update(job, func(j *prefetchJob) {
+			now := time.Now()
+			j.FinishedAt = &now
+			j.State = "done"
+			if err != nil {
+				j.State, j.Error = "failed", err.Error()
+			}
+		})
+	}
+
+	log.Printf("[PREFETCH] %s/%s: listing upstream", job.Bucket, job.Prefix)
+	keys, err := b.listUpstream(ctx, job.Bucket, job.Prefix)
+	if err != nil {
+		log.Printf("[PREFETCH] %s/%s: %v", job.Bucket, job.Prefix, err)
+		finish(err)
+		return
+	}
+	b.prefetches.update(job, func(j *prefetchJob) {
+		j.State, j.Listed = "fetching", len(keys)
+	})
+	log.Printf("[PREFETCH] %s/%s: fetching %d object(s)", job.Bucket, job.Prefix, len(keys))
+
+	entries := make([]warmEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = warmEntry{Bucket: job.Bucket, Key: key}
+	}
+	b.ensureBuckets(entries)
+	b.fillAll(ctx, entries, concurrency, func(e warmEntry, err error) {
+		if err != nil {
+			log.Printf("[PREFETCH] %s/%s: %v", e.Bucket, e.Key, err)
+		}
+		b.prefetches.update(job, func(j *prefetchJob) {
+			if err != nil {
+				j.Failed++
+			} else {
+				j.Cached++
+			}
+		})
+	})
+
+	snapshot, _ := b.prefetches.get(job.ID)
+	log.Printf("[PREFETCH] %s/%s: complete: %d cached, %d failed", job.Bucket, job.Prefix, snapshot.Cached, snapshot.Failed)
+	finish(ctx.Err())
+}
+
+// listUpstream returns every key under prefix in the upstream bucket that a
+// local bucket maps to. Folder placeholder keys ending in "/" are skipped.
+func (b *LazyBackend) listUpstream(ctx context.Context, bucketName, prefix string) ([]string, error) {
+	upstream, awsBucket := b.upstreamFor(bucketName)
+	lister, ok := upstream.(s3.ListObjectsV2APIClient)
+	if !ok {
+		return nil, fmt.Errorf("bucket %s: %w", bucketName, errNotListable)
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(lister, &s3.ListObjectsV2Input{
+		Bucket: aws.String(awsBucket),
+		Prefix: aws.String(prefix),
+	})
+	var keys []string
+	for paginator.HasMorePages() {
+		pageCtx, cancel := b.operation(ctx, opList)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, b.quirks.translate(err, bucketName, prefix)
+		}
+		for _, obj := range page.Contents {
+			if key := aws.ToString(obj.Key); key != "" && !strings.HasSuffix(key, "/") {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
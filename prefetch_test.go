@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// waitForPrefetch polls a prefetch job until it finishes.
+func waitForPrefetch(t *testing.T, lazyBackend *LazyBackend, id string) prefetchJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, ok := lazyBackend.prefetches.get(id); ok && job.FinishedAt != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("prefetch job %s didn't finish", id)
+	return prefetchJob{}
+}
+
+func TestLazyBackend_Prefetch(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if err := awsBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create AWS bucket: %v", err)
+	}
+	for _, key := range []string{"fixtures/a.json", "fixtures/nested/b.json", "fixtures/", "other/c.json"} {
+		if _, err := awsBackend.PutObject("test-bucket", key, nil,
+			bytes.NewReader([]byte(key)), int64(len(key)), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+
+	job := waitForPrefetch(t, lazyBackend, lazyBackend.Prefetch(context.Background(), "test-bucket", "fixtures/", 2))
+	if job.State != "done" || job.Listed != 2 || job.Cached != 2 || job.Failed != 0 {
+		t.Errorf("job = %+v, want 2 listed and cached", job)
+	}
+	for _, key := range []string{"fixtures/a.json", "fixtures/nested/b.json"} {
+		if _, err := localBackend.HeadObject("test-bucket", key); err != nil {
+			t.Errorf("%s should be cached: %v", key, err)
+		}
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "other/c.json"); err == nil {
+		t.Error("keys outside the prefix should not be fetched")
+	}
+
+	job = waitForPrefetch(t, lazyBackend, lazyBackend.Prefetch(context.Background(), "missing-bucket", "", 2))
+	if job.State != "failed" || job.Error == "" {
+		t.Errorf("job for missing bucket = %+v, want failed", job)
+	}
+}
+
+func TestLazyBackend_PrefetchURLSource(t *testing.T) {
+	lazyBackend := NewLazyBackend(s3mem.New(), nil)
+	if err := lazyBackend.SetURLSources(map[string]string{"cdn": "https://cdn.example.com/{key}"}, false); err != nil {
+		t.Fatalf("SetURLSources: %v", err)
+	}
+	job := waitForPrefetch(t, lazyBackend, lazyBackend.Prefetch(context.Background(), "cdn", "", 2))
+	if job.State != "failed" || !strings.Contains(job.Error, "can't list") {
+		t.Errorf("job = %+v, want failure for an unlistable upstream", job)
+	}
+}
+
+func TestAdminPrefetch(t *testing.T) {
+	lazyBackend, _, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	if err := awsBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create AWS bucket: %v", err)
+	}
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/admin/prefetch?prefix=test-bucket/fixtures/")
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"id": "1"`) {
+		t.Fatalf("POST = %d %s, want job 1 accepted", rec.Code, rec.Body)
+	}
+	waitForPrefetch(t, lazyBackend, "1")
+	if rec := do(http.MethodGet, "/admin/prefetch/1"); !strings.Contains(rec.Body.String(), `"state": "done"`) {
+		t.Errorf("GET job = %s, want done", rec.Body)
+	}
+	if rec := do(http.MethodGet, "/admin/prefetch/99"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET unknown job status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(http.MethodPost, "/admin/prefetch"); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST without prefix status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
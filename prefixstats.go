@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxTrackedPrefixes caps the number of distinct prefixes tracked, so a
+// deep or high-cardinality key space can't grow the stats without bound.
+// Further prefixes are folded into otherPrefix.
+const maxTrackedPrefixes = 10000
+
+// otherPrefix aggregates prefixes seen after maxTrackedPrefixes is reached.
+const otherPrefix = "(other)"
+
+// prefixCounters are the statistics kept for a single bucket/prefix.
+type prefixCounters struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	EgressBytes int64 `json:"egress_bytes"`
+}
+
+// prefixReport is one row of the hot-prefix report.
+type prefixReport struct {
+	Bucket   string  `json:"bucket"`
+	Prefix   string  `json:"prefix"`
+	Requests int64   `json:"requests"`
+	HitRatio float64 `json:"hit_ratio"`
+	prefixCounters
+}
+
+type prefixKey struct {
+	bucket string
+	prefix string
+}
+
+// prefixStats aggregates hit/miss/egress statistics by key prefix, truncated
+// to a configurable number of path segments.
+type prefixStats struct {
+	depth int
+
+	mu       sync.Mutex
+	counters map[prefixKey]*prefixCounters
+}
+
+// newPrefixStats creates prefix statistics aggregated at depth path segments.
+func newPrefixStats(depth int) *prefixStats {
+	return &prefixStats{
+		depth:    depth,
+		counters: make(map[prefixKey]*prefixCounters),
+	}
+}
+
+// prefixOf returns the first depth directory segments of key, including the
+// trailing slash. Keys at the top level have an empty prefix.
+func prefixOf(key string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		next := strings.IndexByte(key[end:], '/')
+		if next < 0 {
+			break
+		}
+		end += next + 1
+	}
+	return key[:end]
+}
+
+// counter returns the counters for a key's prefix. Callers must hold s.mu.
+func (s *prefixStats) counter(bucket, key string) *prefixCounters {
+	k := prefixKey{bucket, prefixOf(key, s.depth)}
+	c, ok := s.counters[k]
+	if !ok {
+		if len(s.counters) >= maxTrackedPrefixes {
+			k.prefix = otherPrefix
+			if c, ok = s.counters[k]; ok {
+				return c
+			}
+		}
+		c = &prefixCounters{}
+		s.counters[k] = c
+	}
+	return c
+}
+
+// recordHit counts a request served from the local cache.
+func (s *prefixStats) recordHit(bucket, key string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counter(bucket, key).Hits++
+}
+
+// recordMiss counts a request that was fetched from upstream.
+func (s *prefixStats) recordMiss(bucket, key string, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.counter(bucket, key)
+	c.Misses++
+	c.EgressBytes += bytes
+}
+
+// report returns the top prefixes by request count. A limit of 0 returns all.
+func (s *prefixStats) report(limit int) []prefixReport {
+	if s == nil {
+		return []prefixReport{}
+	}
+	s.mu.Lock()
+	rows := make([]prefixReport, 0, len(s.counters))
+	for k, c := range s.counters {
+		row := prefixReport{Bucket: k.bucket, Prefix: k.prefix, prefixCounters: *c}
+		row.Requests = c.Hits + c.Misses
+		if row.Requests > 0 {
+			row.HitRatio = float64(c.Hits) / float64(row.Requests)
+		}
+		rows = append(rows, row)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Requests != rows[j].Requests {
+			return rows[i].Requests > rows[j].Requests
+		}
+		if rows[i].Bucket != rows[j].Bucket {
+			return rows[i].Bucket < rows[j].Bucket
+		}
+		return rows[i].Prefix < rows[j].Prefix
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrefixOf(t *testing.T) {
+	tests := []struct {
+		key   string
+		depth int
+		want  string
+	}{
+		{"a/b/c.txt", 1, "a/"},
+		{"a/b/c.txt", 2, "a/b/"},
+		{"a/b/c.txt", 5, "a/b/"},
+		{"a/b.txt", 2, "a/"},
+		{"top.txt", 1, ""},
+	}
+
+	for _, tt := range tests {
+		if got := prefixOf(tt.key, tt.depth); got != tt.want {
+			t.Errorf("prefixOf(%q, %d) = %q, want %q", tt.key, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixStats_Report(t *testing.T) {
+	s := newPrefixStats(1)
+	s.recordMiss("b", "logs/1.txt", 100)
+	s.recordHit("b", "logs/1.txt")
+	s.recordHit("b", "logs/2.txt")
+	s.recordMiss("b", "images/1.png", 50)
+
+	rows := s.report(0)
+	if len(rows) != 2 {
+		t.Fatalf("report rows = %d, want 2", len(rows))
+	}
+	hot := rows[0]
+	if hot.Prefix != "logs/" || hot.Requests != 3 || hot.Hits != 2 || hot.Misses != 1 || hot.EgressBytes != 100 {
+		t.Errorf("hottest prefix = %+v, want logs/ with 2 hits, 1 miss, 100 bytes", hot)
+	}
+
+	if rows := s.report(1); len(rows) != 1 {
+		t.Errorf("report(1) rows = %d, want 1", len(rows))
+	}
+}
+
+func TestLazyBackend_PrefixStats(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create local bucket: %v", err)
+	}
+	if err := awsBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create AWS bucket: %v", err)
+	}
+	content := []byte("hello")
+	if _, err := awsBackend.PutObject("test-bucket", "data/file.txt", nil,
+		bytes.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("Failed to put object in AWS: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		obj, err := lazyBackend.GetObject("test-bucket", "data/file.txt", nil)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		obj.Contents.Close()
+	}
+
+	rec := httptest.NewRecorder()
+	newAdminHandler(lazyBackend, DefaultConfig()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/stats/prefixes", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var rows []prefixReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("report rows = %d, want 1", len(rows))
+	}
+	row := rows[0]
+	if row.Bucket != "test-bucket" || row.Prefix != "data/" {
+		t.Errorf("row = %s/%s, want test-bucket/data/", row.Bucket, row.Prefix)
+	}
+	if row.Hits != 1 || row.Misses != 1 || row.EgressBytes != int64(len(content)) {
+		t.Errorf("row counters = %+v, want 1 hit, 1 miss, %d bytes", row.prefixCounters, len(content))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"iter"
+	"log"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// Purge removes an object cached from upstream and any chunks cached for
+// range reads of it from the local backend without touching upstream, so
+// the next GET fetches it again. It reports whether anything was cached.
+// Objects the cache index doesn't track are left alone, failing with
+// ErrNotCached: they were written by clients and exist nowhere else, or were
+// cached before a restart on a backend whose index isn't saved.
+func (b *LazyBackend) Purge(bucketName, objectName string) (bool, error) {
+	if err := b.rejectPurge(bucketName); err != nil {
+		return false, err
+	}
+	purged, err := b.purge(bucketName, objectName, false)
+	if err != nil || purged {
+		return purged, err
+	}
+	if _, err := b.local.HeadObject(b.canonicalBucket(bucketName), objectName); err == nil {
+		return false, errNotCached(bucketName, objectName)
+	}
+	return false, nil
+}
+
+// PurgePrefix purges every cached object in a bucket whose key starts with
+// prefix and returns how many were removed, and how many objects under it
+// were kept because the cache index doesn't track them, as Purge does. An
+// empty prefix purges the whole bucket but keeps the bucket itself.
+func (b *LazyBackend) PurgePrefix(bucketName, prefix string) (purged, kept int, err error) {
+	if err := b.rejectPurge(bucketName); err != nil {
+		return 0, 0, err
+	}
+	bucketName = b.canonicalBucket(bucketName)
+	for key, err := range localKeys(b.local, bucketName, prefix) {
+		if err != nil {
+			return purged, kept, err
+		}
+		ok, err := b.purge(bucketName, key, false)
+		if err != nil {
+			return purged, kept, err
+		}
+		if ok {
+			purged++
+		} else {
+			kept++
+		}
+	}
+	if kept > 0 {
+		log.Printf("[PURGE] %s/%s: kept %d object(s) not tracked as cached", bucketName, prefix, kept)
+	}
+	return purged, kept, nil
+}
+
+// errNotCached is the error purging an object the cache index doesn't track
+// fails with.
+func errNotCached(bucketName, objectName string) error {
+	return newFailure(ErrNotCached, gofakes3.ErrNoSuchKey, nil,
+		"%s/%s isn't tracked as cached, so it was kept: it was written by a client, or cached before a restart with a cache index that isn't saved; delete it with an S3 client",
+		bucketName, objectName)
+}
+
+// rejectPurge returns the error purging from a bucket fails with: nothing
+// may be removed while s3lazy is read-only, nor from a snapshot.
+func (b *LazyBackend) rejectPurge(bucketName string) error {
+	if b.toggles.readOnly.Load() {
+		return errReadOnly()
+	}
+	if isSnapshotBucket(b.canonicalBucket(bucketName)) {
+		return errSnapshotWrite(bucketName)
+	}
+	return nil
+}
+
+// purge removes a cached object and its chunks, and with written also an
+// object clients wrote, reporting whether there was anything to remove.
+func (b *LazyBackend) purge(bucketName, objectName string, written bool) (bool, error) {
+	bucketName = b.canonicalBucket(bucketName)
+	unlock := b.locks.Lock(bucketName, objectName)
+	defer unlock()
+
+	chunked := b.chunks.drop(bucketName, objectName)
+	entry, cached := b.index.lookup(bucketName, objectName)
+	if !cached && !written {
+		if chunked {
+			log.Printf("[PURGE] %s/%s (chunks)", bucketName, objectName)
+		}
+		return chunked, nil
+	}
+	if _, err := b.local.HeadObject(bucketName, objectName); err != nil {
+		if isNotFound(err) {
+			b.index.remove(bucketName, objectName)
+			if chunked {
+				log.Printf("[PURGE] %s/%s (chunks)", bucketName, objectName)
+			}
+			return chunked || cached, nil
+		}
+		return false, err
+	}
+
+	b.index.remove(bucketName, objectName)
+	b.localData.forget(bucketName, objectName)
+	if _, err := b.local.DeleteObject(bucketName, objectName); err != nil {
+		return false, err
+	}
+	log.Printf("[PURGE] %s/%s", bucketName, objectName)
+	b.events.record(bucketName, objectName, eventPurged, "")
+	b.notify(eventPurged, bucketName, objectName, entry.Size)
+	return true, nil
+}
+
+// localKeys yields every key in a local bucket under prefix, one listing
+// page at a time, so callers never hold the whole key set.
+func localKeys(backend gofakes3.Backend, bucketName, prefix string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for c, err := range localContents(backend, bucketName, prefix) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			if !yield(c.Key, nil) {
+				return
+			}
+		}
+	}
+}
+
+// localContents is localKeys with the listing's description of each key.
+func localContents(backend gofakes3.Backend, bucketName, prefix string) iter.Seq2[*gofakes3.Content, error] {
+	return func(yield func(*gofakes3.Content, error) bool) {
+		p := &gofakes3.Prefix{HasPrefix: prefix != "", Prefix: prefix}
+		page := gofakes3.ListBucketPage{MaxKeys: gofakes3.DefaultMaxBucketKeys}
+		for {
+			list, err := backend.ListBucket(bucketName, p, page)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, c := range list.Contents {
+				if !yield(c, nil) {
+					return
+				}
+			}
+			if !list.IsTruncated || list.NextMarker == "" {
+				return
+			}
+			page = gofakes3.ListBucketPage{Marker: list.NextMarker, HasMarker: true, MaxKeys: gofakes3.DefaultMaxBucketKeys}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminPurge(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create local bucket: %v", err)
+	}
+	for _, key := range []string{"logs/a.txt", "logs/b.txt", "data/c.txt"} {
+		if _, err := localBackend.PutObject("test-bucket", key, nil,
+			bytes.NewReader([]byte(key)), int64(len(key)), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+		lazyBackend.index.add("test-bucket", key, int64(len(key)), "")
+	}
+	// Written by a client, so it exists nowhere else
+	putString(t, lazyBackend, "logs/local.txt", "local")
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	if rec := do(http.MethodDelete, "/admin/cache/test-bucket/data/c.txt"); rec.Code != http.StatusOK {
+		t.Errorf("DELETE status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, "/admin/cache/test-bucket/data/c.txt"); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of purged key status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec := do(http.MethodPost, "/admin/cache/purge?prefix=test-bucket/logs/")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("purge status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Body.String(); got != "{\n  \"kept\": 1,\n  \"purged\": 2\n}\n" {
+		t.Errorf("purge response = %q, want 2 purged and 1 kept", got)
+	}
+
+	for _, key := range []string{"logs/a.txt", "logs/b.txt", "data/c.txt"} {
+		if _, err := localBackend.HeadObject("test-bucket", key); err == nil {
+			t.Errorf("%s should have been purged", key)
+		}
+	}
+
+	if _, err := localBackend.HeadObject("test-bucket", "logs/local.txt"); err != nil {
+		t.Errorf("logs/local.txt was written by a client and should be kept: %v", err)
+	}
+	rec = do(http.MethodDelete, "/admin/cache/test-bucket/logs/local.txt")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a local object status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if !strings.Contains(rec.Body.String(), "isn't tracked as cached") {
+		t.Errorf("DELETE of a local object response = %q, want it to say the object isn't tracked", rec.Body.String())
+	}
+	if _, err := lazyBackend.Purge("test-bucket", "logs/local.txt"); !errors.Is(err, ErrNotCached) {
+		t.Errorf("Purge of a local object error = %v, want ErrNotCached", err)
+	}
+
+	lazyBackend.toggles.readOnly.Store(true)
+	if rec := do(http.MethodPost, "/admin/cache/purge?prefix=test-bucket"); rec.Code != http.StatusForbidden {
+		t.Errorf("purge while read-only status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	lazyBackend.toggles.readOnly.Store(false)
+	if rec := do(http.MethodDelete, "/admin/cache/test-bucket@v1/logs/a.txt"); rec.Code != http.StatusForbidden {
+		t.Errorf("DELETE from a snapshot status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	if rec := do(http.MethodPost, "/admin/cache/purge"); rec.Code != http.StatusBadRequest {
+		t.Errorf("purge without prefix status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodPost, "/admin/cache/purge?prefix=missing-bucket"); rec.Code != http.StatusNotFound {
+		t.Errorf("purge of missing bucket status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// upstreamQuirks describes how an S3-compatible upstream deviates from AWS, so
+// error mapping and revalidation degrade gracefully instead of misreading its
+// responses.
+type upstreamQuirks struct {
+	name string
+
+	// notFoundCodes are error codes meaning the key or bucket doesn't exist
+	notFoundCodes map[string]bool
+
+	// notFoundByStatus treats any HTTP 404 as not found, whatever the code.
+	// Needed for upstreams that return non-standard or empty error bodies.
+	notFoundByStatus bool
+
+	// md5ETags is true when single-part ETags are the hex MD5 of the content,
+	// matching the ETag the local cache computes after a fill
+	md5ETags bool
+}
+
+// quirksProfiles are the built-in compatibility modes selectable in config.
+var quirksProfiles = map[string]*upstreamQuirks{
+	"aws": {
+		name:          "aws",
+		notFoundCodes: map[string]bool{"NoSuchKey": true, "NoSuchBucket": true, "NotFound": true},
+		md5ETags:      true,
+	},
+	"minio": {
+		name: "minio",
+		notFoundCodes: map[string]bool{
+			"NoSuchKey": true, "NoSuchBucket": true, "NotFound": true,
+			"XMinioInvalidObjectName": true,
+		},
+		notFoundByStatus: true,
+		md5ETags:         true,
+	},
+	"ceph": {
+		// RGW returns an empty body for some 404s and non-MD5 ETags for
+		// objects written with server-side encryption or via its Swift API
+		name:             "ceph",
+		notFoundCodes:    map[string]bool{"NoSuchKey": true, "NoSuchBucket": true, "NotFound": true},
+		notFoundByStatus: true,
+	},
+	"generic": {
+		// Lowest common denominator for unknown S3-compatible services
+		name:             "generic",
+		notFoundCodes:    map[string]bool{"NoSuchKey": true, "NoSuchBucket": true, "NotFound": true},
+		notFoundByStatus: true,
+	},
+}
+
+// lookupQuirks returns the named compatibility profile.
+func lookupQuirks(name string) (*upstreamQuirks, error) {
+	q, ok := quirksProfiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		names := make([]string, 0, len(quirksProfiles))
+		for n := range quirksProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown upstream quirks mode %q (valid options: %s)", name, strings.Join(names, ", "))
+	}
+	return q, nil
+}
+
+// isNotFound reports whether an upstream error means the object doesn't exist.
+func (q *upstreamQuirks) isNotFound(err error) bool {
+	if q.notFoundCodes[s3ErrorCode(err)] {
+		return true
+	}
+	if q.notFoundByStatus {
+		var respErr *awshttp.ResponseError
+		if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+			return true
+		}
+	}
+	return false
+}
+
+// translate converts an upstream error into the gofakes3 error returned to the
+// client. Only genuine "not found" responses become NoSuchKey; everything else
+// keeps its upstream code so access and availability problems aren't hidden.
+// Failures to reach upstream, or an open circuit keeping requests from it,
+// are ErrUpstreamUnavailable, and failures of s3lazy's own credentials
+// ErrUpstreamCredentials: the client's credentials are fine, so the upstream
+// code isn't passed on.
+func (q *upstreamQuirks) translate(err error, bucketName, objectName string) error {
+	if q.isNotFound(err) {
+		return gofakes3.KeyNotFound(objectName)
+	}
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		return newFailure(ErrUpstreamUnavailable, "ServiceUnavailable", err, "%s/%s is not cached and %s is unavailable; it is retried after %s", bucketName, objectName, open.name, open.until.Format(time.RFC3339))
+	}
+	if isCredentialError(err) {
+		return newFailure(ErrUpstreamCredentials, gofakes3.ErrInternal, err, "upstream credentials failing fetching %s/%s", bucketName, objectName)
+	}
+	if isUpstreamUnavailable(err) {
+		code := gofakes3.ErrorCode(s3ErrorCode(err))
+		if code == "" || strings.HasPrefix(string(code), "HTTP") {
+			code = gofakes3.ErrInternal
+		}
+		return newFailure(ErrUpstreamUnavailable, code, err, "upstream unavailable fetching %s/%s", bucketName, objectName)
+	}
+	return s3ErrorToGofakes3(err, bucketName, objectName)
+}
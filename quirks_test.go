@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// httpResponseError builds an SDK error carrying only an HTTP status, like
+// those returned when an upstream sends an empty error body.
+func httpResponseError(status int) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      errors.New("empty error body"),
+		},
+	}
+}
+
+func TestLookupQuirks(t *testing.T) {
+	for _, name := range []string{"aws", "minio", "ceph", "generic", " MinIO "} {
+		if _, err := lookupQuirks(name); err != nil {
+			t.Errorf("lookupQuirks(%q) failed: %v", name, err)
+		}
+	}
+
+	if _, err := lookupQuirks("swift"); err == nil {
+		t.Error("lookupQuirks should reject unknown modes")
+	}
+}
+
+func TestUpstreamQuirks_IsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		err  error
+		want bool
+	}{
+		{"aws NoSuchKey", "aws", &smithy.GenericAPIError{Code: "NoSuchKey"}, true},
+		{"aws HEAD NotFound", "aws", &smithy.GenericAPIError{Code: "NotFound"}, true},
+		{"aws AccessDenied", "aws", &smithy.GenericAPIError{Code: "AccessDenied"}, false},
+		{"aws bare 404", "aws", httpResponseError(http.StatusNotFound), false},
+		{"minio object name", "minio", &smithy.GenericAPIError{Code: "XMinioInvalidObjectName"}, true},
+		{"ceph bare 404", "ceph", httpResponseError(http.StatusNotFound), true},
+		{"generic bare 500", "generic", httpResponseError(http.StatusInternalServerError), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := lookupQuirks(tt.mode)
+			if err != nil {
+				t.Fatalf("lookupQuirks failed: %v", err)
+			}
+			if got := q.isNotFound(tt.err); got != tt.want {
+				t.Errorf("isNotFound() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamQuirks_TranslateKeepsAccessDenied(t *testing.T) {
+	q, _ := lookupQuirks("aws")
+
+	err := q.translate(&smithy.GenericAPIError{Code: "AccessDenied"}, "bucket", "key")
+	if gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Error("AccessDenied should not be reported as NoSuchKey")
+	}
+	if !gofakes3.HasErrorCode(err, gofakes3.ErrorCode("AccessDenied")) {
+		t.Errorf("expected AccessDenied, got %v", err)
+	}
+
+	err = q.translate(&smithy.GenericAPIError{Code: "NoSuchKey"}, "bucket", "key")
+	if !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("expected NoSuchKey, got %v", err)
+	}
+}
+
+func TestLazyBackend_SetUpstreamQuirks(t *testing.T) {
+	lazyBackend, _, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if lazyBackend.quirks.name != "aws" {
+		t.Errorf("default quirks = %q, want %q", lazyBackend.quirks.name, "aws")
+	}
+	if err := lazyBackend.SetUpstreamQuirks("ceph"); err != nil {
+		t.Fatalf("SetUpstreamQuirks failed: %v", err)
+	}
+	if lazyBackend.quirks.name != "ceph" {
+		t.Errorf("quirks = %q, want %q", lazyBackend.quirks.name, "ceph")
+	}
+	if err := lazyBackend.SetUpstreamQuirks("bogus"); err == nil {
+		t.Error("SetUpstreamQuirks should reject unknown modes")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// rangeHeader renders a range request as an HTTP Range header.
+func rangeHeader(rr *gofakes3.ObjectRangeRequest) string {
+	switch {
+	case rr.FromEnd:
+		return fmt.Sprintf("bytes=-%d", rr.End)
+	case rr.End == gofakes3.RangeNoEnd:
+		return fmt.Sprintf("bytes=%d-", rr.Start)
+	default:
+		return fmt.Sprintf("bytes=%d-%d", rr.Start, rr.End)
+	}
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range header.
+func parseContentRange(s string) (rng *gofakes3.ObjectRange, total int64, ok bool) {
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return nil, 0, false
+	}
+	span, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return nil, 0, false
+	}
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return nil, 0, false
+	}
+	start, err1 := strconv.ParseInt(from, 10, 64)
+	end, err2 := strconv.ParseInt(to, 10, 64)
+	total, err3 := strconv.ParseInt(size, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || end < start {
+		return nil, 0, false
+	}
+	return &gofakes3.ObjectRange{Start: start, Length: end - start + 1}, total, true
+}
+
+// passThroughRange serves a range read of an uncached object by forwarding
+// the same range to upstream, so only the requested bytes are downloaded.
+// With background fill on, the whole object is then cached in the
+// background for later reads.
+func (b *LazyBackend) passThroughRange(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	if b.toggles.offline.Load() {
+		return nil, errOffline(objectName)
+	}
+	acquired, err := b.shaper.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The GET runs until the client closes the contents
+	ctx, cancel := b.operation(ctx, opGet)
+	release := func() {
+		acquired()
+		cancel()
+	}
+
+	upstream, awsBucket := b.upstreamFor(bucketName)
+	out, err := upstream.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(awsBucket),
+		Key:    aws.String(objectName),
+		Range:  aws.String(rangeHeader(rangeRequest)),
+	})
+	if err != nil {
+		release()
+		log.Printf("[AWS ERROR] %s/%s: %v", awsBucket, objectName, err)
+		return nil, b.quirks.translate(err, bucketName, objectName)
+	}
+
+	var body io.ReadCloser = out.Body
+	rng, size, ok := parseContentRange(aws.ToString(out.ContentRange))
+	if !ok {
+		// The upstream ignored the range and sent the whole object: cut it out
+		size = aws.ToInt64(out.ContentLength)
+		if rng, err = rangeRequest.Range(size); err != nil {
+			out.Body.Close()
+			release()
+			return nil, err
+		}
+		if _, err := io.CopyN(io.Discard, out.Body, rng.Start); err != nil {
+			out.Body.Close()
+			release()
+			return nil, fmt.Errorf("skipping to byte %d of %s/%s: %w", rng.Start, awsBucket, objectName, err)
+		}
+		body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(out.Body, rng.Length), out.Body}
+	}
+
+	b.toggles.infof("[RANGE PASS-THROUGH] %s/%s bytes %d-%d of %d", bucketName, objectName, rng.Start, rng.Start+rng.Length-1, size)
+	b.stats.recordMiss(rng.Length)
+	b.prefixStats.recordMiss(bucketName, objectName, rng.Length)
+	if b.rangeFills != nil && b.caches(bucketName, objectName) && !b.tooLargeToCache(size) {
+		b.rangeFills.start(bucketName, objectName, b.fill)
+	}
+
+	return &gofakes3.Object{
+		Name:     objectName,
+		Metadata: upstreamMetadata(out),
+		Size:     size,
+		Hash:     parseETagToHash(out.ETag),
+		Range:    rng,
+		Contents: &streamBody{Reader: b.shaper.reader(body), body: body, release: release},
+	}, nil
+}
+
+// backgroundFills runs whole-object fills after range pass-through reads,
+// at most one per key at a time.
+type backgroundFills struct {
+	mu       sync.Mutex
+	inflight map[entryKey]bool
+	wg       sync.WaitGroup
+}
+
+func newBackgroundFills() *backgroundFills {
+	return &backgroundFills{inflight: make(map[entryKey]bool)}
+}
+
+// start fills a key in the background unless a fill of it is running.
+func (f *backgroundFills) start(bucketName, objectName string, fill func(ctx context.Context, bucketName, objectName string) error) {
+	k := entryKey{bucketName, objectName}
+	f.mu.Lock()
+	if f.inflight[k] {
+		f.mu.Unlock()
+		return
+	}
+	f.inflight[k] = true
+	f.mu.Unlock()
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer func() {
+			f.mu.Lock()
+			delete(f.inflight, k)
+			f.mu.Unlock()
+		}()
+		// The fill outlives the request that started it
+		if err := fill(context.Background(), bucketName, objectName); err != nil && !errors.Is(err, errNotCacheable) {
+			log.Printf("[BACKGROUND FILL ERROR] %s/%s: %v", bucketName, objectName, err)
+		}
+	}()
+}
+
+// wait blocks until every running fill has finished.
+func (f *backgroundFills) wait() {
+	if f != nil {
+		f.wg.Wait()
+	}
+}
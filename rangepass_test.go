@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestRangeHeader(t *testing.T) {
+	tests := []struct {
+		rr   gofakes3.ObjectRangeRequest
+		want string
+	}{
+		{gofakes3.ObjectRangeRequest{Start: 10, End: 19}, "bytes=10-19"},
+		{gofakes3.ObjectRangeRequest{Start: 10, End: gofakes3.RangeNoEnd}, "bytes=10-"},
+		{gofakes3.ObjectRangeRequest{FromEnd: true, End: 8}, "bytes=-8"},
+	}
+	for _, tt := range tests {
+		if got := rangeHeader(&tt.rr); got != tt.want {
+			t.Errorf("rangeHeader(%+v) = %q, want %q", tt.rr, got, tt.want)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	rng, total, ok := parseContentRange("bytes 10-19/100")
+	if !ok || rng.Start != 10 || rng.Length != 10 || total != 100 {
+		t.Errorf("parseContentRange = %+v, %d, %v; want start 10 length 10 of 100", rng, total, ok)
+	}
+	for _, bad := range []string{"", "bytes */100", "bytes 10-19", "bytes 19-10/100"} {
+		if _, _, ok := parseContentRange(bad); ok {
+			t.Errorf("parseContentRange(%q) should fail", bad)
+		}
+	}
+}
+
+func TestLazyBackend_RangePassthrough(t *testing.T) {
+	for _, backgroundFill := range []bool{false, true} {
+		lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+		defer awsServer.Close()
+		upstream := &rangeRecorder{upstreamClient: lazyBackend.awsClient}
+		lazyBackend.awsClient = upstream
+		lazyBackend.SetRangePassthrough(true, backgroundFill)
+
+		for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+			if err := b.CreateBucket("test-bucket"); err != nil {
+				t.Fatalf("Failed to create bucket: %v", err)
+			}
+		}
+		content := strings.Repeat("0123456789", 10)
+		if _, err := awsBackend.PutObject("test-bucket", "data.bin", map[string]string{"Content-Type": "application/octet-stream"},
+			strings.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+
+		obj, err := lazyBackend.GetObject("test-bucket", "data.bin", &gofakes3.ObjectRangeRequest{FromEnd: true, End: 15})
+		if err != nil {
+			t.Fatalf("GetObject: %v", err)
+		}
+		data, err := io.ReadAll(obj.Contents)
+		obj.Contents.Close()
+		if err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+		if string(data) != content[85:] {
+			t.Errorf("range = %q, want %q", data, content[85:])
+		}
+		if obj.Size != 100 || obj.Range == nil || obj.Range.Start != 85 || obj.Range.Length != 15 {
+			t.Errorf("object size %d range %+v, want size 100 and bytes 85-99", obj.Size, obj.Range)
+		}
+		if ranges := upstream.recorded(); len(ranges) == 0 || ranges[0] != "bytes=-15" {
+			t.Errorf("upstream GET ranges = %q, want the client's range forwarded first", ranges)
+		}
+		// A background fill adds the whole object once it starts
+		if stats := lazyBackend.Stats(); !backgroundFill && stats.BytesFromUpstream != 15 {
+			t.Errorf("bytes from upstream = %d, want only the range", stats.BytesFromUpstream)
+		}
+
+		lazyBackend.rangeFills.wait()
+		_, err = localBackend.HeadObject("test-bucket", "data.bin")
+		if backgroundFill && err != nil {
+			t.Errorf("background fill should cache the whole object: %v", err)
+		}
+		if !backgroundFill && err == nil {
+			t.Error("without background fill the object should not be cached")
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// redactMaxBytes bounds the objects a redactor inspects, since they are held
+// in memory while scanned. Larger objects are never cached: client reads
+// stream them and fills reject them.
+const redactMaxBytes = 64 << 20
+
+// redactionPlaceholder replaces every match of the built-in redactor.
+const redactionPlaceholder = "[REDACTED]"
+
+// errRedactionRejected is returned by a Redactor to keep an object out of the
+// cache entirely.
+var errRedactionRejected = errors.New("rejected by redaction policy")
+
+// Redactor inspects objects fetched from upstream before they are written to
+// the local cache.
+type Redactor interface {
+	// Redact returns the content to cache and how many sensitive matches it
+	// scrubbed, or errRedactionRejected to keep the object out of the cache.
+	Redact(bucket, key string, data []byte) ([]byte, int, error)
+}
+
+// regexRedactor is the built-in Redactor: it replaces matches of any pattern
+// with redactionPlaceholder, or rejects objects with a match.
+type regexRedactor struct {
+	patterns []*regexp.Regexp
+	reject   bool
+}
+
+// newRegexRedactor compiles patterns for action "scrub" or "reject".
+func newRegexRedactor(patterns []string, action string) (*regexRedactor, error) {
+	r := &regexRedactor{}
+	switch action {
+	case "", "scrub":
+	case "reject":
+		r.reject = true
+	default:
+		return nil, fmt.Errorf("unknown redaction action %q (valid options: scrub, reject)", action)
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+func (r *regexRedactor) Redact(bucket, key string, data []byte) ([]byte, int, error) {
+	matches := 0
+	for _, re := range r.patterns {
+		found := re.FindAllIndex(data, -1)
+		if len(found) == 0 {
+			continue
+		}
+		if r.reject {
+			return nil, 0, errRedactionRejected
+		}
+		matches += len(found)
+		data = re.ReplaceAllLiteral(data, []byte(redactionPlaceholder))
+	}
+	return data, matches, nil
+}
+
+// parseRedactPatterns splits the S3LAZY_REDACT_PATTERNS format: patterns
+// separated by ";", since commas are common in regular expressions.
+func parseRedactPatterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ";") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// redactionStats counts what the redactor did, for /admin/stats.
+type redactionStats struct {
+	Scanned  int64 `json:"scanned"`
+	Scrubbed int64 `json:"scrubbed"`
+	Matches  int64 `json:"matches"`
+	Rejected int64 `json:"rejected"`
+}
+
+type redactionCounters struct {
+	scanned, scrubbed, matches, rejected atomic.Int64
+}
+
+func (c *redactionCounters) snapshot() *redactionStats {
+	return &redactionStats{
+		Scanned:  c.scanned.Load(),
+		Scrubbed: c.scrubbed.Load(),
+		Matches:  c.matches.Load(),
+		Rejected: c.rejected.Load(),
+	}
+}
+
+// redact runs a downloaded object through the redactor and returns the
+// content to cache. A rejected object comes back with errRedactionRejected
+// and its original content, or none if it was too large to scan.
+func (b *LazyBackend) redact(bucketName, objectName string, body io.Reader, size int64) ([]byte, error) {
+	b.redaction.scanned.Add(1)
+	if size > redactMaxBytes {
+		b.redaction.rejected.Add(1)
+		log.Printf("[REDACT] %s/%s: %d bytes is too large to scan - not caching", bucketName, objectName, size)
+		return nil, errRedactionRejected
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s/%s: %w", bucketName, objectName, err)
+	}
+
+	redacted, matches, err := b.redactor.Redact(bucketName, objectName, data)
+	if errors.Is(err, errRedactionRejected) {
+		b.redaction.rejected.Add(1)
+		log.Printf("[REDACT] %s/%s: rejected - not caching", bucketName, objectName)
+		// Hand back the original so a client read can still be served
+		return data, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redacting %s/%s: %w", bucketName, objectName, err)
+	}
+	if matches > 0 {
+		b.redaction.scrubbed.Add(1)
+		b.redaction.matches.Add(int64(matches))
+		log.Printf("[REDACT] %s/%s: scrubbed %d match(es)", bucketName, objectName, matches)
+	}
+	return redacted, nil
+}
+
+// memoryObject serves an object the redactor kept out of the cache straight
+// from the downloaded copy.
+func memoryObject(objectName string, meta map[string]string, etag *string, data []byte, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	obj := &gofakes3.Object{
+		Name:     objectName,
+		Metadata: meta,
+		Size:     int64(len(data)),
+		Hash:     parseETagToHash(etag),
+	}
+	rng, err := rangeRequest.Range(obj.Size)
+	if err != nil {
+		return nil, err
+	}
+	if rng != nil {
+		data = data[rng.Start : rng.Start+rng.Length]
+		obj.Range = rng
+	}
+	obj.Contents = io.NopCloser(bytes.NewReader(data))
+	return obj, nil
+}
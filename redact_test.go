@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestRegexRedactor(t *testing.T) {
+	scrub, err := newRegexRedactor([]string{`\d{3}-\d{2}-\d{4}`, `[\w.]+@example\.com`}, "scrub")
+	if err != nil {
+		t.Fatalf("newRegexRedactor: %v", err)
+	}
+	got, matches, err := scrub.Redact("b", "k", []byte("ssn 123-45-6789, mail bob@example.com and 987-65-4321"))
+	if err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	if want := "ssn [REDACTED], mail [REDACTED] and [REDACTED]"; string(got) != want || matches != 3 {
+		t.Errorf("Redact = %q (%d matches), want %q (3 matches)", got, matches, want)
+	}
+
+	reject, _ := newRegexRedactor([]string{`\d{3}-\d{2}-\d{4}`}, "reject")
+	if _, _, err := reject.Redact("b", "k", []byte("ssn 123-45-6789")); !errors.Is(err, errRedactionRejected) {
+		t.Errorf("reject error = %v, want errRedactionRejected", err)
+	}
+	if got, _, err := reject.Redact("b", "k", []byte("clean")); err != nil || string(got) != "clean" {
+		t.Errorf("reject of clean data = %q, %v; want it unchanged", got, err)
+	}
+
+	if _, err := newRegexRedactor([]string{"("}, "scrub"); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if _, err := newRegexRedactor(nil, "mask"); err == nil {
+		t.Error("expected error for unknown action")
+	}
+}
+
+func TestLazyBackend_Redaction(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	for key, content := range map[string]string{"users.csv": "alice,123-45-6789\n", "readme.txt": "nothing here\n"} {
+		if _, err := awsBackend.PutObject("test-bucket", key, nil, strings.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	read := func(key string) string {
+		t.Helper()
+		obj, err := lazyBackend.GetObject("test-bucket", key, nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s): %v", key, err)
+		}
+		defer obj.Contents.Close()
+		data, _ := io.ReadAll(obj.Contents)
+		return string(data)
+	}
+
+	scrub, _ := newRegexRedactor([]string{`\d{3}-\d{2}-\d{4}`}, "scrub")
+	lazyBackend.SetRedactor(scrub)
+	if got := read("users.csv"); got != "alice,[REDACTED]\n" {
+		t.Errorf("users.csv = %q, want the SSN scrubbed", got)
+	}
+	if got := read("readme.txt"); got != "nothing here\n" {
+		t.Errorf("readme.txt = %q, want it unchanged", got)
+	}
+	if got := lazyBackend.Stats().Redaction; got == nil || *got != (redactionStats{Scanned: 2, Scrubbed: 1, Matches: 1}) {
+		t.Errorf("redaction stats = %+v, want 2 scanned, 1 scrubbed", got)
+	}
+
+	// A rejected object is served to the client but never cached
+	if _, err := lazyBackend.Purge("test-bucket", "users.csv"); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	reject, _ := newRegexRedactor([]string{`\d{3}-\d{2}-\d{4}`}, "reject")
+	lazyBackend.SetRedactor(reject)
+	if got := read("users.csv"); got != "alice,123-45-6789\n" {
+		t.Errorf("rejected users.csv = %q, want the upstream content", got)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "users.csv"); err == nil {
+		t.Error("rejected objects should not be cached")
+	}
+	if err := lazyBackend.fill(context.Background(), "test-bucket", "users.csv"); !errors.Is(err, errNotCacheable) {
+		t.Errorf("fill error = %v, want errNotCacheable", err)
+	}
+}
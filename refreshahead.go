@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// defaultRefreshAheadMinHits is how many hits since it was fetched make a
+// cached object hot enough to refresh ahead of its expiry.
+const defaultRefreshAheadMinHits = 3
+
+// refreshAheadPolicy decides which hot objects are re-fetched shortly before
+// their TTL runs out, so busy keys are revalidated in the background instead
+// of by a client.
+type refreshAheadPolicy struct {
+	window  time.Duration // before expiry in which hot objects are refreshed
+	minHits int64         // since the last fetch, for an object to be hot
+}
+
+// refreshAheadInterval is how often objects are checked for refresh-ahead
+// with a given window, so that an expiring object is seen inside it.
+func refreshAheadInterval(window time.Duration) time.Duration {
+	return min(max(window/2, time.Second), time.Minute)
+}
+
+// SetRefreshAhead refreshes cached objects with at least minHits hits since
+// they were fetched once they are within window of expiring. A zero window
+// disables it.
+func (b *LazyBackend) SetRefreshAhead(window time.Duration, minHits int) {
+	b.refreshAhead = nil
+	if window > 0 {
+		b.refreshAhead = &refreshAheadPolicy{window: window, minHits: int64(max(minHits, 1))}
+	}
+}
+
+// refreshAheadCandidates returns the hot cached objects that expire within
+// the window, soonest first.
+func (b *LazyBackend) refreshAheadCandidates() []cacheEntry {
+	b.index.mu.Lock()
+	now := b.index.now()
+	var hot []cacheEntry
+	for _, e := range b.index.entries {
+		if e.recentHits >= b.refreshAhead.minHits {
+			hot = append(hot, *e)
+		}
+	}
+	b.index.mu.Unlock()
+
+	var due []cacheEntry
+	for _, e := range hot {
+		ttl := b.entryTTL(e)
+		if ttl <= 0 || b.pins.pinned(e.Bucket, e.Key) {
+			continue
+		}
+		if e.CachedAt.Add(ttl).Sub(now) <= b.refreshAhead.window {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CachedAt.Add(b.entryTTL(due[i])).Before(due[j].CachedAt.Add(b.entryTTL(due[j])))
+	})
+	return due
+}
+
+// RefreshAhead revalidates the hot objects about to expire, re-downloading
+// those that changed upstream. It returns the number refreshed.
+func (b *LazyBackend) RefreshAhead(ctx context.Context) int {
+	if b.refreshAhead == nil || b.toggles.offline.Load() {
+		return 0
+	}
+	refreshed := 0
+	for _, e := range b.refreshAheadCandidates() {
+		if ctx.Err() != nil {
+			break
+		}
+		log.Printf("[REFRESH AHEAD] %s/%s (%d hits since fetched)", e.Bucket, e.Key, e.recentHits)
+		_, err := b.refresh(ctx, e.Bucket, e.Key, false)
+		switch {
+		case err == nil:
+			refreshed++
+		case errors.Is(err, errNotCacheable):
+			// refresh dropped the cached copy
+		case isNotFound(err):
+			if _, err := b.purge(e.Bucket, e.Key, false); err != nil {
+				log.Printf("[REFRESH AHEAD ERROR] %s/%s: %v", e.Bucket, e.Key, err)
+			}
+		default:
+			// Left to expire; the next read tries again
+			log.Printf("[REFRESH AHEAD ERROR] %s/%s: %v", e.Bucket, e.Key, err)
+		}
+	}
+	return refreshed
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_RefreshAhead(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	now := time.Now()
+	lazyBackend.index.now = func() time.Time { return now }
+	lazyBackend.SetCacheTTL(time.Hour, nil)
+	lazyBackend.SetRefreshAhead(5*time.Minute, 2)
+
+	for _, key := range []string{"hot.json", "cold.json"} {
+		putString(t, awsBackend, key, "v1")
+		readObject(t, lazyBackend, key, nil)
+	}
+	readObject(t, lazyBackend, "hot.json", nil)
+	readObject(t, lazyBackend, "hot.json", nil)
+	readObject(t, lazyBackend, "cold.json", nil)
+	putString(t, awsBackend, "hot.json", "v2")
+	putString(t, awsBackend, "cold.json", "v2")
+
+	if n := lazyBackend.RefreshAhead(t.Context()); n != 0 {
+		t.Errorf("refreshed %d object(s) long before expiry, want 0", n)
+	}
+	now = now.Add(56 * time.Minute)
+	if n := lazyBackend.RefreshAhead(t.Context()); n != 1 {
+		t.Errorf("refreshed %d object(s), want the hot one", n)
+	}
+	if _, got := readObject(t, localBackend, "hot.json", nil); got != "v2" {
+		t.Errorf("hot.json = %q, want it refreshed to v2", got)
+	}
+	if _, got := readObject(t, localBackend, "cold.json", nil); got != "v1" {
+		t.Errorf("cold.json = %q, want it left to expire", got)
+	}
+	if age, _ := lazyBackend.index.age("test-bucket", "hot.json"); age != 0 {
+		t.Errorf("hot.json age = %v, want its TTL restarted", age)
+	}
+
+	// It has to be hot again before it is refreshed again
+	now = now.Add(56 * time.Minute)
+	if n := lazyBackend.RefreshAhead(t.Context()); n != 0 {
+		t.Errorf("refreshed %d object(s) without new hits, want 0", n)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// regionCorrector sends each bucket's requests to the region it lives in.
+// AWS answers requests signed for the wrong region with a redirect or an
+// AuthorizationHeaderMalformed error; the bucket's region is then taken from
+// the response, or looked up with a HeadBucket, remembered, and the request
+// retried there.
+type regionCorrector struct {
+	client *s3.Client
+
+	mu      sync.RWMutex
+	regions map[string]string // by bucket
+}
+
+func newRegionCorrector(client *s3.Client) *regionCorrector {
+	return &regionCorrector{client: client, regions: make(map[string]string)}
+}
+
+// regionOf returns the region a bucket was found to be in, if it was
+// corrected.
+func (c *regionCorrector) regionOf(bucket string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	region, ok := c.regions[bucket]
+	return region, ok
+}
+
+// wrongRegion reports whether an error means the request was signed for the
+// wrong region, and the bucket's region if the response named it.
+func wrongRegion(err error) (string, bool) {
+	var respErr *awshttp.ResponseError
+	if !errors.As(err, &respErr) {
+		return "", false
+	}
+	switch respErr.HTTPStatusCode() {
+	case http.StatusMovedPermanently, http.StatusTemporaryRedirect:
+	default:
+		if code := s3ErrorCode(err); code != "AuthorizationHeaderMalformed" && code != "PermanentRedirect" {
+			return "", false
+		}
+	}
+	if respErr.Response == nil {
+		return "", true
+	}
+	return respErr.Response.Header.Get("X-Amz-Bucket-Region"), true
+}
+
+// resolve looks up a bucket's region with a HeadBucket. AWS names the
+// region whether or not the request was signed for it.
+func (c *regionCorrector) resolve(ctx context.Context, bucket string) (string, error) {
+	out, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		return aws.ToString(out.BucketRegion), nil
+	}
+	if region, ok := wrongRegion(err); ok && region != "" {
+		return region, nil
+	}
+	return "", err
+}
+
+// inRegion runs a request for bucket in its corrected region, if it has one,
+// and retries it once in the bucket's region if AWS says it is elsewhere.
+func inRegion[T any](ctx context.Context, c *regionCorrector, bucket string, optFns []func(*s3.Options), call func(optFns ...func(*s3.Options)) (T, error)) (T, error) {
+	if isObjectLambdaARN(bucket) {
+		// Access point ARNs carry their own region
+		return call(optFns...)
+	}
+	if region, ok := c.regionOf(bucket); ok {
+		optFns = append(slices.Clip(optFns), withS3Region(region))
+	}
+	out, err := call(optFns...)
+	region, wrong := wrongRegion(err)
+	if !wrong {
+		return out, err
+	}
+	if region == "" {
+		resolved, resolveErr := c.resolve(ctx, bucket)
+		if resolveErr != nil || resolved == "" {
+			log.Printf("[REGION] %s answered for the wrong region, and its region couldn't be looked up: %v", bucket, resolveErr)
+			return out, err
+		}
+		region = resolved
+	}
+	if current, ok := c.regionOf(bucket); ok && current == region {
+		// Already asked the right region; the error is genuine
+		return out, err
+	}
+
+	c.mu.Lock()
+	c.regions[bucket] = region
+	c.mu.Unlock()
+	log.Printf("[REGION] bucket %s is in %s: retrying there", bucket, region)
+	return call(append(slices.Clip(optFns), withS3Region(region))...)
+}
+
+// withS3Region sends a request to region.
+func withS3Region(region string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.Region = region
+	}
+}
+
+func (c *regionCorrector) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return inRegion(ctx, c, aws.ToString(params.Bucket), optFns, func(optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+		return c.client.GetObject(ctx, params, optFns...)
+	})
+}
+
+func (c *regionCorrector) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return inRegion(ctx, c, aws.ToString(params.Bucket), optFns, func(optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+		return c.client.HeadObject(ctx, params, optFns...)
+	})
+}
+
+func (c *regionCorrector) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	return inRegion(ctx, c, aws.ToString(params.Bucket), optFns, func(optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+		return c.client.ListObjectsV2(ctx, params, optFns...)
+	})
+}
+
+func (c *regionCorrector) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	return inRegion(ctx, c, aws.ToString(params.Bucket), optFns, func(optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+		return c.client.GetObjectTagging(ctx, params, optFns...)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// newRegionalUpstream serves buckets that live in eu-west-1, answering
+// requests signed for any other region the way AWS does. It counts the
+// requests that were signed for the wrong region.
+func newRegionalUpstream(t *testing.T) (*regionCorrector, gofakes3.Backend, *int) {
+	t.Helper()
+	backend := s3mem.New()
+	faker := gofakes3.New(backend).Server()
+	wrong := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/s3/") {
+			faker.ServeHTTP(w, r)
+			return
+		}
+		wrong++
+		if r.Method == http.MethodHead {
+			w.Header().Set("X-Amz-Bucket-Region", "eu-west-1")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		// GETs name the region only in the error body
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`<Error><Code>AuthorizationHeaderMalformed</Code><Message>the region 'us-east-1' is wrong; expecting 'eu-west-1'</Message><Region>eu-west-1</Region></Error>`))
+	}))
+	t.Cleanup(server.Close)
+
+	awsCfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion("us-east-1"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+	)
+	if err != nil {
+		t.Fatalf("Failed to load AWS config: %v", err)
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(server.URL)
+		o.UsePathStyle = true
+	})
+	return newRegionCorrector(client), backend, &wrong
+}
+
+func TestRegionCorrector(t *testing.T) {
+	corrector, upstream, wrong := newRegionalUpstream(t)
+	if err := upstream.CreateBucket("eu-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	if _, err := upstream.PutObject("eu-bucket", "key", map[string]string{}, strings.NewReader("data"), 4, nil); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+
+	lazyBackend := NewLazyBackend(s3mem.New(), corrector)
+	if err := lazyBackend.CreateBucket("eu-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	// The GET's error doesn't name the region, so it is looked up
+	obj, err := lazyBackend.GetObject("eu-bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	obj.Contents.Close()
+	if region, _ := corrector.regionOf("eu-bucket"); region != "eu-west-1" {
+		t.Errorf("region of eu-bucket = %q, want eu-west-1", region)
+	}
+	if *wrong != 2 {
+		t.Errorf("requests to the wrong region = %d, want the GET and the lookup", *wrong)
+	}
+
+	// Later requests go straight to the bucket's region
+	if _, err := lazyBackend.HeadObject("eu-bucket", "missing"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("HeadObject: err = %v, want NoSuchKey", err)
+	}
+	if *wrong != 2 {
+		t.Errorf("requests to the wrong region = %d after correcting it, want no more", *wrong)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// defaultReportPrefix is where cache reports go in the report bucket unless
+// configured otherwise.
+const defaultReportPrefix = "s3lazy-reports/"
+
+// defaultReportInterval is how often cache reports are written unless
+// configured otherwise.
+const defaultReportInterval = time.Hour
+
+// cacheReport is a snapshot of one instance's cache usage, written to the
+// report bucket so usage across many instances can be aggregated centrally.
+type cacheReport struct {
+	Instance    string        `json:"instance"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Stats       statsReport   `json:"stats"`
+	Buckets     []bucketUsage `json:"buckets"`
+}
+
+// bucketUsage is what a report lists for each local bucket with cached
+// objects.
+type bucketUsage struct {
+	Bucket   string `json:"bucket"`
+	Upstream string `json:"upstream"`
+	Objects  int    `json:"objects"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// reportPutter uploads reports; *s3.Client implements it.
+type reportPutter interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// cacheReporter writes cache reports to an upstream bucket, one object per
+// report under the prefix and the instance's name.
+type cacheReporter struct {
+	client   reportPutter
+	bucket   string
+	prefix   string
+	instance string
+	now      func() time.Time
+}
+
+func newCacheReporter(client reportPutter, bucket, prefix, instance string) *cacheReporter {
+	return &cacheReporter{client: client, bucket: bucket, prefix: prefix, instance: instance, now: time.Now}
+}
+
+// key returns the key of a report generated at t, e.g.
+// "s3lazy-reports/dev-laptop/20261016T093000Z.json".
+func (r *cacheReporter) key(t time.Time) string {
+	return fmt.Sprintf("%s%s/%s.json", r.prefix, r.instance, t.UTC().Format("20060102T150405Z"))
+}
+
+// Report builds a cache report for the instance.
+func (b *LazyBackend) Report(instance string, now time.Time) cacheReport {
+	report := cacheReport{Instance: instance, GeneratedAt: now.UTC(), Stats: b.Stats(), Buckets: []bucketUsage{}}
+	usage := make(map[string]*bucketUsage)
+	b.index.mu.Lock()
+	for _, e := range b.index.entries {
+		u, ok := usage[e.Bucket]
+		if !ok {
+			u = &bucketUsage{Bucket: e.Bucket}
+			usage[e.Bucket] = u
+		}
+		u.Objects++
+		u.Bytes += e.Size
+	}
+	b.index.mu.Unlock()
+	for _, bucket := range slices.Sorted(maps.Keys(usage)) {
+		u := usage[bucket]
+		_, u.Upstream = b.upstreamFor(bucket)
+		report.Buckets = append(report.Buckets, *u)
+	}
+	return report
+}
+
+// WriteReport uploads a cache report to the report bucket and returns its
+// key. Nothing is written in offline mode.
+func (b *LazyBackend) WriteReport(ctx context.Context, r *cacheReporter) (string, error) {
+	if b.toggles.offline.Load() {
+		return "", nil
+	}
+	now := r.now()
+	data, err := json.MarshalIndent(b.Report(r.instance, now), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	key := r.key(now)
+	if _, err := r.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(r.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return "", fmt.Errorf("writing report to %s/%s: %w", r.bucket, key, err)
+	}
+	log.Printf("[REPORT] wrote %s/%s", r.bucket, key)
+	return key, nil
+}
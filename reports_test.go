@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_WriteReport(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	if err := awsBackend.CreateBucket("platform-reports"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	putString(t, awsBackend, "a.txt", "hello")
+	putString(t, awsBackend, "b.txt", "world!")
+	readObject(t, lazyBackend, "a.txt", nil)
+	readObject(t, lazyBackend, "b.txt", nil)
+	readObject(t, lazyBackend, "b.txt", nil)
+	putString(t, lazyBackend, "written.txt", "not cached from upstream")
+
+	reporter := newCacheReporter(lazyBackend.awsClient.(*s3.Client), "platform-reports", defaultReportPrefix, "dev-laptop")
+	reporter.now = func() time.Time { return time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC) }
+	key, err := lazyBackend.WriteReport(t.Context(), reporter)
+	if err != nil {
+		t.Fatalf("WriteReport: %v", err)
+	}
+	if key != "s3lazy-reports/dev-laptop/20261016T093000Z.json" {
+		t.Errorf("key = %q", key)
+	}
+
+	obj, err := awsBackend.GetObject("platform-reports", key, nil)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	defer obj.Contents.Close()
+	var report cacheReport
+	if err := json.NewDecoder(obj.Contents).Decode(&report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if report.Instance != "dev-laptop" || report.Stats.Hits != 1 || report.Stats.Misses != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	want := bucketUsage{Bucket: "test-bucket", Upstream: "test-bucket", Objects: 2, Bytes: 11}
+	if len(report.Buckets) != 1 || report.Buckets[0] != want {
+		t.Errorf("buckets = %+v, want %+v", report.Buckets, want)
+	}
+
+	lazyBackend.toggles.offline.Store(true)
+	if key, err := lazyBackend.WriteReport(t.Context(), reporter); key != "" || err != nil {
+		t.Errorf("WriteReport offline = %q, %v; want nothing written", key, err)
+	}
+}
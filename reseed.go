@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// localStackPollInterval is how often LocalStack is checked for a restart.
+const localStackPollInterval = 15 * time.Second
+
+// localStackWatcher detects LocalStack restarts. Without persistence enabled,
+// a restarted LocalStack comes back with no buckets at all, so a bucket list
+// that drops from non-empty to empty means its state was lost.
+type localStackWatcher struct {
+	backend gofakes3.Backend
+	seen    []string
+}
+
+// poll lists LocalStack's buckets and returns the buckets lost if it has
+// restarted since the last poll.
+func (w *localStackWatcher) poll() (lost []string, err error) {
+	buckets, err := w.backend.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets) == 0 && len(w.seen) > 0 {
+		lost, w.seen = w.seen, nil
+		return lost, nil
+	}
+	w.seen = w.seen[:0]
+	for _, b := range buckets {
+		w.seen = append(w.seen, b.Name)
+	}
+	return nil, nil
+}
+
+// run polls until ctx is cancelled, calling onRestart with the lost buckets
+// whenever a restart is detected.
+func (w *localStackWatcher) run(ctx context.Context, onRestart func(lost []string)) {
+	ticker := time.NewTicker(localStackPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			lost, err := w.poll()
+			if err != nil {
+				log.Printf("[LOCALSTACK] health check failed: %v", err)
+				continue
+			}
+			if len(lost) > 0 {
+				log.Printf("[LOCALSTACK] restart detected: %d bucket(s) lost", len(lost))
+				onRestart(lost)
+			}
+		}
+	}
+}
+
+// findLocalStack returns the LocalStack backend behind a local backend, if any.
+func findLocalStack(backend gofakes3.Backend) *LocalStackBackend {
+	switch b := backend.(type) {
+	case *LocalStackBackend:
+		return b
+	case *MultiplexBackend:
+		for _, routed := range b.backends() {
+			if ls, ok := routed.(*LocalStackBackend); ok {
+				return ls
+			}
+		}
+	}
+	return nil
+}
+
+// createInitBuckets creates the configured startup buckets. Buckets that
+// already exist are fine; other failures are logged and returned together so
+// strict mode can abort on them.
+func createInitBuckets(backend gofakes3.Backend, buckets []string) error {
+	var errs []error
+	for _, bucket := range buckets {
+		err := backend.CreateBucket(bucket)
+		switch {
+		case err == nil:
+			log.Printf("Created bucket: %s", bucket)
+		case gofakes3.IsAlreadyExists(err):
+			log.Printf("Bucket already exists: %s", bucket)
+		default:
+			log.Printf("Warning: couldn't create bucket %s: %v", bucket, err)
+			errs = append(errs, fmt.Errorf("bucket %s: %w", bucket, err))
+		}
+	}
+	return errors.Join(errs...)
+}
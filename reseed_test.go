@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func TestLocalStackWatcher_DetectsRestart(t *testing.T) {
+	backend := s3mem.New()
+	w := &localStackWatcher{backend: backend}
+
+	// Empty from the start: nothing to lose yet
+	if lost, err := w.poll(); err != nil || len(lost) != 0 {
+		t.Fatalf("poll on fresh backend = %v, %v, want no loss", lost, err)
+	}
+
+	if err := backend.CreateBucket("data"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if lost, err := w.poll(); err != nil || len(lost) != 0 {
+		t.Fatalf("poll with buckets = %v, %v, want no loss", lost, err)
+	}
+
+	// Simulate a restart wiping all state
+	if err := backend.DeleteBucket("data"); err != nil {
+		t.Fatalf("DeleteBucket failed: %v", err)
+	}
+	lost, err := w.poll()
+	if err != nil {
+		t.Fatalf("poll failed: %v", err)
+	}
+	if len(lost) != 1 || lost[0] != "data" {
+		t.Errorf("lost = %v, want [data]", lost)
+	}
+
+	// The restart is reported once
+	if lost, _ := w.poll(); len(lost) != 0 {
+		t.Errorf("second poll lost = %v, want none", lost)
+	}
+}
+
+func TestFindLocalStack(t *testing.T) {
+	ls, err := NewLocalStackBackend("http://localhost:4566", "us-east-1")
+	if err != nil {
+		t.Fatalf("NewLocalStackBackend failed: %v", err)
+	}
+
+	if findLocalStack(s3mem.New()) != nil {
+		t.Error("memory backend should not be reported as LocalStack")
+	}
+	if findLocalStack(ls) != ls {
+		t.Error("LocalStack backend not found")
+	}
+	mux := NewMultiplexBackend(s3mem.New(), map[string]gofakes3.Backend{"shared": ls})
+	if findLocalStack(mux) != ls {
+		t.Error("LocalStack backend behind the multiplexer not found")
+	}
+}
+
+// refusingBackend fails to create one bucket.
+type refusingBackend struct {
+	gofakes3.Backend
+	refuse string
+}
+
+func (b *refusingBackend) CreateBucket(name string) error {
+	if name == b.refuse {
+		return errors.New("permission denied")
+	}
+	return b.Backend.CreateBucket(name)
+}
+
+func TestCreateInitBuckets(t *testing.T) {
+	backend := &refusingBackend{Backend: s3mem.New(), refuse: "locked"}
+	if err := backend.Backend.CreateBucket("existing"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+
+	if err := createInitBuckets(backend, []string{"new", "existing"}); err != nil {
+		t.Errorf("existing buckets should not be an error, got %v", err)
+	}
+	err := createInitBuckets(backend, []string{"new", "locked"})
+	if err == nil || !strings.Contains(err.Error(), "bucket locked") {
+		t.Errorf("err = %v, want failure for bucket locked", err)
+	}
+}
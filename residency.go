@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// cachePolicy decides which local buckets may persist upstream objects.
+// Buckets that may not are proxy-only: reads are streamed from upstream on
+// every request and nothing is written to the local backend.
+type cachePolicy struct {
+	allow map[string]bool // nil allows every bucket not denied
+	deny  map[string]bool
+}
+
+// newCachePolicy creates a policy from allow and deny lists of local bucket
+// names. An empty allow list allows every bucket; deny wins over allow.
+func newCachePolicy(allow, deny []string) *cachePolicy {
+	p := &cachePolicy{deny: make(map[string]bool)}
+	if len(allow) > 0 {
+		p.allow = make(map[string]bool)
+		for _, bucket := range allow {
+			p.allow[bucket] = true
+		}
+	}
+	for _, bucket := range deny {
+		p.deny[bucket] = true
+	}
+	return p
+}
+
+// caches reports whether objects of a local bucket may be cached.
+func (p *cachePolicy) caches(bucket string) bool {
+	if p == nil {
+		return true
+	}
+	return !p.deny[bucket] && (p.allow == nil || p.allow[bucket])
+}
+
+// residencyRecord summarizes everything ever persisted locally from one
+// upstream bucket. Counts are cumulative and aren't reduced by eviction.
+type residencyRecord struct {
+	Bucket        string    `json:"bucket"`
+	LocalBuckets  []string  `json:"local_buckets"`
+	Objects       int64     `json:"objects"`
+	Bytes         int64     `json:"bytes"`
+	FirstCachedAt time.Time `json:"first_cached_at"`
+	LastCachedAt  time.Time `json:"last_cached_at"`
+}
+
+// residencyLedger records which upstream buckets have had bytes written to
+// the local backend, for compliance audits. With a path set it survives
+// restarts, and a bucket appearing for the first time is saved immediately.
+type residencyLedger struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	records map[string]*residencyRecord
+}
+
+func newResidencyLedger() *residencyLedger {
+	return &residencyLedger{now: time.Now, records: make(map[string]*residencyRecord)}
+}
+
+// record notes that an object from an upstream bucket was cached under a
+// local bucket.
+func (l *residencyLedger) record(upstreamBucket, localBucket string, bytes int64) error {
+	l.mu.Lock()
+	now := l.now()
+	r, ok := l.records[upstreamBucket]
+	if !ok {
+		r = &residencyRecord{Bucket: upstreamBucket, FirstCachedAt: now}
+		l.records[upstreamBucket] = r
+	}
+	r.Objects++
+	r.Bytes += bytes
+	r.LastCachedAt = now
+	if i := sort.SearchStrings(r.LocalBuckets, localBucket); i == len(r.LocalBuckets) || r.LocalBuckets[i] != localBucket {
+		r.LocalBuckets = append(r.LocalBuckets, localBucket)
+		sort.Strings(r.LocalBuckets)
+	}
+	l.mu.Unlock()
+
+	if !ok && l.path != "" {
+		return l.save()
+	}
+	return nil
+}
+
+// report returns every record, sorted by upstream bucket.
+func (l *residencyLedger) report() []residencyRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]residencyRecord, 0, len(l.records))
+	for _, r := range l.records {
+		rec := *r
+		rec.LocalBuckets = append([]string(nil), r.LocalBuckets...)
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Bucket < out[j].Bucket })
+	return out
+}
+
+// save writes the ledger to its path via a temp file and rename.
+func (l *residencyLedger) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(l.report())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// load reads the ledger saved at path and keeps saving there. A missing
+// file leaves the ledger empty.
+func (l *residencyLedger) load(path string) error {
+	l.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []residencyRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = make(map[string]*residencyRecord, len(records))
+	for i := range records {
+		l.records[records[i].Bucket] = &records[i]
+	}
+	return nil
+}
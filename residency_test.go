@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestCachePolicy(t *testing.T) {
+	var unset *cachePolicy
+	if !unset.caches("any") {
+		t.Error("a nil policy should cache every bucket")
+	}
+
+	p := newCachePolicy([]string{"assets", "prod-data"}, []string{"prod-data"})
+	for bucket, want := range map[string]bool{"assets": true, "prod-data": false, "other": false} {
+		if got := p.caches(bucket); got != want {
+			t.Errorf("caches(%s) = %v, want %v", bucket, got, want)
+		}
+	}
+	if p := newCachePolicy(nil, []string{"pii"}); !p.caches("other") || p.caches("pii") {
+		t.Error("a deny list alone should only make its buckets proxy-only")
+	}
+}
+
+func TestResidencyLedger_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "residency.json")
+	ledger := newResidencyLedger()
+	if err := ledger.load(path); err != nil {
+		t.Fatalf("load of missing file: %v", err)
+	}
+
+	// The first object from a bucket is saved right away
+	if err := ledger.record("prod-assets", "assets", 100); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := ledger.record("prod-assets", "assets-copy", 50); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	reloaded := newResidencyLedger()
+	if err := reloaded.load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := reloaded.report(); len(got) != 1 || got[0].Bucket != "prod-assets" {
+		t.Fatalf("reloaded report = %+v, want prod-assets", got)
+	}
+
+	if err := ledger.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := reloaded.load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	got := reloaded.report()[0]
+	if got.Objects != 2 || got.Bytes != 150 || strings.Join(got.LocalBuckets, ",") != "assets,assets-copy" {
+		t.Errorf("record = %+v, want 2 objects, 150 bytes from both local buckets", got)
+	}
+}
+
+func TestLazyBackend_ProxyOnlyBuckets(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	lazyBackend.SetCachePolicy(nil, []string{"prod-data"})
+
+	for _, bucket := range []string{"prod-data", "assets"} {
+		for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+			if err := b.CreateBucket(bucket); err != nil {
+				t.Fatalf("Failed to create bucket: %v", err)
+			}
+		}
+		if _, err := awsBackend.PutObject(bucket, "file.txt", nil, strings.NewReader(bucket), int64(len(bucket)), nil); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		obj, err := lazyBackend.GetObject(bucket, "file.txt", nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s): %v", bucket, err)
+		}
+		data, _ := io.ReadAll(obj.Contents)
+		obj.Contents.Close()
+		if string(data) != bucket {
+			t.Errorf("%s/file.txt = %q, want %q", bucket, data, bucket)
+		}
+	}
+
+	if _, err := localBackend.HeadObject("prod-data", "file.txt"); err == nil {
+		t.Error("objects in a proxy-only bucket should not be cached")
+	}
+	if _, err := localBackend.HeadObject("assets", "file.txt"); err != nil {
+		t.Errorf("objects in other buckets should be cached: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.CacheDenyBuckets = []string{"prod-data"}
+	rec := httptest.NewRecorder()
+	newAdminHandler(lazyBackend, cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/residency", nil))
+	var report struct {
+		Deny      []string          `json:"cache_deny_buckets"`
+		Persisted []residencyRecord `json:"persisted"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(report.Deny) != 1 || len(report.Persisted) != 1 || report.Persisted[0].Bucket != "assets" {
+		t.Errorf("residency report = %+v, want only assets persisted", report)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// retryClasses are the classes of upstream failures upstream_retry_on can
+// name, by name. Together they are the SDK's default retry policy.
+var retryClasses = map[string][]retry.IsErrorRetryable{
+	// Connection failures, resets and network timeouts
+	"connection": {retry.RetryableConnectionError{}},
+	// 500, 502, 503 and 504 responses, and RequestTimeout errors
+	"server": {
+		retry.RetryableHTTPStatusCode{Codes: retry.DefaultRetryableHTTPStatusCodes},
+		retry.RetryableErrorCode{Codes: retry.DefaultRetryableErrorCodes},
+	},
+	// SlowDown and the other throttling error codes
+	"throttling": {retry.RetryableErrorCode{Codes: retry.DefaultThrottleErrorCodes}},
+}
+
+// validateRetryOn checks the entries of upstream_retry_on: class names, or
+// S3 error codes, which start with a capital letter.
+func validateRetryOn(entries []string) error {
+	for _, entry := range entries {
+		if _, ok := retryClasses[entry]; ok {
+			continue
+		}
+		if entry == "" || !unicode.IsUpper(rune(entry[0])) {
+			classes := make([]string, 0, len(retryClasses))
+			for name := range retryClasses {
+				classes = append(classes, name)
+			}
+			slices.Sort(classes)
+			return fmt.Errorf("unknown class %q (want one of %s, or an error code such as InternalError)", entry, strings.Join(classes, ", "))
+		}
+	}
+	return nil
+}
+
+// retryables returns the checks of the failures upstream_retry_on retries.
+// Cancelled requests and errors the SDK marks as retryable are always
+// checked first.
+func retryables(entries []string) []retry.IsErrorRetryable {
+	checks := []retry.IsErrorRetryable{retry.NoRetryCanceledError{}, retry.RetryableError{}}
+	codes := make(map[string]struct{})
+	for _, entry := range entries {
+		if class, ok := retryClasses[entry]; ok {
+			checks = append(checks, class...)
+		} else {
+			codes[entry] = struct{}{}
+		}
+	}
+	if len(codes) > 0 {
+		checks = append(checks, retry.RetryableErrorCode{Codes: codes})
+	}
+	return checks
+}
+
+// upstreamRetryer returns the retryer of upstream clients when the retry
+// policy is configured, or nil to leave the SDK's in place. The number of
+// attempts is set through aws.Config.RetryMaxAttempts instead.
+func upstreamRetryer(cfg *Config) func() aws.Retryer {
+	if cfg.UpstreamMaxBackoff == 0 && len(cfg.UpstreamRetryOn) == 0 {
+		return nil
+	}
+	return func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			if cfg.UpstreamMaxBackoff > 0 {
+				o.MaxBackoff = cfg.UpstreamMaxBackoff
+			}
+			if len(cfg.UpstreamRetryOn) > 0 {
+				o.Retryables = retryables(cfg.UpstreamRetryOn)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// failingUpstream fails the first failures requests with status and code,
+// then serves an empty bucket.
+func failingUpstream(t *testing.T, failures int64, status int, code string) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	backend := s3mem.New()
+	if err := backend.CreateBucket("test-bucket"); err != nil {
+		t.Fatal(err)
+	}
+	faker := gofakes3.New(backend).Server()
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(status)
+			fmt.Fprintf(w, "<Error><Code>%s</Code><Message>flaky</Message></Error>", code)
+			return
+		}
+		faker.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestCreateUpstreamClient_RetryPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		maxAttempts  int
+		retryOn      []string
+		status       int
+		code         string
+		wantErr      bool
+		wantRequests int64
+	}{
+		{"more attempts", 5, nil, http.StatusServiceUnavailable, "ServiceUnavailable", false, 5},
+		{"SDK default attempts", 0, nil, http.StatusServiceUnavailable, "ServiceUnavailable", true, 3},
+		{"class not retried", 5, []string{"connection", "throttling"}, http.StatusServiceUnavailable, "ServiceUnavailable", true, 1},
+		{"error code retried", 5, []string{"XMinioServerNotInitialized"}, http.StatusBadRequest, "XMinioServerNotInitialized", false, 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := failingUpstream(t, 4, tt.status, tt.code)
+			cfg := DefaultConfig()
+			cfg.UpstreamEndpoint = server.URL
+			cfg.UpstreamAccessKey, cfg.UpstreamSecretKey = "test", "test"
+			cfg.UpstreamMaxAttempts = tt.maxAttempts
+			cfg.UpstreamMaxBackoff = time.Millisecond
+			cfg.UpstreamRetryOn = tt.retryOn
+			client, err := createUpstreamClient(cfg, BucketUpstream{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = client.ListObjectsV2(t.Context(), &s3.ListObjectsV2Input{Bucket: aws.String("test-bucket")})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListObjectsV2 error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("upstream got %d request(s), want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
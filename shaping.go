@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shapingRecheckInterval is how often a fetch waiting for a concurrency slot
+// re-reads the schedule, so a window opening up more slots is noticed.
+const shapingRecheckInterval = time.Second
+
+// shapingChunkSize caps how much a shaped read returns at once, keeping the
+// pauses between chunks short and even.
+const shapingChunkSize = 32 * 1024
+
+// TrafficRule limits upstream traffic during a daily time window.
+type TrafficRule struct {
+	// Window is "HH:MM-HH:MM" in local time; it may wrap past midnight
+	Window string `yaml:"window"`
+
+	// Bandwidth is the total upstream download rate per second (0 is unlimited)
+	Bandwidth byteSize `yaml:"bandwidth"`
+
+	// Concurrency is the number of simultaneous upstream fetches (0 is unlimited)
+	Concurrency int `yaml:"concurrency"`
+}
+
+// trafficWindow is a parsed TrafficRule, with times as minutes after midnight.
+type trafficWindow struct {
+	rule       TrafficRule
+	start, end int
+}
+
+// contains reports whether a minute of the day falls inside the window.
+func (w trafficWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+func (w trafficWindow) String() string {
+	bandwidth := "unlimited"
+	if w.rule.Bandwidth > 0 {
+		bandwidth = fmt.Sprintf("%d bytes/s", w.rule.Bandwidth)
+	}
+	concurrency := "unlimited"
+	if w.rule.Concurrency > 0 {
+		concurrency = strconv.Itoa(w.rule.Concurrency)
+	}
+	return fmt.Sprintf("%s (bandwidth %s, concurrency %s)", w.rule.Window, bandwidth, concurrency)
+}
+
+// parseTrafficRule validates a rule and parses its window.
+func parseTrafficRule(rule TrafficRule) (trafficWindow, error) {
+	from, to, ok := strings.Cut(rule.Window, "-")
+	if !ok {
+		return trafficWindow{}, fmt.Errorf("invalid window %q: want HH:MM-HH:MM", rule.Window)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return trafficWindow{}, fmt.Errorf("invalid window %q: %w", rule.Window, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return trafficWindow{}, fmt.Errorf("invalid window %q: %w", rule.Window, err)
+	}
+	if start == end {
+		return trafficWindow{}, fmt.Errorf("invalid window %q: start and end are the same", rule.Window)
+	}
+	if rule.Bandwidth < 0 || rule.Concurrency < 0 {
+		return trafficWindow{}, fmt.Errorf("window %s: limits must not be negative", rule.Window)
+	}
+	return trafficWindow{rule: rule, start: start, end: end}, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseTrafficSchedule parses the S3LAZY_TRAFFIC_SCHEDULE format: rules
+// separated by ";", each a window followed by bandwidth=SIZE and/or
+// concurrency=N, e.g. "09:00-18:00 bandwidth=10MiB concurrency=2".
+func parseTrafficSchedule(s string) ([]TrafficRule, error) {
+	var rules []TrafficRule
+	for _, part := range strings.Split(s, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		rule := TrafficRule{Window: fields[0]}
+		for _, f := range fields[1:] {
+			name, v, _ := strings.Cut(f, "=")
+			switch name {
+			case "bandwidth":
+				n, err := parseByteSize(v)
+				if err != nil {
+					return nil, err
+				}
+				rule.Bandwidth = n
+			case "concurrency":
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid concurrency %q", v)
+				}
+				rule.Concurrency = n
+			default:
+				return nil, fmt.Errorf("unknown limit %q (valid options: bandwidth, concurrency)", f)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// trafficShaper applies the limits of whichever window is active to upstream
+// fetches. Outside every window traffic is unlimited; when windows overlap,
+// the first one listed wins.
+type trafficShaper struct {
+	windows []trafficWindow
+	now     func() time.Time
+
+	mu     sync.Mutex
+	active int           // fetches holding a concurrency slot
+	freed  chan struct{} // closed and replaced whenever a slot is released
+	next   time.Time     // when the bandwidth budget is next available
+	last   int           // index of the window last logged, -1 for none
+}
+
+// newTrafficShaper creates a shaper for a schedule, or nil (no shaping) if
+// the schedule is empty.
+func newTrafficShaper(rules []TrafficRule) (*trafficShaper, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	s := &trafficShaper{now: time.Now, freed: make(chan struct{}), last: -1}
+	for _, rule := range rules {
+		w, err := parseTrafficRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// limits returns the limits in effect now. The caller must hold s.mu.
+func (s *trafficShaper) limits() TrafficRule {
+	now := s.now()
+	minute := now.Hour()*60 + now.Minute()
+	current := -1
+	for i, w := range s.windows {
+		if w.contains(minute) {
+			current = i
+			break
+		}
+	}
+	if current != s.last {
+		if current < 0 {
+			log.Printf("[SHAPING] outside traffic windows: unlimited")
+		} else {
+			log.Printf("[SHAPING] entering window %s", s.windows[current])
+		}
+		s.last = current
+	}
+	if current < 0 {
+		return TrafficRule{}
+	}
+	return s.windows[current].rule
+}
+
+// acquire waits for an upstream fetch slot and returns the function that
+// releases it.
+func (s *trafficShaper) acquire(ctx context.Context) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	for {
+		s.mu.Lock()
+		limit := s.limits().Concurrency
+		if limit == 0 || s.active < limit {
+			s.active++
+			s.mu.Unlock()
+			return s.release, nil
+		}
+		freed := s.freed
+		s.mu.Unlock()
+
+		timer := time.NewTimer(shapingRecheckInterval)
+		select {
+		case <-freed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+func (s *trafficShaper) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
+
+// reserve books n bytes of bandwidth and returns how long to wait before
+// using them. All fetches share one budget.
+func (s *trafficShaper) reserve(n int) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rate := int64(s.limits().Bandwidth)
+	if rate == 0 {
+		return 0
+	}
+	now := s.now()
+	if s.next.Before(now) {
+		s.next = now
+	}
+	s.next = s.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	return s.next.Sub(now)
+}
+
+// reader shapes a download to the bandwidth limit.
+func (s *trafficShaper) reader(r io.Reader) io.Reader {
+	if s == nil {
+		return r
+	}
+	return &shapedReader{r: r, shaper: s}
+}
+
+type shapedReader struct {
+	r      io.Reader
+	shaper *trafficShaper
+}
+
+func (r *shapedReader) Read(p []byte) (int, error) {
+	if len(p) > shapingChunkSize {
+		p = p[:shapingChunkSize]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if wait := r.shaper.reserve(n); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
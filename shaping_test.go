@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseTrafficSchedule(t *testing.T) {
+	rules, err := parseTrafficSchedule("09:00-18:00 bandwidth=10MiB concurrency=2; 22:00-06:00 concurrency=16")
+	if err != nil {
+		t.Fatalf("parseTrafficSchedule: %v", err)
+	}
+	want := []TrafficRule{
+		{Window: "09:00-18:00", Bandwidth: 10 << 20, Concurrency: 2},
+		{Window: "22:00-06:00", Concurrency: 16},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"09:00-18:00 speed=1M", "09:00-18:00 concurrency=lots"} {
+		if _, err := parseTrafficSchedule(bad); err == nil {
+			t.Errorf("parseTrafficSchedule(%q) should fail", bad)
+		}
+	}
+}
+
+func TestParseTrafficRule(t *testing.T) {
+	for _, bad := range []TrafficRule{
+		{Window: "9am-5pm"},
+		{Window: "09:00"},
+		{Window: "09:00-09:00"},
+		{Window: "09:00-10:00", Concurrency: -1},
+	} {
+		if _, err := parseTrafficRule(bad); err == nil {
+			t.Errorf("parseTrafficRule(%+v) should fail", bad)
+		}
+	}
+
+	w, err := parseTrafficRule(TrafficRule{Window: "22:00-06:00"})
+	if err != nil {
+		t.Fatalf("parseTrafficRule: %v", err)
+	}
+	for minute, want := range map[int]bool{23 * 60: true, 60: true, 6 * 60: false, 12 * 60: false} {
+		if got := w.contains(minute); got != want {
+			t.Errorf("contains(%d) = %v, want %v", minute, got, want)
+		}
+	}
+}
+
+func TestTrafficShaper_Concurrency(t *testing.T) {
+	shaper, err := newTrafficShaper([]TrafficRule{{Window: "09:00-18:00", Concurrency: 1}})
+	if err != nil {
+		t.Fatalf("newTrafficShaper: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	shaper.now = func() time.Time { return now }
+
+	release, err := shaper.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := shaper.acquire(ctx); err == nil {
+		t.Fatal("second fetch should wait for the only slot")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		r, _ := shaper.acquire(context.Background())
+		r()
+		close(acquired)
+	}()
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("releasing the slot should unblock the waiting fetch")
+	}
+
+	// Outside the window there is no limit
+	now = time.Date(2024, 1, 1, 20, 0, 0, 0, time.Local)
+	r1, _ := shaper.acquire(context.Background())
+	r2, _ := shaper.acquire(context.Background())
+	r1()
+	r2()
+}
+
+func TestTrafficShaper_Bandwidth(t *testing.T) {
+	shaper, err := newTrafficShaper([]TrafficRule{{Window: "00:00-12:00", Bandwidth: 1000}})
+	if err != nil {
+		t.Fatalf("newTrafficShaper: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	shaper.now = func() time.Time { return now }
+
+	if wait := shaper.reserve(500); wait != 500*time.Millisecond {
+		t.Errorf("first reserve wait = %v, want 500ms", wait)
+	}
+	// Reservations queue behind each other
+	if wait := shaper.reserve(500); wait != time.Second {
+		t.Errorf("second reserve wait = %v, want 1s", wait)
+	}
+
+	now = time.Date(2024, 1, 1, 13, 0, 0, 0, time.Local)
+	if wait := shaper.reserve(1 << 20); wait != 0 {
+		t.Errorf("reserve outside the window wait = %v, want 0", wait)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSLOWindow is the rolling window objectives are checked over unless
+// configured otherwise.
+const defaultSLOWindow = 5 * time.Minute
+
+// sloSlots is the number of slots a window is divided into. Requests older
+// than the window are dropped a slot at a time.
+const sloSlots = 10
+
+// sloOperations are the operations objectives can be set for. A GET counts
+// towards get and towards get_hit or get_miss.
+var sloOperations = []string{"get", "get_hit", "get_miss", "head", "put", "delete", "list"}
+
+// sloObjective is a latency or error objective for one operation, written
+// "get_hit:p99<20ms" or "get_miss:errors<1%".
+type sloObjective struct {
+	op string
+
+	// latency objectives: at least percentile% of requests take less than
+	// latency
+	percentile float64
+	latency    time.Duration
+
+	// error objectives: less than errorRate of requests fail with a 5xx
+	errorRate float64
+
+	text string
+}
+
+// parseSLO parses an objective.
+func parseSLO(s string) (sloObjective, error) {
+	o := sloObjective{text: strings.TrimSpace(s)}
+	op, rest, ok := strings.Cut(o.text, ":")
+	measure, target, ok2 := strings.Cut(rest, "<")
+	if !ok || !ok2 {
+		return o, fmt.Errorf("%q: want <operation>:p<percentile><<latency> or <operation>:errors<<percent>%%", s)
+	}
+	o.op, measure, target = strings.TrimSpace(op), strings.TrimSpace(measure), strings.TrimSpace(target)
+	if !slices.Contains(sloOperations, o.op) {
+		return o, fmt.Errorf("%q: unknown operation %q (want one of %s)", s, o.op, strings.Join(sloOperations, ", "))
+	}
+	if measure == "errors" {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(target, "%"), 64)
+		if err != nil || !strings.HasSuffix(target, "%") || percent <= 0 || percent >= 100 {
+			return o, fmt.Errorf("%q: error rate must be a percentage between 0%% and 100%%", s)
+		}
+		o.errorRate = percent / 100
+		return o, nil
+	}
+	p, ok := strings.CutPrefix(measure, "p")
+	percentile, err := strconv.ParseFloat(p, 64)
+	if !ok || err != nil || percentile <= 0 || percentile >= 100 {
+		return o, fmt.Errorf("%q: unknown measure %q (want a percentile such as p99, or errors)", s, measure)
+	}
+	latency, err := time.ParseDuration(target)
+	if err != nil || latency <= 0 {
+		return o, fmt.Errorf("%q: latency must be a positive duration", s)
+	}
+	o.percentile, o.latency = percentile, latency
+	return o, nil
+}
+
+// budget is the fraction of requests that may miss the objective.
+func (o sloObjective) budget() float64 {
+	if o.latency > 0 {
+		return 1 - o.percentile/100
+	}
+	return o.errorRate
+}
+
+// missed reports whether a request missed the objective.
+func (o sloObjective) missed(status int, elapsed time.Duration) bool {
+	if o.latency > 0 {
+		return elapsed >= o.latency
+	}
+	return status >= 500
+}
+
+// sloSlot counts the requests of one slot of the window.
+type sloSlot struct {
+	start  time.Time
+	total  int64
+	missed int64
+}
+
+// sloState tracks one objective over the rolling window.
+type sloState struct {
+	sloObjective
+	mu       sync.Mutex
+	slots    [sloSlots]sloSlot
+	violated bool
+	since    time.Time
+}
+
+// sloReport is the state of an objective in the SLO report.
+type sloReport struct {
+	Objective string    `json:"objective"`
+	Requests  int64     `json:"requests"`
+	Missed    int64     `json:"missed"`
+	Budget    float64   `json:"budget"`
+	MissRatio float64   `json:"miss_ratio"`
+	Violated  bool      `json:"violated"`
+	Since     time.Time `json:"since,omitzero"`
+}
+
+// sloTracker checks every objective against the requests of the last
+// window, and logs when one starts or stops being violated.
+type sloTracker struct {
+	window     time.Duration
+	slot       time.Duration
+	objectives []*sloState
+	now        func() time.Time
+}
+
+func newSLOTracker(objectives []sloObjective, window time.Duration) *sloTracker {
+	t := &sloTracker{window: window, slot: window / sloSlots, now: time.Now}
+	for _, o := range objectives {
+		t.objectives = append(t.objectives, &sloState{sloObjective: o})
+	}
+	return t
+}
+
+// SetSLOs checks latency and error objectives per operation over a rolling
+// window, e.g. "get_hit:p99<20ms". No objectives disables the checks.
+func (b *LazyBackend) SetSLOs(objectives []string, window time.Duration) error {
+	if len(objectives) == 0 {
+		b.slos = nil
+		return nil
+	}
+	if window < time.Second {
+		return fmt.Errorf("slo window must be at least 1s, got %v", window)
+	}
+	parsed := make([]sloObjective, 0, len(objectives))
+	for _, s := range objectives {
+		o, err := parseSLO(s)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, o)
+	}
+	b.slos = newSLOTracker(parsed, window)
+	return nil
+}
+
+// sloOperationsOf returns the operations a served request counts towards.
+func sloOperationsOf(r *http.Request, header http.Header) []string {
+	_, _, isObject := objectPath(r.URL.Path)
+	switch {
+	case r.Method == http.MethodGet && isObject && isObjectRead(r):
+		if header.Get(cacheStatusHeader) == cacheMiss {
+			return []string{"get", "get_miss"}
+		}
+		if header.Get(cacheStatusHeader) != "" {
+			return []string{"get", "get_hit"}
+		}
+		return []string{"get"}
+	case r.Method == http.MethodGet && !isObject && strings.Trim(r.URL.Path, "/") != "":
+		return []string{"list"}
+	case r.Method == http.MethodHead && isObject:
+		return []string{"head"}
+	case r.Method == http.MethodPut && isObject:
+		return []string{"put"}
+	case r.Method == http.MethodDelete && isObject:
+		return []string{"delete"}
+	}
+	return nil
+}
+
+// observe counts a served request towards the objectives of its
+// operations.
+func (t *sloTracker) observe(ops []string, status int, elapsed time.Duration) {
+	if t == nil || len(ops) == 0 {
+		return
+	}
+	now := t.now()
+	for _, s := range t.objectives {
+		if slices.Contains(ops, s.op) {
+			s.record(t, now, s.missed(status, elapsed))
+		}
+	}
+}
+
+// slotAt returns the slot now falls in, emptied if it last held requests
+// from an earlier window.
+func (s *sloState) slotAt(t *sloTracker, now time.Time) *sloSlot {
+	start := now.Truncate(t.slot)
+	slot := &s.slots[int(start.UnixNano()/int64(t.slot))%sloSlots]
+	if !slot.start.Equal(start) {
+		*slot = sloSlot{start: start}
+	}
+	return slot
+}
+
+func (s *sloState) record(t *sloTracker, now time.Time, missed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := s.slotAt(t, now)
+	slot.total++
+	if missed {
+		slot.missed++
+	}
+	s.evaluate(t, now)
+}
+
+// evaluate updates whether the objective is violated and logs changes. A
+// window needs enough requests for a single miss to be within budget before
+// it can violate the objective, so p99 needs at least 100 requests.
+func (s *sloState) evaluate(t *sloTracker, now time.Time) sloReport {
+	r := sloReport{Objective: s.text, Budget: s.budget()}
+	for _, slot := range s.slots {
+		if now.Sub(slot.start) < t.window {
+			r.Requests += slot.total
+			r.Missed += slot.missed
+		}
+	}
+	if r.Requests > 0 {
+		r.MissRatio = float64(r.Missed) / float64(r.Requests)
+	}
+	enough := float64(r.Requests) >= math.Ceil(1/r.Budget-1e-9)
+	violated := enough && r.MissRatio > r.Budget
+	if violated != s.violated {
+		s.violated, s.since = violated, now
+		if violated {
+			log.Printf("[SLO] %s violated: %d of %d request(s) in the last %v missed it (%.2f%%, budget %.2f%%)", s.text, r.Missed, r.Requests, t.window, r.MissRatio*100, r.Budget*100)
+		} else {
+			log.Printf("[SLO] %s no longer violated: %d of %d request(s) in the last %v missed it", s.text, r.Missed, r.Requests, t.window)
+		}
+	}
+	r.Violated, r.Since = s.violated, s.since
+	return r
+}
+
+// report returns the state of every objective, in configured order.
+func (t *sloTracker) report() []sloReport {
+	if t == nil {
+		return []sloReport{}
+	}
+	now := t.now()
+	reports := make([]sloReport, 0, len(t.objectives))
+	for _, s := range t.objectives {
+		s.mu.Lock()
+		reports = append(reports, s.evaluate(t, now))
+		s.mu.Unlock()
+	}
+	return reports
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestParseSLO(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    sloObjective
+		wantErr string
+	}{
+		{in: "get_hit:p99<20ms", want: sloObjective{op: "get_hit", percentile: 99, latency: 20 * time.Millisecond, text: "get_hit:p99<20ms"}},
+		{in: " get_miss : p99.9 < 5s ", want: sloObjective{op: "get_miss", percentile: 99.9, latency: 5 * time.Second, text: "get_miss : p99.9 < 5s"}},
+		{in: "put:errors<0.5%", want: sloObjective{op: "put", errorRate: 0.005, text: "put:errors<0.5%"}},
+		{in: "copy:p99<1s", wantErr: `unknown operation "copy"`},
+		{in: "get:p100<1s", wantErr: "unknown measure"},
+		{in: "get:p99<fast", wantErr: "latency must be a positive duration"},
+		{in: "get:errors<1", wantErr: "error rate must be a percentage"},
+		{in: "get p99 20ms", wantErr: "want <operation>:"},
+	} {
+		got, err := parseSLO(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseSLO(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSLO(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSLOTracker(t *testing.T) {
+	latency, _ := parseSLO("get_miss:p99<100ms")
+	errors, _ := parseSLO("get:errors<10%")
+	tracker := newSLOTracker([]sloObjective{latency, errors}, time.Minute)
+	now := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	// A slow request alone isn't enough to judge p99
+	tracker.observe([]string{"get", "get_miss"}, http.StatusOK, time.Second)
+	if tracker.report()[0].Violated {
+		t.Error("p99 violated by a single request")
+	}
+	for range 98 {
+		tracker.observe([]string{"get", "get_miss"}, http.StatusOK, 10*time.Millisecond)
+	}
+	tracker.observe([]string{"get", "get_miss"}, http.StatusOK, time.Second)
+	// Hits don't count towards get_miss
+	tracker.observe([]string{"get", "get_hit"}, http.StatusInternalServerError, time.Second)
+
+	reports := tracker.report()
+	if r := reports[0]; !r.Violated || r.Requests != 100 || r.Missed != 2 || !r.Since.Equal(now) {
+		t.Errorf("latency objective = %+v, want violated by 2 of 100", r)
+	}
+	if r := reports[1]; r.Violated || r.Requests != 101 || r.Missed != 1 {
+		t.Errorf("error objective = %+v, want 1 of 101 within budget", r)
+	}
+
+	// Requests older than the window no longer count
+	now = now.Add(time.Minute + time.Second)
+	if r := tracker.report()[0]; r.Violated || r.Requests != 0 {
+		t.Errorf("latency objective after the window = %+v, want no longer violated", r)
+	}
+}
+
+func TestSLOOperationsOf(t *testing.T) {
+	for _, tt := range []struct {
+		method, target, xCache string
+		want                   []string
+	}{
+		{http.MethodGet, "/bucket/key", cacheHit, []string{"get", "get_hit"}},
+		{http.MethodGet, "/bucket/key", cacheStale, []string{"get", "get_hit"}},
+		{http.MethodGet, "/bucket/key", cacheMiss, []string{"get", "get_miss"}},
+		{http.MethodGet, "/bucket/key", "", []string{"get"}},
+		{http.MethodGet, "/bucket/key?tagging", "", nil},
+		{http.MethodGet, "/bucket?list-type=2", "", []string{"list"}},
+		{http.MethodGet, "/", "", nil},
+		{http.MethodHead, "/bucket/key", "", []string{"head"}},
+		{http.MethodPut, "/bucket/key", "", []string{"put"}},
+		{http.MethodDelete, "/bucket/key", "", []string{"delete"}},
+	} {
+		h := make(http.Header)
+		if tt.xCache != "" {
+			h.Set(cacheStatusHeader, tt.xCache)
+		}
+		if got := sloOperationsOf(httptest.NewRequest(tt.method, tt.target, nil), h); !slices.Equal(got, tt.want) {
+			t.Errorf("%s %s (X-Cache %q) = %v, want %v", tt.method, tt.target, tt.xCache, got, tt.want)
+		}
+	}
+}
+
+func TestLazyBackend_SLOs(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "data.txt", "content")
+	if err := lazyBackend.SetSLOs([]string{"get_miss:p50<1h", "get_hit:p50<1h"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	handler := lazyBackend.identityLogger(gofakes3.New(lazyBackend).Server())
+	for range 2 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test-bucket/data.txt", nil))
+	}
+	reports := lazyBackend.slos.report()
+	if reports[0].Requests != 1 || reports[1].Requests != 1 {
+		t.Errorf("reports = %+v, want one miss and one hit", reports)
+	}
+
+	if err := lazyBackend.SetSLOs([]string{"get:p99<1s"}, 0); err == nil {
+		t.Error("SetSLOs accepted a zero window")
+	}
+}
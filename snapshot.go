@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// snapshotSeparator joins a bucket's name and a snapshot's label into the
+// snapshot's bucket name, e.g. "dataset@2024-06-01". S3 bucket names can't
+// contain it, so no real bucket is mistaken for a snapshot.
+const snapshotSeparator = "@"
+
+// snapshotLabel is what a snapshot label may look like.
+var snapshotLabel = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// isSnapshotBucket reports whether a local bucket is a snapshot.
+func isSnapshotBucket(name string) bool {
+	return strings.Contains(name, snapshotSeparator)
+}
+
+// snapshotInfo describes a snapshot.
+type snapshotInfo struct {
+	Bucket    string    `json:"bucket"`
+	Source    string    `json:"source"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+}
+
+// snapshotResult reports a snapshot just taken.
+type snapshotResult struct {
+	snapshotInfo
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+
+	// Linked counts the objects that share their data with the source
+	// bucket instead of being copied
+	Linked int `json:"linked"`
+}
+
+// objectLinker is a local backend that can store an object under a second
+// bucket sharing its data, instead of a copy.
+type objectLinker interface {
+	// linkObject reports false if it can't link the object, which must
+	// then be copied.
+	linkObject(srcBucket, dstBucket, key string) (bool, error)
+}
+
+// Snapshot creates the read-only local bucket "<bucket>@<label>" holding
+// the objects bucket has now, cached or written by clients, so experiments
+// can run against a frozen view while the bucket keeps changing. An empty
+// label is today's date. Snapshots never fetch from upstream, expire or
+// get evicted; they last until deleted with DeleteSnapshot.
+//
+// On the disk backend, objects are hard-linked rather than copied, so a
+// snapshot takes no space until the bucket's copies are replaced or
+// evicted.
+func (b *LazyBackend) Snapshot(bucketName, label string) (snapshotResult, error) {
+	src := b.canonicalBucket(bucketName)
+	if isSnapshotBucket(src) {
+		return snapshotResult{}, gofakes3.ErrorMessagef(gofakes3.ErrInvalidArgument, "%s is a snapshot already", src)
+	}
+	if exists, err := b.local.BucketExists(src); err != nil {
+		return snapshotResult{}, err
+	} else if !exists {
+		return snapshotResult{}, gofakes3.BucketNotFound(src)
+	}
+	if label == "" {
+		label = time.Now().UTC().Format(time.DateOnly)
+	}
+	if !snapshotLabel.MatchString(label) {
+		return snapshotResult{}, gofakes3.ErrorMessagef(gofakes3.ErrInvalidArgument, "snapshot label %q must be lowercase letters, digits, '.', '_' and '-'", label)
+	}
+	dst := src + snapshotSeparator + label
+	if exists, err := b.local.BucketExists(dst); err != nil {
+		return snapshotResult{}, err
+	} else if exists {
+		return snapshotResult{}, gofakes3.ResourceError(gofakes3.ErrBucketAlreadyExists, dst)
+	}
+	if err := b.local.CreateBucket(dst); err != nil {
+		return snapshotResult{}, err
+	}
+
+	result := snapshotResult{snapshotInfo: snapshotInfo{Bucket: dst, Source: src, Label: label, CreatedAt: time.Now().UTC()}}
+	linker, _ := b.local.(objectLinker)
+	for key, err := range localKeys(b.local, src, "") {
+		if err != nil {
+			return result, err
+		}
+		size, linked, err := b.snapshotObject(linker, src, dst, key)
+		if gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			// Evicted or deleted since it was listed
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("snapshotting %s/%s: %w", src, key, err)
+		}
+		result.Objects++
+		result.Bytes += size
+		if linked {
+			result.Linked++
+		}
+	}
+	log.Printf("[SNAPSHOT] %s as %s: %d object(s), %d bytes, %d hard-linked", src, dst, result.Objects, result.Bytes, result.Linked)
+	return result, nil
+}
+
+// snapshotObject links or copies an object into a snapshot, returning its
+// size and whether it was linked.
+func (b *LazyBackend) snapshotObject(linker objectLinker, src, dst, key string) (int64, bool, error) {
+	unlock := b.locks.RLock(src, key)
+	defer unlock()
+	if linker != nil {
+		linked, err := linker.linkObject(src, dst, key)
+		if err != nil {
+			return 0, false, err
+		}
+		if linked {
+			head, err := b.local.HeadObject(dst, key)
+			if err != nil {
+				return 0, false, err
+			}
+			return head.Size, true, nil
+		}
+	}
+	obj, err := b.local.GetObject(src, key, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer obj.Contents.Close()
+	if _, err := b.local.PutObject(dst, key, obj.Metadata, obj.Contents, obj.Size, nil); err != nil {
+		return 0, false, err
+	}
+	return obj.Size, false, nil
+}
+
+// Snapshots lists the snapshots of every bucket.
+func (b *LazyBackend) Snapshots() ([]snapshotInfo, error) {
+	buckets, err := b.local.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []snapshotInfo{}
+	for _, bucket := range buckets {
+		source, label, ok := strings.Cut(bucket.Name, snapshotSeparator)
+		if !ok {
+			continue
+		}
+		snapshots = append(snapshots, snapshotInfo{Bucket: bucket.Name, Source: source, Label: label, CreatedAt: bucket.CreationDate.Time})
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot deletes a snapshot and its objects. The bucket it was
+// taken of is left alone.
+func (b *LazyBackend) DeleteSnapshot(name string) error {
+	if !isSnapshotBucket(name) {
+		return gofakes3.ErrorMessagef(gofakes3.ErrInvalidArgument, "%s is not a snapshot", name)
+	}
+	if err := b.local.ForceDeleteBucket(name); err != nil {
+		return err
+	}
+	log.Printf("[SNAPSHOT] deleted %s", name)
+	return nil
+}
+
+// errSnapshotWrite is returned for client writes to a snapshot.
+func errSnapshotWrite(bucketName string) error {
+	return newFailure(ErrReadOnly, "AccessDenied", nil, "bucket %s is a read-only snapshot", bucketName)
+}
+
+// linkObject hard-links an object's file into dstBucket and gives the link
+// a copy of the object's metadata. Packed objects, and objects on a file
+// system that can't link, are left to be copied.
+func (d *diskListing) linkObject(srcBucket, dstBucket, key string) (bool, error) {
+	if d.packs != nil {
+		if _, packed := d.packs.lookup(srcBucket, key); packed {
+			return false, nil
+		}
+	}
+	metaName := diskMetaName(key)
+	data, err := os.ReadFile(filepath.Join(d.meta, srcBucket, metaName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return false, fmt.Errorf("metadata of %s/%s: %w", srcBucket, key, err)
+	}
+	if meta["File"], err = json.Marshal(path.Join(dstBucket, key)); err != nil {
+		return false, err
+	}
+	if data, err = json.Marshal(meta); err != nil {
+		return false, err
+	}
+
+	dst := filepath.Join(d.root, dstBucket, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return false, err
+	}
+	if err := os.Link(filepath.Join(d.root, srcBucket, filepath.FromSlash(key)), dst); err != nil {
+		// Copying fails properly if the object is gone
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Join(d.meta, dstBucket), 0755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(filepath.Join(d.meta, dstBucket, metaName), data, 0644)
+}
+
+// ListBuckets adds snapshots to the disk backend's buckets, which s3afero
+// leaves out as their names aren't valid S3 bucket names.
+func (d *diskListing) ListBuckets() ([]gofakes3.BucketInfo, error) {
+	buckets, err := d.Backend.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(d.root)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		source, label, ok := strings.Cut(entry.Name(), snapshotSeparator)
+		if !ok || !entry.IsDir() || gofakes3.ValidateBucketName(source) != nil || !snapshotLabel.MatchString(label) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		buckets = append(buckets, gofakes3.BucketInfo{Name: entry.Name(), CreationDate: gofakes3.NewContentTime(info.ModTime())})
+	}
+	slices.SortFunc(buckets, func(a, b gofakes3.BucketInfo) int { return strings.Compare(a.Name, b.Name) })
+	return buckets, nil
+}
+
+// diskMetaName returns the name of the file s3afero keeps a key's metadata
+// in, under the metadata directory of its bucket.
+func diskMetaName(key string) string {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(key) + "-" + hex.EncodeToString(h.Sum(nil))
+}
+
+// runSnapshot implements `s3lazy snapshot`: it asks a running instance to
+// snapshot a bucket and prints the snapshot's name.
+func runSnapshot(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: s3lazy snapshot [flags] <bucket> [label]")
+		fs.PrintDefaults()
+	}
+	endpoint := fs.String("endpoint", defaultAdminEndpoint, "s3lazy admin endpoint")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errors.New("want a bucket and an optional label")
+	}
+
+	u := fmt.Sprintf("%s/admin/buckets/%s/snapshot", strings.TrimSuffix(*endpoint, "/"), url.PathEscape(fs.Arg(0)))
+	if label := fs.Arg(1); label != "" {
+		u += "?label=" + url.QueryEscape(label)
+	}
+	resp, err := adminRequest(context.Background(), http.MethodPost, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) != nil || failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return errors.New(failure.Error)
+	}
+	var result snapshotResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Snapshot %s of %s: %d object(s), %d bytes, %d hard-linked\n",
+		result.Bucket, result.Source, result.Objects, result.Bytes, result.Linked)
+	return nil
+}
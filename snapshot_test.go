@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestSnapshot(t *testing.T) {
+	_, _, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	if err := awsBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	dataDir := t.TempDir()
+	disk, _ := newTestPackedDisk(t, dataDir, 8)
+	lazyBackend := NewLazyBackend(disk, newEndpointClient(t, awsServer.URL))
+
+	putString(t, awsBackend, "data.csv", "a,b,c\n1,2,3\n")
+	putString(t, awsBackend, "tiny", "packed")
+	putString(t, awsBackend, "uncached.txt", "never read")
+	readObject(t, lazyBackend, "data.csv", nil)
+	readObject(t, lazyBackend, "tiny", nil)
+	putString(t, lazyBackend, "notes.txt", "written locally")
+
+	admin := httptest.NewServer(newAdminHandler(lazyBackend, DefaultConfig()))
+	defer admin.Close()
+	var out bytes.Buffer
+	if err := runSnapshot([]string{"-endpoint", admin.URL, "test-bucket", "2024-06-01"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Snapshot test-bucket@2024-06-01 of test-bucket: 3 object(s), 33 bytes, 2 hard-linked"; !strings.Contains(out.String(), want) {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	live, _ := os.Stat(filepath.Join(dataDir, "buckets", "test-bucket", "data.csv"))
+	frozen, _ := os.Stat(filepath.Join(dataDir, "buckets", "test-bucket@2024-06-01", "data.csv"))
+	if live == nil || frozen == nil || !os.SameFile(live, frozen) {
+		t.Error("data.csv copied instead of hard-linked")
+	}
+
+	// The bucket changes, the snapshot doesn't
+	putString(t, lazyBackend, "data.csv", "rewritten")
+	if _, err := lazyBackend.DeleteObject("test-bucket", "notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	s3Server := httptest.NewServer(gofakes3.New(lazyBackend).Server())
+	defer s3Server.Close()
+	client := newEndpointClient(t, s3Server.URL)
+	for key, want := range map[string]string{"data.csv": "a,b,c\n1,2,3\n", "tiny": "packed", "notes.txt": "written locally"} {
+		obj, err := client.GetObject(t.Context(), &s3.GetObjectInput{Bucket: aws.String("test-bucket@2024-06-01"), Key: aws.String(key)})
+		if err != nil {
+			t.Fatalf("GET %s from the snapshot: %v", key, err)
+		}
+		data, _ := io.ReadAll(obj.Body)
+		obj.Body.Close()
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", key, data, want)
+		}
+	}
+
+	// Misses aren't fetched, and writes are refused
+	if _, err := lazyBackend.GetObject("test-bucket@2024-06-01", "uncached.txt", nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Errorf("GET of an object the bucket hadn't cached = %v, want NoSuchKey", err)
+	}
+	_, err := client.PutObject(t.Context(), &s3.PutObjectInput{Bucket: aws.String("test-bucket@2024-06-01"), Key: aws.String("new.txt"), Body: strings.NewReader("x")})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDenied" {
+		t.Errorf("PUT to the snapshot = %v, want AccessDenied", err)
+	}
+
+	if err := runSnapshot([]string{"-endpoint", admin.URL, "test-bucket", "2024-06-01"}, &out); err == nil || !strings.Contains(err.Error(), "BucketAlreadyExists") {
+		t.Errorf("second snapshot with the same label = %v, want BucketAlreadyExists", err)
+	}
+	snapshots, err := lazyBackend.Snapshots()
+	if err != nil || len(snapshots) != 1 || snapshots[0].Source != "test-bucket" || snapshots[0].Label != "2024-06-01" {
+		t.Errorf("Snapshots() = %+v, %v", snapshots, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, admin.URL+"/admin/snapshots/test-bucket@2024-06-01", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want 204", resp.StatusCode)
+	}
+	if exists, _ := lazyBackend.BucketExists("test-bucket@2024-06-01"); exists {
+		t.Error("snapshot still exists after deletion")
+	}
+	if _, data := readObject(t, lazyBackend, "data.csv", nil); data != "rewritten" {
+		t.Errorf("live data.csv = %q after deleting the snapshot", data)
+	}
+}
+
+func TestSnapshot_Invalid(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	// Without a disk backend objects are copied
+	putString(t, localBackend, "a.txt", "alpha")
+	result, err := lazyBackend.Snapshot("test-bucket", "")
+	if err != nil || result.Objects != 1 || result.Linked != 0 {
+		t.Errorf("Snapshot = %+v, %v; want a.txt copied", result, err)
+	}
+
+	for _, tt := range []struct {
+		bucket, label string
+		want          gofakes3.ErrorCode
+	}{
+		{"missing", "v1", gofakes3.ErrNoSuchBucket},
+		{"test-bucket", "Not/A/Label", gofakes3.ErrInvalidArgument},
+		{result.Bucket, "v1", gofakes3.ErrInvalidArgument},
+	} {
+		if _, err := lazyBackend.Snapshot(tt.bucket, tt.label); !gofakes3.HasErrorCode(err, tt.want) {
+			t.Errorf("Snapshot(%q, %q) = %v, want %s", tt.bucket, tt.label, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+)
+
+// sqlitePageSize is the page size of the SQLite databases s3lazy writes.
+const sqlitePageSize = 4096
+
+// sqliteWriter writes a SQLite database of rowid tables, for exports meant to
+// be queried with standard tools. It writes only what a fresh database of
+// such tables needs: table b-trees and overflow pages, but no indexes, free
+// pages or journal. Pages are written as tables fill them, and page 1, which
+// holds the schema, last.
+type sqliteWriter struct {
+	w      io.WriterAt
+	pages  uint32 // allocated so far, page 1 included
+	schema [][]any
+}
+
+func newSQLiteWriter(w io.WriterAt) *sqliteWriter {
+	return &sqliteWriter{w: w, pages: 1}
+}
+
+// sqliteChild is a page of a table b-tree and the largest rowid under it.
+type sqliteChild struct {
+	page  uint32
+	rowid int64
+}
+
+// table writes a table holding rows, numbered from 1, and adds it to the
+// schema. create is its CREATE TABLE statement; values are nil, int64,
+// float64, string or []byte.
+func (s *sqliteWriter) table(name, create string, rows iter.Seq[[]any]) error {
+	var children []sqliteChild
+	var cells [][]byte
+	used := 0
+	flush := func(rowid int64) error {
+		page, err := s.write(sqliteLeafPage(cells, 0))
+		children = append(children, sqliteChild{page: page, rowid: rowid})
+		cells, used = cells[:0], 0
+		return err
+	}
+	var rowid int64
+	for row := range rows {
+		record, err := sqliteRecord(row)
+		if err != nil {
+			return fmt.Errorf("%s row %d: %w", name, rowid+1, err)
+		}
+		cell, err := s.leafCell(rowid+1, record)
+		if err != nil {
+			return err
+		}
+		if used+len(cell)+2 > sqlitePageSize-8 {
+			if err := flush(rowid); err != nil {
+				return err
+			}
+		}
+		rowid++
+		cells = append(cells, cell)
+		used += len(cell) + 2
+	}
+	// An empty table still has a root page
+	if len(cells) > 0 || len(children) == 0 {
+		if err := flush(rowid); err != nil {
+			return err
+		}
+	}
+
+	// Interior pages point at the pages of the level below until a single
+	// page, the root, is left
+	for len(children) > 1 {
+		var parents []sqliteChild
+		for len(children) > 0 {
+			n, used := 1, 12 // the last child of a page is its right-most pointer
+			for n < len(children) {
+				length := 4 + len(sqliteVarint(uint64(children[n-1].rowid))) + 2
+				if used+length > sqlitePageSize {
+					break
+				}
+				used += length
+				n++
+			}
+			page, err := s.write(sqliteInteriorPage(children[:n]))
+			if err != nil {
+				return err
+			}
+			parents = append(parents, sqliteChild{page: page, rowid: children[n-1].rowid})
+			children = children[n:]
+		}
+		children = parents
+	}
+	s.schema = append(s.schema, []any{"table", name, name, int64(children[0].page), create})
+	return nil
+}
+
+// close writes page 1: the database header and the schema.
+func (s *sqliteWriter) close() error {
+	var cells [][]byte
+	used := 100 + 8
+	for i, row := range s.schema {
+		record, err := sqliteRecord(row)
+		if err != nil {
+			return err
+		}
+		cell, err := s.leafCell(int64(i+1), record)
+		if err != nil {
+			return err
+		}
+		if used += len(cell) + 2; used > sqlitePageSize {
+			return fmt.Errorf("sqlite schema of %d tables doesn't fit on one page", len(s.schema))
+		}
+		cells = append(cells, cell)
+	}
+	page := sqliteLeafPage(cells, 100)
+	copy(page, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(page[16:], sqlitePageSize)
+	page[18], page[19] = 1, 1                      // legacy journal mode
+	page[21], page[22], page[23] = 64, 32, 32      // payload fractions
+	binary.BigEndian.PutUint32(page[24:], 1)       // change counter
+	binary.BigEndian.PutUint32(page[28:], s.pages) // database size
+	binary.BigEndian.PutUint32(page[40:], 1)       // schema cookie
+	binary.BigEndian.PutUint32(page[44:], 4)       // schema format
+	binary.BigEndian.PutUint32(page[56:], 1)       // UTF-8
+	binary.BigEndian.PutUint32(page[92:], 1)       // version-valid-for
+	binary.BigEndian.PutUint32(page[96:], 3046000) // SQLite version
+	_, err := s.w.WriteAt(page, 0)
+	return err
+}
+
+// write writes a page at the end of the database and returns its number.
+func (s *sqliteWriter) write(page []byte) (uint32, error) {
+	s.pages++
+	_, err := s.w.WriteAt(page, int64(s.pages-1)*sqlitePageSize)
+	return s.pages, err
+}
+
+// leafCell returns the cell of a row in a table leaf page. The part of a
+// record that doesn't fit in the page is written to overflow pages.
+func (s *sqliteWriter) leafCell(rowid int64, record []byte) ([]byte, error) {
+	cell := append(sqliteVarint(uint64(len(record))), sqliteVarint(uint64(rowid))...)
+	const usable = sqlitePageSize
+	const maxLocal = usable - 35
+	if len(record) <= maxLocal {
+		return append(cell, record...), nil
+	}
+	// The split the SQLite file format prescribes
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (len(record)-minLocal)%(usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	cell = append(cell, record[:local]...)
+
+	// Overflow pages are chained in order, so each knows its successor
+	rest := record[local:]
+	next := s.pages + 1
+	cell = binary.BigEndian.AppendUint32(cell, next)
+	for len(rest) > 0 {
+		page := make([]byte, sqlitePageSize)
+		n := copy(page[4:], rest)
+		if rest = rest[n:]; len(rest) > 0 {
+			binary.BigEndian.PutUint32(page, next+1)
+		}
+		if _, err := s.write(page); err != nil {
+			return nil, err
+		}
+		next++
+	}
+	return cell, nil
+}
+
+// sqliteLeafPage lays out a table leaf page holding cells, whose b-tree
+// header starts at offset.
+func sqliteLeafPage(cells [][]byte, offset int) []byte {
+	page := make([]byte, sqlitePageSize)
+	page[offset] = 0x0d
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(cells)))
+	end := sqlitePageSize
+	for i, cell := range cells {
+		end -= len(cell)
+		copy(page[end:], cell)
+		binary.BigEndian.PutUint16(page[offset+8+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(end))
+	return page
+}
+
+// sqliteInteriorPage lays out a table interior page pointing at children.
+func sqliteInteriorPage(children []sqliteChild) []byte {
+	page := make([]byte, sqlitePageSize)
+	page[0] = 0x05
+	cells := children[:len(children)-1]
+	binary.BigEndian.PutUint16(page[3:], uint16(len(cells)))
+	binary.BigEndian.PutUint32(page[8:], children[len(children)-1].page)
+	end := sqlitePageSize
+	for i, child := range cells {
+		cell := binary.BigEndian.AppendUint32(nil, child.page)
+		cell = append(cell, sqliteVarint(uint64(child.rowid))...)
+		end -= len(cell)
+		copy(page[end:], cell)
+		binary.BigEndian.PutUint16(page[12+2*i:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(page[5:], uint16(end))
+	return page
+}
+
+// sqliteRecord encodes a row in the SQLite record format.
+func sqliteRecord(values []any) ([]byte, error) {
+	var types, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = append(types, 0)
+		case int64:
+			serial, size := sqliteIntType(v)
+			types = append(types, byte(serial))
+			for i := size - 1; i >= 0; i-- {
+				body = append(body, byte(v>>(8*i)))
+			}
+		case float64:
+			types = append(types, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			types = append(types, sqliteVarint(uint64(2*len(v)+13))...)
+			body = append(body, v...)
+		case []byte:
+			types = append(types, sqliteVarint(uint64(2*len(v)+12))...)
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported sqlite value %T", v)
+		}
+	}
+	// The header size counts its own varint
+	size := len(types) + 1
+	if len(sqliteVarint(uint64(size))) > 1 {
+		size++
+	}
+	record := append(sqliteVarint(uint64(size)), types...)
+	return append(record, body...), nil
+}
+
+// sqliteIntType returns the serial type of an integer and its size in bytes.
+func sqliteIntType(v int64) (serial, size int) {
+	switch {
+	case v == 0:
+		return 8, 0
+	case v == 1:
+		return 9, 0
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return 1, 1
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return 2, 2
+	case v >= -1<<23 && v < 1<<23:
+		return 3, 3
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return 4, 4
+	case v >= -1<<47 && v < 1<<47:
+		return 5, 6
+	}
+	return 6, 8
+}
+
+// sqliteVarint encodes v as a SQLite varint: big-endian groups of 7 bits,
+// with the ninth byte, if any, holding 8.
+func sqliteVarint(v uint64) []byte {
+	if v > 1<<56-1 {
+		b := make([]byte, 9)
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return b
+	}
+	var b []byte
+	for {
+		b = append([]byte{byte(v & 0x7f)}, b...)
+		if v >>= 7; v == 0 {
+			break
+		}
+	}
+	for i := range len(b) - 1 {
+		b[i] |= 0x80
+	}
+	return b
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sqliteRows reads the rows of a table of a database sqliteWriter wrote, by
+// walking its b-tree.
+func sqliteRows(t *testing.T, db []byte, table string) [][]any {
+	t.Helper()
+	if !strings.HasPrefix(string(db), "SQLite format 3\x00") || len(db)%sqlitePageSize != 0 {
+		t.Fatalf("not a SQLite database of %d-byte pages", sqlitePageSize)
+	}
+	if pages := binary.BigEndian.Uint32(db[28:]); int(pages)*sqlitePageSize != len(db) {
+		t.Fatalf("header says %d pages, file has %d", pages, len(db)/sqlitePageSize)
+	}
+	var walk func(n uint32) [][]any
+	walk = func(n uint32) [][]any {
+		page := db[(n-1)*sqlitePageSize : n*sqlitePageSize]
+		offset := 0
+		if n == 1 {
+			offset = 100
+		}
+		cells := int(binary.BigEndian.Uint16(page[offset+3:]))
+		var rows [][]any
+		switch page[offset] {
+		case 0x05:
+			for i := range cells {
+				cell := binary.BigEndian.Uint16(page[offset+12+2*i:])
+				rows = append(rows, walk(binary.BigEndian.Uint32(page[cell:]))...)
+			}
+			return append(rows, walk(binary.BigEndian.Uint32(page[offset+8:]))...)
+		case 0x0d:
+			for i := range cells {
+				cell := page[binary.BigEndian.Uint16(page[offset+8+2*i:]):]
+				size, n1 := readSQLiteVarint(cell)
+				_, n2 := readSQLiteVarint(cell[n1:])
+				cell = cell[n1+n2:]
+				if size <= sqlitePageSize-35 {
+					rows = append(rows, decodeSQLiteRecord(t, cell[:size]))
+					continue
+				}
+				local := (sqlitePageSize-12)*32/255 - 23
+				if k := local + (int(size)-local)%(sqlitePageSize-4); k <= sqlitePageSize-35 {
+					local = k
+				}
+				record := append([]byte(nil), cell[:local]...)
+				for next := binary.BigEndian.Uint32(cell[local:]); next != 0; {
+					overflow := db[(next-1)*sqlitePageSize : next*sqlitePageSize]
+					record = append(record, overflow[4:min(len(overflow), 4+int(size)-len(record))]...)
+					next = binary.BigEndian.Uint32(overflow)
+				}
+				rows = append(rows, decodeSQLiteRecord(t, record))
+			}
+			return rows
+		}
+		t.Fatalf("page %d has unexpected type %#x", n, page[offset])
+		return nil
+	}
+	for _, row := range walk(1) {
+		if row[1] == table {
+			return walk(uint32(row[3].(int64)))
+		}
+	}
+	t.Fatalf("no table %s", table)
+	return nil
+}
+
+func readSQLiteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := range 8 {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+func decodeSQLiteRecord(t *testing.T, record []byte) []any {
+	t.Helper()
+	size, n := readSQLiteVarint(record)
+	header, body := record[n:size], record[size:]
+	var values []any
+	for len(header) > 0 {
+		serial, n := readSQLiteVarint(header)
+		header = header[n:]
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial == 8 || serial == 9:
+			values = append(values, int64(serial-8))
+		case serial == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(body)))
+			body = body[8:]
+		case serial <= 6:
+			size := []int{0, 1, 2, 3, 4, 6, 8}[serial]
+			v := int64(int8(body[0]))
+			for _, b := range body[1:size] {
+				v = v<<8 | int64(b)
+			}
+			values, body = append(values, v), body[size:]
+		case serial%2 == 1:
+			size := (serial - 13) / 2
+			values, body = append(values, string(body[:size])), body[size:]
+		default:
+			size := (serial - 12) / 2
+			values, body = append(values, append([]byte(nil), body[:size]...)), body[size:]
+		}
+	}
+	if len(body) != 0 {
+		t.Fatalf("record has %d trailing bytes", len(body))
+	}
+	return values
+}
+
+func TestSQLiteWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Enough rows for interior pages, and rows too large for a page
+	var want [][]any
+	for i := range int64(5000) {
+		text := strings.Repeat("k", int(i%40))
+		if i%500 == 3 {
+			text = strings.Repeat("x", int(4000+i*3))
+		}
+		want = append(want, []any{i, -i << 40, text, nil, float64(i) / 4, []byte{byte(i)}})
+	}
+	db := newSQLiteWriter(f)
+	if err := db.table("rows", "CREATE TABLE rows (a, b, c, d, e, f)", func(yield func([]any) bool) {
+		for _, row := range want {
+			if !yield(row) {
+				return
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.table("empty", "CREATE TABLE empty (a)", func(yield func([]any) bool) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sqliteRows(t, data, "rows"); !reflect.DeepEqual(got, want) {
+		t.Errorf("read back %d rows, want the %d written", len(got), len(want))
+	}
+	if got := sqliteRows(t, data, "empty"); len(got) != 0 {
+		t.Errorf("empty table has %d rows", len(got))
+	}
+}
+
+func TestSQLiteRecord_Integers(t *testing.T) {
+	for _, v := range []int64{0, 1, -1, 127, -128, 128, 32767, -32768, 1<<23 - 1, -1 << 23, 1 << 31, 1<<47 - 1, -1 << 47, math.MaxInt64, math.MinInt64} {
+		record, err := sqliteRecord([]any{v})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decodeSQLiteRecord(t, record); got[0] != v {
+			t.Errorf("%d decoded as %v", v, got[0])
+		}
+	}
+	if _, err := sqliteRecord([]any{int32(1)}); err == nil {
+		t.Error("unsupported value accepted")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// cacheStats counts requests and bytes served from the cache and upstream
+// since startup.
+type cacheStats struct {
+	hits          atomic.Int64
+	misses        atomic.Int64
+	cacheBytes    atomic.Int64
+	upstreamBytes atomic.Int64
+}
+
+// recordHit counts a request served from the local cache.
+func (s *cacheStats) recordHit(bytes int64) {
+	s.hits.Add(1)
+	s.cacheBytes.Add(bytes)
+}
+
+// recordMiss counts an object fetched from upstream.
+func (s *cacheStats) recordMiss(bytes int64) {
+	s.misses.Add(1)
+	s.upstreamBytes.Add(bytes)
+}
+
+// servedBytes returns how many bytes of an object a GET returns.
+func servedBytes(obj *gofakes3.Object) int64 {
+	if obj.Range != nil {
+		return obj.Range.Length
+	}
+	return obj.Size
+}
+
+// statsReport is the /admin/stats response.
+type statsReport struct {
+	Hits              int64   `json:"hits"`
+	Misses            int64   `json:"misses"`
+	HitRatio          float64 `json:"hit_ratio"`
+	BytesFromCache    int64   `json:"bytes_from_cache"`
+	BytesFromUpstream int64   `json:"bytes_from_upstream"`
+	Objects           int     `json:"objects"`
+	CacheBytes        int64   `json:"cache_bytes"`
+
+	// LocalObjects and LocalBytes count the objects clients wrote, which
+	// hold no cached data
+	LocalObjects int   `json:"local_objects"`
+	LocalBytes   int64 `json:"local_bytes"`
+
+	// Redaction is only reported while a redactor is configured
+	Redaction *redactionStats `json:"redaction,omitempty"`
+
+	// Chunks is only reported while chunked range caching is enabled
+	Chunks *chunkStats `json:"chunks,omitempty"`
+
+	// UpstreamCredentials is only reported for upstream clients s3lazy
+	// signs requests for
+	UpstreamCredentials *credentialStatus `json:"upstream_credentials,omitempty"`
+
+	// UpstreamCircuit is only reported while the circuit breaker is enabled
+	UpstreamCircuit *circuitStatus `json:"upstream_circuit,omitempty"`
+
+	// UploadVerification is only reported while uploads are verified
+	UploadVerification *uploadVerificationStats `json:"upload_verification,omitempty"`
+}
+
+// chunkStats describes the chunks cached for range reads.
+type chunkStats struct {
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// Stats returns the cache counters along with the number and total size of
+// objects currently cached from upstream.
+func (b *LazyBackend) Stats() statsReport {
+	r := statsReport{
+		Hits:              b.stats.hits.Load(),
+		Misses:            b.stats.misses.Load(),
+		BytesFromCache:    b.stats.cacheBytes.Load(),
+		BytesFromUpstream: b.stats.upstreamBytes.Load(),
+	}
+	if total := r.Hits + r.Misses; total > 0 {
+		r.HitRatio = float64(r.Hits) / float64(total)
+	}
+	r.Objects, r.CacheBytes = b.index.usage()
+	r.LocalObjects, r.LocalBytes = b.localData.total()
+	if b.redactor != nil {
+		r.Redaction = b.redaction.snapshot()
+	}
+	if b.chunks != nil {
+		r.Chunks = &chunkStats{}
+		r.Chunks.Objects, r.Chunks.Bytes = b.chunks.usage()
+	}
+	if creds := upstreamCredentialsOf(b.awsClient); creds != nil {
+		r.UpstreamCredentials = creds.status()
+	}
+	if circuit, ok := b.awsClient.(*circuitBreaker); ok {
+		r.UpstreamCircuit = circuit.status()
+	}
+	if b.verifier != nil {
+		r.UploadVerification = b.verifier.status()
+	}
+	return r
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestAdminStats(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	content := "0123456789"
+	if _, err := awsBackend.PutObject("test-bucket", "data.txt", nil, strings.NewReader(content), int64(len(content)), nil); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	read := func(rng *gofakes3.ObjectRangeRequest) {
+		t.Helper()
+		obj, err := lazyBackend.GetObject("test-bucket", "data.txt", rng)
+		if err != nil {
+			t.Fatalf("GetObject: %v", err)
+		}
+		_, _ = io.Copy(io.Discard, obj.Contents)
+		obj.Contents.Close()
+	}
+	read(nil)                                            // miss
+	read(nil)                                            // hit
+	read(&gofakes3.ObjectRangeRequest{Start: 0, End: 3}) // hit on 4 bytes
+
+	rec := httptest.NewRecorder()
+	newAdminHandler(lazyBackend, DefaultConfig()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got statsReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want := statsReport{Hits: 2, Misses: 1, HitRatio: 2.0 / 3, BytesFromCache: 14, BytesFromUpstream: 10, Objects: 1, CacheBytes: 10}
+	if got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+// streamRequest asks fetchLocked to stream objects it won't cache to the
+// client, serving rangeRequest if set.
+type streamRequest struct {
+	rangeRequest *gofakes3.ObjectRangeRequest
+}
+
+// streamThrough turns an upstream response into an object streamed to the
+// client without touching the local backend. A range is fetched with its own
+// ranged GET so only the requested bytes cross the network. release is called
+// once the client closes the contents; the upstream requests are made under
+// ctx until then.
+func (b *LazyBackend) streamThrough(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest, upstream upstreamClient, awsBucket string, awsObj *s3.GetObjectOutput, release func()) (*gofakes3.Object, error) {
+	size := aws.ToInt64(awsObj.ContentLength)
+	obj := &gofakes3.Object{
+		Name:     objectName,
+		Metadata: upstreamMetadata(awsObj),
+		Size:     size,
+		Hash:     parseETagToHash(awsObj.ETag),
+	}
+	rng, err := rangeRequest.Range(size)
+	if err != nil {
+		awsObj.Body.Close()
+		return nil, err
+	}
+
+	// There's no cache entry to protect, so the body isn't MD5-verified
+	var body io.ReadCloser = newResumableBody(ctx, upstream, awsBucket, objectName, awsObj, false)
+	served := size
+	if rng != nil {
+		awsObj.Body.Close()
+		if body, err = b.fetchRange(ctx, upstream, awsBucket, objectName, awsObj.ETag, rng); err != nil {
+			return nil, b.quirks.translate(err, bucketName, objectName)
+		}
+		obj.Range, served = rng, rng.Length
+	}
+
+	log.Printf("[STREAM] %s/%s (%d bytes) - not caching", bucketName, objectName, size)
+	b.stats.recordMiss(served)
+	b.prefixStats.recordMiss(bucketName, objectName, served)
+	obj.Contents = &streamBody{Reader: b.shaper.reader(body), body: body, release: release}
+	return obj, nil
+}
+
+// fetchRange requests one range of an object, pinned to the ETag of the
+// first response.
+func (b *LazyBackend) fetchRange(ctx context.Context, upstream upstreamClient, awsBucket, objectName string, etag *string, rng *gofakes3.ObjectRange) (io.ReadCloser, error) {
+	out, err := upstream.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(awsBucket),
+		Key:     aws.String(objectName),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", rng.Start, rng.Start+rng.Length-1)),
+		IfMatch: etag,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(aws.ToString(out.ContentRange), fmt.Sprintf("bytes %d-", rng.Start)) {
+		return out.Body, nil
+	}
+	// The upstream ignored the range and sent the whole object: cut it out
+	if _, err := io.CopyN(io.Discard, out.Body, rng.Start); err != nil {
+		out.Body.Close()
+		return nil, fmt.Errorf("skipping to byte %d of %s/%s: %w", rng.Start, awsBucket, objectName, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(out.Body, rng.Length), out.Body}, nil
+}
+
+// streamBody is the contents of a streamed object. Closing it closes the
+// upstream response and frees the upstream fetch slot.
+type streamBody struct {
+	io.Reader
+	body    io.Closer
+	release func()
+	once    sync.Once
+}
+
+func (s *streamBody) Close() error {
+	err := s.body.Close()
+	s.once.Do(s.release)
+	return err
+}
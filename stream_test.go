@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_StreamThrough(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	lazyBackend.SetStreamThreshold(16)
+
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	large := strings.Repeat("0123456789", 10)
+	for key, content := range map[string]string{"large.bin": large, "small.txt": "small"} {
+		if _, err := awsBackend.PutObject("test-bucket", key, map[string]string{"Content-Type": "application/octet-stream"},
+			strings.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+
+	read := func(key string, rng *gofakes3.ObjectRangeRequest) (*gofakes3.Object, string) {
+		t.Helper()
+		obj, err := lazyBackend.GetObject("test-bucket", key, rng)
+		if err != nil {
+			t.Fatalf("GetObject(%s): %v", key, err)
+		}
+		defer obj.Contents.Close()
+		data, err := io.ReadAll(obj.Contents)
+		if err != nil {
+			t.Fatalf("reading %s: %v", key, err)
+		}
+		return obj, string(data)
+	}
+
+	obj, got := read("large.bin", nil)
+	if got != large || obj.Size != int64(len(large)) {
+		t.Errorf("large.bin = %q (size %d), want the upstream content", got, obj.Size)
+	}
+	if obj.Metadata["Content-Type"] != "application/octet-stream" {
+		t.Errorf("Content-Type = %q, want it passed through", obj.Metadata["Content-Type"])
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "large.bin"); err == nil {
+		t.Error("objects above the threshold should not be cached")
+	}
+
+	obj, got = read("large.bin", &gofakes3.ObjectRangeRequest{Start: 10, End: 19})
+	if got != "0123456789" {
+		t.Errorf("range of large.bin = %q, want %q", got, "0123456789")
+	}
+	if obj.Range == nil || obj.Range.Start != 10 || obj.Range.Length != 10 {
+		t.Errorf("Range = %+v, want start 10 length 10", obj.Range)
+	}
+
+	if _, got = read("small.txt", nil); got != "small" {
+		t.Errorf("small.txt = %q, want %q", got, "small")
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "small.txt"); err != nil {
+		t.Errorf("objects below the threshold should be cached: %v", err)
+	}
+
+	// Warming isn't a client read, so large objects are still cached
+	if err := lazyBackend.fill(context.Background(), "test-bucket", "large.bin"); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "large.bin"); err != nil {
+		t.Errorf("fill should cache objects above the threshold: %v", err)
+	}
+}
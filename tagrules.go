@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TagRule changes how objects carrying an upstream tag are cached.
+type TagRule struct {
+	// Tag is "key=value"
+	Tag string `yaml:"tag"`
+
+	// NoCache keeps matching objects out of the local backend; client reads
+	// are streamed from upstream instead
+	NoCache bool `yaml:"no_cache"`
+
+	// TTL replaces the bucket's TTL for matching objects
+	TTL time.Duration `yaml:"ttl"`
+}
+
+func (r TagRule) String() string {
+	if r.NoCache {
+		return r.Tag + " (no-cache)"
+	}
+	return fmt.Sprintf("%s (ttl %s)", r.Tag, r.TTL)
+}
+
+// validateTagRule checks that a rule names a tag and exactly one action.
+func validateTagRule(rule TagRule) error {
+	if key, _, ok := strings.Cut(rule.Tag, "="); !ok || key == "" {
+		return fmt.Errorf("invalid tag %q: want key=value", rule.Tag)
+	}
+	if rule.TTL < 0 {
+		return fmt.Errorf("tag %s: ttl must not be negative", rule.Tag)
+	}
+	if rule.NoCache == (rule.TTL > 0) {
+		return fmt.Errorf("tag %s: set exactly one of no_cache or ttl", rule.Tag)
+	}
+	return nil
+}
+
+// parseTagRules parses the S3LAZY_TAG_RULES format: comma-separated
+// "key=value:action" entries where action is "no-cache" or a TTL, e.g.
+// "pii=true:no-cache,tmp=true:5m".
+func parseTagRules(s string) ([]TagRule, error) {
+	var rules []TagRule
+	for _, entry := range parseCommaSeparated(s) {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid tag rule %q: want key=value:action", entry)
+		}
+		rule := TagRule{Tag: entry[:i]}
+		if action := entry[i+1:]; action == "no-cache" {
+			rule.NoCache = true
+		} else {
+			ttl, err := time.ParseDuration(action)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag rule %q: action must be no-cache or a duration", entry)
+			}
+			rule.TTL = ttl
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// upstreamTagger is an upstream that can report object tags.
+type upstreamTagger interface {
+	GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+}
+
+// tagRuleFor fetches an object's tags and returns the first rule matching
+// one of them. Tags are only fetched when rules are configured, and only
+// from upstreams that support them. If the tags can't be read the object
+// isn't cached, so a rule can't be bypassed by an upstream error.
+func (b *LazyBackend) tagRuleFor(ctx context.Context, upstream upstreamClient, awsBucket, objectName string) (TagRule, bool) {
+	b.mu.RLock()
+	rules := b.tagRules
+	b.mu.RUnlock()
+	if len(rules) == 0 {
+		return TagRule{}, false
+	}
+	tagger, ok := upstream.(upstreamTagger)
+	if !ok {
+		return TagRule{}, false
+	}
+
+	ctx, cancel := b.operation(ctx, opTagging)
+	defer cancel()
+	out, err := tagger.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(awsBucket),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		if isNotFound(b.quirks.translate(err, awsBucket, objectName)) {
+			// Let the GET report the missing object
+			return TagRule{}, false
+		}
+		log.Printf("[TAG ERROR] %s/%s: %v - not caching", awsBucket, objectName, err)
+		return TagRule{Tag: "unknown", NoCache: true}, true
+	}
+	tags := make(map[string]bool, len(out.TagSet))
+	for _, tag := range out.TagSet {
+		tags[aws.ToString(tag.Key)+"="+aws.ToString(tag.Value)] = true
+	}
+	for _, rule := range rules {
+		if tags[rule.Tag] {
+			log.Printf("[TAG RULE] %s/%s: %s", awsBucket, objectName, rule)
+			return rule, true
+		}
+	}
+	return TagRule{}, false
+}
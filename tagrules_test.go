@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestValidateTagRule(t *testing.T) {
+	for _, bad := range []TagRule{
+		{Tag: "pii", NoCache: true},
+		{Tag: "=true", NoCache: true},
+		{Tag: "pii=true"},
+		{Tag: "pii=true", NoCache: true, TTL: time.Minute},
+		{Tag: "tmp=true", TTL: -time.Minute},
+	} {
+		if err := validateTagRule(bad); err == nil {
+			t.Errorf("validateTagRule(%+v) should fail", bad)
+		}
+	}
+}
+
+// taggedUpstream serves object tags from a map, since gofakes3 doesn't keep
+// tags apart from object contents.
+type taggedUpstream struct {
+	*s3.Client
+	tags map[string]string // key -> "name=value"
+}
+
+func (u *taggedUpstream) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	out := &s3.GetObjectTaggingOutput{}
+	if tag, ok := u.tags[aws.ToString(params.Key)]; ok {
+		name, value, _ := strings.Cut(tag, "=")
+		out.TagSet = []types.Tag{{Key: aws.String(name), Value: aws.String(value)}}
+	}
+	return out, nil
+}
+
+func TestLazyBackend_TagRules(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	if err := lazyBackend.SetTagRules([]TagRule{
+		{Tag: "pii=true", NoCache: true},
+		{Tag: "tmp=true", TTL: time.Minute},
+	}); err != nil {
+		t.Fatalf("SetTagRules: %v", err)
+	}
+
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	upstream := &taggedUpstream{Client: lazyBackend.awsClient.(*s3.Client), tags: map[string]string{}}
+	lazyBackend.awsClient = upstream
+	for _, key := range []string{"customers.csv", "scratch.txt", "plain.txt"} {
+		if _, err := awsBackend.PutObject("test-bucket", key, nil, strings.NewReader(key), int64(len(key)), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	upstream.tags["customers.csv"] = "pii=true"
+	upstream.tags["scratch.txt"] = "tmp=true"
+
+	read := func(key string) string {
+		t.Helper()
+		obj, err := lazyBackend.GetObject("test-bucket", key, nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s): %v", key, err)
+		}
+		defer obj.Contents.Close()
+		data, _ := io.ReadAll(obj.Contents)
+		return string(data)
+	}
+
+	if got := read("customers.csv"); got != "customers.csv" {
+		t.Errorf("customers.csv = %q, want the upstream content", got)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "customers.csv"); err == nil {
+		t.Error("objects tagged pii=true should not be cached")
+	}
+	if err := lazyBackend.fill(context.Background(), "test-bucket", "customers.csv"); !errors.Is(err, errNotCacheable) {
+		t.Errorf("fill error = %v, want errNotCacheable", err)
+	}
+
+	read("scratch.txt")
+	if entry, ok := lazyBackend.index.lookup("test-bucket", "scratch.txt"); !ok || entry.TTL != time.Minute {
+		t.Errorf("scratch.txt entry = %+v, want a 1m TTL", entry)
+	}
+	read("plain.txt")
+	if entry, ok := lazyBackend.index.lookup("test-bucket", "plain.txt"); !ok || entry.TTL != 0 {
+		t.Errorf("plain.txt entry = %+v, want cached with the bucket TTL", entry)
+	}
+
+	// Tagging a cached object drops it on the next revalidation
+	upstream.tags["plain.txt"] = "pii=true"
+	lazyBackend.SetRevalidate(true)
+	if got := read("plain.txt"); got != "plain.txt" {
+		t.Errorf("plain.txt = %q, want the upstream content", got)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "plain.txt"); err == nil {
+		t.Error("plain.txt should be dropped from the cache once tagged pii=true")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Metadata a team cache copy keeps of the origin object it was filled from,
+// so instances reading the copy cache it under the origin's validators.
+const (
+	teamETagMeta         = "s3lazy-origin-etag"
+	teamLastModifiedMeta = "s3lazy-origin-last-modified"
+)
+
+// teamClient is what a team cache needs from its S3 bucket; *s3.Client
+// implements it.
+type teamClient interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// teamCache is a shared S3 bucket, such as an in-region mirror, looked up
+// between the local cache and the origin. Objects filled from the origin are
+// written back to it, so cross-region egress is paid once per team rather
+// than once per instance. Copies are kept under "<prefix><origin bucket>/".
+type teamCache struct {
+	client     teamClient
+	bucket     string
+	prefix     string
+	region     string
+	writeBacks *backgroundFills
+}
+
+func newTeamCache(client teamClient, bucket, prefix, region string) *teamCache {
+	return &teamCache{client: client, bucket: bucket, prefix: prefix, region: region, writeBacks: newBackgroundFills()}
+}
+
+// SetTeamCache looks uncached objects up in a team cache bucket before the
+// origin. A nil team cache disables it.
+func (b *LazyBackend) SetTeamCache(team *teamCache) {
+	b.team = team
+}
+
+// key returns the key of an origin object's copy in the team bucket.
+func (t *teamCache) key(awsBucket, objectName string) string {
+	return t.prefix + awsBucket + "/" + objectName
+}
+
+// options points requests at the team bucket's region, if it has its own.
+func (t *teamCache) options(o *s3.Options) {
+	if t.region != "" {
+		o.Region = t.region
+	}
+}
+
+// covers reports whether objects from upstream go through the team cache:
+// URL sources aren't S3 buckets.
+func (t *teamCache) covers(upstream upstreamClient) bool {
+	if t == nil {
+		return false
+	}
+	_, isHTTPSource := upstream.(*httpSource)
+	return !isHTTPSource
+}
+
+// get reads an origin object's copy from the team bucket, with the origin's
+// ETag and Last-Modified restored.
+func (t *teamCache) get(ctx context.Context, awsBucket, objectName string) (*s3.GetObjectOutput, error) {
+	out, err := t.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key(awsBucket, objectName)),
+	}, t.options)
+	if err != nil {
+		return nil, err
+	}
+	if etag, ok := out.Metadata[teamETagMeta]; ok {
+		out.ETag = aws.String(etag)
+	}
+	if modified, ok := out.Metadata[teamLastModifiedMeta]; ok {
+		if at, err := time.Parse(http.TimeFormat, modified); err == nil {
+			out.LastModified = aws.Time(at)
+		}
+	}
+	meta := make(map[string]string, len(out.Metadata))
+	for k, v := range out.Metadata {
+		if !strings.HasPrefix(k, "s3lazy-origin-") {
+			meta[k] = v
+		}
+	}
+	out.Metadata = meta
+	return out, nil
+}
+
+// writeBack copies an object just filled from the origin to the team bucket
+// in the background, from the local copy. Redacted copies are kept to this
+// instance: other instances may be configured to scrub differently.
+func (b *LazyBackend) writeBack(bucketName, objectName, awsBucket string, awsObj *s3.GetObjectOutput) {
+	if b.redactor != nil {
+		return
+	}
+	team := b.team
+	input := &s3.PutObjectInput{
+		Bucket:       aws.String(team.bucket),
+		Key:          aws.String(team.key(awsBucket, objectName)),
+		ContentType:  awsObj.ContentType,
+		CacheControl: awsObj.CacheControl,
+		Metadata:     map[string]string{teamETagMeta: aws.ToString(awsObj.ETag)},
+	}
+	for k, v := range awsObj.Metadata {
+		input.Metadata[k] = v
+	}
+	if awsObj.LastModified != nil {
+		input.Metadata[teamLastModifiedMeta] = awsObj.LastModified.UTC().Format(http.TimeFormat)
+	}
+	team.writeBacks.start(bucketName, objectName, func(ctx context.Context, bucketName, objectName string) error {
+		// The fill holds the key's lock until the local copy is complete.
+		// Uploads need a seekable body to be signed.
+		unlock := b.locks.RLock(bucketName, objectName)
+		obj, err := b.local.GetObject(bucketName, objectName, nil)
+		if err != nil {
+			unlock()
+			return err
+		}
+		spooled, sent, err := spoolForUpload(obj.Contents)
+		obj.Contents.Close()
+		unlock()
+		if err != nil {
+			return err
+		}
+		defer spooled.Close()
+		input.Body, input.ContentLength = spooled, aws.Int64(sent.size)
+		put, err := team.client.PutObject(ctx, input, team.options)
+		if err != nil {
+			return fmt.Errorf("writing back to team cache %s/%s: %w", team.bucket, *input.Key, err)
+		}
+		if err := b.verifier.verify(ctx, team.client, team.bucket, *input.Key, sent, put, team.options); err != nil {
+			// Other instances would cache a bad copy as the origin's
+			if _, delErr := team.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: input.Bucket, Key: input.Key}, team.options); delErr != nil {
+				log.Printf("Warning: couldn't delete unverified team cache copy %s/%s: %v", team.bucket, *input.Key, delErr)
+			}
+			return err
+		}
+		log.Printf("[TEAM WRITE-BACK] %s/%s -> %s/%s (%d bytes)", bucketName, objectName, team.bucket, *input.Key, sent.size)
+		return nil
+	})
+}
+
+// wait blocks until every running write-back has finished.
+func (t *teamCache) wait() {
+	if t != nil {
+		t.writeBacks.wait()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func TestLazyBackend_TeamCache(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putString(t, awsBackend, "file.txt", "shared data")
+	origin, err := awsBackend.HeadObject("test-bucket", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teamBackend := s3mem.New()
+	if err := teamBackend.CreateBucket("team-mirror"); err != nil {
+		t.Fatal(err)
+	}
+	teamServer := httptest.NewServer(gofakes3.New(teamBackend).Server())
+	defer teamServer.Close()
+	team := newTeamCache(newEndpointClient(t, teamServer.URL), "team-mirror", "s3lazy/", "")
+	lazyBackend.SetTeamCache(team)
+
+	// A miss everywhere is filled from the origin and written back
+	if _, data := readObject(t, lazyBackend, "file.txt", nil); data != "shared data" {
+		t.Fatalf("data = %q", data)
+	}
+	team.wait()
+	copied, err := teamBackend.GetObject("team-mirror", "s3lazy/test-bucket/file.txt", nil)
+	if err != nil {
+		t.Fatalf("team copy: %v", err)
+	}
+	data, _ := io.ReadAll(copied.Contents)
+	copied.Contents.Close()
+	if string(data) != "shared data" {
+		t.Errorf("team copy = %q", data)
+	}
+
+	// Another instance finds it in the team cache, under the origin's ETag,
+	// without asking the origin
+	if _, err := awsBackend.DeleteObject("test-bucket", "file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	otherLocal := s3mem.New()
+	if err := otherLocal.CreateBucket("test-bucket"); err != nil {
+		t.Fatal(err)
+	}
+	other := NewLazyBackend(otherLocal, lazyBackend.awsClient)
+	other.SetTeamCache(team)
+	obj, data2 := readObject(t, other, "file.txt", nil)
+	if data2 != "shared data" {
+		t.Errorf("data = %q", data2)
+	}
+	if obj.Hash == nil || string(obj.Hash) != string(origin.Hash) {
+		t.Errorf("Hash = %x, want the origin's %x", obj.Hash, origin.Hash)
+	}
+	if got, want := other.index.etag("test-bucket", "file.txt"), lazyBackend.index.etag("test-bucket", "file.txt"); got != want {
+		t.Errorf("ETag = %s, want the origin's %s", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// maxAuditEntries bounds how many toggle changes the audit log keeps.
+const maxAuditEntries = 100
+
+// logLevels maps the log levels accepted by the admin API onto gofakes3's,
+// ordered from least to most verbose.
+var logLevels = []struct {
+	name  string
+	level gofakes3.LogLevel
+}{
+	{"error", gofakes3.LogErr},
+	{"warn", gofakes3.LogWarn},
+	{"info", gofakes3.LogInfo},
+	{"debug", gofakes3.LogDebug},
+}
+
+// logLevelRank returns the position of a log level name in logLevels.
+func logLevelRank(name string) (int, bool) {
+	for i, l := range logLevels {
+		if l.name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// runtimeToggles are operational switches that can be flipped through the
+// admin API and take effect immediately, without a restart.
+type runtimeToggles struct {
+	// offline serves only what is cached and never contacts upstream
+	offline atomic.Bool
+
+	// readOnly rejects client writes; cache fills still happen
+	readOnly atomic.Bool
+
+	// bypass re-fetches every cache hit from upstream
+	bypass atomic.Bool
+
+	// logLevel is the rank in logLevels of the most verbose level logged
+	logLevel atomic.Int32
+
+	mu    sync.Mutex
+	audit []auditEntry
+
+	// onAudit are called with every audit entry, under mu
+	onAudit []func(auditEntry)
+}
+
+// auditEntry records a single toggle change.
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Identity string    `json:"identity"`
+	Remote   string    `json:"remote"`
+	Setting  string    `json:"setting"`
+	From     any       `json:"from"`
+	To       any       `json:"to"`
+}
+
+// toggleState is the JSON form of the toggles. Pointer fields let a PATCH
+// change only the settings it names.
+type toggleState struct {
+	Offline     *bool   `json:"offline,omitempty"`
+	ReadOnly    *bool   `json:"read_only,omitempty"`
+	CacheBypass *bool   `json:"cache_bypass,omitempty"`
+	LogLevel    *string `json:"log_level,omitempty"`
+}
+
+func newRuntimeToggles() *runtimeToggles {
+	t := &runtimeToggles{}
+	rank, _ := logLevelRank("info")
+	t.logLevel.Store(int32(rank))
+	return t
+}
+
+// state returns the current value of every toggle.
+func (t *runtimeToggles) state() toggleState {
+	offline, readOnly, bypass := t.offline.Load(), t.readOnly.Load(), t.bypass.Load()
+	level := logLevels[t.logLevel.Load()].name
+	return toggleState{Offline: &offline, ReadOnly: &readOnly, CacheBypass: &bypass, LogLevel: &level}
+}
+
+// apply changes the toggles set in s, recording each change that actually
+// alters a value in the audit log along with the identity and address that
+// made it. Nothing is changed if s is invalid.
+func (t *runtimeToggles) apply(s toggleState, identity, remote string) error {
+	var rank int
+	if s.LogLevel != nil {
+		var ok bool
+		if rank, ok = logLevelRank(*s.LogLevel); !ok {
+			return fmt.Errorf("invalid log_level %q (valid options: error, warn, info, debug)", *s.LogLevel)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	setBool := func(setting string, flag *atomic.Bool, v *bool) {
+		if v != nil && flag.Swap(*v) != *v {
+			t.record(identity, remote, setting, !*v, *v)
+		}
+	}
+	setBool("offline", &t.offline, s.Offline)
+	setBool("read_only", &t.readOnly, s.ReadOnly)
+	setBool("cache_bypass", &t.bypass, s.CacheBypass)
+	if s.LogLevel != nil {
+		if old := t.logLevel.Swap(int32(rank)); int(old) != rank {
+			t.record(identity, remote, "log_level", logLevels[old].name, *s.LogLevel)
+		}
+	}
+	return nil
+}
+
+// record appends an audit entry. The caller must hold t.mu.
+func (t *runtimeToggles) record(identity, remote, setting string, from, to any) {
+	log.Printf("[AUDIT] %s (%s) changed %s from %v to %v", identity, remote, setting, from, to)
+	e := auditEntry{Time: time.Now(), Identity: identity, Remote: remote, Setting: setting, From: from, To: to}
+	t.audit = append(t.audit, e)
+	for _, fn := range t.onAudit {
+		fn(e)
+	}
+	if len(t.audit) > maxAuditEntries {
+		t.audit = t.audit[len(t.audit)-maxAuditEntries:]
+	}
+}
+
+// auditLog returns the recorded toggle changes, oldest first.
+func (t *runtimeToggles) auditLog() []auditEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]auditEntry{}, t.audit...)
+}
+
+// enabled reports whether messages at a gofakes3 log level are logged.
+func (t *runtimeToggles) enabled(level gofakes3.LogLevel) bool {
+	for i, l := range logLevels {
+		if l.level == level {
+			return int32(i) <= t.logLevel.Load()
+		}
+	}
+	return true
+}
+
+// infof logs a per-request message that is suppressed below the info level.
+func (t *runtimeToggles) infof(format string, args ...any) {
+	if t.enabled(gofakes3.LogInfo) {
+		log.Printf(format, args...)
+	}
+}
+
+// Print implements gofakes3.Logger, filtering by the current log level.
+func (t *runtimeToggles) Print(level gofakes3.LogLevel, v ...any) {
+	if t.enabled(level) {
+		log.Println(append([]any{level}, v...)...)
+	}
+}
+
+// readOnlyGuard rejects S3 write requests while read-only mode is on. Only
+// GET and HEAD requests read; every other method changes state.
+func (t *runtimeToggles) readOnlyGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.readOnly.Load() && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusForbidden)
+			_ = xml.NewEncoder(w).Encode(&gofakes3.ErrorResponse{
+				Code:    "AccessDenied",
+				Message: ErrReadOnly.Error(),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
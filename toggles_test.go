@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestRuntimeToggles_Apply(t *testing.T) {
+	toggles := newRuntimeToggles()
+	on, debug := true, "debug"
+
+	if err := toggles.apply(toggleState{Offline: &on, LogLevel: &debug}, "alice", "10.0.0.1:1234"); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !toggles.offline.Load() || !toggles.enabled(gofakes3.LogDebug) {
+		t.Error("offline and debug logging should be enabled")
+	}
+
+	// Re-applying the same values isn't a change worth auditing
+	if err := toggles.apply(toggleState{Offline: &on}, "alice", "10.0.0.1:1234"); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	audit := toggles.auditLog()
+	if len(audit) != 2 {
+		t.Fatalf("audit has %d entries, want 2", len(audit))
+	}
+	if audit[1].Setting != "log_level" || audit[1].From != "info" || audit[1].To != "debug" {
+		t.Errorf("audit[1] = %+v, want log_level info -> debug", audit[1])
+	}
+
+	bogus := "verbose"
+	if err := toggles.apply(toggleState{ReadOnly: &on, LogLevel: &bogus}, "alice", "10.0.0.1:1234"); err == nil {
+		t.Error("expected error for invalid log level")
+	}
+	if toggles.readOnly.Load() {
+		t.Error("an invalid change should not apply any toggle")
+	}
+}
+
+func TestRuntimeToggles_LogLevel(t *testing.T) {
+	toggles := newRuntimeToggles()
+	if toggles.enabled(gofakes3.LogDebug) || !toggles.enabled(gofakes3.LogInfo) {
+		t.Error("default level should be info")
+	}
+	warn := "warn"
+	_ = toggles.apply(toggleState{LogLevel: &warn}, "test", "test")
+	if toggles.enabled(gofakes3.LogInfo) || !toggles.enabled(gofakes3.LogErr) {
+		t.Error("warn level should suppress info but keep errors")
+	}
+}
+
+func TestRuntimeToggles_ReadOnlyGuard(t *testing.T) {
+	toggles := newRuntimeToggles()
+	handler := toggles.readOnlyGuard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(method string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/bucket/key", nil))
+		return rec.Code
+	}
+
+	if code := do(http.MethodPut); code != http.StatusOK {
+		t.Errorf("PUT status = %d, want %d", code, http.StatusOK)
+	}
+	toggles.readOnly.Store(true)
+	for _, method := range []string{http.MethodPut, http.MethodPost, http.MethodDelete} {
+		if code := do(method); code != http.StatusForbidden {
+			t.Errorf("%s status = %d, want %d", method, code, http.StatusForbidden)
+		}
+	}
+	if code := do(http.MethodGet); code != http.StatusOK {
+		t.Errorf("GET status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestLazyBackend_OfflineAndBypass(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	put := func(key, content string) {
+		t.Helper()
+		if _, err := awsBackend.PutObject("test-bucket", key, nil,
+			bytes.NewReader([]byte(content)), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put object in AWS: %v", err)
+		}
+	}
+	read := func(key string) (string, error) {
+		obj, err := lazyBackend.GetObject("test-bucket", key, nil)
+		if err != nil {
+			return "", err
+		}
+		defer obj.Contents.Close()
+		data, _ := io.ReadAll(obj.Contents)
+		return string(data), nil
+	}
+
+	put("cached.txt", "v1")
+	put("uncached.txt", "data")
+	if got, err := read("cached.txt"); err != nil || got != "v1" {
+		t.Fatalf("read = %q, %v; want v1", got, err)
+	}
+
+	lazyBackend.toggles.offline.Store(true)
+	if got, err := read("cached.txt"); err != nil || got != "v1" {
+		t.Errorf("offline read of cached key = %q, %v; want v1", got, err)
+	}
+	_, err := read("uncached.txt")
+	if !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) || !strings.Contains(err.Error(), "offline") {
+		t.Errorf("offline read of uncached key error = %v, want NoSuchKey", err)
+	}
+	if _, err := lazyBackend.HeadObject("test-bucket", "uncached.txt"); !isNotFound(err) {
+		t.Errorf("offline HEAD of uncached key error = %v, want NoSuchKey", err)
+	}
+	lazyBackend.toggles.offline.Store(false)
+
+	put("cached.txt", "v2")
+	lazyBackend.toggles.bypass.Store(true)
+	if got, err := read("cached.txt"); err != nil || got != "v2" {
+		t.Errorf("bypass read = %q, %v; want v2", got, err)
+	}
+}
+
+func TestAdminToggles(t *testing.T) {
+	lazyBackend, _, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPatch, "/admin/toggles", `{"read_only": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"read_only": true`) || !lazyBackend.toggles.readOnly.Load() {
+		t.Errorf("read_only should be on, got %s", rec.Body)
+	}
+	if rec := do(http.MethodPatch, "/admin/toggles", `{"readonly": true}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PATCH with unknown toggle status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodPatch, "/admin/toggles", `{"log_level": "loud"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PATCH with invalid level status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = do(http.MethodGet, "/admin/audit", "")
+	if !strings.Contains(rec.Body.String(), `"setting": "read_only"`) {
+		t.Errorf("audit log = %s, want the read_only change", rec.Body)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"time"
+)
+
+// SetTTLJitter shortens each cached object's TTL by a random share of up to
+// percent percent, so objects warmed together don't all expire, and hit
+// upstream, at once. 0 disables it.
+func (b *LazyBackend) SetTTLJitter(percent int) {
+	b.ttlJitter = percent
+}
+
+// jittered returns an entry's TTL shortened by its share of the jitter. The
+// share is derived from the key and when the copy was cached, so it stays the
+// same for a cached copy and is drawn afresh each time it is fetched or
+// revalidated.
+func (b *LazyBackend) jittered(e cacheEntry, ttl time.Duration) time.Duration {
+	if b.ttlJitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	h := fnv.New64a()
+	h.Write([]byte(e.Bucket))
+	h.Write([]byte{0})
+	h.Write([]byte(e.Key))
+	h.Write(binary.LittleEndian.AppendUint64(nil, uint64(e.CachedAt.UnixNano())))
+	share := float64(h.Sum64()%10000) / 10000
+	return ttl - time.Duration(float64(ttl)*float64(b.ttlJitter)/100*share)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLazyBackend_TTLJitter(t *testing.T) {
+	lazyBackend, _, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	lazyBackend.SetCacheTTL(time.Hour, nil)
+	cachedAt := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+
+	entry := func(key string) cacheEntry {
+		return cacheEntry{Bucket: "test-bucket", Key: key, CachedAt: cachedAt}
+	}
+	if ttl := lazyBackend.entryTTL(entry("a")); ttl != time.Hour {
+		t.Errorf("TTL without jitter = %v, want 1h", ttl)
+	}
+
+	lazyBackend.SetTTLJitter(10)
+	ttls := make(map[time.Duration]bool)
+	for i := range 100 {
+		e := entry(fmt.Sprintf("key-%d", i))
+		ttl := lazyBackend.entryTTL(e)
+		if ttl > time.Hour || ttl < 54*time.Minute {
+			t.Fatalf("jittered TTL = %v, want between 54m and 1h", ttl)
+		}
+		if again := lazyBackend.entryTTL(e); again != ttl {
+			t.Fatalf("TTL of the same copy changed from %v to %v", ttl, again)
+		}
+		ttls[ttl] = true
+	}
+	if len(ttls) < 50 {
+		t.Errorf("only %d distinct TTLs among 100 objects cached together", len(ttls))
+	}
+
+	// Revalidating draws a new share
+	e := entry("key-0")
+	renewed := e
+	renewed.CachedAt = cachedAt.Add(time.Minute)
+	if lazyBackend.entryTTL(e) == lazyBackend.entryTTL(renewed) {
+		t.Error("renewed copy kept the same jitter")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// defaultPresignedUploadExpiry is how long pre-signed part URLs stay valid
+// unless configured otherwise.
+const defaultPresignedUploadExpiry = time.Hour
+
+// maxPresignedUploadExpiry is the longest SigV4 allows a pre-signed URL to
+// stay valid.
+const maxPresignedUploadExpiry = 7 * 24 * time.Hour
+
+// maxUploadParts is the most parts S3 accepts in a multipart upload.
+const maxUploadParts = 10000
+
+// errNoSuchUpload is returned for upload IDs s3lazy didn't start or already
+// finished.
+var errNoSuchUpload = errors.New("no such pre-signed upload")
+
+// presignedUpload is a multipart upload a client sends straight to upstream.
+type presignedUpload struct {
+	ID        string    `json:"upload_id"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Parts     []partURL `json:"parts"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	upstreamBucket string
+}
+
+// partURL is the pre-signed URL a client PUTs one part to.
+type partURL struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+}
+
+// uploadedPart is a part the client uploaded, as it reports on completion.
+type uploadedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// presignedUploads starts multipart uploads upstream and hands clients
+// pre-signed URLs for their parts, so huge uploads don't pass through
+// s3lazy twice. Uploads are remembered until completed or aborted, or until
+// their URLs expire.
+type presignedUploads struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	expiry  time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	uploads map[string]*presignedUpload // by upload ID
+}
+
+func newPresignedUploads(client *s3.Client, expiry time.Duration) *presignedUploads {
+	return &presignedUploads{
+		client:  client,
+		presign: s3.NewPresignClient(client),
+		expiry:  expiry,
+		now:     time.Now,
+		uploads: make(map[string]*presignedUpload),
+	}
+}
+
+// take removes and returns an upload that hasn't expired.
+func (u *presignedUploads) take(id string) (*presignedUpload, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload, ok := u.uploads[id]
+	if !ok || u.now().After(upload.ExpiresAt) {
+		return nil, fmt.Errorf("%w: %s", errNoSuchUpload, id)
+	}
+	delete(u.uploads, id)
+	return upload, nil
+}
+
+// forgetExpired drops uploads whose URLs expired. Upstream keeps their
+// parts until a lifecycle rule aborts them.
+func (u *presignedUploads) forgetExpired() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for id, upload := range u.uploads {
+		if u.now().After(upload.ExpiresAt) {
+			log.Printf("[UPLOAD] %s/%s: upload %s expired without completing", upload.Bucket, upload.Key, id)
+			delete(u.uploads, id)
+		}
+	}
+}
+
+// s3ClientOf returns the S3 client behind an upstream client's wrappers, for
+// requests the wrappers don't cover.
+func s3ClientOf(client upstreamClient) (*s3.Client, bool) {
+	switch c := client.(type) {
+	case *s3.Client:
+		return c, true
+	case *regionCorrector:
+		return c.client, true
+	case *hedgedClient:
+		return s3ClientOf(c.upstreamLister)
+	case *fallbackChain:
+		return s3ClientOf(c.upstreamLister)
+	case *circuitBreaker:
+		return s3ClientOf(c.upstreamLister)
+	case *endpointPool:
+		return s3ClientOf(c.endpoints[0].client)
+	}
+	return nil, false
+}
+
+// SetPresignedUploads lets clients upload objects straight to upstream with
+// pre-signed multipart URLs valid for expiry. A nil client disables it.
+func (b *LazyBackend) SetPresignedUploads(client *s3.Client, expiry time.Duration) {
+	b.uploads = nil
+	if client != nil {
+		b.uploads = newPresignedUploads(client, expiry)
+	}
+}
+
+// StartPresignedUpload starts a multipart upload of a key in the bucket's
+// upstream and returns a pre-signed URL for each of its parts.
+func (b *LazyBackend) StartPresignedUpload(ctx context.Context, bucketName, objectName string, parts int) (*presignedUpload, error) {
+	if err := b.rejectWrite(bucketName); err != nil {
+		return nil, err
+	}
+	if parts < 1 || parts > maxUploadParts {
+		return nil, fmt.Errorf("parts must be between 1 and %d, got %d", maxUploadParts, parts)
+	}
+	bucketName = b.canonicalBucket(bucketName)
+	b.mu.RLock()
+	_, urlSource := b.urlSources[bucketName]
+	_, ownUpstream := b.bucketUpstreams[bucketName]
+	b.mu.RUnlock()
+	if urlSource {
+		return nil, fmt.Errorf("bucket %s is fetched from a URL source, which can't take uploads", bucketName)
+	}
+	if ownUpstream {
+		return nil, fmt.Errorf("bucket %s has its own upstream, which pre-signed uploads don't support", bucketName)
+	}
+	b.uploads.forgetExpired()
+
+	upstreamBucket := b.awsBucketName(bucketName)
+	created, err := b.uploads.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(upstreamBucket),
+		Key:    aws.String(objectName),
+	})
+	if err != nil {
+		return nil, b.quirks.translate(err, bucketName, objectName)
+	}
+	upload := &presignedUpload{
+		ID:             aws.ToString(created.UploadId),
+		Bucket:         bucketName,
+		Key:            objectName,
+		ExpiresAt:      b.uploads.now().Add(b.uploads.expiry),
+		upstreamBucket: upstreamBucket,
+	}
+	for n := int32(1); n <= int32(parts); n++ {
+		req, err := b.uploads.presign.PresignUploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(upstreamBucket),
+			Key:        aws.String(objectName),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int32(n),
+		}, s3.WithPresignExpires(b.uploads.expiry))
+		if err != nil {
+			_ = b.abortUpstream(upload)
+			return nil, err
+		}
+		upload.Parts = append(upload.Parts, partURL{PartNumber: n, URL: req.URL})
+	}
+
+	b.uploads.mu.Lock()
+	b.uploads.uploads[upload.ID] = upload
+	b.uploads.mu.Unlock()
+	log.Printf("[UPLOAD] %s/%s: started upload %s of %d part(s) to %s", bucketName, objectName, upload.ID, parts, upstreamBucket)
+	return upload, nil
+}
+
+// CompletePresignedUpload completes an upload once the client uploaded its
+// parts. Any local copy of the key is purged, so the next read fetches the
+// new object from upstream.
+func (b *LazyBackend) CompletePresignedUpload(ctx context.Context, id string, parts []uploadedPart) (string, error) {
+	upload, err := b.uploads.take(id)
+	if err != nil {
+		return "", err
+	}
+	completed := make([]types.CompletedPart, len(parts))
+	for i, p := range parts {
+		completed[i] = types.CompletedPart{PartNumber: aws.Int32(p.PartNumber), ETag: aws.String(p.ETag)}
+	}
+	out, err := b.uploads.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(upload.upstreamBucket),
+		Key:             aws.String(upload.Key),
+		UploadId:        aws.String(id),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		// Let the client retry with the right parts
+		b.uploads.mu.Lock()
+		b.uploads.uploads[id] = upload
+		b.uploads.mu.Unlock()
+		return "", b.quirks.translate(err, upload.Bucket, upload.Key)
+	}
+	if _, err := b.purge(upload.Bucket, upload.Key, true); err != nil {
+		log.Printf("[UPLOAD ERROR] %s/%s: couldn't purge the local copy: %v", upload.Bucket, upload.Key, err)
+	}
+	log.Printf("[UPLOAD] %s/%s: completed upload %s", upload.Bucket, upload.Key, id)
+	b.events.record(upload.Bucket, upload.Key, eventWrittenBack, "ETag "+aws.ToString(out.ETag))
+	return aws.ToString(out.ETag), nil
+}
+
+// AbortPresignedUpload aborts an upload and discards its parts upstream.
+func (b *LazyBackend) AbortPresignedUpload(id string) error {
+	upload, err := b.uploads.take(id)
+	if err != nil {
+		return err
+	}
+	if err := b.abortUpstream(upload); err != nil {
+		return b.quirks.translate(err, upload.Bucket, upload.Key)
+	}
+	log.Printf("[UPLOAD] %s/%s: aborted upload %s", upload.Bucket, upload.Key, id)
+	return nil
+}
+
+// abortUpstream aborts an upload upstream. It outlives the request that
+// asked for it, so a disconnecting client doesn't leave parts behind.
+func (b *LazyBackend) abortUpstream(upload *presignedUpload) error {
+	_, err := b.uploads.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(upload.upstreamBucket),
+		Key:      aws.String(upload.Key),
+		UploadId: aws.String(upload.ID),
+	})
+	return err
+}
+
+// registerUploads adds the pre-signed upload endpoints to the admin API.
+func registerUploads(mux *http.ServeMux, lazy *LazyBackend) {
+	fail := func(w http.ResponseWriter, err error) {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, errNoSuchUpload):
+			status = http.StatusNotFound
+		case errors.Is(err, ErrReadOnly):
+			status = http.StatusForbidden
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+	}
+	enabled := func(w http.ResponseWriter) bool {
+		if lazy.uploads == nil {
+			http.Error(w, "pre-signed uploads are disabled", http.StatusNotFound)
+			return false
+		}
+		return true
+	}
+
+	mux.HandleFunc("POST /admin/uploads", func(w http.ResponseWriter, r *http.Request) {
+		if !enabled(w) {
+			return
+		}
+		q := r.URL.Query()
+		bucket, key := q.Get("bucket"), q.Get("key")
+		parts, err := strconv.Atoi(q.Get("parts"))
+		if bucket == "" || key == "" || err != nil {
+			http.Error(w, "bucket, key and parts are required", http.StatusBadRequest)
+			return
+		}
+		if parts < 1 || parts > maxUploadParts {
+			http.Error(w, fmt.Sprintf("parts must be between 1 and %d", maxUploadParts), http.StatusBadRequest)
+			return
+		}
+		upload, err := lazy.StartPresignedUpload(r.Context(), bucket, key, parts)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, upload)
+	})
+	mux.HandleFunc("POST /admin/uploads/{id}/complete", func(w http.ResponseWriter, r *http.Request) {
+		if !enabled(w) {
+			return
+		}
+		var body struct {
+			Parts []uploadedPart `json:"parts"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Parts) == 0 {
+			http.Error(w, "body must list the uploaded parts", http.StatusBadRequest)
+			return
+		}
+		etag, err := lazy.CompletePresignedUpload(r.Context(), r.PathValue("id"), body.Parts)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"etag": etag})
+	})
+	mux.HandleFunc("DELETE /admin/uploads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if !enabled(w) {
+			return
+		}
+		if err := lazy.AbortPresignedUpload(r.PathValue("id")); err != nil {
+			fail(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestPresignedUploads(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/admin/uploads?bucket=test-bucket&key=big.bin&parts=2", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("status with uploads disabled = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	lazyBackend.SetPresignedUploads(lazyBackend.awsClient.(*s3.Client), time.Hour)
+	// A stale local copy is replaced by the uploaded object
+	putString(t, lazyBackend, "big.bin", "stale")
+
+	rec := do(http.MethodPost, "/admin/uploads?bucket=test-bucket&key=big.bin&parts=2", "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("start status = %d: %s", rec.Code, rec.Body)
+	}
+	var upload presignedUpload
+	if err := json.NewDecoder(rec.Body).Decode(&upload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(upload.Parts) != 2 || !strings.Contains(upload.Parts[0].URL, "X-Amz-Signature") {
+		t.Fatalf("parts = %+v, want 2 pre-signed URLs", upload.Parts)
+	}
+
+	// The client uploads its parts straight to upstream
+	var parts []string
+	for i, part := range upload.Parts {
+		req, _ := http.NewRequest(http.MethodPut, part.URL, strings.NewReader(fmt.Sprintf("part %d;", i+1)))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT part %d: %v", part.PartNumber, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT part %d status = %d", part.PartNumber, resp.StatusCode)
+		}
+		parts = append(parts, fmt.Sprintf(`{"part_number":%d,"etag":%q}`, part.PartNumber, resp.Header.Get("ETag")))
+	}
+
+	complete := "/admin/uploads/" + upload.ID + "/complete"
+	if rec := do(http.MethodPost, complete, `{"parts":[`+strings.Join(parts, ",")+`]}`); rec.Code != http.StatusOK {
+		t.Fatalf("complete status = %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := localBackend.HeadObject("test-bucket", "big.bin"); err == nil {
+		t.Error("the stale local copy should be purged")
+	}
+	if _, got := readObject(t, lazyBackend, "big.bin", nil); got != "part 1;part 2;" {
+		t.Errorf("content = %q, want the uploaded parts", got)
+	}
+
+	if rec := do(http.MethodPost, complete, `{"parts":[`+parts[0]+`]}`); rec.Code != http.StatusNotFound {
+		t.Errorf("second complete status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestPresignedUploads_Abort(t *testing.T) {
+	lazyBackend, _, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	if err := awsBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	lazyBackend.SetPresignedUploads(lazyBackend.awsClient.(*s3.Client), time.Hour)
+	admin := newAdminHandler(lazyBackend, DefaultConfig())
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	for _, target := range []string{
+		"/admin/uploads?bucket=test-bucket&key=big.bin",
+		"/admin/uploads?bucket=test-bucket&key=big.bin&parts=10001",
+	} {
+		if rec := do(http.MethodPost, target); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s status = %d, want %d", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	upload, err := lazyBackend.StartPresignedUpload(t.Context(), "test-bucket", "big.bin", 1)
+	if err != nil {
+		t.Fatalf("StartPresignedUpload: %v", err)
+	}
+	if rec := do(http.MethodDelete, "/admin/uploads/"+upload.ID); rec.Code != http.StatusNoContent {
+		t.Errorf("abort status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodDelete, "/admin/uploads/"+upload.ID); rec.Code != http.StatusNotFound {
+		t.Errorf("second abort status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	lazyBackend.toggles.readOnly.Store(true)
+	if rec := do(http.MethodPost, "/admin/uploads?bucket=test-bucket&key=big.bin&parts=1"); rec.Code != http.StatusForbidden {
+		t.Errorf("status in read-only mode = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
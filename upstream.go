@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// upstreamClient is the subset of the S3 API that LazyBackend needs from an
+// upstream source. *s3.Client satisfies it directly; non-S3 sources adapt
+// their responses to the same shape.
+type upstreamClient interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// upstreamFor returns the client and upstream bucket name to use for a local bucket.
+func (b *LazyBackend) upstreamFor(localBucket string) (upstreamClient, string) {
+	b.mu.RLock()
+	src, ok := b.urlSources[localBucket]
+	b.mu.RUnlock()
+	if ok {
+		return src, localBucket
+	}
+	b.mu.RLock()
+	own, ok := b.bucketUpstreams[localBucket]
+	b.mu.RUnlock()
+	if ok {
+		return own.client, b.awsBucketName(localBucket)
+	}
+	return b.awsClient, b.awsBucketName(localBucket)
+}
+
+// isNotModified reports whether an upstream error is a 304 answer to a
+// conditional GET.
+func isNotModified(err error) bool {
+	if s3ErrorCode(err) == "NotModified" {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// objectHeader HEADs objects; *s3.Client implements it.
+type objectHeader interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// uploadVerifier checks objects s3lazy uploads, backups and team cache
+// write-backs, against what upstream stored, so corruption on the way is
+// caught instead of being found when the copy is needed.
+type uploadVerifier struct {
+	mu            sync.Mutex
+	verified      int64
+	failed        int64
+	lastFailure   string
+	lastFailureAt time.Time
+}
+
+func newUploadVerifier() *uploadVerifier {
+	return &uploadVerifier{}
+}
+
+// SetUploadVerifier verifies every object uploaded upstream after the upload.
+// A nil verifier disables it.
+func (b *LazyBackend) SetUploadVerifier(v *uploadVerifier) {
+	b.verifier = v
+}
+
+// sentObject is what was uploaded, for verification.
+type sentObject struct {
+	size int64
+	md5  []byte
+}
+
+// spoolForUpload spools r to a temp file, as signed uploads need a seekable
+// body, and returns the size and MD5 of what was spooled.
+func spoolForUpload(r io.Reader) (*spooledFile, sentObject, error) {
+	hash := md5.New()
+	spooled, size, err := spoolToTempFile(io.TeeReader(r, hash))
+	if err != nil {
+		return nil, sentObject{}, err
+	}
+	return spooled, sentObject{size: size, md5: hash.Sum(nil)}, nil
+}
+
+// verify HEADs bucket/key, just uploaded, and compares it with what was sent
+// and what the upload returned: its size, its ETag, the MD5 of the content
+// when the ETag is one, and any checksums upstream keeps. A nil verifier
+// accepts every upload.
+func (v *uploadVerifier) verify(ctx context.Context, client objectHeader, bucket, key string, sent sentObject, put *s3.PutObjectOutput, optFns ...func(*s3.Options)) error {
+	if v == nil {
+		return nil
+	}
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		ChecksumMode: types.ChecksumModeEnabled,
+	}, optFns...)
+	if err != nil {
+		return fmt.Errorf("verifying upload of %s/%s: %w", bucket, key, err)
+	}
+	mismatch := uploadMismatch(head, sent, put)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if mismatch == "" {
+		v.verified++
+		return nil
+	}
+	v.failed++
+	v.lastFailure = fmt.Sprintf("%s/%s: %s", bucket, key, mismatch)
+	v.lastFailureAt = time.Now()
+	log.Printf("[VERIFY FAILED] %s", v.lastFailure)
+	return fmt.Errorf("upload of %s/%s failed verification: %s", bucket, key, mismatch)
+}
+
+// uploadMismatch describes how an uploaded object differs from what was sent,
+// or returns "" if it doesn't.
+func uploadMismatch(head *s3.HeadObjectOutput, sent sentObject, put *s3.PutObjectOutput) string {
+	if size := aws.ToInt64(head.ContentLength); size != sent.size {
+		return fmt.Sprintf("upstream has %d bytes, sent %d", size, sent.size)
+	}
+	etag := aws.ToString(head.ETag)
+	if want := aws.ToString(put.ETag); want != "" && etag != want {
+		return fmt.Sprintf("upstream has ETag %s, the upload returned %s", etag, want)
+	}
+	if etagIsMD5(head) && !strings.EqualFold(strings.Trim(etag, `"`), hex.EncodeToString(sent.md5)) {
+		return fmt.Sprintf("upstream has ETag %s, sent content with MD5 %x", etag, sent.md5)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want *string
+	}{
+		{"CRC32", head.ChecksumCRC32, put.ChecksumCRC32},
+		{"CRC32C", head.ChecksumCRC32C, put.ChecksumCRC32C},
+		{"CRC64NVME", head.ChecksumCRC64NVME, put.ChecksumCRC64NVME},
+		{"SHA1", head.ChecksumSHA1, put.ChecksumSHA1},
+		{"SHA256", head.ChecksumSHA256, put.ChecksumSHA256},
+	} {
+		got, want := aws.ToString(c.got), aws.ToString(c.want)
+		if got != "" && want != "" && got != want {
+			return fmt.Sprintf("upstream has %s checksum %s, the upload returned %s", c.name, got, want)
+		}
+	}
+	return ""
+}
+
+// etagIsMD5 reports whether an object's ETag is the MD5 of its content: it
+// isn't for multipart uploads, or for objects encrypted with KMS or a
+// customer key.
+func etagIsMD5(head *s3.HeadObjectOutput) bool {
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	if len(etag) != 2*md5.Size || strings.Contains(etag, "-") {
+		return false
+	}
+	switch head.ServerSideEncryption {
+	case types.ServerSideEncryptionAwsKms, types.ServerSideEncryptionAwsKmsDsse:
+		return false
+	}
+	return head.SSECustomerAlgorithm == nil
+}
+
+// uploadVerificationStats is how uploads fared in verification, in
+// /admin/stats.
+type uploadVerificationStats struct {
+	Verified      int64     `json:"verified"`
+	Failed        int64     `json:"failed"`
+	LastFailure   string    `json:"last_failure,omitempty"`
+	LastFailureAt time.Time `json:"last_failure_at,omitzero"`
+}
+
+func (v *uploadVerifier) status() *uploadVerificationStats {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return &uploadVerificationStats{
+		Verified:      v.verified,
+		Failed:        v.failed,
+		LastFailure:   v.lastFailure,
+		LastFailureAt: v.lastFailureAt,
+	}
+}
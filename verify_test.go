@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// corruptingClient flips the first byte of every object it uploads, as a
+// faulty network path or proxy might.
+type corruptingClient struct {
+	*s3.Client
+}
+
+func (c corruptingClient) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		data[0] ^= 0xff
+	}
+	corrupted := *params
+	corrupted.Body = bytes.NewReader(data)
+	return c.Client.PutObject(ctx, &corrupted, optFns...)
+}
+
+func TestUploadMismatch(t *testing.T) {
+	sum := md5.Sum([]byte("hello"))
+	sent := sentObject{size: 5, md5: sum[:]}
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	other := `"0123456789abcdef0123456789abcdef"`
+
+	for _, tt := range []struct {
+		name string
+		head s3.HeadObjectOutput
+		put  s3.PutObjectOutput
+		want string
+	}{
+		{"match", s3.HeadObjectOutput{ContentLength: aws.Int64(5), ETag: aws.String(etag)}, s3.PutObjectOutput{ETag: aws.String(etag)}, ""},
+		{"size", s3.HeadObjectOutput{ContentLength: aws.Int64(4), ETag: aws.String(etag)}, s3.PutObjectOutput{}, "upstream has 4 bytes, sent 5"},
+		{"changed since the upload", s3.HeadObjectOutput{ContentLength: aws.Int64(5), ETag: aws.String(other)}, s3.PutObjectOutput{ETag: aws.String(etag)}, "the upload returned"},
+		{"content", s3.HeadObjectOutput{ContentLength: aws.Int64(5), ETag: aws.String(other)}, s3.PutObjectOutput{ETag: aws.String(other)}, "sent content with MD5"},
+		{"KMS ETag", s3.HeadObjectOutput{ContentLength: aws.Int64(5), ETag: aws.String(other), ServerSideEncryption: types.ServerSideEncryptionAwsKms}, s3.PutObjectOutput{}, ""},
+		{"multipart ETag", s3.HeadObjectOutput{ContentLength: aws.Int64(5), ETag: aws.String(`"0123456789abcdef0123456789abcdef-2"`)}, s3.PutObjectOutput{}, ""},
+		{"checksum", s3.HeadObjectOutput{ContentLength: aws.Int64(5), ETag: aws.String(etag), ChecksumCRC32: aws.String("AAAAAA==")}, s3.PutObjectOutput{ChecksumCRC32: aws.String("NhCmhg==")}, "CRC32 checksum"},
+	} {
+		got := uploadMismatch(&tt.head, sent, &tt.put)
+		if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: mismatch = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLazyBackend_VerifyBackups(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, bucket := range []string{"test-bucket", "scratch-backup"} {
+		if err := awsBackend.CreateBucket(bucket); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	putString(t, lazyBackend, "notes/todo.txt", "written locally")
+	lazyBackend.SetUploadVerifier(newUploadVerifier())
+
+	client := lazyBackend.awsClient.(*s3.Client)
+	corrupted := newLocalBackup(corruptingClient{client}, "scratch-backup", defaultBackupPrefix, "dev-laptop")
+	result, err := lazyBackend.BackUpLocalObjects(t.Context(), corrupted)
+	if err != nil {
+		t.Fatalf("BackUpLocalObjects: %v", err)
+	}
+	if result.Uploaded != 0 || result.Failed != 1 {
+		t.Errorf("result = %+v, want the corrupted backup failed", result)
+	}
+	stats := lazyBackend.Stats().UploadVerification
+	if stats == nil || stats.Failed != 1 || !strings.Contains(stats.LastFailure, "scratch-backup/s3lazy-backup/dev-laptop/test-bucket/notes/todo.txt") {
+		t.Fatalf("upload_verification = %+v, want the failure reported", stats)
+	}
+
+	// The next run uploads it again
+	backup := newLocalBackup(client, "scratch-backup", defaultBackupPrefix, "dev-laptop")
+	if result, _ := lazyBackend.BackUpLocalObjects(t.Context(), backup); result.Uploaded != 1 || result.Failed != 0 {
+		t.Errorf("result = %+v, want the backup uploaded", result)
+	}
+	if stats := lazyBackend.Stats().UploadVerification; stats.Verified != 1 {
+		t.Errorf("verified = %d, want 1", stats.Verified)
+	}
+}
+
+func TestLazyBackend_VerifyTeamWriteBacks(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, backend := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := backend.CreateBucket("test-bucket"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putString(t, awsBackend, "file.txt", "shared data")
+	teamBackend := s3mem.New()
+	if err := teamBackend.CreateBucket("team-mirror"); err != nil {
+		t.Fatal(err)
+	}
+	teamServer := httptest.NewServer(gofakes3.New(teamBackend).Server())
+	defer teamServer.Close()
+	team := newTeamCache(corruptingClient{newEndpointClient(t, teamServer.URL)}, "team-mirror", "s3lazy/", "")
+	lazyBackend.SetTeamCache(team)
+	lazyBackend.SetUploadVerifier(newUploadVerifier())
+
+	readObject(t, lazyBackend, "file.txt", nil)
+	team.wait()
+	if _, err := teamBackend.HeadObject("team-mirror", "s3lazy/test-bucket/file.txt"); err == nil {
+		t.Error("corrupted team cache copy left for other instances")
+	}
+	if stats := lazyBackend.Stats().UploadVerification; stats.Failed != 1 {
+		t.Errorf("failed = %d, want 1", stats.Failed)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// versionKey identifies one version of a cached object by the upstream ETag
+// it was fetched with, or the local hash of objects written by clients.
+type versionKey struct {
+	bucket, key, etag string
+}
+
+// openVersions counts the readers still streaming each version of a cached
+// object. On a local backend that keeps opened objects intact when they are
+// replaced, readers only hold the key's shared lock while opening an object:
+// a revalidation then switches the key to the new version without waiting
+// for them, and they finish reading the version they opened.
+type openVersions struct {
+	mu      sync.Mutex
+	readers map[versionKey]int
+}
+
+func newOpenVersions() *openVersions {
+	return &openVersions{readers: make(map[versionKey]int)}
+}
+
+// open records a reader of a version and returns the func that releases it.
+func (v *openVersions) open(k versionKey) func() {
+	v.mu.Lock()
+	v.readers[k]++
+	v.mu.Unlock()
+	return func() {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if v.readers[k]--; v.readers[k] <= 0 {
+			delete(v.readers, k)
+		}
+	}
+}
+
+// others returns the readers of a key's versions other than etag.
+func (v *openVersions) others(bucket, key, etag string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	n := 0
+	for k, readers := range v.readers {
+		if k.bucket == bucket && k.key == key && k.etag != etag {
+			n += readers
+		}
+	}
+	return n
+}
+
+// keepsOpenVersions reports whether replacing or deleting an object in a
+// local backend leaves readers that already opened it with the whole old
+// version. The memory backend replaces an object's data rather than writing
+// into it, and the disk backend renames new files into place and appends to
+// pack segments. Other backends, such as LocalStack, make no such promise.
+func keepsOpenVersions(backend gofakes3.Backend) bool {
+	switch b := backend.(type) {
+	case *s3mem.Backend, *diskListing:
+		return true
+	case *memorySnapshot:
+		return keepsOpenVersions(b.Backend)
+	case *MultiplexBackend:
+		for _, each := range b.backends() {
+			if !keepsOpenVersions(each) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// openVersion wraps the contents of an object opened under the key's shared
+// lock. Where the local backend keeps opened versions, the lock is released
+// at once and the reader is counted against the version it opened until
+// closed; elsewhere the lock is held until then.
+func (b *LazyBackend) openVersion(bucketName, objectName string, obj *gofakes3.Object, unlock func()) io.ReadCloser {
+	if !keepsOpenVersions(b.local) {
+		return &unlockOnClose{ReadCloser: obj.Contents, unlock: unlock}
+	}
+	etag := b.index.etag(bucketName, objectName)
+	if etag == "" {
+		etag = hex.EncodeToString(obj.Hash)
+	}
+	release := b.versions.open(versionKey{bucketName, objectName, etag})
+	unlock()
+	return &unlockOnClose{ReadCloser: obj.Contents, unlock: release}
+}
+
+// logSwitch notes a revalidation that replaced a cached object while readers
+// of its previous version were still streaming it.
+func (b *LazyBackend) logSwitch(bucketName, objectName string) {
+	etag := b.index.etag(bucketName, objectName)
+	if n := b.versions.others(bucketName, objectName, etag); n > 0 {
+		log.Printf("[VERSION] %s/%s: switched to %s with %d reader(s) of the previous version still open", bucketName, objectName, etag, n)
+	}
+}
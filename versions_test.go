@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3afero"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/spf13/afero"
+)
+
+func TestLazyBackend_RefreshKeepsOpenVersion(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	oldBody := strings.Repeat("v1", 32*1024)
+	putString(t, awsBackend, "data.bin", oldBody)
+	readObject(t, lazyBackend, "data.bin", nil)
+
+	// A client is part way through the cached version when upstream changes
+	obj, err := lazyBackend.GetObject("test-bucket", "data.bin", nil)
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	head := make([]byte, 1024)
+	if _, err := io.ReadFull(obj.Contents, head); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	putString(t, awsBackend, "data.bin", "v2")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := lazyBackend.refresh(t.Context(), "test-bucket", "data.bin", false)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh waited for the reader of the old version")
+	}
+
+	if _, got := readObject(t, lazyBackend, "data.bin", nil); got != "v2" {
+		t.Errorf("content after refresh = %q, want v2", got)
+	}
+	rest, err := io.ReadAll(obj.Contents)
+	obj.Contents.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := string(head) + string(rest); got != oldBody {
+		t.Errorf("old reader got %d bytes, want the whole %d-byte old version", len(got), len(oldBody))
+	}
+	if n := lazyBackend.versions.others("test-bucket", "data.bin", ""); n != 0 {
+		t.Errorf("%d reader(s) still counted after closing", n)
+	}
+}
+
+func TestKeepsOpenVersions(t *testing.T) {
+	files, err := s3afero.MultiBucket(afero.NewMemMapFs())
+	if err != nil {
+		t.Fatalf("Failed to create disk backend: %v", err)
+	}
+	mem := s3mem.New()
+	for _, tt := range []struct {
+		name    string
+		backend gofakes3.Backend
+		want    bool
+	}{
+		{"memory", mem, true},
+		{"snapshotted memory", newMemorySnapshot(mem, "unused"), true},
+		{"unwrapped disk", files, false},
+		{"multiplexed memory", NewMultiplexBackend(mem, map[string]gofakes3.Backend{"a": s3mem.New()}), true},
+		{"multiplexed with disk", NewMultiplexBackend(mem, map[string]gofakes3.Backend{"a": files}), false},
+	} {
+		if got := keepsOpenVersions(tt.backend); got != tt.want {
+			t.Errorf("%s: keepsOpenVersions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// warmConcurrency bounds how many objects are fetched in parallel while warming.
+const warmConcurrency = 4
+
+// warmEntry is a single object listed in a warm manifest.
+type warmEntry struct {
+	Bucket string
+	Key    string
+}
+
+// errWarmManifestSyntax marks warm manifest lines that aren't "bucket/key",
+// a config error, apart from failures to read the manifest.
+var errWarmManifestSyntax = errors.New("expected bucket/key")
+
+// loadWarmManifest reads a warm manifest: one "bucket/key" per line, with blank
+// lines and lines starting with "#" ignored.
+func loadWarmManifest(path string) ([]warmEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []warmEntry
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		bucket, key, ok := strings.Cut(line, "/")
+		if !ok || bucket == "" || key == "" {
+			return nil, fmt.Errorf("%s:%d: %w, got %q", path, lineNo, errWarmManifestSyntax, line)
+		}
+		entries = append(entries, warmEntry{Bucket: bucket, Key: key})
+	}
+	return entries, scanner.Err()
+}
+
+// Warm fetches every entry into the local cache, creating local buckets as
+// needed. Entries already cached are skipped. It returns how many entries are
+// now cached and how many failed.
+func (b *LazyBackend) Warm(entries []warmEntry) (warmed, failed int) {
+	for i := range entries {
+		entries[i].Bucket = b.canonicalBucket(entries[i].Bucket)
+	}
+	b.ensureBuckets(entries)
+
+	var ok, bad atomic.Int64
+	b.fillAll(context.Background(), entries, warmConcurrency, func(e warmEntry, err error) {
+		if err != nil {
+			log.Printf("[WARM] %s/%s: %v", e.Bucket, e.Key, err)
+			bad.Add(1)
+		} else {
+			ok.Add(1)
+		}
+	})
+	return int(ok.Load()), int(bad.Load())
+}
+
+// ensureBuckets creates the local buckets entries belong to if missing.
+func (b *LazyBackend) ensureBuckets(entries []warmEntry) {
+	buckets := make(map[string]bool)
+	for _, e := range entries {
+		if buckets[e.Bucket] {
+			continue
+		}
+		buckets[e.Bucket] = true
+		if exists, err := b.local.BucketExists(e.Bucket); err == nil && !exists {
+			if err := b.local.CreateBucket(e.Bucket); err != nil {
+				log.Printf("[WARM] couldn't create bucket %s: %v", e.Bucket, err)
+			}
+		}
+	}
+}
+
+// fillAll fetches entries into the local cache with a pool of concurrency
+// workers, reporting each result to done. Once ctx is cancelled it stops
+// handing out work, and fills in progress are abandoned.
+func (b *LazyBackend) fillAll(ctx context.Context, entries []warmEntry, concurrency int, done func(warmEntry, error)) {
+	work := make(chan warmEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range work {
+				done(e, b.fill(ctx, e.Bucket, e.Key))
+			}
+		}()
+	}
+	defer func() {
+		close(work)
+		wg.Wait()
+	}()
+	for _, e := range entries {
+		select {
+		case work <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadWarmManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warm.txt")
+	manifest := `# reference data
+fixtures/users.json
+
+fixtures/nested/path/orders.csv
+models/v1/weights.bin
+`
+	if err := os.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	entries, err := loadWarmManifest(path)
+	if err != nil {
+		t.Fatalf("loadWarmManifest failed: %v", err)
+	}
+
+	want := []warmEntry{
+		{"fixtures", "users.json"},
+		{"fixtures", "nested/path/orders.csv"},
+		{"models", "v1/weights.bin"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %v, want %v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entries[%d] = %v, want %v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestLoadWarmManifest_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warm.txt")
+	if err := os.WriteFile(path, []byte("bucket-without-key\n"), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	if _, err := loadWarmManifest(path); !errors.Is(err, errWarmManifestSyntax) {
+		t.Errorf("expected a syntax error for line without a key, got %v", err)
+	}
+	if _, err := loadWarmManifest(filepath.Join(t.TempDir(), "missing.txt")); err == nil || errors.Is(err, errWarmManifestSyntax) {
+		t.Errorf("expected a read error for a missing manifest, got %v", err)
+	}
+}
+
+func TestLazyBackend_Warm(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if err := awsBackend.CreateBucket("warm-bucket"); err != nil {
+		t.Fatalf("Failed to create AWS bucket: %v", err)
+	}
+	for _, key := range []string{"a.txt", "b/c.txt"} {
+		content := []byte("content of " + key)
+		if _, err := awsBackend.PutObject("warm-bucket", key, nil,
+			bytes.NewReader(content), int64(len(content)), nil); err != nil {
+			t.Fatalf("Failed to put object in AWS: %v", err)
+		}
+	}
+
+	// The local bucket doesn't exist yet; Warm creates it
+	warmed, failed := lazyBackend.Warm([]warmEntry{
+		{"warm-bucket", "a.txt"},
+		{"warm-bucket", "b/c.txt"},
+		{"warm-bucket", "missing.txt"},
+	})
+	if warmed != 2 || failed != 1 {
+		t.Errorf("Warm() = (%d, %d), want (2, 1)", warmed, failed)
+	}
+
+	for _, key := range []string{"a.txt", "b/c.txt"} {
+		obj, err := localBackend.GetObject("warm-bucket", key, nil)
+		if err != nil {
+			t.Errorf("%s should be cached after warming: %v", key, err)
+			continue
+		}
+		obj.Contents.Close()
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// diskCheckInterval is how often the disk backend's usage is measured
+// against the watermarks.
+const diskCheckInterval = 30 * time.Second
+
+// defaultLowWatermarkPercent sets the low watermark relative to the high
+// one when only the high watermark is configured.
+const defaultLowWatermarkPercent = 80
+
+// diskUsage returns the total size of the regular files under dir.
+func diskUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// TrimDisk measures the disk backend's usage under dir and, once it is above
+// high bytes, evicts objects cached from upstream in the eviction policy's
+// order until it is down to low. Objects written by clients and pinned objects are never
+// evicted, so usage can stay above low. It returns the bytes freed.
+func (b *LazyBackend) TrimDisk(dir string, high, low int64) (int64, error) {
+	used, err := diskUsage(dir)
+	if err != nil {
+		return 0, err
+	}
+	if used <= high {
+		return 0, nil
+	}
+	log.Printf("[DISK] %d bytes used, above high watermark %d: trimming to %d", used, high, low)
+	freed := b.evictEntries(b.index.trimCandidates(used-low, b.pins.pinned))
+	if used-freed > low {
+		log.Printf("[DISK] freed %d bytes, still above low watermark %d: the rest is local data or pinned", freed, low)
+	} else {
+		log.Printf("[DISK] freed %d bytes", freed)
+	}
+	return freed, nil
+}
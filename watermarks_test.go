@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLazyBackend_TrimDisk(t *testing.T) {
+	lazyBackend, localBackend, _, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+
+	if err := localBackend.CreateBucket("test-bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %v", err)
+	}
+	// Oldest first, so c.bin is the most recently used
+	for _, e := range []struct {
+		key  string
+		size int
+	}{{"a.bin", 400}, {"b.bin", 400}, {"c.bin", 100}} {
+		if _, err := localBackend.PutObject("test-bucket", e.key, nil, bytes.NewReader(make([]byte, e.size)), int64(e.size), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", e.key, err)
+		}
+		lazyBackend.index.add("test-bucket", e.key, int64(e.size), "")
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bucket", "data"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := diskUsage(dir); err != nil || used != 1000 {
+		t.Fatalf("diskUsage = %d, %v; want 1000", used, err)
+	}
+
+	if freed, err := lazyBackend.TrimDisk(dir, 2000, 1500); err != nil || freed != 0 {
+		t.Errorf("TrimDisk below the high watermark freed %d, %v; want nothing", freed, err)
+	}
+	freed, err := lazyBackend.TrimDisk(dir, 500, 300)
+	if err != nil {
+		t.Fatalf("TrimDisk: %v", err)
+	}
+	if freed != 800 {
+		t.Errorf("freed %d bytes, want 800", freed)
+	}
+	for key, want := range map[string]bool{"a.bin": false, "b.bin": false, "c.bin": true} {
+		if _, err := localBackend.HeadObject("test-bucket", key); (err == nil) != want {
+			t.Errorf("%s present = %v, want %v", key, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// webIdentitySessionName names the role sessions s3lazy starts, unless
+// AWS_ROLE_SESSION_NAME does.
+const webIdentitySessionName = "s3lazy"
+
+// webIdentityExpiryWindow is how long before they expire role credentials
+// are refreshed, so requests in flight are never signed with credentials
+// that expire on the way.
+const webIdentityExpiryWindow = 5 * time.Minute
+
+// webIdentityCredentials assumes roleARN with the OIDC token in tokenFile,
+// as with IAM roles for service accounts (IRSA) on EKS. The token file is
+// read again on every refresh, so tokens the kubelet rotates are picked up.
+func webIdentityCredentials(awsCfg aws.Config, roleARN, tokenFile string) aws.CredentialsProvider {
+	provider := stscreds.NewWebIdentityRoleProvider(sts.NewFromConfig(awsCfg), roleARN, identityTokenFile(tokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+		o.RoleSessionName = cmp.Or(os.Getenv("AWS_ROLE_SESSION_NAME"), webIdentitySessionName)
+	})
+	return aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = webIdentityExpiryWindow
+	})
+}
+
+// identityTokenFile reads a web identity token from a file. Unlike
+// stscreds.IdentityTokenFile, it ignores surrounding whitespace, such as
+// the trailing newline of a token file written by hand.
+type identityTokenFile string
+
+func (f identityTokenFile) GetIdentityToken() ([]byte, error) {
+	token, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(token), nil
+}
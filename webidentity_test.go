@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// fakeSTS answers AssumeRoleWithWebIdentity with credentials named after the
+// token it was sent, expiring after ttl, and records the tokens.
+type fakeSTS struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	tokens []string
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "AssumeRoleWithWebIdentity" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	token := r.Form.Get("WebIdentityToken")
+	f.mu.Lock()
+	f.tokens = append(f.tokens, token)
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIA-%s</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>session-%s</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>%s/%s</Arn>
+      <AssumedRoleId>AROA:%s</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, token, token, time.Now().Add(f.ttl).UTC().Format(time.RFC3339), r.Form.Get("RoleArn"), r.Form.Get("RoleSessionName"), r.Form.Get("RoleSessionName"))
+}
+
+func TestCreateUpstreamClient_WebIdentity(t *testing.T) {
+	// Credentials expire within the refresh window, so every request
+	// assumes the role again
+	sts := &fakeSTS{ttl: time.Minute}
+	stsServer := httptest.NewServer(sts)
+	defer stsServer.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", stsServer.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_ROLE_SESSION_NAME", "")
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("test-bucket"); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var signedBy []string
+	s3Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		signedBy = append(signedBy, r.Header.Get("X-Amz-Security-Token"))
+		mu.Unlock()
+		gofakes3.New(backend).Server().ServeHTTP(w, r)
+	}))
+	defer s3Server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.UpstreamEndpoint = s3Server.URL
+	cfg.AWSRoleARN = "arn:aws:iam::123456789012:role/s3lazy"
+	cfg.AWSWebIdentityTokenFile = tokenFile
+	client, err := createUpstreamClient(cfg, BucketUpstream{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := func() {
+		t.Helper()
+		if _, err := client.ListObjectsV2(t.Context(), &s3.ListObjectsV2Input{Bucket: aws.String("test-bucket")}); err != nil {
+			t.Fatalf("ListObjectsV2: %v", err)
+		}
+	}
+
+	list()
+	// The kubelet rotates the token in place
+	if err := os.WriteFile(tokenFile, []byte("second-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list()
+
+	sts.mu.Lock()
+	defer sts.mu.Unlock()
+	if len(sts.tokens) != 2 || sts.tokens[0] != "first-token" || sts.tokens[1] != "second-token" {
+		t.Errorf("STS got tokens %q, want the file re-read on refresh", sts.tokens)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(signedBy) != 2 || signedBy[0] != "session-first-token" || signedBy[1] != "session-second-token" {
+		t.Errorf("requests signed with sessions %q", signedBy)
+	}
+}
+
+func TestWebIdentityCredentials_Cached(t *testing.T) {
+	sts := &fakeSTS{ttl: time.Hour}
+	stsServer := httptest.NewServer(sts)
+	defer stsServer.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", stsServer.URL)
+	t.Setenv("AWS_ROLE_SESSION_NAME", "")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.UpstreamEndpoint = "http://127.0.0.1:1"
+	cfg.AWSRoleARN = "arn:aws:iam::123456789012:role/s3lazy"
+	cfg.AWSWebIdentityTokenFile = tokenFile
+	client, err := createUpstreamClient(cfg, BucketUpstream{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := client.(*endpointPool).endpoints[0].client.(*s3.Client).Options().Credentials
+	for range 3 {
+		got, err := creds.Retrieve(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.AccessKeyID != "ASIA-token" {
+			t.Errorf("credentials = %+v", got)
+		}
+	}
+	if len(sts.tokens) != 1 {
+		t.Errorf("STS called %d times, want credentials cached until they near expiry", len(sts.tokens))
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"maps"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// cacheStatusHeader tells clients how a GET was served, for debugging
+// staleness without the server logs.
+const cacheStatusHeader = "X-Cache"
+
+// The values of the X-Cache header.
+const (
+	cacheHit         = "HIT"         // served from the cache
+	cacheMiss        = "MISS"        // fetched from upstream
+	cacheRevalidated = "REVALIDATED" // served from the cache after upstream confirmed it's current
+	cacheStale       = "STALE"       // served from the cache because revalidating it failed
+)
+
+// withCacheStatus sets an object's X-Cache header, which gofakes3 sends
+// along with its metadata. The metadata is copied, since backends may hand
+// out the map they store.
+func withCacheStatus(obj *gofakes3.Object, status string) *gofakes3.Object {
+	meta := maps.Clone(obj.Metadata)
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	meta[cacheStatusHeader] = status
+	obj.Metadata = meta
+	return obj
+}
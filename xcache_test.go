@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestLazyBackend_CacheStatus(t *testing.T) {
+	lazyBackend, localBackend, awsBackend, awsServer := setupTestBackends(t)
+	defer awsServer.Close()
+	lazyBackend.awsClient = &conditionalUpstream{upstreamClient: lazyBackend.awsClient}
+	for _, b := range []gofakes3.Backend{localBackend, awsBackend} {
+		if err := b.CreateBucket("test-bucket"); err != nil {
+			t.Fatalf("Failed to create bucket: %v", err)
+		}
+	}
+	putString(t, awsBackend, "file.txt", "v1")
+
+	status := func() string {
+		t.Helper()
+		obj, _ := readObject(t, lazyBackend, "file.txt", nil)
+		return obj.Metadata[cacheStatusHeader]
+	}
+	if got := status(); got != cacheMiss {
+		t.Errorf("first read X-Cache = %q, want %s", got, cacheMiss)
+	}
+	if got := status(); got != cacheHit {
+		t.Errorf("second read X-Cache = %q, want %s", got, cacheHit)
+	}
+
+	lazyBackend.SetRevalidate(true)
+	if got := status(); got != cacheRevalidated {
+		t.Errorf("revalidated read X-Cache = %q, want %s", got, cacheRevalidated)
+	}
+	putString(t, awsBackend, "file.txt", "v2")
+	if got := status(); got != cacheMiss {
+		t.Errorf("read after upstream change X-Cache = %q, want %s", got, cacheMiss)
+	}
+
+	// The header is added per response, never stored with the object
+	if obj, err := localBackend.HeadObject("test-bucket", "file.txt"); err != nil || obj.Metadata[cacheStatusHeader] != "" {
+		t.Errorf("cached metadata = %v (err %v), want no %s", obj, err, cacheStatusHeader)
+	}
+}